@@ -10,6 +10,7 @@ const (
 	ServiceIntentions  string = "serviceintentions"
 	IngressGateway     string = "ingressgateway"
 	TerminatingGateway string = "terminatinggateway"
+	Registration       string = "registration"
 
 	Global                 string = "global"
 	DefaultConsulNamespace string = "default"
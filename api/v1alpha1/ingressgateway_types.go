@@ -26,9 +26,11 @@ func init() {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=consul
 
 // IngressGateway is the Schema for the ingressgateways API
 // +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced Time",type="date",JSONPath=".status.lastSyncedTime",description="The last time the resource was successfully synced with Consul"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
 type IngressGateway struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -158,15 +160,19 @@ func (in *IngressGateway) KubernetesName() string {
 }
 
 func (in *IngressGateway) SetSyncedCondition(status corev1.ConditionStatus, reason, message string) {
+	now := metav1.Now()
 	in.Status.Conditions = Conditions{
 		{
 			Type:               ConditionSynced,
 			Status:             status,
-			LastTransitionTime: metav1.Now(),
+			LastTransitionTime: now,
 			Reason:             reason,
 			Message:            message,
 		},
 	}
+	if status == corev1.ConditionTrue {
+		in.Status.LastSyncedTime = &now
+	}
 }
 
 func (in *IngressGateway) SyncedCondition() (status corev1.ConditionStatus, reason, message string) {
@@ -212,8 +218,18 @@ func (in *IngressGateway) Validate(namespacesEnabled bool) error {
 	var errs field.ErrorList
 	path := field.NewPath("spec")
 
+	// Index of the first listener of each port.
+	ports := make(map[int]int)
 	for i, v := range in.Spec.Listeners {
 		errs = append(errs, v.validate(path.Child("listeners").Index(i))...)
+
+		if j, ok := ports[v.Port]; ok {
+			errs = append(errs, field.Invalid(path.Child("listeners").Index(i).Child("port"),
+				v.Port,
+				fmt.Sprintf("port is already used by listeners[%d]", j)))
+		} else {
+			ports[v.Port] = i
+		}
 	}
 
 	errs = append(errs, in.validateNamespaces(namespacesEnabled)...)
@@ -287,7 +303,29 @@ func (in IngressListener) validate(path *field.Path) field.ErrorList {
 			fmt.Sprintf("if protocol is \"tcp\", only a single service is allowed, found %d", len(in.Services))))
 	}
 
+	// Index of the first service of each name and namespace, and of each host.
+	services := make(map[string]int)
+	hosts := make(map[string]int)
 	for i, svc := range in.Services {
+		key := svc.Namespace + "/" + svc.Name
+		if j, ok := services[key]; ok {
+			errs = append(errs, field.Invalid(path.Child("services").Index(i).Child("name"),
+				svc.Name,
+				fmt.Sprintf("service is already exposed by services[%d] of this listener", j)))
+		} else {
+			services[key] = i
+		}
+
+		for _, host := range svc.Hosts {
+			if j, ok := hosts[host]; ok {
+				errs = append(errs, field.Invalid(path.Child("services").Index(i).Child("hosts"),
+					host,
+					fmt.Sprintf("host is already used by services[%d] of this listener", j)))
+			} else {
+				hosts[host] = i
+			}
+		}
+
 		if svc.Name == wildcardServiceName && in.Protocol != "http" {
 			errs = append(errs, field.Invalid(path.Child("services").Index(i).Child("name"),
 				svc.Name,
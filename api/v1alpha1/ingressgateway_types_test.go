@@ -419,6 +419,112 @@ func TestIngressGateway_Validate(t *testing.T) {
 			},
 			namespacesEnabled: true,
 		},
+		"listener.port used twice": {
+			input: &IngressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo",
+				},
+				Spec: IngressGatewaySpec{
+					Listeners: []IngressListener{
+						{
+							Port:     8080,
+							Protocol: "tcp",
+						},
+						{
+							Port:     8081,
+							Protocol: "tcp",
+						},
+						{
+							Port:     8080,
+							Protocol: "http",
+						},
+					},
+				},
+			},
+			namespacesEnabled: false,
+			expectedErrMsgs: []string{
+				`spec.listeners[2].port: Invalid value: 8080: port is already used by listeners[0]`,
+			},
+		},
+		"service exposed twice by a listener": {
+			input: &IngressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo",
+				},
+				Spec: IngressGatewaySpec{
+					Listeners: []IngressListener{
+						{
+							Protocol: "http",
+							Services: []IngressService{
+								{
+									Name: "name",
+								},
+								{
+									Name: "name",
+								},
+							},
+						},
+					},
+				},
+			},
+			namespacesEnabled: false,
+			expectedErrMsgs: []string{
+				`spec.listeners[0].services[1].name: Invalid value: "name": service is already exposed by services[0] of this listener`,
+			},
+		},
+		"service exposed in different namespaces by a listener": {
+			input: &IngressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo",
+				},
+				Spec: IngressGatewaySpec{
+					Listeners: []IngressListener{
+						{
+							Protocol: "http",
+							Services: []IngressService{
+								{
+									Name:      "name",
+									Namespace: "foo",
+								},
+								{
+									Name:      "name",
+									Namespace: "bar",
+								},
+							},
+						},
+					},
+				},
+			},
+			namespacesEnabled: true,
+		},
+		"host used twice by a listener": {
+			input: &IngressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo",
+				},
+				Spec: IngressGatewaySpec{
+					Listeners: []IngressListener{
+						{
+							Protocol: "http",
+							Services: []IngressService{
+								{
+									Name:  "svc1",
+									Hosts: []string{"test.example.com"},
+								},
+								{
+									Name:  "svc2",
+									Hosts: []string{"other.example.com", "test.example.com"},
+								},
+							},
+						},
+					},
+				},
+			},
+			namespacesEnabled: false,
+			expectedErrMsgs: []string{
+				`spec.listeners[0].services[1].hosts: Invalid value: "test.example.com": host is already used by services[0] of this listener`,
+			},
+		},
 		"multiple errors": {
 			input: &IngressGateway{
 				ObjectMeta: metav1.ObjectMeta{
@@ -577,6 +683,7 @@ func TestIngressGateway_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", resource.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, resource.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, resource.Status.LastSyncedTime)
 }
 
 func TestIngressGateway_GetSyncedConditionStatus(t *testing.T) {
@@ -26,9 +26,11 @@ func init() {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=consul
 
 // ProxyDefaults is the Schema for the proxydefaults API
 // +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced Time",type="date",JSONPath=".status.lastSyncedTime",description="The last time the resource was successfully synced with Consul"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
 type ProxyDefaults struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -124,15 +126,19 @@ func (in *ProxyDefaults) KubernetesName() string {
 }
 
 func (in *ProxyDefaults) SetSyncedCondition(status corev1.ConditionStatus, reason string, message string) {
+	now := metav1.Now()
 	in.Status.Conditions = Conditions{
 		{
 			Type:               ConditionSynced,
 			Status:             status,
-			LastTransitionTime: metav1.Now(),
+			LastTransitionTime: now,
 			Reason:             reason,
 			Message:            message,
 		},
 	}
+	if status == corev1.ConditionTrue {
+		in.Status.LastSyncedTime = &now
+	}
 }
 
 func (in *ProxyDefaults) ToConsul(datacenter string) capi.ConfigEntry {
@@ -284,6 +284,7 @@ func TestProxyDefaults_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", resolver.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, resolver.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, resolver.Status.LastSyncedTime)
 }
 
 func TestProxyDefaults_GetSyncedConditionStatus(t *testing.T) {
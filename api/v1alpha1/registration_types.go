@@ -0,0 +1,338 @@
+package v1alpha1
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/common"
+	capi "github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	RegistrationKubeKind string = "registration"
+
+	// RegistrationKey is the meta key set on services registered from a
+	// Registration resource. Its value is "<k8s namespace>/<name>" and is used
+	// to check that a service in Consul is owned by the resource before
+	// modifying or deregistering it. Service meta keys can't contain dots or
+	// slashes so this doesn't follow the config entry meta keys.
+	RegistrationKey string = "k8s-registration"
+)
+
+func init() {
+	SchemeBuilder.Register(&Registration{}, &RegistrationList{})
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=consul
+
+// Registration is the Schema for the registrations API
+// +kubebuilder:printcolumn:name="Service",type="string",JSONPath=".spec.service.name",description="The name of the registered service"
+// +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced Time",type="date",JSONPath=".status.lastSyncedTime",description="The last time the resource was successfully synced with Consul"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
+type Registration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              RegistrationSpec   `json:"spec,omitempty"`
+	Status            RegistrationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RegistrationList contains a list of Registration
+type RegistrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Registration `json:"items"`
+}
+
+// RegistrationSpec defines the desired state of Registration
+type RegistrationSpec struct {
+	// Node is the name of the Consul node the service is registered on.
+	// This is typically the name of the VM or managed service hosting it.
+	Node string `json:"node,omitempty"`
+	// Address is the address of the node.
+	Address string `json:"address,omitempty"`
+	// NodeMeta is arbitrary metadata to set on the node.
+	NodeMeta map[string]string `json:"nodeMeta,omitempty"`
+	// Service is the service instance to register.
+	Service RegistrationService `json:"service,omitempty"`
+	// Checks are health checks for the service instance. Consul doesn't run
+	// checks for services registered this way: their status is set here,
+	// or updated by an external monitor such as consul-esm using the
+	// check's definition.
+	Checks []RegistrationCheck `json:"checks,omitempty"`
+}
+
+// RegistrationService is a service instance registered in the Consul catalog.
+type RegistrationService struct {
+	// Name is the name of the service.
+	Name string `json:"name,omitempty"`
+	// ID is the ID of the service instance. Defaults to the name.
+	ID string `json:"id,omitempty"`
+	// Address is the address of the service instance. Defaults to the
+	// node's address.
+	Address string `json:"address,omitempty"`
+	// Port is the port of the service instance.
+	Port int `json:"port,omitempty"`
+	// Tags are the tags of the service instance.
+	Tags []string `json:"tags,omitempty"`
+	// Meta is arbitrary metadata to set on the service instance.
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// RegistrationCheck is a health check for a registered service instance.
+type RegistrationCheck struct {
+	// CheckID is the ID of the check. Defaults to the name.
+	CheckID string `json:"checkID,omitempty"`
+	// Name is the name of the check.
+	Name string `json:"name,omitempty"`
+	// Status is the initial status of the check, one of "passing",
+	// "warning" or "critical". Defaults to "critical" if the check has an
+	// HTTP or TCP definition, since it hasn't been run yet, and to
+	// "passing" otherwise.
+	Status string `json:"status,omitempty"`
+	// Notes is a human-readable description of the check.
+	Notes string `json:"notes,omitempty"`
+	// Output is the output of the check.
+	Output string `json:"output,omitempty"`
+	// Definition describes how an external monitor should run the check.
+	Definition RegistrationCheckDefinition `json:"definition,omitempty"`
+}
+
+// RegistrationCheckDefinition describes how an external monitor should run
+// a check.
+type RegistrationCheckDefinition struct {
+	// HTTP is the URL to send a GET request to.
+	HTTP string `json:"http,omitempty"`
+	// TCP is the host:port to open a connection to.
+	TCP string `json:"tcp,omitempty"`
+	// Interval is how often to run the check, e.g. "10s".
+	Interval string `json:"interval,omitempty"`
+	// Timeout is how long to wait for the check to complete, e.g. "5s".
+	Timeout string `json:"timeout,omitempty"`
+	// TLSSkipVerify disables TLS certificate verification for HTTP checks.
+	TLSSkipVerify bool `json:"tlsSkipVerify,omitempty"`
+}
+
+// RegistrationStatus defines the observed state of Registration
+type RegistrationStatus struct {
+	Status `json:",inline"`
+	// Node is the node the service was last registered on.
+	Node string `json:"node,omitempty"`
+	// ServiceID is the ID the service was last registered with.
+	ServiceID string `json:"serviceID,omitempty"`
+	// ObservedGeneration is the generation of the resource that was last
+	// registered.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+func (in *Registration) KubeKind() string {
+	return RegistrationKubeKind
+}
+
+func (in *Registration) KubernetesName() string {
+	return in.ObjectMeta.Name
+}
+
+func (in *Registration) AddFinalizer(name string) {
+	in.ObjectMeta.Finalizers = append(in.Finalizers(), name)
+}
+
+func (in *Registration) RemoveFinalizer(name string) {
+	var newFinalizers []string
+	for _, oldF := range in.Finalizers() {
+		if oldF != name {
+			newFinalizers = append(newFinalizers, oldF)
+		}
+	}
+	in.ObjectMeta.Finalizers = newFinalizers
+}
+
+func (in *Registration) Finalizers() []string {
+	return in.ObjectMeta.Finalizers
+}
+
+func (in *Registration) SetSyncedCondition(status corev1.ConditionStatus, reason string, message string) {
+	now := metav1.Now()
+	in.Status.Conditions = Conditions{
+		{
+			Type:               ConditionSynced,
+			Status:             status,
+			LastTransitionTime: now,
+			Reason:             reason,
+			Message:            message,
+		},
+	}
+	if status == corev1.ConditionTrue {
+		in.Status.LastSyncedTime = &now
+	}
+}
+
+func (in *Registration) SyncedConditionStatus() corev1.ConditionStatus {
+	condition := in.Status.GetCondition(ConditionSynced)
+	if condition == nil {
+		return corev1.ConditionUnknown
+	}
+	return condition.Status
+}
+
+// ServiceID returns the ID of the service instance, defaulting to its name.
+func (in *Registration) ServiceID() string {
+	if in.Spec.Service.ID != "" {
+		return in.Spec.Service.ID
+	}
+	return in.Spec.Service.Name
+}
+
+// OwnerValue returns the value of the RegistrationKey meta key for services
+// registered from this resource.
+func (in *Registration) OwnerValue() string {
+	return in.Namespace + "/" + in.Name
+}
+
+// ToConsul converts the resource into a Consul catalog registration in
+// the given Consul namespace.
+func (in *Registration) ToConsul(consulNamespace string) *capi.CatalogRegistration {
+	nodeMeta := map[string]string{
+		// external-node tells consul-esm that this node isn't running a
+		// Consul agent so it should run the checks on it.
+		"external-node":  "true",
+		common.SourceKey: common.SourceValue,
+	}
+	for k, v := range in.Spec.NodeMeta {
+		nodeMeta[k] = v
+	}
+	serviceMeta := map[string]string{
+		common.SourceKey: common.SourceValue,
+		RegistrationKey:  in.OwnerValue(),
+	}
+	for k, v := range in.Spec.Service.Meta {
+		serviceMeta[k] = v
+	}
+
+	var checks capi.HealthChecks
+	for _, check := range in.Spec.Checks {
+		checks = append(checks, check.toConsul(in.ServiceID(), consulNamespace))
+	}
+
+	return &capi.CatalogRegistration{
+		Node:     in.Spec.Node,
+		Address:  in.Spec.Address,
+		NodeMeta: nodeMeta,
+		Service: &capi.AgentService{
+			ID:        in.ServiceID(),
+			Service:   in.Spec.Service.Name,
+			Address:   in.Spec.Service.Address,
+			Port:      in.Spec.Service.Port,
+			Tags:      in.Spec.Service.Tags,
+			Meta:      serviceMeta,
+			Namespace: consulNamespace,
+		},
+		Checks: checks,
+	}
+}
+
+// Validate validates the fields provided in the spec of the Registration and
+// returns an error which lists all invalid fields in the resource spec.
+func (in *Registration) Validate() error {
+	var allErrs field.ErrorList
+	path := field.NewPath("spec")
+
+	if in.Spec.Node == "" {
+		allErrs = append(allErrs, field.Required(path.Child("node"), "node must be set"))
+	}
+	if in.Spec.Address == "" {
+		allErrs = append(allErrs, field.Required(path.Child("address"), "address must be set"))
+	}
+	if in.Spec.Service.Name == "" {
+		allErrs = append(allErrs, field.Required(path.Child("service", "name"), "service name must be set"))
+	}
+	if in.Spec.Service.Port < 0 || in.Spec.Service.Port > 65535 {
+		allErrs = append(allErrs, field.Invalid(path.Child("service", "port"), in.Spec.Service.Port, "must be between 0 and 65535"))
+	}
+	for i, check := range in.Spec.Checks {
+		allErrs = append(allErrs, check.validate(path.Child("checks").Index(i))...)
+	}
+
+	if len(allErrs) > 0 {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: ConsulHashicorpGroup, Kind: RegistrationKubeKind},
+			in.KubernetesName(), allErrs)
+	}
+	return nil
+}
+
+func (c RegistrationCheck) toConsul(serviceID, consulNamespace string) *capi.HealthCheck {
+	checkID := c.CheckID
+	if checkID == "" {
+		checkID = c.Name
+	}
+	status := c.Status
+	if status == "" {
+		if c.Definition.HTTP != "" || c.Definition.TCP != "" {
+			status = capi.HealthCritical
+		} else {
+			status = capi.HealthPassing
+		}
+	}
+	return &capi.HealthCheck{
+		CheckID:    checkID,
+		Name:       c.Name,
+		Status:     status,
+		Notes:      c.Notes,
+		Output:     c.Output,
+		ServiceID:  serviceID,
+		Namespace:  consulNamespace,
+		Definition: c.Definition.toConsul(),
+	}
+}
+
+// toConsul returns the check definition. Durations are validated by
+// Validate so parse errors are ignored here.
+func (d RegistrationCheckDefinition) toConsul() capi.HealthCheckDefinition {
+	interval, _ := parseDuration(d.Interval)
+	timeout, _ := parseDuration(d.Timeout)
+	return capi.HealthCheckDefinition{
+		HTTP:             d.HTTP,
+		TCP:              d.TCP,
+		IntervalDuration: interval,
+		TimeoutDuration:  timeout,
+		TLSSkipVerify:    d.TLSSkipVerify,
+	}
+}
+
+func (c RegistrationCheck) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if c.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), "name must be set"))
+	}
+	statuses := []string{capi.HealthPassing, capi.HealthWarning, capi.HealthCritical, ""}
+	if !sliceContains(statuses, c.Status) {
+		errs = append(errs, field.Invalid(path.Child("status"), c.Status, notInSliceMessage(statuses)))
+	}
+	if c.Definition.HTTP != "" && c.Definition.TCP != "" {
+		errs = append(errs, field.Invalid(path.Child("definition"), c.Definition, "only one of http or tcp may be set"))
+	}
+	if _, err := parseDuration(c.Definition.Interval); err != nil {
+		errs = append(errs, field.Invalid(path.Child("definition", "interval"), c.Definition.Interval, err.Error()))
+	}
+	if _, err := parseDuration(c.Definition.Timeout); err != nil {
+		errs = append(errs, field.Invalid(path.Child("definition", "timeout"), c.Definition.Timeout, err.Error()))
+	}
+	return errs
+}
+
+// parseDuration parses s as a time.Duration, treating an empty string as 0.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,214 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRegistration_ToConsul(t *testing.T) {
+	cases := map[string]struct {
+		input    *Registration
+		expected *capi.CatalogRegistration
+	}{
+		"defaults": {
+			input: &Registration{
+				ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+				Spec: RegistrationSpec{
+					Node:    "external-db",
+					Address: "10.0.0.20",
+					Service: RegistrationService{Name: "db"},
+				},
+			},
+			expected: &capi.CatalogRegistration{
+				Node:    "external-db",
+				Address: "10.0.0.20",
+				NodeMeta: map[string]string{
+					"external-node":  "true",
+					common.SourceKey: common.SourceValue,
+				},
+				Service: &capi.AgentService{
+					ID:      "db",
+					Service: "db",
+					Meta: map[string]string{
+						common.SourceKey: common.SourceValue,
+						RegistrationKey:  "default/db",
+					},
+				},
+			},
+		},
+		"every field set": {
+			input: &Registration{
+				ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns"},
+				Spec: RegistrationSpec{
+					Node:     "external-db",
+					Address:  "10.0.0.20",
+					NodeMeta: map[string]string{"zone": "a"},
+					Service: RegistrationService{
+						Name:    "db",
+						ID:      "db-1",
+						Address: "10.0.0.21",
+						Port:    5432,
+						Tags:    []string{"primary"},
+						Meta:    map[string]string{"version": "12"},
+					},
+					Checks: []RegistrationCheck{
+						{
+							Name: "tcp",
+							Definition: RegistrationCheckDefinition{
+								TCP:      "10.0.0.21:5432",
+								Interval: "10s",
+								Timeout:  "1s",
+							},
+						},
+						{
+							CheckID: "maintenance",
+							Name:    "Maintenance",
+							Status:  capi.HealthWarning,
+							Notes:   "notes",
+							Output:  "output",
+						},
+					},
+				},
+			},
+			expected: &capi.CatalogRegistration{
+				Node:    "external-db",
+				Address: "10.0.0.20",
+				NodeMeta: map[string]string{
+					"external-node":  "true",
+					common.SourceKey: common.SourceValue,
+					"zone":           "a",
+				},
+				Service: &capi.AgentService{
+					ID:      "db-1",
+					Service: "db",
+					Address: "10.0.0.21",
+					Port:    5432,
+					Tags:    []string{"primary"},
+					Meta: map[string]string{
+						common.SourceKey: common.SourceValue,
+						RegistrationKey:  "ns/db",
+						"version":        "12",
+					},
+					Namespace: "consul-ns",
+				},
+				Checks: capi.HealthChecks{
+					{
+						CheckID:   "tcp",
+						Name:      "tcp",
+						Status:    capi.HealthCritical,
+						ServiceID: "db-1",
+						Namespace: "consul-ns",
+						Definition: capi.HealthCheckDefinition{
+							TCP:              "10.0.0.21:5432",
+							IntervalDuration: 10 * time.Second,
+							TimeoutDuration:  time.Second,
+						},
+					},
+					{
+						CheckID:   "maintenance",
+						Name:      "Maintenance",
+						Status:    capi.HealthWarning,
+						Notes:     "notes",
+						Output:    "output",
+						ServiceID: "db-1",
+						Namespace: "consul-ns",
+					},
+				},
+			},
+		},
+	}
+
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			consulNS := testCase.expected.Service.Namespace
+			require.Equal(t, testCase.expected, testCase.input.ToConsul(consulNS))
+		})
+	}
+}
+
+func TestRegistration_Validate(t *testing.T) {
+	valid := func() *Registration {
+		return &Registration{
+			ObjectMeta: metav1.ObjectMeta{Name: "db"},
+			Spec: RegistrationSpec{
+				Node:    "external-db",
+				Address: "10.0.0.20",
+				Service: RegistrationService{Name: "db", Port: 5432},
+				Checks: []RegistrationCheck{
+					{
+						Name:       "tcp",
+						Definition: RegistrationCheckDefinition{TCP: "10.0.0.20:5432", Interval: "10s"},
+					},
+				},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		modify         func(*Registration)
+		expectedErrMsg string
+	}{
+		"valid": {
+			modify: func(*Registration) {},
+		},
+		"required fields": {
+			modify: func(r *Registration) {
+				r.Spec = RegistrationSpec{}
+			},
+			expectedErrMsg: `registration.consul.hashicorp.com "db" is invalid: [spec.node: Required value: node must be set, spec.address: Required value: address must be set, spec.service.name: Required value: service name must be set]`,
+		},
+		"service.port": {
+			modify: func(r *Registration) {
+				r.Spec.Service.Port = 70000
+			},
+			expectedErrMsg: `registration.consul.hashicorp.com "db" is invalid: spec.service.port: Invalid value: 70000: must be between 0 and 65535`,
+		},
+		"checks[].status": {
+			modify: func(r *Registration) {
+				r.Spec.Checks[0].Status = "up"
+			},
+			expectedErrMsg: `registration.consul.hashicorp.com "db" is invalid: spec.checks[0].status: Invalid value: "up": must be one of "passing", "warning", "critical", ""`,
+		},
+		"checks[].definition.interval": {
+			modify: func(r *Registration) {
+				r.Spec.Checks[0].Name = ""
+				r.Spec.Checks[0].Definition.Interval = "often"
+			},
+			expectedErrMsg: `registration.consul.hashicorp.com "db" is invalid: [spec.checks[0].name: Required value: name must be set, spec.checks[0].definition.interval: Invalid value: "often": time: invalid duration "often"]`,
+		},
+	}
+
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			input := valid()
+			testCase.modify(input)
+			err := input.Validate()
+			if testCase.expectedErrMsg != "" {
+				require.EqualError(t, err, testCase.expectedErrMsg)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRegistration_SetSyncedCondition(t *testing.T) {
+	registration := &Registration{}
+	registration.SetSyncedCondition(corev1.ConditionTrue, "reason", "message")
+
+	require.Equal(t, corev1.ConditionTrue, registration.Status.Conditions[0].Status)
+	require.Equal(t, "reason", registration.Status.Conditions[0].Reason)
+	require.Equal(t, "message", registration.Status.Conditions[0].Message)
+	require.Equal(t, corev1.ConditionTrue, registration.SyncedConditionStatus())
+	require.NotNil(t, registration.Status.LastSyncedTime)
+}
+
+func TestRegistration_SyncedConditionStatusWhenStatusNil(t *testing.T) {
+	require.Equal(t, corev1.ConditionUnknown, (&Registration{}).SyncedConditionStatus())
+}
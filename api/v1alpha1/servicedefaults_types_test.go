@@ -347,6 +347,7 @@ func TestServiceDefaults_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", serviceDefaults.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, serviceDefaults.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, serviceDefaults.Status.LastSyncedTime)
 }
 
 func TestServiceDefaults_GetSyncedConditionStatus(t *testing.T) {
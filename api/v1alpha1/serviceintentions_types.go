@@ -2,6 +2,7 @@ package v1alpha1
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -24,9 +25,11 @@ func init() {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=consul
 
 // ServiceIntentions is the Schema for the serviceintentions API
 // +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced Time",type="date",JSONPath=".status.lastSyncedTime",description="The last time the resource was successfully synced with Consul"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
 type ServiceIntentions struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -177,15 +180,19 @@ func (in *ServiceIntentions) KubernetesName() string {
 }
 
 func (in *ServiceIntentions) SetSyncedCondition(status corev1.ConditionStatus, reason, message string) {
+	now := metav1.Now()
 	in.Status.Conditions = Conditions{
 		{
 			Type:               ConditionSynced,
 			Status:             status,
-			LastTransitionTime: metav1.Now(),
+			LastTransitionTime: now,
 			Reason:             reason,
 			Message:            message,
 		},
 	}
+	if status == corev1.ConditionTrue {
+		in.Status.LastSyncedTime = &now
+	}
 }
 
 func (in *ServiceIntentions) SyncedCondition() (status corev1.ConditionStatus, reason, message string) {
@@ -248,7 +255,17 @@ func (in *ServiceIntentions) Validate(namespacesEnabled bool) error {
 	if len(in.Spec.Sources) == 0 {
 		errs = append(errs, field.Required(path.Child("sources"), `at least one source must be specified`))
 	}
+	// Consul rejects intentions with more than one source for the same
+	// service so they are rejected here instead of failing to sync.
+	seenSources := make(map[string]int)
 	for i, source := range in.Spec.Sources {
+		key := source.Namespace + "/" + source.Name
+		if j, ok := seenSources[key]; ok {
+			errs = append(errs, field.Invalid(path.Child("sources").Index(i), source.Name,
+				fmt.Sprintf("the source is already specified in spec.sources[%d]", j)))
+		} else {
+			seenSources[key] = i
+		}
 		if len(source.Permissions) > 0 && source.Action != "" {
 			asJSON, _ := json.Marshal(source)
 			errs = append(errs, field.Invalid(path.Child("sources").Index(i), string(asJSON), `action and permissions are mutually exclusive and only one of them can be specified`))
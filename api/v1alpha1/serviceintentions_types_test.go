@@ -380,6 +380,7 @@ func TestServiceIntentions_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", serviceResolver.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, serviceResolver.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, serviceResolver.Status.LastSyncedTime)
 }
 
 func TestServiceIntentions_GetSyncedConditionStatus(t *testing.T) {
@@ -718,6 +719,69 @@ func TestServiceIntentions_Validate(t *testing.T) {
 				`serviceintentions.consul.hashicorp.com "does-not-matter" is invalid: spec.sources[0].action: Invalid value: "foo": must be one of "allow", "deny"`,
 			},
 		},
+		"duplicate sources": {
+			input: &ServiceIntentions{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "does-not-matter",
+				},
+				Spec: ServiceIntentionsSpec{
+					Destination: Destination{
+						Name: "dest-service",
+					},
+					Sources: SourceIntentions{
+						{
+							Name:   "web",
+							Action: "allow",
+						},
+						{
+							Name:   "*",
+							Action: "deny",
+						},
+						{
+							Name: "web",
+							Permissions: IntentionPermissions{
+								{
+									Action: "allow",
+									HTTP: &IntentionHTTPPermission{
+										PathExact: "/foo",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			namespacesEnabled: false,
+			expectedErrMsgs: []string{
+				`serviceintentions.consul.hashicorp.com "does-not-matter" is invalid: spec.sources[2]: Invalid value: "web": the source is already specified in spec.sources[0]`,
+			},
+		},
+		"same source name in different namespaces": {
+			input: &ServiceIntentions{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "does-not-matter",
+				},
+				Spec: ServiceIntentionsSpec{
+					Destination: Destination{
+						Name:      "dest-service",
+						Namespace: "namespace",
+					},
+					Sources: SourceIntentions{
+						{
+							Name:      "web",
+							Namespace: "ns1",
+							Action:    "allow",
+						},
+						{
+							Name:      "web",
+							Namespace: "ns2",
+							Action:    "deny",
+						},
+					},
+				},
+			},
+			namespacesEnabled: true,
+		},
 		"invalid permissions.http.pathPrefix": {
 			input: &ServiceIntentions{
 				ObjectMeta: metav1.ObjectMeta{
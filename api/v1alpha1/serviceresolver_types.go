@@ -22,9 +22,11 @@ func init() {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=consul
 
 // ServiceResolver is the Schema for the serviceresolvers API
 // +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced Time",type="date",JSONPath=".status.lastSyncedTime",description="The last time the resource was successfully synced with Consul"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
 type ServiceResolver struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -229,15 +231,19 @@ func (in *ServiceResolver) ConsulName() string {
 }
 
 func (in *ServiceResolver) SetSyncedCondition(status corev1.ConditionStatus, reason string, message string) {
+	now := metav1.Now()
 	in.Status.Conditions = Conditions{
 		{
 			Type:               ConditionSynced,
 			Status:             status,
-			LastTransitionTime: metav1.Now(),
+			LastTransitionTime: now,
 			Reason:             reason,
 			Message:            message,
 		},
 	}
+	if status == corev1.ConditionTrue {
+		in.Status.LastSyncedTime = &now
+	}
 }
 
 func (in *ServiceResolver) SyncedCondition() (status corev1.ConditionStatus, reason string, message string) {
@@ -369,6 +369,7 @@ func TestServiceResolver_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", serviceResolver.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, serviceResolver.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, serviceResolver.Status.LastSyncedTime)
 }
 
 func TestServiceResolver_GetSyncedConditionStatus(t *testing.T) {
@@ -315,6 +315,7 @@ func TestServiceRouter_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", ServiceRouter.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, ServiceRouter.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, ServiceRouter.Status.LastSyncedTime)
 }
 
 func TestServiceRouter_GetSyncedConditionStatus(t *testing.T) {
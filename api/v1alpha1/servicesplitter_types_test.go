@@ -181,6 +181,7 @@ func TestServiceSplitter_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", ServiceSplitter.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, ServiceSplitter.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, ServiceSplitter.Status.LastSyncedTime)
 }
 
 func TestServiceSplitter_GetSyncedConditionStatus(t *testing.T) {
@@ -74,6 +74,11 @@ type Status struct {
 	// +patchMergeKey=type
 	// +patchStrategy=merge
 	Conditions Conditions `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// LastSyncedTime is the last time the resource was successfully synced
+	// with Consul.
+	// +optional
+	LastSyncedTime *metav1.Time `json:"lastSyncedTime,omitempty" description:"last time the resource was successfully synced with Consul"`
 }
 
 func (s *Status) GetCondition(t ConditionType) *Condition {
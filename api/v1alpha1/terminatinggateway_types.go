@@ -2,6 +2,7 @@ package v1alpha1
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -24,9 +25,11 @@ func init() {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=consul
 
 // TerminatingGateway is the Schema for the terminatinggateways API
 // +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced Time",type="date",JSONPath=".status.lastSyncedTime",description="The last time the resource was successfully synced with Consul"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
 type TerminatingGateway struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -122,15 +125,19 @@ func (in *TerminatingGateway) KubernetesName() string {
 }
 
 func (in *TerminatingGateway) SetSyncedCondition(status corev1.ConditionStatus, reason, message string) {
+	now := metav1.Now()
 	in.Status.Conditions = Conditions{
 		{
 			Type:               ConditionSynced,
 			Status:             status,
-			LastTransitionTime: metav1.Now(),
+			LastTransitionTime: now,
 			Reason:             reason,
 			Message:            message,
 		},
 	}
+	if status == corev1.ConditionTrue {
+		in.Status.LastSyncedTime = &now
+	}
 }
 
 func (in *TerminatingGateway) SyncedCondition() (status corev1.ConditionStatus, reason, message string) {
@@ -175,8 +182,19 @@ func (in *TerminatingGateway) Validate(namespacesEnabled bool) error {
 	var errs field.ErrorList
 	path := field.NewPath("spec")
 
+	// Index of the first service of each name and namespace.
+	services := make(map[string]int)
 	for i, v := range in.Spec.Services {
 		errs = append(errs, v.validate(path.Child("services").Index(i))...)
+
+		key := v.Namespace + "/" + v.Name
+		if j, ok := services[key]; ok {
+			errs = append(errs, field.Invalid(path.Child("services").Index(i).Child("name"),
+				v.Name,
+				fmt.Sprintf("service is already linked by services[%d]", j)))
+		} else {
+			services[key] = i
+		}
 	}
 
 	errs = append(errs, in.validateNamespaces(namespacesEnabled)...)
@@ -246,6 +246,52 @@ func TestTerminatingGateway_Validate(t *testing.T) {
 				`spec.services[0].namespace: Invalid value: "ns": Consul Enterprise namespaces must be enabled to set service.namespace`,
 			},
 		},
+		"service linked twice": {
+			input: &TerminatingGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo",
+				},
+				Spec: TerminatingGatewaySpec{
+					Services: []LinkedService{
+						{
+							Name: "foo",
+						},
+						{
+							Name: "bar",
+						},
+						{
+							Name: "foo",
+							SNI:  "foo.example.com",
+						},
+					},
+				},
+			},
+			namespacesEnabled: false,
+			expectedErrMsgs: []string{
+				`spec.services[2].name: Invalid value: "foo": service is already linked by services[0]`,
+			},
+		},
+		"service linked in different namespaces": {
+			input: &TerminatingGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo",
+				},
+				Spec: TerminatingGatewaySpec{
+					Services: []LinkedService{
+						{
+							Name:      "foo",
+							Namespace: "ns1",
+						},
+						{
+							Name:      "foo",
+							Namespace: "ns2",
+						},
+					},
+				},
+			},
+			namespacesEnabled: true,
+			expectedErrMsgs:   []string{},
+		},
 		"service.namespace set when namespaces enabled": {
 			input: &TerminatingGateway{
 				ObjectMeta: metav1.ObjectMeta{
@@ -387,6 +433,7 @@ func TestTerminatingGateway_SetSyncedCondition(t *testing.T) {
 	require.Equal(t, "message", resource.Status.Conditions[0].Message)
 	now := metav1.Now()
 	require.True(t, resource.Status.Conditions[0].LastTransitionTime.Before(&now))
+	require.NotNil(t, resource.Status.LastSyncedTime)
 }
 
 func TestTerminatingGateway_GetSyncedConditionStatus(t *testing.T) {
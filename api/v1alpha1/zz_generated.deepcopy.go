@@ -547,6 +547,167 @@ func (in *RingHashConfig) DeepCopy() *RingHashConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Registration) DeepCopyInto(out *Registration) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Registration.
+func (in *Registration) DeepCopy() *Registration {
+	if in == nil {
+		return nil
+	}
+	out := new(Registration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Registration) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistrationCheck) DeepCopyInto(out *RegistrationCheck) {
+	*out = *in
+	out.Definition = in.Definition
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrationCheck.
+func (in *RegistrationCheck) DeepCopy() *RegistrationCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistrationCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistrationCheckDefinition) DeepCopyInto(out *RegistrationCheckDefinition) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrationCheckDefinition.
+func (in *RegistrationCheckDefinition) DeepCopy() *RegistrationCheckDefinition {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistrationCheckDefinition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistrationList) DeepCopyInto(out *RegistrationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Registration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrationList.
+func (in *RegistrationList) DeepCopy() *RegistrationList {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistrationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RegistrationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistrationService) DeepCopyInto(out *RegistrationService) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Meta != nil {
+		in, out := &in.Meta, &out.Meta
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrationService.
+func (in *RegistrationService) DeepCopy() *RegistrationService {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistrationService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistrationSpec) DeepCopyInto(out *RegistrationSpec) {
+	*out = *in
+	if in.NodeMeta != nil {
+		in, out := &in.NodeMeta, &out.NodeMeta
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Service.DeepCopyInto(&out.Service)
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]RegistrationCheck, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrationSpec.
+func (in *RegistrationSpec) DeepCopy() *RegistrationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistrationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistrationStatus) DeepCopyInto(out *RegistrationStatus) {
+	*out = *in
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrationStatus.
+func (in *RegistrationStatus) DeepCopy() *RegistrationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistrationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceDefaults) DeepCopyInto(out *ServiceDefaults) {
 	*out = *in
@@ -1279,6 +1440,10 @@ func (in *Status) DeepCopyInto(out *Status) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LastSyncedTime != nil {
+		in, out := &in.LastSyncedTime, &out.LastSyncedTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Status.
@@ -0,0 +1,133 @@
+// Package dryrun records the changes that the catalog sync would make when
+// it runs in dry-run mode, without making them.
+package dryrun
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The operations of the planned changes.
+const (
+	OperationRegister   = "register"
+	OperationDeregister = "deregister"
+	OperationCreate     = "create"
+	OperationUpdate     = "update"
+	OperationDelete     = "delete"
+)
+
+// ConsulChange is a registration or deregistration of a Consul service
+// instance.
+type ConsulChange struct {
+	Operation   string `json:"operation"`
+	Node        string `json:"node"`
+	ServiceID   string `json:"serviceID"`
+	ServiceName string `json:"serviceName,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+}
+
+// K8SChange is a creation, update or deletion of a Kubernetes service.
+type K8SChange struct {
+	Operation    string `json:"operation"`
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	ExternalName string `json:"externalName,omitempty"`
+}
+
+// Plan holds the changes planned by the last reconciliation pass of each
+// direction of the sync. Each pass replaces the changes of the previous one
+// since nothing is written, so they'd be planned again.
+type Plan struct {
+	lock     sync.Mutex
+	toConsul *pass
+	toK8S    *pass
+}
+
+// pass is a reconciliation pass of one direction.
+type pass struct {
+	Time          time.Time      `json:"time"`
+	ConsulChanges []ConsulChange `json:"consulChanges,omitempty"`
+	K8SChanges    []K8SChange    `json:"k8sChanges,omitempty"`
+}
+
+// SetConsulChanges records the changes planned by a pass of the Kubernetes
+// to Consul sync.
+func (p *Plan) SetConsulChanges(changes []ConsulChange) {
+	sorted := append([]ConsulChange{}, changes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Operation != b.Operation {
+			return a.Operation < b.Operation
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.ServiceID < b.ServiceID
+	})
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.toConsul = &pass{Time: time.Now(), ConsulChanges: sorted}
+}
+
+// SetK8SChanges records the changes planned by a pass of the Consul to
+// Kubernetes sync.
+func (p *Plan) SetK8SChanges(changes []K8SChange) {
+	sorted := append([]K8SChange{}, changes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Operation != b.Operation {
+			return a.Operation < b.Operation
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.toK8S = &pass{Time: time.Now(), K8SChanges: sorted}
+}
+
+// ConsulChanges returns the changes planned by the last pass of the
+// Kubernetes to Consul sync, and false if there hasn't been one yet.
+func (p *Plan) ConsulChanges() ([]ConsulChange, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.toConsul == nil {
+		return nil, false
+	}
+	return p.toConsul.ConsulChanges, true
+}
+
+// K8SChanges returns the changes planned by the last pass of the Consul to
+// Kubernetes sync, and false if there hasn't been one yet.
+func (p *Plan) K8SChanges() ([]K8SChange, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.toK8S == nil {
+		return nil, false
+	}
+	return p.toK8S.K8SChanges, true
+}
+
+// ServeHTTP responds with the plan as JSON. The direction of the sync that
+// hasn't had a pass yet, or isn't enabled, is null.
+func (p *Plan) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	body, err := json.MarshalIndent(struct {
+		ToConsul *pass `json:"toConsul"`
+		ToK8S    *pass `json:"toK8S"`
+	}{p.toConsul, p.toK8S}, "", "  ")
+	p.lock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,66 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+	plan := &Plan{}
+	serve := func() map[string]json.RawMessage {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		plan.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dry-run", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		var body map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	// Nothing is planned until the first passes.
+	_, ok := plan.ConsulChanges()
+	require.False(t, ok)
+	body := serve()
+	require.JSONEq(t, "null", string(body["toConsul"]))
+	require.JSONEq(t, "null", string(body["toK8S"]))
+
+	// The changes are sorted by operation, namespace and name.
+	plan.SetConsulChanges([]ConsulChange{
+		{Operation: OperationRegister, Node: "k8s-sync", ServiceID: "b", ServiceName: "b"},
+		{Operation: OperationDeregister, Node: "k8s-sync", ServiceID: "c"},
+		{Operation: OperationRegister, Node: "k8s-sync", ServiceID: "a", ServiceName: "a"},
+	})
+	changes, ok := plan.ConsulChanges()
+	require.True(t, ok)
+	require.Equal(t, []ConsulChange{
+		{Operation: OperationDeregister, Node: "k8s-sync", ServiceID: "c"},
+		{Operation: OperationRegister, Node: "k8s-sync", ServiceID: "a", ServiceName: "a"},
+		{Operation: OperationRegister, Node: "k8s-sync", ServiceID: "b", ServiceName: "b"},
+	}, changes)
+
+	plan.SetK8SChanges([]K8SChange{
+		{Operation: OperationCreate, Namespace: "team-b", Name: "web", ExternalName: "web.service.consul"},
+		{Operation: OperationCreate, Namespace: "team-a", Name: "web", ExternalName: "web.service.consul"},
+	})
+	body = serve()
+	var k8sPass struct {
+		K8SChanges []K8SChange
+	}
+	require.NoError(t, json.Unmarshal(body["toK8S"], &k8sPass))
+	require.Equal(t, []K8SChange{
+		{Operation: OperationCreate, Namespace: "team-a", Name: "web", ExternalName: "web.service.consul"},
+		{Operation: OperationCreate, Namespace: "team-b", Name: "web", ExternalName: "web.service.consul"},
+	}, k8sPass.K8SChanges)
+
+	// A pass replaces the changes of the previous one.
+	plan.SetConsulChanges(nil)
+	changes, ok = plan.ConsulChanges()
+	require.True(t, ok)
+	require.Empty(t, changes)
+}
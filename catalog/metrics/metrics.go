@@ -0,0 +1,135 @@
+// Package metrics has the Prometheus metrics reported by the catalog sync.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DirectionToConsul is the direction label value of the metrics
+	// reported by the Kubernetes to Consul sync.
+	DirectionToConsul = "to-consul"
+
+	// DirectionToK8S is the direction label value of the metrics reported
+	// by the Consul to Kubernetes sync.
+	DirectionToK8S = "to-k8s"
+)
+
+// The operation label values of the Consul API errors metric.
+const (
+	OperationRegister     = "register"
+	OperationDeregister   = "deregister"
+	OperationListServices = "list-services"
+	OperationNamespace    = "namespace"
+)
+
+// SyncMetrics are the metrics reported by one direction of the catalog sync.
+// The methods are no-ops on a nil *SyncMetrics so syncers don't need to
+// check whether metrics are enabled.
+type SyncMetrics struct {
+	// SyncErrors counts the services that failed to sync, by namespace.
+	SyncErrors *prometheus.CounterVec
+	// Services is the number of services kept in sync by the last sync, by
+	// namespace.
+	Services *prometheus.GaugeVec
+	// SyncDuration is the duration of the syncs.
+	SyncDuration prometheus.Histogram
+	// ConsulAPIErrors counts the failed Consul API requests, by operation.
+	ConsulAPIErrors *prometheus.CounterVec
+
+	now func() time.Time
+
+	lock        sync.Mutex
+	lastSuccess time.Time
+}
+
+// NewSyncMetrics creates the metrics for direction, either DirectionToConsul
+// or DirectionToK8S, and registers them with reg.
+func NewSyncMetrics(reg prometheus.Registerer, direction string) (*SyncMetrics, error) {
+	m := &SyncMetrics{
+		SyncErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "consul_k8s_sync_catalog_sync_errors_total",
+			Help:        "Number of services that failed to be registered, updated or deregistered, by namespace.",
+			ConstLabels: prometheus.Labels{"direction": direction},
+		}, []string{"namespace"}),
+		Services: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "consul_k8s_sync_catalog_services",
+			Help:        "Number of services kept in sync by the last sync, by namespace.",
+			ConstLabels: prometheus.Labels{"direction": direction},
+		}, []string{"namespace"}),
+		SyncDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "consul_k8s_sync_catalog_sync_duration_seconds",
+			Help:        "Duration of the syncs in seconds.",
+			ConstLabels: prometheus.Labels{"direction": direction},
+			Buckets:     []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60},
+		}),
+		ConsulAPIErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "consul_k8s_sync_catalog_consul_api_errors_total",
+			Help:        "Number of failed Consul API requests, by operation.",
+			ConstLabels: prometheus.Labels{"direction": direction},
+		}, []string{"operation"}),
+		now: time.Now,
+	}
+	// Until the first sync succeeds, the time is counted from startup so
+	// that a sync that never succeeds can be alerted on too.
+	m.lastSuccess = m.now()
+	sinceLastSync := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "consul_k8s_sync_catalog_seconds_since_last_sync",
+		Help: "Seconds since the last sync that completed without errors, or since startup " +
+			"if none has. Syncs run periodically even if nothing changed.",
+		ConstLabels: prometheus.Labels{"direction": direction},
+	}, m.secondsSinceLastSync)
+	for _, c := range []prometheus.Collector{m.SyncErrors, m.Services, m.SyncDuration, m.ConsulAPIErrors, sinceLastSync} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// RecordSync updates the metrics after a sync that started at start.
+// services and failures map namespaces to the number of services that were
+// synced and that failed to sync in them. The sync is successful if none
+// failed.
+func (m *SyncMetrics) RecordSync(start time.Time, services, failures map[string]int) {
+	if m == nil {
+		return
+	}
+	m.SyncDuration.Observe(m.now().Sub(start).Seconds())
+	// Namespaces that no longer have services are dropped.
+	m.Services.Reset()
+	for ns, count := range services {
+		m.Services.WithLabelValues(ns).Set(float64(count))
+	}
+	failed := false
+	for ns, count := range failures {
+		if count > 0 {
+			m.SyncErrors.WithLabelValues(ns).Add(float64(count))
+			failed = true
+		}
+	}
+	if failed {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lastSuccess = m.now()
+}
+
+// ConsulAPIError counts a failed Consul API request for operation, one of
+// the Operation* constants.
+func (m *SyncMetrics) ConsulAPIError(operation string) {
+	if m == nil {
+		return
+	}
+	m.ConsulAPIErrors.WithLabelValues(operation).Inc()
+}
+
+func (m *SyncMetrics) secondsSinceLastSync() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.now().Sub(m.lastSuccess).Seconds()
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncMetrics(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	reg := prometheus.NewRegistry()
+	m, err := NewSyncMetrics(reg, DirectionToConsul)
+	require.NoError(err)
+	now := time.Unix(1000, 0)
+	m.now = func() time.Time { return now }
+	m.lastSuccess = now
+
+	// Failed syncs don't reset the time since the last sync.
+	now = now.Add(10 * time.Second)
+	m.RecordSync(now.Add(-2*time.Second), map[string]int{"default": 3, "ns1": 1}, map[string]int{"default": 2, "ns1": 0})
+	require.Equal(10.0, secondsSinceLastSync(t, reg))
+	require.Equal(2.0, testutil.ToFloat64(m.SyncErrors.WithLabelValues("default")))
+	require.Equal(0.0, testutil.ToFloat64(m.SyncErrors.WithLabelValues("ns1")))
+	require.Equal(3.0, testutil.ToFloat64(m.Services.WithLabelValues("default")))
+	require.Equal(1.0, testutil.ToFloat64(m.Services.WithLabelValues("ns1")))
+
+	// Namespaces without services are dropped from the services gauge.
+	now = now.Add(5 * time.Second)
+	m.RecordSync(now.Add(-time.Second), map[string]int{"default": 5}, map[string]int{"default": 0})
+	require.Equal(0.0, secondsSinceLastSync(t, reg))
+	require.Equal(5.0, testutil.ToFloat64(m.Services.WithLabelValues("default")))
+	require.Equal(1, testutil.CollectAndCount(m.Services))
+
+	count, sum := syncDuration(t, reg)
+	require.Equal(uint64(2), count)
+	require.Equal(3.0, sum)
+
+	now = now.Add(3 * time.Second)
+	require.Equal(3.0, secondsSinceLastSync(t, reg))
+}
+
+// Test that both directions can be registered with the same registry.
+func TestNewSyncMetrics_bothDirections(t *testing.T) {
+	t.Parallel()
+	reg := prometheus.NewRegistry()
+	_, err := NewSyncMetrics(reg, DirectionToConsul)
+	require.NoError(t, err)
+	_, err = NewSyncMetrics(reg, DirectionToK8S)
+	require.NoError(t, err)
+	_, err = NewSyncMetrics(reg, DirectionToK8S)
+	require.Error(t, err)
+}
+
+func TestSyncMetrics_ConsulAPIError(t *testing.T) {
+	t.Parallel()
+	m, err := NewSyncMetrics(prometheus.NewRegistry(), DirectionToK8S)
+	require.NoError(t, err)
+	m.ConsulAPIError(OperationListServices)
+	m.ConsulAPIError(OperationListServices)
+	m.ConsulAPIError(OperationRegister)
+	require.Equal(t, 2.0, testutil.ToFloat64(m.ConsulAPIErrors.WithLabelValues(OperationListServices)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.ConsulAPIErrors.WithLabelValues(OperationRegister)))
+}
+
+func TestSyncMetrics_nil(t *testing.T) {
+	t.Parallel()
+	var m *SyncMetrics
+	m.RecordSync(time.Now(), map[string]int{"default": 1}, map[string]int{"default": 1})
+	m.ConsulAPIError(OperationRegister)
+}
+
+func secondsSinceLastSync(t *testing.T, reg *prometheus.Registry) float64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() == "consul_k8s_sync_catalog_seconds_since_last_sync" {
+			require.Len(t, f.GetMetric(), 1)
+			return f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatal("seconds since last sync metric not found")
+	return 0
+}
+
+func syncDuration(t *testing.T, reg *prometheus.Registry) (uint64, float64) {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() == "consul_k8s_sync_catalog_sync_duration_seconds" {
+			require.Len(t, f.GetMetric(), 1)
+			h := f.GetMetric()[0].GetHistogram()
+			return h.GetSampleCount(), h.GetSampleSum()
+		}
+	}
+	t.Fatal("sync duration metric not found")
+	return 0, 0
+}
@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ingressServiceSuffix is appended to the name of ingresses to get the
+	// name of their Consul service so that they don't add instances to the
+	// service synced from the Kubernetes service of the same name, which
+	// ingresses often share.
+	ingressServiceSuffix = "-ingress"
+
+	// ConsulK8SIngressTag is the tag set on the instances of ingresses, in
+	// addition to the ConsulK8STag.
+	ConsulK8SIngressTag = "k8s-ingress"
+)
+
+// ingressResource implements controller.Resource and is started by the
+// ServiceResource when IngressSync is set to register ingresses alongside
+// its services.
+type ingressResource struct {
+	Service *ServiceResource
+}
+
+func (t *ingressResource) Informer() cache.SharedIndexInformer {
+	// Watch all k8s namespaces. Events are filtered out as appropriate in
+	// the `shouldSyncObject` function, like those of services.
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return t.Service.Client.NetworkingV1beta1().
+					Ingresses(metav1.NamespaceAll).
+					List(context.TODO(), options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return t.Service.Client.NetworkingV1beta1().
+					Ingresses(metav1.NamespaceAll).
+					Watch(context.TODO(), options)
+			},
+		},
+		&networkingv1beta1.Ingress{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+func (t *ingressResource) Upsert(key string, raw interface{}) error {
+	ingress, ok := raw.(*networkingv1beta1.Ingress)
+	if !ok {
+		t.Service.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+
+	svc := t.Service
+	svc.serviceLock.Lock()
+	defer svc.serviceLock.Unlock()
+
+	if !svc.shouldSyncObject(&ingress.ObjectMeta) {
+		if _, ok := svc.ingressMap[key]; ok {
+			svc.Log.Info("ingress should no longer be synced", "ingress", key)
+			delete(svc.ingressMap, key)
+			svc.sync()
+		}
+		return nil
+	}
+
+	if svc.ingressMap == nil {
+		svc.ingressMap = make(map[string][]*consulapi.CatalogRegistration)
+	}
+	svc.ingressMap[key] = svc.ingressRegistrations(ingress)
+	svc.sync()
+	svc.Log.Info("upsert ingress", "key", key)
+	return nil
+}
+
+func (t *ingressResource) Delete(key string, _ interface{}) error {
+	svc := t.Service
+	svc.serviceLock.Lock()
+	defer svc.serviceLock.Unlock()
+
+	if _, ok := svc.ingressMap[key]; ok {
+		delete(svc.ingressMap, key)
+		svc.sync()
+	}
+	svc.Log.Info("delete ingress", "key", key)
+	return nil
+}
+
+// ingressRegistrations returns the registrations of the ingress, one per
+// address of its load balancer. The port is 443 if the ingress terminates
+// TLS and 80 otherwise, unless it's annotated with the service port. The
+// name, tags and meta annotations of services apply too.
+//
+// Precondition: assumes t.serviceLock is held
+func (t *ServiceResource) ingressRegistrations(ingress *networkingv1beta1.Ingress) []*consulapi.CatalogRegistration {
+	baseNode := consulapi.CatalogRegistration{
+		SkipNodeUpdate: true,
+		Node:           t.ConsulNodeName,
+		Address:        "127.0.0.1",
+		NodeMeta: map[string]string{
+			ConsulSourceKey: ConsulSourceValue,
+		},
+	}
+
+	baseService := consulapi.AgentService{
+		Service: t.addPrefixAndK8SNamespace(ingress.Name+ingressServiceSuffix, ingress.Namespace),
+		Tags:    []string{t.ConsulK8STag, ConsulK8SIngressTag},
+		Port:    80,
+		Meta: map[string]string{
+			ConsulSourceKey:   ConsulSourceValue,
+			ConsulK8SNS:       ingress.Namespace,
+			ConsulK8SRefKind:  "Ingress",
+			ConsulK8SRefValue: ingress.Name,
+		},
+		Namespace: t.consulNamespace(ingress.Namespace),
+	}
+	if v, ok := ingress.Annotations[annotationServiceName]; ok {
+		baseService.Service = strings.TrimSpace(v)
+	}
+	if len(ingress.Spec.TLS) > 0 {
+		baseService.Port = 443
+	}
+	if v, ok := ingress.Annotations[annotationServicePort]; ok {
+		if port, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			baseService.Port = port
+		} else {
+			t.Log.Warn("ingress port annotation must be a number", "ingress", ingress.Name,
+				"namespace", ingress.Namespace, "value", v)
+		}
+	}
+	if tags, ok := ingress.Annotations[annotationServiceTags]; ok {
+		for _, tag := range strings.Split(tags, ",") {
+			baseService.Tags = append(baseService.Tags, strings.TrimSpace(tag))
+		}
+	}
+	propagated, errs := t.MetaPropagator.Meta(ingress.Labels, ingress.Annotations)
+	for _, err := range errs {
+		t.Log.Warn("not copying to service meta", "ingress", ingress.Name, "namespace", ingress.Namespace, "err", err)
+	}
+	for k, v := range propagated {
+		if _, ok := baseService.Meta[k]; !ok {
+			baseService.Meta[k] = v
+		}
+	}
+	for k, v := range ingress.Annotations {
+		if strings.HasPrefix(k, annotationServiceMetaPrefix) {
+			baseService.Meta[strings.TrimPrefix(k, annotationServiceMetaPrefix)] = v
+		}
+	}
+
+	var registrations []*consulapi.CatalogRegistration
+	seen := map[string]struct{}{}
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		addr := lb.IP
+		if addr == "" {
+			addr = lb.Hostname
+		}
+		if addr == "" {
+			continue
+		}
+		if _, ok := seen[addr]; ok {
+			continue
+		}
+		seen[addr] = struct{}{}
+
+		r := baseNode
+		rs := baseService
+		r.Service = &rs
+		r.Service.ID = serviceID(r.Service.Service, addr)
+		r.Service.Address = addr
+		registrations = append(registrations, &r)
+	}
+	t.Log.Debug("generated ingress registration",
+		"ingress", ingress.Name,
+		"namespace", ingress.Namespace,
+		"service", baseService.Service,
+		"instances", len(registrations))
+	return registrations
+}
@@ -0,0 +1,153 @@
+package catalog
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that ingresses are registered with an instance per load balancer
+// address and deregistered when they're deleted.
+func TestServiceResource_ingress(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ConsulK8STag = TestConsulK8STag
+	serviceResource.IngressSync = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	ingress := lbIngress("web", metav1.NamespaceDefault, "1.2.3.4", "web.example.com")
+	ingress.Annotations[annotationServiceTags] = "public"
+	_, err := client.NetworkingV1beta1().Ingresses(metav1.NamespaceDefault).Create(context.Background(), ingress, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		for i, addr := range []string{"1.2.3.4", "web.example.com"} {
+			require.Equal(r, "web-ingress", actual[i].Service.Service)
+			require.Equal(r, addr, actual[i].Service.Address)
+			require.Equal(r, 80, actual[i].Service.Port)
+			require.Equal(r, []string{TestConsulK8STag, ConsulK8SIngressTag, "public"}, actual[i].Service.Tags)
+			require.Equal(r, "Ingress", actual[i].Service.Meta[ConsulK8SRefKind])
+			require.Equal(r, "web", actual[i].Service.Meta[ConsulK8SRefValue])
+		}
+	})
+
+	err = client.NetworkingV1beta1().Ingresses(metav1.NamespaceDefault).Delete(context.Background(), "web", metav1.DeleteOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 0)
+	})
+}
+
+// Test that ingresses and services with the same name are registered as
+// separate Consul services.
+func TestServiceResource_ingressAndService(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.IngressSync = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), lbService("web", metav1.NamespaceDefault, "5.6.7.8"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.NetworkingV1beta1().Ingresses(metav1.NamespaceDefault).Create(context.Background(), lbIngress("web", metav1.NamespaceDefault, "1.2.3.4"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		names := map[string]string{}
+		for _, reg := range syncer.Registrations {
+			names[reg.Service.Service] = reg.Service.Address
+		}
+		require.Equal(r, map[string]string{"web": "5.6.7.8", "web-ingress": "1.2.3.4"}, names)
+	})
+}
+
+func TestServiceResource_ingressRegistrations(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		annotations map[string]string
+		tls         bool
+		expName     string
+		expPort     int
+	}{
+		"defaults": {
+			expName: "web-ingress",
+			expPort: 80,
+		},
+		"tls": {
+			tls:     true,
+			expName: "web-ingress",
+			expPort: 443,
+		},
+		"annotations": {
+			annotations: map[string]string{
+				annotationServiceName: "web-public",
+				annotationServicePort: "8443",
+			},
+			tls:     true,
+			expName: "web-public",
+			expPort: 8443,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			serviceResource := defaultServiceResource(fake.NewSimpleClientset(), newTestSyncer())
+			ingress := lbIngress("web", metav1.NamespaceDefault, "1.2.3.4")
+			for k, v := range c.annotations {
+				ingress.Annotations[k] = v
+			}
+			if c.tls {
+				ingress.Spec.TLS = []networkingv1beta1.IngressTLS{{Hosts: []string{"web.example.com"}}}
+			}
+			registrations := serviceResource.ingressRegistrations(ingress)
+			require.Len(t, registrations, 1)
+			require.Equal(t, c.expName, registrations[0].Service.Service)
+			require.Equal(t, c.expPort, registrations[0].Service.Port)
+		})
+	}
+}
+
+// lbIngress returns an ingress whose load balancer has the given addresses,
+// which are IPs unless they contain letters.
+func lbIngress(name, namespace string, addrs ...string) *networkingv1beta1.Ingress {
+	ingress := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: map[string]string{},
+		},
+	}
+	for _, addr := range addrs {
+		if strings.ContainsAny(addr, "abcdefghijklmnopqrstuvwxyz") {
+			ingress.Status.LoadBalancer.Ingress = append(ingress.Status.LoadBalancer.Ingress, apiv1.LoadBalancerIngress{Hostname: addr})
+		} else {
+			ingress.Status.LoadBalancer.Ingress = append(ingress.Status.LoadBalancer.Ingress, apiv1.LoadBalancerIngress{IP: addr})
+		}
+	}
+	return ingress
+}
@@ -3,17 +3,22 @@ package catalog
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	mapset "github.com/deckarep/golang-set"
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/helper/dashboard"
+	"github.com/hashicorp/consul-k8s/helper/servicemeta"
 	"github.com/hashicorp/consul-k8s/namespaces"
 	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	apiv1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
@@ -32,6 +37,10 @@ const (
 	ConsulK8SRefKind  = "external-k8s-ref-kind"
 	ConsulK8SRefValue = "external-k8s-ref-name"
 	ConsulK8SNodeName = "external-k8s-node-name"
+
+	// ConsulK8SDashboardURL is the key used in the meta to record the
+	// rendered DashboardURLTemplate.
+	ConsulK8SDashboardURL = dashboard.MetaKey
 )
 
 type NodePortSyncType string
@@ -68,9 +77,22 @@ type ServiceResource struct {
 	// takes precedence over AllowK8sNamespacesSet.
 	DenyK8sNamespacesSet mapset.Set
 
+	// LabelSelector, if set, limits syncing to the services whose labels
+	// match it. Services that don't match aren't synced even if annotated
+	// to, while the annotation can still disable syncing those that do.
+	LabelSelector labels.Selector
+
 	// ConsulK8STag is the tag value for services registered.
 	ConsulK8STag string
 
+	// MetaPropagator copies the service's labels and annotations that match
+	// its prefixes into the meta of its registrations.
+	MetaPropagator *servicemeta.Propagator
+
+	// DashboardURLTemplate, if set, is rendered for each service and added
+	// to the meta of its registrations under ConsulK8SDashboardURL.
+	DashboardURLTemplate *dashboard.URLTemplate
+
 	//ConsulServicePrefix prepends K8s services in Consul with a prefix
 	ConsulServicePrefix string
 
@@ -83,6 +105,12 @@ type ServiceResource struct {
 	// Setting this to false will ignore ClusterIP services during the sync.
 	ClusterIPSync bool
 
+	// HeadlessSync set to true syncs headless ClusterIP services, whose
+	// ClusterIP is "None", even if ClusterIPSync is false. Like the other
+	// ClusterIP services, they're registered with an instance per endpoint,
+	// e.g. per pod of a StatefulSet.
+	HeadlessSync bool
+
 	// LoadBalancerEndpointsSync set to true (default false) will sync ServiceTypeLoadBalancer endpoints.
 	LoadBalancerEndpointsSync bool
 
@@ -91,6 +119,17 @@ type ServiceResource struct {
 	// ip address will be used instead.
 	NodePortSync NodePortSyncType
 
+	// EndpointSlicesSync set to true (default false) reads the endpoints of
+	// services from their EndpointSlices instead of their Endpoints. NodePort
+	// services are then registered with an instance per ready endpoint, using
+	// the address and port of the pod, rather than an instance per node.
+	EndpointSlicesSync bool
+
+	// IngressSync set to true (default false) also syncs Ingresses, with an
+	// instance per address of their load balancer. They're filtered and
+	// annotated like services.
+	IngressSync bool
+
 	// AddK8SNamespaceSuffix set to true appends Kubernetes namespace
 	// to the service name being synced to Consul separated by a dash.
 	// For example, service 'foo' in the 'default' namespace will be synced
@@ -119,6 +158,12 @@ type ServiceResource struct {
 	// `k8s-default` namespace.
 	K8SNSMirroringPrefix string
 
+	// K8SNSMapping, if set, maps Kubernetes namespaces to the Consul
+	// namespace their services are registered into when Consul namespaces
+	// are enabled. Namespaces it doesn't match fall back to mirroring or
+	// ConsulDestinationNamespace.
+	K8SNSMapping *namespaces.Mapping
+
 	// The Consul node name to register service with.
 	ConsulNodeName string
 
@@ -133,10 +178,20 @@ type ServiceResource struct {
 	// of each service.
 	endpointsMap map[string]*apiv1.Endpoints
 
+	// endpointSlicesMap uses the same keys as serviceMap but maps to the
+	// EndpointSlices of each service by name. It's only populated if
+	// EndpointSlicesSync is set, in which case endpointsMap is built from it.
+	endpointSlicesMap map[string]map[string]*discoveryv1beta1.EndpointSlice
+
 	// consulMap holds the services in Consul that we've registered from kube.
 	// It's populated via Consul's API and lets us diff what is actually in
 	// Consul vs. what we expect to be there.
 	consulMap map[string][]*consulapi.CatalogRegistration
+
+	// ingressMap holds the registrations of the ingresses we've synced,
+	// keyed by the namespace and name of the ingress. It's separate from
+	// consulMap since ingresses and services can have the same key.
+	ingressMap map[string][]*consulapi.CatalogRegistration
 }
 
 // Informer implements the controller.Resource interface.
@@ -191,7 +246,13 @@ func (t *ServiceResource) Upsert(key string, raw interface{}) error {
 	t.Log.Debug("[ServiceResource.Upsert] adding service to serviceMap", "key", key, "service", service)
 
 	// If we care about endpoints, we should do the initial endpoints load.
-	if t.shouldTrackEndpoints(key) {
+	if t.shouldTrackEndpoints(key) && t.EndpointSlicesSync {
+		if err := t.loadEndpointSlices(key, service); err != nil {
+			t.Log.Warn("error loading initial endpoint slices",
+				"key", key,
+				"err", err)
+		}
+	} else if t.shouldTrackEndpoints(key) {
 		endpoints, err := t.Client.CoreV1().
 			Endpoints(service.Namespace).
 			Get(context.TODO(), service.Name, metav1.GetOptions{})
@@ -231,6 +292,7 @@ func (t *ServiceResource) doDelete(key string) {
 	delete(t.serviceMap, key)
 	t.Log.Debug("[doDelete] deleting service from serviceMap", "key", key)
 	delete(t.endpointsMap, key)
+	delete(t.endpointSlicesMap, key)
 	t.Log.Debug("[doDelete] deleting endpoints from endpointsMap", "key", key)
 	// If there were registrations related to this service, then
 	// delete them and sync.
@@ -243,34 +305,63 @@ func (t *ServiceResource) doDelete(key string) {
 // Run implements the controller.Backgrounder interface.
 func (t *ServiceResource) Run(ch <-chan struct{}) {
 	t.Log.Info("starting runner for endpoints")
+	if t.IngressSync {
+		t.Log.Info("starting runner for ingresses")
+		go (&controller.Controller{
+			Log:      t.Log.Named("controller/ingresses"),
+			Resource: &ingressResource{Service: t},
+		}).Run(ch)
+	}
+	var resource controller.Resource = &serviceEndpointsResource{Service: t}
+	if t.EndpointSlicesSync {
+		resource = &serviceEndpointSlicesResource{Service: t}
+	}
 	(&controller.Controller{
 		Log:      t.Log.Named("controller/endpoints"),
-		Resource: &serviceEndpointsResource{Service: t},
+		Resource: resource,
 	}).Run(ch)
 }
 
 // shouldSync returns true if resyncing should be enabled for the given service.
 func (t *ServiceResource) shouldSync(svc *apiv1.Service) bool {
+	// Ignore ClusterIP services if ClusterIP sync is disabled, unless
+	// they're headless and headless sync is enabled.
+	if svc.Spec.Type == apiv1.ServiceTypeClusterIP && !t.ClusterIPSync && !(t.HeadlessSync && isHeadless(svc)) {
+		t.Log.Debug("[shouldSync] ignoring clusterip service", "svc.Namespace", svc.Namespace, "service", svc)
+		return false
+	}
+	return t.shouldSyncObject(&svc.ObjectMeta)
+}
+
+// isHeadless returns true if the service is a headless service, which
+// doesn't have a ClusterIP and whose DNS records are those of its pods.
+func isHeadless(svc *apiv1.Service) bool {
+	return svc.Spec.ClusterIP == apiv1.ClusterIPNone
+}
+
+// shouldSyncObject returns true if the service or ingress with the given
+// metadata passes the namespace and label filters and is annotated to sync,
+// or syncing is enabled by default.
+func (t *ServiceResource) shouldSyncObject(obj *metav1.ObjectMeta) bool {
 	// Namespace logic
 	// If in deny list, don't sync
-	if t.DenyK8sNamespacesSet.Contains(svc.Namespace) {
-		t.Log.Debug("[shouldSync] service is in the deny list", "svc.Namespace", svc.Namespace, "service", svc)
+	if t.DenyK8sNamespacesSet.Contains(obj.Namespace) {
+		t.Log.Debug("[shouldSync] object is in the deny list", "namespace", obj.Namespace, "name", obj.Name)
 		return false
 	}
 
 	// If not in allow list or allow list is not *, don't sync
-	if !t.AllowK8sNamespacesSet.Contains("*") && !t.AllowK8sNamespacesSet.Contains(svc.Namespace) {
-		t.Log.Debug("[shouldSync] service not in allow list", "svc.Namespace", svc.Namespace, "service", svc)
+	if !t.AllowK8sNamespacesSet.Contains("*") && !t.AllowK8sNamespacesSet.Contains(obj.Namespace) {
+		t.Log.Debug("[shouldSync] object not in allow list", "namespace", obj.Namespace, "name", obj.Name)
 		return false
 	}
 
-	// Ignore ClusterIP services if ClusterIP sync is disabled
-	if svc.Spec.Type == apiv1.ServiceTypeClusterIP && !t.ClusterIPSync {
-		t.Log.Debug("[shouldSync] ignoring clusterip service", "svc.Namespace", svc.Namespace, "service", svc)
+	if t.LabelSelector != nil && !t.LabelSelector.Matches(labels.Set(obj.Labels)) {
+		t.Log.Debug("[shouldSync] object doesn't match the label selector", "namespace", obj.Namespace, "name", obj.Name)
 		return false
 	}
 
-	raw, ok := svc.Annotations[annotationServiceSync]
+	raw, ok := obj.Annotations[annotationServiceSync]
 	if !ok {
 		// If there is no explicit value, then set it to our current default.
 		return !t.ExplicitEnable
@@ -279,7 +370,7 @@ func (t *ServiceResource) shouldSync(svc *apiv1.Service) bool {
 	v, err := strconv.ParseBool(raw)
 	if err != nil {
 		t.Log.Warn("error parsing service-sync annotation",
-			"service-name", t.addPrefixAndK8SNamespace(svc.Name, svc.Namespace),
+			"service-name", t.addPrefixAndK8SNamespace(obj.Name, obj.Namespace),
 			"err", err)
 
 		// Fallback to default
@@ -360,13 +451,19 @@ func (t *ServiceResource) generateRegistrations(key string) {
 		baseService.Service = strings.TrimSpace(v)
 	}
 
+	dashboardURL, err := t.DashboardURLTemplate.Render(dashboard.URLData{
+		Namespace:   svc.Namespace,
+		Name:        svc.Name,
+		ServiceName: baseService.Service,
+	})
+	if err != nil {
+		t.Log.Warn("error rendering dashboard URL", "key", key, "err", err)
+	} else if dashboardURL != "" {
+		baseService.Meta[ConsulK8SDashboardURL] = dashboardURL
+	}
+
 	// Update the Consul namespace based on namespace settings
-	consulNS := namespaces.ConsulNamespace(svc.Namespace,
-		t.EnableNamespaces,
-		t.ConsulDestinationNamespace,
-		t.EnableK8SNSMirroring,
-		t.K8SNSMirroringPrefix)
-	if consulNS != "" {
+	if consulNS := t.consulNamespace(svc.Namespace); consulNS != "" {
 		t.Log.Debug("[generateRegistrations] namespace being used", "key", key, "namespace", consulNS)
 		baseService.Namespace = consulNS
 	}
@@ -440,6 +537,18 @@ func (t *ServiceResource) generateRegistrations(key string) {
 		}
 	}
 
+	// Copy the labels and annotations matching the propagation prefixes.
+	// They don't override the meta set above or by the meta annotations.
+	propagated, errs := t.MetaPropagator.Meta(svc.Labels, svc.Annotations)
+	for _, err := range errs {
+		t.Log.Warn("not copying to service meta", "key", key, "err", err)
+	}
+	for k, v := range propagated {
+		if _, ok := baseService.Meta[k]; !ok {
+			baseService.Meta[k] = v
+		}
+	}
+
 	// Parse any additional meta
 	for k, v := range svc.Annotations {
 		if strings.HasPrefix(k, annotationServiceMetaPrefix) {
@@ -511,6 +620,13 @@ func (t *ServiceResource) generateRegistrations(key string) {
 	// pods are running on. This way we don't register _every_ K8S
 	// node as part of the service.
 	case apiv1.ServiceTypeNodePort:
+		// Endpoint slices are registered per endpoint, with the pod's
+		// address and port, so that each pod has its own health.
+		if t.EndpointSlicesSync {
+			t.registerServiceInstance(baseNode, baseService, key, overridePortName, overridePortNumber, false)
+			return
+		}
+
 		if t.endpointsMap == nil {
 			return
 		}
@@ -666,6 +782,21 @@ func (t *ServiceResource) registerServiceInstance(
 // sync calls the Syncer.Sync function from the generated registrations.
 //
 // Precondition: lock must be held
+// consulNamespace returns the Consul namespace that the services synced
+// from the k8s namespace k8sNS are registered into, or "" if Consul
+// namespaces are disabled.
+func (t *ServiceResource) consulNamespace(k8sNS string) string {
+	consulNS := namespaces.ConsulNamespace(k8sNS,
+		t.EnableNamespaces,
+		t.ConsulDestinationNamespace,
+		t.EnableK8SNSMirroring,
+		t.K8SNSMirroringPrefix)
+	if mappedNS, ok := t.K8SNSMapping.ConsulNamespace(k8sNS); ok && t.EnableNamespaces {
+		consulNS = mappedNS
+	}
+	return consulNS
+}
+
 func (t *ServiceResource) sync() {
 	// NOTE(mitchellh): This isn't the most efficient way to do this and
 	// the times that sync are called are also not the most efficient. All
@@ -675,6 +806,9 @@ func (t *ServiceResource) sync() {
 	for _, set := range t.consulMap {
 		rs = append(rs, set...)
 	}
+	for _, set := range t.ingressMap {
+		rs = append(rs, set...)
+	}
 
 	// Sync, which should be non-blocking in real-world cases
 	t.Syncer.Sync(rs)
@@ -760,6 +894,196 @@ func (t *serviceEndpointsResource) Delete(key string, _ interface{}) error {
 	return nil
 }
 
+// serviceEndpointSlicesResource implements controller.Resource and starts
+// a background watcher on EndpointSlices that is used instead of
+// serviceEndpointsResource when EndpointSlicesSync is set.
+type serviceEndpointSlicesResource struct {
+	Service *ServiceResource
+}
+
+func (t *serviceEndpointSlicesResource) Informer() cache.SharedIndexInformer {
+	// Like with Endpoints, all k8s namespaces are watched and slices of
+	// services that aren't tracked are ignored in Upsert.
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return t.Service.Client.DiscoveryV1beta1().
+					EndpointSlices(metav1.NamespaceAll).
+					List(context.TODO(), options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return t.Service.Client.DiscoveryV1beta1().
+					EndpointSlices(metav1.NamespaceAll).
+					Watch(context.TODO(), options)
+			},
+		},
+		&discoveryv1beta1.EndpointSlice{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+func (t *serviceEndpointSlicesResource) Upsert(key string, raw interface{}) error {
+	svc := t.Service
+	slice, ok := raw.(*discoveryv1beta1.EndpointSlice)
+	if !ok {
+		svc.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+
+	// Slices are named after their service but keyed by their own name, so
+	// the service is found from the label the slice controller sets.
+	serviceName, ok := slice.Labels[discoveryv1beta1.LabelServiceName]
+	if !ok {
+		return nil
+	}
+	serviceKey := slice.Namespace + "/" + serviceName
+
+	svc.serviceLock.Lock()
+	defer svc.serviceLock.Unlock()
+
+	// Check if we care about endpoints for this service
+	if !svc.shouldTrackEndpoints(serviceKey) {
+		return nil
+	}
+
+	if svc.endpointSlicesMap == nil {
+		svc.endpointSlicesMap = make(map[string]map[string]*discoveryv1beta1.EndpointSlice)
+	}
+	if svc.endpointSlicesMap[serviceKey] == nil {
+		svc.endpointSlicesMap[serviceKey] = make(map[string]*discoveryv1beta1.EndpointSlice)
+	}
+	svc.endpointSlicesMap[serviceKey][slice.Name] = slice
+	svc.setEndpointsFromSlices(serviceKey)
+
+	// Update the registration and trigger a sync
+	svc.generateRegistrations(serviceKey)
+	svc.sync()
+	svc.Log.Info("upsert endpoint slice", "key", key, "service", serviceKey)
+	return nil
+}
+
+func (t *serviceEndpointSlicesResource) Delete(key string, _ interface{}) error {
+	svc := t.Service
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		svc.Log.Warn("delete got invalid key", "key", key, "err", err)
+		return nil
+	}
+
+	svc.serviceLock.Lock()
+	defer svc.serviceLock.Unlock()
+
+	// The slice's labels may not be known anymore, so the service is found
+	// from the slices that are tracked instead.
+	for serviceKey, slices := range svc.endpointSlicesMap {
+		if _, ok := slices[name]; !ok || !strings.HasPrefix(serviceKey, namespace+"/") {
+			continue
+		}
+		delete(slices, name)
+		svc.setEndpointsFromSlices(serviceKey)
+		svc.generateRegistrations(serviceKey)
+		svc.sync()
+		break
+	}
+
+	svc.Log.Info("delete endpoint slice", "key", key)
+	return nil
+}
+
+// loadEndpointSlices loads the EndpointSlices of service, stored under key,
+// and sets its endpoints from them.
+//
+// Precondition: assumes t.serviceLock is held
+func (t *ServiceResource) loadEndpointSlices(key string, service *apiv1.Service) error {
+	list, err := t.Client.DiscoveryV1beta1().
+		EndpointSlices(service.Namespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: discoveryv1beta1.LabelServiceName + "=" + service.Name,
+		})
+	if err != nil {
+		return err
+	}
+
+	slices := make(map[string]*discoveryv1beta1.EndpointSlice, len(list.Items))
+	for i := range list.Items {
+		slices[list.Items[i].Name] = &list.Items[i]
+	}
+	if t.endpointSlicesMap == nil {
+		t.endpointSlicesMap = make(map[string]map[string]*discoveryv1beta1.EndpointSlice)
+	}
+	t.endpointSlicesMap[key] = slices
+	t.setEndpointsFromSlices(key)
+	t.Log.Debug("[ServiceResource.loadEndpointSlices] adding service's endpoint slices to endpointSlicesMap", "key", key, "slices", len(slices))
+	return nil
+}
+
+// setEndpointsFromSlices sets the endpoints of the service stored under key
+// to the ready endpoints of its EndpointSlices.
+//
+// Precondition: assumes t.serviceLock is held
+func (t *ServiceResource) setEndpointsFromSlices(key string) {
+	if t.endpointsMap == nil {
+		t.endpointsMap = make(map[string]*apiv1.Endpoints)
+	}
+	t.endpointsMap[key] = endpointsFromSlices(t.endpointSlicesMap[key])
+}
+
+// endpointsFromSlices returns Endpoints with a subset per EndpointSlice that
+// has the slice's ports and the addresses of its ready endpoints. FQDN slices
+// are skipped since their addresses aren't IPs.
+func endpointsFromSlices(slices map[string]*discoveryv1beta1.EndpointSlice) *apiv1.Endpoints {
+	// Sort the slices so the registrations are generated in the same order.
+	names := make([]string, 0, len(slices))
+	for name := range slices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	endpoints := &apiv1.Endpoints{}
+	for _, name := range names {
+		slice := slices[name]
+		if slice.AddressType == discoveryv1beta1.AddressTypeFQDN {
+			continue
+		}
+
+		var subset apiv1.EndpointSubset
+		for _, p := range slice.Ports {
+			var port apiv1.EndpointPort
+			if p.Name != nil {
+				port.Name = *p.Name
+			}
+			if p.Port != nil {
+				port.Port = *p.Port
+			}
+			if p.Protocol != nil {
+				port.Protocol = *p.Protocol
+			}
+			subset.Ports = append(subset.Ports, port)
+		}
+		for _, ep := range slice.Endpoints {
+			// A nil ready condition means the endpoint is ready.
+			if len(ep.Addresses) == 0 || (ep.Conditions.Ready != nil && !*ep.Conditions.Ready) {
+				continue
+			}
+			addr := apiv1.EndpointAddress{
+				IP:        ep.Addresses[0],
+				TargetRef: ep.TargetRef,
+			}
+			if ep.Hostname != nil {
+				addr.Hostname = *ep.Hostname
+			}
+			if nodeName, ok := ep.Topology[apiv1.LabelHostname]; ok {
+				addr.NodeName = &nodeName
+			}
+			subset.Addresses = append(subset.Addresses, addr)
+		}
+		endpoints.Subsets = append(endpoints.Subsets, subset)
+	}
+	return endpoints
+}
+
 func (t *ServiceResource) addPrefixAndK8SNamespace(name, namespace string) string {
 	if t.ConsulServicePrefix != "" {
 		name = fmt.Sprintf("%s%s", t.ConsulServicePrefix, name)
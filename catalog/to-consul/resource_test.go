@@ -6,11 +6,16 @@ import (
 
 	"github.com/deckarep/golang-set"
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/helper/dashboard"
+	"github.com/hashicorp/consul-k8s/helper/servicemeta"
+	"github.com/hashicorp/consul-k8s/namespaces"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
 	apiv1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
@@ -102,6 +107,49 @@ func TestServiceResource_defaultEnableDisable(t *testing.T) {
 	})
 }
 
+// Test that only services matching the label selector are synced and that
+// a service is deregistered once it stops matching.
+func TestServiceResource_labelSelector(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	selector, err := labels.Parse("consul.hashicorp.com/sync=true")
+	require.NoError(t, err)
+	serviceResource.LabelSelector = selector
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	// Insert a labeled and an unlabeled LB service
+	svc := lbService("foo", metav1.NamespaceDefault, "1.2.3.4")
+	svc.Labels = map[string]string{"consul.hashicorp.com/sync": "true"}
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), lbService("bar", metav1.NamespaceDefault, "5.6.7.8"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 1)
+		require.Equal(r, "foo", actual[0].Service.Service)
+	})
+
+	// Remove the label
+	svc.Labels = nil
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Update(context.Background(), svc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 0)
+	})
+}
+
 // Test that we can default disable
 func TestServiceResource_defaultDisable(t *testing.T) {
 	t.Parallel()
@@ -607,6 +655,77 @@ func TestServiceResource_lbAnnotatedMeta(t *testing.T) {
 	})
 }
 
+// Test that the dashboard URL is rendered with the service's name and
+// namespace and the name it's registered with in Consul.
+func TestServiceResource_dashboardURL(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	tpl, err := dashboard.ParseURLTemplate("https://dashboard.example.com/#/service/{{ .Namespace }}/{{ .Name }}?consul={{ .ServiceName }}")
+	require.NoError(t, err)
+	serviceResource.DashboardURLTemplate = tpl
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	// Insert an LB service
+	svc := lbService("foo", metav1.NamespaceDefault, "1.2.3.4")
+	svc.Annotations[annotationServiceName] = "bar"
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// Verify what we got
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 1)
+		require.Equal(r, "https://dashboard.example.com/#/service/default/foo?consul=bar", actual[0].Service.Meta[ConsulK8SDashboardURL])
+		require.Equal(r, ConsulSourceValue, actual[0].Service.Meta[ConsulSourceKey])
+	})
+}
+
+// Test that labels and annotations matching the propagation prefixes are
+// copied into the meta without overriding the meta annotations.
+func TestServiceResource_propagatedMeta(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.MetaPropagator = &servicemeta.Propagator{
+		LabelPrefixes:      []string{"team"},
+		AnnotationPrefixes: []string{"example.com/"},
+	}
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	// Insert an LB service
+	svc := lbService("foo", metav1.NamespaceDefault, "1.2.3.4")
+	svc.Labels = map[string]string{"team": "payments", "tier": "backend"}
+	svc.Annotations["example.com/cost-center"] = "42"
+	svc.Annotations["example.com/owner"] = "someone"
+	svc.Annotations[annotationServiceMetaPrefix+"example_com_owner"] = "override"
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// Verify what we got
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 1)
+		meta := actual[0].Service.Meta
+		require.Equal(r, "payments", meta["team"])
+		require.Equal(r, "42", meta["example_com_cost-center"])
+		require.Equal(r, "override", meta["example_com_owner"])
+		require.NotContains(r, meta, "tier")
+	})
+}
+
 // Test that with LoadBalancerEndpointsSync set to true we track the IP of the endpoints not the LB IP/name
 func TestServiceResource_lbRegisterEndpoints(t *testing.T) {
 	t.Parallel()
@@ -969,6 +1088,72 @@ func TestServiceResource_nodePort_externalFirstSync(t *testing.T) {
 	})
 }
 
+// Test that with EndpointSlicesSync, NodePort services are registered with
+// an instance per ready endpoint and are updated when the slices change.
+func TestServiceResource_nodePortEndpointSlices(t *testing.T) {
+	t.Parallel()
+	syncer := newTestSyncer()
+	client := fake.NewSimpleClientset()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.NodePortSync = ExternalOnly
+	serviceResource.EndpointSlicesSync = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	createNodes(t, client)
+
+	// The first slice exists before the service so it's loaded on upsert.
+	createEndpointSlice(t, client, "foo-abc", "foo", metav1.NamespaceDefault, []discoveryv1beta1.Endpoint{
+		endpointSliceEndpoint("1.1.1.1", nodeName1, true),
+		endpointSliceEndpoint("3.3.3.3", nodeName1, false),
+	})
+
+	// Insert the service
+	svc := nodePortService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 1)
+		require.Equal(r, "foo", actual[0].Service.Service)
+		require.Equal(r, "1.1.1.1", actual[0].Service.Address)
+		require.Equal(r, 8080, actual[0].Service.Port)
+		require.Equal(r, "pod-1.1.1.1", actual[0].Service.Meta[ConsulK8SRefValue])
+		require.Equal(r, nodeName1, actual[0].Service.Meta[ConsulK8SNodeName])
+	})
+
+	// Slices created later are watched.
+	createEndpointSlice(t, client, "foo-def", "foo", metav1.NamespaceDefault, []discoveryv1beta1.Endpoint{
+		endpointSliceEndpoint("2.2.2.2", nodeName2, true),
+	})
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		require.Equal(r, "1.1.1.1", actual[0].Service.Address)
+		require.Equal(r, "2.2.2.2", actual[1].Service.Address)
+		require.Equal(r, 8080, actual[1].Service.Port)
+		require.NotEqual(r, actual[0].Service.ID, actual[1].Service.ID)
+	})
+
+	// Deleting a slice deregisters its endpoints.
+	err = client.DiscoveryV1beta1().EndpointSlices(metav1.NamespaceDefault).Delete(context.Background(), "foo-abc", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 1)
+		require.Equal(r, "2.2.2.2", actual[0].Service.Address)
+	})
+}
+
 // Test that the proper registrations are generated for a ClusterIP type.
 func TestServiceResource_clusterIP(t *testing.T) {
 	t.Parallel()
@@ -1188,6 +1373,69 @@ func TestServiceResource_clusterIPSyncDisabled(t *testing.T) {
 	})
 }
 
+// Test that headless services are synced with an instance per pod when
+// HeadlessSync is enabled, even if ClusterIPSync is disabled.
+func TestServiceResource_headlessSync(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = false
+	serviceResource.HeadlessSync = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	// Insert a ClusterIP service, which isn't synced, and a headless one.
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), clusterIPService("foo", metav1.NamespaceDefault), metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+	svc := clusterIPService("db", metav1.NamespaceDefault)
+	svc.Spec.ClusterIP = apiv1.ClusterIPNone
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	node := nodeName1
+	_, err = client.CoreV1().Endpoints(metav1.NamespaceDefault).Create(
+		context.Background(),
+		&apiv1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db",
+				Namespace: metav1.NamespaceDefault,
+			},
+			Subsets: []apiv1.EndpointSubset{
+				{
+					Addresses: []apiv1.EndpointAddress{
+						{NodeName: &node, IP: "1.1.1.1", Hostname: "db-0", TargetRef: &apiv1.ObjectReference{Kind: "Pod", Name: "db-0"}},
+						{NodeName: &node, IP: "2.2.2.2", Hostname: "db-1", TargetRef: &apiv1.ObjectReference{Kind: "Pod", Name: "db-1"}},
+					},
+					Ports: []apiv1.EndpointPort{
+						{Name: "http", Port: 8080},
+						{Name: "rpc", Port: 2000},
+					},
+				},
+			},
+		},
+		metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// Verify what we got
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		for i, pod := range []struct{ name, addr string }{{"db-0", "1.1.1.1"}, {"db-1", "2.2.2.2"}} {
+			require.Equal(r, "db", actual[i].Service.Service)
+			require.Equal(r, pod.addr, actual[i].Service.Address)
+			require.Equal(r, 8080, actual[i].Service.Port)
+			require.Equal(r, pod.name, actual[i].Service.Meta[ConsulK8SRefValue])
+			require.Equal(r, "Pod", actual[i].Service.Meta[ConsulK8SRefKind])
+		}
+		require.NotEqual(r, actual[0].Service.ID, actual[1].Service.ID)
+	})
+}
+
 // Test that the ClusterIP services are synced when watching all namespaces
 func TestServiceResource_clusterIPAllNamespaces(t *testing.T) {
 	t.Parallel()
@@ -1453,6 +1701,45 @@ func TestServiceResource_MirroredPrefixNamespace(t *testing.T) {
 	})
 }
 
+// Test that mapped namespaces take precedence over mirroring and that
+// namespaces that aren't mapped are still mirrored.
+func TestServiceResource_MappedNamespace(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.EnableK8SNSMirroring = true
+	serviceResource.EnableNamespaces = true
+	serviceResource.K8SNSMapping = &namespaces.Mapping{
+		Mappings: []namespaces.NamespaceMapping{
+			{K8SNamespace: "team-a-*", ConsulNamespace: "team-a"},
+		},
+	}
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	expNamespaces := map[string]string{
+		"team-a-prod": "team-a",
+		"team-a-dev":  "team-a",
+		"team-b":      "team-b",
+	}
+	for ns := range expNamespaces {
+		_, err := client.CoreV1().Services(ns).
+			Create(context.Background(), lbService(ns, ns, "1.2.3.4"), metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 3)
+		for _, reg := range actual {
+			require.Equal(r, expNamespaces[reg.Service.Service], reg.Service.Namespace)
+		}
+	})
+}
+
 // lbService returns a Kubernetes service of type LoadBalancer.
 func lbService(name, namespace, lbIP string) *apiv1.Service {
 	return &apiv1.Service{
@@ -1590,6 +1877,40 @@ func createEndpoints(t *testing.T, client *fake.Clientset, serviceName string, n
 	require.NoError(t, err)
 }
 
+// createEndpointSlice calls the fake k8s client to create an EndpointSlice
+// of the service with the http and rpc ports.
+func createEndpointSlice(t *testing.T, client *fake.Clientset, name, serviceName, namespace string, endpoints []discoveryv1beta1.Endpoint) {
+	http, rpc := "http", "rpc"
+	httpPort, rpcPort := int32(8080), int32(2000)
+	_, err := client.DiscoveryV1beta1().EndpointSlices(namespace).Create(
+		context.Background(),
+		&discoveryv1beta1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{discoveryv1beta1.LabelServiceName: serviceName},
+			},
+			AddressType: discoveryv1beta1.AddressTypeIPv4,
+			Endpoints:   endpoints,
+			Ports: []discoveryv1beta1.EndpointPort{
+				{Name: &http, Port: &httpPort},
+				{Name: &rpc, Port: &rpcPort},
+			},
+		},
+		metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+// endpointSliceEndpoint returns an endpoint of a pod named after its IP.
+func endpointSliceEndpoint(ip, nodeName string, ready bool) discoveryv1beta1.Endpoint {
+	return discoveryv1beta1.Endpoint{
+		Addresses:  []string{ip},
+		Conditions: discoveryv1beta1.EndpointConditions{Ready: &ready},
+		TargetRef:  &apiv1.ObjectReference{Kind: "Pod", Name: "pod-" + ip},
+		Topology:   map[string]string{apiv1.LabelHostname: nodeName},
+	}
+}
+
 func defaultServiceResource(client kubernetes.Interface, syncer Syncer) ServiceResource {
 	return ServiceResource{
 		Log:                   hclog.Default(),
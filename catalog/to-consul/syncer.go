@@ -7,9 +7,12 @@ import (
 
 	"github.com/cenkalti/backoff"
 	"github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/catalog/dryrun"
+	"github.com/hashicorp/consul-k8s/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/namespaces"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -73,8 +76,25 @@ type ConsulSyncer struct {
 	// separate client for this API call that handles older version of Consul.
 	ConsulNodeServicesClient ConsulNodeServicesClient
 
-	lock sync.Mutex
-	once sync.Once
+	// Metrics are updated after each full sync. Optional.
+	Metrics *metrics.SyncMetrics
+
+	// DryRun, if set, makes the syncer record the registrations and
+	// deregistrations of each full sync on the plan instead of making them.
+	DryRun *dryrun.Plan
+
+	// WriteRateLimit is the maximum number of write requests per second
+	// made to Consul by the full syncs, or 0 for no limit.
+	//
+	// WriteBatchSize is the maximum number of registrations written in a
+	// single catalog transaction, up to MaxWriteBatchSize. With 0 or 1, each
+	// is written with its own request.
+	WriteRateLimit float64
+	WriteBatchSize int
+
+	lock    sync.Mutex
+	once    sync.Once
+	limiter *rate.Limiter
 
 	// initialSync is used to ensure that we have received our initial list
 	// of services before we start reaping services. When it is closed,
@@ -190,6 +210,9 @@ func (s *ConsulSyncer) watchReapableServices(ctx context.Context) {
 		err := backoff.Retry(func() error {
 			var err error
 			services, meta, err = s.ConsulNodeServicesClient.NodeServices(s.ConsulK8STag, s.ConsulNodeName, *opts)
+			if err != nil {
+				s.Metrics.ConsulAPIError(metrics.OperationListServices)
+			}
 			return err
 		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 
@@ -276,6 +299,9 @@ func (s *ConsulSyncer) watchService(ctx context.Context, name, namespace string)
 		err := backoff.Retry(func() error {
 			var err error
 			services, _, err = s.Client.Catalog().Service(name, s.ConsulK8STag, queryOpts)
+			if err != nil {
+				s.Metrics.ConsulAPIError(metrics.OperationListServices)
+			}
 			return err
 		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 		if err != nil {
@@ -330,6 +356,7 @@ func (s *ConsulSyncer) scheduleReapServiceLocked(name, namespace string) error {
 	// Only consider services that are tagged from k8s
 	services, _, err := s.Client.Catalog().Service(name, s.ConsulK8STag, &opts)
 	if err != nil {
+		s.Metrics.ConsulAPIError(metrics.OperationListServices)
 		return err
 	}
 
@@ -361,6 +388,12 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 
 	s.Log.Info("registering services")
 
+	start := time.Now()
+	// registered and failures count the services that were registered and
+	// that failed to sync by Consul namespace.
+	registered := make(map[string]int)
+	failures := make(map[string]int)
+
 	// Update the service watchers
 	for ns, watchers := range s.watchers {
 		// If the service the watcher is watching is no longer valid,
@@ -393,59 +426,102 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 		}
 	}
 
+	if s.DryRun != nil {
+		s.planFullLocked()
+		return
+	}
+
 	// Do all deregistrations first
+	deregs := make([]*api.CatalogDeregistration, 0, len(s.deregs))
 	for _, r := range s.deregs {
-		s.Log.Info("deregistering service",
-			"node-name", r.Node,
-			"service-id", r.ServiceID,
-			"service-consul-namespace", r.Namespace)
-		_, err := s.Client.Catalog().Deregister(r, nil)
-		if err != nil {
-			s.Log.Warn("error deregistering service",
-				"node-name", r.Node,
-				"service-id", r.ServiceID,
-				"service-consul-namespace", r.Namespace,
-				"err", err)
-		}
+		deregs = append(deregs, r)
 	}
+	s.deregister(ctx, deregs, failures)
 
 	// Always clear deregistrations, they'll repopulate if we had errors
 	s.deregs = make(map[string]*api.CatalogDeregistration)
 
 	// Register all the services. This will overwrite any changes that
 	// may have been made to the registered services.
+	var regs []*api.CatalogRegistration
+	// ensuredNamespaces holds the result of checking each Consul namespace
+	// exists so that it's only checked once per sync.
+	ensuredNamespaces := make(map[string]error)
 	for _, services := range s.namespaces {
 		for _, r := range services {
 			if s.EnableNamespaces {
-				_, err := namespaces.EnsureExists(s.Client, r.Service.Namespace, s.CrossNamespaceACLPolicy)
+				err, ok := ensuredNamespaces[r.Service.Namespace]
+				if !ok {
+					if err = s.waitWrite(ctx); err != nil {
+						return
+					}
+					_, err = namespaces.EnsureExists(s.Client, r.Service.Namespace, s.CrossNamespaceACLPolicy)
+					ensuredNamespaces[r.Service.Namespace] = err
+					if err != nil {
+						s.Metrics.ConsulAPIError(metrics.OperationNamespace)
+					}
+				}
 				if err != nil {
 					s.Log.Warn("error checking and creating Consul namespace",
 						"node-name", r.Node,
 						"service-name", r.Service.Service,
 						"consul-namespace-name", r.Service.Namespace,
 						"err", err)
+					failures[r.Service.Namespace]++
 					continue
 				}
 			}
+			regs = append(regs, r)
+		}
+	}
+	s.register(ctx, regs, registered, failures)
 
-			// Register the service
-			_, err := s.Client.Catalog().Register(r, nil)
-			if err != nil {
-				s.Log.Warn("error registering service",
-					"node-name", r.Node,
-					"service-name", r.Service.Service,
-					"service", r.Service,
-					"err", err)
-				continue
-			}
+	s.Metrics.RecordSync(start, registered, failures)
+}
 
-			s.Log.Debug("registered service instance",
+// planFullLocked records the deregistrations and registrations that syncFull
+// would make on the dry-run plan.
+//
+// Precondition: lock must be held
+func (s *ConsulSyncer) planFullLocked() {
+	var changes []dryrun.ConsulChange
+	// Since the deregistrations aren't made, the services would remain in
+	// Consul and the reaper, which only reports changes, wouldn't schedule
+	// them again. They're kept instead, unless the services are valid again.
+	for id, r := range s.deregs {
+		if s.namespaces[r.Namespace][id] != nil {
+			delete(s.deregs, id)
+			continue
+		}
+		s.Log.Info("[dry-run] would deregister service",
+			"node-name", r.Node,
+			"service-id", r.ServiceID,
+			"service-consul-namespace", r.Namespace)
+		changes = append(changes, dryrun.ConsulChange{
+			Operation: dryrun.OperationDeregister,
+			Node:      r.Node,
+			ServiceID: r.ServiceID,
+			Namespace: r.Namespace,
+		})
+	}
+
+	for _, services := range s.namespaces {
+		for _, r := range services {
+			s.Log.Info("[dry-run] would register service",
 				"node-name", r.Node,
+				"service-id", r.Service.ID,
 				"service-name", r.Service.Service,
-				"consul-namespace-name", r.Service.Namespace,
-				"service", r.Service)
+				"service-consul-namespace", r.Service.Namespace)
+			changes = append(changes, dryrun.ConsulChange{
+				Operation:   dryrun.OperationRegister,
+				Node:        r.Node,
+				ServiceID:   r.Service.ID,
+				ServiceName: r.Service.Service,
+				Namespace:   r.Service.Namespace,
+			})
 		}
 	}
+	s.DryRun.SetConsulChanges(changes)
 }
 
 func (s *ConsulSyncer) init() {
@@ -472,4 +548,7 @@ func (s *ConsulSyncer) init() {
 	if s.initialSync == nil {
 		s.initialSync = make(chan bool)
 	}
+	if s.WriteRateLimit > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(s.WriteRateLimit), 1)
+	}
 }
@@ -2,16 +2,22 @@ package catalog
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/catalog/dryrun"
+	"github.com/hashicorp/consul-k8s/catalog/metrics"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -264,6 +270,215 @@ func TestConsulSyncer_stopsGracefully(t *testing.T) {
 	require.LessOrEqual(t, callCount-beforeStopAPICount, 2)
 }
 
+// Test that the registered services and the failed registrations are
+// reported in the metrics.
+func TestConsulSyncer_metrics(t *testing.T) {
+	t.Parallel()
+
+	// Registrations of the "fail" service are rejected to count the errors.
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/catalog/register" {
+			var reg api.CatalogRegistration
+			err := json.NewDecoder(r.Body).Decode(&reg)
+			if err != nil || reg.Service.Service == "fail" {
+				w.WriteHeader(500)
+				return
+			}
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		fmt.Fprint(w, "[]")
+	}))
+	defer consulServer.Close()
+	client, err := api.NewClient(&api.Config{
+		Address: consulServer.URL,
+	})
+	require.NoError(t, err)
+
+	syncMetrics, err := metrics.NewSyncMetrics(prometheus.NewRegistry(), metrics.DirectionToConsul)
+	require.NoError(t, err)
+	s, closer := testConsulSyncerWithConfig(client, func(s *ConsulSyncer) {
+		s.Metrics = syncMetrics
+	})
+	defer closer()
+
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration(ConsulSyncNodeName, "bar", "default"),
+		testRegistration(ConsulSyncNodeName, "baz", "default"),
+		testRegistration(ConsulSyncNodeName, "fail", "default"),
+	})
+
+	retry.Run(t, func(r *retry.R) {
+		if v := promtestutil.ToFloat64(syncMetrics.ConsulAPIErrors.WithLabelValues(metrics.OperationRegister)); v < 1 {
+			r.Fatalf("expected register errors, got %v", v)
+		}
+		if v := promtestutil.ToFloat64(syncMetrics.SyncErrors.WithLabelValues("")); v < 1 {
+			r.Fatalf("expected sync errors, got %v", v)
+		}
+		if v := promtestutil.ToFloat64(syncMetrics.Services.WithLabelValues("")); v != 2 {
+			r.Fatalf("expected 2 registered services, got %v", v)
+		}
+	})
+}
+
+// Test that in dry-run mode the registrations and deregistrations are
+// planned but not made.
+func TestConsulSyncer_dryRun(t *testing.T) {
+	t.Parallel()
+
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	// Create a service directly in Consul that would be reaped.
+	_, err = client.Catalog().Register(testRegistration(ConsulSyncNodeName, "baz", "default"), nil)
+	require.NoError(t, err)
+
+	plan := &dryrun.Plan{}
+	s, closer := testConsulSyncerWithConfig(client, func(s *ConsulSyncer) {
+		s.DryRun = plan
+	})
+	defer closer()
+
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration(ConsulSyncNodeName, "bar", "default"),
+	})
+
+	expected := []dryrun.ConsulChange{
+		{
+			Operation: dryrun.OperationDeregister,
+			Node:      ConsulSyncNodeName,
+			ServiceID: serviceID(ConsulSyncNodeName, "baz"),
+		},
+		{
+			Operation:   dryrun.OperationRegister,
+			Node:        ConsulSyncNodeName,
+			ServiceID:   serviceID(ConsulSyncNodeName, "bar"),
+			ServiceName: "bar",
+		},
+	}
+	retry.Run(t, func(r *retry.R) {
+		changes, ok := plan.ConsulChanges()
+		require.True(r, ok)
+		require.Equal(r, expected, changes)
+	})
+
+	// The deregistration is still planned by the following syncs.
+	time.Sleep(2 * s.SyncPeriod)
+	changes, _ := plan.ConsulChanges()
+	require.Equal(t, expected, changes)
+
+	// Nothing was written.
+	bazInstances, _, err := client.Catalog().Service("baz", "", nil)
+	require.NoError(t, err)
+	require.Len(t, bazInstances, 1)
+	barInstances, _, err := client.Catalog().Service("bar", "", nil)
+	require.NoError(t, err)
+	require.Len(t, barInstances, 0)
+}
+
+// Test that the registrations are batched into transactions.
+func TestConsulSyncer_batchedWrites(t *testing.T) {
+	t.Parallel()
+
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	s, closer := testConsulSyncerWithConfig(client, func(s *ConsulSyncer) {
+		s.WriteBatchSize = 2
+	})
+	defer closer()
+
+	var regs []*api.CatalogRegistration
+	for i := 0; i < 5; i++ {
+		regs = append(regs, testRegistration(ConsulSyncNodeName, fmt.Sprintf("svc-%d", i), "default"))
+	}
+	// The first transactions fail since the node doesn't exist yet, so the
+	// registrations are made one at a time.
+	s.Sync(regs)
+	requireServices := func(exp ...string) {
+		t.Helper()
+		retry.Run(t, func(r *retry.R) {
+			node, _, err := client.Catalog().Node(ConsulSyncNodeName, nil)
+			require.NoError(r, err)
+			require.NotNil(r, node)
+			var services []string
+			for _, svc := range node.Services {
+				services = append(services, svc.Service)
+			}
+			require.ElementsMatch(r, exp, services)
+		})
+	}
+	requireServices("svc-0", "svc-1", "svc-2", "svc-3", "svc-4")
+
+	// Once the node exists, they're written in transactions.
+	_, err = client.Catalog().Deregister(&api.CatalogDeregistration{
+		Node:      ConsulSyncNodeName,
+		ServiceID: serviceID(ConsulSyncNodeName, "svc-0"),
+	}, nil)
+	require.NoError(t, err)
+	requireServices("svc-0", "svc-1", "svc-2", "svc-3", "svc-4")
+
+	s.Sync(regs[:2])
+	requireServices("svc-0", "svc-1")
+}
+
+// Test that the writes are paced to the rate limit.
+func TestConsulSyncer_writeRateLimit(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	var writes []time.Time
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/catalog/register" {
+			lock.Lock()
+			writes = append(writes, time.Now())
+			lock.Unlock()
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		fmt.Fprint(w, "[]")
+	}))
+	defer consulServer.Close()
+	client, err := api.NewClient(&api.Config{
+		Address: consulServer.URL,
+	})
+	require.NoError(t, err)
+
+	s, closer := testConsulSyncerWithConfig(client, func(s *ConsulSyncer) {
+		s.WriteRateLimit = 10
+	})
+	defer closer()
+
+	var regs []*api.CatalogRegistration
+	for i := 0; i < 5; i++ {
+		regs = append(regs, testRegistration(ConsulSyncNodeName, fmt.Sprintf("svc-%d", i), "default"))
+	}
+	s.Sync(regs)
+
+	retry.Run(t, func(r *retry.R) {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(writes) < 5 {
+			r.Fatalf("expected 5 writes, got %d", len(writes))
+		}
+	})
+	lock.Lock()
+	defer lock.Unlock()
+	// The writes are 100ms apart, give or take the limiter's precision.
+	require.True(t, writes[4].Sub(writes[0]) >= 350*time.Millisecond,
+		"5 writes made in %s", writes[4].Sub(writes[0]))
+}
+
 func testRegistration(node, service, k8sSrcNamespace string) *api.CatalogRegistration {
 	return &api.CatalogRegistration{
 		Node:           node,
@@ -0,0 +1,148 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/catalog/metrics"
+	"github.com/hashicorp/consul/api"
+)
+
+// MaxWriteBatchSize is the maximum number of registrations written in a
+// single catalog transaction, which is the maximum number of
+// operations of a Consul transaction.
+const MaxWriteBatchSize = 64
+
+// waitWrite blocks until the next write to Consul is allowed by
+// WriteRateLimit, or ctx is done.
+func (s *ConsulSyncer) waitWrite(ctx context.Context) error {
+	if s.limiter == nil {
+		return ctx.Err()
+	}
+	return s.limiter.Wait(ctx)
+}
+
+// deregister makes the deregistrations one at a time, since the
+// deregistrations of a transaction need the names of the services. It stops
+// once ctx is done.
+func (s *ConsulSyncer) deregister(ctx context.Context, deregs []*api.CatalogDeregistration, failures map[string]int) {
+	for _, r := range deregs {
+		if err := s.waitWrite(ctx); err != nil {
+			return
+		}
+		s.Log.Info("deregistering service",
+			"node-name", r.Node,
+			"service-id", r.ServiceID,
+			"service-consul-namespace", r.Namespace)
+		_, err := s.Client.Catalog().Deregister(r, nil)
+		if err != nil {
+			s.Log.Warn("error deregistering service",
+				"node-name", r.Node,
+				"service-id", r.ServiceID,
+				"service-consul-namespace", r.Namespace,
+				"err", err)
+			s.Metrics.ConsulAPIError(metrics.OperationDeregister)
+			failures[r.Namespace]++
+		}
+	}
+}
+
+// register makes the registrations, in transactions of up to WriteBatchSize
+// of them. It stops once ctx is done.
+//
+// The transactions don't create the nodes the services are registered on, so
+// they fail until the first registration of the node is made on its own, e.g.
+// by the first sync. A batch that fails is retried one registration at a time
+// so that a single invalid registration doesn't fail the others.
+func (s *ConsulSyncer) register(ctx context.Context, regs []*api.CatalogRegistration, registered, failures map[string]int) {
+	for len(regs) > 0 && ctx.Err() == nil {
+		batch := regs[:s.batchLen(len(regs))]
+		regs = regs[len(batch):]
+
+		if len(batch) > 1 {
+			ops := make(api.TxnOps, 0, len(batch))
+			for _, r := range batch {
+				ops = append(ops, &api.TxnOp{Service: &api.ServiceTxnOp{
+					Verb:    api.ServiceSet,
+					Node:    r.Node,
+					Service: *r.Service,
+				}})
+			}
+			err := s.txn(ctx, ops)
+			if err == nil {
+				for _, r := range batch {
+					registered[r.Service.Namespace]++
+					s.Log.Debug("registered service instance",
+						"node-name", r.Node,
+						"service-name", r.Service.Service,
+						"consul-namespace-name", r.Service.Namespace,
+						"service", r.Service)
+				}
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			s.Log.Warn("error registering services in a transaction, registering them one at a time",
+				"count", len(batch), "err", err)
+			s.Metrics.ConsulAPIError(metrics.OperationRegister)
+		}
+
+		for _, r := range batch {
+			if err := s.waitWrite(ctx); err != nil {
+				return
+			}
+			_, err := s.Client.Catalog().Register(r, nil)
+			if err != nil {
+				s.Log.Warn("error registering service",
+					"node-name", r.Node,
+					"service-name", r.Service.Service,
+					"service", r.Service,
+					"err", err)
+				s.Metrics.ConsulAPIError(metrics.OperationRegister)
+				failures[r.Service.Namespace]++
+				continue
+			}
+			registered[r.Service.Namespace]++
+
+			s.Log.Debug("registered service instance",
+				"node-name", r.Node,
+				"service-name", r.Service.Service,
+				"consul-namespace-name", r.Service.Namespace,
+				"service", r.Service)
+		}
+	}
+}
+
+// batchLen returns the number of the remaining writes to make in the next
+// batch.
+func (s *ConsulSyncer) batchLen(remaining int) int {
+	if s.WriteBatchSize <= 1 {
+		return 1
+	}
+	if remaining < s.WriteBatchSize {
+		return remaining
+	}
+	return s.WriteBatchSize
+}
+
+// txn makes the catalog operations in a single transaction, which Consul
+// rolls back if any of them fails.
+func (s *ConsulSyncer) txn(ctx context.Context, ops api.TxnOps) error {
+	if err := s.waitWrite(ctx); err != nil {
+		return err
+	}
+	ok, resp, _, err := s.Client.Txn().Txn(ops, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		var errs []string
+		for _, e := range resp.Errors {
+			errs = append(errs, fmt.Sprintf("operation %d: %s", e.OpIndex, e.What))
+		}
+		return fmt.Errorf("transaction rolled back: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -2,15 +2,21 @@ package catalog
 
 import (
 	"context"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/catalog/dryrun"
+	"github.com/hashicorp/consul-k8s/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/helper/coalesce"
 	"github.com/hashicorp/go-hclog"
 	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
@@ -23,6 +29,16 @@ const (
 	// K8SMaxPeriod is the maximum time to wait before forcing a sync, even
 	// if there are active changes going on.
 	K8SMaxPeriod = 5 * time.Second
+
+	// annotationServiceTags is set to the comma separated tags of the Consul
+	// service when metadata is synced. It's the same annotation that sets
+	// the tags of services synced from Kubernetes to Consul.
+	annotationServiceTags = "consul.hashicorp.com/service-tags"
+
+	// annotationServiceMetaPrefix is the prefix of the annotations set to
+	// the meta of the Consul service when metadata is synced. The remainder
+	// of the key is the meta key.
+	annotationServiceMetaPrefix = "consul.hashicorp.com/service-meta-"
 )
 
 // Sink is the destination where services are registered.
@@ -31,9 +47,21 @@ const (
 // makes it easy and possible to test the Source in isolation.
 type Sink interface {
 	// SetServices is called with the services that should be created.
-	// The key is the service name and the destination is the external DNS
-	// entry to point to.
+	// The key is the service name, or <namespace>/<name> for services of
+	// another namespace than the sink's, and the destination is the
+	// external DNS entry to point to.
 	SetServices(map[string]string)
+
+	// SetServiceMetadata is called with the metadata of the services
+	// before SetServices if metadata is synced. The key is the service name.
+	SetServiceMetadata(map[string]ServiceMetadata)
+}
+
+// ServiceMetadata is the metadata of a Consul service that is set as
+// annotations on the Kubernetes service created for it.
+type ServiceMetadata struct {
+	Tags []string
+	Meta map[string]string
 }
 
 // K8SSink is a Sink implementation that registers services with Kubernetes.
@@ -51,33 +79,49 @@ type K8SSink struct {
 	// done if there are no changes.
 	SyncPeriod time.Duration
 
+	// Metrics are updated after each sync. Optional.
+	Metrics *metrics.SyncMetrics
+
+	// AllNamespaces, if true, watches the services of all namespaces so
+	// that the services set with keys of the form <namespace>/<name> are
+	// synced into their namespace, which is created if it doesn't exist.
+	AllNamespaces bool
+
+	// DryRun, if set, makes the sink record the services it would create,
+	// update and delete on each sync on the plan instead of writing them.
+	DryRun *dryrun.Plan
+
 	// lock gates concurrent access to all the maps.
 	lock sync.Mutex
 
 	// sourceServices holds Consul services that should be synced to Kube.
-	// It maps from Consul service names to Consul DNS entry, e.g.
-	// foo => foo.service.consul. It's populated from the Consul API.
-	// We lowercase the Consul service names and DNS entries
+	// It maps from Kube controller keys to Consul DNS entry, e.g.
+	// default/foo => foo.service.consul. It's populated from the Consul API.
+	// Controller keys are in the form <kube namespace>/<kube svc name>
+	// e.g. default/foo, and are the keys Kube uses to inform that something
+	// changed. We lowercase the Consul service names and DNS entries
 	// because Kube names must be lowercase.
 	sourceServices map[string]string
 
-	// keyToName maps from Kube controller keys to Kube service names.
-	// Controller keys are in the form <kube namespace>/<kube svc name>
-	// e.g. default/foo, and are the keys Kube uses to inform that something
-	// changed.
-	keyToName map[string]string
+	// sourceMetadata holds the metadata of the Consul services, keyed by
+	// the same keys as sourceServices. It's nil if metadata isn't synced,
+	// in which case the annotations are left alone.
+	sourceMetadata map[string]ServiceMetadata
 
-	// serviceMap holds all Kubernetes service names in the namespaces
-	// we're watching. The keys are Kubernetes service names and there are no
-	// values.
+	// serviceMap holds the controller keys of all Kubernetes services in the
+	// namespaces we're watching. There are no values.
 	serviceMap map[string]struct{}
 
 	// serviceMapConsul is a subset of serviceMap. It holds all Kube services
-	// that were created by this sync process. Keys are Kube service names.
+	// that were created by this sync process, keyed by controller key.
 	// It's populated from Kubernetes data.
 	serviceMapConsul map[string]*apiv1.Service
 	triggerCh        chan struct{}
 	readyCh          chan struct{}
+
+	// namespaces are the namespaces that services were synced into and so
+	// either existed or were created. It's only used by Run.
+	namespaces map[string]bool
 }
 
 // SetServices implements Sink
@@ -93,24 +137,37 @@ func (s *K8SSink) SetServices(svcs map[string]string) {
 	// but different cases, and so svcs will be unique even after lowercasing.
 	lowercasedSvcs := make(map[string]string)
 	for consulName, consulDNS := range svcs {
-		lowercasedSvcs[strings.ToLower(consulName)] = strings.ToLower(consulDNS)
+		lowercasedSvcs[s.key(consulName)] = strings.ToLower(consulDNS)
 	}
 
 	s.sourceServices = lowercasedSvcs
 	s.trigger() // Any service change probably requires syncing
 }
 
+// SetServiceMetadata implements Sink
+func (s *K8SSink) SetServiceMetadata(metadata map[string]ServiceMetadata) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The services are lowercased in SetServices, which triggers the sync.
+	lowercased := make(map[string]ServiceMetadata, len(metadata))
+	for consulName, md := range metadata {
+		lowercased[s.key(consulName)] = md
+	}
+	s.sourceMetadata = lowercased
+}
+
 // Informer implements the controller.Resource interface.
 // It tells Kubernetes that we want to watch for changes to Services.
 func (s *K8SSink) Informer() cache.SharedIndexInformer {
 	return cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return s.Client.CoreV1().Services(s.namespace()).List(context.TODO(), options)
+				return s.Client.CoreV1().Services(s.watchNamespace()).List(context.TODO(), options)
 			},
 
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				return s.Client.CoreV1().Services(s.namespace()).Watch(context.TODO(), options)
+				return s.Client.CoreV1().Services(s.watchNamespace()).Watch(context.TODO(), options)
 			},
 		},
 		&apiv1.Service{},
@@ -131,17 +188,10 @@ func (s *K8SSink) Upsert(key string, raw interface{}) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	// Store all the key to name mappings. We need this because the key
-	// is opaque but we want to do all the lookups by service name.
-	if s.keyToName == nil {
-		s.keyToName = make(map[string]string)
-	}
-	s.keyToName[key] = service.Name
-
 	if s.serviceMap == nil {
 		s.serviceMap = make(map[string]struct{})
 	}
-	s.serviceMap[service.Name] = struct{}{}
+	s.serviceMap[key] = struct{}{}
 
 	// If the service is a Consul-sourced service, then keep track of it
 	// separately for a quick lookup.
@@ -150,7 +200,7 @@ func (s *K8SSink) Upsert(key string, raw interface{}) error {
 			s.serviceMapConsul = make(map[string]*apiv1.Service)
 		}
 
-		s.serviceMapConsul[service.Name] = service
+		s.serviceMapConsul[key] = service
 		s.trigger() // Always trigger sync
 	}
 
@@ -163,8 +213,7 @@ func (s *K8SSink) Delete(key string, _ interface{}) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	name, ok := s.keyToName[key]
-	if !ok {
+	if _, ok := s.serviceMap[key]; !ok {
 		// This is a weird scenario, but in unit tests we've seen this happen
 		// in cases where the delete happens very quickly after the create.
 		// Just to be sure, lets trigger a sync. This is cheap cause it'll
@@ -173,17 +222,16 @@ func (s *K8SSink) Delete(key string, _ interface{}) error {
 		return nil
 	}
 
-	delete(s.keyToName, key)
-	delete(s.serviceMap, name)
-	delete(s.serviceMapConsul, name)
+	delete(s.serviceMap, key)
+	delete(s.serviceMapConsul, key)
 
 	// If the service that is deleted is part of Consul services, then
 	// we need to trigger a sync to recreate it.
-	if _, ok := s.sourceServices[name]; ok {
+	if _, ok := s.sourceServices[key]; ok {
 		s.trigger()
 	}
 
-	s.Log.Info("delete", "key", key, "name", name)
+	s.Log.Info("delete", "key", key)
 	return nil
 }
 
@@ -218,52 +266,133 @@ func (s *K8SSink) Run(ch <-chan struct{}) {
 				})
 		}
 
+		start := time.Now()
 		s.lock.Lock()
 		create, update, delete := s.crudList()
+		synced := map[string]int{s.namespace(): 0}
+		for key := range s.sourceServices {
+			ns, _, _ := cache.SplitMetaNamespaceKey(key)
+			synced[ns]++
+		}
 		s.lock.Unlock()
 		s.Log.Debug("sync triggered", "create", len(create), "update", len(update), "delete", len(delete))
+		if s.DryRun != nil {
+			s.plan(create, update, delete)
+			continue
+		}
 
-		svcClient := s.Client.CoreV1().Services(s.namespace())
-		for _, name := range delete {
-			if err := svcClient.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
-				s.Log.Warn("error deleting service", "name", name, "error", err)
+		failures := make(map[string]int)
+		for _, key := range delete {
+			ns, name, _ := cache.SplitMetaNamespaceKey(key)
+			if err := s.Client.CoreV1().Services(ns).Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+				s.Log.Warn("error deleting service", "name", name, "namespace", ns, "error", err)
+				failures[ns]++
 			}
 		}
 
 		for _, svc := range update {
-			_, err := svcClient.Update(context.TODO(), svc, metav1.UpdateOptions{})
+			_, err := s.Client.CoreV1().Services(svc.Namespace).Update(context.TODO(), svc, metav1.UpdateOptions{})
 			if err != nil {
-				s.Log.Warn("error updating service", "name", svc.Name, "error", err)
+				s.Log.Warn("error updating service", "name", svc.Name, "namespace", svc.Namespace, "error", err)
+				failures[svc.Namespace]++
 			}
 		}
 
 		for _, svc := range create {
-			_, err := svcClient.Create(context.TODO(), svc, metav1.CreateOptions{})
+			if err := s.ensureNamespace(svc.Namespace); err != nil {
+				s.Log.Warn("error creating namespace", "namespace", svc.Namespace, "error", err)
+				failures[svc.Namespace]++
+				continue
+			}
+			_, err := s.Client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
 			if err != nil {
-				s.Log.Warn("error creating service", "name", svc.Name, "error", err)
+				s.Log.Warn("error creating service", "name", svc.Name, "namespace", svc.Namespace, "error", err)
+				failures[svc.Namespace]++
 			}
 		}
+
+		s.Metrics.RecordSync(start, synced, failures)
 	}
 }
 
+// plan records the services that a sync would create, update and delete
+// on the dry-run plan.
+func (s *K8SSink) plan(create, update []*apiv1.Service, delete []string) {
+	var changes []dryrun.K8SChange
+	for _, key := range delete {
+		ns, name, _ := cache.SplitMetaNamespaceKey(key)
+		s.Log.Info("[dry-run] would delete service", "name", name, "namespace", ns)
+		changes = append(changes, dryrun.K8SChange{
+			Operation: dryrun.OperationDelete,
+			Namespace: ns,
+			Name:      name,
+		})
+	}
+	for _, svc := range update {
+		s.Log.Info("[dry-run] would update service", "name", svc.Name, "namespace", svc.Namespace,
+			"external-name", svc.Spec.ExternalName)
+		changes = append(changes, dryrun.K8SChange{
+			Operation:    dryrun.OperationUpdate,
+			Namespace:    svc.Namespace,
+			Name:         svc.Name,
+			ExternalName: svc.Spec.ExternalName,
+		})
+	}
+	for _, svc := range create {
+		s.Log.Info("[dry-run] would create service", "name", svc.Name, "namespace", svc.Namespace,
+			"external-name", svc.Spec.ExternalName)
+		changes = append(changes, dryrun.K8SChange{
+			Operation:    dryrun.OperationCreate,
+			Namespace:    svc.Namespace,
+			Name:         svc.Name,
+			ExternalName: svc.Spec.ExternalName,
+		})
+	}
+	s.DryRun.SetK8SChanges(changes)
+}
+
 // crudList returns the services to create, update, and delete (respectively).
 func (s *K8SSink) crudList() ([]*apiv1.Service, []*apiv1.Service, []string) {
 	var create, update []*apiv1.Service
 	var delete []string
 
 	// Determine what needs to be created or updated
-	for consulName, consulDNS := range s.sourceServices {
+	for key, consulDNS := range s.sourceServices {
+		var metadata map[string]string
+		if s.sourceMetadata != nil {
+			metadata = s.metadataAnnotations(key)
+		}
+
 		// If this is an already registered service, then update it
 		if s.serviceMapConsul != nil {
-			if svc, ok := s.serviceMapConsul[consulName]; ok {
-				if svc.Spec.ExternalName == consulDNS {
+			if svc, ok := s.serviceMapConsul[key]; ok {
+				metadataSynced := s.sourceMetadata == nil ||
+					reflect.DeepEqual(currentMetadataAnnotations(svc), metadata)
+				if svc.Spec.ExternalName == consulDNS && metadataSynced {
 					// Matching service, no update required.
 					continue
 				}
 
-				svc.Spec = apiv1.ServiceSpec{
-					Type:         apiv1.ServiceTypeExternalName,
-					ExternalName: consulDNS,
+				// The service is copied so that the one we track isn't
+				// changed until the update is made and watched.
+				svc = svc.DeepCopy()
+				if svc.Spec.ExternalName != consulDNS {
+					svc.Spec = apiv1.ServiceSpec{
+						Type:         apiv1.ServiceTypeExternalName,
+						ExternalName: consulDNS,
+					}
+				}
+				if !metadataSynced {
+					annotations := make(map[string]string, len(svc.Annotations))
+					for k, v := range svc.Annotations {
+						if !isMetadataAnnotation(k) {
+							annotations[k] = v
+						}
+					}
+					for k, v := range metadata {
+						annotations[k] = v
+					}
+					svc.Annotations = annotations
 				}
 
 				update = append(update, svc)
@@ -272,20 +401,26 @@ func (s *K8SSink) crudList() ([]*apiv1.Service, []*apiv1.Service, []string) {
 		}
 
 		// If this is a registered K8S service, ignore.
-		if _, ok := s.serviceMap[consulName]; ok {
-			s.Log.Warn("service already registered in K8S, not registering", "name", consulName)
+		ns, name, _ := cache.SplitMetaNamespaceKey(key)
+		if _, ok := s.serviceMap[key]; ok {
+			s.Log.Warn("service already registered in K8S, not registering", "name", name, "namespace", ns)
 			continue
 		}
 
 		// Register!
+		annotations := map[string]string{
+			// Ensure we don't sync the service back to Consul
+			"consul.hashicorp.com/service-sync": "false",
+		}
+		for k, v := range metadata {
+			annotations[k] = v
+		}
 		create = append(create, &apiv1.Service{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:   consulName,
-				Labels: map[string]string{"consul": "true"},
-				Annotations: map[string]string{
-					// Ensure we don't sync the service back to Consul
-					"consul.hashicorp.com/service-sync": "false",
-				},
+				Name:        name,
+				Namespace:   ns,
+				Labels:      map[string]string{"consul": "true"},
+				Annotations: annotations,
 			},
 
 			Spec: apiv1.ServiceSpec{
@@ -305,6 +440,54 @@ func (s *K8SSink) crudList() ([]*apiv1.Service, []*apiv1.Service, []string) {
 	return create, update, delete
 }
 
+// metadataAnnotations returns the annotations to set on the Kubernetes
+// service for the metadata of the Consul service with the controller key
+// name. Meta keys that don't make valid annotation keys are skipped. lock
+// must be held.
+func (s *K8SSink) metadataAnnotations(name string) map[string]string {
+	md := s.sourceMetadata[name]
+	annotations := make(map[string]string, len(md.Meta)+1)
+	var tags []string
+	for _, tag := range md.Tags {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > 0 {
+		// Consul doesn't order tags so they're sorted so that the
+		// annotation only changes when the tags do.
+		sort.Strings(tags)
+		annotations[annotationServiceTags] = strings.Join(tags, ",")
+	}
+	for k, v := range md.Meta {
+		key := annotationServiceMetaPrefix + k
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			s.Log.Debug("skipping meta that isn't a valid annotation",
+				"name", name, "key", k, "errors", strings.Join(errs, ", "))
+			continue
+		}
+		annotations[key] = v
+	}
+	return annotations
+}
+
+// currentMetadataAnnotations returns the metadata annotations set on svc.
+func currentMetadataAnnotations(svc *apiv1.Service) map[string]string {
+	annotations := make(map[string]string)
+	for k, v := range svc.Annotations {
+		if isMetadataAnnotation(k) {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// isMetadataAnnotation returns true if key is set from the metadata of the
+// Consul service.
+func isMetadataAnnotation(key string) bool {
+	return key == annotationServiceTags || strings.HasPrefix(key, annotationServiceMetaPrefix)
+}
+
 // namespace returns the K8S namespace to setup the resource watchers in.
 func (s *K8SSink) namespace() string {
 	if s.Namespace != "" {
@@ -316,6 +499,49 @@ func (s *K8SSink) namespace() string {
 	return metav1.NamespaceDefault
 }
 
+// watchNamespace returns the K8S namespace to watch services in.
+func (s *K8SSink) watchNamespace() string {
+	if s.AllNamespaces {
+		return metav1.NamespaceAll
+	}
+	return s.namespace()
+}
+
+// key returns the lowercased controller key of the service with the key
+// consulName in SetServices.
+func (s *K8SSink) key(consulName string) string {
+	consulName = strings.ToLower(consulName)
+	if !strings.Contains(consulName, "/") {
+		return s.namespace() + "/" + consulName
+	}
+	return consulName
+}
+
+// ensureNamespace creates the namespace ns if it doesn't exist.
+func (s *K8SSink) ensureNamespace(ns string) error {
+	if ns == s.namespace() || s.namespaces[ns] {
+		return nil
+	}
+	_, err := s.Client.CoreV1().Namespaces().Get(context.TODO(), ns, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		s.Log.Info("creating namespace", "namespace", ns)
+		_, err = s.Client.CoreV1().Namespaces().Create(context.TODO(), &apiv1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: ns},
+		}, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if s.namespaces == nil {
+		s.namespaces = make(map[string]bool)
+	}
+	s.namespaces[ns] = true
+	return nil
+}
+
 // trigger will notify a sync should occur. lock must be held.
 //
 // This is not synchronous and does not guarantee a sync will happen. This
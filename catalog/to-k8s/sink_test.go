@@ -2,16 +2,24 @@ package catalog
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"testing"
 
+	"github.com/hashicorp/consul-k8s/catalog/dryrun"
+	"github.com/hashicorp/consul-k8s/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/helper/controller"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func init() {
@@ -285,6 +293,64 @@ func TestK8SSink_updateService(t *testing.T) {
 	})
 }
 
+// Test that the metadata of the service is set as annotations and that they
+// are updated when it changes.
+func TestK8SSink_metadata(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+
+	// Start the controller
+	sink, closer := testSink(t, client)
+	defer closer()
+
+	waitForAnnotations := func(expected map[string]string) {
+		retry.Run(t, func(r *retry.R) {
+			svc, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(context.Background(), "web", metav1.GetOptions{})
+			if err != nil {
+				r.Fatalf("err: %s", err)
+			}
+			if !reflect.DeepEqual(expected, svc.Annotations) {
+				r.Fatalf("expected annotations %v, got %v", expected, svc.Annotations)
+			}
+		})
+	}
+
+	// Set a service with metadata
+	sink.SetServiceMetadata(map[string]ServiceMetadata{
+		"WEB": {
+			Tags: []string{"v2", "primary"},
+			Meta: map[string]string{
+				"version": "2",
+				// Not a valid annotation key so it isn't synced.
+				"this-meta-key-is-too-long-to-be-the-name-of-an-annotation": "x",
+			},
+		},
+	})
+	sink.SetServices(map[string]string{"WEB": "web.service.local."})
+	waitForAnnotations(map[string]string{
+		"consul.hashicorp.com/service-sync":         "false",
+		"consul.hashicorp.com/service-tags":         "primary,v2",
+		"consul.hashicorp.com/service-meta-version": "2",
+	})
+
+	// Annotations set by others are kept when the metadata changes.
+	svc, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(context.Background(), "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	svc.Annotations["example.com/owner"] = "mesh"
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Update(context.Background(), svc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	sink.SetServiceMetadata(map[string]ServiceMetadata{
+		"web": {Meta: map[string]string{"team": "mesh"}},
+	})
+	sink.SetServices(map[string]string{"web": "web.service.local."})
+	waitForAnnotations(map[string]string{
+		"consul.hashicorp.com/service-sync":      "false",
+		"consul.hashicorp.com/service-meta-team": "mesh",
+		"example.com/owner":                      "mesh",
+	})
+}
+
 // Test that if the service is deleted remotely, it is recreated
 func TestK8SSink_deleteReconcileRemote(t *testing.T) {
 	t.Parallel()
@@ -394,6 +460,148 @@ func TestK8SSink_deleteReconcileLocal(t *testing.T) {
 	})
 }
 
+// Test that services are synced into the namespace of their key, which is
+// created, if AllNamespaces is true.
+func TestK8SSink_allNamespaces(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	sink := &K8SSink{
+		Client:        client,
+		Log:           hclog.Default(),
+		AllNamespaces: true,
+	}
+	closer := controller.TestControllerRun(sink)
+	defer closer()
+
+	sink.SetServices(map[string]string{
+		"web":           "web.service.local.",
+		"Team-A/api":    "api.service.team-a.dc1.local.",
+		"default/admin": "admin.service.local.",
+	})
+	retry.Run(t, func(r *retry.R) {
+		for _, svc := range []struct{ namespace, name, externalName string }{
+			{metav1.NamespaceDefault, "web", "web.service.local."},
+			{metav1.NamespaceDefault, "admin", "admin.service.local."},
+			{"team-a", "api", "api.service.team-a.dc1.local."},
+		} {
+			actual, err := client.CoreV1().Services(svc.namespace).Get(context.Background(), svc.name, metav1.GetOptions{})
+			if err != nil {
+				r.Fatalf("err: %s", err)
+			}
+			if actual.Spec.ExternalName != svc.externalName {
+				r.Fatalf("unexpected external name of %s/%s: %s", svc.namespace, svc.name, actual.Spec.ExternalName)
+			}
+		}
+	})
+	_, err := client.CoreV1().Namespaces().Get(context.Background(), "team-a", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// Services removed from Consul are deleted from their namespace.
+	sink.SetServices(map[string]string{"web": "web.service.local."})
+	retry.Run(t, func(r *retry.R) {
+		list, err := client.CoreV1().Services("team-a").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			r.Fatalf("err: %s", err)
+		}
+		if len(list.Items) != 0 {
+			r.Fatalf("expected no services in team-a, got %d", len(list.Items))
+		}
+	})
+}
+
+// Test that services that fail to be created are counted as sync errors.
+func TestK8SSink_createErrorMetrics(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("create failed")
+	})
+	syncMetrics, err := metrics.NewSyncMetrics(prometheus.NewRegistry(), metrics.DirectionToK8S)
+	require.NoError(t, err)
+
+	sink := &K8SSink{
+		Client:  client,
+		Log:     hclog.Default(),
+		Metrics: syncMetrics,
+	}
+	closer := controller.TestControllerRun(sink)
+	defer closer()
+	sink.SetServices(map[string]string{"web": "web.service.local."})
+
+	retry.Run(t, func(r *retry.R) {
+		if v := testutil.ToFloat64(syncMetrics.SyncErrors.WithLabelValues(metav1.NamespaceDefault)); v < 1 {
+			r.Fatalf("expected sync errors, got %v", v)
+		}
+	})
+}
+
+// Test that in dry-run mode the services are planned but not written.
+func TestK8SSink_dryRun(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+
+	// A previously synced service that's now out of date and one that's
+	// no longer in Consul.
+	for name, externalName := range map[string]string{"web": "old.service.local.", "gone": "gone.service.local."} {
+		_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), &apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"consul": "true"},
+			},
+			Spec: apiv1.ServiceSpec{
+				Type:         apiv1.ServiceTypeExternalName,
+				ExternalName: externalName,
+			},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	plan := &dryrun.Plan{}
+	sink := &K8SSink{
+		Client: client,
+		Log:    hclog.Default(),
+		DryRun: plan,
+	}
+	closer := controller.TestControllerRun(sink)
+	defer closer()
+	sink.SetServices(map[string]string{
+		"web": "web.service.local.",
+		"api": "api.service.local.",
+	})
+
+	retry.Run(t, func(r *retry.R) {
+		changes, ok := plan.K8SChanges()
+		require.True(r, ok)
+		require.Equal(r, []dryrun.K8SChange{
+			{
+				Operation:    dryrun.OperationCreate,
+				Namespace:    metav1.NamespaceDefault,
+				Name:         "api",
+				ExternalName: "api.service.local.",
+			},
+			{
+				Operation: dryrun.OperationDelete,
+				Namespace: metav1.NamespaceDefault,
+				Name:      "gone",
+			},
+			{
+				Operation:    dryrun.OperationUpdate,
+				Namespace:    metav1.NamespaceDefault,
+				Name:         "web",
+				ExternalName: "web.service.local.",
+			},
+		}, changes)
+	})
+
+	// Nothing was written.
+	list, err := client.CoreV1().Services(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 2)
+	for _, svc := range list.Items {
+		require.NotEqual(t, "web.service.local.", svc.Spec.ExternalName)
+	}
+}
+
 func testSink(t *testing.T, client kubernetes.Interface) (*K8SSink, func()) {
 	sink := &K8SSink{
 		Client: client,
@@ -3,9 +3,12 @@ package catalog
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/catalog/metrics"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 )
@@ -19,16 +22,161 @@ type Source struct {
 	Prefix       string       // Prefix is a prefix to prepend to services
 	Log          hclog.Logger // Logger
 	ConsulK8STag string       // The tag value for services registered
+
+	// SyncMetadata, if true, also passes the tags and meta of the services
+	// to the Sink. The meta requires one more query per service.
+	SyncMetadata bool
+
+	// Metrics count the failed Consul API requests. Optional.
+	Metrics *metrics.SyncMetrics
+
+	// EnableNamespaces indicates that a user is running Consul Enterprise
+	// with version 1.7+ which is namespace aware. If true, the services of
+	// ConsulSourceNamespace are synced.
+	EnableNamespaces bool
+
+	// ConsulSourceNamespace is the Consul namespace whose services are
+	// synced, or WildcardNamespace to sync the services of all namespaces.
+	// Services with the same name in different namespaces are only synced
+	// once unless EnableConsulNSMirroring is true.
+	ConsulSourceNamespace string
+
+	// EnableConsulNSMirroring, if true, syncs the services of each Consul
+	// namespace into the Kubernetes namespace of the same name prepended
+	// with ConsulNSMirroringPrefix instead of the Sink's namespace. The
+	// services are passed to the Sink with keys of the form
+	// <namespace>/<name>.
+	EnableConsulNSMirroring bool
+	ConsulNSMirroringPrefix string
+
+	// lock guards datacenter and namespaceServices.
+	lock sync.Mutex
+
+	// datacenter is the datacenter of the agent, which is part of the DNS
+	// names of the services outside of the default namespace.
+	datacenter string
+
+	// namespaceServices are the services to sync in each Consul namespace.
+	namespaceServices map[string]namespaceServices
 }
 
+// namespaceServices are the services of a Consul namespace that aren't
+// synced from Kubernetes.
+type namespaceServices struct {
+	// tags maps the services to their tags.
+	tags map[string][]string
+	// metadata maps the services to their metadata, if SyncMetadata is true.
+	metadata map[string]ServiceMetadata
+}
+
+// WildcardNamespace is the ConsulSourceNamespace that syncs the services of
+// all namespaces.
+const WildcardNamespace = "*"
+
 // Run is the long-running runloop for watching Consul services and
 // updating the Sink.
 func (s *Source) Run(ctx context.Context) {
+	if !s.EnableNamespaces {
+		s.watchServices(ctx, "")
+		return
+	}
+
+	err := backoff.Retry(func() error {
+		self, err := s.Client.Agent().Self()
+		if err != nil {
+			return err
+		}
+		datacenter, ok := self["Config"]["Datacenter"].(string)
+		if !ok {
+			return fmt.Errorf("agent has no datacenter")
+		}
+		s.lock.Lock()
+		s.datacenter = datacenter
+		s.lock.Unlock()
+		return nil
+	}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
+	if err != nil {
+		// The context ended.
+		return
+	}
+
+	if s.ConsulSourceNamespace == WildcardNamespace {
+		s.watchNamespaces(ctx)
+	} else {
+		s.watchServices(ctx, s.ConsulSourceNamespace)
+	}
+}
+
+// watchNamespaces watches the Consul namespaces and the services of each of
+// them until ctx ends.
+func (s *Source) watchNamespaces(ctx context.Context) {
 	opts := (&api.QueryOptions{
 		AllowStale: true,
 		WaitIndex:  1,
 		WaitTime:   1 * time.Minute,
 	}).WithContext(ctx)
+	cancels := make(map[string]context.CancelFunc)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var namespaces []*api.Namespace
+		var meta *api.QueryMeta
+		err := backoff.Retry(func() error {
+			var err error
+			namespaces, meta, err = s.Client.Namespaces().List(opts)
+			if err != nil && ctx.Err() == nil {
+				s.Metrics.ConsulAPIError(metrics.OperationNamespace)
+			}
+			return err
+		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.Log.Warn("error querying namespaces, will retry", "err", err)
+			continue
+		}
+		opts.WaitIndex = meta.LastIndex
+
+		existing := make(map[string]bool, len(namespaces))
+		for _, ns := range namespaces {
+			existing[ns.Name] = true
+			if _, ok := cancels[ns.Name]; ok {
+				continue
+			}
+			s.Log.Info("watching services of namespace", "namespace", ns.Name)
+			nsCtx, cancel := context.WithCancel(ctx)
+			cancels[ns.Name] = cancel
+			wg.Add(1)
+			go func(ns string) {
+				defer wg.Done()
+				s.watchServices(nsCtx, ns)
+			}(ns.Name)
+		}
+		for ns, cancel := range cancels {
+			if existing[ns] {
+				continue
+			}
+			s.Log.Info("namespace deleted, removing its services", "namespace", ns)
+			cancel()
+			delete(cancels, ns)
+			s.lock.Lock()
+			delete(s.namespaceServices, ns)
+			s.updateSinkLocked()
+			s.lock.Unlock()
+		}
+	}
+}
+
+// watchServices watches the services of the Consul namespace ns, which is
+// empty if namespaces aren't enabled, and updates the Sink until ctx ends.
+func (s *Source) watchServices(ctx context.Context, ns string) {
+	opts := (&api.QueryOptions{
+		AllowStale: true,
+		WaitIndex:  1,
+		WaitTime:   1 * time.Minute,
+		Namespace:  ns,
+	}).WithContext(ctx)
 	for {
 		// Get all services with tags.
 		var serviceMap map[string][]string
@@ -36,6 +184,9 @@ func (s *Source) Run(ctx context.Context) {
 		err := backoff.Retry(func() error {
 			var err error
 			serviceMap, meta, err = s.Client.Catalog().Services(opts)
+			if err != nil && ctx.Err() == nil {
+				s.Metrics.ConsulAPIError(metrics.OperationListServices)
+			}
 			return err
 		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 
@@ -50,11 +201,8 @@ func (s *Source) Run(ctx context.Context) {
 			continue
 		}
 
-		// Update our blocking index
-		opts.WaitIndex = meta.LastIndex
-
 		// Setup the services
-		services := make(map[string]string, len(serviceMap))
+		synced := make(map[string][]string, len(serviceMap))
 		for name, tags := range serviceMap {
 			// We ignore services that are synced from k8s so we can avoid
 			// circular syncing. Realistically this shouldn't happen since
@@ -69,11 +217,127 @@ func (s *Source) Run(ctx context.Context) {
 			}
 
 			if !k8s {
-				services[s.Prefix+name] = fmt.Sprintf("%s.service.%s", name, s.Domain)
+				synced[name] = tags
+			}
+		}
+		s.Log.Info("received services from Consul", "count", len(synced), "namespace", ns)
+
+		var metadata map[string]ServiceMetadata
+		if s.SyncMetadata {
+			err := backoff.Retry(func() error {
+				var err error
+				metadata, err = s.serviceMetadata(ctx, ns, synced)
+				return err
+			}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
+			if ctx.Err() != nil {
+				return
+			}
+			// The blocking index isn't updated so that the services are
+			// queried again right away.
+			if err != nil {
+				s.Log.Warn("error querying service meta, will retry", "err", err)
+				continue
 			}
 		}
-		s.Log.Info("received services from Consul", "count", len(services))
 
-		s.Sink.SetServices(services)
+		// Update our blocking index
+		opts.WaitIndex = meta.LastIndex
+
+		s.lock.Lock()
+		// The namespace may have been deleted while its services were
+		// queried.
+		if ctx.Err() == nil {
+			if s.namespaceServices == nil {
+				s.namespaceServices = make(map[string]namespaceServices)
+			}
+			s.namespaceServices[ns] = namespaceServices{tags: synced, metadata: metadata}
+			s.updateSinkLocked()
+		}
+		s.lock.Unlock()
+	}
+}
+
+// updateSinkLocked sets the services of all the namespaces in the Sink.
+//
+// Precondition: lock must be held
+func (s *Source) updateSinkLocked() {
+	namespaces := make([]string, 0, len(s.namespaceServices))
+	for ns := range s.namespaceServices {
+		namespaces = append(namespaces, ns)
+	}
+	// The namespaces are sorted so that the same service is synced if
+	// several namespaces have a service of the same name.
+	sort.Strings(namespaces)
+
+	services := make(map[string]string)
+	metadata := make(map[string]ServiceMetadata)
+	for _, ns := range namespaces {
+		for name := range s.namespaceServices[ns].tags {
+			key := s.Prefix + name
+			if s.EnableNamespaces && s.EnableConsulNSMirroring {
+				key = s.ConsulNSMirroringPrefix + ns + "/" + key
+			}
+			if _, ok := services[key]; ok {
+				s.Log.Warn("service already synced from another namespace, not syncing",
+					"name", name, "namespace", ns)
+				continue
+			}
+			services[key] = s.dnsName(ns, name)
+			if md, ok := s.namespaceServices[ns].metadata[name]; ok {
+				metadata[key] = md
+			}
+		}
+	}
+
+	if s.SyncMetadata {
+		s.Sink.SetServiceMetadata(metadata)
+	}
+	s.Sink.SetServices(services)
+}
+
+// dnsName returns the Consul DNS name of the service name in the namespace
+// ns. The namespace and datacenter must both be in the name for services
+// outside of the default namespace.
+//
+// Precondition: lock must be held
+func (s *Source) dnsName(ns, name string) string {
+	if ns == "" || ns == "default" {
+		return fmt.Sprintf("%s.service.%s", name, s.Domain)
+	}
+	return fmt.Sprintf("%s.service.%s.%s.%s", name, ns, s.datacenter, s.Domain)
+}
+
+// serviceMetadata returns the metadata of the services in serviceMap, which
+// maps the names of the Consul services of the namespace ns to their tags.
+// The meta of a service is the meta that all of its instances have in
+// common.
+func (s *Source) serviceMetadata(ctx context.Context, ns string, serviceMap map[string][]string) (map[string]ServiceMetadata, error) {
+	opts := (&api.QueryOptions{AllowStale: true, Namespace: ns}).WithContext(ctx)
+	metadata := make(map[string]ServiceMetadata, len(serviceMap))
+	for name, tags := range serviceMap {
+		instances, _, err := s.Client.Catalog().Service(name, "", opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.Metrics.ConsulAPIError(metrics.OperationListServices)
+			}
+			return nil, err
+		}
+		var meta map[string]string
+		for i, instance := range instances {
+			if i == 0 {
+				meta = make(map[string]string, len(instance.ServiceMeta))
+				for k, v := range instance.ServiceMeta {
+					meta[k] = v
+				}
+				continue
+			}
+			for k, v := range meta {
+				if instance.ServiceMeta[k] != v {
+					delete(meta, k)
+				}
+			}
+		}
+		metadata[name] = ServiceMetadata{Tags: tags, Meta: meta}
 	}
+	return metadata, nil
 }
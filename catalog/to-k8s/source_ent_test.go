@@ -0,0 +1,74 @@
+// +build enterprise
+
+package catalog
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the services of all Consul namespaces are synced and that the
+// services of deleted namespaces are removed.
+func TestSource_ConsulNamespaces(t *testing.T) {
+	t.Parallel()
+
+	a, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.Datacenter = "dc1"
+	})
+	require.NoError(t, err)
+	defer a.Stop()
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	_, _, err = client.Namespaces().Create(&api.Namespace{Name: "foo"}, nil)
+	require.NoError(t, err)
+	reg := testRegistration("hostA", "svcA", nil)
+	_, err = client.Catalog().Register(reg, nil)
+	require.NoError(t, err)
+	reg = testRegistration("hostA", "svcB", nil)
+	reg.Service.Namespace = "foo"
+	_, err = client.Catalog().Register(reg, nil)
+	require.NoError(t, err)
+
+	_, sink, closer := testSourceWithConfig(client, func(s *Source) {
+		s.EnableNamespaces = true
+		s.ConsulSourceNamespace = WildcardNamespace
+		s.EnableConsulNSMirroring = true
+	})
+	defer closer()
+
+	retry.Run(t, func(r *retry.R) {
+		sink.Lock()
+		defer sink.Unlock()
+		expected := map[string]string{
+			"default/consul": "consul.service.test",
+			"default/svcA":   "svcA.service.test",
+			"foo/svcB":       "svcB.service.foo.dc1.test",
+		}
+		if len(sink.Services) != len(expected) {
+			r.Fatalf("unexpected services: %v", sink.Services)
+		}
+		for k, v := range expected {
+			if sink.Services[k] != v {
+				r.Fatalf("unexpected services: %v", sink.Services)
+			}
+		}
+	})
+
+	_, err = client.Namespaces().Delete("foo", nil)
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		sink.Lock()
+		defer sink.Unlock()
+		if _, ok := sink.Services["foo/svcB"]; ok {
+			r.Fatalf("services of the deleted namespace are still synced: %v", sink.Services)
+		}
+	})
+}
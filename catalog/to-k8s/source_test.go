@@ -3,6 +3,7 @@ package catalog
 import (
 	"context"
 	"reflect"
+	"sort"
 	"testing"
 
 	toconsul "github.com/hashicorp/consul-k8s/catalog/to-consul"
@@ -259,6 +260,125 @@ func TestSource_deleteServiceInstance(t *testing.T) {
 	})
 }
 
+// Test that the tags and the meta shared by all instances are synced.
+func TestSource_metadata(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	// Set up server, client
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(err)
+	defer a.Stop()
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(err)
+
+	regA := testRegistration("hostA", "svcA", []string{"v1"})
+	regA.Service.Meta = map[string]string{"version": "1", "team": "mesh"}
+	_, err = client.Catalog().Register(regA, nil)
+	require.NoError(err)
+	regB := testRegistration("hostB", "svcA", []string{"v2"})
+	regB.Service.Meta = map[string]string{"version": "2", "team": "mesh"}
+	_, err = client.Catalog().Register(regB, nil)
+	require.NoError(err)
+
+	_, sink, closer := testSourceWithConfig(client, func(s *Source) {
+		s.Prefix = "foo-"
+		s.SyncMetadata = true
+	})
+	defer closer()
+
+	retry.Run(t, func(r *retry.R) {
+		sink.Lock()
+		defer sink.Unlock()
+		md, ok := sink.Metadata["foo-svcA"]
+		if !ok {
+			r.Fatal("metadata not found")
+		}
+		tags := append([]string{}, md.Tags...)
+		sort.Strings(tags)
+		if !reflect.DeepEqual([]string{"v1", "v2"}, tags) {
+			r.Fatalf("unexpected tags: %v", md.Tags)
+		}
+		if !reflect.DeepEqual(map[string]string{"team": "mesh"}, md.Meta) {
+			r.Fatalf("unexpected meta: %v", md.Meta)
+		}
+	})
+
+	// Meta changes are synced.
+	regB.Service.Meta = map[string]string{"version": "1", "team": "mesh"}
+	_, err = client.Catalog().Register(regB, nil)
+	require.NoError(err)
+	retry.Run(t, func(r *retry.R) {
+		sink.Lock()
+		defer sink.Unlock()
+		if meta := sink.Metadata["foo-svcA"].Meta; meta["version"] != "1" {
+			r.Fatalf("meta not updated: %v", meta)
+		}
+	})
+}
+
+// Test that the services of several Consul namespaces are flattened into
+// the Sink's namespace or mirrored into their own.
+func TestSource_updateSinkNamespaces(t *testing.T) {
+	t.Parallel()
+	namespaces := map[string]namespaceServices{
+		"default": {tags: map[string][]string{"svcA": nil}},
+		"foo": {
+			tags:     map[string][]string{"svcA": nil, "svcB": {"v1"}},
+			metadata: map[string]ServiceMetadata{"svcB": {Tags: []string{"v1"}}},
+		},
+	}
+	cases := map[string]struct {
+		mirroring   bool
+		expServices map[string]string
+		expMetadata map[string]ServiceMetadata
+	}{
+		"flattened": {
+			mirroring: false,
+			// svcA of the foo namespace isn't synced since svcA of the
+			// default namespace is.
+			expServices: map[string]string{
+				"pre-svcA": "svcA.service.test",
+				"pre-svcB": "svcB.service.foo.dc1.test",
+			},
+			expMetadata: map[string]ServiceMetadata{"pre-svcB": {Tags: []string{"v1"}}},
+		},
+		"mirrored": {
+			mirroring: true,
+			expServices: map[string]string{
+				"k8s-default/pre-svcA": "svcA.service.test",
+				"k8s-foo/pre-svcA":     "svcA.service.foo.dc1.test",
+				"k8s-foo/pre-svcB":     "svcB.service.foo.dc1.test",
+			},
+			expMetadata: map[string]ServiceMetadata{"k8s-foo/pre-svcB": {Tags: []string{"v1"}}},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			sink := &TestSink{}
+			s := &Source{
+				Domain:                  "test",
+				Sink:                    sink,
+				Prefix:                  "pre-",
+				Log:                     hclog.Default(),
+				SyncMetadata:            true,
+				EnableNamespaces:        true,
+				ConsulSourceNamespace:   WildcardNamespace,
+				EnableConsulNSMirroring: c.mirroring,
+				ConsulNSMirroringPrefix: "k8s-",
+				datacenter:              "dc1",
+				namespaceServices:       namespaces,
+			}
+			s.updateSinkLocked()
+			require.Equal(t, c.expServices, sink.Services)
+			require.Equal(t, c.expMetadata, sink.Metadata)
+		})
+	}
+}
+
 // testRegistration creates a Consul test registration
 func testRegistration(node, service string, tags []string) *api.CatalogRegistration {
 	return &api.CatalogRegistration{
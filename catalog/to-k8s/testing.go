@@ -9,6 +9,7 @@ import (
 type TestSink struct {
 	sync.Mutex
 	Services map[string]string
+	Metadata map[string]ServiceMetadata
 }
 
 func (s *TestSink) SetServices(raw map[string]string) {
@@ -16,3 +17,9 @@ func (s *TestSink) SetServices(raw map[string]string) {
 	defer s.Unlock()
 	s.Services = raw
 }
+
+func (s *TestSink) SetServiceMetadata(raw map[string]ServiceMetadata) {
+	s.Lock()
+	defer s.Unlock()
+	s.Metadata = raw
+}
@@ -3,17 +3,29 @@ package main
 import (
 	"os"
 
+	cmdACLCleanup "github.com/hashicorp/consul-k8s/subcommand/acl-cleanup"
 	cmdACLInit "github.com/hashicorp/consul-k8s/subcommand/acl-init"
+	cmdACLReplicationStatus "github.com/hashicorp/consul-k8s/subcommand/acl-replication-status"
+	cmdBenchInject "github.com/hashicorp/consul-k8s/subcommand/bench-inject"
+	cmdConfigApply "github.com/hashicorp/consul-k8s/subcommand/config-apply"
+	cmdConfigExport "github.com/hashicorp/consul-k8s/subcommand/config-export"
+	cmdConfigVerify "github.com/hashicorp/consul-k8s/subcommand/config-verify"
 	cmdConsulSidecar "github.com/hashicorp/consul-k8s/subcommand/consul-sidecar"
 	cmdController "github.com/hashicorp/consul-k8s/subcommand/controller"
+	cmdCoreDNSConfig "github.com/hashicorp/consul-k8s/subcommand/coredns-config"
 	cmdCreateFederationSecret "github.com/hashicorp/consul-k8s/subcommand/create-federation-secret"
 	cmdDeleteCompletedJob "github.com/hashicorp/consul-k8s/subcommand/delete-completed-job"
+	cmdDNSProxy "github.com/hashicorp/consul-k8s/subcommand/dns-proxy"
 	cmdGetConsulClientCA "github.com/hashicorp/consul-k8s/subcommand/get-consul-client-ca"
 	cmdInjectConnect "github.com/hashicorp/consul-k8s/subcommand/inject-connect"
 	cmdServerACLInit "github.com/hashicorp/consul-k8s/subcommand/server-acl-init"
+	cmdServerRestart "github.com/hashicorp/consul-k8s/subcommand/server-restart"
 	cmdServiceAddress "github.com/hashicorp/consul-k8s/subcommand/service-address"
+	cmdSnapshotRestore "github.com/hashicorp/consul-k8s/subcommand/snapshot-restore"
+	cmdStatus "github.com/hashicorp/consul-k8s/subcommand/status"
 	cmdSyncCatalog "github.com/hashicorp/consul-k8s/subcommand/sync-catalog"
 	cmdTLSInit "github.com/hashicorp/consul-k8s/subcommand/tls-init"
+	cmdVerify "github.com/hashicorp/consul-k8s/subcommand/verify"
 	cmdVersion "github.com/hashicorp/consul-k8s/subcommand/version"
 	webhookCertManager "github.com/hashicorp/consul-k8s/subcommand/webhook-cert-manager"
 	"github.com/hashicorp/consul-k8s/version"
@@ -78,6 +90,54 @@ func init() {
 		"tls-init": func() (cli.Command, error) {
 			return &cmdTLSInit.Command{UI: ui}, nil
 		},
+
+		"bench-inject": func() (cli.Command, error) {
+			return &cmdBenchInject.Command{UI: ui}, nil
+		},
+
+		"verify": func() (cli.Command, error) {
+			return &cmdVerify.Command{UI: ui}, nil
+		},
+
+		"config verify": func() (cli.Command, error) {
+			return &cmdConfigVerify.Command{UI: ui}, nil
+		},
+
+		"config export": func() (cli.Command, error) {
+			return &cmdConfigExport.Command{UI: ui}, nil
+		},
+
+		"config apply": func() (cli.Command, error) {
+			return &cmdConfigApply.Command{UI: ui}, nil
+		},
+
+		"dns-proxy": func() (cli.Command, error) {
+			return &cmdDNSProxy.Command{UI: ui}, nil
+		},
+
+		"coredns-config": func() (cli.Command, error) {
+			return &cmdCoreDNSConfig.Command{UI: ui}, nil
+		},
+
+		"server restart": func() (cli.Command, error) {
+			return &cmdServerRestart.Command{UI: ui}, nil
+		},
+
+		"snapshot restore": func() (cli.Command, error) {
+			return &cmdSnapshotRestore.Command{UI: ui}, nil
+		},
+
+		"acl-replication status": func() (cli.Command, error) {
+			return &cmdACLReplicationStatus.Command{UI: ui}, nil
+		},
+
+		"acl-cleanup": func() (cli.Command, error) {
+			return &cmdACLCleanup.Command{UI: ui}, nil
+		},
+
+		"status": func() (cli.Command, error) {
+			return &cmdStatus.Command{UI: ui}, nil
+		},
 	}
 }
 
@@ -88,6 +148,7 @@ func helpFunc() cli.HelpFunc {
 	// or advanced features.
 	hidden := map[string]struct{}{
 		"inject-connect": struct{}{},
+		"bench-inject":   struct{}{},
 	}
 
 	var include []string
@@ -8,6 +8,7 @@ import (
 	"github.com/hashicorp/consul-k8s/consul"
 	capi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,8 +20,8 @@ import (
 
 // CleanupResource implements Resource and is used to clean up Consul service
 // instances that weren't deregistered when their pods were deleted.
-// Usually the preStop hook in the pods handles this but during a force delete
-// or OOM the preStop hook doesn't run.
+// Usually the preStop hook in the pods handles this but during a force delete,
+// OOM or the loss of the pod's node the preStop hook doesn't run.
 type CleanupResource struct {
 	Log              hclog.Logger
 	KubernetesClient kubernetes.Interface
@@ -32,12 +33,67 @@ type CleanupResource struct {
 	// i.e. "http" or "https".
 	ConsulScheme string
 	// ConsulPort is the port to make HTTP API calls to Consul agents on.
-	ConsulPort             string
+	ConsulPort string
+	// ConsulAPITimeout bounds the calls made to the Consul agents on other
+	// nodes. If 0, they never time out.
+	ConsulAPITimeout       time.Duration
 	EnableConsulNamespaces bool
+	// Metrics are updated as service instances are deregistered. Optional.
+	Metrics *CleanupMetrics
 
 	lock sync.Mutex
 }
 
+const (
+	// triggerReconcile and triggerDelete are the values of the "trigger"
+	// label of the cleanup metrics, telling whether the orphaned instance
+	// was found by the periodic reconcile or by a pod delete event.
+	triggerReconcile = "reconcile"
+	triggerDelete    = "delete"
+)
+
+// CleanupMetrics are the metrics reported by CleanupResource.
+type CleanupMetrics struct {
+	// Deregistered counts the service instances deregistered because their
+	// pod no longer exists.
+	Deregistered *prometheus.CounterVec
+	// DeregisterErrors counts the failed attempts to deregister them.
+	DeregisterErrors *prometheus.CounterVec
+}
+
+// NewCleanupMetrics creates the cleanup metrics and registers them with reg.
+func NewCleanupMetrics(reg prometheus.Registerer) (*CleanupMetrics, error) {
+	m := &CleanupMetrics{
+		Deregistered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_k8s_connect_inject_cleanup_deregistered_total",
+			Help: "Number of service instances deregistered because their pod no longer exists.",
+		}, []string{"trigger"}),
+		DeregisterErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_k8s_connect_inject_cleanup_deregister_errors_total",
+			Help: "Number of failed attempts to deregister service instances whose pod no longer exists.",
+		}, []string{"trigger"}),
+	}
+	for _, c := range []prometheus.Collector{m.Deregistered, m.DeregisterErrors} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// recordDeregistration updates the metrics after trying to deregister an
+// instance. It is a no-op if metrics aren't enabled.
+func (m *CleanupMetrics) recordDeregistration(trigger string, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.DeregisterErrors.WithLabelValues(trigger).Inc()
+		return
+	}
+	m.Deregistered.WithLabelValues(trigger).Inc()
+}
+
 // Run starts the long-running Reconcile loop that runs on a timer.
 func (c *CleanupResource) Run(stopCh <-chan struct{}) {
 	reconcileTimer := time.NewTimer(c.ReconcilePeriod)
@@ -129,6 +185,11 @@ func (c *CleanupResource) reconcile() {
 			for _, instance := range serviceInstances {
 				podName, hasPodMeta := instance.ServiceMeta[MetaKeyPodName]
 				k8sNamespace, hasNSMeta := instance.ServiceMeta[MetaKeyKubeNS]
+				if instance.ServiceMeta[MetaKeyRetainRegistration] == "true" {
+					c.Log.Debug("skipping deregistration because instance is retained",
+						"pod", podName, "id", instance.ServiceID, "ns", ns)
+					continue
+				}
 				if hasPodMeta && hasNSMeta {
 
 					// Check if the instance matches a running pod. If not, deregister it.
@@ -141,6 +202,19 @@ func (c *CleanupResource) reconcile() {
 
 						c.Log.Info("found service instance from terminated pod still registered", "pod", podName, "id", instance.ServiceID, "ns", ns)
 						err := c.deregisterInstance(instance, instance.Address)
+						// If the node was lost, its agent can't be reached and
+						// won't sync the instance again so it's deregistered
+						// from the catalog instead.
+						if err != nil && !nodeReady(kubeNodes, instance.Node) {
+							c.Log.Info("unable to reach the agent of a node that isn't ready, deregistering from the catalog",
+								"id", instance.ServiceID, "ns", ns, "node", instance.Node, "error", err)
+							_, err = c.ConsulClient.Catalog().Deregister(&capi.CatalogDeregistration{
+								Node:      instance.Node,
+								ServiceID: instance.ServiceID,
+								Namespace: instance.Namespace,
+							}, nil)
+						}
+						c.Metrics.recordDeregistration(triggerReconcile, err)
 						if err != nil {
 							c.Log.Error("unable to deregister service instance", "id", instance.ServiceID, "ns", ns, "error", err)
 							continue
@@ -167,17 +241,24 @@ func (c *CleanupResource) Delete(key string, obj interface{}) error {
 	if pod == nil {
 		return fmt.Errorf("object for key %s was nil", key)
 	}
-	serviceName, ok := pod.ObjectMeta.Annotations[annotationService]
-	if !ok {
+	if _, ok := pod.ObjectMeta.Annotations[annotationService]; !ok {
 		return fmt.Errorf("pod did not have %s annotation", annotationService)
 	}
+	// The annotation was validated when the pod was injected.
+	if retain, _ := retainRegistration(pod); retain {
+		c.Log.Debug("skipping deregistration because the pod's instances are retained", "pod", pod.Name, "ns", pod.Namespace)
+		return nil
+	}
 	kubeNS := pod.Namespace
 	podName := pod.Name
 	// NOTE: This will be an empty string with Consul OSS.
 	consulNS := pod.ObjectMeta.Annotations[annotationConsulNamespace]
 
-	// Look for both the service and its sidecar proxy.
-	consulServiceNames := []string{serviceName, fmt.Sprintf("%s-sidecar-proxy", serviceName)}
+	// Look for both the services and their sidecar proxies.
+	var consulServiceNames []string
+	for _, serviceName := range serviceNames(pod) {
+		consulServiceNames = append(consulServiceNames, serviceName, fmt.Sprintf("%s-sidecar-proxy", serviceName))
+	}
 
 	for _, consulServiceName := range consulServiceNames {
 		instances, _, err := c.ConsulClient.Catalog().Service(consulServiceName, "", &capi.QueryOptions{
@@ -203,6 +284,7 @@ func (c *CleanupResource) Delete(key string, obj interface{}) error {
 
 			c.Log.Info("found service instance from terminated pod still registered", "pod", podName, "id", instance.ServiceID, "ns", consulNS)
 			err := c.deregisterInstance(instance, pod.Status.HostIP)
+			c.Metrics.recordDeregistration(triggerDelete, err)
 			if err != nil {
 				c.Log.Error("unable to deregister service instance", "id", instance.ServiceID, "error", err)
 				return err
@@ -246,6 +328,9 @@ func (c *CleanupResource) deregisterInstance(instance *capi.CatalogService, host
 		localConfig.Namespace = instance.Namespace
 	}
 	localConfig.Address = fullAddr
+	if err := consul.SetTimeout(localConfig, c.ConsulAPITimeout); err != nil {
+		return fmt.Errorf("constructing client for address %q: %s", hostIP, err)
+	}
 	client, err := consul.NewClient(localConfig)
 	if err != nil {
 		return fmt.Errorf("constructing client for address %q: %s", hostIP, err)
@@ -271,6 +356,22 @@ func nodeInCluster(nodes *corev1.NodeList, nodeName string) bool {
 	return false
 }
 
+// nodeReady returns whether the node named nodeName in nodes has the Ready
+// condition. Nodes that are lost have it set to Unknown.
+func nodeReady(nodes *corev1.NodeList, nodeName string) bool {
+	for _, n := range nodes.Items {
+		if n.Name != nodeName {
+			continue
+		}
+		for _, cond := range n.Status.Conditions {
+			if cond.Type == corev1.NodeReady {
+				return cond.Status == corev1.ConditionTrue
+			}
+		}
+	}
+	return false
+}
+
 // keys returns the keys of m.
 func keys(m map[string][]string) []string {
 	var ks []string
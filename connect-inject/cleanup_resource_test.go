@@ -3,10 +3,13 @@ package connectinject
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	capi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -73,6 +76,11 @@ func TestReconcile(t *testing.T) {
 			KubePods:            []runtime.Object{fooPod},
 			ExpConsulServiceIDs: []string{"foo-abc123-foo", "foo-abc123-foo-sidecar-proxy"},
 		},
+		"retained instances of terminated pod": {
+			ConsulServices:      []capi.AgentServiceRegistration{retained(consulFooSvc), retained(consulFooSvcSidecar)},
+			KubePods:            nil,
+			ExpConsulServiceIDs: []string{"foo-abc123-foo", "foo-abc123-foo-sidecar-proxy"},
+		},
 	}
 
 	for name, c := range cases {
@@ -110,17 +118,25 @@ func TestReconcile(t *testing.T) {
 				})
 
 			}
+			metrics, err := NewCleanupMetrics(prometheus.NewRegistry())
+			require.NoError(err)
 			cleanupResource := CleanupResource{
 				Log:              log,
 				KubernetesClient: fake.NewSimpleClientset(kubeResources...),
 				ConsulClient:     consulClient,
 				ConsulScheme:     consulURL.Scheme,
 				ConsulPort:       consulURL.Port(),
+				Metrics:          metrics,
 			}
 
 			// Run Reconcile.
 			cleanupResource.reconcile()
 
+			// Test that the deregistered services were counted.
+			expDeregistered := len(c.ConsulServices) - len(c.ExpConsulServiceIDs)
+			require.Equal(float64(expDeregistered), promtest.ToFloat64(metrics.Deregistered.WithLabelValues(triggerReconcile)))
+			require.Equal(float64(0), promtest.ToFloat64(metrics.DeregisterErrors.WithLabelValues(triggerReconcile)))
+
 			// Test that the remaining services are what we expect.
 			services, err := consulClient.Agent().Services()
 			require.NoError(err)
@@ -133,6 +149,84 @@ func TestReconcile(t *testing.T) {
 	}
 }
 
+// Test that the instances of a node that was lost, whose agent can't be
+// reached, are deregistered from the catalog unless the node is ready.
+func TestReconcile_LostNode(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		NodeStatus          corev1.ConditionStatus
+		ExpConsulServiceIDs []string
+		ExpDeregistered     float64
+		ExpErrors           float64
+	}{
+		"node not ready": {
+			NodeStatus:      corev1.ConditionUnknown,
+			ExpDeregistered: 1,
+		},
+		"node ready": {
+			NodeStatus:          corev1.ConditionTrue,
+			ExpConsulServiceIDs: []string{"foo-abc123-foo"},
+			ExpErrors:           1,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			server, err := testutil.NewTestServerConfigT(t, nil)
+			defer server.Stop()
+			require.NoError(err)
+			server.WaitForLeader(t)
+			consulClient, err := capi.NewClient(&capi.Config{Address: server.HTTPAddr})
+			require.NoError(err)
+
+			// Register the instance on a node without an agent listening.
+			_, err = consulClient.Catalog().Register(&capi.CatalogRegistration{
+				Node:    "lost-node",
+				Address: "127.0.0.2",
+				Service: &capi.AgentService{
+					ID:      consulFooSvc.ID,
+					Service: consulFooSvc.Name,
+					Meta:    consulFooSvc.Meta,
+				},
+			}, nil)
+			require.NoError(err)
+
+			consulURL, err := url.Parse("http://" + server.HTTPAddr)
+			require.NoError(err)
+			metrics, err := NewCleanupMetrics(prometheus.NewRegistry())
+			require.NoError(err)
+			cleanupResource := CleanupResource{
+				Log: hclog.Default().Named("cleanupResource"),
+				KubernetesClient: fake.NewSimpleClientset(&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "lost-node"},
+					Status: corev1.NodeStatus{
+						Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: c.NodeStatus}},
+					},
+				}),
+				ConsulClient:     consulClient,
+				ConsulScheme:     consulURL.Scheme,
+				ConsulPort:       consulURL.Port(),
+				ConsulAPITimeout: 5 * time.Second,
+				Metrics:          metrics,
+			}
+
+			cleanupResource.reconcile()
+
+			require.Equal(c.ExpDeregistered, promtest.ToFloat64(metrics.Deregistered.WithLabelValues(triggerReconcile)))
+			require.Equal(c.ExpErrors, promtest.ToFloat64(metrics.DeregisterErrors.WithLabelValues(triggerReconcile)))
+			instances, _, err := consulClient.Catalog().Service("foo", "", nil)
+			require.NoError(err)
+			var actualServiceIDs []string
+			for _, instance := range instances {
+				actualServiceIDs = append(actualServiceIDs, instance.ServiceID)
+			}
+			require.ElementsMatch(actualServiceIDs, c.ExpConsulServiceIDs)
+		})
+	}
+}
+
 func TestDelete(t *testing.T) {
 	t.Parallel()
 
@@ -203,6 +297,54 @@ func TestDelete(t *testing.T) {
 			},
 			ExpConsulServiceIDs: []string{"foo-def456-foo", "foo-def456-foo-sidecar-proxy"},
 		},
+		"multi-port pod terminated": {
+			Pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo-abc123",
+					Namespace: "default",
+					Annotations: map[string]string{
+						annotationService: "foo,foo-admin",
+					},
+				},
+				Status: corev1.PodStatus{
+					HostIP: "127.0.0.1",
+				},
+			},
+			ConsulServices: []capi.AgentServiceRegistration{
+				consulFooSvc,
+				consulFooSvcSidecar,
+				{
+					ID:      "foo-abc123-foo-admin",
+					Name:    "foo-admin",
+					Address: "127.0.0.1",
+					Meta:    map[string]string{MetaKeyPodName: "foo-abc123", MetaKeyKubeNS: "default"},
+				},
+				{
+					ID:      "foo-abc123-foo-admin-sidecar-proxy",
+					Name:    "foo-admin-sidecar-proxy",
+					Address: "127.0.0.1",
+					Meta:    map[string]string{MetaKeyPodName: "foo-abc123", MetaKeyKubeNS: "default"},
+				},
+			},
+			ExpConsulServiceIDs: nil,
+		},
+		"pod with retained instances": {
+			Pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo-abc123",
+					Namespace: "default",
+					Annotations: map[string]string{
+						annotationService:            "foo",
+						annotationRetainRegistration: "true",
+					},
+				},
+				Status: corev1.PodStatus{
+					HostIP: "127.0.0.1",
+				},
+			},
+			ConsulServices:      []capi.AgentServiceRegistration{retained(consulFooSvc), retained(consulFooSvcSidecar)},
+			ExpConsulServiceIDs: []string{"foo-abc123-foo", "foo-abc123-foo-sidecar-proxy"},
+		},
 	}
 
 	for name, c := range cases {
@@ -227,12 +369,15 @@ func TestDelete(t *testing.T) {
 			log.SetLevel(hclog.Debug)
 			consulURL, err := url.Parse("http://" + server.HTTPAddr)
 			require.NoError(err)
+			metrics, err := NewCleanupMetrics(prometheus.NewRegistry())
+			require.NoError(err)
 			cleanupResource := CleanupResource{
 				Log:              log,
 				KubernetesClient: fake.NewSimpleClientset(),
 				ConsulClient:     consulClient,
 				ConsulScheme:     consulURL.Scheme,
 				ConsulPort:       consulURL.Port(),
+				Metrics:          metrics,
 			}
 
 			// Run Delete.
@@ -251,11 +396,24 @@ func TestDelete(t *testing.T) {
 					actualServiceIDs = append(actualServiceIDs, id)
 				}
 				require.ElementsMatch(actualServiceIDs, c.ExpConsulServiceIDs)
+
+				expDeregistered := len(c.ConsulServices) - len(c.ExpConsulServiceIDs)
+				require.Equal(float64(expDeregistered), promtest.ToFloat64(metrics.Deregistered.WithLabelValues(triggerDelete)))
 			}
 		})
 	}
 }
 
+// retained returns a copy of svc marked as retained after its pod is deleted.
+func retained(svc capi.AgentServiceRegistration) capi.AgentServiceRegistration {
+	meta := map[string]string{MetaKeyRetainRegistration: "true"}
+	for k, v := range svc.Meta {
+		meta[k] = v
+	}
+	svc.Meta = meta
+	return svc
+}
+
 // nodeName returns the Consul node name for the agent that client
 // points at.
 func nodeName(t *testing.T, client *capi.Client) string {
@@ -1,11 +1,17 @@
 package connectinject
 
 import (
+	"fmt"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// defaultConsulSidecarHealthPort is the port of the consul-sidecar's health
+// endpoint unless the pod overrides it.
+const defaultConsulSidecarHealthPort = 20300
+
 func (h *Handler) consulSidecar(pod *corev1.Pod) corev1.Container {
 	command := []string{
 		"consul-k8s",
@@ -21,6 +27,29 @@ func (h *Handler) consulSidecar(pod *corev1.Pod) corev1.Container {
 		command = append(command, "-sync-period="+strings.TrimSpace(period))
 	}
 
+	// The annotation has been validated by Mutate.
+	healthPort, _ := consulSidecarHealthPort(pod)
+	command = append(command, fmt.Sprintf("-health-port=%d", healthPort))
+
+	// The annotations have been validated by containerInit.
+	if metrics, _ := h.envoyMetrics(pod); metrics != nil {
+		command = append(command, metrics.consulSidecarFlags()...)
+	}
+
+	// The annotation has been validated by Mutate.
+	if drainTime, _ := h.envoyDrainTime(pod); drainTime > 0 {
+		// The services have been validated by containerInit.
+		services, _ := podServices(pod)
+		for _, addr := range envoyAdminAddrs(services) {
+			command = append(command, "-drain-envoy-admin-addr="+addr)
+		}
+	}
+
+	// The annotation has been validated by Mutate.
+	if job, _ := isJobPod(pod); job {
+		command = jobSidecarCommand(command, "")
+	}
+
 	envVariables := []corev1.EnvVar{
 		{
 			Name: "HOST_IP",
@@ -65,5 +94,29 @@ func (h *Handler) consulSidecar(pod *corev1.Pod) corev1.Container {
 		},
 		Command:   command,
 		Resources: h.ConsulSidecarResources,
+		// Restart the sidecar once it's been failing to sync the service
+		// registration for a while, rather than letting the registration
+		// drift from the pod.
+		LivenessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/health",
+					Port: intstr.FromInt(healthPort),
+				},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+			FailureThreshold:    6,
+		},
+	}
+}
+
+// consulSidecarHealthPort returns the port of the consul-sidecar's health
+// endpoint.
+func consulSidecarHealthPort(pod *corev1.Pod) (int, error) {
+	port := defaultConsulSidecarHealthPort
+	if err := portAnnotation(pod, annotationConsulSidecarHealthPort, &port); err != nil {
+		return 0, err
 	}
+	return port, nil
 }
@@ -3,11 +3,14 @@ package connectinject
 import (
 	"testing"
 
+	mapset "github.com/deckarep/golang-set"
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 var (
@@ -68,8 +71,10 @@ func TestConsulSidecar_Default(t *testing.T) {
 			"consul-k8s", "consul-sidecar",
 			"-service-config", "/consul/connect-inject/service.hcl",
 			"-consul-binary", "/consul/connect-inject/consul",
+			"-health-port=20300",
 		},
-		Resources: consulSidecarResources,
+		Resources:     consulSidecarResources,
+		LivenessProbe: consulSidecarLivenessProbe(20300),
 	}, container)
 }
 
@@ -130,6 +135,33 @@ func TestConsulSidecar_SyncPeriodAnnotation(t *testing.T) {
 	require.Contains(t, container.Command, "-sync-period=55s")
 }
 
+// Test that the Consul sidecar of a job exits with the other containers.
+func TestConsulSidecar_Job(t *testing.T) {
+	handler := Handler{
+		Log:            hclog.Default().Named("handler"),
+		ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
+	}
+	container := handler.consulSidecar(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationJob: "true",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	})
+
+	require.Len(t, container.Command, 3)
+	require.Equal(t, []string{"/bin/sh", "-ec"}, container.Command[:2])
+	require.Contains(t, container.Command[2], "'consul-k8s' 'consul-sidecar' '-service-config' '/consul/connect-inject/service.hcl'")
+	require.NotContains(t, container.Command[2], "preStop")
+}
+
 // Test that the Consul address uses HTTPS
 // and that the CA is provided
 func TestConsulSidecar_TLS(t *testing.T) {
@@ -177,7 +209,77 @@ func TestConsulSidecar_TLS(t *testing.T) {
 			"consul-k8s", "consul-sidecar",
 			"-service-config", "/consul/connect-inject/service.hcl",
 			"-consul-binary", "/consul/connect-inject/consul",
+			"-health-port=20300",
 		},
-		Resources: consulSidecarResources,
+		Resources:     consulSidecarResources,
+		LivenessProbe: consulSidecarLivenessProbe(20300),
 	}, container)
 }
+
+// Test that the liveness probe of the Consul sidecar checks its health
+// endpoint, on the port of the annotation if it's set.
+func TestConsulSidecar_HealthPortAnnotation(t *testing.T) {
+	handler := Handler{
+		Log:            hclog.Default().Named("handler"),
+		ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
+	}
+	container := handler.consulSidecar(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationConsulSidecarHealthPort: "21300",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	})
+
+	require.Contains(t, container.Command, "-health-port=21300")
+	require.Equal(t, consulSidecarLivenessProbe(21300), container.LivenessProbe)
+}
+
+// Test that an invalid health port annotation is rejected.
+func TestHandlerHandle_InvalidConsulSidecarHealthPort(t *testing.T) {
+	handler := Handler{
+		Log:                   hclog.Default().Named("handler"),
+		AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:  mapset.NewSet(),
+	}
+	resp := handler.Mutate(&v1beta1.AdmissionRequest{
+		Object: encodeRaw(t, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					annotationConsulSidecarHealthPort: "http",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name: "web",
+					},
+				},
+			},
+		}),
+	})
+	require.False(t, resp.Allowed)
+	require.Equal(t, `Error getting the consul-sidecar health port: consul.hashicorp.com/consul-sidecar-health-port `+
+		`annotation value of "http" is invalid: must be a port number`, resp.Result.Message)
+}
+
+func consulSidecarLivenessProbe(port int) *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: "/health",
+				Port: intstr.FromInt(port),
+			},
+		},
+		InitialDelaySeconds: 10,
+		PeriodSeconds:       10,
+		FailureThreshold:    6,
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/hashicorp/consul-k8s/helper/dashboard"
 	capi "github.com/hashicorp/consul/api"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -15,12 +17,34 @@ const (
 	InjectInitContainerName = "consul-connect-inject-init"
 	MetaKeyPodName          = "pod-name"
 	MetaKeyKubeNS           = "k8s-namespace"
+
+	// MetaKeyExternalSource is set to MetaValueExternalSource on all
+	// registrations so that the Consul UI shows they come from Kubernetes.
+	MetaKeyExternalSource   = "external-source"
+	MetaValueExternalSource = "kubernetes"
+
+	// MetaKeyDashboardURL is set to the rendered -dashboard-url-template.
+	MetaKeyDashboardURL = dashboard.MetaKey
+
+	// MetaKeyRetainRegistration is set to "true" on registrations of pods
+	// with the retain registration annotation so that the cleanup
+	// controller can tell they should be kept once the pod is gone.
+	MetaKeyRetainRegistration = "retain-registration"
+
+	// proxyPublicListenerPort and proxyAdminPort are the ports of the
+	// public listener and Envoy admin API of the sidecar proxy. The i-th
+	// proxy of a multi-port pod uses these ports plus i.
+	proxyPublicListenerPort = 20000
+	proxyAdminPort          = 19000
 )
 
 type initContainerCommandData struct {
-	ServiceName      string
-	ProxyServiceName string
-	ServicePort      int32
+	// ServiceName is the name of the first service registered for the pod,
+	// which is the only one unless the pod is multi-port.
+	ServiceName string
+	// Services are the services registered for the pod along with their
+	// sidecar proxies.
+	Services []initContainerCommandServiceData
 	// ServiceProtocol is the protocol for the service-defaults config
 	// that will be written if WriteServiceDefaults is true.
 	ServiceProtocol string
@@ -38,16 +62,78 @@ type initContainerCommandData struct {
 	Meta                      map[string]string
 	MetaKeyPodName            string
 	MetaKeyKubeNS             string
+	// ConsulPartition is the Consul admin partition to register the service
+	// and proxy in and to log in to. An empty string indicates partitions
+	// are not enabled.
+	ConsulPartition string
+	// RetainRegistration is true if the registrations should be kept after
+	// the pod is deleted, in which case Consul doesn't deregister the proxy
+	// once its checks have been critical for a while either.
+	RetainRegistration bool
+	// ServiceAddress is the address the services and proxies are registered
+	// with. ProxyBindAddress is the address the proxies bind if it differs.
+	ServiceAddress   string
+	ProxyBindAddress string
 
 	// The PEM-encoded CA certificate to use when
 	// communicating with Consul clients
 	ConsulCACert string
 }
 
+// initContainerCommandServiceData is a service registered by the init
+// container along with its sidecar proxy.
+type initContainerCommandServiceData struct {
+	ServiceName      string
+	ProxyServiceName string
+	ServicePort      int32
+	// ServiceID and ProxyServiceID are expanded by the init container's
+	// shell to the IDs of the service and proxy registrations.
+	ServiceID      string
+	ProxyServiceID string
+	// ProxyPort is the port of the proxy's public listener.
+	ProxyPort int
+	// AdminBind is the address of the proxy's Envoy admin API if it isn't
+	// the default.
+	AdminBind string
+	// BootstrapFile is the name of the proxy's Envoy bootstrap file in the
+	// shared volume.
+	BootstrapFile string
+	// Meta is the meta of both registrations. It differs between services
+	// only by the dashboard URL.
+	Meta map[string]string
+	// Upstreams are only set on the first proxy so that the upstreams'
+	// local ports are only bound once in the pod.
+	Upstreams []initContainerCommandUpstreamData
+	// EnvoyTracingJSON and EnvoyTracingClusterJSON are the quoted
+	// envoy_tracing_json and envoy_extra_static_clusters_json proxy configs
+	// if tracing is enabled.
+	EnvoyTracingJSON        string
+	EnvoyTracingClusterJSON string
+	// EnvoyPrometheusBindAddr is the envoy_prometheus_bind_addr proxy config
+	// if metrics are enabled. It's only set on the first proxy.
+	EnvoyPrometheusBindAddr string
+}
+
+// multiPort returns whether the pod has more than one service, each with its
+// own sidecar proxy.
+func multiPort(services []podService) bool {
+	return len(services) > 1
+}
+
+// envoyBootstrapFile returns the name of the Envoy bootstrap file of the
+// proxy of service in the shared volume.
+func envoyBootstrapFile(service string, multiPort bool) string {
+	if multiPort {
+		return fmt.Sprintf("envoy-bootstrap-%s.yaml", service)
+	}
+	return "envoy-bootstrap.yaml"
+}
+
 type initContainerCommandUpstreamData struct {
 	Name                    string
 	LocalPort               int32
 	ConsulUpstreamNamespace string
+	ConsulUpstreamPartition string
 	Datacenter              string
 	Query                   string
 }
@@ -55,12 +141,16 @@ type initContainerCommandUpstreamData struct {
 // containerInit returns the init container spec for registering the Consul
 // service, setting up the Envoy bootstrap, etc.
 func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Container, error) {
+	services, err := podServices(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
 	data := initContainerCommandData{
-		ServiceName:               pod.Annotations[annotationService],
-		ProxyServiceName:          fmt.Sprintf("%s-sidecar-proxy", pod.Annotations[annotationService]),
+		ServiceName:               services[0].name,
 		AuthMethod:                h.AuthMethod,
 		ConsulNamespace:           h.consulNamespace(k8sNamespace),
 		NamespaceMirroringEnabled: h.EnableK8SNSMirroring,
+		ConsulPartition:           h.ConsulPartition,
 		ConsulCACert:              h.ConsulCACert,
 		MetaKeyPodName:            MetaKeyPodName,
 		MetaKeyKubeNS:             MetaKeyKubeNS,
@@ -72,17 +162,10 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 	}
 
 	// When ACLs are enabled, the ACL token returned from `consul login` is only
-	// valid for a service with the same name as the ServiceAccountName.
-	if data.AuthMethod != "" && data.ServiceName != pod.Spec.ServiceAccountName {
-		return corev1.Container{}, fmt.Errorf("serviceAccountName %q does not match service name %q", pod.Spec.ServiceAccountName, data.ServiceName)
-	}
-
-	// If a port is specified, then we determine the value of that port
-	// and register that port for the host service.
-	if raw, ok := pod.Annotations[annotationPort]; ok && raw != "" {
-		if port, _ := portValue(pod, raw); port > 0 {
-			data.ServicePort = port
-		}
+	// valid for a service with the same name as the ServiceAccountName, so
+	// there can't be a token for each service of a multi-port pod.
+	if data.AuthMethod != "" && multiPort(services) {
+		return corev1.Container{}, fmt.Errorf("multi-port pods are not supported when ACLs are enabled")
 	}
 
 	var tags []string
@@ -105,20 +188,40 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 		}
 	}
 
-	// If there is metadata specified split into a map and create.
-	data.Meta = make(map[string]string)
+	// If there is metadata specified split into a map and create. The
+	// external source and the labels and annotations copied by the meta
+	// propagator can be overridden by the meta annotations.
+	data.Meta = map[string]string{MetaKeyExternalSource: MetaValueExternalSource}
+	propagated, errs := h.MetaPropagator.Meta(pod.Labels, pod.Annotations)
+	for _, err := range errs {
+		h.Log.Warn("not copying to service meta", "service", data.ServiceName, "err", err)
+	}
+	for k, v := range propagated {
+		if _, ok := data.Meta[k]; !ok {
+			data.Meta[k] = v
+		}
+	}
 	for k, v := range pod.Annotations {
 		if strings.HasPrefix(k, annotationMeta) && strings.TrimPrefix(k, annotationMeta) != "" {
 			data.Meta[strings.TrimPrefix(k, annotationMeta)] = v
 		}
 	}
 
+	retain, err := retainRegistration(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	if retain {
+		data.RetainRegistration = true
+		data.Meta[MetaKeyRetainRegistration] = "true"
+	}
+
 	// If upstreams are specified, configure those
 	if raw, ok := pod.Annotations[annotationUpstreams]; ok && raw != "" {
 		for _, raw := range strings.Split(raw, ",") {
 			parts := strings.SplitN(raw, ":", 3)
 
-			var datacenter, service_name, prepared_query, namespace string
+			var datacenter, service_name, prepared_query, namespace, partition string
 			var port int32
 			if strings.TrimSpace(parts[0]) == "prepared_query" {
 				port, _ = portValue(pod, strings.TrimSpace(parts[2]))
@@ -126,14 +229,22 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 			} else {
 				port, _ = portValue(pod, strings.TrimSpace(parts[1]))
 
-				// Parse the namespace if provided
+				// Parse the namespace and, if partitions are enabled, the
+				// partition if provided
 				if data.ConsulNamespace != "" {
-					pieces := strings.SplitN(parts[0], ".", 2)
+					n := 2
+					if data.ConsulPartition != "" {
+						n = 3
+					}
+					pieces := strings.SplitN(parts[0], ".", n)
 					service_name = pieces[0]
 
 					if len(pieces) > 1 {
 						namespace = pieces[1]
 					}
+					if len(pieces) > 2 {
+						partition = pieces[2]
+					}
 				} else {
 					service_name = strings.TrimSpace(parts[0])
 				}
@@ -141,7 +252,8 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 				// parse the optional datacenter
 				if len(parts) > 2 {
 					datacenter = strings.TrimSpace(parts[2])
-
+				}
+				if datacenter != "" && h.ConsulClient != nil {
 					// Check if there's a proxy defaults config with mesh gateway
 					// mode set to local or remote. This helps users from
 					// accidentally forgetting to set a mesh gateway mode
@@ -175,11 +287,88 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 					upstream.ConsulUpstreamNamespace = namespace
 				}
 
+				// Add partition to upstream
+				if partition != "" {
+					upstream.ConsulUpstreamPartition = partition
+				}
+
 				data.Upstreams = append(data.Upstreams, upstream)
 			}
 		}
 	}
 
+	data.ServiceAddress, data.ProxyBindAddress, err = serviceAddress(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	firstProxyPort, err := proxyPort(pod, len(services))
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	tracing, err := h.envoyTracing(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	metrics, err := h.envoyMetrics(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	for i, service := range services {
+		svc := initContainerCommandServiceData{
+			ServiceName:      service.name,
+			ProxyServiceName: fmt.Sprintf("%s-sidecar-proxy", service.name),
+			ServiceID:        "${SERVICE_ID}",
+			ProxyServiceID:   "${PROXY_SERVICE_ID}",
+			ProxyPort:        firstProxyPort + i,
+			BootstrapFile:    envoyBootstrapFile(service.name, multiPort(services)),
+			Meta:             make(map[string]string),
+		}
+		if multiPort(services) {
+			svc.ServiceID = fmt.Sprintf("${POD_NAME}-%s", svc.ServiceName)
+			svc.ProxyServiceID = fmt.Sprintf("${POD_NAME}-%s", svc.ProxyServiceName)
+			if i > 0 {
+				svc.AdminBind = fmt.Sprintf("127.0.0.1:%d", proxyAdminPort+i)
+			}
+		}
+		if i == 0 {
+			svc.Upstreams = data.Upstreams
+			if metrics != nil {
+				svc.EnvoyPrometheusBindAddr = metrics.prometheusBindAddr()
+			}
+		}
+		if tracing != nil {
+			svc.EnvoyTracingJSON = strconv.Quote(tracing.tracingJSON(svc.ServiceName))
+			svc.EnvoyTracingClusterJSON = strconv.Quote(tracing.clusterJSON())
+		}
+
+		// If a port is specified, then we determine the value of that port
+		// and register that port for the host service.
+		if service.port != "" {
+			if port, _ := portValue(pod, service.port); port > 0 {
+				svc.ServicePort = port
+			}
+		}
+
+		for k, v := range data.Meta {
+			svc.Meta[k] = v
+		}
+		// The pod's name isn't known yet when it's created from a template so
+		// it's expanded by the init container's shell.
+		dashboardURL, err := h.DashboardURLTemplate.Render(dashboard.URLData{
+			Namespace:   k8sNamespace,
+			Name:        "${POD_NAME}",
+			ServiceName: svc.ServiceName,
+		})
+		if err != nil {
+			return corev1.Container{}, fmt.Errorf("rendering dashboard URL: %s", err)
+		}
+		if dashboardURL != "" {
+			svc.Meta[MetaKeyDashboardURL] = dashboardURL
+		}
+		data.Services = append(data.Services, svc)
+	}
+
 	// Create expected volume mounts
 	volMounts := []corev1.VolumeMount{
 		corev1.VolumeMount{
@@ -203,14 +392,14 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 	var buf bytes.Buffer
 	tpl := template.Must(template.New("root").Parse(strings.TrimSpace(
 		initContainerCommandTpl)))
-	err := tpl.Execute(&buf, &data)
+	err = tpl.Execute(&buf, &data)
 	if err != nil {
 		return corev1.Container{}, err
 	}
 
 	return corev1.Container{
 		Name:  InjectInitContainerName,
-		Image: h.ImageConsul,
+		Image: h.imageConsul(k8sNamespace),
 		Env: []corev1.EnvVar{
 			{
 				Name: "HOST_IP",
@@ -238,11 +427,11 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 			},
 			{
 				Name:  "SERVICE_ID",
-				Value: fmt.Sprintf("$(POD_NAME)-%s", data.ServiceName),
+				Value: fmt.Sprintf("$(POD_NAME)-%s", data.Services[0].ServiceName),
 			},
 			{
 				Name:  "PROXY_SERVICE_ID",
-				Value: fmt.Sprintf("$(POD_NAME)-%s", data.ProxyServiceName),
+				Value: fmt.Sprintf("$(POD_NAME)-%s", data.Services[0].ProxyServiceName),
 			},
 		},
 		Resources:    h.InitContainerResources,
@@ -272,58 +461,81 @@ export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 # Register the service. The HCL is stored in the volume so that
 # the preStop hook can access it to deregister the service.
 cat <<EOF >/consul/connect-inject/service.hcl
+{{- range $i, $svc := .Services }}
+{{- if $i }}
+{{ end }}
 services {
-  id   = "${SERVICE_ID}"
-  name = "{{ .ServiceName }}"
-  address = "${POD_IP}"
-  port = {{ .ServicePort }}
-  {{- if .ConsulNamespace }}
-  namespace = "{{ .ConsulNamespace }}"
+  id   = "{{ $svc.ServiceID }}"
+  name = "{{ $svc.ServiceName }}"
+  address = "{{ $.ServiceAddress }}"
+  port = {{ $svc.ServicePort }}
+  {{- if $.ConsulPartition }}
+  partition = "{{ $.ConsulPartition }}"
+  {{- end }}
+  {{- if $.ConsulNamespace }}
+  namespace = "{{ $.ConsulNamespace }}"
   {{- end }}
-  {{- if .Tags}}
-  tags = {{.Tags}}
+  {{- if $.Tags}}
+  tags = {{$.Tags}}
   {{- end}}
   meta = {
-    {{- if .Meta}}
-    {{- range $key, $value := .Meta }}
+    {{- if $svc.Meta}}
+    {{- range $key, $value := $svc.Meta }}
     {{$key}} = "{{$value}}"
     {{- end }}
     {{- end }}
-    {{ .MetaKeyPodName }} = "${POD_NAME}"
-    {{ .MetaKeyKubeNS }} = "${POD_NAMESPACE}"
+    {{ $.MetaKeyPodName }} = "${POD_NAME}"
+    {{ $.MetaKeyKubeNS }} = "${POD_NAMESPACE}"
   }
 }
 
 services {
-  id   = "${PROXY_SERVICE_ID}"
-  name = "{{ .ProxyServiceName }}"
+  id   = "{{ $svc.ProxyServiceID }}"
+  name = "{{ $svc.ProxyServiceName }}"
   kind = "connect-proxy"
-  address = "${POD_IP}"
-  port = 20000
-  {{- if .ConsulNamespace }}
-  namespace = "{{ .ConsulNamespace }}"
+  address = "{{ $.ServiceAddress }}"
+  port = {{ $svc.ProxyPort }}
+  {{- if $.ConsulPartition }}
+  partition = "{{ $.ConsulPartition }}"
+  {{- end }}
+  {{- if $.ConsulNamespace }}
+  namespace = "{{ $.ConsulNamespace }}"
   {{- end }}
-  {{- if .Tags}}
-  tags = {{.Tags}}
+  {{- if $.Tags}}
+  tags = {{$.Tags}}
   {{- end}}
   meta = {
-    {{- if .Meta}}
-    {{- range $key, $value := .Meta }}
+    {{- if $svc.Meta}}
+    {{- range $key, $value := $svc.Meta }}
     {{$key}} = "{{$value}}"
     {{- end }}
     {{- end }}
-    {{ .MetaKeyPodName }} = "${POD_NAME}"
-    {{ .MetaKeyKubeNS }} = "${POD_NAMESPACE}"
+    {{ $.MetaKeyPodName }} = "${POD_NAME}"
+    {{ $.MetaKeyKubeNS }} = "${POD_NAMESPACE}"
   }
 
   proxy {
-    destination_service_name = "{{ .ServiceName }}"
-    destination_service_id = "${SERVICE_ID}"
-    {{- if (gt .ServicePort 0) }}
+    destination_service_name = "{{ $svc.ServiceName }}"
+    destination_service_id = "{{ $svc.ServiceID }}"
+    {{- if (gt $svc.ServicePort 0) }}
     local_service_address = "127.0.0.1"
-    local_service_port = {{ .ServicePort }}
+    local_service_port = {{ $svc.ServicePort }}
     {{- end }}
-    {{- range .Upstreams }}
+    {{- if or $svc.EnvoyTracingJSON $svc.EnvoyPrometheusBindAddr $.ProxyBindAddress }}
+    config {
+      {{- if $.ProxyBindAddress }}
+      bind_address = "{{ $.ProxyBindAddress }}"
+      {{- end }}
+      {{- if $svc.EnvoyPrometheusBindAddr }}
+      envoy_prometheus_bind_addr = "{{ $svc.EnvoyPrometheusBindAddr }}"
+      {{- end }}
+      {{- if $svc.EnvoyTracingJSON }}
+      envoy_tracing_json = {{ $svc.EnvoyTracingJSON }}
+      envoy_extra_static_clusters_json = {{ $svc.EnvoyTracingClusterJSON }}
+      {{- end }}
+    }
+    {{- end }}
+    {{- range $svc.Upstreams }}
     upstreams {
       {{- if .Name }}
       destination_type = "service" 
@@ -336,6 +548,9 @@ services {
       {{- if .ConsulUpstreamNamespace }}
       destination_namespace = "{{ .ConsulUpstreamNamespace }}"
       {{- end}}
+      {{- if .ConsulUpstreamPartition }}
+      destination_partition = "{{ .ConsulUpstreamPartition }}"
+      {{- end}}
       local_bind_port = {{ .LocalPort }}
       {{- if .Datacenter }}
       datacenter = "{{ .Datacenter }}"
@@ -346,22 +561,28 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_IP}:{{ $svc.ProxyPort }}"
     interval = "10s"
+    {{- if not $.RetainRegistration }}
     deregister_critical_service_after = "10m"
+    {{- end }}
   }
 
   checks {
     name = "Destination Alias"
-    alias_service = "${SERVICE_ID}"
+    alias_service = "{{ $svc.ServiceID }}"
   }
 }
+{{- end }}
 EOF
 
 {{- if .AuthMethod }}
 /bin/consul login -method="{{ .AuthMethod }}" \
   -bearer-token-file="/var/run/secrets/kubernetes.io/serviceaccount/token" \
   -token-sink-file="/consul/connect-inject/acl-token" \
+  {{- if .ConsulPartition }}
+  -partition="{{ .ConsulPartition }}" \
+  {{- end }}
   {{- if.ConsulNamespace }}
   {{- if .NamespaceMirroringEnabled }}
   {{- /* If namespace mirroring is enabled, the auth method is
@@ -381,21 +602,32 @@ chmod 444 /consul/connect-inject/acl-token
   {{- if .AuthMethod }}
   -token-file="/consul/connect-inject/acl-token" \
   {{- end }}
+  {{- if .ConsulPartition }}
+  -partition="{{ .ConsulPartition }}" \
+  {{- end }}
   {{- if .ConsulNamespace }}
   -namespace="{{ .ConsulNamespace }}" \
   {{- end }}
   /consul/connect-inject/service.hcl
 
 # Generate the envoy bootstrap code
+{{- range .Services }}
 /bin/consul connect envoy \
-  -proxy-id="${PROXY_SERVICE_ID}" \
-  {{- if .AuthMethod }}
+  -proxy-id="{{ .ProxyServiceID }}" \
+  {{- if .AdminBind }}
+  -admin-bind="{{ .AdminBind }}" \
+  {{- end }}
+  {{- if $.AuthMethod }}
   -token-file="/consul/connect-inject/acl-token" \
   {{- end }}
-  {{- if .ConsulNamespace }}
-  -namespace="{{ .ConsulNamespace }}" \
+  {{- if $.ConsulPartition }}
+  -partition="{{ $.ConsulPartition }}" \
   {{- end }}
-  -bootstrap > /consul/connect-inject/envoy-bootstrap.yaml
+  {{- if $.ConsulNamespace }}
+  -namespace="{{ $.ConsulNamespace }}" \
+  {{- end }}
+  -bootstrap > /consul/connect-inject/{{ .BootstrapFile }}
+{{- end }}
 
 # Copy the Consul binary
 cp /bin/consul /consul/connect-inject/consul
@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/hashicorp/consul-k8s/helper/dashboard"
+	"github.com/hashicorp/consul-k8s/helper/servicemeta"
 	capi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/stretchr/testify/require"
@@ -64,6 +66,7 @@ services {
   address = "${POD_IP}"
   port = 0
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -76,6 +79,7 @@ services {
   address = "${POD_IP}"
   port = 20000
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -125,6 +129,7 @@ cp /bin/consul /consul/connect-inject/consul`,
   address = "${POD_IP}"
   port = 1234
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -137,6 +142,7 @@ services {
   address = "${POD_IP}"
   port = 20000
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -306,6 +312,7 @@ services {
   port = 1234
   tags = ["abc"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -319,6 +326,7 @@ services {
   port = 20000
   tags = ["abc"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -360,6 +368,7 @@ services {
   port = 1234
   tags = ["abc","123"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -373,6 +382,7 @@ services {
   port = 20000
   tags = ["abc","123"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -414,6 +424,7 @@ services {
   port = 1234
   tags = ["abc","123"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -427,6 +438,7 @@ services {
   port = 20000
   tags = ["abc","123"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -469,6 +481,7 @@ services {
   port = 1234
   tags = ["abc","123","abc","123","def","456"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -482,6 +495,7 @@ services {
   port = 20000
   tags = ["abc","123","abc","123","def","456"]
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -532,6 +546,7 @@ services {
   address = "${POD_IP}"
   port = 1234
   meta = {
+    external-source = "kubernetes"
     name = "abc"
     version = "2"
     pod-name = "${POD_NAME}"
@@ -546,6 +561,7 @@ services {
   address = "${POD_IP}"
   port = 20000
   meta = {
+    external-source = "kubernetes"
     name = "abc"
     version = "2"
     pod-name = "${POD_NAME}"
@@ -574,6 +590,39 @@ services {
 			"",
 		},
 
+		{
+			"Retained registration",
+			func(pod *corev1.Pod) *corev1.Pod {
+				pod.Annotations[annotationService] = "web"
+				pod.Annotations[annotationRetainRegistration] = "true"
+				return pod
+			},
+			`  meta = {
+    external-source = "kubernetes"
+    retain-registration = "true"
+    pod-name = "${POD_NAME}"
+    k8s-namespace = "${POD_NAMESPACE}"
+  }
+`,
+			`deregister_critical_service_after`,
+		},
+
+		{
+			"External source overridden",
+			func(pod *corev1.Pod) *corev1.Pod {
+				pod.Annotations[annotationService] = "web"
+				pod.Annotations[fmt.Sprintf("%sexternal-source", annotationMeta)] = "vm"
+				return pod
+			},
+			`  meta = {
+    external-source = "vm"
+    pod-name = "${POD_NAME}"
+    k8s-namespace = "${POD_NAMESPACE}"
+  }
+`,
+			`external-source = "kubernetes"`,
+		},
+
 		{
 			"No Metadata specified",
 			func(pod *corev1.Pod) *corev1.Pod {
@@ -581,6 +630,7 @@ services {
 				return pod
 			},
 			`  meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -595,6 +645,7 @@ services {
 				return pod
 			},
 			`  meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -706,6 +757,7 @@ services {
   port = 0
   namespace = "default"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -719,6 +771,7 @@ services {
   port = 20000
   namespace = "default"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -782,6 +835,7 @@ services {
   port = 0
   namespace = "non-default"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -795,6 +849,7 @@ services {
   port = 20000
   namespace = "non-default"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -859,6 +914,7 @@ services {
   port = 0
   namespace = "non-default"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -872,6 +928,7 @@ services {
   port = 20000
   namespace = "non-default"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -945,6 +1002,7 @@ services {
   port = 0
   namespace = "k8snamespace"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -958,6 +1016,7 @@ services {
   port = 20000
   namespace = "k8snamespace"
   meta = {
+    external-source = "kubernetes"
     pod-name = "${POD_NAME}"
     k8s-namespace = "${POD_NAMESPACE}"
   }
@@ -1092,6 +1151,92 @@ cp /bin/consul /consul/connect-inject/consul`,
 	}
 }
 
+// Test that the services, proxies and upstreams are registered in their
+// admin partitions and that the login uses the auth method of the
+// partition if partitions are enabled.
+func TestHandlerContainerInit_partitionsEnabled(t *testing.T) {
+	require := require.New(t)
+	h := Handler{
+		AuthMethod:                 "auth-method",
+		EnableNamespaces:           true,
+		ConsulDestinationNamespace: "non-default",
+		ConsulPartition:            "foo",
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService:   "web",
+				annotationUpstreams: "db.db-ns.bar:1234,cache.cache-ns:2345,api:3456",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      "default-token-podid",
+							ReadOnly:  true,
+							MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+						},
+					},
+				},
+			},
+			ServiceAccountName: "web",
+		},
+	}
+	container, err := h.containerInit(pod, k8sNamespace)
+	require.NoError(err)
+	actual := strings.Join(container.Command, " ")
+
+	// Both the service and its proxy.
+	require.Equal(2, strings.Count(actual, `
+  partition = "foo"
+  namespace = "non-default"`))
+	require.Contains(actual, `
+    upstreams {
+      destination_type = "service" 
+      destination_name = "db"
+      destination_namespace = "db-ns"
+      destination_partition = "bar"
+      local_bind_port = 1234
+    }
+    upstreams {
+      destination_type = "service" 
+      destination_name = "cache"
+      destination_namespace = "cache-ns"
+      local_bind_port = 2345
+    }
+    upstreams {
+      destination_type = "service" 
+      destination_name = "api"
+      local_bind_port = 3456
+    }`)
+	require.Contains(actual, `
+/bin/consul login -method="auth-method" \
+  -bearer-token-file="/var/run/secrets/kubernetes.io/serviceaccount/token" \
+  -token-sink-file="/consul/connect-inject/acl-token" \
+  -partition="foo" \
+  -namespace="non-default" \
+  -meta="pod=${POD_NAMESPACE}/${POD_NAME}"
+chmod 444 /consul/connect-inject/acl-token
+
+/bin/consul services register \
+  -token-file="/consul/connect-inject/acl-token" \
+  -partition="foo" \
+  -namespace="non-default" \
+  /consul/connect-inject/service.hcl
+
+# Generate the envoy bootstrap code
+/bin/consul connect envoy \
+  -proxy-id="${PROXY_SERVICE_ID}" \
+  -token-file="/consul/connect-inject/acl-token" \
+  -partition="foo" \
+  -namespace="non-default" \
+  -bootstrap > /consul/connect-inject/envoy-bootstrap.yaml`)
+}
+
 func TestHandlerContainerInit_authMethod(t *testing.T) {
 	require := require.New(t)
 	h := Handler{
@@ -1179,6 +1324,84 @@ export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"`)
 }
 
+func TestHandlerContainerInit_DashboardURL(t *testing.T) {
+	require := require.New(t)
+	tpl, err := dashboard.ParseURLTemplate("https://dashboard.example.com/#/pod/{{ .Namespace }}/{{ .Name }}?service={{ .ServiceName }}")
+	require.NoError(err)
+	h := Handler{
+		DashboardURLTemplate: tpl,
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService: "foo",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	}
+	container, err := h.containerInit(pod, k8sNamespace)
+	require.NoError(err)
+	actual := strings.Join(container.Command, " ")
+	require.Contains(actual, `  meta = {
+    external-source = "kubernetes"
+    k8s-dashboard-url = "https://dashboard.example.com/#/pod/k8snamespace/${POD_NAME}?service=foo"
+    pod-name = "${POD_NAME}"
+    k8s-namespace = "${POD_NAMESPACE}"
+  }
+`)
+}
+
+func TestHandlerContainerInit_PropagatedMeta(t *testing.T) {
+	require := require.New(t)
+	h := Handler{
+		MetaPropagator: &servicemeta.Propagator{
+			LabelPrefixes:      []string{"app.kubernetes.io/"},
+			AnnotationPrefixes: []string{"example.com/"},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"app.kubernetes.io/version": "1.2.3",
+				"tier":                      "backend",
+			},
+			Annotations: map[string]string{
+				annotationService:                    "foo",
+				"example.com/team":                   "payments",
+				"example.com/owner":                  "someone",
+				annotationMeta + "example_com_owner": "override",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	}
+	container, err := h.containerInit(pod, k8sNamespace)
+	require.NoError(err)
+	actual := strings.Join(container.Command, " ")
+	require.Contains(actual, `  meta = {
+    app_kubernetes_io_version = "1.2.3"
+    example_com_owner = "override"
+    example_com_team = "payments"
+    external-source = "kubernetes"
+    pod-name = "${POD_NAME}"
+    k8s-namespace = "${POD_NAMESPACE}"
+  }
+`)
+}
+
 func TestHandlerContainerInit_Resources(t *testing.T) {
 	require := require.New(t)
 	h := Handler{
@@ -1222,7 +1445,7 @@ func TestHandlerContainerInit_Resources(t *testing.T) {
 	}, container.Resources)
 }
 
-func TestHandlerContainerInit_MismatchedServiceNameServiceAccountNameWithACLsEnabled(t *testing.T) {
+func TestHandlerContainerInit_MultiPortWithACLsEnabled(t *testing.T) {
 	require := require.New(t)
 	h := Handler{
 		AuthMethod: "auth-method",
@@ -1230,21 +1453,22 @@ func TestHandlerContainerInit_MismatchedServiceNameServiceAccountNameWithACLsEna
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Annotations: map[string]string{
-				annotationService: "foo",
+				annotationService: "web,web-admin",
+				annotationPort:    "8080,9090",
 			},
 		},
 		Spec: corev1.PodSpec{
 			Containers: []corev1.Container{
 				{
-					Name: "serviceName",
+					Name: "web",
 				},
 			},
-			ServiceAccountName: "notServiceName",
+			ServiceAccountName: "web",
 		},
 	}
 
 	_, err := h.containerInit(pod, k8sNamespace)
-	require.EqualError(err, `serviceAccountName "notServiceName" does not match service name "foo"`)
+	require.EqualError(err, "multi-port pods are not supported when ACLs are enabled")
 }
 
 func TestHandlerContainerInit_MismatchedServiceNameServiceAccountNameWithACLsDisabled(t *testing.T) {
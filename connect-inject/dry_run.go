@@ -0,0 +1,93 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	applypatch "github.com/evanphx/json-patch"
+	capi "github.com/hashicorp/consul/api"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DryRun runs the pod through the same mutation the webhook would perform
+// for a pod created in the given namespace and returns the mutated pod.
+// It requires neither a Kubernetes cluster nor, if ConsulClient is nil,
+// a Consul agent. When ConsulClient is nil, the checks and writes that
+// would have been made against Consul are skipped and a warning describing
+// each one is returned alongside the pod.
+func (h *Handler) DryRun(pod corev1.Pod, namespace string) (corev1.Pod, []string, error) {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return corev1.Pod{}, nil, err
+	}
+
+	resp := h.Mutate(&v1beta1.AdmissionRequest{
+		Namespace: namespace,
+		Object:    runtime.RawExtension{Raw: raw},
+	})
+	if !resp.Allowed {
+		if resp.Result != nil {
+			return corev1.Pod{}, nil, fmt.Errorf("%s", resp.Result.Message)
+		}
+		return corev1.Pod{}, nil, fmt.Errorf("pod was not allowed")
+	}
+	if len(resp.Patch) == 0 {
+		return pod, nil, nil
+	}
+
+	patch, err := applypatch.DecodePatch(resp.Patch)
+	if err != nil {
+		return corev1.Pod{}, nil, fmt.Errorf("decoding patch: %s", err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		return corev1.Pod{}, nil, fmt.Errorf("applying patch: %s", err)
+	}
+
+	var result corev1.Pod
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return corev1.Pod{}, nil, err
+	}
+
+	var warnings []string
+	if h.ConsulClient == nil && result.Annotations[annotationStatus] == injected {
+		warnings = h.skippedConsulChecks(&result, namespace)
+	}
+	if h.EnableOpenShift && h.KubernetesClient == nil && result.Annotations[annotationStatus] == injected {
+		warnings = append(warnings, fmt.Sprintf(
+			"the user and group of the injected containers aren't set since the %s annotation of namespace %s can't be read without a Kubernetes client",
+			annotationOpenShiftUIDRange, namespace))
+	}
+	if h.EnableNamespaceDefaults && h.KubernetesClient == nil {
+		warnings = append(warnings, fmt.Sprintf(
+			"the annotations of namespace %s aren't used as defaults since they can't be read without a Kubernetes client",
+			namespace))
+	}
+	return result, warnings, nil
+}
+
+// skippedConsulChecks returns a description of each check or write against
+// Consul that Mutate skips for the injected pod because there is no Consul
+// client.
+func (h *Handler) skippedConsulChecks(pod *corev1.Pod, k8sNamespace string) []string {
+	var warnings []string
+	if raw := pod.Annotations[annotationUpstreams]; raw != "" {
+		for _, raw := range strings.Split(raw, ",") {
+			parts := strings.SplitN(raw, ":", 3)
+			if strings.TrimSpace(parts[0]) == "prepared_query" || len(parts) < 3 || strings.TrimSpace(parts[2]) == "" {
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf(
+				"upstream %q references a datacenter: the check that a ProxyDefaults config entry sets "+
+					"mesh gateway mode to %q or %q was skipped", raw, capi.MeshGatewayModeLocal, capi.MeshGatewayModeRemote))
+		}
+	}
+	if h.EnableNamespaces {
+		warnings = append(warnings, fmt.Sprintf(
+			"Consul namespace %q was not checked or created", h.consulNamespace(k8sNamespace)))
+	}
+	return warnings
+}
@@ -0,0 +1,128 @@
+package connectinject
+
+import (
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHandlerDryRun(t *testing.T) {
+	cases := map[string]struct {
+		EnableNamespaces bool
+		Pod              corev1.Pod
+		Namespace        string
+		ExpInjected      bool
+		ExpContainers    []string
+		ExpUpstreamDC    string
+		ExpWarnings      []string
+	}{
+		"injected": {
+			Pod: corev1.Pod{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			},
+			Namespace:     "default",
+			ExpInjected:   true,
+			ExpContainers: []string{"web", "envoy-sidecar", "consul-sidecar"},
+		},
+		"kube-system is not injected": {
+			Pod: corev1.Pod{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			},
+			Namespace:     metav1.NamespaceSystem,
+			ExpInjected:   false,
+			ExpContainers: []string{"web"},
+		},
+		"inject annotation false": {
+			Pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{annotationInject: "false"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			},
+			Namespace:     "default",
+			ExpInjected:   false,
+			ExpContainers: []string{"web"},
+		},
+		"upstream in another datacenter": {
+			Pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{annotationUpstreams: "db:1234:dc2,cache:2345"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			},
+			Namespace:     "default",
+			ExpInjected:   true,
+			ExpContainers: []string{"web", "envoy-sidecar", "consul-sidecar"},
+			ExpUpstreamDC: `datacenter = "dc2"`,
+			ExpWarnings: []string{
+				`upstream "db:1234:dc2" references a datacenter: the check that a ProxyDefaults config entry sets mesh gateway mode to "local" or "remote" was skipped`,
+			},
+		},
+		"namespaces enabled": {
+			EnableNamespaces: true,
+			Pod: corev1.Pod{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			},
+			Namespace:     "default",
+			ExpInjected:   true,
+			ExpContainers: []string{"web", "envoy-sidecar", "consul-sidecar"},
+			ExpWarnings: []string{
+				`Consul namespace "dest" was not checked or created`,
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				Log:                        hclog.Default().Named("handler"),
+				AllowK8sNamespacesSet:      mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:       mapset.NewSet(),
+				ImageConsul:                "consul",
+				ImageEnvoy:                 "envoy",
+				ImageConsulK8S:             "consul-k8s",
+				EnableNamespaces:           c.EnableNamespaces,
+				ConsulDestinationNamespace: "dest",
+			}
+			pod, warnings, err := h.DryRun(c.Pod, c.Namespace)
+			require.NoError(t, err)
+			var names []string
+			for _, container := range pod.Spec.Containers {
+				names = append(names, container.Name)
+			}
+			require.Equal(t, c.ExpContainers, names)
+			require.Equal(t, c.ExpWarnings, warnings)
+			if c.ExpInjected {
+				require.Equal(t, injected, pod.Annotations[annotationStatus])
+				require.Len(t, pod.Spec.InitContainers, 1)
+				if c.ExpUpstreamDC != "" {
+					require.Contains(t, pod.Spec.InitContainers[0].Command[2], c.ExpUpstreamDC)
+				}
+			} else {
+				require.Empty(t, pod.Annotations[annotationStatus])
+			}
+			if c.EnableNamespaces {
+				require.Equal(t, "dest", pod.Annotations[annotationConsulNamespace])
+			}
+		})
+	}
+}
+
+func TestHandlerDryRun_Error(t *testing.T) {
+	h := Handler{
+		Log:                   hclog.Default().Named("handler"),
+		AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:  mapset.NewSet(),
+	}
+	_, _, err := h.DryRun(corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{annotationProtocol: "http"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+	}, "default")
+	require.EqualError(t, err, "Error validating pod: the \"consul.hashicorp.com/connect-service-protocol\" annotation is no longer supported. Instead, create a ServiceDefaults resource (see www.consul.io/docs/k8s/crds/upgrade-to-crds)")
+}
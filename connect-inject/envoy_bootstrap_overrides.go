@@ -0,0 +1,72 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotationEnvoyBootstrapOverrides is a JSON object merged into the
+// Envoy bootstrap of the pod's sidecar proxies, e.g.
+// {"overload_manager": {"refresh_interval": "0.25s"}}. It overrides the
+// injector's -bootstrap-config-overrides flag.
+const annotationEnvoyBootstrapOverrides = "consul.hashicorp.com/envoy-bootstrap-config-overrides"
+
+// envoyBootstrapConfigOverrides returns the config from the pod's annotation
+// or the handler's default that's merged into the bootstrap of its sidecar
+// proxies, or nil if there's none.
+func (h *Handler) envoyBootstrapConfigOverrides(pod *corev1.Pod) (map[string]interface{}, error) {
+	raw := h.EnvoyBootstrapOverrides
+	if anno, ok := pod.Annotations[annotationEnvoyBootstrapOverrides]; ok {
+		raw = anno
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	overrides, err := parseBootstrapConfigOverrides(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s annotation value of %q is invalid: %s", annotationEnvoyBootstrapOverrides, raw, err)
+	}
+	return overrides, nil
+}
+
+// ValidateBootstrapConfigOverrides returns an error if the
+// -bootstrap-config-overrides flag of the injector isn't valid.
+func ValidateBootstrapConfigOverrides(raw string) error {
+	if _, err := parseBootstrapConfigOverrides(raw); err != nil {
+		return fmt.Errorf("-bootstrap-config-overrides is invalid: %s", err)
+	}
+	return nil
+}
+
+// parseBootstrapConfigOverrides parses the JSON object raw. Envoy validates
+// the fields themselves when it starts.
+func parseBootstrapConfigOverrides(raw string) (map[string]interface{}, error) {
+	var overrides map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil || overrides == nil {
+		return nil, fmt.Errorf("must be a JSON object")
+	}
+	return overrides, nil
+}
+
+// mergeBootstrapConfig merges src into dst the way Envoy merges the config
+// of its --config-yaml flag into the bootstrap file: objects are merged,
+// lists are appended to and other values are replaced.
+func mergeBootstrapConfig(dst, src map[string]interface{}) {
+	for key, srcValue := range src {
+		switch srcValue := srcValue.(type) {
+		case map[string]interface{}:
+			if dstValue, ok := dst[key].(map[string]interface{}); ok {
+				mergeBootstrapConfig(dstValue, srcValue)
+				continue
+			}
+		case []interface{}:
+			if dstValue, ok := dst[key].([]interface{}); ok {
+				dst[key] = append(append([]interface{}{}, dstValue...), srcValue...)
+				continue
+			}
+		}
+		dst[key] = srcValue
+	}
+}
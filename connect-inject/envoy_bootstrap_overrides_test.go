@@ -0,0 +1,110 @@
+package connectinject
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHandlerEnvoySidecar_BootstrapConfigOverrides(t *testing.T) {
+	cases := map[string]struct {
+		handler     Handler
+		annotations map[string]string
+		expCommand  []string
+		expErr      string
+	}{
+		"no overrides": {
+			expCommand: []string{
+				"envoy",
+				"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+			},
+		},
+		"default": {
+			handler: Handler{EnvoyBootstrapOverrides: `{"overload_manager": {"refresh_interval": "0.25s"}}`},
+			expCommand: []string{
+				"envoy",
+				"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+				"--config-yaml", `{"overload_manager":{"refresh_interval":"0.25s"}}`,
+			},
+		},
+		"annotation overrides the default": {
+			handler: Handler{EnvoyBootstrapOverrides: `{"overload_manager": {"refresh_interval": "0.25s"}}`},
+			annotations: map[string]string{
+				annotationEnvoyBootstrapOverrides: `{"stats_flush_interval": "10s"}`,
+			},
+			expCommand: []string{
+				"envoy",
+				"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+				"--config-yaml", `{"stats_flush_interval":"10s"}`,
+			},
+		},
+		"merged with the tracing config": {
+			handler: Handler{
+				EnvoyTracingProvider:      "zipkin",
+				EnvoyTracingCollectorAddr: "zipkin:9411",
+				EnvoyTracingSampleRate:    12.5,
+			},
+			annotations: map[string]string{
+				annotationEnvoyBootstrapOverrides: `{"layered_runtime": {"layers": [{"name": "admin", "admin_layer": {}}]}}`,
+			},
+			expCommand: []string{
+				"envoy",
+				"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+				"--config-yaml", `{"layered_runtime":{"layers":[{"name":"consul_k8s_tracing","static_layer":{"tracing.random_sampling":1250}},{"admin_layer":{},"name":"admin"}]}}`,
+			},
+		},
+		"invalid annotation": {
+			annotations: map[string]string{
+				annotationEnvoyBootstrapOverrides: `overload_manager: {}`,
+			},
+			expErr: "consul.hashicorp.com/envoy-bootstrap-config-overrides annotation value of \"overload_manager: {}\" is invalid: must be a JSON object",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := c.handler
+			h.ImageEnvoy = "envoy:latest"
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations}}
+			container, err := h.envoySidecar(pod, k8sNamespace)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expCommand, container.Command)
+		})
+	}
+}
+
+func TestMergeBootstrapConfig(t *testing.T) {
+	dst := map[string]interface{}{
+		"stats_sinks":          []interface{}{"a"},
+		"stats_flush_interval": "5s",
+		"admin":                map[string]interface{}{"access_log_path": "/dev/null"},
+	}
+	mergeBootstrapConfig(dst, map[string]interface{}{
+		"stats_sinks":          []interface{}{"b"},
+		"stats_flush_interval": "10s",
+		"admin":                map[string]interface{}{"profile_path": "/tmp/envoy.prof"},
+		"overload_manager":     map[string]interface{}{"refresh_interval": "0.25s"},
+	})
+	require.Equal(t, map[string]interface{}{
+		"stats_sinks":          []interface{}{"a", "b"},
+		"stats_flush_interval": "10s",
+		"admin": map[string]interface{}{
+			"access_log_path": "/dev/null",
+			"profile_path":    "/tmp/envoy.prof",
+		},
+		"overload_manager": map[string]interface{}{"refresh_interval": "0.25s"},
+	}, dst)
+}
+
+func TestValidateBootstrapConfigOverrides(t *testing.T) {
+	require.NoError(t, ValidateBootstrapConfigOverrides(`{"overload_manager": {}}`))
+	for _, raw := range []string{"", "null", "[]", `"foo"`, "{"} {
+		require.EqualError(t, ValidateBootstrapConfigOverrides(raw),
+			"-bootstrap-config-overrides is invalid: must be a JSON object", raw)
+	}
+}
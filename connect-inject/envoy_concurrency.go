@@ -0,0 +1,54 @@
+package connectinject
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotationEnvoyConcurrency is the number of worker threads of the sidecar
+// proxies. It defaults to the sidecar's CPU limit rounded up to a whole
+// number of cores or, if there's no CPU limit, to Envoy's default of one
+// thread per core of the node.
+const annotationEnvoyConcurrency = "consul.hashicorp.com/envoy-concurrency"
+
+// envoyConcurrency returns the value of Envoy's --concurrency flag for the
+// sidecars of the pod, or 0 if it shouldn't be set.
+//
+// Envoy starts a worker thread per core of the node by default, which
+// oversubscribes the sidecars of small pods since their CPU limit throttles
+// all the threads together.
+func (h *Handler) envoyConcurrency(pod *corev1.Pod) (int, error) {
+	if raw, ok := pod.Annotations[annotationEnvoyConcurrency]; ok {
+		concurrency, err := strconv.Atoi(raw)
+		if err != nil || concurrency < 1 {
+			return 0, fmt.Errorf("%s annotation %q is invalid: must be a positive number of threads", annotationEnvoyConcurrency, raw)
+		}
+		return concurrency, nil
+	}
+
+	resources, err := h.envoySidecarResources(pod)
+	if err != nil {
+		return 0, err
+	}
+	limit, ok := resources.Limits[corev1.ResourceCPU]
+	if !ok || limit.IsZero() {
+		return 0, nil
+	}
+	// MilliValue rounds up so that e.g. a limit of 1.5 cores gets two threads.
+	return int((limit.MilliValue() + 999) / 1000), nil
+}
+
+// hasEnvoyArg returns true if flag, e.g. --concurrency, is one of the Envoy
+// arguments, in which case it takes precedence over the value the injector
+// would set since Envoy fails to start if a flag is set twice.
+func hasEnvoyArg(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
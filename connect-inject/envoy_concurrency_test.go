@@ -0,0 +1,72 @@
+package connectinject
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test that the sidecars' --concurrency defaults to their CPU limit rounded
+// up, and that it's overridden by the annotation and the extra args.
+func TestHandlerEnvoySidecar_Concurrency(t *testing.T) {
+	cases := map[string]struct {
+		handler     Handler
+		annotations map[string]string
+		expArgs     []string
+		expErr      string
+	}{
+		"no CPU limit": {},
+		"default CPU limit": {
+			handler: Handler{DefaultProxyCPULimit: resource.MustParse("100m")},
+			expArgs: []string{"--concurrency", "1"},
+		},
+		"CPU limit rounded up": {
+			annotations: map[string]string{annotationSidecarProxyCPULimit: "1500m"},
+			expArgs:     []string{"--concurrency", "2"},
+		},
+		"whole CPU limit": {
+			handler:     Handler{DefaultProxyCPULimit: resource.MustParse("100m")},
+			annotations: map[string]string{annotationSidecarProxyCPULimit: "4"},
+			expArgs:     []string{"--concurrency", "4"},
+		},
+		"zero CPU limit": {
+			annotations: map[string]string{annotationSidecarProxyCPULimit: "0"},
+		},
+		"annotation": {
+			handler:     Handler{DefaultProxyCPULimit: resource.MustParse("100m")},
+			annotations: map[string]string{annotationEnvoyConcurrency: "3"},
+			expArgs:     []string{"--concurrency", "3"},
+		},
+		"extra args flag": {
+			handler: Handler{DefaultProxyCPULimit: resource.MustParse("100m"), EnvoyExtraArgs: "--concurrency 8"},
+			expArgs: []string{"--concurrency", "8"},
+		},
+		"extra args annotation": {
+			annotations: map[string]string{
+				annotationEnvoyConcurrency: "3",
+				annotationEnvoyExtraArgs:   "--concurrency=8",
+			},
+			expArgs: []string{"--concurrency=8"},
+		},
+		"invalid annotation": {
+			annotations: map[string]string{annotationEnvoyConcurrency: "0"},
+			expErr:      `consul.hashicorp.com/envoy-concurrency annotation "0" is invalid: must be a positive number of threads`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations}}
+			container, err := c.handler.envoySidecar(pod, k8sNamespace)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			exp := append([]string{"envoy", "--config-path", "/consul/connect-inject/envoy-bootstrap.yaml"}, c.expArgs...)
+			require.Equal(t, exp, container.Command)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package connectinject
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotationEnvoyDrainTime is how long the sidecar proxies drain their
+	// inbound connections when the pod is deleted, e.g. "30s", so that the
+	// in-flight requests complete during rolling deploys. It defaults to
+	// the -default-envoy-drain-time flag. The proxies don't drain if it's 0.
+	annotationEnvoyDrainTime = "consul.hashicorp.com/envoy-drain-time"
+
+	// terminationGracePeriodBuffer is how much longer than the drain time
+	// the pod's termination grace period must be so that the sidecars have
+	// time to exit once the proxies have drained.
+	terminationGracePeriodBuffer = 5 * time.Second
+
+	// defaultTerminationGracePeriod is Kubernetes' termination grace period
+	// of the pods that don't set one.
+	defaultTerminationGracePeriod = 30 * time.Second
+)
+
+// envoyDrainTime returns how long the sidecar proxies of the pod drain when
+// it's deleted, or 0 if they don't.
+//
+// When the pod is deleted, the consul-sidecar starts draining the inbound
+// listeners of the proxies through their admin API while the proxies'
+// preStop hook deregisters the services and then waits for the drain time
+// before Kubernetes stops them.
+func (h *Handler) envoyDrainTime(pod *corev1.Pod) (time.Duration, error) {
+	// The sidecars of Jobs exit once the Job's containers have, so there's
+	// nothing to drain.
+	if job, err := isJobPod(pod); err != nil || job {
+		return 0, err
+	}
+	raw, ok := pod.Annotations[annotationEnvoyDrainTime]
+	if !ok {
+		return h.DefaultEnvoyDrainTime, nil
+	}
+	drainTime, err := time.ParseDuration(raw)
+	if err != nil || drainTime < 0 {
+		return 0, fmt.Errorf("%s annotation %q is invalid: must be a non-negative duration, e.g. \"30s\"", annotationEnvoyDrainTime, raw)
+	}
+	return drainTime, nil
+}
+
+// drainSeconds returns the drain time rounded up to whole seconds, the
+// precision of Envoy's --drain-time-s flag and of the preStop hook's sleep.
+func drainSeconds(drainTime time.Duration) int {
+	return int(math.Ceil(drainTime.Seconds()))
+}
+
+// drainLifecycle returns the preStop hook of the sidecar proxies that have
+// nothing to deregister, which waits for the proxy to drain, or nil if it
+// doesn't drain.
+func drainLifecycle(drainTime time.Duration) *corev1.Lifecycle {
+	if drainTime == 0 {
+		return nil
+	}
+	return &corev1.Lifecycle{
+		PreStop: &corev1.Handler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/sh", "-ec", "sleep " + strconv.Itoa(drainSeconds(drainTime))},
+			},
+		},
+	}
+}
+
+// terminationGracePeriod returns the termination grace period in seconds
+// the pod needs for its sidecar proxies to drain, and whether it's longer
+// than the pod's own, in which case it has to be raised or Kubernetes would
+// kill the proxies while they're draining.
+func terminationGracePeriod(pod *corev1.Pod, drainTime time.Duration) (int64, bool) {
+	if drainTime == 0 {
+		return 0, false
+	}
+	current := defaultTerminationGracePeriod
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		current = time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	needed := time.Duration(drainSeconds(drainTime))*time.Second + terminationGracePeriodBuffer
+	if current >= needed {
+		return 0, false
+	}
+	return int64(needed.Seconds()), true
+}
+
+// envoyAdminAddrs returns the addresses of the Envoy admin APIs of the pod's
+// sidecar proxies, which the consul-sidecar drains.
+func envoyAdminAddrs(services []podService) []string {
+	if !multiPort(services) {
+		return []string{fmt.Sprintf("127.0.0.1:%d", proxyAdminPort)}
+	}
+	var addrs []string
+	for i := range services {
+		addrs = append(addrs, fmt.Sprintf("127.0.0.1:%d", proxyAdminPort+i))
+	}
+	return addrs
+}
@@ -0,0 +1,265 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattbaird/jsonpatch"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test that the sidecars drain for the default drain time unless the pod
+// overrides it, and that Envoy's --drain-time-s and the preStop hook's sleep
+// match it.
+func TestHandlerEnvoySidecar_DrainTime(t *testing.T) {
+	cases := map[string]struct {
+		handler     Handler
+		annotations map[string]string
+		expArgs     []string
+		expPreStop  string
+		expErr      string
+	}{
+		"no drain time": {
+			expPreStop: `/bin/sh -ec /consul/connect-inject/consul services deregister \
+  /consul/connect-inject/service.hcl`,
+		},
+		"default drain time": {
+			handler: Handler{DefaultEnvoyDrainTime: 30 * time.Second},
+			expArgs: []string{"--drain-time-s", "30"},
+			expPreStop: `/bin/sh -ec /consul/connect-inject/consul services deregister \
+  /consul/connect-inject/service.hcl
+sleep 30`,
+		},
+		"annotation rounded up": {
+			handler:     Handler{DefaultEnvoyDrainTime: 30 * time.Second},
+			annotations: map[string]string{annotationEnvoyDrainTime: "1500ms"},
+			expArgs:     []string{"--drain-time-s", "2"},
+			expPreStop: `/bin/sh -ec /consul/connect-inject/consul services deregister \
+  /consul/connect-inject/service.hcl
+sleep 2`,
+		},
+		"zero annotation": {
+			handler:     Handler{DefaultEnvoyDrainTime: 30 * time.Second},
+			annotations: map[string]string{annotationEnvoyDrainTime: "0s"},
+			expPreStop: `/bin/sh -ec /consul/connect-inject/consul services deregister \
+  /consul/connect-inject/service.hcl`,
+		},
+		"extra args": {
+			handler:     Handler{EnvoyExtraArgs: "--drain-time-s=5"},
+			annotations: map[string]string{annotationEnvoyDrainTime: "10s"},
+			expArgs:     []string{"--drain-time-s=5"},
+			expPreStop: `/bin/sh -ec /consul/connect-inject/consul services deregister \
+  /consul/connect-inject/service.hcl
+sleep 10`,
+		},
+		"with auth method": {
+			handler: Handler{AuthMethod: "auth-method", DefaultEnvoyDrainTime: 30 * time.Second},
+			expArgs: []string{"--drain-time-s", "30"},
+			expPreStop: `/bin/sh -ec /consul/connect-inject/consul services deregister \
+  -token-file="/consul/connect-inject/acl-token" \
+  /consul/connect-inject/service.hcl
+sleep 30
+/consul/connect-inject/consul logout \
+  -token-file="/consul/connect-inject/acl-token"`,
+		},
+		"invalid annotation": {
+			annotations: map[string]string{annotationEnvoyDrainTime: "-1s"},
+			expErr:      `consul.hashicorp.com/envoy-drain-time annotation "-1s" is invalid: must be a non-negative duration, e.g. "30s"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			annotations := map[string]string{annotationService: "foo"}
+			for k, v := range c.annotations {
+				annotations[k] = v
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			}
+			container, err := c.handler.envoySidecar(pod, k8sNamespace)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			exp := append([]string{"envoy", "--config-path", "/consul/connect-inject/envoy-bootstrap.yaml"}, c.expArgs...)
+			require.Equal(t, exp, container.Command)
+			require.Equal(t, c.expPreStop, strings.Join(container.Lifecycle.PreStop.Exec.Command, " "))
+		})
+	}
+}
+
+// Test that the sidecars that don't deregister the services still wait for
+// their proxy to drain, and that the sidecars of Jobs don't wait.
+func TestHandlerEnvoySidecars_DrainTime(t *testing.T) {
+	sleepOnly := &corev1.Lifecycle{
+		PreStop: &corev1.Handler{
+			Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-ec", "sleep 10"}},
+		},
+	}
+	// sidecar is the index of the sidecar whose preStop hook is checked.
+	cases := map[string]struct {
+		annotations  map[string]string
+		sidecar      int
+		expLifecycle *corev1.Lifecycle
+	}{
+		"multi-port": {
+			annotations: map[string]string{
+				annotationService: "web,web-admin",
+				annotationPort:    "8080,9090",
+			},
+			sidecar:      1,
+			expLifecycle: sleepOnly,
+		},
+		"retained registration": {
+			annotations: map[string]string{
+				annotationService:            "web",
+				annotationRetainRegistration: "true",
+			},
+			expLifecycle: sleepOnly,
+		},
+		"job": {
+			annotations: map[string]string{
+				annotationService: "web",
+				annotationJob:     "true",
+			},
+			expLifecycle: &corev1.Lifecycle{
+				PreStop: &corev1.Handler{
+					Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-ec", `/consul/connect-inject/consul services deregister \
+  /consul/connect-inject/service.hcl`}},
+				},
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{DefaultEnvoyDrainTime: 10 * time.Second}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			}
+			containers, err := h.envoySidecars(pod, k8sNamespace)
+			require.NoError(t, err)
+			require.Equal(t, c.expLifecycle, containers[c.sidecar].Lifecycle)
+		})
+	}
+}
+
+// Test that the consul-sidecar drains the admin API of each sidecar proxy.
+func TestConsulSidecar_DrainEnvoys(t *testing.T) {
+	cases := map[string]struct {
+		drainTime   time.Duration
+		annotations map[string]string
+		expFlags    []string
+	}{
+		"no drain time": {
+			annotations: map[string]string{annotationService: "web"},
+		},
+		"single service": {
+			drainTime:   10 * time.Second,
+			annotations: map[string]string{annotationService: "web"},
+			expFlags:    []string{"-drain-envoy-admin-addr=127.0.0.1:19000"},
+		},
+		"multi-port": {
+			drainTime: 10 * time.Second,
+			annotations: map[string]string{
+				annotationService: "web,web-admin",
+				annotationPort:    "8080,9090",
+			},
+			expFlags: []string{
+				"-drain-envoy-admin-addr=127.0.0.1:19000",
+				"-drain-envoy-admin-addr=127.0.0.1:19001",
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				Log:                    hclog.NewNullLogger(),
+				ImageConsulK8S:         "hashicorp/consul-k8s:9.9.9",
+				ConsulSidecarResources: consulSidecarResources,
+				DefaultEnvoyDrainTime:  c.drainTime,
+			}
+			container := h.consulSidecar(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			})
+			var flags []string
+			for _, arg := range container.Command {
+				if strings.HasPrefix(arg, "-drain-envoy-admin-addr=") {
+					flags = append(flags, arg)
+				}
+			}
+			require.Equal(t, c.expFlags, flags)
+		})
+	}
+}
+
+// Test that the pods' termination grace period is raised to cover the drain
+// time, but never lowered.
+func TestHandlerHandle_TerminationGracePeriod(t *testing.T) {
+	cases := map[string]struct {
+		drainTime   time.Duration
+		gracePeriod *int64
+		expPatch    interface{}
+	}{
+		"no drain time": {},
+		"within the default grace period": {
+			drainTime: 25 * time.Second,
+		},
+		"longer than the default grace period": {
+			drainTime: 45 * time.Second,
+			expPatch:  float64(50),
+		},
+		"longer than the pod's grace period": {
+			drainTime:   10 * time.Second,
+			gracePeriod: int64Ptr(10),
+			expPatch:    float64(15),
+		},
+		"within the pod's grace period": {
+			drainTime:   45 * time.Second,
+			gracePeriod: int64Ptr(120),
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				Log:                   hclog.NewNullLogger(),
+				AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:  mapset.NewSet(),
+				DefaultEnvoyDrainTime: c.drainTime,
+			}
+			resp := h.Mutate(&v1beta1.AdmissionRequest{
+				Object: encodeRaw(t, &corev1.Pod{
+					Spec: corev1.PodSpec{
+						Containers:                    []corev1.Container{{Name: "web"}},
+						TerminationGracePeriodSeconds: c.gracePeriod,
+					},
+				}),
+			})
+			require.True(t, resp.Allowed, resp.Result)
+
+			var patches []jsonpatch.JsonPatchOperation
+			require.NoError(t, json.Unmarshal(resp.Patch, &patches))
+			var gracePeriod interface{}
+			for _, patch := range patches {
+				if patch.Path == "/spec/terminationGracePeriodSeconds" {
+					gracePeriod = patch.Value
+				}
+			}
+			require.Equal(t, c.expPatch, gracePeriod)
+		})
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
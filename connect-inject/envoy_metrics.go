@@ -0,0 +1,246 @@
+package connectinject
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotationEnableMetrics controls whether the sidecar proxy exposes its
+	// metrics for Prometheus to scrape. This should be set to a truthy or
+	// falsy value, as parseable by strconv.ParseBool. It overrides the
+	// injector's -default-enable-metrics flag.
+	annotationEnableMetrics = "consul.hashicorp.com/enable-metrics"
+
+	// annotationEnableMetricsMerging controls whether the proxy's metrics
+	// are merged with the service's own metrics into a single endpoint,
+	// served by the consul-sidecar container. This should be set to a
+	// truthy or falsy value, as parseable by strconv.ParseBool. It
+	// overrides the injector's -default-enable-metrics-merging flag.
+	annotationEnableMetricsMerging = "consul.hashicorp.com/enable-metrics-merging"
+
+	// annotationMergedMetricsPort is the port that the proxy serves its
+	// metrics on to the consul-sidecar when merging. It's only bound to
+	// localhost. It overrides the injector's -default-merged-metrics-port
+	// flag.
+	annotationMergedMetricsPort = "consul.hashicorp.com/merged-metrics-port"
+
+	// annotationPrometheusScrapePort and annotationPrometheusScrapePath are
+	// the port and path that Prometheus scrapes the metrics of the pod on.
+	// They override the injector's -default-prometheus-scrape-port and
+	// -default-prometheus-scrape-path flags.
+	annotationPrometheusScrapePort = "consul.hashicorp.com/prometheus-scrape-port"
+	annotationPrometheusScrapePath = "consul.hashicorp.com/prometheus-scrape-path"
+
+	// annotationServiceMetricsPort and annotationServiceMetricsPath are the
+	// port, which can be named, and path of the service's own metrics that
+	// are merged with the proxy's. The port defaults to the service's port
+	// and the path to "/metrics".
+	annotationServiceMetricsPort = "consul.hashicorp.com/service-metrics-port"
+	annotationServiceMetricsPath = "consul.hashicorp.com/service-metrics-path"
+
+	// annotationPrometheusScrape, annotationPrometheusPort and
+	// annotationPrometheusPath are the annotations that Prometheus'
+	// Kubernetes pod discovery is commonly configured to scrape pods by.
+	// They're set on the pods whose metrics are enabled.
+	annotationPrometheusScrape = "prometheus.io/scrape"
+	annotationPrometheusPort   = "prometheus.io/port"
+	annotationPrometheusPath   = "prometheus.io/path"
+)
+
+const (
+	// envoyMetricsPath is the only path that Consul's Prometheus listener on
+	// the proxy serves the metrics on.
+	envoyMetricsPath = "/metrics"
+
+	// defaultMergedMetricsPort and defaultPrometheusScrapePort are used if
+	// the handler's defaults aren't set.
+	defaultMergedMetricsPort    = 20100
+	defaultPrometheusScrapePort = 20200
+)
+
+// envoyMetrics is the metrics configuration of the sidecar proxy of a pod.
+// Only the proxy of the first service of a multi-port pod exposes its
+// metrics, since they'd otherwise all need their own ports.
+type envoyMetrics struct {
+	merging    bool
+	mergedPort int
+	scrapePort int
+	scrapePath string
+	// servicePort is the port of the service's metrics when merging, or 0
+	// if the service has no port, in which case only the proxy's metrics
+	// are served.
+	servicePort int
+	servicePath string
+}
+
+// envoyMetrics returns the metrics configuration of the pod's sidecar proxy
+// from its annotations and the handler's defaults, or nil if metrics aren't
+// enabled.
+func (h *Handler) envoyMetrics(pod *corev1.Pod) (*envoyMetrics, error) {
+	enabled, err := boolAnnotation(pod, annotationEnableMetrics, h.DefaultEnableMetrics)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, nil
+	}
+	merging, err := boolAnnotation(pod, annotationEnableMetricsMerging, h.DefaultEnableMetricsMerging)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &envoyMetrics{
+		merging:    merging,
+		mergedPort: h.DefaultMergedMetricsPort,
+		scrapePort: h.DefaultPrometheusScrapePort,
+		scrapePath: h.DefaultPrometheusScrapePath,
+	}
+	if m.mergedPort == 0 {
+		m.mergedPort = defaultMergedMetricsPort
+	}
+	if m.scrapePort == 0 {
+		m.scrapePort = defaultPrometheusScrapePort
+	}
+	if m.scrapePath == "" {
+		m.scrapePath = envoyMetricsPath
+	}
+	if err := portAnnotation(pod, annotationMergedMetricsPort, &m.mergedPort); err != nil {
+		return nil, err
+	}
+	if err := portAnnotation(pod, annotationPrometheusScrapePort, &m.scrapePort); err != nil {
+		return nil, err
+	}
+	if raw, ok := pod.Annotations[annotationPrometheusScrapePath]; ok {
+		if !strings.HasPrefix(raw, "/") {
+			return nil, fmt.Errorf("%s annotation value of %q is invalid: must start with a /", annotationPrometheusScrapePath, raw)
+		}
+		m.scrapePath = raw
+	}
+	if !merging {
+		if m.scrapePath != envoyMetricsPath {
+			return nil, fmt.Errorf("the Prometheus scrape path must be %q unless metrics merging is enabled, not %q",
+				envoyMetricsPath, m.scrapePath)
+		}
+		return m, nil
+	}
+	if m.mergedPort == m.scrapePort {
+		return nil, fmt.Errorf("the merged metrics port and the Prometheus scrape port must differ, both are %d", m.scrapePort)
+	}
+
+	m.servicePath = envoyMetricsPath
+	if raw, ok := pod.Annotations[annotationServiceMetricsPath]; ok {
+		if !strings.HasPrefix(raw, "/") {
+			return nil, fmt.Errorf("%s annotation value of %q is invalid: must start with a /", annotationServiceMetricsPath, raw)
+		}
+		m.servicePath = raw
+	}
+	servicePort, ok := pod.Annotations[annotationServiceMetricsPort]
+	if !ok {
+		// The port annotations have been validated by podServices.
+		services, _ := podServices(pod)
+		if len(services) == 0 || services[0].port == "" {
+			return m, nil
+		}
+		servicePort = services[0].port
+	}
+	port, err := portValue(pod, servicePort)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("%s annotation value of %q is invalid: must be a port number or the name of a container port",
+			annotationServiceMetricsPort, servicePort)
+	}
+	m.servicePort = int(port)
+	return m, nil
+}
+
+// ValidateEnvoyMetrics returns an error if the -default-*-metrics-* and
+// -default-prometheus-* flags of the injector aren't valid.
+func ValidateEnvoyMetrics(merging bool, mergedPort, scrapePort int, scrapePath string) error {
+	if mergedPort <= 0 || mergedPort > 65535 {
+		return fmt.Errorf("-default-merged-metrics-port must be between 1 and 65535")
+	}
+	if scrapePort <= 0 || scrapePort > 65535 {
+		return fmt.Errorf("-default-prometheus-scrape-port must be between 1 and 65535")
+	}
+	if !strings.HasPrefix(scrapePath, "/") {
+		return fmt.Errorf("-default-prometheus-scrape-path must start with a /")
+	}
+	if !merging && scrapePath != envoyMetricsPath {
+		return fmt.Errorf("-default-prometheus-scrape-path must be %q unless -default-enable-metrics-merging is set", envoyMetricsPath)
+	}
+	if merging && mergedPort == scrapePort {
+		return fmt.Errorf("-default-merged-metrics-port and -default-prometheus-scrape-port must differ")
+	}
+	return nil
+}
+
+// prometheusBindAddr returns the envoy_prometheus_bind_addr proxy config,
+// which is only reachable by the consul-sidecar when merging.
+func (m *envoyMetrics) prometheusBindAddr() string {
+	if m.merging {
+		return fmt.Sprintf("127.0.0.1:%d", m.mergedPort)
+	}
+	return fmt.Sprintf("0.0.0.0:%d", m.scrapePort)
+}
+
+// consulSidecarFlags returns the flags of the consul-sidecar command that
+// make it serve the merged metrics, if merging.
+func (m *envoyMetrics) consulSidecarFlags() []string {
+	if !m.merging {
+		return nil
+	}
+	flags := []string{
+		"-enable-metrics-merging=true",
+		fmt.Sprintf("-merged-metrics-port=%d", m.mergedPort),
+		fmt.Sprintf("-prometheus-scrape-port=%d", m.scrapePort),
+		"-prometheus-scrape-path=" + m.scrapePath,
+	}
+	if m.servicePort > 0 {
+		flags = append(flags,
+			fmt.Sprintf("-service-metrics-port=%d", m.servicePort),
+			"-service-metrics-path="+m.servicePath)
+	}
+	return flags
+}
+
+// prometheusAnnotations returns the annotations that let Prometheus find
+// the metrics of the pod.
+func (m *envoyMetrics) prometheusAnnotations() map[string]string {
+	return map[string]string{
+		annotationPrometheusScrape: "true",
+		annotationPrometheusPort:   strconv.Itoa(m.scrapePort),
+		annotationPrometheusPath:   m.scrapePath,
+	}
+}
+
+// boolAnnotation returns the value of the bool annotation key of pod, or def
+// if it isn't set.
+func boolAnnotation(pod *corev1.Pod, key string, def bool) (bool, error) {
+	raw, ok := pod.Annotations[key]
+	if !ok {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s annotation value of %q is invalid: must be a boolean", key, raw)
+	}
+	return v, nil
+}
+
+// portAnnotation sets port to the value of the port number annotation key of
+// pod, if it's set.
+func portAnnotation(pod *corev1.Pod, key string, port *int) error {
+	raw, ok := pod.Annotations[key]
+	if !ok {
+		return nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 || v > 65535 {
+		return fmt.Errorf("%s annotation value of %q is invalid: must be a port number", key, raw)
+	}
+	*port = v
+	return nil
+}
@@ -0,0 +1,311 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattbaird/jsonpatch"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHandlerEnvoyMetrics(t *testing.T) {
+	defaults := Handler{
+		DefaultMergedMetricsPort:    20100,
+		DefaultPrometheusScrapePort: 20200,
+		DefaultPrometheusScrapePath: "/metrics",
+	}
+	withDefaults := func(h Handler) Handler {
+		h.DefaultMergedMetricsPort = defaults.DefaultMergedMetricsPort
+		h.DefaultPrometheusScrapePort = defaults.DefaultPrometheusScrapePort
+		h.DefaultPrometheusScrapePath = defaults.DefaultPrometheusScrapePath
+		return h
+	}
+	cases := map[string]struct {
+		handler     Handler
+		annotations map[string]string
+		ports       []corev1.ContainerPort
+		exp         *envoyMetrics
+		expErr      string
+	}{
+		"disabled": {
+			handler: defaults,
+		},
+		"flags": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true}),
+			exp:     &envoyMetrics{mergedPort: 20100, scrapePort: 20200, scrapePath: "/metrics"},
+		},
+		"handler without defaults": {
+			handler: Handler{DefaultEnableMetrics: true},
+			exp:     &envoyMetrics{mergedPort: 20100, scrapePort: 20200, scrapePath: "/metrics"},
+		},
+		"annotation enables metrics": {
+			handler:     defaults,
+			annotations: map[string]string{annotationEnableMetrics: "true"},
+			exp:         &envoyMetrics{mergedPort: 20100, scrapePort: 20200, scrapePath: "/metrics"},
+		},
+		"annotation disables metrics": {
+			handler:     withDefaults(Handler{DefaultEnableMetrics: true}),
+			annotations: map[string]string{annotationEnableMetrics: "false"},
+		},
+		"merging with the service port": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true, DefaultEnableMetricsMerging: true}),
+			annotations: map[string]string{
+				annotationPort:                 "http",
+				annotationPrometheusScrapePath: "/merged",
+			},
+			ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+			exp: &envoyMetrics{merging: true, mergedPort: 20100, scrapePort: 20200, scrapePath: "/merged",
+				servicePort: 8080, servicePath: "/metrics"},
+		},
+		"merging with the service metrics annotations": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true}),
+			annotations: map[string]string{
+				annotationEnableMetricsMerging: "true",
+				annotationMergedMetricsPort:    "30100",
+				annotationPrometheusScrapePort: "30200",
+				annotationPort:                 "8080",
+				annotationServiceMetricsPort:   "metrics",
+				annotationServiceMetricsPath:   "/stats",
+			},
+			ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9102}},
+			exp: &envoyMetrics{merging: true, mergedPort: 30100, scrapePort: 30200, scrapePath: "/metrics",
+				servicePort: 9102, servicePath: "/stats"},
+		},
+		"merging without a service port": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true, DefaultEnableMetricsMerging: true}),
+			exp:     &envoyMetrics{merging: true, mergedPort: 20100, scrapePort: 20200, scrapePath: "/metrics", servicePath: "/metrics"},
+		},
+		"invalid enable metrics": {
+			handler:     defaults,
+			annotations: map[string]string{annotationEnableMetrics: "yes please"},
+			expErr:      `consul.hashicorp.com/enable-metrics annotation value of "yes please" is invalid: must be a boolean`,
+		},
+		"invalid scrape port": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true}),
+			annotations: map[string]string{
+				annotationPrometheusScrapePort: "70000",
+			},
+			expErr: `consul.hashicorp.com/prometheus-scrape-port annotation value of "70000" is invalid: must be a port number`,
+		},
+		"invalid scrape path": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true}),
+			annotations: map[string]string{
+				annotationPrometheusScrapePath: "metrics",
+			},
+			expErr: `consul.hashicorp.com/prometheus-scrape-path annotation value of "metrics" is invalid: must start with a /`,
+		},
+		"scrape path without merging": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true}),
+			annotations: map[string]string{
+				annotationPrometheusScrapePath: "/merged",
+			},
+			expErr: `the Prometheus scrape path must be "/metrics" unless metrics merging is enabled, not "/merged"`,
+		},
+		"same merged and scrape ports": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true, DefaultEnableMetricsMerging: true}),
+			annotations: map[string]string{
+				annotationMergedMetricsPort: "20200",
+			},
+			expErr: "the merged metrics port and the Prometheus scrape port must differ, both are 20200",
+		},
+		"unknown service metrics port": {
+			handler: withDefaults(Handler{DefaultEnableMetrics: true, DefaultEnableMetricsMerging: true}),
+			annotations: map[string]string{
+				annotationServiceMetricsPort: "metrics",
+			},
+			expErr: `consul.hashicorp.com/service-metrics-port annotation value of "metrics" is invalid: ` +
+				`must be a port number or the name of a container port`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			metrics, err := c.handler.envoyMetrics(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "web", Ports: c.ports}},
+				},
+			})
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, metrics)
+		})
+	}
+}
+
+func TestValidateEnvoyMetrics(t *testing.T) {
+	require.NoError(t, ValidateEnvoyMetrics(false, 20100, 20200, "/metrics"))
+	require.NoError(t, ValidateEnvoyMetrics(true, 20100, 20200, "/merged"))
+	require.EqualError(t, ValidateEnvoyMetrics(false, 0, 20200, "/metrics"),
+		"-default-merged-metrics-port must be between 1 and 65535")
+	require.EqualError(t, ValidateEnvoyMetrics(false, 20100, 70000, "/metrics"),
+		"-default-prometheus-scrape-port must be between 1 and 65535")
+	require.EqualError(t, ValidateEnvoyMetrics(true, 20100, 20200, "merged"),
+		"-default-prometheus-scrape-path must start with a /")
+	require.EqualError(t, ValidateEnvoyMetrics(false, 20100, 20200, "/merged"),
+		`-default-prometheus-scrape-path must be "/metrics" unless -default-enable-metrics-merging is set`)
+	require.EqualError(t, ValidateEnvoyMetrics(true, 20200, 20200, "/metrics"),
+		"-default-merged-metrics-port and -default-prometheus-scrape-port must differ")
+}
+
+// Test that the proxy serves its metrics on the scrape port without merging
+// and only on localhost with merging, for the consul-sidecar to merge them.
+func TestHandlerContainerInit_EnvoyMetrics(t *testing.T) {
+	cases := map[string]struct {
+		merging bool
+		tracing bool
+		exp     string
+	}{
+		"metrics": {
+			exp: `
+    config {
+      envoy_prometheus_bind_addr = "0.0.0.0:20200"
+    }`,
+		},
+		"merged metrics": {
+			merging: true,
+			exp: `
+    config {
+      envoy_prometheus_bind_addr = "127.0.0.1:20100"
+    }`,
+		},
+		"metrics and tracing": {
+			tracing: true,
+			exp: `
+    config {
+      envoy_prometheus_bind_addr = "0.0.0.0:20200"
+      envoy_tracing_json = `,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				DefaultEnableMetrics:        true,
+				DefaultEnableMetricsMerging: c.merging,
+			}
+			if c.tracing {
+				h.EnvoyTracingProvider = "zipkin"
+				h.EnvoyTracingCollectorAddr = "zipkin:9411"
+			}
+			container, err := h.containerInit(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{annotationService: "web"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			}, k8sNamespace)
+			require.NoError(t, err)
+			require.Contains(t, container.Command[2], c.exp)
+			require.Equal(t, 1, strings.Count(container.Command[2], "envoy_prometheus_bind_addr"))
+		})
+	}
+}
+
+func TestHandlerContainerInit_EnvoyMetricsInvalid(t *testing.T) {
+	h := Handler{DefaultEnableMetrics: true}
+	_, err := h.containerInit(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService:              "web",
+				annotationPrometheusScrapePath: "/merged",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+	}, k8sNamespace)
+	require.EqualError(t, err, `the Prometheus scrape path must be "/metrics" unless metrics merging is enabled, not "/merged"`)
+}
+
+// Test that the Consul sidecar only serves the merged metrics when merging.
+func TestConsulSidecar_EnvoyMetrics(t *testing.T) {
+	cases := map[string]struct {
+		handler    Handler
+		port       string
+		expCommand []string
+	}{
+		"metrics disabled": {
+			handler:    Handler{DefaultEnableMetricsMerging: true},
+			expCommand: []string{"consul-k8s", "consul-sidecar", "-service-config", "/consul/connect-inject/service.hcl", "-consul-binary", "/consul/connect-inject/consul", "-health-port=20300"},
+		},
+		"no merging": {
+			handler:    Handler{DefaultEnableMetrics: true},
+			expCommand: []string{"consul-k8s", "consul-sidecar", "-service-config", "/consul/connect-inject/service.hcl", "-consul-binary", "/consul/connect-inject/consul", "-health-port=20300"},
+		},
+		"merging": {
+			handler: Handler{DefaultEnableMetrics: true, DefaultEnableMetricsMerging: true},
+			port:    "8080",
+			expCommand: []string{"consul-k8s", "consul-sidecar", "-service-config", "/consul/connect-inject/service.hcl", "-consul-binary", "/consul/connect-inject/consul", "-health-port=20300",
+				"-enable-metrics-merging=true",
+				"-merged-metrics-port=20100",
+				"-prometheus-scrape-port=20200",
+				"-prometheus-scrape-path=/metrics",
+				"-service-metrics-port=8080",
+				"-service-metrics-path=/metrics",
+			},
+		},
+		"merging without a service port": {
+			handler: Handler{DefaultEnableMetrics: true, DefaultEnableMetricsMerging: true},
+			expCommand: []string{"consul-k8s", "consul-sidecar", "-service-config", "/consul/connect-inject/service.hcl", "-consul-binary", "/consul/connect-inject/consul", "-health-port=20300",
+				"-enable-metrics-merging=true",
+				"-merged-metrics-port=20100",
+				"-prometheus-scrape-port=20200",
+				"-prometheus-scrape-path=/metrics",
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			c.handler.Log = hclog.NewNullLogger()
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			}
+			if c.port != "" {
+				pod.Annotations[annotationPort] = c.port
+			}
+			container := c.handler.consulSidecar(pod)
+			require.Equal(t, c.expCommand, container.Command)
+		})
+	}
+}
+
+// Test that the pods with metrics enabled are annotated for Prometheus.
+func TestHandlerHandle_EnvoyMetricsAnnotations(t *testing.T) {
+	h := Handler{
+		Log:                         hclog.NewNullLogger(),
+		AllowK8sNamespacesSet:       mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:        mapset.NewSet(),
+		DefaultEnableMetrics:        true,
+		DefaultEnableMetricsMerging: true,
+		DefaultMergedMetricsPort:    20100,
+		DefaultPrometheusScrapePort: 20200,
+		DefaultPrometheusScrapePath: "/metrics",
+	}
+	resp := h.Mutate(&v1beta1.AdmissionRequest{
+		Object: encodeRaw(t, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{annotationPrometheusScrapePath: "/merged"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+		}),
+	})
+	require.True(t, resp.Allowed, resp.Result)
+
+	var patches []jsonpatch.JsonPatchOperation
+	require.NoError(t, json.Unmarshal(resp.Patch, &patches))
+	annotations := make(map[string]interface{})
+	for _, patch := range patches {
+		if strings.HasPrefix(patch.Path, "/metadata/annotations/") {
+			annotations[strings.TrimPrefix(patch.Path, "/metadata/annotations/")] = patch.Value
+		}
+	}
+	require.Equal(t, "true", annotations[escapeJSONPointer(annotationPrometheusScrape)])
+	require.Equal(t, "20200", annotations[escapeJSONPointer(annotationPrometheusPort)])
+	require.Equal(t, "/merged", annotations[escapeJSONPointer(annotationPrometheusPath)])
+}
@@ -15,19 +15,75 @@ import (
 type sidecarContainerCommandData struct {
 	AuthMethod      string
 	ConsulNamespace string
+	ConsulPartition string
+	// DrainSeconds is how long the preStop hook waits for the proxy to drain
+	// after deregistering the services.
+	DrainSeconds int
+}
+
+// envoySidecars returns the Envoy sidecar containers of the pod, one for the
+// proxy of each of its services.
+func (h *Handler) envoySidecars(pod *corev1.Pod, k8sNamespace string) ([]corev1.Container, error) {
+	services, err := podServices(pod)
+	if err != nil {
+		return nil, err
+	}
+	if !multiPort(services) {
+		container, err := h.envoySidecar(pod, k8sNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return []corev1.Container{container}, nil
+	}
+
+	var containers []corev1.Container
+	for i, service := range services {
+		// Envoys in the same pod share the IPC namespace so they need
+		// distinct base IDs for their hot restart shared memory.
+		container, err := h.envoySidecarContainer(pod, k8sNamespace,
+			fmt.Sprintf("envoy-sidecar-%s", service.name), envoyBootstrapFile(service.name, true), i)
+		if err != nil {
+			return nil, err
+		}
+		// The preStop hook deregisters all of the pod's services from
+		// service.hcl so the other sidecars only wait for their proxy to
+		// drain.
+		if i > 0 {
+			drainTime, err := h.envoyDrainTime(pod)
+			if err != nil {
+				return nil, err
+			}
+			container.Lifecycle = drainLifecycle(drainTime)
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
 }
 
 func (h *Handler) envoySidecar(pod *corev1.Pod, k8sNamespace string) (corev1.Container, error) {
+	return h.envoySidecarContainer(pod, k8sNamespace, "envoy-sidecar", envoyBootstrapFile("", false), 0)
+}
+
+// envoySidecarContainer returns the container named name running Envoy with
+// the bootstrap file bootstrapFile from the shared volume and, if it isn't 0,
+// the hot restart base ID baseID.
+func (h *Handler) envoySidecarContainer(pod *corev1.Pod, k8sNamespace, name, bootstrapFile string, baseID int) (corev1.Container, error) {
+	drainTime, err := h.envoyDrainTime(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
 	templateData := sidecarContainerCommandData{
 		AuthMethod:      h.AuthMethod,
 		ConsulNamespace: h.consulNamespace(k8sNamespace),
+		ConsulPartition: h.ConsulPartition,
+		DrainSeconds:    drainSeconds(drainTime),
 	}
 
 	// Render the command
 	var buf bytes.Buffer
 	tpl := template.Must(template.New("root").Parse(strings.TrimSpace(
 		sidecarPreStopCommandTpl)))
-	err := tpl.Execute(&buf, &templateData)
+	err = tpl.Execute(&buf, &templateData)
 	if err != nil {
 		return corev1.Container{}, err
 	}
@@ -37,14 +93,14 @@ func (h *Handler) envoySidecar(pod *corev1.Pod, k8sNamespace string) (corev1.Con
 		return corev1.Container{}, err
 	}
 
-	cmd, err := h.getContainerSidecarCommand(pod)
+	cmd, err := h.getContainerSidecarCommand(pod, bootstrapFile, baseID)
 	if err != nil {
 		return corev1.Container{}, err
 	}
 
 	container := corev1.Container{
-		Name:  "envoy-sidecar",
-		Image: h.ImageEnvoy,
+		Name:  name,
+		Image: h.imageEnvoy(k8sNamespace),
 		Env: []corev1.EnvVar{
 			{
 				Name: "HOST_IP",
@@ -73,6 +129,31 @@ func (h *Handler) envoySidecar(pod *corev1.Pod, k8sNamespace string) (corev1.Con
 		},
 		Command: cmd,
 	}
+
+	// Retained registrations must outlive the pod so the preStop hook
+	// doesn't deregister them or log out, which would delete the token
+	// they were registered with.
+	retain, err := retainRegistration(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	if retain {
+		container.Lifecycle = drainLifecycle(drainTime)
+	}
+
+	// Sidecars of Jobs deregister the service themselves since the preStop
+	// hook doesn't run for containers that exit on their own.
+	job, err := isJobPod(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	if job {
+		var preStop string
+		if container.Lifecycle != nil {
+			preStop = buf.String()
+		}
+		container.Command = jobSidecarCommand(container.Command, preStop)
+	}
 	if h.ConsulCACert != "" {
 		caCertEnvVar := corev1.EnvVar{
 			Name:  "CONSUL_CACERT",
@@ -91,37 +172,91 @@ func (h *Handler) envoySidecar(pod *corev1.Pod, k8sNamespace string) (corev1.Con
 	}
 	return container, nil
 }
-func (h *Handler) getContainerSidecarCommand(pod *corev1.Pod) ([]string, error) {
+
+func (h *Handler) getContainerSidecarCommand(pod *corev1.Pod, bootstrapFile string, baseID int) ([]string, error) {
 	cmd := []string{
 		"envoy",
-		"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+		"--config-path", "/consul/connect-inject/" + bootstrapFile,
+	}
+	if baseID > 0 {
+		cmd = append(cmd, "--base-id", strconv.Itoa(baseID))
+	}
+
+	extraArgs, err := h.envoyExtraArgs(pod)
+	if err != nil {
+		return nil, err
+	}
+	if !hasEnvoyArg(extraArgs, "--concurrency") {
+		concurrency, err := h.envoyConcurrency(pod)
+		if err != nil {
+			return nil, err
+		}
+		if concurrency > 0 {
+			cmd = append(cmd, "--concurrency", strconv.Itoa(concurrency))
+		}
+	}
+	if !hasEnvoyArg(extraArgs, "--drain-time-s") {
+		drainTime, err := h.envoyDrainTime(pod)
+		if err != nil {
+			return nil, err
+		}
+		if drainTime > 0 {
+			cmd = append(cmd, "--drain-time-s", strconv.Itoa(drainSeconds(drainTime)))
+		}
+	}
+
+	// The config merged into the bootstrap file is passed with Envoy's
+	// --config-yaml flag, which can only be set once.
+	bootstrapConfig := make(map[string]interface{})
+	tracing, err := h.envoyTracing(pod)
+	if err != nil {
+		return nil, err
+	}
+	if tracing != nil {
+		mergeBootstrapConfig(bootstrapConfig, tracing.bootstrapConfig())
+	}
+	overrides, err := h.envoyBootstrapConfigOverrides(pod)
+	if err != nil {
+		return nil, err
+	}
+	mergeBootstrapConfig(bootstrapConfig, overrides)
+	if len(bootstrapConfig) > 0 {
+		cmd = append(cmd, "--config-yaml", mustMarshalJSON(bootstrapConfig))
 	}
 
+	return append(cmd, extraArgs...), nil
+}
+
+// envoyExtraArgs returns the extra arguments of Envoy from the pod's
+// annotation or else the -envoy-extra-args flag.
+func (h *Handler) envoyExtraArgs(pod *corev1.Pod) ([]string, error) {
 	extraArgs, annotationSet := pod.Annotations[annotationEnvoyExtraArgs]
 
-	if annotationSet || h.EnvoyExtraArgs != "" {
+	if !annotationSet && h.EnvoyExtraArgs == "" {
+		return nil, nil
+	}
 
-		extraArgsToUse := h.EnvoyExtraArgs
+	extraArgsToUse := h.EnvoyExtraArgs
 
-		// Prefer args set by pod annotation over the flag to the consul-k8s binary (h.EnvoyExtraArgs).
-		if annotationSet {
-			extraArgsToUse = extraArgs
-		}
+	// Prefer args set by pod annotation over the flag to the consul-k8s binary (h.EnvoyExtraArgs).
+	if annotationSet {
+		extraArgsToUse = extraArgs
+	}
 
-		// Split string into tokens.
-		// e.g. "--foo bar --boo baz" --> ["--foo", "bar", "--boo", "baz"]
-		tokens, err := shlex.Split(extraArgsToUse)
-		if err != nil {
-			return []string{}, err
-		}
-		for _, t := range tokens {
-			if strings.Contains(t, " ") {
-				t = strconv.Quote(t)
-			}
-			cmd = append(cmd, t)
+	// Split string into tokens.
+	// e.g. "--foo bar --boo baz" --> ["--foo", "bar", "--boo", "baz"]
+	tokens, err := shlex.Split(extraArgsToUse)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for _, t := range tokens {
+		if strings.Contains(t, " ") {
+			t = strconv.Quote(t)
 		}
+		args = append(args, t)
 	}
-	return cmd, nil
+	return args, nil
 }
 
 func (h *Handler) envoySidecarResources(pod *corev1.Pod) (corev1.ResourceRequirements, error) {
@@ -195,10 +330,16 @@ const sidecarPreStopCommandTpl = `
   {{- if .AuthMethod }}
   -token-file="/consul/connect-inject/acl-token" \
   {{- end }}
+  {{- if .ConsulPartition }}
+  -partition="{{ .ConsulPartition }}" \
+  {{- end }}
   {{- if .ConsulNamespace }}
   -namespace="{{ .ConsulNamespace }}" \
   {{- end }}
   /consul/connect-inject/service.hcl
+{{- if .DrainSeconds }}
+sleep {{ .DrainSeconds }}
+{{- end }}
 
 {{- if .AuthMethod }}
 /consul/connect-inject/consul logout \
@@ -60,6 +60,137 @@ func TestHandlerEnvoySidecar(t *testing.T) {
 	})
 }
 
+// Test that pods whose registrations are retained don't get a preStop hook
+// that deregisters them.
+func TestHandlerEnvoySidecar_RetainRegistration(t *testing.T) {
+	cases := map[string]struct {
+		value      string
+		expPreStop bool
+		expErr     string
+	}{
+		"true": {
+			value: "true",
+		},
+		"false": {
+			value:      "false",
+			expPreStop: true,
+		},
+		"invalid": {
+			value:  "yes please",
+			expErr: `consul.hashicorp.com/connect-retain-registration annotation value of "yes please" is invalid`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{AuthMethod: "auth-method"}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						annotationService:            "foo",
+						annotationRetainRegistration: c.value,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "web",
+						},
+					},
+				},
+			}
+			container, err := h.envoySidecar(pod, k8sNamespace)
+			if c.expErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			if c.expPreStop {
+				require.NotNil(t, container.Lifecycle)
+			} else {
+				require.Nil(t, container.Lifecycle)
+			}
+		})
+	}
+}
+
+// Test that the sidecars of jobs run Envoy until the other containers exit,
+// then deregister the service themselves.
+func TestHandlerEnvoySidecar_Job(t *testing.T) {
+	cases := map[string]struct {
+		annotations map[string]string
+		owner       string
+		expJob      bool
+		expPreStop  bool
+	}{
+		"owned by a job": {
+			owner:      "Job",
+			expJob:     true,
+			expPreStop: true,
+		},
+		"owned by a replica set": {
+			owner: "ReplicaSet",
+		},
+		"annotation": {
+			annotations: map[string]string{annotationJob: "true"},
+			expJob:      true,
+			expPreStop:  true,
+		},
+		"annotation overrides owner": {
+			annotations: map[string]string{annotationJob: "false"},
+			owner:       "Job",
+		},
+		"retained registration": {
+			annotations: map[string]string{annotationJob: "true", annotationRetainRegistration: "true"},
+			expJob:      true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{}
+			annotations := map[string]string{annotationService: "foo"}
+			for k, v := range c.annotations {
+				annotations[k] = v
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: annotations,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "web",
+						},
+					},
+				},
+			}
+			if c.owner != "" {
+				pod.OwnerReferences = []metav1.OwnerReference{
+					{APIVersion: "batch/v1", Kind: c.owner, Name: "web"},
+				}
+				if c.owner == "ReplicaSet" {
+					pod.OwnerReferences[0].APIVersion = "apps/v1"
+				}
+			}
+			container, err := h.envoySidecar(pod, k8sNamespace)
+			require.NoError(t, err)
+			if !c.expJob {
+				require.Equal(t, []string{"envoy", "--config-path", "/consul/connect-inject/envoy-bootstrap.yaml"}, container.Command)
+				return
+			}
+			require.Len(t, container.Command, 3)
+			require.Equal(t, []string{"/bin/sh", "-ec"}, container.Command[:2])
+			require.Contains(t, container.Command[2], jobSidecarMarker)
+			require.Contains(t, container.Command[2], "'envoy' '--config-path' '/consul/connect-inject/envoy-bootstrap.yaml' &")
+			if c.expPreStop {
+				require.Contains(t, container.Command[2], "consul services deregister")
+			} else {
+				require.NotContains(t, container.Command[2], "consul services deregister")
+			}
+		})
+	}
+}
+
 // Test that we can pass extra args to envoy via the extraEnvoyArgs flag
 // or via pod annotations. When arguments are passed in both ways, the
 // arguments set via pod annotations are used.
@@ -295,6 +426,44 @@ func TestHandlerEnvoySidecar_NamespacesAndAuthMethod(t *testing.T) {
   -token-file="/consul/connect-inject/acl-token"`)
 }
 
+// Test that the pre-stop command deregisters the services from the
+// partition if admin partitions are enabled.
+func TestHandlerEnvoySidecar_Partitions(t *testing.T) {
+	require := require.New(t)
+	h := Handler{
+		EnableNamespaces:           true,
+		ConsulDestinationNamespace: k8sNamespace,
+		ConsulPartition:            "foo",
+		AuthMethod:                 "test-auth-method",
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService: "foo",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	}
+	container, err := h.envoySidecar(pod, k8sNamespace)
+	require.NoError(err)
+
+	preStopCommand := strings.Join(container.Lifecycle.PreStop.Exec.Command, " ")
+	require.Equal(preStopCommand, `/bin/sh -ec /consul/connect-inject/consul services deregister \
+  -token-file="/consul/connect-inject/acl-token" \
+  -partition="foo" \
+  -namespace="k8snamespace" \
+  /consul/connect-inject/service.hcl
+/consul/connect-inject/consul logout \
+  -token-file="/consul/connect-inject/acl-token"`)
+}
+
 func TestHandlerEnvoySidecar_Resources(t *testing.T) {
 	mem1 := resource.MustParse("100Mi")
 	mem2 := resource.MustParse("200Mi")
@@ -0,0 +1,220 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotationEnvoyTracingProvider is the tracer the sidecar proxies send
+	// their spans with: "zipkin", "jaeger" or "datadog". It overrides the
+	// injector's -envoy-tracing-provider flag.
+	annotationEnvoyTracingProvider = "consul.hashicorp.com/envoy-tracing-provider"
+
+	// annotationEnvoyTracingCollectorAddr is the <host>:<port> address of the
+	// collector that the spans are sent to. It overrides the injector's
+	// -envoy-tracing-collector-addr flag.
+	annotationEnvoyTracingCollectorAddr = "consul.hashicorp.com/envoy-tracing-collector-address"
+
+	// annotationEnvoyTracingSampleRate is the percentage of requests that are
+	// traced, from 0 to 100. It overrides the injector's
+	// -envoy-tracing-sample-rate flag.
+	annotationEnvoyTracingSampleRate = "consul.hashicorp.com/envoy-tracing-sample-rate"
+)
+
+const (
+	tracingProviderZipkin  = "zipkin"
+	tracingProviderJaeger  = "jaeger"
+	tracingProviderDatadog = "datadog"
+
+	// tracingCollectorCluster is the name of the static cluster added to the
+	// Envoy bootstrap for the collector.
+	tracingCollectorCluster = "consul_k8s_tracing_collector"
+)
+
+// validTracingHost matches the hosts of collector addresses. It's strict
+// since the address is written into the init container's shell script.
+var validTracingHost = regexp.MustCompile(`^[A-Za-z0-9.\-:]+$`)
+
+// envoyTracing is the tracing configuration of the sidecar proxies of a pod.
+type envoyTracing struct {
+	provider      string
+	collectorHost string
+	collectorPort int
+	// sampleRate is the percentage of requests that are traced. If it's 0,
+	// only the requests whose trace headers ask for it are, which is the
+	// default in Consul.
+	sampleRate float64
+}
+
+// envoyTracing returns the tracing configuration of the pod's sidecar
+// proxies from its annotations and the handler's defaults, or nil if tracing
+// isn't configured.
+func (h *Handler) envoyTracing(pod *corev1.Pod) (*envoyTracing, error) {
+	provider := h.EnvoyTracingProvider
+	if raw, ok := pod.Annotations[annotationEnvoyTracingProvider]; ok {
+		provider = raw
+	}
+	if provider == "" {
+		return nil, nil
+	}
+	if err := validateTracingProvider(provider); err != nil {
+		return nil, fmt.Errorf("%s annotation value of %q is invalid: %s", annotationEnvoyTracingProvider, provider, err)
+	}
+
+	addr := h.EnvoyTracingCollectorAddr
+	if raw, ok := pod.Annotations[annotationEnvoyTracingCollectorAddr]; ok {
+		addr = raw
+	}
+	host, port, err := parseTracingCollectorAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s annotation value of %q is invalid: %s", annotationEnvoyTracingCollectorAddr, addr, err)
+	}
+
+	sampleRate := h.EnvoyTracingSampleRate
+	if raw, ok := pod.Annotations[annotationEnvoyTracingSampleRate]; ok {
+		sampleRate, err = strconv.ParseFloat(raw, 64)
+		if err != nil || sampleRate < 0 || sampleRate > 100 {
+			return nil, fmt.Errorf("%s annotation value of %q is invalid: must be a number between 0 and 100",
+				annotationEnvoyTracingSampleRate, raw)
+		}
+	}
+
+	return &envoyTracing{
+		provider:      provider,
+		collectorHost: host,
+		collectorPort: port,
+		sampleRate:    sampleRate,
+	}, nil
+}
+
+// ValidateEnvoyTracing returns an error if the -envoy-tracing-* flags of the
+// injector aren't valid.
+func ValidateEnvoyTracing(provider, collectorAddr string, sampleRate float64) error {
+	if err := validateTracingProvider(provider); err != nil {
+		return fmt.Errorf("-envoy-tracing-provider is invalid: %s", err)
+	}
+	if _, _, err := parseTracingCollectorAddr(collectorAddr); err != nil {
+		return fmt.Errorf("-envoy-tracing-collector-addr is invalid: %s", err)
+	}
+	if sampleRate < 0 || sampleRate > 100 {
+		return fmt.Errorf("-envoy-tracing-sample-rate must be between 0 and 100")
+	}
+	return nil
+}
+
+// validateTracingProvider returns an error if provider isn't supported.
+func validateTracingProvider(provider string) error {
+	switch provider {
+	case tracingProviderZipkin, tracingProviderJaeger, tracingProviderDatadog:
+		return nil
+	}
+	return fmt.Errorf("must be one of %q, %q or %q", tracingProviderZipkin, tracingProviderJaeger, tracingProviderDatadog)
+}
+
+// parseTracingCollectorAddr returns the host and port of a collector address
+// in the form <host>:<port>.
+func parseTracingCollectorAddr(addr string) (string, int, error) {
+	host, rawPort, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || !validTracingHost.MatchString(host) {
+		return "", 0, fmt.Errorf("must be in the form <host>:<port>")
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("port must be between 1 and 65535")
+	}
+	return host, port, nil
+}
+
+// tracingJSON returns the value of the envoy_tracing_json proxy config of the
+// proxy of service. Jaeger collectors are sent spans in the Zipkin format,
+// which they accept on their Zipkin port.
+func (t *envoyTracing) tracingJSON(service string) string {
+	var http map[string]interface{}
+	if t.provider == tracingProviderDatadog {
+		http = map[string]interface{}{
+			"name": "envoy.tracers.datadog",
+			"typedConfig": map[string]interface{}{
+				"@type":             "type.googleapis.com/envoy.config.trace.v3.DatadogConfig",
+				"collector_cluster": tracingCollectorCluster,
+				"service_name":      service,
+			},
+		}
+	} else {
+		http = map[string]interface{}{
+			"name": "envoy.tracers.zipkin",
+			"typedConfig": map[string]interface{}{
+				"@type":                      "type.googleapis.com/envoy.config.trace.v3.ZipkinConfig",
+				"collector_cluster":          tracingCollectorCluster,
+				"collector_endpoint":         "/api/v2/spans",
+				"collector_endpoint_version": "HTTP_JSON",
+				"shared_span_context":        false,
+			},
+		}
+	}
+	return mustMarshalJSON(map[string]interface{}{"http": http})
+}
+
+// clusterJSON returns the value of the envoy_extra_static_clusters_json
+// proxy config, which adds the collector's cluster.
+func (t *envoyTracing) clusterJSON() string {
+	return mustMarshalJSON(map[string]interface{}{
+		"name":              tracingCollectorCluster,
+		"type":              "STRICT_DNS",
+		"connect_timeout":   "5s",
+		"dns_lookup_family": "V4_ONLY",
+		"lb_policy":         "ROUND_ROBIN",
+		"load_assignment": map[string]interface{}{
+			"cluster_name": tracingCollectorCluster,
+			"endpoints": []interface{}{map[string]interface{}{
+				"lb_endpoints": []interface{}{map[string]interface{}{
+					"endpoint": map[string]interface{}{
+						"address": map[string]interface{}{
+							"socket_address": map[string]interface{}{
+								"address":    t.collectorHost,
+								"port_value": t.collectorPort,
+								"protocol":   "TCP",
+							},
+						},
+					},
+				}},
+			}},
+		},
+	})
+}
+
+// bootstrapConfig returns the config merged into the bootstrap file, or nil
+// if there's none. Consul's listeners don't sample requests randomly so the
+// sample rate is set with the runtime key that overrides it, which is an
+// integer number of hundredths of a percent, from 0 to 10000.
+func (t *envoyTracing) bootstrapConfig() map[string]interface{} {
+	if t.sampleRate == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"layered_runtime": map[string]interface{}{
+			"layers": []interface{}{map[string]interface{}{
+				"name": "consul_k8s_tracing",
+				"static_layer": map[string]interface{}{
+					"tracing.random_sampling": int(math.Round(t.sampleRate * 100)),
+				},
+			}},
+		},
+	}
+}
+
+// mustMarshalJSON marshals v, which must only contain maps, slices and
+// primitive values.
+func mustMarshalJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,237 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHandlerEnvoyTracing(t *testing.T) {
+	cases := map[string]struct {
+		handler     Handler
+		annotations map[string]string
+		exp         *envoyTracing
+		expErr      string
+	}{
+		"disabled": {},
+		"flags": {
+			handler: Handler{
+				EnvoyTracingProvider:      "zipkin",
+				EnvoyTracingCollectorAddr: "zipkin.monitoring:9411",
+				EnvoyTracingSampleRate:    10,
+			},
+			exp: &envoyTracing{provider: "zipkin", collectorHost: "zipkin.monitoring", collectorPort: 9411, sampleRate: 10},
+		},
+		"annotations": {
+			annotations: map[string]string{
+				annotationEnvoyTracingProvider:      "datadog",
+				annotationEnvoyTracingCollectorAddr: "10.0.0.1:8126",
+				annotationEnvoyTracingSampleRate:    "2.5",
+			},
+			exp: &envoyTracing{provider: "datadog", collectorHost: "10.0.0.1", collectorPort: 8126, sampleRate: 2.5},
+		},
+		"annotations override flags": {
+			handler: Handler{
+				EnvoyTracingProvider:      "zipkin",
+				EnvoyTracingCollectorAddr: "zipkin.monitoring:9411",
+				EnvoyTracingSampleRate:    10,
+			},
+			annotations: map[string]string{
+				annotationEnvoyTracingProvider:   "jaeger",
+				annotationEnvoyTracingSampleRate: "100",
+			},
+			exp: &envoyTracing{provider: "jaeger", collectorHost: "zipkin.monitoring", collectorPort: 9411, sampleRate: 100},
+		},
+		"annotation disables tracing": {
+			handler: Handler{
+				EnvoyTracingProvider:      "zipkin",
+				EnvoyTracingCollectorAddr: "zipkin.monitoring:9411",
+			},
+			annotations: map[string]string{annotationEnvoyTracingProvider: ""},
+		},
+		"invalid provider": {
+			annotations: map[string]string{annotationEnvoyTracingProvider: "xray"},
+			expErr: `consul.hashicorp.com/envoy-tracing-provider annotation value of "xray" is invalid: ` +
+				`must be one of "zipkin", "jaeger" or "datadog"`,
+		},
+		"no collector address": {
+			annotations: map[string]string{annotationEnvoyTracingProvider: "zipkin"},
+			expErr: `consul.hashicorp.com/envoy-tracing-collector-address annotation value of "" is invalid: ` +
+				`must be in the form <host>:<port>`,
+		},
+		"invalid collector address": {
+			annotations: map[string]string{
+				annotationEnvoyTracingProvider:      "zipkin",
+				annotationEnvoyTracingCollectorAddr: "$(zipkin):9411",
+			},
+			expErr: `consul.hashicorp.com/envoy-tracing-collector-address annotation value of "$(zipkin):9411" is invalid: ` +
+				`must be in the form <host>:<port>`,
+		},
+		"invalid collector port": {
+			annotations: map[string]string{
+				annotationEnvoyTracingProvider:      "zipkin",
+				annotationEnvoyTracingCollectorAddr: "zipkin:0",
+			},
+			expErr: `consul.hashicorp.com/envoy-tracing-collector-address annotation value of "zipkin:0" is invalid: ` +
+				`port must be between 1 and 65535`,
+		},
+		"invalid sample rate": {
+			annotations: map[string]string{
+				annotationEnvoyTracingProvider:      "zipkin",
+				annotationEnvoyTracingCollectorAddr: "zipkin:9411",
+				annotationEnvoyTracingSampleRate:    "200",
+			},
+			expErr: `consul.hashicorp.com/envoy-tracing-sample-rate annotation value of "200" is invalid: ` +
+				`must be a number between 0 and 100`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tracing, err := c.handler.envoyTracing(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+			})
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, tracing)
+		})
+	}
+}
+
+func TestValidateEnvoyTracing(t *testing.T) {
+	require.NoError(t, ValidateEnvoyTracing("zipkin", "zipkin:9411", 10))
+	require.EqualError(t, ValidateEnvoyTracing("xray", "zipkin:9411", 10),
+		`-envoy-tracing-provider is invalid: must be one of "zipkin", "jaeger" or "datadog"`)
+	require.EqualError(t, ValidateEnvoyTracing("zipkin", "", 10),
+		"-envoy-tracing-collector-addr is invalid: must be in the form <host>:<port>")
+	require.EqualError(t, ValidateEnvoyTracing("zipkin", "zipkin:9411", -1),
+		"-envoy-tracing-sample-rate must be between 0 and 100")
+}
+
+// Test that the tracing configuration is added to the proxy registration
+// and that it's still valid JSON once the init container's shell has
+// written the service.hcl file.
+func TestHandlerContainerInit_EnvoyTracing(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	cases := map[string]struct {
+		provider string
+		expName  string
+		expType  string
+	}{
+		"zipkin":  {provider: "zipkin", expName: "envoy.tracers.zipkin", expType: "type.googleapis.com/envoy.config.trace.v3.ZipkinConfig"},
+		"jaeger":  {provider: "jaeger", expName: "envoy.tracers.zipkin", expType: "type.googleapis.com/envoy.config.trace.v3.ZipkinConfig"},
+		"datadog": {provider: "datadog", expName: "envoy.tracers.datadog", expType: "type.googleapis.com/envoy.config.trace.v3.DatadogConfig"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				EnvoyTracingProvider:      c.provider,
+				EnvoyTracingCollectorAddr: "collector.monitoring:9411",
+			}
+			container, err := h.containerInit(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{annotationService: "web"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			}, k8sNamespace)
+			require.NoError(t, err)
+
+			// Write service.hcl to stdout with the init container's shell.
+			script := container.Command[2]
+			start := strings.Index(script, "cat <<EOF >/consul/connect-inject/service.hcl\n")
+			require.NotEqual(t, -1, start)
+			end := strings.Index(script[start:], "\nEOF\n")
+			require.NotEqual(t, -1, end)
+			heredoc := strings.Replace(script[start:start+end+len("\nEOF")], " >/consul/connect-inject/service.hcl", "", 1)
+			out, err := exec.Command("sh", "-c", heredoc).Output()
+			require.NoError(t, err)
+
+			file, err := hcl.ParseBytes(out)
+			require.NoError(t, err)
+			services := file.Node.(*ast.ObjectList).Filter("services").Items
+			require.Len(t, services, 2)
+			var proxy struct {
+				Proxy struct {
+					Config struct {
+						TracingJSON  string `hcl:"envoy_tracing_json"`
+						ClustersJSON string `hcl:"envoy_extra_static_clusters_json"`
+					} `hcl:"config"`
+				} `hcl:"proxy"`
+			}
+			require.NoError(t, hcl.DecodeObject(&proxy, services[1].Val))
+
+			var tracing struct {
+				HTTP struct {
+					Name        string
+					TypedConfig map[string]interface{}
+				}
+			}
+			require.NoError(t, json.Unmarshal([]byte(proxy.Proxy.Config.TracingJSON), &tracing))
+			require.Equal(t, c.expName, tracing.HTTP.Name)
+			require.Equal(t, c.expType, tracing.HTTP.TypedConfig["@type"])
+			require.Equal(t, tracingCollectorCluster, tracing.HTTP.TypedConfig["collector_cluster"])
+
+			var cluster map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(proxy.Proxy.Config.ClustersJSON), &cluster))
+			require.Equal(t, tracingCollectorCluster, cluster["name"])
+			require.Contains(t, proxy.Proxy.Config.ClustersJSON,
+				`"socket_address":{"address":"collector.monitoring","port_value":9411,"protocol":"TCP"}`)
+		})
+	}
+}
+
+// Test that the sample rate is set with Envoy's runtime, in hundredths of a
+// percent, if it isn't 0.
+func TestHandlerEnvoySidecar_EnvoyTracing(t *testing.T) {
+	cases := map[string]struct {
+		sampleRate float64
+		expCommand []string
+	}{
+		"no sample rate": {
+			expCommand: []string{
+				"envoy",
+				"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+			},
+		},
+		"sample rate": {
+			sampleRate: 12.5,
+			expCommand: []string{
+				"envoy",
+				"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+				"--config-yaml", `{"layered_runtime":{"layers":[{"name":"consul_k8s_tracing","static_layer":{"tracing.random_sampling":1250}}]}}`,
+			},
+		},
+		"all requests": {
+			sampleRate: 100,
+			expCommand: []string{
+				"envoy",
+				"--config-path", "/consul/connect-inject/envoy-bootstrap.yaml",
+				"--config-yaml", `{"layered_runtime":{"layers":[{"name":"consul_k8s_tracing","static_layer":{"tracing.random_sampling":10000}}]}}`,
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				ImageEnvoy:                "envoy:latest",
+				EnvoyTracingProvider:      "zipkin",
+				EnvoyTracingCollectorAddr: "zipkin:9411",
+				EnvoyTracingSampleRate:    c.sampleRate,
+			}
+			container, err := h.envoySidecar(&corev1.Pod{}, k8sNamespace)
+			require.NoError(t, err)
+			require.Equal(t, c.expCommand, container.Command)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattbaird/jsonpatch"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// update regenerates the golden files instead of comparing against them.
+// Run `make golden` after changing the mutation performed by the injector
+// and review the diff of testdata/golden.
+var update = flag.Bool("update", false, "update golden files")
+
+// TestHandlerGolden runs a matrix of annotations and handler settings
+// through the injector and compares the resulting JSON patch against the
+// golden files in testdata/golden so that any change to the mutation shows
+// up as a diff.
+func TestHandlerGolden(t *testing.T) {
+	cases := map[string]struct {
+		Handler     func(h *Handler)
+		Annotations map[string]string
+		Namespace   string
+		// VolumeMounts are added to the pod's container, for example to
+		// mimic the service account token mount added by Kubernetes.
+		VolumeMounts []corev1.VolumeMount
+	}{
+		"basic": {},
+		"service name and port": {
+			Annotations: map[string]string{
+				annotationService: "web",
+				annotationPort:    "8080",
+			},
+		},
+		"upstreams": {
+			Annotations: map[string]string{
+				annotationUpstreams: "db:1234,cache:2345:dc2,prepared_query:geo-db:3456",
+			},
+		},
+		"tags and meta": {
+			Annotations: map[string]string{
+				annotationTags:                 "abc,123",
+				annotationConnectTags:          "connect",
+				annotationMeta + "name":        "web",
+				annotationMeta + "environment": "prod",
+			},
+		},
+		"sidecar proxy resources": {
+			Annotations: map[string]string{
+				annotationSidecarProxyCPULimit:      "200m",
+				annotationSidecarProxyCPURequest:    "100m",
+				annotationSidecarProxyMemoryLimit:   "128Mi",
+				annotationSidecarProxyMemoryRequest: "64Mi",
+			},
+		},
+		"envoy extra args": {
+			Annotations: map[string]string{
+				annotationEnvoyExtraArgs: "--log-level debug",
+			},
+		},
+		"acls": {
+			Handler: func(h *Handler) {
+				h.AuthMethod = "consul-k8s-auth-method"
+			},
+			Annotations: map[string]string{
+				annotationService: "web",
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "web-token-abcde",
+					ReadOnly:  true,
+					MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+				},
+			},
+		},
+		"tls": {
+			Handler: func(h *Handler) {
+				h.ConsulCACert = "consul-ca-cert"
+			},
+		},
+		"namespaces": {
+			Handler: func(h *Handler) {
+				h.EnableNamespaces = true
+				h.ConsulDestinationNamespace = "dest"
+			},
+		},
+		"namespace mirroring": {
+			Handler: func(h *Handler) {
+				h.EnableNamespaces = true
+				h.EnableK8SNSMirroring = true
+				h.K8SNSMirroringPrefix = "k8s-"
+			},
+			Namespace: "web",
+		},
+		"retain registration": {
+			Annotations: map[string]string{
+				annotationRetainRegistration: "true",
+			},
+		},
+		"multi port": {
+			Annotations: map[string]string{
+				annotationService:   "web,web-admin",
+				annotationPort:      "http,9090",
+				annotationUpstreams: "db:1234",
+			},
+		},
+		"job": {
+			Annotations: map[string]string{
+				annotationJob: "true",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := goldenHandler()
+			if c.Handler != nil {
+				c.Handler(&h)
+			}
+			namespace := c.Namespace
+			if namespace == "" {
+				namespace = "default"
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "web",
+					Namespace:   namespace,
+					Annotations: c.Annotations,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: "web",
+					Containers: []corev1.Container{
+						{
+							Name:         "web",
+							Image:        "web",
+							Ports:        []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+							VolumeMounts: c.VolumeMounts,
+						},
+					},
+				},
+			}
+
+			resp := h.Mutate(&v1beta1.AdmissionRequest{
+				Namespace: namespace,
+				Object:    encodeRaw(t, pod),
+			})
+			require.True(t, resp.Allowed, "%+v", resp.Result)
+
+			assertGolden(t, filepath.Join("testdata", "golden", goldenFileName(name)), stablePatch(t, resp.Patch))
+		})
+	}
+}
+
+// goldenHandler returns a handler whose settings don't change between runs
+// so that the golden files are stable.
+func goldenHandler() Handler {
+	return Handler{
+		Log:                       hclog.Default().Named("handler"),
+		AllowK8sNamespacesSet:     mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:      mapset.NewSet(),
+		ImageConsul:               "hashicorp/consul:1.9.3",
+		ImageEnvoy:                "envoyproxy/envoy-alpine:v1.16.0",
+		ImageConsulK8S:            "hashicorp/consul-k8s:0.24.0",
+		DefaultProxyCPURequest:    resource.MustParse("50m"),
+		DefaultProxyCPULimit:      resource.MustParse("100m"),
+		DefaultProxyMemoryRequest: resource.MustParse("64Mi"),
+		DefaultProxyMemoryLimit:   resource.MustParse("128Mi"),
+		InitContainerResources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("50m"),
+				corev1.ResourceMemory: resource.MustParse("150Mi"),
+			},
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("50m"),
+				corev1.ResourceMemory: resource.MustParse("25Mi"),
+			},
+		},
+		ConsulSidecarResources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("20m"),
+				corev1.ResourceMemory: resource.MustParse("50Mi"),
+			},
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("20m"),
+				corev1.ResourceMemory: resource.MustParse("25Mi"),
+			},
+		},
+	}
+}
+
+// stablePatch returns the patch indented and with its operations sorted by
+// path. The order of the operations generated from a diff of two objects
+// isn't stable so they're sorted to keep the golden files deterministic.
+func stablePatch(t *testing.T, raw []byte) []byte {
+	var ops []jsonpatch.JsonPatchOperation
+	require.NoError(t, json.Unmarshal(raw, &ops))
+	sort.SliceStable(ops, func(i, j int) bool {
+		if ops[i].Path != ops[j].Path {
+			return ops[i].Path < ops[j].Path
+		}
+		return ops[i].Operation < ops[j].Operation
+	})
+	out, err := json.MarshalIndent(ops, "", "  ")
+	require.NoError(t, err)
+	return append(out, '\n')
+}
+
+// goldenFileName returns the golden file name for a test case name.
+func goldenFileName(name string) string {
+	return strings.ReplaceAll(name, " ", "-") + ".golden.json"
+}
+
+// assertGolden compares actual against the golden file at path, or updates
+// the golden file if the -update flag is set.
+func assertGolden(t *testing.T, path string, actual []byte) {
+	t.Helper()
+	if *update {
+		require.NoError(t, ioutil.WriteFile(path, actual, 0644))
+		return
+	}
+	expected, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "golden file missing, run `make golden` to generate it")
+	require.Equal(t, string(expected), string(actual), "golden file %s is out of date, run `make golden` and review the diff", path)
+}
@@ -7,8 +7,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/helper/dashboard"
+	"github.com/hashicorp/consul-k8s/helper/servicemeta"
 	"github.com/hashicorp/consul-k8s/namespaces"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
@@ -19,6 +23,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
@@ -36,7 +42,9 @@ const (
 	annotationService = "consul.hashicorp.com/connect-service"
 
 	// annotationPort is the name or value of the port to proxy incoming
-	// connections to.
+	// connections to. Multi-port pods set it to a comma-separated list of
+	// ports, one per service listed in annotationService, and get one
+	// sidecar proxy per service.
 	annotationPort = "consul.hashicorp.com/connect-service-port"
 
 	// annotationProtocol contains the protocol that should be used for
@@ -50,7 +58,10 @@ const (
 	// proxy in the format of `<service-name>:<local-port>,...`. The
 	// service name should map to a Consul service namd and the local port
 	// is the local port in the pod that the listener will bind to. It can
-	// be a named port.
+	// be a named port. With Consul Enterprise the service name can be
+	// followed by its namespace, `<service-name>.<namespace>`, and if admin
+	// partitions are enabled by its partition,
+	// `<service-name>.<namespace>.<partition>`.
 	annotationUpstreams = "consul.hashicorp.com/connect-service-upstreams"
 
 	// annotationTags is a list of tags to register with the service
@@ -76,6 +87,11 @@ const (
 	// service is synced (i.e. re-registered) with the local agent.
 	annotationSyncPeriod = "consul.hashicorp.com/connect-sync-period"
 
+	// annotationConsulSidecarHealthPort is the port that the consul-sidecar
+	// container serves the health endpoint of its liveness probe on, which
+	// fails once syncing the service registration does. Defaults to 20300.
+	annotationConsulSidecarHealthPort = "consul.hashicorp.com/consul-sidecar-health-port"
+
 	// annotations for sidecar proxy resource limits
 	annotationSidecarProxyCPULimit      = "consul.hashicorp.com/sidecar-proxy-cpu-limit"
 	annotationSidecarProxyCPURequest    = "consul.hashicorp.com/sidecar-proxy-cpu-request"
@@ -94,6 +110,35 @@ const (
 
 	// annotationConsulNamespace is the Consul namespace the service is registered into.
 	annotationConsulNamespace = "consul.hashicorp.com/consul-namespace"
+
+	// annotationConsulPartition is the Consul admin partition the service is
+	// registered into.
+	annotationConsulPartition = "consul.hashicorp.com/consul-partition"
+
+	// annotationRetainRegistration controls whether the service and proxy
+	// registrations are kept in Consul after the pod is deleted, for
+	// tooling that keeps catalog entries around on purpose, e.g. pointing
+	// at a persistent VIP during a blue/green deployment. This should be
+	// set to a truthy or falsy value, as parseable by strconv.ParseBool.
+	// Retained registrations are left alone by the preStop hook and the
+	// cleanup controller and have to be deregistered by that tooling.
+	annotationRetainRegistration = "consul.hashicorp.com/connect-retain-registration"
+
+	// annotationInitialHealthStatus is the status, "passing" or "critical",
+	// of the pod's Kubernetes health check in Consul while the pod is
+	// starting, i.e. until its containers are running and their startup
+	// probes have succeeded. Defaults to "critical". Afterwards the check
+	// follows the pod's ready condition, which only changes once the
+	// readiness probes reach their failureThreshold or successThreshold.
+	annotationInitialHealthStatus = "consul.hashicorp.com/connect-initial-health-status"
+
+	// annotationJob controls whether the pod's sidecars exit once its other
+	// containers have, so that pods of Jobs and CronJobs can complete. This
+	// should be set to a truthy or falsy value, as parseable by
+	// strconv.ParseBool. Defaults to true for pods owned by a Job. It shares
+	// the pod's process namespace so the sidecars can see the other
+	// containers' processes.
+	annotationJob = "consul.hashicorp.com/connect-job"
 )
 
 var (
@@ -107,6 +152,8 @@ var (
 
 // Handler is the HTTP handler for admission webhooks.
 type Handler struct {
+	// ConsulClient is used to check and create Consul resources. It may be
+	// nil when running in dry-run mode, in which case these are skipped.
 	ConsulClient *api.Client
 
 	// ImageConsul is the container image for Consul to use.
@@ -116,6 +163,10 @@ type Handler struct {
 	ImageConsul string
 	ImageEnvoy  string
 
+	// NamespaceImages overrides ImageConsul and ImageEnvoy for the pods of
+	// some namespaces. Optional.
+	NamespaceImages *NamespaceImagesResource
+
 	// ImageConsulK8S is the container image for consul-k8s to use.
 	// This image is used for the consul-sidecar container.
 	ImageConsulK8S string
@@ -124,6 +175,46 @@ type Handler struct {
 	// See a list of args here: https://www.envoyproxy.io/docs/envoy/latest/operations/cli
 	EnvoyExtraArgs string
 
+	// DefaultEnvoyDrainTime is how long the sidecar proxies drain their
+	// inbound connections when a pod is deleted, unless the pod overrides it
+	// with an annotation. The proxies don't drain if it's 0.
+	DefaultEnvoyDrainTime time.Duration
+
+	// EnvoyTracingProvider, EnvoyTracingCollectorAddr and
+	// EnvoyTracingSampleRate configure the sidecar proxies to send traces
+	// to a Zipkin, Jaeger or Datadog collector, unless they're overridden
+	// by the pod's annotations. Tracing is disabled if the provider is empty.
+	EnvoyTracingProvider      string
+	EnvoyTracingCollectorAddr string
+	EnvoyTracingSampleRate    float64
+
+	// EnvoyBootstrapOverrides is a JSON object merged into the Envoy
+	// bootstrap of the sidecar proxies, unless it's overridden by the pod's
+	// annotation. Optional.
+	EnvoyBootstrapOverrides string
+
+	// DefaultEnableMetrics and DefaultEnableMetricsMerging are whether the
+	// sidecar proxies expose their metrics for Prometheus to scrape and
+	// whether they're merged with the services' own metrics, unless they're
+	// overridden by the pod's annotations. The metrics are scraped on
+	// DefaultPrometheusScrapePort and DefaultPrometheusScrapePath, and the
+	// proxies serve theirs to the consul-sidecar on
+	// DefaultMergedMetricsPort when merging.
+	DefaultEnableMetrics        bool
+	DefaultEnableMetricsMerging bool
+	DefaultMergedMetricsPort    int
+	DefaultPrometheusScrapePort int
+	DefaultPrometheusScrapePath string
+
+	// DashboardURLTemplate, if set, is rendered for each pod and added to
+	// the meta of its service and proxy registrations under
+	// MetaKeyDashboardURL.
+	DashboardURLTemplate *dashboard.URLTemplate
+
+	// MetaPropagator copies the pod's labels and annotations that match its
+	// prefixes into the meta of its service and proxy registrations.
+	MetaPropagator *servicemeta.Propagator
+
 	// RequireAnnotation means that the annotation must be given to inject.
 	// If this is false, injection is default.
 	RequireAnnotation bool
@@ -132,6 +223,12 @@ type Handler struct {
 	// use for identity with connectInjection if ACLs are enabled
 	AuthMethod string
 
+	// ServiceAccountValidation is ServiceAccountValidationStrict or
+	// ServiceAccountValidationPermissive and sets whether pods whose
+	// ServiceAccount doesn't match their service are rejected when ACLs are
+	// enabled. It defaults to strict.
+	ServiceAccountValidation string
+
 	// The PEM-encoded CA certificate string
 	// to use when communicating with Consul clients over HTTPS.
 	// If not set, will use HTTP.
@@ -176,6 +273,12 @@ type Handler struct {
 	// Only necessary if ACLs are enabled.
 	CrossNamespaceACLPolicy string
 
+	// ConsulPartition is the name of the Consul Enterprise admin partition
+	// to register the services and proxies in, and to log in to with the
+	// auth method of the partition. It's empty if partitions aren't enabled,
+	// which requires namespaces to be enabled.
+	ConsulPartition string
+
 	// Default resource settings for sidecar proxies. Some of these
 	// fields may be empty.
 	DefaultProxyCPURequest    resource.Quantity
@@ -191,6 +294,21 @@ type Handler struct {
 	// will be populated by the defaults provided in the initial flags.
 	ConsulSidecarResources corev1.ResourceRequirements
 
+	// EnableOpenShift runs the injected containers with the security
+	// context that OpenShift's restricted SCC requires, as the first user
+	// ID of the range annotated on the pod's namespace.
+	EnableOpenShift bool
+
+	// EnableNamespaceDefaults uses the injection annotations set on the
+	// pod's namespace as defaults for the annotations the pod doesn't set.
+	EnableNamespaceDefaults bool
+
+	// KubernetesClient is used to read the namespaces of pods when
+	// EnableOpenShift or EnableNamespaceDefaults is set. It may be nil when
+	// running in dry-run mode, in which case the user IDs and namespace
+	// defaults aren't set.
+	KubernetesClient kubernetes.Interface
+
 	// Log
 	Log hclog.Logger
 }
@@ -291,6 +409,20 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 		}
 	}
 
+	// Use the namespace's annotations for those the pod doesn't set. This
+	// MUST be done before shouldInject too since the inject annotation can
+	// be set on the namespace.
+	if h.EnableNamespaceDefaults && h.KubernetesClient != nil {
+		if err := h.namespaceDefaults(&pod, req.Namespace, &patches); err != nil {
+			h.Log.Error("Error applying namespace defaults", "err", err, "Request Name", req.Name)
+			return &v1beta1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: fmt.Sprintf("Error applying namespace defaults: %s", err),
+				},
+			}
+		}
+	}
+
 	// Check if we should inject, for example we don't inject in the
 	// system namespaces.
 	if shouldInject, err := h.shouldInject(&pod, req.Namespace); err != nil {
@@ -304,6 +436,74 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 		return resp
 	}
 
+	if err := h.validateServiceAccount(&pod); err != nil {
+		if h.ServiceAccountValidation != ServiceAccountValidationPermissive {
+			h.Log.Error("Error validating service account", "err", err, "Request Name", req.Name)
+			return &v1beta1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: fmt.Sprintf("Error validating service account: %s", err),
+				},
+			}
+		}
+		h.Log.Warn("Service account does not match service, injecting anyway since service account validation is permissive",
+			"err", err, "Request Name", req.Name)
+	}
+
+	// The sidecars of Jobs watch the other containers' processes to exit
+	// once they have.
+	job, err := isJobPod(&pod)
+	if err != nil {
+		h.Log.Error("Error checking if the pod is a job", "err", err, "Request Name", req.Name)
+		return &v1beta1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("Error checking if the pod is a job: %s", err),
+			},
+		}
+	}
+	if _, err := consulSidecarHealthPort(&pod); err != nil {
+		h.Log.Error("Error getting the consul-sidecar health port", "err", err, "Request Name", req.Name)
+		return &v1beta1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("Error getting the consul-sidecar health port: %s", err),
+			},
+		}
+	}
+	// The health checks controller can't register the pod's health check if
+	// the annotation is invalid so the pod is rejected instead.
+	if _, err := initialHealthStatus(&pod); err != nil {
+		h.Log.Error("Error getting the initial health status", "err", err, "Request Name", req.Name)
+		return &v1beta1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("Error getting the initial health status: %s", err),
+			},
+		}
+	}
+	drainTime, err := h.envoyDrainTime(&pod)
+	if err != nil {
+		h.Log.Error("Error getting the Envoy drain time", "err", err, "Request Name", req.Name)
+		return &v1beta1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("Error getting the Envoy drain time: %s", err),
+			},
+		}
+	}
+	// The pod must be given the time to drain its proxies before Kubernetes
+	// kills them.
+	if gracePeriod, ok := terminationGracePeriod(&pod, drainTime); ok {
+		patches = append(patches, jsonpatch.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/terminationGracePeriodSeconds",
+			Value:     gracePeriod,
+		})
+	}
+	if job && (pod.Spec.ShareProcessNamespace == nil || !*pod.Spec.ShareProcessNamespace) {
+		patches = append(patches, jsonpatch.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/shareProcessNamespace",
+			Value:     true,
+		})
+	}
+
 	// Add our volume that will be shared by the init container and
 	// the sidecar for passing data in the pod.
 	patches = append(patches, addVolume(
@@ -337,13 +537,9 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 			},
 		}
 	}
-	patches = append(patches, addContainer(
-		pod.Spec.InitContainers,
-		[]corev1.Container{container},
-		"/spec/initContainers")...)
 
 	// Add the Envoy and Consul sidecars.
-	esContainer, err := h.envoySidecar(&pod, req.Namespace)
+	esContainers, err := h.envoySidecars(&pod, req.Namespace)
 	if err != nil {
 		h.Log.Error("Error configuring injection sidecar container", "err", err, "Request Name", req.Name)
 		return &v1beta1.AdmissionResponse{
@@ -353,17 +549,43 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 		}
 	}
 	connectContainer := h.consulSidecar(&pod)
+	sidecars := append(esContainers, connectContainer)
+	if h.EnableOpenShift && h.KubernetesClient != nil {
+		securityContext, err := h.openShiftSecurityContext(req.Namespace)
+		if err != nil {
+			h.Log.Error("Error configuring OpenShift security context", "err", err, "Request Name", req.Name)
+			return &v1beta1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: fmt.Sprintf("Error configuring OpenShift security context: %s", err),
+				},
+			}
+		}
+		container.SecurityContext = securityContext
+		for i := range sidecars {
+			sidecars[i].SecurityContext = securityContext
+		}
+	}
+	patches = append(patches, addContainer(
+		pod.Spec.InitContainers,
+		[]corev1.Container{container},
+		"/spec/initContainers")...)
 	patches = append(patches, addContainer(
 		pod.Spec.Containers,
-		[]corev1.Container{esContainer, connectContainer},
+		sidecars,
 		"/spec/containers")...)
 
-	// Add annotations so that we know we're injected
-	patches = append(patches, updateAnnotation(
-		pod.Annotations,
-		map[string]string{
-			annotationStatus: injected,
-		})...)
+	// Add annotations so that we know we're injected, and so that
+	// Prometheus scrapes the metrics if they're enabled.
+	annotations := map[string]string{
+		annotationStatus: injected,
+	}
+	// The annotations have been validated by containerInit.
+	if metrics, _ := h.envoyMetrics(&pod); metrics != nil {
+		for k, v := range metrics.prometheusAnnotations() {
+			annotations[k] = v
+		}
+	}
+	patches = append(patches, updateAnnotation(pod.Annotations, annotations)...)
 
 	// Add Pod label for health checks
 	patches = append(patches, updateLabels(
@@ -381,6 +603,15 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 			})...)
 	}
 
+	// Consul-ENT only: Add the Consul admin partition as an annotation to the pod.
+	if h.ConsulPartition != "" {
+		patches = append(patches, updateAnnotation(
+			pod.Annotations,
+			map[string]string{
+				annotationConsulPartition: h.ConsulPartition,
+			})...)
+	}
+
 	// Generate the patch
 	var patch []byte
 	if len(patches) > 0 {
@@ -403,7 +634,7 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 	// Check and potentially create Consul resources. This is done after
 	// all patches are created to guarantee no errors were encountered in
 	// that process before modifying the Consul cluster.
-	if h.EnableNamespaces {
+	if h.EnableNamespaces && h.ConsulClient != nil {
 		if _, err := namespaces.EnsureExists(h.ConsulClient, h.consulNamespace(req.Namespace), h.CrossNamespaceACLPolicy); err != nil {
 			h.Log.Error("Error checking or creating namespace", "err", err,
 				"Namespace", h.consulNamespace(req.Namespace), "Request Name", req.Name)
@@ -457,6 +688,39 @@ func (h *Handler) shouldInject(pod *corev1.Pod, namespace string) (bool, error)
 	return !h.RequireAnnotation, nil
 }
 
+// retainRegistration returns true if the pod's registrations should be kept
+// in Consul after it is deleted.
+func retainRegistration(pod *corev1.Pod) (bool, error) {
+	raw, ok := pod.Annotations[annotationRetainRegistration]
+	if !ok {
+		return false, nil
+	}
+	retain, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s annotation value of %q is invalid: %s", annotationRetainRegistration, raw, err)
+	}
+	return retain, nil
+}
+
+// isJobPod returns true if the pod's sidecars should exit once its other
+// containers have, either because of annotationJob or, without it, because
+// the pod is owned by a Job.
+func isJobPod(pod *corev1.Pod) (bool, error) {
+	if raw, ok := pod.Annotations[annotationJob]; ok {
+		job, err := strconv.ParseBool(raw)
+		if err != nil {
+			return false, fmt.Errorf("%s annotation value of %q is invalid: %s", annotationJob, raw, err)
+		}
+		return job, nil
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "Job" && strings.HasPrefix(ref.APIVersion, "batch/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (h *Handler) defaultAnnotations(pod *corev1.Pod, patches *[]jsonpatch.JsonPatchOperation) error {
 	if pod.ObjectMeta.Annotations == nil {
 		pod.ObjectMeta.Annotations = make(map[string]string)
@@ -519,6 +783,59 @@ func (h *Handler) validatePod(pod corev1.Pod) error {
 	return nil
 }
 
+// podService is one of the services registered for a pod.
+type podService struct {
+	name string
+	// port is the name or value of the service's port. It may be empty for
+	// single-port pods that don't expose a port.
+	port string
+}
+
+// podServices returns the services registered for the pod. Pods register a
+// single service unless the annotationService and annotationPort annotations
+// list more than one service and port, in which case the i-th service is
+// registered with the i-th port.
+func podServices(pod *corev1.Pod) ([]podService, error) {
+	names := strings.Split(pod.Annotations[annotationService], ",")
+	ports := strings.Split(pod.Annotations[annotationPort], ",")
+	if len(names) == 1 && len(ports) == 1 {
+		return []podService{{name: names[0], port: ports[0]}}, nil
+	}
+	if len(names) != len(ports) {
+		return nil, fmt.Errorf("%s annotation lists %d services but %s annotation lists %d ports: multi-port pods must list one service per port",
+			annotationService, len(names), annotationPort, len(ports))
+	}
+
+	services := make([]podService, len(names))
+	seen := make(map[string]bool)
+	for i := range names {
+		name, port := strings.TrimSpace(names[i]), strings.TrimSpace(ports[i])
+		// The service name is part of the name of its sidecar container.
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return nil, fmt.Errorf("service name %q in %s annotation is invalid: %s", name, annotationService, strings.Join(errs, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("service name %q is listed more than once in %s annotation", name, annotationService)
+		}
+		seen[name] = true
+		if value, err := portValue(pod, port); err != nil || value <= 0 {
+			return nil, fmt.Errorf("port %q of service %q in %s annotation is invalid: must be a port name or number", port, name, annotationPort)
+		}
+		services[i] = podService{name: name, port: port}
+	}
+	return services, nil
+}
+
+// serviceNames returns the names of the services registered for an injected
+// pod.
+func serviceNames(pod *corev1.Pod) []string {
+	var names []string
+	for _, name := range strings.Split(pod.Annotations[annotationService], ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
 func portValue(pod *corev1.Pod, value string) (int32, error) {
 	// First search for the named port
 	for _, c := range pod.Spec.Containers {
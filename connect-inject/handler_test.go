@@ -302,6 +302,131 @@ func TestHandlerHandle(t *testing.T) {
 			},
 		},
 
+		{
+			"pod owned by a job",
+			Handler{
+				Log:                   hclog.Default().Named("handler"),
+				AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:  mapset.NewSet(),
+			},
+			v1beta1.AdmissionRequest{
+				Object: encodeRaw(t, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							annotationService: "foo",
+						},
+						OwnerReferences: []metav1.OwnerReference{
+							{APIVersion: "batch/v1", Kind: "Job", Name: "migrate"},
+						},
+					},
+					Spec: basicSpec,
+				}),
+			},
+			"",
+			[]jsonpatch.JsonPatchOperation{
+				{
+					Operation: "add",
+					Path:      "/spec/shareProcessNamespace",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/volumes",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/initContainers",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/containers/-",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/containers/-",
+				},
+				{
+					Operation: "add",
+					Path:      "/metadata/annotations/" + escapeJSONPointer(annotationStatus),
+				},
+				{
+					Operation: "add",
+					Path:      "/metadata/labels",
+				},
+			},
+		},
+
+		{
+			"invalid job annotation",
+			Handler{
+				Log:                   hclog.Default().Named("handler"),
+				AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:  mapset.NewSet(),
+			},
+			v1beta1.AdmissionRequest{
+				Object: encodeRaw(t, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							annotationService: "foo",
+							annotationJob:     "sometimes",
+						},
+					},
+					Spec: basicSpec,
+				}),
+			},
+			`consul.hashicorp.com/connect-job annotation value of "sometimes" is invalid`,
+			nil,
+		},
+
+		{
+			"pod in an admin partition",
+			Handler{
+				Log:                   hclog.Default().Named("handler"),
+				AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:  mapset.NewSet(),
+				ConsulPartition:       "foo",
+			},
+			v1beta1.AdmissionRequest{
+				Object: encodeRaw(t, &corev1.Pod{
+					Spec: basicSpec,
+				}),
+			},
+			"",
+			[]jsonpatch.JsonPatchOperation{
+				{
+					Operation: "add",
+					Path:      "/metadata/annotations",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/volumes",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/initContainers",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/containers/-",
+				},
+				{
+					Operation: "add",
+					Path:      "/spec/containers/-",
+				},
+				{
+					Operation: "add",
+					Path:      "/metadata/annotations/" + escapeJSONPointer(annotationStatus),
+				},
+				{
+					Operation: "add",
+					Path:      "/metadata/labels",
+				},
+				{
+					Operation: "add",
+					Path:      "/metadata/annotations/" + escapeJSONPointer(annotationConsulPartition),
+				},
+			},
+		},
+
 		{
 			"pod with existing label",
 			Handler{
@@ -673,6 +798,69 @@ func TestHandlerPortValue(t *testing.T) {
 	}
 }
 
+func TestPodServices(t *testing.T) {
+	pod := func(service, port string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					annotationService: service,
+					annotationPort:    port,
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "web",
+						Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+					},
+				},
+			},
+		}
+	}
+	cases := map[string]struct {
+		Pod      *corev1.Pod
+		Expected []podService
+		Err      string
+	}{
+		"single port": {
+			Pod:      pod("web", "http"),
+			Expected: []podService{{name: "web", port: "http"}},
+		},
+		"multi port": {
+			Pod:      pod("web, web-admin", "http, 9090"),
+			Expected: []podService{{name: "web", port: "http"}, {name: "web-admin", port: "9090"}},
+		},
+		"fewer ports than services": {
+			Pod: pod("web,web-admin", "http"),
+			Err: "consul.hashicorp.com/connect-service annotation lists 2 services but consul.hashicorp.com/connect-service-port annotation lists 1 ports: multi-port pods must list one service per port",
+		},
+		"duplicate service": {
+			Pod: pod("web,web", "http,9090"),
+			Err: `service name "web" is listed more than once in consul.hashicorp.com/connect-service annotation`,
+		},
+		"invalid service name": {
+			Pod: pod("web,Web_Admin", "http,9090"),
+			Err: `service name "Web_Admin" in consul.hashicorp.com/connect-service annotation is invalid`,
+		},
+		"unknown port name": {
+			Pod: pod("web,web-admin", "http,admin"),
+			Err: `port "admin" of service "web-admin" in consul.hashicorp.com/connect-service-port annotation is invalid: must be a port name or number`,
+		},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			services, err := podServices(tt.Pod)
+			if tt.Err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.Err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.Expected, services)
+		})
+	}
+}
+
 // Test consulNamespace function
 func TestConsulNamespace(t *testing.T) {
 	cases := []struct {
@@ -1082,3 +1270,32 @@ func encodeRaw(t *testing.T, input interface{}) runtime.RawExtension {
 	require.NoError(t, err)
 	return runtime.RawExtension{Raw: data}
 }
+
+// Test that an invalid initial health status annotation is rejected rather
+// than failing every reconcile of the pod's health check.
+func TestHandlerHandle_InvalidInitialHealthStatus(t *testing.T) {
+	handler := Handler{
+		Log:                   hclog.Default().Named("handler"),
+		AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:  mapset.NewSet(),
+	}
+	resp := handler.Mutate(&v1beta1.AdmissionRequest{
+		Object: encodeRaw(t, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					annotationInitialHealthStatus: "warning",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name: "web",
+					},
+				},
+			},
+		}),
+	})
+	require.False(t, resp.Allowed)
+	require.Equal(t, `Error getting the initial health status: consul.hashicorp.com/connect-initial-health-status `+
+		`annotation value of "warning" is invalid: must be "passing" or "critical"`, resp.Result.Message)
+}
@@ -28,6 +28,8 @@ const (
 	kubernetesSuccessReasonMsg = "Kubernetes health checks passing"
 
 	podPendingReasonMsg = "Pod is pending"
+
+	podStartingReasonMsg = "Pod is starting"
 )
 
 // ServiceNotFoundErr is returned when a Consul service instance is not registered.
@@ -42,9 +44,17 @@ type HealthCheckResource struct {
 	ConsulScheme string
 	// ConsulPort is the port to make HTTP API calls to Consul agents on.
 	ConsulPort string
+	// ConsulAPITimeout bounds the calls made to the Consul agents. If 0,
+	// they never time out.
+	ConsulAPITimeout time.Duration
 	// ReconcilePeriod is the period by which reconcile gets called.
 	// default to 1 minute.
 	ReconcilePeriod time.Duration
+	// TopologyMeta, if true, sets the MetaKeyZone and MetaKeyRegion meta of
+	// the services of the pods to the zone and region of their node, which
+	// isn't known when they are injected, so that Consul can prefer
+	// instances in the same zone.
+	TopologyMeta bool
 
 	Ctx  context.Context
 	lock sync.Mutex
@@ -147,9 +157,6 @@ func (h *HealthCheckResource) reconcilePod(pod *corev1.Pod) error {
 		// Skip pods that are not running or have not been properly injected.
 		return nil
 	}
-	// Fetch the identifiers we will use to interact with the Consul agent for this pod.
-	serviceID := h.getConsulServiceID(pod)
-	healthCheckID := h.getConsulHealthCheckID(pod)
 	status, reason, err := h.getReadyStatusAndReason(pod)
 	if err != nil {
 		return fmt.Errorf("unable to get pod status: %s", err)
@@ -159,6 +166,32 @@ func (h *HealthCheckResource) reconcilePod(pod *corev1.Pod) error {
 	if err != nil {
 		return fmt.Errorf("unable to get Consul client connection for %s: %s", pod.Name, err)
 	}
+	// Multi-port pods have a health check for each of their services.
+	for _, serviceName := range serviceNames(pod) {
+		if err := h.reconcileHealthCheck(client, pod, serviceName, status, reason); err != nil {
+			return err
+		}
+	}
+	if h.TopologyMeta {
+		topology, err := h.nodeTopology(pod.Spec.NodeName)
+		if err != nil {
+			return err
+		}
+		for _, serviceName := range serviceNames(pod) {
+			if err := h.reconcileTopologyMeta(client, pod, serviceName, topology); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileHealthCheck registers or updates the health check of the service
+// serviceName of pod so that it has status.
+func (h *HealthCheckResource) reconcileHealthCheck(client *api.Client, pod *corev1.Pod, serviceName, status, reason string) error {
+	// Fetch the identifiers we will use to interact with the Consul agent for this pod.
+	serviceID := h.getConsulServiceID(pod, serviceName)
+	healthCheckID := h.getConsulHealthCheckID(pod, serviceName)
 	// Retrieve the health check that would exist if the service had one registered for this pod.
 	serviceCheck, err := h.getServiceCheck(client, healthCheckID)
 	if err != nil {
@@ -244,17 +277,26 @@ func (h *HealthCheckResource) getServiceCheck(client *api.Client, healthCheckID
 // ready state of the pod along with the reason message which will be passed into the Notes
 // field of the Consul health check.
 func (h *HealthCheckResource) getReadyStatusAndReason(pod *corev1.Pod) (string, string, error) {
+	initialStatus, err := initialHealthStatus(pod)
+	if err != nil {
+		return "", "", err
+	}
+
 	// A pod might be pending if the init containers have run but the non-init
-	// containers haven't reached running state. In this case we set a failing health
-	// check so the pod doesn't receive traffic before it's ready.
+	// containers haven't reached running state. In this case we set the
+	// initial status, failing by default, so the pod doesn't receive traffic
+	// before it's ready.
 	if pod.Status.Phase == corev1.PodPending {
-		return api.HealthCritical, podPendingReasonMsg, nil
+		return initialStatus, podPendingReasonMsg, nil
 	}
 
 	for _, cond := range pod.Status.Conditions {
 		var consulStatus, reason string
 		if cond.Type == corev1.PodReady {
-			if cond.Status != corev1.ConditionTrue {
+			if cond.Status != corev1.ConditionTrue && isStarting(pod) {
+				consulStatus = initialStatus
+				reason = podStartingReasonMsg
+			} else if cond.Status != corev1.ConditionTrue {
 				consulStatus = api.HealthCritical
 				reason = cond.Message
 			} else {
@@ -267,6 +309,33 @@ func (h *HealthCheckResource) getReadyStatusAndReason(pod *corev1.Pod) (string,
 	return "", "", fmt.Errorf("no ready status for pod: %s", pod.Name)
 }
 
+// initialHealthStatus returns the status of the health check while pod is
+// starting, from the annotationInitialHealthStatus annotation.
+func initialHealthStatus(pod *corev1.Pod) (string, error) {
+	raw, ok := pod.Annotations[annotationInitialHealthStatus]
+	if !ok {
+		return api.HealthCritical, nil
+	}
+	if raw != api.HealthPassing && raw != api.HealthCritical {
+		return "", fmt.Errorf("%s annotation value of %q is invalid: must be %q or %q",
+			annotationInitialHealthStatus, raw, api.HealthPassing, api.HealthCritical)
+	}
+	return raw, nil
+}
+
+// isStarting returns true if any of the pod's containers hasn't started yet,
+// either because it isn't running or because its startup probe hasn't
+// succeeded, and hasn't failed before. Kubelet doesn't run readiness probes
+// until then so the pod's ready condition doesn't say whether it's healthy.
+func isStarting(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.RestartCount == 0 && (c.State.Waiting != nil || (c.Started != nil && !*c.Started)) {
+			return true
+		}
+	}
+	return false
+}
+
 // getConsulClient returns an *api.Client that points at the consul agent local to the pod.
 func (h *HealthCheckResource) getConsulClient(pod *corev1.Pod) (*api.Client, error) {
 	newAddr := fmt.Sprintf("%s://%s:%s", h.ConsulScheme, pod.Status.HostIP, h.ConsulPort)
@@ -275,6 +344,14 @@ func (h *HealthCheckResource) getConsulClient(pod *corev1.Pod) (*api.Client, err
 	if pod.Annotations[annotationConsulNamespace] != "" {
 		localConfig.Namespace = pod.Annotations[annotationConsulNamespace]
 	}
+	if err := consul.SetPartition(localConfig, pod.Annotations[annotationConsulPartition]); err != nil {
+		h.Log.Error("unable to get Consul API Client", "addr", newAddr, "err", err)
+		return nil, err
+	}
+	if err := consul.SetTimeout(localConfig, h.ConsulAPITimeout); err != nil {
+		h.Log.Error("unable to get Consul API Client", "addr", newAddr, "err", err)
+		return nil, err
+	}
 	localClient, err := consul.NewClient(localConfig)
 	if err != nil {
 		h.Log.Error("unable to get Consul API Client", "addr", newAddr, "err", err)
@@ -330,11 +407,11 @@ func (h *HealthCheckResource) shouldProcess(pod *corev1.Pod) bool {
 
 // getConsulHealthCheckID deterministically generates a health check ID that will be unique to the Agent
 // where the health check is registered and deregistered.
-func (h *HealthCheckResource) getConsulHealthCheckID(pod *corev1.Pod) string {
-	return fmt.Sprintf("%s/%s/kubernetes-health-check", pod.Namespace, h.getConsulServiceID(pod))
+func (h *HealthCheckResource) getConsulHealthCheckID(pod *corev1.Pod, serviceName string) string {
+	return fmt.Sprintf("%s/%s/kubernetes-health-check", pod.Namespace, h.getConsulServiceID(pod, serviceName))
 }
 
-// getConsulServiceID returns the serviceID of the connect service.
-func (h *HealthCheckResource) getConsulServiceID(pod *corev1.Pod) string {
-	return fmt.Sprintf("%s-%s", pod.Name, pod.Annotations[annotationService])
+// getConsulServiceID returns the serviceID of the connect service serviceName.
+func (h *HealthCheckResource) getConsulServiceID(pod *corev1.Pod, serviceName string) string {
+	return fmt.Sprintf("%s-%s", pod.Name, serviceName)
 }
@@ -54,6 +54,19 @@ var completedInjectInitContainer = []corev1.ContainerStatus{
 	},
 }
 
+// startupStatus returns the status of a running container whose startup
+// probe has succeeded if started is true.
+func startupStatus(started bool, restartCount int32) []corev1.ContainerStatus {
+	return []corev1.ContainerStatus{
+		{
+			Name:         testPodName,
+			State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			Started:      &started,
+			RestartCount: restartCount,
+		},
+	}
+}
+
 func registerHealthCheck(t *testing.T, client *api.Client, initialState string) {
 	require := require.New(t)
 	err := client.Agent().CheckRegister(&api.AgentCheckRegistration{
@@ -297,6 +310,184 @@ func TestReconcilePod(t *testing.T) {
 			},
 			"",
 		},
+		{
+			"starting pod is critical by default",
+			false,
+			"",
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      testPodName,
+					Namespace: "default",
+					Labels:    map[string]string{labelInject: "true"},
+					Annotations: map[string]string{
+						annotationStatus:  injected,
+						annotationService: testServiceNameAnnotation,
+					},
+				},
+				Spec: testPodSpec,
+				Status: corev1.PodStatus{
+					HostIP:                "127.0.0.1",
+					Phase:                 corev1.PodRunning,
+					InitContainerStatuses: completedInjectInitContainer,
+					ContainerStatuses:     startupStatus(false, 0),
+					Conditions: []corev1.PodCondition{{
+						Type:    corev1.PodReady,
+						Status:  corev1.ConditionFalse,
+						Message: testFailureMessage,
+					}},
+				},
+			},
+			&api.AgentCheck{
+				CheckID: testHealthCheckID,
+				Status:  api.HealthCritical,
+				Output:  podStartingReasonMsg,
+				Type:    ttl,
+				Name:    name,
+			},
+			"",
+		},
+		{
+			"starting pod uses initial status",
+			false,
+			"",
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      testPodName,
+					Namespace: "default",
+					Labels:    map[string]string{labelInject: "true"},
+					Annotations: map[string]string{
+						annotationStatus:              injected,
+						annotationService:             testServiceNameAnnotation,
+						annotationInitialHealthStatus: api.HealthPassing,
+					},
+				},
+				Spec: testPodSpec,
+				Status: corev1.PodStatus{
+					HostIP:                "127.0.0.1",
+					Phase:                 corev1.PodRunning,
+					InitContainerStatuses: completedInjectInitContainer,
+					ContainerStatuses:     startupStatus(false, 0),
+					Conditions: []corev1.PodCondition{{
+						Type:    corev1.PodReady,
+						Status:  corev1.ConditionFalse,
+						Message: testFailureMessage,
+					}},
+				},
+			},
+			&api.AgentCheck{
+				CheckID: testHealthCheckID,
+				Status:  api.HealthPassing,
+				Output:  podStartingReasonMsg,
+				Type:    ttl,
+				Name:    name,
+			},
+			"",
+		},
+		{
+			"started pod failing readiness ignores initial status",
+			false,
+			"",
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      testPodName,
+					Namespace: "default",
+					Labels:    map[string]string{labelInject: "true"},
+					Annotations: map[string]string{
+						annotationStatus:              injected,
+						annotationService:             testServiceNameAnnotation,
+						annotationInitialHealthStatus: api.HealthPassing,
+					},
+				},
+				Spec: testPodSpec,
+				Status: corev1.PodStatus{
+					HostIP:                "127.0.0.1",
+					Phase:                 corev1.PodRunning,
+					InitContainerStatuses: completedInjectInitContainer,
+					ContainerStatuses:     startupStatus(true, 0),
+					Conditions: []corev1.PodCondition{{
+						Type:    corev1.PodReady,
+						Status:  corev1.ConditionFalse,
+						Message: testFailureMessage,
+					}},
+				},
+			},
+			&api.AgentCheck{
+				CheckID: testHealthCheckID,
+				Status:  api.HealthCritical,
+				Output:  testFailureMessage,
+				Type:    ttl,
+				Name:    name,
+			},
+			"",
+		},
+		{
+			"restarted pod ignores initial status",
+			false,
+			"",
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      testPodName,
+					Namespace: "default",
+					Labels:    map[string]string{labelInject: "true"},
+					Annotations: map[string]string{
+						annotationStatus:              injected,
+						annotationService:             testServiceNameAnnotation,
+						annotationInitialHealthStatus: api.HealthPassing,
+					},
+				},
+				Spec: testPodSpec,
+				Status: corev1.PodStatus{
+					HostIP:                "127.0.0.1",
+					Phase:                 corev1.PodRunning,
+					InitContainerStatuses: completedInjectInitContainer,
+					ContainerStatuses:     startupStatus(false, 1),
+					Conditions: []corev1.PodCondition{{
+						Type:    corev1.PodReady,
+						Status:  corev1.ConditionFalse,
+						Message: testFailureMessage,
+					}},
+				},
+			},
+			&api.AgentCheck{
+				CheckID: testHealthCheckID,
+				Status:  api.HealthCritical,
+				Output:  testFailureMessage,
+				Type:    ttl,
+				Name:    name,
+			},
+			"",
+		},
+		{
+			"invalid initial status",
+			false,
+			"",
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      testPodName,
+					Namespace: "default",
+					Labels:    map[string]string{labelInject: "true"},
+					Annotations: map[string]string{
+						annotationStatus:              injected,
+						annotationService:             testServiceNameAnnotation,
+						annotationInitialHealthStatus: "warning",
+					},
+				},
+				Spec: testPodSpec,
+				Status: corev1.PodStatus{
+					HostIP:                "127.0.0.1",
+					Phase:                 corev1.PodRunning,
+					InitContainerStatuses: completedInjectInitContainer,
+					ContainerStatuses:     startupStatus(false, 0),
+					Conditions: []corev1.PodCondition{{
+						Type:    corev1.PodReady,
+						Status:  corev1.ConditionFalse,
+						Message: testFailureMessage,
+					}},
+				},
+			},
+			nil,
+			"consul.hashicorp.com/connect-initial-health-status annotation value of \"warning\" is invalid",
+		},
 		{
 			"PodRunning no annotations will be ignored for processing",
 			false,
@@ -377,6 +568,46 @@ func TestReconcilePod(t *testing.T) {
 	}
 }
 
+// Test that multi-port pods get a health check for each of their services.
+func TestReconcilePod_MultiPort(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testPodName,
+			Namespace: "default",
+			Labels:    map[string]string{labelInject: "true"},
+			Annotations: map[string]string{
+				annotationStatus:  injected,
+				annotationService: "web,web-admin",
+				annotationPort:    "8080,9090",
+			},
+		},
+		Spec: testPodSpec,
+		Status: corev1.PodStatus{
+			HostIP:                "127.0.0.1",
+			Phase:                 corev1.PodRunning,
+			InitContainerStatuses: completedInjectInitContainer,
+			Conditions: []corev1.PodCondition{{
+				Type:   corev1.PodReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+	server, client, resource := testServerAgentResourceAndController(t, pod)
+	defer server.Stop()
+	server.AddService(t, "test-pod-web", api.HealthPassing, nil)
+	server.AddService(t, "test-pod-web-admin", api.HealthPassing, nil)
+
+	require.NoError(resource.reconcilePod(pod))
+	for _, serviceID := range []string{"test-pod-web", "test-pod-web-admin"} {
+		check := getConsulAgentChecks(t, client, fmt.Sprintf("default/%s/kubernetes-health-check", serviceID))
+		require.NotNil(check, serviceID)
+		require.Equal(serviceID, check.ServiceID)
+		require.Equal(api.HealthPassing, check.Status)
+	}
+}
+
 // Test that when we call upsert and the service hasn't been registered
 // in Consul yet, we don't return an error.
 func TestUpsert_PodWithNoService(t *testing.T) {
@@ -0,0 +1,72 @@
+package connectinject
+
+import (
+	"fmt"
+	"strings"
+)
+
+// jobSidecarMarker is in the command of the sidecars of Jobs so that they
+// can tell their processes apart from those of the other containers.
+const jobSidecarMarker = "consul-connect-job-sidecar"
+
+// jobSidecarCommand returns the command of a sidecar of a Job that runs
+// command until the other containers of the pod have exited, then stops it
+// and runs the shell commands preStop if it isn't empty. Exiting with
+// status 0 lets the pod complete.
+func jobSidecarCommand(command []string, preStop string) []string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	if preStop != "" {
+		preStop = fmt.Sprintf("    ( %s ) || echo \"Error running the preStop commands\"\n",
+			strings.Replace(preStop, "\n", "\n      ", -1))
+	}
+	return []string{
+		"/bin/sh",
+		"-ec",
+		fmt.Sprintf(jobSidecarCommandTpl, jobSidecarMarker, strings.Join(quoted, " "), preStop),
+	}
+}
+
+// jobSidecarCommandTpl is formatted with the marker, the quoted command and
+// the preStop commands. The main processes of the containers are the ones
+// without a parent in the pod's process namespace other than the pause
+// process, PID 1. Containers get a few seconds without any processes before
+// the sidecar stops in case they're restarting.
+const jobSidecarCommandTpl = `# %[1]s
+others_running() {
+  for stat in /proc/[0-9]*/stat; do
+    p=${stat#/proc/}
+    p=${p%%/stat}
+    case "$p" in 1|"$$") continue ;; esac
+    line=$(cat "$stat" 2>/dev/null) || continue
+    set -- ${line##*") "}
+    [ "$2" = 0 ] && [ "$1" != Z ] || continue
+    case "$(tr '\0' ' ' < "/proc/$p/cmdline" 2>/dev/null)" in
+      *%[1]s*) continue ;;
+    esac
+    return 0
+  done
+  return 1
+}
+
+%[2]s &
+pid=$!
+trap 'kill -TERM "$pid" 2>/dev/null' TERM INT
+idle=0
+while kill -0 "$pid" 2>/dev/null; do
+  if others_running; then
+    idle=0
+  elif [ "$idle" -ge 5 ]; then
+    echo "The other containers have exited, stopping"
+    kill -TERM "$pid" 2>/dev/null || true
+    wait "$pid" || true
+%[3]s    exit 0
+  else
+    idle=$((idle + 1))
+  fi
+  sleep 1
+done
+wait "$pid"
+`
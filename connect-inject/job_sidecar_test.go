@@ -0,0 +1,19 @@
+package connectinject
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the arguments are quoted so that the shell runs the command as
+// it would have been run without it.
+func TestJobSidecarCommand(t *testing.T) {
+	cmd := jobSidecarCommand([]string{"envoy", "--log-format", "it's %v"}, "deregister \\\n  service.hcl")
+	require.Contains(t, cmd[2], `'envoy' '--log-format' 'it'\''s %v' &`)
+	if sh, err := exec.LookPath("sh"); err == nil {
+		out, err := exec.Command(sh, "-n", "-c", cmd[2]).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+}
@@ -0,0 +1,61 @@
+package connectinject
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mattbaird/jsonpatch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespaceDefaultAnnotations are the pod annotations that can be set on a
+// namespace as the default for the pods in it. The protocol isn't one of
+// them since it's set with ServiceDefaults resources instead.
+var namespaceDefaultAnnotations = []string{
+	annotationInject,
+	annotationSidecarProxyCPULimit,
+	annotationSidecarProxyCPURequest,
+	annotationSidecarProxyMemoryLimit,
+	annotationSidecarProxyMemoryRequest,
+	annotationEnvoyExtraArgs,
+	annotationEnvoyTracingProvider,
+	annotationEnvoyTracingCollectorAddr,
+	annotationEnvoyTracingSampleRate,
+	annotationEnvoyBootstrapOverrides,
+}
+
+// namespaceDefaults copies the namespaceDefaultAnnotations set on the
+// namespace k8sNamespace onto the pod unless the pod has its own value.
+// The copied annotations are added to the pod with patches too so that
+// they're kept when it's created.
+func (h *Handler) namespaceDefaults(pod *corev1.Pod, k8sNamespace string, patches *[]jsonpatch.JsonPatchOperation) error {
+	ns, err := h.KubernetesClient.CoreV1().Namespaces().Get(context.Background(), k8sNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting namespace %s: %s", k8sNamespace, err)
+	}
+
+	defaults := make(map[string]string)
+	for _, key := range namespaceDefaultAnnotations {
+		if _, ok := pod.Annotations[key]; ok {
+			continue
+		}
+		if value, ok := ns.Annotations[key]; ok {
+			defaults[key] = value
+		}
+	}
+	if len(defaults) == 0 {
+		return nil
+	}
+
+	// Create the patch first, so that the Annotation object will be
+	// created if necessary.
+	*patches = append(*patches, updateAnnotation(pod.Annotations, defaults)...)
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	for key, value := range defaults {
+		pod.Annotations[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package connectinject
+
+import (
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the namespace's annotations are used as defaults for those the
+// pod doesn't set.
+func TestHandlerNamespaceDefaults(t *testing.T) {
+	cases := map[string]struct {
+		nsAnnotations  map[string]string
+		podAnnotations map[string]string
+		expInjected    bool
+		expCPULimit    string
+	}{
+		"no namespace annotations": {
+			expInjected: true,
+		},
+		"namespace disables injection": {
+			nsAnnotations: map[string]string{annotationInject: "false"},
+			expInjected:   false,
+		},
+		"pod overrides namespace injection": {
+			nsAnnotations:  map[string]string{annotationInject: "false"},
+			podAnnotations: map[string]string{annotationInject: "true"},
+			expInjected:    true,
+		},
+		"namespace sidecar resources": {
+			nsAnnotations: map[string]string{annotationSidecarProxyCPULimit: "200m"},
+			expInjected:   true,
+			expCPULimit:   "200m",
+		},
+		"pod overrides namespace sidecar resources": {
+			nsAnnotations:  map[string]string{annotationSidecarProxyCPULimit: "200m"},
+			podAnnotations: map[string]string{annotationSidecarProxyCPULimit: "100m"},
+			expInjected:    true,
+			expCPULimit:    "100m",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				Log:                     hclog.Default().Named("handler"),
+				AllowK8sNamespacesSet:   mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:    mapset.NewSet(),
+				EnableNamespaceDefaults: true,
+				KubernetesClient: fake.NewSimpleClientset(&corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: c.nsAnnotations},
+				}),
+			}
+			pod, warnings, err := h.DryRun(corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.podAnnotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			}, "app")
+			require.NoError(t, err)
+			require.Empty(t, warnings)
+
+			if !c.expInjected {
+				require.Empty(t, pod.Annotations[annotationStatus])
+				require.Len(t, pod.Spec.Containers, 1)
+				return
+			}
+			require.Equal(t, injected, pod.Annotations[annotationStatus])
+			for key, value := range c.nsAnnotations {
+				if _, ok := c.podAnnotations[key]; !ok {
+					require.Equal(t, value, pod.Annotations[key])
+				}
+			}
+			if c.expCPULimit != "" {
+				require.Equal(t, resource.MustParse(c.expCPULimit), pod.Spec.Containers[1].Resources.Limits[corev1.ResourceCPU])
+			}
+		})
+	}
+}
+
+// Test that the namespace isn't read unless namespace defaults are enabled.
+func TestHandlerNamespaceDefaults_Disabled(t *testing.T) {
+	h := Handler{
+		Log:                   hclog.Default().Named("handler"),
+		AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:  mapset.NewSet(),
+		KubernetesClient: fake.NewSimpleClientset(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: map[string]string{annotationInject: "false"}},
+		}),
+	}
+	pod, _, err := h.DryRun(corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+	}, "app")
+	require.NoError(t, err)
+	require.Equal(t, injected, pod.Annotations[annotationStatus])
+}
+
+// Test that pods are rejected if their namespace can't be read.
+func TestHandlerNamespaceDefaults_NamespaceNotFound(t *testing.T) {
+	h := Handler{
+		Log:                     hclog.Default().Named("handler"),
+		AllowK8sNamespacesSet:   mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:    mapset.NewSet(),
+		EnableNamespaceDefaults: true,
+		KubernetesClient:        fake.NewSimpleClientset(),
+	}
+	_, _, err := h.DryRun(corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+	}, "app")
+	require.EqualError(t, err, `Error applying namespace defaults: getting namespace app: namespaces "app" not found`)
+}
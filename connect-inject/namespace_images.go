@@ -0,0 +1,126 @@
+package connectinject
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// namespaceImages are the images used for the pods of a Kubernetes namespace
+// instead of the injector's -consul-image and -envoy-image. Either may be
+// empty to keep the injector's image.
+type namespaceImages struct {
+	ImageConsul string `json:"consulImage"`
+	ImageEnvoy  string `json:"envoyImage"`
+}
+
+// NamespaceImagesResource is a controller.Resource that watches a ConfigMap
+// of the images to use for the pods of some Kubernetes namespaces, so that a
+// new Consul or Envoy version can be rolled out to one namespace at a time.
+// Each key of the ConfigMap is a Kubernetes namespace and its value is YAML
+// of the form:
+//
+//	consulImage: "hashicorp/consul:1.9.4"
+//	envoyImage: "envoyproxy/envoy-alpine:v1.16.2"
+//
+// Values that aren't valid are logged and ignored.
+type NamespaceImagesResource struct {
+	Log              hclog.Logger
+	KubernetesClient kubernetes.Interface
+	Ctx              context.Context
+
+	// Namespace and Name are the namespace and name of the ConfigMap.
+	Namespace string
+	Name      string
+
+	lock   sync.RWMutex
+	images map[string]namespaceImages
+}
+
+// Informer watches the ConfigMap.
+func (r *NamespaceImagesResource) Informer() cache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector("metadata.name", r.Name).String()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return r.KubernetesClient.CoreV1().ConfigMaps(r.Namespace).List(r.Ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return r.KubernetesClient.CoreV1().ConfigMaps(r.Namespace).Watch(r.Ctx, options)
+			},
+		},
+		&corev1.ConfigMap{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// Upsert replaces the images with the ones in the ConfigMap.
+func (r *NamespaceImagesResource) Upsert(_ string, obj interface{}) error {
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return nil
+	}
+	images := make(map[string]namespaceImages, len(configMap.Data))
+	for ns, raw := range configMap.Data {
+		var nsImages namespaceImages
+		if err := yaml.UnmarshalStrict([]byte(raw), &nsImages); err != nil {
+			r.Log.Error("ignoring invalid images for namespace", "namespace", ns, "err", err)
+			continue
+		}
+		images[ns] = nsImages
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.images = images
+	r.Log.Info("updated namespace images", "namespaces", len(images))
+	return nil
+}
+
+// Delete removes the images so that the injector's are used again.
+func (r *NamespaceImagesResource) Delete(_ string, _ interface{}) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.images = nil
+	r.Log.Info("namespace images ConfigMap deleted")
+	return nil
+}
+
+// namespaceImages returns the images for the pods of k8sNamespace. It's
+// safe to call on a nil *NamespaceImagesResource.
+func (r *NamespaceImagesResource) namespaceImages(k8sNamespace string) namespaceImages {
+	if r == nil {
+		return namespaceImages{}
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.images[k8sNamespace]
+}
+
+// imageConsul returns the Consul image for the pods of k8sNamespace.
+func (h *Handler) imageConsul(k8sNamespace string) string {
+	if image := h.NamespaceImages.namespaceImages(k8sNamespace).ImageConsul; image != "" {
+		return image
+	}
+	return h.ImageConsul
+}
+
+// imageEnvoy returns the Envoy image for the pods of k8sNamespace.
+func (h *Handler) imageEnvoy(k8sNamespace string) string {
+	if image := h.NamespaceImages.namespaceImages(k8sNamespace).ImageEnvoy; image != "" {
+		return image
+	}
+	return h.ImageEnvoy
+}
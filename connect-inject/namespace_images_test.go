@@ -0,0 +1,99 @@
+package connectinject
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceImagesResource(t *testing.T) {
+	t.Parallel()
+	r := &NamespaceImagesResource{Log: hclog.NewNullLogger()}
+	h := Handler{
+		ImageConsul:     "consul:latest",
+		ImageEnvoy:      "envoy:latest",
+		NamespaceImages: r,
+	}
+
+	require.NoError(t, r.Upsert("", &corev1.ConfigMap{
+		Data: map[string]string{
+			"canary":  "consulImage: consul:canary\nenvoyImage: envoy:canary",
+			"envoy":   "envoyImage: envoy:canary",
+			"invalid": "image: envoy:canary",
+		},
+	}))
+	require.Equal(t, "consul:canary", h.imageConsul("canary"))
+	require.Equal(t, "envoy:canary", h.imageEnvoy("canary"))
+	require.Equal(t, "consul:latest", h.imageConsul("envoy"))
+	require.Equal(t, "envoy:canary", h.imageEnvoy("envoy"))
+	require.Equal(t, "envoy:latest", h.imageEnvoy("invalid"))
+	require.Equal(t, "envoy:latest", h.imageEnvoy(k8sNamespace))
+
+	// Namespaces removed from the ConfigMap use the handler's images again.
+	require.NoError(t, r.Upsert("", &corev1.ConfigMap{
+		Data: map[string]string{"envoy": "envoyImage: envoy:canary"},
+	}))
+	require.Equal(t, "envoy:latest", h.imageEnvoy("canary"))
+	require.Equal(t, "envoy:canary", h.imageEnvoy("envoy"))
+
+	require.NoError(t, r.Delete("", nil))
+	require.Equal(t, "envoy:latest", h.imageEnvoy("envoy"))
+
+	h.NamespaceImages = nil
+	require.Equal(t, "consul:latest", h.imageConsul("canary"))
+}
+
+// Test that the images of the ConfigMap are used for the injected containers
+// once the ConfigMap is created.
+func TestHandler_NamespaceImages(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	r := &NamespaceImagesResource{
+		Log:              hclog.NewNullLogger(),
+		KubernetesClient: client,
+		Ctx:              context.Background(),
+		Namespace:        "consul",
+		Name:             "images",
+	}
+	closer := controller.TestControllerRun(r)
+	defer closer()
+	h := Handler{
+		ImageConsul:     "consul:latest",
+		ImageEnvoy:      "envoy:latest",
+		NamespaceImages: r,
+	}
+
+	_, err := client.CoreV1().ConfigMaps("consul").Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "images", Namespace: "consul"},
+		Data: map[string]string{
+			"canary": "consulImage: consul:canary\nenvoyImage: envoy:canary",
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{annotationService: "web"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+	}
+	retry.Run(t, func(r *retry.R) {
+		init, err := h.containerInit(pod, "canary")
+		require.NoError(r, err)
+		require.Equal(r, "consul:canary", init.Image)
+		sidecar, err := h.envoySidecar(pod, "canary")
+		require.NoError(r, err)
+		require.Equal(r, "envoy:canary", sidecar.Image)
+	})
+
+	sidecar, err := h.envoySidecar(pod, k8sNamespace)
+	require.NoError(t, err)
+	require.Equal(t, "envoy:latest", sidecar.Image)
+}
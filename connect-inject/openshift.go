@@ -0,0 +1,67 @@
+package connectinject
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotationOpenShiftUIDRange is the annotation OpenShift sets on namespaces
+// to the range of user IDs that the restricted SCCs allow pods in it to run
+// as, in the form <first>/<size>.
+const annotationOpenShiftUIDRange = "openshift.io/sa.scc.uid-range"
+
+// openShiftSecurityContext returns the security context of the containers
+// injected into pods in the namespace k8sNamespace so that they're admitted
+// by the restricted SCC: they run as the first user ID of the namespace's
+// range, with that ID as their group, and without any privileges.
+func (h *Handler) openShiftSecurityContext(k8sNamespace string) (*corev1.SecurityContext, error) {
+	ns, err := h.KubernetesClient.CoreV1().Namespaces().Get(context.Background(), k8sNamespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting namespace %s: %s", k8sNamespace, err)
+	}
+	raw, ok := ns.Annotations[annotationOpenShiftUIDRange]
+	if !ok {
+		return nil, fmt.Errorf("namespace %s has no %s annotation", k8sNamespace, annotationOpenShiftUIDRange)
+	}
+	uid, err := parseUIDRange(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s annotation value of %q on namespace %s is invalid: %s",
+			annotationOpenShiftUIDRange, raw, k8sNamespace, err)
+	}
+
+	runAsNonRoot := true
+	privileged := false
+	return &corev1.SecurityContext{
+		RunAsUser:                &uid,
+		RunAsGroup:               &uid,
+		RunAsNonRoot:             &runAsNonRoot,
+		Privileged:               &privileged,
+		AllowPrivilegeEscalation: &privileged,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}, nil
+}
+
+// parseUIDRange returns the first user ID of a range in the form
+// <first>/<size>.
+func parseUIDRange(raw string) (int64, error) {
+	parts := strings.Split(raw, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("must be in the form <first>/<size>")
+	}
+	first, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || first <= 0 {
+		return 0, fmt.Errorf("first user ID must be a positive integer")
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("size must be a positive integer")
+	}
+	return first, nil
+}
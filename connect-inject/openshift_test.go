@@ -0,0 +1,118 @@
+package connectinject
+
+import (
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the injected containers run as the first user ID of the
+// namespace's range and without privileges.
+func TestHandlerOpenShift(t *testing.T) {
+	cases := map[string]struct {
+		annotations map[string]string
+		expUID      int64
+		expErr      string
+	}{
+		"uid range": {
+			annotations: map[string]string{annotationOpenShiftUIDRange: "1000620000/10000"},
+			expUID:      1000620000,
+		},
+		"no annotation": {
+			expErr: "Error configuring OpenShift security context: namespace openshift-app has no openshift.io/sa.scc.uid-range annotation",
+		},
+		"invalid annotation": {
+			annotations: map[string]string{annotationOpenShiftUIDRange: "1000620000-1000629999"},
+			expErr: `Error configuring OpenShift security context: openshift.io/sa.scc.uid-range annotation value of ` +
+				`"1000620000-1000629999" on namespace openshift-app is invalid: must be in the form <first>/<size>`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				Log:                   hclog.Default().Named("handler"),
+				AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:  mapset.NewSet(),
+				EnableOpenShift:       true,
+				KubernetesClient: fake.NewSimpleClientset(&corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{Name: "openshift-app", Annotations: c.annotations},
+				}),
+			}
+			pod, warnings, err := h.DryRun(corev1.Pod{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			}, "openshift-app")
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Empty(t, warnings)
+
+			// The pod's own containers are left alone.
+			require.Nil(t, pod.Spec.Containers[0].SecurityContext)
+			injected := append(pod.Spec.InitContainers, pod.Spec.Containers[1:]...)
+			require.Len(t, injected, 3)
+			for _, container := range injected {
+				sc := container.SecurityContext
+				require.NotNil(t, sc, container.Name)
+				require.Equal(t, c.expUID, *sc.RunAsUser, container.Name)
+				require.Equal(t, c.expUID, *sc.RunAsGroup, container.Name)
+				require.True(t, *sc.RunAsNonRoot, container.Name)
+				require.False(t, *sc.Privileged, container.Name)
+				require.False(t, *sc.AllowPrivilegeEscalation, container.Name)
+				require.Equal(t, []corev1.Capability{"ALL"}, sc.Capabilities.Drop, container.Name)
+			}
+		})
+	}
+}
+
+// Test that dry runs without a Kubernetes client warn that the user IDs
+// aren't set.
+func TestHandlerOpenShift_DryRunWithoutClient(t *testing.T) {
+	h := Handler{
+		Log:                   hclog.Default().Named("handler"),
+		AllowK8sNamespacesSet: mapset.NewSetWith("*"),
+		DenyK8sNamespacesSet:  mapset.NewSet(),
+		EnableOpenShift:       true,
+	}
+	pod, warnings, err := h.DryRun(corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+	}, "openshift-app")
+	require.NoError(t, err)
+	require.Nil(t, pod.Spec.InitContainers[0].SecurityContext)
+	require.Equal(t, []string{
+		"the user and group of the injected containers aren't set since the openshift.io/sa.scc.uid-range " +
+			"annotation of namespace openshift-app can't be read without a Kubernetes client",
+	}, warnings)
+}
+
+func TestParseUIDRange(t *testing.T) {
+	cases := map[string]struct {
+		raw    string
+		exp    int64
+		expErr string
+	}{
+		"valid":          {raw: "1000620000/10000", exp: 1000620000},
+		"no size":        {raw: "1000620000", expErr: "must be in the form <first>/<size>"},
+		"invalid first":  {raw: "abc/10000", expErr: "first user ID must be a positive integer"},
+		"root":           {raw: "0/10000", expErr: "first user ID must be a positive integer"},
+		"invalid size":   {raw: "1000620000/0", expErr: "size must be a positive integer"},
+		"too many parts": {raw: "1/2/3", expErr: "must be in the form <first>/<size>"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			uid, err := parseUIDRange(c.raw)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, uid)
+		})
+	}
+}
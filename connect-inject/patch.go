@@ -1,6 +1,7 @@
 package connectinject
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/mattbaird/jsonpatch"
@@ -90,11 +91,17 @@ func updateAnnotation(target, add map[string]string) []jsonpatch.JsonPatchOperat
 		return result
 	}
 
-	for key, value := range add {
+	// Sort the keys so that the patches are the same for every request.
+	keys := make([]string, 0, len(add))
+	for key := range add {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
 		result = append(result, jsonpatch.JsonPatchOperation{
 			Operation: "add",
 			Path:      "/metadata/annotations/" + escapeJSONPointer(key),
-			Value:     value,
+			Value:     add[key],
 		})
 	}
 
@@ -0,0 +1,43 @@
+package connectinject
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ServiceAccountValidationStrict rejects the pods whose ServiceAccount
+	// doesn't match their service when ACLs are enabled.
+	ServiceAccountValidationStrict = "strict"
+
+	// ServiceAccountValidationPermissive only logs a warning for the pods
+	// whose ServiceAccount doesn't match their service, e.g. when the binding
+	// rules of the auth method don't bind the service identities to the
+	// ServiceAccount names.
+	ServiceAccountValidationPermissive = "permissive"
+)
+
+// validateServiceAccount returns an error if ACLs are enabled and the pod's
+// ServiceAccount doesn't have the name of its service. The ACL token the
+// init container gets from `consul login` is only valid for the service with
+// the ServiceAccount's name, so the service would fail to register.
+//
+// Multi-port pods aren't checked since they're rejected when ACLs are enabled
+// anyway.
+func (h *Handler) validateServiceAccount(pod *corev1.Pod) error {
+	if h.AuthMethod == "" {
+		return nil
+	}
+	services, err := podServices(pod)
+	if err != nil || multiPort(services) {
+		return nil
+	}
+	if services[0].name != pod.Spec.ServiceAccountName {
+		return fmt.Errorf("serviceAccountName %q does not match service name %q: when ACLs are enabled the pod "+
+			"must run as a ServiceAccount named after its service, either create the ServiceAccount %q or "+
+			"set the %s annotation to %q",
+			pod.Spec.ServiceAccountName, services[0].name, services[0].name, annotationService, pod.Spec.ServiceAccountName)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package connectinject
+
+import (
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test that when ACLs are enabled, pods whose ServiceAccount doesn't match
+// their service are rejected unless the validation is permissive.
+func TestHandlerMutate_ServiceAccountValidation(t *testing.T) {
+	cases := map[string]struct {
+		authMethod     string
+		validation     string
+		serviceAccount string
+		expErr         string
+	}{
+		"ACLs disabled": {
+			serviceAccount: "default",
+		},
+		"matching service account": {
+			authMethod:     "auth-method",
+			serviceAccount: "web",
+		},
+		"mismatched service account defaults to strict": {
+			authMethod:     "auth-method",
+			serviceAccount: "default",
+			expErr: `Error validating service account: serviceAccountName "default" does not match service name "web": ` +
+				`when ACLs are enabled the pod must run as a ServiceAccount named after its service, either create the ` +
+				`ServiceAccount "web" or set the consul.hashicorp.com/connect-service annotation to "default"`,
+		},
+		"mismatched service account strict": {
+			authMethod:     "auth-method",
+			validation:     ServiceAccountValidationStrict,
+			serviceAccount: "default",
+			expErr:         `Error validating service account: serviceAccountName "default" does not match service name "web"`,
+		},
+		"mismatched service account permissive": {
+			authMethod:     "auth-method",
+			validation:     ServiceAccountValidationPermissive,
+			serviceAccount: "default",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := Handler{
+				Log:                      hclog.Default().Named("handler"),
+				AllowK8sNamespacesSet:    mapset.NewSetWith("*"),
+				DenyK8sNamespacesSet:     mapset.NewSet(),
+				AuthMethod:               c.authMethod,
+				ServiceAccountValidation: c.validation,
+			}
+			req := v1beta1.AdmissionRequest{
+				Namespace: "default",
+				Object: encodeRaw(t, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							annotationService: "web",
+						},
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{
+								Name: "web",
+								VolumeMounts: []corev1.VolumeMount{
+									{
+										Name:      c.serviceAccount + "-token-abcde",
+										ReadOnly:  true,
+										MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+									},
+								},
+							},
+						},
+						ServiceAccountName: c.serviceAccount,
+					},
+				}),
+			}
+
+			resp := h.Mutate(&req)
+			if c.expErr != "" {
+				require.False(t, resp.Allowed)
+				require.Contains(t, resp.Result.Message, c.expErr)
+			} else {
+				require.True(t, resp.Allowed, resp.Result)
+			}
+		})
+	}
+}
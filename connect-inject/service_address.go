@@ -0,0 +1,63 @@
+package connectinject
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotationServiceAddress is the address that the pod's services and
+	// their sidecar proxies are registered with instead of the pod's IP,
+	// e.g. a static address in front of a hostNetwork pod. The proxies still
+	// bind the pod's IP.
+	annotationServiceAddress = "consul.hashicorp.com/service-address"
+
+	// annotationProxyPort is the port of the public listener of the pod's
+	// sidecar proxy, 20000 by default, e.g. for hostNetwork pods on nodes
+	// where that port is taken. The i-th proxy of a multi-port pod uses this
+	// port plus i. The proxies' admin API still binds 19000 and up, so only
+	// one hostNetwork pod with sidecar proxies can run on each node.
+	annotationProxyPort = "consul.hashicorp.com/connect-proxy-port"
+)
+
+// validServiceAddress matches the addresses set with annotationServiceAddress.
+// It's strict since the address is written into the init container's shell
+// script.
+var validServiceAddress = regexp.MustCompile(`^[A-Za-z0-9.\-:]+$`)
+
+// serviceAddress returns the address the pod's services and proxies are
+// registered with and, if it isn't the address they listen on, the address
+// the proxies bind. hostNetwork pods are registered with the node's IP, which
+// is also their pod IP. Both are expanded by the init container's shell.
+func serviceAddress(pod *corev1.Pod) (string, string, error) {
+	raw, ok := pod.Annotations[annotationServiceAddress]
+	if !ok {
+		if pod.Spec.HostNetwork {
+			return "${HOST_IP}", "", nil
+		}
+		return "${POD_IP}", "", nil
+	}
+	if !validServiceAddress.MatchString(raw) {
+		return "", "", fmt.Errorf("%s annotation value of %q is invalid: must be an IP address or a DNS name",
+			annotationServiceAddress, raw)
+	}
+	return raw, "${POD_IP}", nil
+}
+
+// proxyPort returns the port of the public listener of the first sidecar
+// proxy of the pod, which is followed by those of the other services' proxies.
+func proxyPort(pod *corev1.Pod, services int) (int, error) {
+	raw, ok := pod.Annotations[annotationProxyPort]
+	if !ok {
+		return proxyPublicListenerPort, nil
+	}
+	port, err := strconv.Atoi(raw)
+	if err != nil || port < 1 || port+services-1 > 65535 {
+		return 0, fmt.Errorf("%s annotation value of %q is invalid: must be a port between 1 and %d",
+			annotationProxyPort, raw, 65535-services+1)
+	}
+	return port, nil
+}
@@ -0,0 +1,119 @@
+package connectinject
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHandlerContainerInit_ServiceAddress(t *testing.T) {
+	cases := map[string]struct {
+		HostNetwork bool
+		Annotations map[string]string
+		Expected    []string
+		NotExpected []string
+		ExpErr      string
+	}{
+		"pod IP by default": {
+			Expected: []string{
+				`  address = "${POD_IP}"`,
+				`  port = 20000`,
+			},
+			NotExpected: []string{"bind_address"},
+		},
+		"host IP with hostNetwork": {
+			HostNetwork: true,
+			Expected: []string{
+				`  address = "${HOST_IP}"`,
+			},
+			NotExpected: []string{`address = "${POD_IP}"`, "bind_address"},
+		},
+		"address annotation": {
+			HostNetwork: true,
+			Annotations: map[string]string{annotationServiceAddress: "web.example.com"},
+			Expected: []string{
+				`  address = "web.example.com"`,
+				`      bind_address = "${POD_IP}"`,
+				`    tcp = "${POD_IP}:20000"`,
+			},
+		},
+		"proxy port annotation": {
+			Annotations: map[string]string{annotationProxyPort: "21000"},
+			Expected: []string{
+				`  port = 21000`,
+				`    tcp = "${POD_IP}:21000"`,
+			},
+		},
+		"invalid address": {
+			Annotations: map[string]string{annotationServiceAddress: "1.2.3.4\"\nEOF"},
+			ExpErr:      "consul.hashicorp.com/service-address annotation value of \"1.2.3.4\\\"\\nEOF\" is invalid",
+		},
+		"invalid proxy port": {
+			Annotations: map[string]string{annotationProxyPort: "abc"},
+			ExpErr:      "consul.hashicorp.com/connect-proxy-port annotation value of \"abc\" is invalid: must be a port between 1 and 65535",
+		},
+		"proxy port out of range": {
+			Annotations: map[string]string{annotationProxyPort: "65536"},
+			ExpErr:      "consul.hashicorp.com/connect-proxy-port annotation value of \"65536\" is invalid",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			annotations := map[string]string{annotationService: "web"}
+			for k, v := range c.Annotations {
+				annotations[k] = v
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec: corev1.PodSpec{
+					HostNetwork: c.HostNetwork,
+					Containers:  []corev1.Container{{Name: "web"}},
+				},
+			}
+			h := Handler{}
+			container, err := h.containerInit(pod, k8sNamespace)
+			if c.ExpErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.ExpErr)
+				return
+			}
+			require.NoError(t, err)
+			actual := strings.Join(container.Command, " ")
+			for _, exp := range c.Expected {
+				require.Contains(t, actual, exp)
+			}
+			for _, notExp := range c.NotExpected {
+				require.NotContains(t, actual, notExp)
+			}
+		})
+	}
+}
+
+func TestHandlerContainerInit_ProxyPortMultiPort(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService:   "web,web-admin",
+				annotationPort:      "8080,9090",
+				annotationProxyPort: "21000",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "web"}, {Name: "web-admin"}},
+		},
+	}
+	h := Handler{}
+	container, err := h.containerInit(pod, k8sNamespace)
+	require.NoError(t, err)
+	actual := strings.Join(container.Command, " ")
+	require.Contains(t, actual, `    tcp = "${POD_IP}:21000"`)
+	require.Contains(t, actual, `    tcp = "${POD_IP}:21001"`)
+
+	// The last proxy's port must be valid too.
+	pod.Annotations[annotationProxyPort] = "65535"
+	_, err = h.containerInit(pod, k8sNamespace)
+	require.EqualError(t, err, "consul.hashicorp.com/connect-proxy-port annotation value of \"65535\" is invalid: must be a port between 1 and 65534")
+}
@@ -0,0 +1,105 @@
+package connectinject
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// MetaKeyZone and MetaKeyRegion are set to the zone and region of the
+	// node of the pod, from its topology.kubernetes.io labels, if
+	// HealthCheckResource.TopologyMeta is true.
+	MetaKeyZone   = "k8s-zone"
+	MetaKeyRegion = "k8s-region"
+)
+
+// nodeTopology returns the MetaKeyZone and MetaKeyRegion meta for the node
+// nodeName. The deprecated failure-domain.beta.kubernetes.io labels are used
+// if the node doesn't have the stable ones. Keys whose label isn't set are
+// set to an empty string so that they are removed when the node's labels
+// are.
+func (h *HealthCheckResource) nodeTopology(nodeName string) (map[string]string, error) {
+	node, err := h.KubernetesClientset.CoreV1().Nodes().Get(h.Ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting node %s: %s", nodeName, err)
+	}
+	label := func(stable, deprecated string) string {
+		if v, ok := node.Labels[stable]; ok {
+			return v
+		}
+		return node.Labels[deprecated]
+	}
+	return map[string]string{
+		MetaKeyZone:   label(corev1.LabelZoneFailureDomainStable, corev1.LabelZoneFailureDomain),
+		MetaKeyRegion: label(corev1.LabelZoneRegionStable, corev1.LabelZoneRegion),
+	}, nil
+}
+
+// reconcileTopologyMeta sets the topology meta of the service serviceName of
+// pod and of its sidecar proxy. The services are registered again with the
+// meta since the agent API can't update a service in place; their checks
+// are kept.
+func (h *HealthCheckResource) reconcileTopologyMeta(client *api.Client, pod *corev1.Pod, serviceName string, topology map[string]string) error {
+	serviceID := h.getConsulServiceID(pod, serviceName)
+	for _, id := range []string{serviceID, serviceID + "-sidecar-proxy"} {
+		svc, _, err := client.Agent().Service(id, nil)
+		if err != nil {
+			// The service is deregistered when the pod shuts down.
+			if strings.Contains(err.Error(), "404") {
+				h.Log.Debug("skipping topology meta because service not registered with Consul", "serviceID", id)
+				continue
+			}
+			return fmt.Errorf("getting service %q: %s", id, err)
+		}
+		if !topologyMetaChanged(svc.Meta, topology) {
+			continue
+		}
+
+		meta := make(map[string]string, len(svc.Meta)+len(topology))
+		for k, v := range svc.Meta {
+			meta[k] = v
+		}
+		for k, v := range topology {
+			if v == "" {
+				delete(meta, k)
+			} else {
+				meta[k] = v
+			}
+		}
+		h.Log.Debug("updating topology meta", "serviceID", id, "meta", topology)
+		err = client.Agent().ServiceRegister(&api.AgentServiceRegistration{
+			Kind:              svc.Kind,
+			ID:                svc.ID,
+			Name:              svc.Service,
+			Tags:              svc.Tags,
+			Port:              svc.Port,
+			Address:           svc.Address,
+			TaggedAddresses:   svc.TaggedAddresses,
+			EnableTagOverride: svc.EnableTagOverride,
+			Meta:              meta,
+			Weights:           &svc.Weights,
+			Proxy:             svc.Proxy,
+			Connect:           svc.Connect,
+			Namespace:         svc.Namespace,
+		})
+		if err != nil {
+			return fmt.Errorf("updating topology meta of service %q: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// topologyMetaChanged returns true if meta doesn't have the topology meta,
+// where empty values mean that the key must not be set.
+func topologyMetaChanged(meta, topology map[string]string) bool {
+	for k, v := range topology {
+		if current, ok := meta[k]; current != v || (v == "" && ok) {
+			return true
+		}
+	}
+	return false
+}
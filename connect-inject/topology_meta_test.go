@@ -0,0 +1,142 @@
+package connectinject
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test that the meta of the services of a pod and of their sidecar proxies is
+// kept in sync with the topology labels of its node.
+func TestReconcilePod_TopologyMeta(t *testing.T) {
+	t.Parallel()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testPodName,
+			Namespace: "default",
+			Labels:    map[string]string{labelInject: "true"},
+			Annotations: map[string]string{
+				annotationStatus:  injected,
+				annotationService: testServiceNameAnnotation,
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: testPodSpec.Containers,
+		},
+		Status: corev1.PodStatus{
+			HostIP:                "127.0.0.1",
+			Phase:                 corev1.PodRunning,
+			InitContainerStatuses: completedInjectInitContainer,
+			Conditions: []corev1.PodCondition{{
+				Type:   corev1.PodReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+	server, client, resource := testServerAgentResourceAndController(t, pod)
+	defer server.Stop()
+	resource.Ctx = context.Background()
+	resource.TopologyMeta = true
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Labels: map[string]string{
+				corev1.LabelZoneFailureDomainStable: "us-east-1a",
+				// The deprecated label is used if the stable one isn't set.
+				corev1.LabelZoneRegion: "us-east-1",
+			},
+		},
+	}
+	_, err := resource.KubernetesClientset.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, client.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		ID:   testServiceNameReg,
+		Name: testServiceNameAnnotation,
+		Port: 8080,
+		Meta: map[string]string{MetaKeyPodName: testPodName},
+	}))
+	require.NoError(t, client.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		Kind: api.ServiceKindConnectProxy,
+		ID:   testServiceNameReg + "-sidecar-proxy",
+		Name: testServiceNameAnnotation + "-sidecar-proxy",
+		Port: 20000,
+		Proxy: &api.AgentServiceConnectProxyConfig{
+			DestinationServiceName: testServiceNameAnnotation,
+			DestinationServiceID:   testServiceNameReg,
+			LocalServicePort:       8080,
+		},
+	}))
+
+	require.NoError(t, resource.reconcilePod(pod))
+	svc, _, err := client.Agent().Service(testServiceNameReg, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		MetaKeyPodName: testPodName,
+		MetaKeyZone:    "us-east-1a",
+		MetaKeyRegion:  "us-east-1",
+	}, svc.Meta)
+	require.Equal(t, 8080, svc.Port)
+	proxy, _, err := client.Agent().Service(testServiceNameReg+"-sidecar-proxy", nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		MetaKeyZone:   "us-east-1a",
+		MetaKeyRegion: "us-east-1",
+	}, proxy.Meta)
+	require.Equal(t, api.ServiceKindConnectProxy, proxy.Kind)
+	require.Equal(t, testServiceNameReg, proxy.Proxy.DestinationServiceID)
+
+	// The health check is kept when the service is registered again.
+	check := getConsulAgentChecks(t, client, testHealthCheckID)
+	require.NotNil(t, check)
+	require.Equal(t, api.HealthPassing, check.Status)
+
+	// The meta is removed when the node's label is.
+	delete(node.Labels, corev1.LabelZoneFailureDomainStable)
+	_, err = resource.KubernetesClientset.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, resource.reconcilePod(pod))
+	svc, _, err = client.Agent().Service(testServiceNameReg, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		MetaKeyPodName: testPodName,
+		MetaKeyRegion:  "us-east-1",
+	}, svc.Meta)
+}
+
+func TestTopologyMetaChanged(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		meta map[string]string
+		exp  bool
+	}{
+		"unchanged": {
+			meta: map[string]string{"foo": "bar", MetaKeyZone: "us-east-1a"},
+			exp:  false,
+		},
+		"zone changed": {
+			meta: map[string]string{MetaKeyZone: "us-east-1b"},
+			exp:  true,
+		},
+		"region set": {
+			meta: map[string]string{MetaKeyZone: "us-east-1a", MetaKeyRegion: "us-east-1"},
+			exp:  true,
+		},
+		"no meta": {
+			meta: nil,
+			exp:  true,
+		},
+	}
+	topology := map[string]string{MetaKeyZone: "us-east-1a", MetaKeyRegion: ""}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.exp, topologyMetaChanged(c.meta, topology))
+		})
+	}
+}
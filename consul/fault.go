@@ -0,0 +1,70 @@
+package consul
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FaultInjector is an http.RoundTripper that artificially delays or fails a
+// percentage of the requests made through it. It is used to wrap the
+// transport of a Consul API client so that retry and backoff behavior can be
+// tested without a misbehaving Consul cluster. It should never be used
+// outside of testing.
+type FaultInjector struct {
+	// Transport is the RoundTripper that requests are sent through when
+	// they aren't failed.
+	Transport http.RoundTripper
+
+	// Delay is how long to delay the requests that are delayed.
+	Delay time.Duration
+
+	// DelayPercent is the percentage of requests, from 0 to 100, to delay.
+	DelayPercent float64
+
+	// ErrorPercent is the percentage of requests, from 0 to 100, to fail.
+	// Failed requests never reach Consul.
+	ErrorPercent float64
+
+	// rand returns a number in [0, 100). It defaults to a pseudo-random
+	// number and is exposed for setting in tests.
+	rand func() float64
+
+	once sync.Once
+	mu   sync.Mutex
+}
+
+func (f *FaultInjector) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.once.Do(f.init)
+
+	if f.Delay > 0 && f.roll() < f.DelayPercent {
+		select {
+		case <-time.After(f.Delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if f.roll() < f.ErrorPercent {
+		return nil, fmt.Errorf("fault injected: %s %s failed", req.Method, req.URL.Path)
+	}
+	return f.Transport.RoundTrip(req)
+}
+
+func (f *FaultInjector) init() {
+	if f.rand == nil {
+		src := rand.New(rand.NewSource(time.Now().UnixNano()))
+		f.rand = func() float64 { return src.Float64() * 100 }
+	}
+	if f.Transport == nil {
+		f.Transport = http.DefaultTransport
+	}
+}
+
+// roll returns a number in [0, 100). It's safe to call concurrently.
+func (f *FaultInjector) roll() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand()
+}
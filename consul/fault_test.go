@@ -0,0 +1,116 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjector(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		delay        time.Duration
+		delayPercent float64
+		errorPercent float64
+		roll         float64
+		expDelay     bool
+		expErr       bool
+	}{
+		"no faults": {
+			roll: 0,
+		},
+		"delayed": {
+			delay:        100 * time.Millisecond,
+			delayPercent: 50,
+			roll:         49,
+			expDelay:     true,
+		},
+		"not delayed": {
+			delay:        100 * time.Millisecond,
+			delayPercent: 50,
+			roll:         50,
+		},
+		"failed": {
+			errorPercent: 50,
+			roll:         49,
+			expErr:       true,
+		},
+		"not failed": {
+			errorPercent: 50,
+			roll:         50,
+		},
+		"delayed and failed": {
+			delay:        100 * time.Millisecond,
+			delayPercent: 100,
+			errorPercent: 100,
+			roll:         0,
+			expDelay:     true,
+			expErr:       true,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			var received int
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received++
+				fmt.Fprintln(w, "\"leader\"")
+			}))
+			defer consulServer.Close()
+
+			client, err := NewClient(&capi.Config{
+				Address: consulServer.URL,
+				HttpClient: &http.Client{
+					Transport: &FaultInjector{
+						Transport:    http.DefaultTransport,
+						Delay:        c.delay,
+						DelayPercent: c.delayPercent,
+						ErrorPercent: c.errorPercent,
+						rand:         func() float64 { return c.roll },
+					},
+				},
+			})
+			require.NoError(t, err)
+
+			start := time.Now()
+			leader, err := client.Status().Leader()
+			elapsed := time.Since(start)
+			if c.expErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "fault injected: GET /v1/status/leader failed")
+				require.Equal(t, 0, received)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "leader", leader)
+				require.Equal(t, 1, received)
+			}
+			if c.expDelay {
+				require.True(t, elapsed >= c.delay, "expected request to be delayed by %s, took %s", c.delay, elapsed)
+			} else {
+				require.True(t, elapsed < 100*time.Millisecond, "expected request not to be delayed, took %s", elapsed)
+			}
+		})
+	}
+}
+
+// Test that a delayed request returns as soon as its context is cancelled.
+func TestFaultInjector_DelayCancelled(t *testing.T) {
+	t.Parallel()
+	f := &FaultInjector{
+		Delay:        time.Minute,
+		DelayPercent: 100,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://127.0.0.1/v1/status/leader", nil)
+	require.NoError(t, err)
+
+	_, err = f.RoundTrip(req)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,65 @@
+package consul
+
+import (
+	"net/http"
+
+	capi "github.com/hashicorp/consul/api"
+)
+
+// DefaultPartition is the name of the admin partition that Consul Enterprise
+// servers run in and that requests are scoped to if they don't set one.
+const DefaultPartition = "default"
+
+// PartitionTransport is an http.RoundTripper that scopes each request made
+// through it to an admin partition of Consul Enterprise by setting the
+// partition query parameter, so that the policies, tokens, auth methods and
+// namespaces created through it belong to the partition.
+type PartitionTransport struct {
+	// Transport is the RoundTripper that requests are sent through.
+	Transport http.RoundTripper
+
+	// Partition is the name of the admin partition.
+	Partition string
+}
+
+// SetPartition sets an HTTP client on config that scopes each request to
+// partition using a PartitionTransport. If config already has an HTTP
+// client, its transport is wrapped instead. It must be called after any TLS
+// settings have been set on config and does nothing if partition is empty or
+// the default partition.
+func SetPartition(config *capi.Config, partition string) error {
+	if partition == "" || partition == DefaultPartition {
+		return nil
+	}
+	if config.HttpClient == nil {
+		transport := config.Transport
+		if transport == nil {
+			transport = capi.DefaultConfig().Transport
+		}
+		httpClient, err := capi.NewHttpClient(transport, config.TLSConfig)
+		if err != nil {
+			return err
+		}
+		config.HttpClient = httpClient
+	}
+	config.HttpClient.Transport = &PartitionTransport{
+		Transport: config.HttpClient.Transport,
+		Partition: partition,
+	}
+	return nil
+}
+
+func (t *PartitionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	// RoundTrip must not modify the request so the partition is set on a
+	// copy of its URL.
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	query.Set("partition", t.Partition)
+	req.URL.RawQuery = query.Encode()
+	return transport.RoundTrip(req)
+}
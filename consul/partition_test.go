@@ -0,0 +1,79 @@
+package consul
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionTransport(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		partition    string
+		expPartition string
+	}{
+		"no partition": {},
+		"default partition": {
+			partition: DefaultPartition,
+		},
+		"partition": {
+			partition:    "foo",
+			expPartition: "foo",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var partition, dc string
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				partition = r.URL.Query().Get("partition")
+				dc = r.URL.Query().Get("dc")
+				fmt.Fprintln(w, `{}`)
+			}))
+			defer consulServer.Close()
+
+			cfg := &capi.Config{Address: consulServer.URL}
+			require.NoError(t, SetPartition(cfg, c.partition))
+			if c.expPartition == "" {
+				require.Nil(t, cfg.HttpClient)
+			}
+			client, err := NewClient(cfg)
+			require.NoError(t, err)
+
+			// The other query parameters of the request are kept.
+			_, _, err = client.Catalog().Services(&capi.QueryOptions{Datacenter: "dc2"})
+			require.NoError(t, err)
+			require.Equal(t, c.expPartition, partition)
+			require.Equal(t, "dc2", dc)
+		})
+	}
+}
+
+// Test that the partition is set on the requests of a client whose requests
+// are also bounded by a timeout.
+func TestSetPartition_WithTimeout(t *testing.T) {
+	t.Parallel()
+	var partition string
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partition = r.URL.Query().Get("partition")
+		fmt.Fprintln(w, `{}`)
+	}))
+	defer consulServer.Close()
+
+	cfg := &capi.Config{Address: consulServer.URL}
+	require.NoError(t, SetPartition(cfg, "foo"))
+	require.NoError(t, SetTimeout(cfg, time.Second))
+	require.IsType(t, &TimeoutTransport{}, cfg.HttpClient.Transport)
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+
+	_, _, err = client.Catalog().Services(nil)
+	require.NoError(t, err)
+	require.Equal(t, "foo", partition)
+}
@@ -0,0 +1,216 @@
+package consul
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+)
+
+// TLSReloadTransport is an http.RoundTripper that reloads the CA and client
+// certificates when their files change, so that long running commands keep
+// working when the certificates are rotated. The files are checked before
+// each request.
+type TLSReloadTransport struct {
+	base      *http.Transport
+	tlsConfig capi.TLSConfig
+
+	lock      sync.Mutex
+	transport *http.Transport
+	modTimes  []time.Time
+}
+
+// SetTLSReload sets an HTTP client on config that reloads its TLS files when
+// they change using a TLSReloadTransport. It must be called after the TLS
+// settings have been set on config and before any other HTTP client is set.
+// It does nothing if no TLS files are configured.
+func SetTLSReload(config *capi.Config) error {
+	t := &TLSReloadTransport{
+		base:      config.Transport,
+		tlsConfig: config.TLSConfig,
+	}
+	if len(t.files()) == 0 {
+		return nil
+	}
+	if t.base == nil {
+		t.base = capi.DefaultConfig().Transport
+	}
+	if err := t.reload(); err != nil {
+		return err
+	}
+	config.HttpClient = &http.Client{Transport: t}
+	return nil
+}
+
+func (t *TLSReloadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	if !timesEqual(t.modTimes, t.fileModTimes()) {
+		// The files may be in the middle of being written, in which case
+		// the current certificates are kept until the next change.
+		t.reload()
+	}
+	transport := t.transport
+	t.lock.Unlock()
+	return transport.RoundTrip(req)
+}
+
+// reload replaces the transport with one that uses the current TLS files.
+// The transport is kept if they can't be loaded.
+func (t *TLSReloadTransport) reload() error {
+	t.modTimes = t.fileModTimes()
+	tlsClientConfig, err := capi.SetupTLSConfig(&t.tlsConfig)
+	if err != nil {
+		return err
+	}
+	transport := t.base.Clone()
+	transport.TLSClientConfig = tlsClientConfig
+	if t.transport != nil {
+		t.transport.CloseIdleConnections()
+	}
+	t.transport = transport
+	return nil
+}
+
+func (t *TLSReloadTransport) files() []string {
+	var files []string
+	for _, f := range []string{t.tlsConfig.CAFile, t.tlsConfig.CAPath, t.tlsConfig.CertFile, t.tlsConfig.KeyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// fileModTimes returns the modification times of the TLS files, which are
+// zero for the files that don't exist.
+func (t *TLSReloadTransport) fileModTimes() []time.Time {
+	var modTimes []time.Time
+	for _, f := range t.files() {
+		modTimes = append(modTimes, modTime(f))
+	}
+	return modTimes
+}
+
+// TokenFileTransport is an http.RoundTripper that sets the ACL token of the
+// requests to the contents of a file, re-reading it when it changes, so that
+// long running commands pick up a rotated token. Requests made with another
+// token than the client's, for example one set in the query options, are
+// sent unchanged.
+type TokenFileTransport struct {
+	// Transport is the RoundTripper that requests are sent through.
+	Transport http.RoundTripper
+
+	tokenFile     string
+	clientToken   string
+	fallbackToken string
+
+	lock    sync.Mutex
+	token   string
+	modTime time.Time
+}
+
+// SetTokenFile sets an HTTP client on config that uses the token in the
+// file config.TokenFile using a TokenFileTransport. As with the Consul API
+// client, the token of config is used if the file is empty. If config
+// already has an HTTP client its transport is wrapped instead. It does
+// nothing if config has no token file.
+func SetTokenFile(config *capi.Config) error {
+	if config.TokenFile == "" {
+		return nil
+	}
+	t := &TokenFileTransport{
+		tokenFile:     config.TokenFile,
+		fallbackToken: config.Token,
+	}
+	if err := t.readToken(); err != nil {
+		return err
+	}
+	t.clientToken = t.token
+
+	if config.HttpClient == nil {
+		transport := config.Transport
+		if transport == nil {
+			transport = capi.DefaultConfig().Transport
+		}
+		httpClient, err := capi.NewHttpClient(transport, config.TLSConfig)
+		if err != nil {
+			return err
+		}
+		config.HttpClient = httpClient
+	}
+	t.Transport = config.HttpClient.Transport
+	config.HttpClient.Transport = t
+
+	// The client would otherwise only read the file once.
+	config.Token = t.clientToken
+	config.TokenFile = ""
+	return nil
+}
+
+func (t *TokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if req.Header.Get("X-Consul-Token") != t.clientToken {
+		return transport.RoundTrip(req)
+	}
+
+	t.lock.Lock()
+	if !modTime(t.tokenFile).Equal(t.modTime) {
+		// The file may be in the middle of being written, in which case
+		// the current token is kept until the next change.
+		t.readToken()
+	}
+	token := t.token
+	t.lock.Unlock()
+
+	if token != t.clientToken {
+		req = req.Clone(req.Context())
+		if token == "" {
+			req.Header.Del("X-Consul-Token")
+		} else {
+			req.Header.Set("X-Consul-Token", token)
+		}
+	}
+	return transport.RoundTrip(req)
+}
+
+func (t *TokenFileTransport) readToken() error {
+	t.modTime = modTime(t.tokenFile)
+	data, err := ioutil.ReadFile(t.tokenFile)
+	if err != nil {
+		return fmt.Errorf("reading token file: %s", err)
+	}
+	t.token = strings.TrimSpace(string(data))
+	if t.token == "" {
+		t.token = t.fallbackToken
+	}
+	return nil
+}
+
+// modTime returns the modification time of path or zero if it doesn't exist.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func timesEqual(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package consul
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/cert"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the CA file is loaded again when it changes.
+func TestTLSReloadTransport(t *testing.T) {
+	t.Parallel()
+	serverBundle := testBundle(t)
+	otherBundle := testBundle(t)
+
+	consulServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `"leader"`)
+	}))
+	keyPair, err := tls.X509KeyPair(serverBundle.Cert, serverBundle.Key)
+	require.NoError(t, err)
+	consulServer.TLS = &tls.Config{Certificates: []tls.Certificate{keyPair}}
+	consulServer.StartTLS()
+	defer consulServer.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, otherBundle.CACert, 0600))
+
+	cfg := &capi.Config{Address: consulServer.URL, TLSConfig: capi.TLSConfig{CAFile: caFile}}
+	require.NoError(t, SetTLSReload(cfg))
+	require.IsType(t, &TLSReloadTransport{}, cfg.HttpClient.Transport)
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+
+	_, err = client.Status().Leader()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "certificate signed by unknown authority")
+
+	writeFile(t, caFile, serverBundle.CACert)
+	leader, err := client.Status().Leader()
+	require.NoError(t, err)
+	require.Equal(t, "leader", leader)
+
+	// An invalid CA file keeps the current one.
+	writeFile(t, caFile, []byte("invalid"))
+	_, err = client.Status().Leader()
+	require.NoError(t, err)
+}
+
+func TestSetTLSReload_Errors(t *testing.T) {
+	t.Parallel()
+	cfg := capi.DefaultConfig()
+	cfg.TLSConfig = capi.TLSConfig{}
+	require.NoError(t, SetTLSReload(cfg))
+	require.Nil(t, cfg.HttpClient)
+
+	cfg.TLSConfig.CAFile = filepath.Join(t.TempDir(), "missing.pem")
+	require.Error(t, SetTLSReload(cfg))
+}
+
+// Test that the token file is read again when it changes and that it's only
+// used for the requests made with the client's token.
+func TestTokenFileTransport(t *testing.T) {
+	t.Parallel()
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Key": %q}`, r.Header.Get("X-Consul-Token"))
+	}))
+	defer consulServer.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("token-1\n"), 0600))
+
+	cfg := &capi.Config{Address: consulServer.URL, Token: "fallback", TokenFile: tokenFile}
+	require.NoError(t, SetTokenFile(cfg))
+	require.IsType(t, &TokenFileTransport{}, cfg.HttpClient.Transport)
+	require.Equal(t, "token-1", cfg.Token)
+	require.Empty(t, cfg.TokenFile)
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+
+	requireToken := func(exp string, q *capi.QueryOptions) {
+		t.Helper()
+		var out struct{ Key string }
+		_, err := client.Raw().Query("/v1/token", &out, q)
+		require.NoError(t, err)
+		require.Equal(t, exp, out.Key)
+	}
+	requireToken("token-1", nil)
+
+	writeFile(t, tokenFile, []byte("token-2"))
+	requireToken("token-2", nil)
+	requireToken("other", &capi.QueryOptions{Token: "other"})
+
+	// The client's token is used if the file is empty.
+	writeFile(t, tokenFile, nil)
+	requireToken("fallback", nil)
+}
+
+func TestSetTokenFile_Errors(t *testing.T) {
+	t.Parallel()
+	cfg := capi.DefaultConfig()
+	cfg.TokenFile = ""
+	require.NoError(t, SetTokenFile(cfg))
+	require.Nil(t, cfg.HttpClient)
+
+	cfg.TokenFile = filepath.Join(t.TempDir(), "missing")
+	require.Error(t, SetTokenFile(cfg))
+}
+
+func testBundle(t *testing.T) cert.Bundle {
+	source := &cert.GenSource{Name: "Consul", Hosts: []string{"127.0.0.1"}}
+	bundle, err := source.Certificate(context.Background(), nil)
+	require.NoError(t, err)
+	return bundle
+}
+
+// writeFile writes data to path and moves its modification time forward, since
+// it may otherwise not change on file systems with a low time resolution.
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	modTime := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
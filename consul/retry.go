@@ -0,0 +1,125 @@
+package consul
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+)
+
+// maxRetryWait bounds how long RetryTransport waits between two attempts.
+const maxRetryWait = 10 * time.Second
+
+// RetryTransport is an http.RoundTripper that retries the requests that fail
+// because Consul can't be reached or has no leader, so that commands ride out
+// a Consul server or client restart instead of failing.
+//
+// Reads are retried when they fail or Consul responds with a 5xx status.
+// Writes aren't idempotent so they're only retried when the connection to
+// Consul couldn't be made, in which case they were never sent.
+type RetryTransport struct {
+	// Transport is the RoundTripper that requests are sent through.
+	Transport http.RoundTripper
+
+	// Retries is how many times a request is retried.
+	Retries int
+
+	// Wait is how long to wait before the first retry. It doubles with each
+	// retry, up to maxRetryWait.
+	Wait time.Duration
+}
+
+// SetRetries sets an HTTP client on config that retries each request up to
+// retries times using a RetryTransport. If config already has an HTTP client
+// its transport is wrapped instead, so that each attempt is bounded by a
+// TimeoutTransport. It must be called after any TLS settings have been set
+// on config and does nothing if retries is zero.
+func SetRetries(config *capi.Config, retries int, wait time.Duration) error {
+	if retries <= 0 {
+		return nil
+	}
+	if config.HttpClient == nil {
+		transport := config.Transport
+		if transport == nil {
+			transport = capi.DefaultConfig().Transport
+		}
+		httpClient, err := capi.NewHttpClient(transport, config.TLSConfig)
+		if err != nil {
+			return err
+		}
+		config.HttpClient = httpClient
+	}
+	config.HttpClient.Transport = &RetryTransport{
+		Transport: config.HttpClient.Transport,
+		Retries:   retries,
+		Wait:      wait,
+	}
+	return nil
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	wait := t.Wait
+	for attempt := 0; ; attempt++ {
+		resp, err := transport.RoundTrip(req)
+		if attempt >= t.Retries || !retryable(req, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain the body so that the connection can be reused.
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			// The body was consumed by the attempt.
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		wait *= 2
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// retryable returns true if req can be sent again after it got resp or err.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	// Requests whose body can't be read again, like snapshot restores,
+	// can't be retried.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+		return isRead(req)
+	}
+	return resp.StatusCode >= 500 && isRead(req)
+}
+
+func isRead(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
@@ -0,0 +1,122 @@
+package consul
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryTransport(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		status      int
+		write       bool
+		expRequests int32
+		expError    bool
+	}{
+		"success": {
+			status:      http.StatusOK,
+			expRequests: 1,
+		},
+		"read server error is retried": {
+			status:      http.StatusInternalServerError,
+			expRequests: 3,
+			expError:    true,
+		},
+		"write server error isn't retried": {
+			status:      http.StatusInternalServerError,
+			write:       true,
+			expRequests: 1,
+			expError:    true,
+		},
+		"client error isn't retried": {
+			status:      http.StatusForbidden,
+			expRequests: 1,
+			expError:    true,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var requests int32
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.WriteHeader(c.status)
+				fmt.Fprintln(w, `{}`)
+			}))
+			defer consulServer.Close()
+
+			cfg := &capi.Config{Address: consulServer.URL}
+			require.NoError(t, SetRetries(cfg, 2, time.Millisecond))
+			require.IsType(t, &RetryTransport{}, cfg.HttpClient.Transport)
+			client, err := NewClient(cfg)
+			require.NoError(t, err)
+
+			if c.write {
+				_, err = client.KV().Put(&capi.KVPair{Key: "foo", Value: []byte("bar")}, nil)
+			} else {
+				_, _, err = client.Catalog().Services(nil)
+			}
+			if c.expError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, c.expRequests, atomic.LoadInt32(&requests))
+		})
+	}
+}
+
+// Test that writes are retried with their body when Consul can't be reached.
+func TestRetryTransport_WriteNotSent(t *testing.T) {
+	t.Parallel()
+	// Reserve an address that nothing listens on until the retry.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	bodies := make(chan string, 1)
+	consulServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		fmt.Fprintln(w, `true`)
+	}))
+	consulServer.Listener.Close()
+	defer consulServer.Close()
+
+	cfg := &capi.Config{Address: addr}
+	require.NoError(t, SetRetries(cfg, 1, 500*time.Millisecond))
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("listening on %s: %s", addr, err)
+			return
+		}
+		consulServer.Listener = listener
+		consulServer.Start()
+	}()
+	_, err = client.KV().Put(&capi.KVPair{Key: "foo", Value: []byte("bar")}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "bar", <-bodies)
+}
+
+func TestSetRetries_Disabled(t *testing.T) {
+	t.Parallel()
+	cfg := capi.DefaultConfig()
+	require.NoError(t, SetRetries(cfg, 0, time.Second))
+	require.Nil(t, cfg.HttpClient)
+}
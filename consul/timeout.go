@@ -0,0 +1,105 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+)
+
+// defaultBlockingWait is how long Consul holds a blocking query open when the
+// query doesn't set a wait time.
+const defaultBlockingWait = 5 * time.Minute
+
+// TimeoutTransport is an http.RoundTripper that bounds how long each request
+// made through it can take, including reading the response body, so that a
+// hung Consul server causes an error instead of a request that never
+// returns.
+//
+// Blocking queries are expected to be held open by Consul for up to their
+// wait time so the timeout is added on top of it.
+type TimeoutTransport struct {
+	// Transport is the RoundTripper that requests are sent through.
+	Transport http.RoundTripper
+
+	// Timeout is how long a request can take, on top of the wait time of
+	// blocking queries.
+	Timeout time.Duration
+}
+
+// SetTimeout sets an HTTP client on config that bounds each request to
+// timeout using a TimeoutTransport. If config already has an HTTP client, for
+// example one that injects faults, its transport is wrapped instead. It must
+// be called after any TLS settings have been set on config and does nothing
+// if timeout is zero.
+func SetTimeout(config *capi.Config, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	if config.HttpClient == nil {
+		transport := config.Transport
+		if transport == nil {
+			transport = capi.DefaultConfig().Transport
+		}
+		httpClient, err := capi.NewHttpClient(transport, config.TLSConfig)
+		if err != nil {
+			return err
+		}
+		config.HttpClient = httpClient
+	}
+	config.HttpClient.Transport = &TimeoutTransport{
+		Transport: config.HttpClient.Transport,
+		Timeout:   timeout,
+	}
+	return nil
+}
+
+func (t *TimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	timeout := t.Timeout + blockingWait(req)
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s %s timed out after %s", req.Method, req.URL.Path, timeout)
+		}
+		return nil, err
+	}
+	// The context has to outlive RoundTrip since it also bounds reading the
+	// body. It's cancelled once the body is closed.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// blockingWait returns how long Consul may hold req open, which is zero
+// unless it's a blocking query. Consul adds up to wait/16 of jitter to the
+// wait time.
+func blockingWait(req *http.Request) time.Duration {
+	query := req.URL.Query()
+	if query.Get("index") == "" {
+		return 0
+	}
+	wait := defaultBlockingWait
+	if w, err := time.ParseDuration(query.Get("wait")); err == nil && w > 0 {
+		wait = w
+	}
+	return wait + wait/16
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,121 @@
+package consul
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutTransport(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		delay    time.Duration
+		query    *capi.QueryOptions
+		expError bool
+	}{
+		"fast": {},
+		"hung": {
+			delay:    time.Second,
+			expError: true,
+		},
+		"blocking query within its wait time": {
+			delay: 300 * time.Millisecond,
+			query: &capi.QueryOptions{WaitIndex: 1, WaitTime: 500 * time.Millisecond},
+		},
+		"blocking query past its wait time": {
+			delay:    time.Second,
+			query:    &capi.QueryOptions{WaitIndex: 1, WaitTime: 200 * time.Millisecond},
+			expError: true,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(c.delay):
+				case <-r.Context().Done():
+					return
+				}
+				fmt.Fprintln(w, `{"dc1": []}`)
+			}))
+			defer consulServer.Close()
+
+			cfg := &capi.Config{Address: consulServer.URL}
+			require.NoError(t, SetTimeout(cfg, 100*time.Millisecond))
+			require.IsType(t, &TimeoutTransport{}, cfg.HttpClient.Transport)
+			client, err := NewClient(cfg)
+			require.NoError(t, err)
+
+			_, _, err = client.Catalog().Services(c.query)
+			if c.expError {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "GET /v1/catalog/services timed out after")
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// Test that an existing HTTP client, such as one injecting faults, is wrapped
+// so that its delays count towards the timeout.
+func TestSetTimeout_WrapsHTTPClient(t *testing.T) {
+	t.Parallel()
+	fault := &FaultInjector{Transport: http.DefaultTransport, Delay: time.Second, DelayPercent: 100}
+	cfg := &capi.Config{
+		Address:    "127.0.0.1:8500",
+		HttpClient: &http.Client{Transport: fault},
+	}
+	require.NoError(t, SetTimeout(cfg, 100*time.Millisecond))
+	require.Equal(t, &TimeoutTransport{Transport: fault, Timeout: 100 * time.Millisecond}, cfg.HttpClient.Transport)
+
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = client.Status().Leader()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "GET /v1/status/leader timed out after 100ms")
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestSetTimeout_Disabled(t *testing.T) {
+	t.Parallel()
+	cfg := capi.DefaultConfig()
+	require.NoError(t, SetTimeout(cfg, 0))
+	require.Nil(t, cfg.HttpClient)
+}
+
+func TestBlockingWait(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		query   string
+		expWait time.Duration
+	}{
+		"not blocking": {
+			query:   "wait=1m",
+			expWait: 0,
+		},
+		"default wait": {
+			query:   "index=10",
+			expWait: 5*time.Minute + 5*time.Minute/16,
+		},
+		"wait in milliseconds": {
+			query:   "index=10&wait=60000ms",
+			expWait: time.Minute + time.Minute/16,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://127.0.0.1:8500/v1/catalog/services?"+c.query, nil)
+			require.NoError(t, err)
+			require.Equal(t, c.expWait, blockingWait(req))
+		})
+	}
+}
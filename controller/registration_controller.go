@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/consul-k8s/api/common"
+	consulv1alpha1 "github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/namespaces"
+	capi "github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const InvalidRegistrationError = "InvalidRegistrationError"
+
+// RegistrationController is the controller for Registration resources. Unlike
+// the other controllers it doesn't manage a config entry: it registers the
+// service instance described by the resource in the Consul catalog and
+// deregisters it when the resource is deleted.
+type RegistrationController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// ConfigEntryController holds the Consul client and the Consul
+	// namespace settings, which are shared with the config entry controllers.
+	ConfigEntryController *ConfigEntryController
+}
+
+// +kubebuilder:rbac:groups=consul.hashicorp.com,resources=registrations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=consul.hashicorp.com,resources=registrations/status,verbs=get;update;patch
+
+func (r *RegistrationController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	logger := r.Logger(req.NamespacedName)
+
+	var registration consulv1alpha1.Registration
+	err := r.Get(ctx, req.NamespacedName, &registration)
+	if k8serr.IsNotFound(err) {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	} else if err != nil {
+		logger.Error(err, "retrieving resource")
+		return ctrl.Result{}, err
+	}
+
+	cfg := r.ConfigEntryController
+	consulNS := namespaces.ConsulNamespace(registration.Namespace, cfg.EnableConsulNamespaces,
+		cfg.ConsulDestinationNamespace, cfg.EnableNSMirroring, cfg.NSMirroringPrefix)
+
+	if !registration.DeletionTimestamp.IsZero() {
+		if containsString(registration.Finalizers(), FinalizerName) {
+			logger.Info("deletion event")
+			if err := r.deregister(logger, &registration, registration.Status.Node, registration.Status.ServiceID, consulNS); err != nil {
+				return r.syncFailed(ctx, logger, &registration, ConsulAgentError,
+					fmt.Errorf("deregistering service from consul: %w", err))
+			}
+			registration.RemoveFinalizer(FinalizerName)
+			if err := r.Update(ctx, &registration); err != nil {
+				return ctrl.Result{}, err
+			}
+			logger.Info("finalizer removed")
+		}
+
+		// Stop reconciliation as the item is being deleted
+		return ctrl.Result{}, nil
+	}
+
+	if !containsString(registration.Finalizers(), FinalizerName) {
+		registration.AddFinalizer(FinalizerName)
+		registration.SetSyncedCondition(corev1.ConditionUnknown, "", "")
+		if err := r.Update(ctx, &registration); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := registration.Validate(); err != nil {
+		// Retrying won't help until the resource is changed so the error is
+		// only reported in the status.
+		registration.SetSyncedCondition(corev1.ConditionFalse, InvalidRegistrationError, err.Error())
+		return ctrl.Result{}, r.UpdateStatus(ctx, &registration)
+	}
+
+	// If the node or ID of the service changed since it was last registered,
+	// the old instance has to be removed since registering doesn't replace it.
+	if registration.Status.ServiceID != "" &&
+		(registration.Status.Node != registration.Spec.Node || registration.Status.ServiceID != registration.ServiceID()) {
+		if err := r.deregister(logger, &registration, registration.Status.Node, registration.Status.ServiceID, consulNS); err != nil {
+			return r.syncFailed(ctx, logger, &registration, ConsulAgentError,
+				fmt.Errorf("deregistering previous service instance from consul: %w", err))
+		}
+	}
+
+	existing, err := r.catalogService(registration.Spec.Node, registration.ServiceID(), consulNS)
+	if err != nil {
+		return r.syncFailed(ctx, logger, &registration, ConsulAgentError,
+			fmt.Errorf("getting service instance from consul: %w", err))
+	}
+	if existing != nil && existing.Meta[consulv1alpha1.RegistrationKey] != registration.OwnerValue() {
+		return r.syncFailed(ctx, logger, &registration, ExternallyManagedConfigError,
+			fmt.Errorf("service instance %q already exists on node %q in Consul", registration.ServiceID(), registration.Spec.Node))
+	}
+
+	// Registering resets the status of the checks, which may have been
+	// updated by an external monitor since, so only register if the
+	// resource changed or the instance is missing from Consul.
+	if existing != nil &&
+		registration.Status.ObservedGeneration == registration.Generation &&
+		registration.SyncedConditionStatus() == corev1.ConditionTrue {
+		return ctrl.Result{}, nil
+	}
+
+	if cfg.EnableConsulNamespaces {
+		created, err := namespaces.EnsureExists(cfg.ConsulClient, consulNS, cfg.CrossNSACLPolicy)
+		if err != nil {
+			return r.syncFailed(ctx, logger, &registration, ConsulAgentError,
+				fmt.Errorf("creating consul namespace %q: %w", consulNS, err))
+		}
+		if created {
+			logger.Info("consul namespace created", "ns", consulNS)
+		}
+	}
+
+	writeMeta, err := cfg.ConsulClient.Catalog().Register(registration.ToConsul(consulNS), nil)
+	if err != nil {
+		return r.syncFailed(ctx, logger, &registration, ConsulAgentError,
+			fmt.Errorf("registering service in consul: %w", err))
+	}
+	logger.Info("service registered", "node", registration.Spec.Node, "service-id", registration.ServiceID(), "request-time", writeMeta.RequestTime)
+
+	registration.Status.Node = registration.Spec.Node
+	registration.Status.ServiceID = registration.ServiceID()
+	registration.Status.ObservedGeneration = registration.Generation
+	registration.SetSyncedCondition(corev1.ConditionTrue, "", "")
+	return ctrl.Result{}, r.UpdateStatus(ctx, &registration)
+}
+
+// catalogService returns the service instance with the given ID on node, or
+// nil if it isn't registered.
+func (r *RegistrationController) catalogService(node, serviceID, consulNS string) (*capi.AgentService, error) {
+	catalogNode, _, err := r.ConfigEntryController.ConsulClient.Catalog().Node(node, &capi.QueryOptions{Namespace: consulNS})
+	if err != nil {
+		return nil, err
+	}
+	if catalogNode == nil {
+		return nil, nil
+	}
+	return catalogNode.Services[serviceID], nil
+}
+
+// deregister removes the service instance from node if it is owned by
+// registration. The node itself is removed once it has no services left if
+// it was created by a Registration.
+func (r *RegistrationController) deregister(logger logr.Logger, registration *consulv1alpha1.Registration, node, serviceID, consulNS string) error {
+	if serviceID == "" {
+		// The service was never registered.
+		return nil
+	}
+	existing, err := r.catalogService(node, serviceID, consulNS)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+	if existing.Meta[consulv1alpha1.RegistrationKey] != registration.OwnerValue() {
+		logger.Info("service instance in Consul is not managed by this resource - skipping deregistration", "node", node, "service-id", serviceID)
+		return nil
+	}
+
+	consulClient := r.ConfigEntryController.ConsulClient
+	_, err = consulClient.Catalog().Deregister(&capi.CatalogDeregistration{
+		Node:      node,
+		ServiceID: serviceID,
+		Namespace: consulNS,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	logger.Info("service deregistered", "node", node, "service-id", serviceID)
+
+	// Look for services on the node in all Consul namespaces.
+	var allNamespaces string
+	if r.ConfigEntryController.EnableConsulNamespaces {
+		allNamespaces = common.WildcardNamespace
+	}
+	catalogNode, _, err := consulClient.Catalog().Node(node, &capi.QueryOptions{Namespace: allNamespaces})
+	if err != nil {
+		return err
+	}
+	if catalogNode == nil || len(catalogNode.Services) > 0 || catalogNode.Node.Meta[common.SourceKey] != common.SourceValue {
+		return nil
+	}
+	if _, err := consulClient.Catalog().Deregister(&capi.CatalogDeregistration{Node: node}, nil); err != nil {
+		return err
+	}
+	logger.Info("node deregistered", "node", node)
+	return nil
+}
+
+func (r *RegistrationController) syncFailed(ctx context.Context, logger logr.Logger, registration *consulv1alpha1.Registration, errType string, err error) (ctrl.Result, error) {
+	registration.SetSyncedCondition(corev1.ConditionFalse, errType, err.Error())
+	if updateErr := r.UpdateStatus(ctx, registration); updateErr != nil {
+		// Log the original error here because we are returning the updateErr.
+		// Otherwise the original error would be lost.
+		logger.Error(err, "sync failed")
+		return ctrl.Result{}, updateErr
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *RegistrationController) Logger(name types.NamespacedName) logr.Logger {
+	return r.Log.WithValues("request", name)
+}
+
+func (r *RegistrationController) UpdateStatus(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return r.Status().Update(ctx, obj, opts...)
+}
+
+func (r *RegistrationController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&consulv1alpha1.Registration{}).
+		Complete(r)
+}
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	logrtest "github.com/go-logr/logr/testing"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Test the lifecycle of a Registration: it is registered on creation,
+// re-registered on update and deregistered, along with its node, on deletion.
+func TestRegistrationController_lifecycle(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	ctx := context.Background()
+
+	registration := &v1alpha1.Registration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "db",
+			Namespace:  "default",
+			Generation: 1,
+		},
+		Spec: v1alpha1.RegistrationSpec{
+			Node:    "external-db",
+			Address: "10.0.0.20",
+			Service: v1alpha1.RegistrationService{
+				Name: "db",
+				Port: 5432,
+				Tags: []string{"primary"},
+			},
+			Checks: []v1alpha1.RegistrationCheck{
+				{
+					Name:   "db-alive",
+					Status: capi.HealthPassing,
+				},
+			},
+		},
+	}
+	k8sClient, consulClient, r := setupRegistrationController(t, registration)
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "db"}
+	reconcile := func() {
+		resp, err := r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+		req.NoError(err)
+		req.False(resp.Requeue)
+		req.NoError(k8sClient.Get(ctx, namespacedName, registration))
+	}
+
+	// Create.
+	reconcile()
+	req.Contains(registration.Finalizers(), FinalizerName)
+	req.Equal(corev1.ConditionTrue, registration.SyncedConditionStatus())
+	req.Equal("external-db", registration.Status.Node)
+	req.Equal("db", registration.Status.ServiceID)
+
+	services, _, err := consulClient.Catalog().Service("db", "", nil)
+	req.NoError(err)
+	req.Len(services, 1)
+	req.Equal("external-db", services[0].Node)
+	req.Equal("10.0.0.20", services[0].Address)
+	req.Equal(5432, services[0].ServicePort)
+	req.Equal([]string{"primary"}, services[0].ServiceTags)
+	req.Equal("default/db", services[0].ServiceMeta[v1alpha1.RegistrationKey])
+	checks, _, err := consulClient.Health().Checks("db", nil)
+	req.NoError(err)
+	req.Len(checks, 1)
+	req.Equal(capi.HealthPassing, checks[0].Status)
+
+	// Update the port and the service ID. The old instance must be removed.
+	registration.Spec.Service.Port = 5433
+	registration.Spec.Service.ID = "db-1"
+	registration.Generation = 2
+	req.NoError(k8sClient.Update(ctx, registration))
+	reconcile()
+	req.Equal("db-1", registration.Status.ServiceID)
+	req.Equal(int64(2), registration.Status.ObservedGeneration)
+
+	services, _, err = consulClient.Catalog().Service("db", "", nil)
+	req.NoError(err)
+	req.Len(services, 1)
+	req.Equal("db-1", services[0].ServiceID)
+	req.Equal(5433, services[0].ServicePort)
+
+	// Delete. The fake client ignores deletion timestamps on update so it is
+	// replaced with one holding the deleted resource.
+	registration.DeletionTimestamp = &metav1.Time{Time: metav1.Now().Time}
+	k8sClient = fake.NewFakeClientWithScheme(r.Scheme, registration)
+	r.Client = k8sClient
+	resp, err := r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	req.False(resp.Requeue)
+	var deleted v1alpha1.Registration
+	req.NoError(k8sClient.Get(ctx, namespacedName, &deleted))
+	req.NotContains(deleted.Finalizers(), FinalizerName)
+
+	services, _, err = consulClient.Catalog().Service("db", "", nil)
+	req.NoError(err)
+	req.Empty(services)
+	node, _, err := consulClient.Catalog().Node("external-db", nil)
+	req.NoError(err)
+	req.Nil(node)
+}
+
+// Test that a service instance that wasn't registered by the resource is
+// neither overwritten nor deregistered.
+func TestRegistrationController_doesNotModifyUnownedService(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	ctx := context.Background()
+
+	registration := &v1alpha1.Registration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.RegistrationSpec{
+			Node:    "external-db",
+			Address: "10.0.0.20",
+			Service: v1alpha1.RegistrationService{Name: "db", Port: 5432},
+		},
+	}
+	k8sClient, consulClient, r := setupRegistrationController(t, registration)
+	_, err := consulClient.Catalog().Register(&capi.CatalogRegistration{
+		Node:    "external-db",
+		Address: "10.0.0.20",
+		Service: &capi.AgentService{ID: "db", Service: "db", Port: 1234},
+	}, nil)
+	req.NoError(err)
+
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "db"}
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+	req.EqualError(err, `service instance "db" already exists on node "external-db" in Consul`)
+	req.NoError(k8sClient.Get(ctx, namespacedName, registration))
+	cond := registration.Status.GetCondition(v1alpha1.ConditionSynced)
+	req.Equal(corev1.ConditionFalse, cond.Status)
+	req.Equal(ExternallyManagedConfigError, cond.Reason)
+
+	services, _, err := consulClient.Catalog().Service("db", "", nil)
+	req.NoError(err)
+	req.Len(services, 1)
+	req.Equal(1234, services[0].ServicePort)
+
+	// Deleting the resource leaves the service alone.
+	registration.Status.Node = "external-db"
+	registration.Status.ServiceID = "db"
+	registration.DeletionTimestamp = &metav1.Time{Time: metav1.Now().Time}
+	r.Client = fake.NewFakeClientWithScheme(r.Scheme, registration)
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	services, _, err = consulClient.Catalog().Service("db", "", nil)
+	req.NoError(err)
+	req.Len(services, 1)
+}
+
+// Test that an invalid resource is reported in its status without being
+// registered.
+func TestRegistrationController_invalid(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	ctx := context.Background()
+
+	registration := &v1alpha1.Registration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.RegistrationSpec{
+			Address: "10.0.0.20",
+			Service: v1alpha1.RegistrationService{Name: "db"},
+		},
+	}
+	k8sClient, consulClient, r := setupRegistrationController(t, registration)
+
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "db"}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	req.NoError(k8sClient.Get(ctx, namespacedName, registration))
+	cond := registration.Status.GetCondition(v1alpha1.ConditionSynced)
+	req.Equal(corev1.ConditionFalse, cond.Status)
+	req.Equal(InvalidRegistrationError, cond.Reason)
+	req.Contains(cond.Message, "spec.node: Required value")
+
+	services, _, err := consulClient.Catalog().Service("db", "", nil)
+	req.NoError(err)
+	req.Empty(services)
+}
+
+func setupRegistrationController(t *testing.T, registration *v1alpha1.Registration) (client.Client, *capi.Client, *RegistrationController) {
+	s := runtime.NewScheme()
+	s.AddKnownTypes(v1alpha1.GroupVersion, registration)
+	k8sClient := fake.NewFakeClientWithScheme(s, registration)
+
+	consul, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { consul.Stop() })
+	consul.WaitForLeader(t)
+	consulClient, err := capi.NewClient(&capi.Config{
+		Address: consul.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	return k8sClient, consulClient, &RegistrationController{
+		Client: k8sClient,
+		Log:    logrtest.TestLogger{T: t},
+		Scheme: s,
+		ConfigEntryController: &ConfigEntryController{
+			ConsulClient:   consulClient,
+			DatacenterName: datacenterName,
+		},
+	}
+}
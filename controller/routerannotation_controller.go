@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	consulv1alpha1 "github.com/hashicorp/consul-k8s/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// AnnotationRetryAttempts is the annotation on a Kubernetes Service that
+	// sets the number of times requests to the service are retried when
+	// they fail to connect or the service responds with a 503.
+	AnnotationRetryAttempts = "consul.hashicorp.com/retry-attempts"
+
+	// AnnotationRequestTimeout is the annotation on a Kubernetes Service that
+	// sets the total time a request to the service, including retries, may
+	// take, e.g. "15s".
+	AnnotationRequestTimeout = "consul.hashicorp.com/request-timeout"
+)
+
+// RouterAnnotationController generates a ServiceRouter from the
+// AnnotationRetryAttempts and AnnotationRequestTimeout annotations on
+// Kubernetes Services. The ServiceRouter has the same name and namespace as
+// the Service and a single catch-all route, and is owned by the Service so
+// it is removed along with it. It is then synced to Consul by the
+// ServiceRouterController like any other ServiceRouter.
+//
+// A ServiceRouter that already exists and isn't owned by the Service is left
+// untouched so that the annotations never override a router written by hand.
+type RouterAnnotationController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups=consul.hashicorp.com,resources=servicerouters,verbs=get;list;watch;create;update;patch;delete
+
+func (r *RouterAnnotationController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	logger := r.Log.WithValues("request", req.NamespacedName)
+
+	var svc corev1.Service
+	err := r.Get(ctx, req.NamespacedName, &svc)
+	if k8serr.IsNotFound(err) {
+		// The ServiceRouter is garbage collected by Kubernetes since it is
+		// owned by the Service.
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		logger.Error(err, "retrieving service")
+		return ctrl.Result{}, err
+	}
+
+	var existing consulv1alpha1.ServiceRouter
+	err = r.Get(ctx, req.NamespacedName, &existing)
+	if err != nil && !k8serr.IsNotFound(err) {
+		logger.Error(err, "retrieving service router")
+		return ctrl.Result{}, err
+	}
+	found := err == nil
+	if found && !metav1.IsControlledBy(&existing, &svc) {
+		if hasRouterAnnotations(&svc) {
+			logger.Info("service router already exists and is not managed by the service's annotations - skipping")
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !hasRouterAnnotations(&svc) {
+		if !found {
+			return ctrl.Result{}, nil
+		}
+		if err := r.Delete(ctx, &existing); err != nil && !k8serr.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		logger.Info("service router deleted")
+		return ctrl.Result{}, nil
+	}
+
+	destination, err := routeDestinationFromAnnotations(&svc)
+	if err != nil {
+		// Retrying won't help until the annotations are changed.
+		logger.Error(err, "invalid annotations")
+		return ctrl.Result{}, nil
+	}
+
+	if !found {
+		router := &consulv1alpha1.ServiceRouter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      svc.Name,
+				Namespace: svc.Namespace,
+			},
+			Spec: consulv1alpha1.ServiceRouterSpec{
+				Routes: []consulv1alpha1.ServiceRoute{{Destination: destination}},
+			},
+		}
+		if err := controllerutil.SetControllerReference(&svc, router, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, router); err != nil {
+			return ctrl.Result{}, err
+		}
+		logger.Info("service router created")
+		return ctrl.Result{}, nil
+	}
+
+	// The Consul namespace of the destination is defaulted by the webhook
+	// so it's kept to avoid updating the router on every reconcile.
+	if len(existing.Spec.Routes) == 1 && existing.Spec.Routes[0].Destination != nil {
+		destination.Namespace = existing.Spec.Routes[0].Destination.Namespace
+	}
+	routes := []consulv1alpha1.ServiceRoute{{Destination: destination}}
+	if reflect.DeepEqual(existing.Spec.Routes, routes) {
+		return ctrl.Result{}, nil
+	}
+	existing.Spec.Routes = routes
+	if err := r.Update(ctx, &existing); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.Info("service router updated")
+	return ctrl.Result{}, nil
+}
+
+func (r *RouterAnnotationController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("routerannotation").
+		For(&corev1.Service{}).
+		Owns(&consulv1alpha1.ServiceRouter{}).
+		Complete(r)
+}
+
+func hasRouterAnnotations(svc *corev1.Service) bool {
+	_, retries := svc.Annotations[AnnotationRetryAttempts]
+	_, timeout := svc.Annotations[AnnotationRequestTimeout]
+	return retries || timeout
+}
+
+// routeDestinationFromAnnotations returns the destination of the catch-all
+// route configured by the annotations on svc.
+func routeDestinationFromAnnotations(svc *corev1.Service) (*consulv1alpha1.ServiceRouteDestination, error) {
+	destination := &consulv1alpha1.ServiceRouteDestination{}
+	if raw, ok := svc.Annotations[AnnotationRetryAttempts]; ok {
+		attempts, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s annotation value of %q is invalid: must be a non-negative integer", AnnotationRetryAttempts, raw)
+		}
+		if attempts > 0 {
+			destination.NumRetries = uint32(attempts)
+			destination.RetryOnConnectFailure = true
+			// A 503 is returned when no healthy instance is available so
+			// it is safe to retry, unlike other 5xx codes where the
+			// request may have been processed.
+			destination.RetryOnStatusCodes = []uint32{503}
+		}
+	}
+	if raw, ok := svc.Annotations[AnnotationRequestTimeout]; ok {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%s annotation value of %q is invalid: must be a positive duration, e.g. \"15s\"", AnnotationRequestTimeout, raw)
+		}
+		destination.RequestTimeout = timeout
+	}
+	return destination, nil
+}
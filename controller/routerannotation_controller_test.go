@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	logrtest "github.com/go-logr/logr/testing"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Test that a ServiceRouter is generated from the annotations, updated when
+// they change and deleted when they are removed.
+func TestRouterAnnotationController_lifecycle(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	ctx := context.Background()
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+			UID:       "web-uid",
+			Annotations: map[string]string{
+				AnnotationRetryAttempts:  "3",
+				AnnotationRequestTimeout: "15s",
+			},
+		},
+	}
+	r := setupRouterAnnotationController(t, svc)
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "web"}
+	reconcile := func() {
+		resp, err := r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+		req.NoError(err)
+		req.False(resp.Requeue)
+	}
+
+	// Create.
+	reconcile()
+	var router v1alpha1.ServiceRouter
+	req.NoError(r.Get(ctx, namespacedName, &router))
+	req.True(metav1.IsControlledBy(&router, svc))
+	req.Equal([]v1alpha1.ServiceRoute{
+		{
+			Destination: &v1alpha1.ServiceRouteDestination{
+				RequestTimeout:        15 * time.Second,
+				NumRetries:            3,
+				RetryOnConnectFailure: true,
+				RetryOnStatusCodes:    []uint32{503},
+			},
+		},
+	}, router.Spec.Routes)
+
+	// Update.
+	req.NoError(r.Get(ctx, namespacedName, svc))
+	delete(svc.Annotations, AnnotationRetryAttempts)
+	req.NoError(r.Update(ctx, svc))
+	reconcile()
+	// Decode into a new resource since decoding doesn't clear omitted fields.
+	var updated v1alpha1.ServiceRouter
+	req.NoError(r.Get(ctx, namespacedName, &updated))
+	req.Equal([]v1alpha1.ServiceRoute{
+		{Destination: &v1alpha1.ServiceRouteDestination{RequestTimeout: 15 * time.Second}},
+	}, updated.Spec.Routes)
+
+	// Removing the annotations deletes the router.
+	req.NoError(r.Get(ctx, namespacedName, svc))
+	svc.Annotations = nil
+	req.NoError(r.Update(ctx, svc))
+	reconcile()
+	err := r.Get(ctx, namespacedName, &router)
+	req.True(k8serr.IsNotFound(err), "expected not found error, got: %v", err)
+}
+
+// Test that a ServiceRouter that wasn't generated from the annotations is
+// neither modified nor deleted.
+func TestRouterAnnotationController_doesNotModifyUnownedRouter(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	ctx := context.Background()
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationRetryAttempts: "3"},
+		},
+	}
+	routes := []v1alpha1.ServiceRoute{
+		{
+			Match: &v1alpha1.ServiceRouteMatch{
+				HTTP: &v1alpha1.ServiceRouteHTTPMatch{PathPrefix: "/admin"},
+			},
+			Destination: &v1alpha1.ServiceRouteDestination{Service: "admin"},
+		},
+	}
+	r := setupRouterAnnotationController(t, svc, &v1alpha1.ServiceRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       v1alpha1.ServiceRouterSpec{Routes: routes},
+	})
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	var router v1alpha1.ServiceRouter
+	req.NoError(r.Get(ctx, namespacedName, &router))
+	req.Equal(routes, router.Spec.Routes)
+
+	svc.Annotations = nil
+	req.NoError(r.Update(ctx, svc))
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	req.NoError(r.Get(ctx, namespacedName, &router))
+}
+
+func TestRouteDestinationFromAnnotations(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		annotations map[string]string
+		exp         *v1alpha1.ServiceRouteDestination
+		expErr      string
+	}{
+		"zero retries": {
+			annotations: map[string]string{AnnotationRetryAttempts: "0"},
+			exp:         &v1alpha1.ServiceRouteDestination{},
+		},
+		"invalid retries": {
+			annotations: map[string]string{AnnotationRetryAttempts: "-1"},
+			expErr:      `consul.hashicorp.com/retry-attempts annotation value of "-1" is invalid: must be a non-negative integer`,
+		},
+		"invalid timeout": {
+			annotations: map[string]string{AnnotationRequestTimeout: "15"},
+			expErr:      `consul.hashicorp.com/request-timeout annotation value of "15" is invalid: must be a positive duration, e.g. "15s"`,
+		},
+		"zero timeout": {
+			annotations: map[string]string{AnnotationRequestTimeout: "0s"},
+			expErr:      `consul.hashicorp.com/request-timeout annotation value of "0s" is invalid: must be a positive duration, e.g. "15s"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations}}
+			destination, err := routeDestinationFromAnnotations(svc)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, destination)
+		})
+	}
+}
+
+func setupRouterAnnotationController(t *testing.T, objs ...runtime.Object) *RouterAnnotationController {
+	s := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(s))
+	s.AddKnownTypes(v1alpha1.GroupVersion, &v1alpha1.ServiceRouter{}, &v1alpha1.ServiceRouterList{})
+	return &RouterAnnotationController{
+		Client: fake.NewFakeClientWithScheme(s, objs...),
+		Log:    logrtest.TestLogger{T: t},
+		Scheme: s,
+	}
+}
@@ -37,9 +37,13 @@ type Bundle struct {
 // when a new Bundle is available.
 type MetaBundle struct {
 	Bundle
-	// WebhookConfigName is the name of the MutatingWebhookConfiguration
+	// WebhookConfigName is the name of the webhook configuration
 	// that will be updated with the CA bundle when a new CA is generated.
 	WebhookConfigName string
+	// WebhookConfigType is the type of the webhook configuration, either
+	// "mutating" for a MutatingWebhookConfiguration or "validating" for a
+	// ValidatingWebhookConfiguration. Empty means "mutating".
+	WebhookConfigType string
 	// SecretName is the name of the Kubernetes TLS secret that will be
 	// be created/updated with the leaf certificate and it's private key when
 	// a new certificate key pair are generated.
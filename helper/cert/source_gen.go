@@ -28,6 +28,10 @@ type GenSource struct {
 	// is about 10% of Expiry.
 	ExpiryWithin time.Duration
 
+	// Key is the type and size of the private keys of the CA and leaf
+	// certificates. This defaults to 256-bit ECDSA keys.
+	Key KeySpec
+
 	mu             sync.Mutex
 	caCert         string
 	caCertTemplate *x509.Certificate
@@ -76,7 +80,7 @@ func (s *GenSource) Certificate(ctx context.Context, last *Bundle) (Bundle, erro
 	}
 
 	// Generate cert, set it on the result, and return
-	cert, key, err := GenerateCert(s.Name+" Service", s.expiry(), s.caCertTemplate, s.caSigner, s.Hosts)
+	cert, key, err := GenerateCertWithKey(s.Name+" Service", s.expiry(), s.caCertTemplate, s.caSigner, s.Hosts, s.Key)
 	if err == nil {
 		result.Cert = []byte(cert)
 		result.Key = []byte(key)
@@ -104,7 +108,7 @@ func (s *GenSource) expiryWithin() time.Duration {
 
 func (s *GenSource) generateCA() error {
 	// generate the CA
-	signer, _, caCertPem, caCertTemplate, err := GenerateCA(s.Name + " CA")
+	signer, _, caCertPem, caCertTemplate, err := GenerateCAWithKey(s.Name+" CA", s.Key)
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,8 @@ package cert
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -65,6 +67,62 @@ func TestGenSource_expiry(t *testing.T) {
 	testBundleVerify(t, &bundle)
 }
 
+// Test that the CA and leaf keys are generated with the configured type and size.
+func TestGenSource_key(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		key     KeySpec
+		expType string
+		expBits int
+	}{
+		"default":  {KeySpec{}, KeyTypeEC, 256},
+		"EC 384":   {KeySpec{Type: KeyTypeEC, Bits: 384}, KeyTypeEC, 384},
+		"RSA":      {KeySpec{Type: KeyTypeRSA}, KeyTypeRSA, 2048},
+		"RSA 3072": {KeySpec{Type: KeyTypeRSA, Bits: 3072}, KeyTypeRSA, 3072},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			source := testGenSource()
+			source.Key = c.key
+			bundle, err := source.Certificate(context.Background(), nil)
+			require.NoError(t, err)
+
+			signer, err := ParseSigner(string(bundle.Key))
+			require.NoError(t, err)
+			caCert, err := ParseCert(bundle.CACert)
+			require.NoError(t, err)
+			for _, pub := range []interface{}{signer.Public(), caCert.PublicKey} {
+				switch k := pub.(type) {
+				case *ecdsa.PublicKey:
+					require.Equal(t, c.expType, KeyTypeEC)
+					require.Equal(t, c.expBits, k.Curve.Params().BitSize)
+				case *rsa.PublicKey:
+					require.Equal(t, c.expType, KeyTypeRSA)
+					require.Equal(t, c.expBits, k.N.BitLen())
+				default:
+					t.Fatalf("unexpected key type %T", pub)
+				}
+			}
+			if hasOpenSSL {
+				testBundleVerify(t, &bundle)
+			}
+		})
+	}
+}
+
+func TestKeySpec_Validate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, KeySpec{}.Validate())
+	require.NoError(t, KeySpec{Type: KeyTypeRSA, Bits: 4096}.Validate())
+	require.EqualError(t, KeySpec{Type: KeyTypeEC, Bits: 521}.Validate(), "EC keys must be 256 or 384 bits, not 521")
+	require.EqualError(t, KeySpec{Type: KeyTypeRSA, Bits: 1024}.Validate(), "RSA keys must be 2048, 3072 or 4096 bits, not 1024")
+	require.EqualError(t, KeySpec{Type: "dsa"}.Validate(), `key type must be "ec" or "rsa", not "dsa"`)
+}
+
 func testGenSource() *GenSource {
 	return &GenSource{
 		Name:  "Test",
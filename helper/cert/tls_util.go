@@ -6,6 +6,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -17,18 +18,68 @@ import (
 	"time"
 )
 
+const (
+	// KeyTypeEC is the KeySpec type of ECDSA keys.
+	KeyTypeEC = "ec"
+
+	// KeyTypeRSA is the KeySpec type of RSA keys.
+	KeyTypeRSA = "rsa"
+)
+
+// KeySpec is the type and size of the private keys to generate.
+type KeySpec struct {
+	// Type is KeyTypeEC or KeyTypeRSA. Defaults to KeyTypeEC.
+	Type string
+
+	// Bits is the curve size of EC keys, 256 or 384, or the size of RSA
+	// keys, 2048, 3072 or 4096. Defaults to 256 for EC keys and 2048 for
+	// RSA keys.
+	Bits int
+}
+
+// Validate returns an error if the key type or size isn't supported.
+func (k KeySpec) Validate() error {
+	switch k.Type {
+	case "", KeyTypeEC:
+		switch k.Bits {
+		case 0, 256, 384:
+			return nil
+		}
+		return fmt.Errorf("EC keys must be 256 or 384 bits, not %d", k.Bits)
+	case KeyTypeRSA:
+		switch k.Bits {
+		case 0, 2048, 3072, 4096:
+			return nil
+		}
+		return fmt.Errorf("RSA keys must be 2048, 3072 or 4096 bits, not %d", k.Bits)
+	default:
+		return fmt.Errorf("key type must be %q or %q, not %q", KeyTypeEC, KeyTypeRSA, k.Type)
+	}
+}
+
 // GenerateCA generates a CA with the provided
 // common name valid for 10 years. It returns the private key as
 // a crypto.Signer and a PEM string and certificate
 // as a *x509.Certificate and a PEM string or an error.
 func GenerateCA(commonName string) (
+	signer crypto.Signer,
+	keyPem string,
+	caCertPem string,
+	caCertTemplate *x509.Certificate,
+	err error) {
+	return GenerateCAWithKey(commonName, KeySpec{})
+}
+
+// GenerateCAWithKey is like GenerateCA but generates the private key of the
+// CA as described by key.
+func GenerateCAWithKey(commonName string, key KeySpec) (
 	signer crypto.Signer,
 	keyPem string,
 	caCertPem string,
 	caCertTemplate *x509.Certificate,
 	err error) {
 	// Create the private key we'll use for this CA cert.
-	signer, keyPem, err = privateKey()
+	signer, keyPem, err = privateKey(key)
 	if err != nil {
 		return
 	}
@@ -92,8 +143,20 @@ func GenerateCert(
 	caCert *x509.Certificate,
 	caCertSigner crypto.Signer,
 	hosts []string) (string, string, error) {
+	return GenerateCertWithKey(commonName, expiry, caCert, caCertSigner, hosts, KeySpec{})
+}
+
+// GenerateCertWithKey is like GenerateCert but generates the private key of
+// the certificate as described by key.
+func GenerateCertWithKey(
+	commonName string,
+	expiry time.Duration,
+	caCert *x509.Certificate,
+	caCertSigner crypto.Signer,
+	hosts []string,
+	key KeySpec) (string, string, error) {
 	// Create the private key we'll use for this leaf cert.
-	signer, keyPEM, err := privateKey()
+	signer, keyPEM, err := privateKey(key)
 	if err != nil {
 		return "", "", err
 	}
@@ -162,31 +225,57 @@ func ParseSigner(pemValue string) (crypto.Signer, error) {
 	switch block.Type {
 	case "EC PRIVATE KEY":
 		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
 	default:
 		return nil, fmt.Errorf("unknown PEM block type for signing key: %s", block.Type)
 	}
 }
 
-// privateKey returns a new ECDSA-based private key. Both a crypto.Signer
-// and the key in PEM format are returned.
-func privateKey() (crypto.Signer, string, error) {
-	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
+// privateKey returns a new private key as described by key. Both a
+// crypto.Signer and the key in PEM format are returned.
+func privateKey(key KeySpec) (crypto.Signer, string, error) {
+	if err := key.Validate(); err != nil {
 		return nil, "", err
 	}
 
-	bs, err := x509.MarshalECPrivateKey(pk)
-	if err != nil {
-		return nil, "", err
+	var signer crypto.Signer
+	var block pem.Block
+	if key.Type == KeyTypeRSA {
+		bits := key.Bits
+		if bits == 0 {
+			bits = 2048
+		}
+		pk, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, "", err
+		}
+		signer = pk
+		block = pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)}
+	} else {
+		curve := elliptic.P256()
+		if key.Bits == 384 {
+			curve = elliptic.P384()
+		}
+		pk, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, "", err
+		}
+		bs, err := x509.MarshalECPrivateKey(pk)
+		if err != nil {
+			return nil, "", err
+		}
+		signer = pk
+		block = pem.Block{Type: "EC PRIVATE KEY", Bytes: bs}
 	}
 
 	var buf bytes.Buffer
-	err = pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: bs})
+	err := pem.Encode(&buf, &block)
 	if err != nil {
 		return nil, "", err
 	}
 
-	return pk, buf.String(), nil
+	return signer, buf.String(), nil
 }
 
 // serialNumber generates a new random serial number.
@@ -195,10 +284,10 @@ func serialNumber() (*big.Int, error) {
 }
 
 // keyId returns a x509 keyId from the given signing key. The key must be
-// an *ecdsa.PublicKey currently, but may support more types in the future.
+// an *ecdsa.PublicKey or an *rsa.PublicKey.
 func keyId(raw interface{}) ([]byte, error) {
 	switch raw.(type) {
-	case *ecdsa.PublicKey:
+	case *ecdsa.PublicKey, *rsa.PublicKey:
 	default:
 		return nil, fmt.Errorf("invalid key type: %T", raw)
 	}
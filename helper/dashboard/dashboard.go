@@ -0,0 +1,70 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// MetaKey is the key of the rendered URL template in the meta of the
+// services registered by the connect injector and by catalog sync, so that
+// consumers find the link under the same key for both.
+const MetaKey = "k8s-dashboard-url"
+
+// URLData is the data a URL template is rendered with.
+type URLData struct {
+	// Namespace is the Kubernetes namespace of the object.
+	Namespace string
+
+	// Name is the name of the Kubernetes object the registration comes
+	// from: the pod for Connect services and the service for synced
+	// services.
+	Name string
+
+	// ServiceName is the name of the service in Consul.
+	ServiceName string
+}
+
+// URLTemplate renders links to the Kubernetes dashboard that are added to the
+// meta of services registered in Consul so that the owning objects can be
+// found from Consul.
+type URLTemplate struct {
+	tpl *template.Template
+}
+
+// ParseURLTemplate parses raw as a Go template, e.g.
+// "https://dashboard.example.com/#/pod/{{ .Namespace }}/{{ .Name }}".
+func ParseURLTemplate(raw string) (*URLTemplate, error) {
+	tpl, err := template.New("dashboard-url").Option("missingkey=error").Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	t := &URLTemplate{tpl: tpl}
+
+	// Render the template once so that templates referencing fields that
+	// don't exist are caught at startup.
+	url, err := t.Render(URLData{Namespace: "namespace", Name: "name", ServiceName: "service"})
+	if err != nil {
+		return nil, err
+	}
+	// The URL is written into the HCL service definition by the Connect
+	// init container's shell script so these can't be escaped.
+	if strings.ContainsAny(url, "\"\\`$") {
+		return nil, fmt.Errorf("must not contain any of the characters \" \\ ` $")
+	}
+	return t, nil
+}
+
+// Render returns the URL for data. It returns an empty string if t is nil
+// so that callers don't need to check whether a template was configured.
+func (t *URLTemplate) Render(data URLData) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := t.tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
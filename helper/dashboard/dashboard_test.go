@@ -0,0 +1,53 @@
+package dashboard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURLTemplate(t *testing.T) {
+	cases := map[string]struct {
+		raw    string
+		exp    string
+		expErr string
+	}{
+		"all fields": {
+			raw: "https://dashboard.example.com/#/{{ .Namespace }}/{{ .Name }}?service={{ .ServiceName }}",
+			exp: "https://dashboard.example.com/#/default/web-a1b2c?service=web",
+		},
+		"invalid syntax": {
+			raw:    "https://dashboard.example.com/{{ .Name",
+			expErr: "unclosed action",
+		},
+		"unknown field": {
+			raw:    "https://dashboard.example.com/{{ .Pod }}",
+			expErr: "can't evaluate field Pod",
+		},
+		"shell variable": {
+			raw:    "https://${DASHBOARD_HOST}/{{ .Name }}",
+			expErr: "must not contain any of the characters",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tpl, err := ParseURLTemplate(c.raw)
+			if c.expErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			url, err := tpl.Render(URLData{Namespace: "default", Name: "web-a1b2c", ServiceName: "web"})
+			require.NoError(t, err)
+			require.Equal(t, c.exp, url)
+		})
+	}
+}
+
+func TestRender_Nil(t *testing.T) {
+	var tpl *URLTemplate
+	url, err := tpl.Render(URLData{Name: "web"})
+	require.NoError(t, err)
+	require.Empty(t, url)
+}
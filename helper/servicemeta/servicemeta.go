@@ -0,0 +1,98 @@
+// Package servicemeta copies Kubernetes labels and annotations into the meta
+// of the services registered in Consul.
+package servicemeta
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxKeyLength and maxValueLength are the limits Consul enforces on
+	// service meta.
+	maxKeyLength   = 128
+	maxValueLength = 512
+
+	// reservedKeyPrefix is reserved by Consul for internal meta keys.
+	reservedKeyPrefix = "consul-"
+)
+
+// invalidKeyChars matches the characters that aren't allowed in Consul meta
+// keys.
+var invalidKeyChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// Propagator copies the labels and annotations that start with one of its
+// prefixes into service meta.
+type Propagator struct {
+	// LabelPrefixes and AnnotationPrefixes are the prefixes of the labels
+	// and annotations to copy.
+	LabelPrefixes      []string
+	AnnotationPrefixes []string
+}
+
+// Meta returns the meta for the matching labels and annotations, keyed by the
+// label or annotation key with any character that isn't allowed in Consul
+// meta keys replaced by an underscore, e.g. "example.com/team" becomes
+// "example_com_team". Labels take precedence over annotations with the same
+// sanitized key.
+//
+// Values that can't be written to a Connect service definition, i.e. that
+// contain quotes, backslashes, backticks, '$' or newlines, are skipped as are
+// keys and values longer than Consul allows. An error describing each skipped
+// entry is returned so that it can be logged.
+func (p *Propagator) Meta(labels, annotations map[string]string) (map[string]string, []error) {
+	meta := make(map[string]string)
+	if p == nil {
+		return meta, nil
+	}
+	var errs []error
+	// Annotations are added first so that labels overwrite them.
+	errs = append(errs, add(meta, "annotation", annotations, p.AnnotationPrefixes)...)
+	errs = append(errs, add(meta, "label", labels, p.LabelPrefixes)...)
+	return meta, errs
+}
+
+func add(meta map[string]string, kind string, from map[string]string, prefixes []string) []error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	// Sort the keys so that keys that sanitize to the same value always
+	// resolve the same way.
+	keys := make([]string, 0, len(from))
+	for k := range from {
+		if hasAnyPrefix(k, prefixes) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, k := range keys {
+		v := from[k]
+		metaKey := invalidKeyChars.ReplaceAllString(k, "_")
+		switch {
+		case len(metaKey) > maxKeyLength:
+			errs = append(errs, fmt.Errorf("%s %q: key is longer than %d characters", kind, k, maxKeyLength))
+		case strings.HasPrefix(metaKey, reservedKeyPrefix):
+			errs = append(errs, fmt.Errorf("%s %q: keys starting with %q are reserved by Consul", kind, k, reservedKeyPrefix))
+		case len(v) > maxValueLength:
+			errs = append(errs, fmt.Errorf("%s %q: value is longer than %d characters", kind, k, maxValueLength))
+		case strings.ContainsAny(v, "\"\\`$\n"):
+			errs = append(errs, fmt.Errorf("%s %q: value must not contain any of the characters \" \\ ` $ or newlines", kind, k))
+		default:
+			meta[metaKey] = v
+		}
+	}
+	return errs
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package servicemeta
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropagator_Meta(t *testing.T) {
+	cases := map[string]struct {
+		propagator *Propagator
+		labels     map[string]string
+		annots     map[string]string
+		exp        map[string]string
+		expErrs    []string
+	}{
+		"nil": {
+			labels: map[string]string{"team": "a"},
+			exp:    map[string]string{},
+		},
+		"no prefixes": {
+			propagator: &Propagator{},
+			labels:     map[string]string{"team": "a"},
+			exp:        map[string]string{},
+		},
+		"matching labels and annotations": {
+			propagator: &Propagator{
+				LabelPrefixes:      []string{"team", "app.kubernetes.io/"},
+				AnnotationPrefixes: []string{"example.com/"},
+			},
+			labels: map[string]string{
+				"team":                      "payments",
+				"app.kubernetes.io/version": "1.2.3",
+				"tier":                      "backend",
+			},
+			annots: map[string]string{
+				"example.com/cost-center": "42",
+				"other.com/owner":         "me",
+			},
+			exp: map[string]string{
+				"team":                      "payments",
+				"app_kubernetes_io_version": "1.2.3",
+				"example_com_cost-center":   "42",
+			},
+		},
+		"labels take precedence": {
+			propagator: &Propagator{LabelPrefixes: []string{"team"}, AnnotationPrefixes: []string{"team"}},
+			labels:     map[string]string{"team": "label"},
+			annots:     map[string]string{"team": "annotation"},
+			exp:        map[string]string{"team": "label"},
+		},
+		"skipped": {
+			propagator: &Propagator{AnnotationPrefixes: []string{"example.com/", "consul-"}},
+			annots: map[string]string{
+				"example.com/" + strings.Repeat("k", 120): "v",
+				"example.com/long-value":                  strings.Repeat("v", 513),
+				"example.com/json":                        `{"a": 1}`,
+				"consul-version":                          "1.9",
+				"example.com/ok":                          "yes",
+			},
+			exp: map[string]string{"example_com_ok": "yes"},
+			expErrs: []string{
+				`annotation "consul-version": keys starting with "consul-" are reserved by Consul`,
+				`annotation "example.com/json": value must not contain any of the characters`,
+				`annotation "example.com/kkkk`,
+				`annotation "example.com/long-value": value is longer than 512 characters`,
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			meta, errs := c.propagator.Meta(c.labels, c.annots)
+			require.Equal(t, c.exp, meta)
+			require.Len(t, errs, len(c.expErrs))
+			for i, expErr := range c.expErrs {
+				require.Contains(t, errs[i].Error(), expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// Package vault is a minimal client of the Vault HTTP API for the commands
+// that read from or write to Vault.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// AuthMethodToken uses the token in Config.TokenFile or the VAULT_TOKEN
+	// environment variable, if any.
+	AuthMethodToken = "token"
+	// AuthMethodKubernetes logs in with the pod's service account token
+	// using Vault's Kubernetes auth method.
+	AuthMethodKubernetes = "kubernetes"
+
+	// DefaultBearerTokenFile is the pod's service account token.
+	DefaultBearerTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// Config is the configuration of a Client.
+type Config struct {
+	// Addr is the address of the Vault server, e.g. https://vault:8200.
+	Addr string
+	// CAFile and TLSServerName configure the TLS connections to Vault.
+	CAFile        string
+	TLSServerName string
+	// Namespace is the Vault Enterprise namespace of the requests.
+	Namespace string
+
+	// AuthMethod is AuthMethodToken or AuthMethodKubernetes.
+	AuthMethod string
+	// TokenFile is the file containing the token with AuthMethodToken.
+	TokenFile string
+	// AuthPath and Role are the mount path of the Kubernetes auth method and
+	// the role to log in as. BearerTokenFile is the service account token
+	// to log in with.
+	AuthPath        string
+	Role            string
+	BearerTokenFile string
+}
+
+// Client sends requests to the Vault HTTP API.
+type Client struct {
+	addr            string
+	namespace       string
+	authMethod      string
+	authPath        string
+	role            string
+	bearerTokenFile string
+
+	http  *http.Client
+	token string
+}
+
+// NewClient returns a client for the Vault server at cfg.Addr.
+func NewClient(cfg Config) (*Client, error) {
+	tlsConfig := &tls.Config{ServerName: cfg.TLSServerName}
+	if cfg.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading Vault CA file: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("Vault CA file %s contains no PEM certificates", cfg.CAFile)
+		}
+	}
+
+	client := &Client{
+		addr:            strings.TrimSuffix(cfg.Addr, "/"),
+		namespace:       cfg.Namespace,
+		authMethod:      cfg.AuthMethod,
+		authPath:        strings.Trim(cfg.AuthPath, "/"),
+		role:            cfg.Role,
+		bearerTokenFile: cfg.BearerTokenFile,
+		http: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+	if client.authMethod == AuthMethodToken {
+		if cfg.TokenFile != "" {
+			token, err := ioutil.ReadFile(cfg.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading Vault token file: %s", err)
+			}
+			client.token = strings.TrimSpace(string(token))
+		} else {
+			client.token = os.Getenv("VAULT_TOKEN")
+		}
+	}
+	return client, nil
+}
+
+// Get sends a GET request to the Vault API and returns the response body.
+func (c *Client) Get(ctx context.Context, path string) ([]byte, error) {
+	resp, _, err := c.request(ctx, http.MethodGet, path, nil)
+	return resp, err
+}
+
+// ReadKV returns the value of key in the secret at path of the KV version 2
+// secrets engine mounted at mount, and false if there's no such secret.
+func (c *Client) ReadKV(ctx context.Context, mount, path, key string) (string, bool, error) {
+	resp, status, err := c.request(ctx, http.MethodGet, kvPath(mount, path), nil)
+	if status == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &secret); err != nil {
+		return "", false, fmt.Errorf("decoding Vault secret %s: %s", path, err)
+	}
+	// The secret exists but its latest version is deleted.
+	if secret.Data.Data == nil {
+		return "", false, nil
+	}
+	value, ok := secret.Data.Data[key].(string)
+	if !ok {
+		return "", false, fmt.Errorf("Vault secret %s has no string value for key %q", path, key)
+	}
+	return value, true, nil
+}
+
+// WriteKV writes data as a new version of the secret at path of the KV
+// version 2 secrets engine mounted at mount.
+func (c *Client) WriteKV(ctx context.Context, mount, path string, data map[string]string) error {
+	body, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	_, _, err = c.request(ctx, http.MethodPost, kvPath(mount, path), body)
+	return err
+}
+
+// kvPath returns the API path of the data of the secret at path of the KV
+// version 2 secrets engine mounted at mount.
+func kvPath(mount, path string) string {
+	return fmt.Sprintf("/v1/%s/data/%s", strings.Trim(mount, "/"), strings.Trim(path, "/"))
+}
+
+// login logs in to Vault with the Kubernetes auth method and keeps the
+// token it returns for later requests.
+func (c *Client) login(ctx context.Context) error {
+	jwt, err := ioutil.ReadFile(c.bearerTokenFile)
+	if err != nil {
+		return fmt.Errorf("reading service account token: %s", err)
+	}
+	body, err := json.Marshal(map[string]string{
+		"role": c.role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return err
+	}
+	resp, _, err := c.send(ctx, http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", c.authPath), body)
+	if err != nil {
+		return fmt.Errorf("logging in to Vault: %w", err)
+	}
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(resp, &login); err != nil {
+		return fmt.Errorf("decoding Vault login response: %s", err)
+	}
+	if login.Auth.ClientToken == "" {
+		return fmt.Errorf("Vault login response has no client token")
+	}
+	c.token = login.Auth.ClientToken
+	return nil
+}
+
+// request sends a request to the Vault API, logging in first if needed, and
+// returns the response body and status code.
+func (c *Client) request(ctx context.Context, method, path string, body []byte) ([]byte, int, error) {
+	if c.authMethod == AuthMethodKubernetes && c.token == "" {
+		if err := c.login(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+	return c.send(ctx, method, path, body)
+}
+
+// send sends a request to the Vault API and returns the response body and
+// status code.
+func (c *Client) send(ctx context.Context, method, path string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		// Log in again on the next attempt in case the token expired.
+		if resp.StatusCode == http.StatusForbidden && c.authMethod == AuthMethodKubernetes {
+			c.token = ""
+		}
+		return nil, resp.StatusCode, fmt.Errorf("unexpected response code from %s %s: %d (%s)",
+			method, path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, resp.StatusCode, nil
+}
@@ -0,0 +1,97 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that secrets are written to and read from the KV secrets engine and
+// that the Kubernetes auth method logs in again once its token expired.
+func TestClient_KV(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "vault")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	jwtFile := filepath.Join(dir, "jwt")
+	require.NoError(t, ioutil.WriteFile(jwtFile, []byte("service-account-jwt\n"), 0600))
+
+	logins := 0
+	validToken := ""
+	var stored map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/k8s/login" {
+			var body map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Equal(t, map[string]string{"role": "consul", "jwt": "service-account-jwt"}, body)
+			logins++
+			validToken = fmt.Sprintf("token-%d", logins)
+			json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": validToken}})
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != validToken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		require.Equal(t, "/v1/kv/data/consul/token", r.URL.Path)
+		switch r.Method {
+		case http.MethodPost:
+			var body struct {
+				Data map[string]string `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			stored = body.Data
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": stored}})
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Addr:            server.URL,
+		AuthMethod:      AuthMethodKubernetes,
+		AuthPath:        "/k8s/",
+		Role:            "consul",
+		BearerTokenFile: jwtFile,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// There's no secret yet.
+	_, ok, err := client.ReadKV(ctx, "kv", "consul/token", "token")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, client.WriteKV(ctx, "kv/", "/consul/token", map[string]string{"token": "secret-id"}))
+	value, ok, err := client.ReadKV(ctx, "kv", "consul/token", "token")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "secret-id", value)
+	require.Equal(t, 1, logins)
+
+	_, _, err = client.ReadKV(ctx, "kv", "consul/token", "other")
+	require.EqualError(t, err, `Vault secret consul/token has no string value for key "other"`)
+
+	// Once the token expired, the request fails and the next one logs in
+	// again.
+	validToken = "expired"
+	_, _, err = client.ReadKV(ctx, "kv", "consul/token", "token")
+	require.Error(t, err)
+	value, _, err = client.ReadKV(ctx, "kv", "consul/token", "token")
+	require.NoError(t, err)
+	require.Equal(t, "secret-id", value)
+	require.Equal(t, 2, logins)
+}
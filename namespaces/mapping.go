@@ -0,0 +1,81 @@
+package namespaces
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Mapping maps Kubernetes namespaces to Consul namespaces for organizations
+// whose Consul namespaces don't match their Kubernetes namespaces closely
+// enough for mirroring. It is read from a YAML file of the form:
+//
+//	mappings:
+//	- k8sNamespace: "team-a-*"
+//	  consulNamespace: "team-a"
+//	- k8sNamespace: "payments"
+//	  consulNamespace: "finance"
+//
+// The first mapping whose pattern matches a namespace is used. Patterns use
+// the syntax of path.Match so "*" matches any part of a name.
+type Mapping struct {
+	Mappings []NamespaceMapping `json:"mappings"`
+}
+
+// NamespaceMapping maps the Kubernetes namespaces matching K8SNamespace to
+// ConsulNamespace.
+type NamespaceMapping struct {
+	K8SNamespace    string `json:"k8sNamespace"`
+	ConsulNamespace string `json:"consulNamespace"`
+}
+
+// LoadMapping reads and validates the mapping file at filename.
+func LoadMapping(filename string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m Mapping
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", filename, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %s", filename, err)
+	}
+	return &m, nil
+}
+
+func (m *Mapping) validate() error {
+	for i, nm := range m.Mappings {
+		if nm.K8SNamespace == "" {
+			return fmt.Errorf("mappings[%d].k8sNamespace must be set", i)
+		}
+		if _, err := path.Match(nm.K8SNamespace, ""); err != nil {
+			return fmt.Errorf("mappings[%d].k8sNamespace %q is not a valid pattern", i, nm.K8SNamespace)
+		}
+		if nm.ConsulNamespace == "" {
+			return fmt.Errorf("mappings[%d].consulNamespace must be set", i)
+		}
+		if nm.ConsulNamespace == WildcardNamespace {
+			return fmt.Errorf("mappings[%d].consulNamespace can't be %q", i, WildcardNamespace)
+		}
+	}
+	return nil
+}
+
+// ConsulNamespace returns the Consul namespace that kubeNS is mapped to and
+// true, or false if no mapping matches. A nil Mapping matches nothing.
+func (m *Mapping) ConsulNamespace(kubeNS string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	for _, nm := range m.Mappings {
+		// The pattern was validated when the mapping was loaded.
+		if ok, _ := path.Match(nm.K8SNamespace, kubeNS); ok {
+			return nm.ConsulNamespace, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,91 @@
+package namespaces
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapping_ConsulNamespace(t *testing.T) {
+	m := writeMapping(t, `
+mappings:
+- k8sNamespace: "team-a-*"
+  consulNamespace: "team-a"
+- k8sNamespace: "payments"
+  consulNamespace: "finance"
+- k8sNamespace: "team-*"
+  consulNamespace: "teams"
+`)
+	cases := map[string]struct {
+		kubeNS   string
+		expNS    string
+		expFound bool
+	}{
+		"wildcard":           {kubeNS: "team-a-prod", expNS: "team-a", expFound: true},
+		"exact":              {kubeNS: "payments", expNS: "finance", expFound: true},
+		"first match wins":   {kubeNS: "team-a-", expNS: "team-a", expFound: true},
+		"later wildcard":     {kubeNS: "team-b-prod", expNS: "teams", expFound: true},
+		"not matched":        {kubeNS: "default"},
+		"prefix not matched": {kubeNS: "payments-dev"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ns, found := m.ConsulNamespace(c.kubeNS)
+			require.Equal(t, c.expFound, found)
+			require.Equal(t, c.expNS, ns)
+		})
+	}
+}
+
+func TestMapping_Nil(t *testing.T) {
+	var m *Mapping
+	_, found := m.ConsulNamespace("default")
+	require.False(t, found)
+}
+
+func TestLoadMapping_Errors(t *testing.T) {
+	cases := map[string]struct {
+		contents string
+		expErr   string
+	}{
+		"unknown field": {
+			contents: "mappings:\n- k8sNamespace: a\n  namespace: b\n",
+			expErr:   `unknown field "namespace"`,
+		},
+		"missing k8s namespace": {
+			contents: "mappings:\n- consulNamespace: b\n",
+			expErr:   "mappings[0].k8sNamespace must be set",
+		},
+		"invalid pattern": {
+			contents: "mappings:\n- k8sNamespace: \"team-[\"\n  consulNamespace: b\n",
+			expErr:   `mappings[0].k8sNamespace "team-[" is not a valid pattern`,
+		},
+		"missing consul namespace": {
+			contents: "mappings:\n- k8sNamespace: a\n",
+			expErr:   "mappings[0].consulNamespace must be set",
+		},
+		"wildcard consul namespace": {
+			contents: "mappings:\n- k8sNamespace: a\n  consulNamespace: \"*\"\n",
+			expErr:   `mappings[0].consulNamespace can't be "*"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "mapping.yaml")
+			require.NoError(t, ioutil.WriteFile(filename, []byte(c.contents), 0600))
+			_, err := LoadMapping(filename)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expErr)
+		})
+	}
+}
+
+func writeMapping(t *testing.T, contents string) *Mapping {
+	filename := filepath.Join(t.TempDir(), "mapping.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0600))
+	m, err := LoadMapping(filename)
+	require.NoError(t, err)
+	return m
+}
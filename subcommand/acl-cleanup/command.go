@@ -0,0 +1,218 @@
+package aclcleanup
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// loginDescriptionPrefix is the prefix of the description of the tokens
+// created by logging in with an auth method. It's followed by the JSON of
+// the meta passed to the login, which for connect injected pods is
+// {"pod":"<namespace>/<name>"}.
+const loginDescriptionPrefix = "token created via login: "
+
+// Command is the command for deleting the ACL tokens of pods that no longer
+// exist.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	http  *flags.HTTPFlags
+	k8s   *flags.K8SFlags
+
+	flagAuthMethodName   string
+	flagEnableNamespaces bool
+	flagDryRun           bool
+	flagLogLevel         string
+
+	consulClient *api.Client
+	clientset    kubernetes.Interface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagAuthMethodName, "auth-method-name", "",
+		"Name of the Kubernetes auth method whose tokens are cleaned up.")
+	c.flags.BoolVar(&c.flagEnableNamespaces, "enable-namespaces", false,
+		"[Enterprise Only] Clean up the tokens of all Consul namespaces.")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"If true, the orphaned tokens are only logged and not deleted.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagAuthMethodName == "" {
+		c.UI.Error("-auth-method-name must be set")
+		return 1
+	}
+
+	logger, err := common.Logger(c.flagLogLevel)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	var queryOptions api.QueryOptions
+	if c.flagEnableNamespaces {
+		queryOptions.Namespace = "*"
+	}
+	tokens, _, err := c.consulClient.ACL().TokenList(&queryOptions)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error listing ACL tokens: %s", err))
+		return 1
+	}
+
+	deleted, failed := 0, 0
+	for _, token := range tokens {
+		if token.AuthMethod != c.flagAuthMethodName {
+			continue
+		}
+		tokenLog := logger.With("accessorID", token.AccessorID)
+		orphaned, err := c.orphaned(token, tokenLog)
+		if err != nil {
+			tokenLog.Error("unable to check if the token's pod exists", "err", err)
+			failed++
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+		if c.flagDryRun {
+			tokenLog.Info("would delete orphaned token", "description", token.Description)
+			deleted++
+			continue
+		}
+		_, err = c.consulClient.ACL().TokenDelete(token.AccessorID, &api.WriteOptions{Namespace: token.Namespace})
+		if err != nil {
+			tokenLog.Error("unable to delete orphaned token", "err", err)
+			failed++
+			continue
+		}
+		tokenLog.Info("deleted orphaned token", "description", token.Description)
+		deleted++
+	}
+
+	verb := "Deleted"
+	if c.flagDryRun {
+		verb = "Found"
+	}
+	c.UI.Info(fmt.Sprintf("%s %d orphaned tokens of auth method %s", verb, deleted, c.flagAuthMethodName))
+	if failed > 0 {
+		c.UI.Error(fmt.Sprintf("Failed to clean up %d tokens", failed))
+		return 1
+	}
+	return 0
+}
+
+// orphaned returns true if the pod that token was created for doesn't exist
+// anymore, either because it was deleted or because it was replaced by a pod
+// of the same name. Tokens that weren't created for a pod are never orphaned.
+func (c *Command) orphaned(token *api.ACLTokenListEntry, log hclog.Logger) (bool, error) {
+	namespace, name, ok := tokenPod(token.Description)
+	if !ok {
+		log.Debug("skipping token that wasn't created for a pod", "description", token.Description)
+		return false, nil
+	}
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// Pods of stateful sets are recreated with the same name. The token of
+	// the current pod is created after it is.
+	return token.CreateTime.Before(pod.CreationTimestamp.Time), nil
+}
+
+// tokenPod returns the namespace and name of the pod that the token with the
+// description was created for by logging in, if any.
+func tokenPod(description string) (string, string, bool) {
+	if !strings.HasPrefix(description, loginDescriptionPrefix) {
+		return "", "", false
+	}
+	var meta map[string]string
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(description, loginDescriptionPrefix)), &meta); err != nil {
+		return "", "", false
+	}
+	parts := strings.Split(meta["pod"], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Delete the ACL tokens of pods that no longer exist."
+const help = `
+Usage: consul-k8s acl-cleanup [options]
+
+  Deletes the ACL tokens that connect injected pods logged in for with the
+  Kubernetes auth method -auth-method-name when their pod doesn't exist
+  anymore. The tokens are normally deleted when the pods stop, but they
+  are left behind if the pods are killed before they can log out. The pod
+  of each token is the pod in its login meta.
+
+  The command cleans up the tokens once and exits, so that it can be run
+  periodically as a CronJob. It exits non-zero if any token couldn't be
+  checked or deleted.
+`
@@ -0,0 +1,225 @@
+package aclcleanup
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-auth-method-name must be set",
+		},
+		{
+			flags:  []string{"-auth-method-name", "k8s", "-log-level", "invalid"},
+			expErr: "unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that only the tokens of the auth method whose pod doesn't exist
+// anymore are deleted.
+func TestRun_DeletesOrphanedTokens(t *testing.T) {
+	t.Parallel()
+	created := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	tokens := []*api.ACLTokenListEntry{
+		// The pod exists.
+		{AccessorID: "running", AuthMethod: "k8s", CreateTime: created,
+			Description: `token created via login: {"pod":"default/running"}`},
+		// The pod was deleted.
+		{AccessorID: "deleted", AuthMethod: "k8s", CreateTime: created,
+			Description: `token created via login: {"pod":"default/deleted"}`},
+		// The pod was replaced by one of the same name.
+		{AccessorID: "replaced", AuthMethod: "k8s", CreateTime: created,
+			Description: `token created via login: {"pod":"default/web-0"}`},
+		// The token wasn't created for a pod.
+		{AccessorID: "no-pod", AuthMethod: "k8s", CreateTime: created,
+			Description: "token created via login"},
+		// The token is of another auth method.
+		{AccessorID: "other", AuthMethod: "other", CreateTime: created,
+			Description: `token created via login: {"pod":"default/deleted"}`},
+		// The token wasn't created by logging in.
+		{AccessorID: "client", CreateTime: created, Description: "client token"},
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		name := "delete"
+		if dryRun {
+			name = "dry run"
+		}
+		t.Run(name, func(t *testing.T) {
+			consulServer, deletedTokens := testConsul(t, tokens)
+			defer consulServer.Close()
+
+			clientset := fake.NewSimpleClientset(
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:              "running",
+					Namespace:         "default",
+					CreationTimestamp: metav1.NewTime(created.Add(-time.Minute)),
+				}},
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:              "web-0",
+					Namespace:         "default",
+					CreationTimestamp: metav1.NewTime(created.Add(time.Hour)),
+				}},
+			)
+			client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+			require.NoError(t, err)
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:           ui,
+				consulClient: client,
+				clientset:    clientset,
+			}
+			args := []string{"-auth-method-name", "k8s"}
+			if dryRun {
+				args = append(args, "-dry-run")
+			}
+			exitCode := cmd.Run(args)
+			require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+			if dryRun {
+				require.Empty(t, deletedTokens())
+				require.Contains(t, ui.OutputWriter.String(), "Found 2 orphaned tokens of auth method k8s")
+			} else {
+				require.Equal(t, []string{"deleted", "replaced"}, deletedTokens())
+				require.Contains(t, ui.OutputWriter.String(), "Deleted 2 orphaned tokens of auth method k8s")
+			}
+		})
+	}
+}
+
+// Test that the command fails if a pod can't be checked, but still cleans
+// up the other tokens.
+func TestRun_PodLookupError(t *testing.T) {
+	t.Parallel()
+	tokens := []*api.ACLTokenListEntry{
+		{AccessorID: "forbidden", AuthMethod: "k8s",
+			Description: `token created via login: {"pod":"forbidden/web"}`},
+		{AccessorID: "deleted", AuthMethod: "k8s",
+			Description: `token created via login: {"pod":"default/deleted"}`},
+	}
+	consulServer, deletedTokens := testConsul(t, tokens)
+	defer consulServer.Close()
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "forbidden" {
+			return true, nil, errors.New("forbidden")
+		}
+		return false, nil, nil
+	})
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		consulClient: client,
+		clientset:    clientset,
+	}
+	exitCode := cmd.Run([]string{"-auth-method-name", "k8s"})
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.ErrorWriter.String(), "Failed to clean up 1 tokens")
+	require.Equal(t, []string{"deleted"}, deletedTokens())
+}
+
+func TestTokenPod(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		description  string
+		expNamespace string
+		expName      string
+		expOK        bool
+	}{
+		"pod": {
+			description:  `token created via login: {"pod":"ns/web"}`,
+			expNamespace: "ns",
+			expName:      "web",
+			expOK:        true,
+		},
+		"no meta": {
+			description: "token created via login",
+		},
+		"other meta": {
+			description: `token created via login: {"host":"web"}`,
+		},
+		"invalid pod": {
+			description: `token created via login: {"pod":"web"}`,
+		},
+		"invalid json": {
+			description: `token created via login: pod=ns/web`,
+		},
+		"not a login": {
+			description: `{"pod":"ns/web"}`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			namespace, name, ok := tokenPod(c.description)
+			require.Equal(t, c.expOK, ok)
+			require.Equal(t, c.expNamespace, namespace)
+			require.Equal(t, c.expName, name)
+		})
+	}
+}
+
+// testConsul returns a fake Consul API server that lists tokens and records
+// the tokens that are deleted.
+func testConsul(t *testing.T, tokens []*api.ACLTokenListEntry) (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/acl/tokens":
+			if err := json.NewEncoder(w).Encode(tokens); err != nil {
+				t.Errorf("encoding tokens: %s", err)
+			}
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/acl/token/"):
+			lock.Lock()
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/v1/acl/token/"))
+			lock.Unlock()
+			w.Write([]byte("true"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		result := append([]string{}, deleted...)
+		sort.Strings(result)
+		return result
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/mitchellh/cli"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -29,9 +30,15 @@ type Command struct {
 	flagNamespace     string
 	flagACLDir        string
 	flagTokenSinkFile string
+	flagTimeout       time.Duration
+	flagLogLevel      string
 
 	k8sClient kubernetes.Interface
 
+	// retryInterval is how long to wait between attempts to read the
+	// Secret. It defaults to 1s and is exposed for setting in tests.
+	retryInterval time.Duration
+
 	once sync.Once
 	help string
 }
@@ -48,41 +55,67 @@ func (c *Command) init() {
 		"Directory name of shared volume where client acl config file acl-config.json will be written if -init-type=client")
 	c.flags.StringVar(&c.flagTokenSinkFile, "token-sink-file", "",
 		"Optional filepath to write acl token")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 0,
+		"How long to wait for the secret before exiting with exit code 2, e.g. 1ms, 2s, 3m. "+
+			"If 0, the command waits forever.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
 
 	c.k8s = &flags.K8SFlags{}
 	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
+
+	if c.retryInterval == 0 {
+		c.retryInterval = 1 * time.Second
+	}
 }
 
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		return 1
 	}
 	if len(c.flags.Args()) > 0 {
 		c.UI.Error(fmt.Sprintf("Should have no non-flag arguments."))
 		return 1
 	}
+	if c.flagTimeout < 0 {
+		c.UI.Error("-timeout must not be negative")
+		return 1
+	}
+
+	logger, err := common.Logger(c.flagLogLevel)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	// Create the Kubernetes clientset
 	if c.k8sClient == nil {
-		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
-			return 1
+			return common.LogExit(logger, common.ExitCodeError, err)
 		}
 		c.k8sClient, err = kubernetes.NewForConfig(config)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
-			return 1
+			return common.LogExit(logger, common.ExitCodeError, err)
 		}
 	}
 
 	// Check if the client secret exists yet
 	// If not, wait until it does
+	var deadline <-chan time.Time
+	if c.flagTimeout > 0 {
+		timer := time.NewTimer(c.flagTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
 	var secret string
 	for {
-		var err error
 		secret, err = c.getSecret(c.flagSecretName)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error getting Kubernetes secret: %s", err))
@@ -90,7 +123,12 @@ func (c *Command) Run(args []string) int {
 		if err == nil {
 			break
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-time.After(c.retryInterval):
+		case <-deadline:
+			c.UI.Error(fmt.Sprintf("Timed out after %s waiting for Kubernetes secret %q", c.flagTimeout, c.flagSecretName))
+			return common.LogExit(logger, common.ExitCodeTimeout, err)
+		}
 	}
 
 	if c.flagInitType == "client" {
@@ -101,7 +139,7 @@ func (c *Command) Run(args []string) int {
 		err := tpl.Execute(&buf, secret)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error creating template: %s", err))
-			return 1
+			return common.LogExit(logger, common.ExitCodeError, err)
 		}
 
 		// Write the data out as a file.
@@ -110,7 +148,7 @@ func (c *Command) Run(args []string) int {
 		err = ioutil.WriteFile(filepath.Join(c.flagACLDir, "acl-config.json"), buf.Bytes(), 0644)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error writing config file: %s", err))
-			return 1
+			return common.LogExit(logger, common.ExitCodeError, err)
 		}
 	}
 
@@ -120,11 +158,11 @@ func (c *Command) Run(args []string) int {
 		err := ioutil.WriteFile(c.flagTokenSinkFile, []byte(secret), 0600)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error writing token to file %q: %s", c.flagTokenSinkFile, err))
-			return 1
+			return common.LogExit(logger, common.ExitCodeError, err)
 		}
 	}
 
-	return 0
+	return common.LogExit(logger, 0, nil)
 }
 
 func (c *Command) getSecret(secretName string) (string, error) {
@@ -150,6 +188,13 @@ Usage: consul-k8s acl-init [options]
   Bootstraps non-server components with ACLs by waiting for a
   secret to be populated with an ACL token to be used.
 
+  The command exits with one of the following codes and logs a final
+  "exiting" line with the exit_code and reason:
+
+    0  The ACL token was written.
+    1  Invalid flags or any other error.
+    2  Timed out after -timeout waiting for the secret.
+
 `
 
 const clientACLConfigTpl = `
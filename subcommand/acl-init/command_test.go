@@ -6,7 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
@@ -149,3 +151,22 @@ func TestRun_TokenSinkFileTwice(t *testing.T) {
 		require.Equal(token, string(bytes), "exp: %s, got: %s", token, string(bytes))
 	}
 }
+
+// Test that the command exits with the timeout exit code if the secret
+// doesn't exist within -timeout.
+func TestRun_Timeout(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		k8sClient:     fake.NewSimpleClientset(),
+		retryInterval: 10 * time.Millisecond,
+	}
+	code := cmd.Run([]string{
+		"-k8s-namespace", "default",
+		"-secret-name", "secret-name",
+		"-timeout", "100ms",
+	})
+	require.Equal(t, common.ExitCodeTimeout, code)
+	require.Contains(t, ui.ErrorWriter.String(), `Timed out after 100ms waiting for Kubernetes secret "secret-name"`)
+}
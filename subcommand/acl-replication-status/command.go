@@ -0,0 +1,240 @@
+package aclreplicationstatus
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Command is the command for checking the health of ACL replication in a
+// secondary datacenter.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	http  *flags.HTTPFlags
+
+	flagMaxLag        time.Duration
+	flagMetricsListen string
+	flagPollInterval  time.Duration
+	flagLogLevel      string
+
+	consulClient *api.Client
+
+	// now returns the current time. It is exposed for setting in tests.
+	now func() time.Time
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.DurationVar(&c.flagMaxLag, "max-lag", 10*time.Minute,
+		"Maximum time since the last successful replication round before replication is considered unhealthy. "+
+			"Replication rounds block until the primary datacenter changes, for up to 5m, so this should be longer than 5m.")
+	c.flags.StringVar(&c.flagMetricsListen, "metrics-listen", "",
+		"Address to serve Prometheus metrics on, e.g. :9102. If set, the command keeps running and "+
+			"polls the replication status every -poll-interval instead of checking it once.")
+	c.flags.DurationVar(&c.flagPollInterval, "poll-interval", 30*time.Second,
+		"How often to poll the replication status when -metrics-listen is set.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	if c.now == nil {
+		c.now = time.Now
+	}
+
+	// Wait on an interrupt or terminate to exit. This channel must be initialized before
+	// Run() is called so that there are no race conditions where the channel
+	// is not defined.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagMaxLag <= 0 {
+		c.UI.Error("-max-lag must be greater than 0")
+		return 1
+	}
+	if c.flagMetricsListen != "" && c.flagPollInterval <= 0 {
+		c.UI.Error("-poll-interval must be greater than 0")
+		return 1
+	}
+
+	logger, err := common.Logger(c.flagLogLevel)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.consulClient == nil {
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagMetricsListen != "" {
+		return c.monitor(logger)
+	}
+
+	status, _, err := c.consulClient.ACL().Replication(nil)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading ACL replication status: %s", err))
+		return 1
+	}
+	now := c.now()
+	c.UI.Output(fmt.Sprintf("Enabled:            %t", status.Enabled))
+	c.UI.Output(fmt.Sprintf("Running:            %t", status.Running))
+	c.UI.Output(fmt.Sprintf("Source datacenter:  %s", status.SourceDatacenter))
+	c.UI.Output(fmt.Sprintf("Replication type:   %s", status.ReplicationType))
+	c.UI.Output(fmt.Sprintf("Replicated indexes: policies=%d roles=%d tokens=%d",
+		status.ReplicatedIndex, status.ReplicatedRoleIndex, status.ReplicatedTokenIndex))
+	c.UI.Output(fmt.Sprintf("Last success:       %s", formatTime(status.LastSuccess, now)))
+	c.UI.Output(fmt.Sprintf("Last error:         %s", formatTime(status.LastError, now)))
+
+	problems := checkStatus(status, now, c.flagMaxLag)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			c.UI.Error(fmt.Sprintf("UNHEALTHY  %s", problem))
+		}
+		return 1
+	}
+	c.UI.Info("ACL replication is healthy")
+	return 0
+}
+
+// monitor serves the replication status as Prometheus metrics, refreshing
+// it every poll interval until the command is interrupted.
+func (c *Command) monitor(logger hclog.Logger) int {
+	registry := prometheus.NewRegistry()
+	metrics := newMetrics(registry)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:    c.flagMetricsListen,
+		Handler: mux,
+	}
+	serverErrors := make(chan error, 1)
+	go func() {
+		c.UI.Info(fmt.Sprintf("Serving metrics on %q...", c.flagMetricsListen))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErrors <- err
+		}
+	}()
+	defer server.Close()
+
+	for {
+		status, _, err := c.consulClient.ACL().Replication(nil)
+		if err != nil {
+			logger.Error("reading ACL replication status", "err", err)
+			metrics.statusErrors.Inc()
+		} else {
+			problems := checkStatus(status, c.now(), c.flagMaxLag)
+			metrics.update(status, c.now(), len(problems) == 0)
+			for _, problem := range problems {
+				logger.Warn("ACL replication is unhealthy", "problem", problem)
+			}
+		}
+
+		select {
+		case <-time.After(c.flagPollInterval):
+		case err := <-serverErrors:
+			c.UI.Error(fmt.Sprintf("Error listening for metrics: %s", err))
+			return 1
+		case sig := <-c.sigCh:
+			logger.Info(fmt.Sprintf("%s received, shutting down", sig))
+			return 0
+		}
+	}
+}
+
+// checkStatus returns the reasons replication is unhealthy, if any.
+func checkStatus(status *api.ACLReplicationStatus, now time.Time, maxLag time.Duration) []string {
+	if !status.Enabled {
+		return []string{"ACL replication is not enabled, it is only enabled in secondary datacenters"}
+	}
+	var problems []string
+	if !status.Running {
+		problems = append(problems, "ACL replication is not running, the replication token may be missing "+
+			"or this server may not be the leader")
+	}
+	if !status.LastError.IsZero() && status.LastError.After(status.LastSuccess) {
+		problems = append(problems, fmt.Sprintf("the last replication round failed at %s, check the server logs and "+
+			"that the replication token is valid in the primary datacenter", status.LastError.UTC().Format(time.RFC3339)))
+	}
+	if status.LastSuccess.IsZero() {
+		problems = append(problems, "ACL replication has never succeeded")
+	} else if lag := now.Sub(status.LastSuccess); lag > maxLag {
+		problems = append(problems, fmt.Sprintf("the last successful replication round was %s ago, more than -max-lag of %s",
+			lag.Round(time.Second), maxLag))
+	}
+	return problems
+}
+
+func formatTime(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.UTC().Format(time.RFC3339), now.Sub(t).Round(time.Second))
+}
+
+func (c *Command) interrupt() {
+	c.sigCh <- syscall.SIGINT
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Check the health of ACL replication in a secondary datacenter."
+const help = `
+Usage: consul-k8s acl-replication status [options]
+
+  Reads the ACL replication status of a secondary datacenter and reports
+  whether replication is running, when it last succeeded and whether the
+  last round failed. The command exits non-zero if replication is unhealthy,
+  which usually means the replication token is missing, has been deleted
+  or lacks permissions in the primary datacenter. Logins in the secondary
+  datacenter start failing once the replicated tokens and policies are
+  out of date.
+
+  If -metrics-listen is set, the command keeps running and serves the
+  status as Prometheus metrics instead, for alerting on broken
+  replication.
+`
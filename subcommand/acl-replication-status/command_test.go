@@ -0,0 +1,211 @@
+package aclreplicationstatus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/freeport"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"-max-lag", "0s"},
+			expErr: "-max-lag must be greater than 0",
+		},
+		{
+			flags:  []string{"-metrics-listen", ":9102", "-poll-interval", "0s"},
+			expErr: "-poll-interval must be greater than 0",
+		},
+		{
+			flags:  []string{"-log-level", "invalid"},
+			expErr: "unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_Status(t *testing.T) {
+	t.Parallel()
+	healthy := api.ACLReplicationStatus{
+		Enabled:              true,
+		Running:              true,
+		SourceDatacenter:     "dc1",
+		ReplicationType:      "tokens",
+		ReplicatedIndex:      10,
+		ReplicatedRoleIndex:  11,
+		ReplicatedTokenIndex: 12,
+		LastSuccess:          now.Add(-time.Minute),
+		LastError:            now.Add(-time.Hour),
+	}
+
+	cases := map[string]struct {
+		modify    func(*api.ACLReplicationStatus)
+		expErrors []string
+	}{
+		"healthy": {
+			modify: func(*api.ACLReplicationStatus) {},
+		},
+		"not enabled": {
+			modify: func(s *api.ACLReplicationStatus) {
+				*s = api.ACLReplicationStatus{}
+			},
+			expErrors: []string{"ACL replication is not enabled"},
+		},
+		"not running and failing": {
+			modify: func(s *api.ACLReplicationStatus) {
+				s.Running = false
+				s.LastError = now.Add(-30 * time.Second)
+			},
+			expErrors: []string{
+				"ACL replication is not running",
+				"the last replication round failed at 2021-02-01T11:59:30Z",
+			},
+		},
+		"lagging": {
+			modify: func(s *api.ACLReplicationStatus) {
+				s.LastSuccess = now.Add(-20 * time.Minute)
+			},
+			expErrors: []string{"the last successful replication round was 20m0s ago, more than -max-lag of 10m0s"},
+		},
+		"never succeeded": {
+			modify: func(s *api.ACLReplicationStatus) {
+				s.LastSuccess = time.Time{}
+			},
+			expErrors: []string{"ACL replication has never succeeded"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			status := healthy
+			c.modify(&status)
+			consul := newFakeConsul(t, status)
+
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, consulClient: consul.client(t), now: func() time.Time { return now }}
+			exitCode := cmd.Run(nil)
+			if len(c.expErrors) == 0 {
+				require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+				require.Contains(t, ui.OutputWriter.String(), "ACL replication is healthy")
+				require.Contains(t, ui.OutputWriter.String(), "Last success:       2021-02-01T11:59:00Z (1m0s ago)")
+				return
+			}
+			require.Equal(t, 1, exitCode)
+			for _, expErr := range c.expErrors {
+				require.Contains(t, ui.ErrorWriter.String(), expErr)
+			}
+		})
+	}
+}
+
+// Test that metrics are served and updated when the status changes.
+func TestRun_Metrics(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(t, api.ACLReplicationStatus{
+		Enabled:              true,
+		Running:              true,
+		ReplicatedTokenIndex: 12,
+		LastSuccess:          now.Add(-time.Minute),
+	})
+
+	port := freeport.MustTake(1)[0]
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consul.client(t), now: func() time.Time { return now }}
+	cmd.init()
+	exitChan := make(chan int, 1)
+	go func() {
+		exitChan <- cmd.Run([]string{"-metrics-listen", fmt.Sprintf("127.0.0.1:%d", port), "-poll-interval", "50ms"})
+	}()
+	defer func() {
+		cmd.interrupt()
+		select {
+		case exitCode := <-exitChan:
+			require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+		case <-time.After(time.Second):
+			require.Fail(t, "timeout waiting for command to exit")
+		}
+	}()
+
+	scrape := func(r *retry.R) string {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", port))
+		require.NoError(r, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(r, err)
+		return string(body)
+	}
+	retry.Run(t, func(r *retry.R) {
+		body := scrape(r)
+		require.Contains(r, body, "consul_k8s_acl_replication_healthy 1")
+		require.Contains(r, body, "consul_k8s_acl_replication_lag_seconds 60")
+		require.Contains(r, body, `consul_k8s_acl_replication_replicated_index{type="tokens"} 12`)
+	})
+
+	consul.set(api.ACLReplicationStatus{Enabled: true, LastSuccess: now.Add(-time.Minute), LastError: now})
+	retry.Run(t, func(r *retry.R) {
+		body := scrape(r)
+		require.Contains(r, body, "consul_k8s_acl_replication_healthy 0")
+		require.Contains(r, body, "consul_k8s_acl_replication_running 0")
+		require.Contains(r, body, fmt.Sprintf("consul_k8s_acl_replication_last_error_timestamp_seconds %g", float64(now.Unix())))
+	})
+}
+
+// fakeConsul serves the ACL replication status.
+type fakeConsul struct {
+	lock   sync.Mutex
+	status api.ACLReplicationStatus
+	server *httptest.Server
+}
+
+func newFakeConsul(t *testing.T, status api.ACLReplicationStatus) *fakeConsul {
+	f := &fakeConsul{status: status}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/acl/replication" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		require.NoError(t, json.NewEncoder(w).Encode(f.status))
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeConsul) set(status api.ACLReplicationStatus) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.status = status
+}
+
+func (f *fakeConsul) client(t *testing.T) *api.Client {
+	client, err := api.NewClient(&api.Config{Address: f.server.URL})
+	require.NoError(t, err)
+	return client
+}
@@ -0,0 +1,79 @@
+package aclreplicationstatus
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsPrefix = "consul_k8s_acl_replication_"
+
+// metrics are the Prometheus metrics describing the replication status.
+type metrics struct {
+	enabled         prometheus.Gauge
+	running         prometheus.Gauge
+	healthy         prometheus.Gauge
+	lastSuccess     prometheus.Gauge
+	lastError       prometheus.Gauge
+	lag             prometheus.Gauge
+	replicatedIndex *prometheus.GaugeVec
+	statusErrors    prometheus.Counter
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	gauge := func(name, help string) prometheus.Gauge {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: metricsPrefix + name, Help: help})
+		reg.MustRegister(g)
+		return g
+	}
+	m := &metrics{
+		enabled: gauge("enabled", "Whether ACL replication is enabled (1) or not (0)."),
+		running: gauge("running", "Whether ACL replication is running (1) or not (0)."),
+		healthy: gauge("healthy", "Whether ACL replication is healthy (1) or not (0) according to the "+
+			"same checks as the acl-replication status command."),
+		lastSuccess: gauge("last_success_timestamp_seconds", "Unix time of the last successful replication round, 0 if it never succeeded."),
+		lastError:   gauge("last_error_timestamp_seconds", "Unix time of the last failed replication round, 0 if it never failed."),
+		lag:         gauge("lag_seconds", "Seconds since the last successful replication round when the status was last read."),
+		replicatedIndex: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricsPrefix + "replicated_index",
+			Help: "The index of the primary datacenter's ACL data that was last replicated.",
+		}, []string{"type"}),
+		statusErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricsPrefix + "status_errors_total",
+			Help: "Number of times reading the ACL replication status failed.",
+		}),
+	}
+	reg.MustRegister(m.replicatedIndex, m.statusErrors)
+	return m
+}
+
+func (m *metrics) update(status *api.ACLReplicationStatus, now time.Time, healthy bool) {
+	m.enabled.Set(boolValue(status.Enabled))
+	m.running.Set(boolValue(status.Running))
+	m.healthy.Set(boolValue(healthy))
+	m.lastSuccess.Set(timestamp(status.LastSuccess))
+	m.lastError.Set(timestamp(status.LastError))
+	if status.LastSuccess.IsZero() {
+		m.lag.Set(0)
+	} else {
+		m.lag.Set(now.Sub(status.LastSuccess).Seconds())
+	}
+	m.replicatedIndex.WithLabelValues("policies").Set(float64(status.ReplicatedIndex))
+	m.replicatedIndex.WithLabelValues("roles").Set(float64(status.ReplicatedRoleIndex))
+	m.replicatedIndex.WithLabelValues("tokens").Set(float64(status.ReplicatedTokenIndex))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func timestamp(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
@@ -13,7 +13,9 @@ import (
 // K8SConfig returns a *restclient.Config for initializing a K8S client.
 // This configuration first attempts to load a local kubeconfig if a
 // path is given. If that doesn't work, then in-cluster auth is used.
-func K8SConfig(path string) (*rest.Config, error) {
+// If context is set, it's used instead of the kubeconfig's current
+// context and in-cluster auth isn't tried since it has no contexts.
+func K8SConfig(path, context string) (*rest.Config, error) {
 	// Get the configuration. This can come from multiple sources. We first
 	// try kubeconfig it is set directly, then we fall back to in-cluster
 	// auth. Finally, we try the default kubeconfig path.
@@ -30,9 +32,14 @@ func K8SConfig(path string) (*rest.Config, error) {
 	}
 
 	// First try to get the configuration from the kubeconfig value
-	config, configErr := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	config, configErr := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
 	if configErr != nil {
 		configErr = fmt.Errorf("error loading kubeconfig: %s", configErr)
+		if context != "" {
+			return nil, configErr
+		}
 
 		// kubeconfig failed, fall back and try in-cluster config. We do
 		// this as the fallback since this makes network connections and
@@ -0,0 +1,368 @@
+package benchinject
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Command replays synthetic AdmissionReview requests against a running
+// connect injector and reports on latency and error rates.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+
+	flagAddr               string
+	flagRequests           int
+	flagConcurrency        int
+	flagTimeout            time.Duration
+	flagCAFile             string
+	flagInsecureSkipVerify bool
+	flagPodFile            string
+	flagNamespace          string
+	flagMaxErrorRate       float64
+
+	once sync.Once
+	help string
+
+	// httpClient may be set in tests.
+	httpClient *http.Client
+}
+
+// result is the outcome of a single admission request.
+type result struct {
+	duration time.Duration
+	err      error
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagAddr, "addr", "",
+		"The URL of the injector's mutate endpoint, e.g. https://127.0.0.1:8080/mutate. This value is required.")
+	c.flags.IntVar(&c.flagRequests, "requests", 1000,
+		"Total number of admission requests to send.")
+	c.flags.IntVar(&c.flagConcurrency, "concurrency", 10,
+		"Number of requests to have in flight at the same time.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Second,
+		"Timeout for each individual admission request.")
+	c.flags.StringVar(&c.flagCAFile, "ca-file", "",
+		"Path to a PEM-encoded CA certificate used to verify the injector's TLS certificate.")
+	c.flags.BoolVar(&c.flagInsecureSkipVerify, "tls-skip-verify", false,
+		"Skip verification of the injector's TLS certificate.")
+	c.flags.StringVar(&c.flagPodFile, "pod-file", "",
+		"Path to a JSON pod manifest to embed in each request. If unset, a minimal "+
+			"pod with the connect-inject annotation is used.")
+	c.flags.StringVar(&c.flagNamespace, "k8s-namespace", "default",
+		"Kubernetes namespace to set on the synthetic admission requests.")
+	c.flags.Float64Var(&c.flagMaxErrorRate, "max-error-rate", 0,
+		"Maximum percentage of requests, from 0 to 100, that may fail before the "+
+			"command exits with a non-zero status.")
+
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagAddr == "" {
+		c.UI.Error("-addr must be set")
+		return 1
+	}
+	if c.flagRequests <= 0 {
+		c.UI.Error("-requests must be greater than 0")
+		return 1
+	}
+	if c.flagConcurrency <= 0 {
+		c.UI.Error("-concurrency must be greater than 0")
+		return 1
+	}
+	if c.flagMaxErrorRate < 0 || c.flagMaxErrorRate > 100 {
+		c.UI.Error("-max-error-rate must be between 0 and 100")
+		return 1
+	}
+
+	pod, err := c.pod()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading pod: %s", err))
+		return 1
+	}
+	// Marshal the pod once up front so the cost of encoding it isn't
+	// included in the latencies we're measuring.
+	rawPod, err := json.Marshal(pod)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error encoding pod: %s", err))
+		return 1
+	}
+
+	if c.httpClient == nil {
+		c.httpClient, err = c.newHTTPClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating HTTP client: %s", err))
+			return 1
+		}
+	}
+
+	c.UI.Info(fmt.Sprintf("Sending %d requests to %q with concurrency %d...", c.flagRequests, c.flagAddr, c.flagConcurrency))
+
+	// Distribute the requests to the workers over a channel so that each
+	// worker always has work as long as there are requests left.
+	work := make(chan int, c.flagRequests)
+	for i := 0; i < c.flagRequests; i++ {
+		work <- i
+	}
+	close(work)
+
+	results := make([]result, c.flagRequests)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < c.flagConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range work {
+				results[n] = c.send(n, rawPod)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	c.UI.Output(report(results, elapsed))
+
+	if rate := errorRate(results); rate > c.flagMaxErrorRate {
+		c.UI.Error(fmt.Sprintf("Error rate of %.2f%% exceeds -max-error-rate of %.2f%%", rate, c.flagMaxErrorRate))
+		return 1
+	}
+	return 0
+}
+
+// send sends one AdmissionReview for the given JSON-encoded pod and times
+// the round trip.
+func (c *Command) send(n int, rawPod []byte) result {
+	review := v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1beta1",
+		},
+		Request: &v1beta1.AdmissionRequest{
+			UID:       types.UID(fmt.Sprintf("bench-inject-%d", n)),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Namespace: c.flagNamespace,
+			Operation: v1beta1.Create,
+		},
+	}
+	review.Request.Object = runtime.RawExtension{Raw: rawPod}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return result{err: err}
+	}
+
+	req, err := http.NewRequest("POST", c.flagAddr, bytes.NewReader(body))
+	if err != nil {
+		return result{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return result{duration: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	duration := time.Since(start)
+	if err != nil {
+		return result{duration: duration, err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return result{duration: duration, err: fmt.Errorf("unexpected status code %d", resp.StatusCode)}
+	}
+
+	var admResp v1beta1.AdmissionReview
+	if err := json.Unmarshal(respBody, &admResp); err != nil {
+		return result{duration: duration, err: fmt.Errorf("decoding response: %s", err)}
+	}
+	if admResp.Response == nil {
+		return result{duration: duration, err: fmt.Errorf("response is empty")}
+	}
+	if !admResp.Response.Allowed {
+		msg := "request not allowed"
+		if admResp.Response.Result != nil && admResp.Response.Result.Message != "" {
+			msg = admResp.Response.Result.Message
+		}
+		return result{duration: duration, err: errors.New(msg)}
+	}
+	return result{duration: duration}
+}
+
+// pod returns the pod to embed in each admission request.
+func (c *Command) pod() (corev1.Pod, error) {
+	var pod corev1.Pod
+	if c.flagPodFile == "" {
+		pod = corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "bench-inject",
+				Namespace: c.flagNamespace,
+				Annotations: map[string]string{
+					"consul.hashicorp.com/connect-inject": "true",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "bench-inject",
+						Image: "bench-inject",
+						Ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+					},
+				},
+			},
+		}
+		return pod, nil
+	}
+
+	data, err := ioutil.ReadFile(c.flagPodFile)
+	if err != nil {
+		return pod, err
+	}
+	err = json.Unmarshal(data, &pod)
+	return pod, err
+}
+
+func (c *Command) newHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.flagInsecureSkipVerify}
+	if c.flagCAFile != "" {
+		caCert, err := ioutil.ReadFile(c.flagCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %q", c.flagCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Timeout: c.flagTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: c.flagConcurrency,
+		},
+	}, nil
+}
+
+// report formats a human-readable summary of the results.
+func report(results []result, elapsed time.Duration) string {
+	var durations []time.Duration
+	errCounts := make(map[string]int)
+	var errCount int
+	for _, r := range results {
+		if r.err != nil {
+			errCount++
+			errCounts[r.err.Error()]++
+			continue
+		}
+		durations = append(durations, r.duration)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Requests:    %d\n", len(results))
+	fmt.Fprintf(&buf, "Duration:    %s\n", elapsed)
+	if elapsed > 0 {
+		fmt.Fprintf(&buf, "Throughput:  %.2f req/s\n", float64(len(results))/elapsed.Seconds())
+	}
+	fmt.Fprintf(&buf, "Errors:      %d (%.2f%%)\n", errCount, errorRate(results))
+	if len(durations) > 0 {
+		fmt.Fprintf(&buf, "Latency p50: %s\n", percentile(durations, 50))
+		fmt.Fprintf(&buf, "Latency p90: %s\n", percentile(durations, 90))
+		fmt.Fprintf(&buf, "Latency p99: %s\n", percentile(durations, 99))
+		fmt.Fprintf(&buf, "Latency max: %s\n", durations[len(durations)-1])
+	}
+
+	if len(errCounts) > 0 {
+		var msgs []string
+		for msg := range errCounts {
+			msgs = append(msgs, msg)
+		}
+		sort.Strings(msgs)
+		buf.WriteString("\nErrors by message:\n")
+		for _, msg := range msgs {
+			fmt.Fprintf(&buf, "  %d: %s\n", errCounts[msg], msg)
+		}
+	}
+
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// errorRate returns the percentage of results that failed.
+func errorRate(results []result) float64 {
+	if len(results) == 0 {
+		return 0
+	}
+	var errCount int
+	for _, r := range results {
+		if r.err != nil {
+			errCount++
+		}
+	}
+	return 100 * float64(errCount) / float64(len(results))
+}
+
+// percentile returns the p-th percentile of the sorted durations using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Load test the connect injector."
+const help = `
+Usage: consul-k8s bench-inject [options]
+
+  Sends synthetic AdmissionReview requests to a running connect injector
+  at the given concurrency and reports latency percentiles and error
+  rates. This is used to validate webhook performance before large-scale
+  rollouts. The command exits with a non-zero status if the error rate
+  exceeds -max-error-rate.
+`
@@ -0,0 +1,293 @@
+package benchinject
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-addr must be set",
+		},
+		{
+			flags:  []string{"-addr=https://127.0.0.1:8080/mutate", "-requests=0"},
+			expErr: "-requests must be greater than 0",
+		},
+		{
+			flags:  []string{"-addr=https://127.0.0.1:8080/mutate", "-concurrency=0"},
+			expErr: "-concurrency must be greater than 0",
+		},
+		{
+			flags:  []string{"-addr=https://127.0.0.1:8080/mutate", "-max-error-rate=101"},
+			expErr: "-max-error-rate must be between 0 and 100",
+		},
+		{
+			flags:  []string{"-addr=https://127.0.0.1:8080/mutate", "-pod-file=/does/not/exist"},
+			expErr: "Error reading pod",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that every request is sent and that rejected requests are reported
+// as errors.
+func TestRun_ReportsLatencyAndErrors(t *testing.T) {
+	t.Parallel()
+	server, reqs := rejectEveryOtherServer(httptest.NewServer)
+	defer server.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:         ui,
+		httpClient: server.Client(),
+	}
+	exitCode := cmd.Run([]string{
+		"-addr", server.URL,
+		"-requests", "10",
+		"-concurrency", "3",
+		"-max-error-rate", "50",
+	})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+	reqs.Lock()
+	require.Len(t, reqs.reviews, 10)
+	for _, review := range reqs.reviews {
+		require.Equal(t, "application/json", review.contentType)
+		require.NoError(t, review.err)
+		require.Equal(t, "default", review.namespace)
+	}
+	reqs.Unlock()
+
+	output := ui.OutputWriter.String()
+	require.Contains(t, output, "Requests:    10")
+	require.Contains(t, output, "Errors:      5 (50.00%)")
+	require.Contains(t, output, "Latency p99:")
+	require.Contains(t, output, "5: injection failed")
+}
+
+// Test that the command exits non-zero when the error rate exceeds
+// -max-error-rate.
+func TestRun_MaxErrorRate(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		flags       []string
+		expExitCode int
+	}{
+		"default fails on any error": {
+			flags:       nil,
+			expExitCode: 1,
+		},
+		"below max error rate": {
+			flags:       []string{"-max-error-rate", "60"},
+			expExitCode: 0,
+		},
+		"above max error rate": {
+			flags:       []string{"-max-error-rate", "40"},
+			expExitCode: 1,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			server, _ := rejectEveryOtherServer(httptest.NewServer)
+			defer server.Close()
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:         ui,
+				httpClient: server.Client(),
+			}
+			exitCode := cmd.Run(append([]string{"-addr", server.URL, "-requests", "10"}, c.flags...))
+			require.Equal(t, c.expExitCode, exitCode, ui.ErrorWriter.String())
+			if c.expExitCode != 0 {
+				require.Contains(t, ui.ErrorWriter.String(), "Error rate of 50.00% exceeds -max-error-rate")
+			}
+		})
+	}
+}
+
+// Test that a connection failure on every request is reported and causes
+// a non-zero exit.
+func TestRun_ConnectionRefused(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.NotFoundHandler())
+	addr := server.URL
+	server.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	exitCode := cmd.Run([]string{"-addr", addr, "-requests", "3", "-timeout", "1s"})
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.OutputWriter.String(), "Errors:      3 (100.00%)")
+}
+
+// Test the TLS options used to build the HTTP client.
+func TestRun_TLS(t *testing.T) {
+	t.Parallel()
+	server, _ := rejectEveryOtherServer(httptest.NewTLSServer)
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "bench-inject-ca")
+	require.NoError(t, err)
+	defer os.Remove(caFile.Name())
+	require.NoError(t, pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	require.NoError(t, caFile.Close())
+
+	invalidCAFile, err := ioutil.TempFile("", "bench-inject-invalid-ca")
+	require.NoError(t, err)
+	defer os.Remove(invalidCAFile.Name())
+	_, err = invalidCAFile.WriteString("not a certificate")
+	require.NoError(t, err)
+	require.NoError(t, invalidCAFile.Close())
+
+	cases := map[string]struct {
+		flags     []string
+		expExit   int
+		expErr    string
+		expOutput string
+	}{
+		"ca-file": {
+			flags:     []string{"-ca-file", caFile.Name()},
+			expOutput: "Errors:      1 (50.00%)",
+		},
+		"tls-skip-verify": {
+			flags:     []string{"-tls-skip-verify"},
+			expOutput: "Errors:      1 (50.00%)",
+		},
+		"unverified certificate": {
+			flags:     nil,
+			expExit:   1,
+			expOutput: "Errors:      2 (100.00%)",
+		},
+		"invalid ca-file": {
+			flags:   []string{"-ca-file", invalidCAFile.Name()},
+			expExit: 1,
+			expErr:  "no certificates found in",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			args := append([]string{
+				"-addr", server.URL,
+				"-requests", "2",
+				"-concurrency", "1",
+				"-timeout", "5s",
+				"-max-error-rate", "50",
+			}, c.flags...)
+			exitCode := cmd.Run(args)
+			require.Equal(t, c.expExit, exitCode, ui.ErrorWriter.String())
+			if c.expErr != "" {
+				require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+			}
+			if c.expOutput != "" {
+				require.Contains(t, ui.OutputWriter.String(), c.expOutput)
+			}
+		})
+	}
+}
+
+// receivedReview records what a test server saw for a single request.
+type receivedReview struct {
+	contentType string
+	namespace   string
+	err         error
+}
+
+type receivedReviews struct {
+	sync.Mutex
+	reviews []receivedReview
+}
+
+// rejectEveryOtherServer starts a server with newServer that allows every
+// odd request and rejects every even one. The requests it receives are
+// recorded so they can be checked from the test goroutine.
+func rejectEveryOtherServer(newServer func(http.Handler) *httptest.Server) (*httptest.Server, *receivedReviews) {
+	reqs := &receivedReviews{}
+	server := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received := receivedReview{contentType: r.Header.Get("Content-Type")}
+		var review v1beta1.AdmissionReview
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &review)
+		}
+		if err == nil && review.Request == nil {
+			err = errors.New("request is empty")
+		}
+		received.err = err
+		if err == nil {
+			received.namespace = review.Request.Namespace
+		}
+
+		reqs.Lock()
+		reqs.reviews = append(reqs.reviews, received)
+		count := len(reqs.reviews)
+		reqs.Unlock()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := v1beta1.AdmissionReview{Response: &v1beta1.AdmissionResponse{UID: review.Request.UID, Allowed: true}}
+		if count%2 == 0 {
+			resp.Response = &v1beta1.AdmissionResponse{Result: &metav1.Status{Message: "injection failed"}}
+		}
+		out, _ := json.Marshal(resp)
+		w.Write(out)
+	}))
+	return server, reqs
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+	var durations []time.Duration
+	for i := 1; i <= 100; i++ {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, 50*time.Millisecond, percentile(durations, 50))
+	require.Equal(t, 90*time.Millisecond, percentile(durations, 90))
+	require.Equal(t, 99*time.Millisecond, percentile(durations, 99))
+	require.Equal(t, time.Millisecond, percentile(durations, 0))
+	require.Equal(t, time.Duration(0), percentile(nil, 50))
+}
+
+func TestReport_AllErrors(t *testing.T) {
+	t.Parallel()
+	output := report([]result{{err: errors.New("boom")}, {err: errors.New("boom")}}, time.Second)
+	require.Contains(t, output, "Errors:      2 (100.00%)")
+	require.NotContains(t, output, "Latency")
+	require.Contains(t, output, "2: boom")
+}
@@ -21,12 +21,30 @@ const (
 
 // Logger returns an hclog instance or an error if level is invalid.
 func Logger(level string) (hclog.Logger, error) {
+	opts, err := loggerOptions(level)
+	if err != nil {
+		return nil, err
+	}
+	return hclog.New(opts), nil
+}
+
+// InterceptLogger is like Logger but returns a logger that sinks can be
+// registered with to also receive its logs.
+func InterceptLogger(level string) (hclog.InterceptLogger, error) {
+	opts, err := loggerOptions(level)
+	if err != nil {
+		return nil, err
+	}
+	return hclog.NewInterceptLogger(opts), nil
+}
+
+func loggerOptions(level string) (*hclog.LoggerOptions, error) {
 	parsedLevel := hclog.LevelFromString(level)
 	if parsedLevel == hclog.NoLevel {
 		return nil, fmt.Errorf("unknown log level: %s", level)
 	}
-	return hclog.New(&hclog.LoggerOptions{
+	return &hclog.LoggerOptions{
 		Level:  parsedLevel,
 		Output: os.Stderr,
-	}), nil
+	}, nil
 }
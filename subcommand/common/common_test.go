@@ -1,6 +1,9 @@
 package common
 
 import (
+	"crypto/x509"
+	"errors"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -17,3 +20,19 @@ func TestLogger(t *testing.T) {
 	require.NotNil(t, lgr)
 	require.True(t, lgr.IsDebug())
 }
+
+func TestInterceptLogger(t *testing.T) {
+	_, err := InterceptLogger("invalid")
+	require.EqualError(t, err, "unknown log level: invalid")
+
+	lgr, err := InterceptLogger("debug")
+	require.NoError(t, err)
+	require.True(t, lgr.IsDebug())
+}
+
+func TestIsTLSVerificationError(t *testing.T) {
+	require.True(t, IsTLSVerificationError(&url.Error{Op: "Get", URL: "https://consul:8501", Err: x509.UnknownAuthorityError{}}))
+	require.True(t, IsTLSVerificationError(x509.HostnameError{Host: "consul"}))
+	require.False(t, IsTLSVerificationError(errors.New("connection refused")))
+	require.False(t, IsTLSVerificationError(nil))
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ConfigEntryKinds are the config entry kinds exported and applied by the
+// config export and config apply commands. They're ordered so that entries
+// are written after the entries they depend on, e.g. a service-router
+// requires the protocol of the service to be set by a service-defaults or
+// proxy-defaults entry.
+var ConfigEntryKinds = []string{
+	api.ProxyDefaults,
+	api.ServiceDefaults,
+	api.ServiceResolver,
+	api.ServiceSplitter,
+	api.ServiceRouter,
+	api.IngressGateway,
+	api.TerminatingGateway,
+	api.ServiceIntentions,
+}
+
+// serverManagedSourceFields are the fields of service-intentions sources
+// that are set by the Consul servers and can't be written back.
+var serverManagedSourceFields = []string{
+	"Precedence",
+	"Type",
+	"LegacyID",
+	"LegacyMeta",
+	"LegacyCreateTime",
+	"LegacyUpdateTime",
+}
+
+// NormalizeConfigEntry returns the JSON representation of entry without the
+// fields that are managed by the Consul servers, such as the raft indexes,
+// so that it can be written to another datacenter or compared with a
+// desired entry.
+func NormalizeConfigEntry(entry api.ConfigEntry) (map[string]interface{}, error) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return nil, err
+	}
+	delete(normalized, "CreateIndex")
+	delete(normalized, "ModifyIndex")
+
+	if entry.GetKind() == api.ServiceIntentions {
+		sources, _ := normalized["Sources"].([]interface{})
+		for _, source := range sources {
+			if s, ok := source.(map[string]interface{}); ok {
+				for _, field := range serverManagedSourceFields {
+					delete(s, field)
+				}
+			}
+		}
+	}
+	return normalized, nil
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+)
+
+// NewConsulClient returns a Consul API client configured from httpFlags and
+// the environment, so that every command talks to Consul the same way:
+//
+//   - The CA and client certificate files are reloaded when they change.
+//   - The -token-file is re-read when it changes.
+//   - The faults of faultFlags are injected, if it isn't nil.
+//   - Each call is bounded by -consul-api-timeout and retried up to
+//     -consul-api-retries times.
+func NewConsulClient(httpFlags *flags.HTTPFlags, faultFlags *flags.FaultFlags) (*api.Client, error) {
+	return NewPartitionConsulClient(httpFlags, faultFlags, "")
+}
+
+// NewPartitionConsulClient returns a Consul API client like NewConsulClient
+// whose calls are scoped to the admin partition partition of Consul
+// Enterprise, unless it's empty or the default partition.
+func NewPartitionConsulClient(httpFlags *flags.HTTPFlags, faultFlags *flags.FaultFlags, partition string) (*api.Client, error) {
+	cfg := api.DefaultConfig()
+	httpFlags.MergeOntoConfig(cfg)
+	if err := consul.SetTLSReload(cfg); err != nil {
+		return nil, fmt.Errorf("configuring TLS: %s", err)
+	}
+	if faultFlags != nil {
+		if err := faultFlags.MergeOntoConfig(cfg); err != nil {
+			return nil, fmt.Errorf("configuring fault injection: %s", err)
+		}
+	}
+	if err := consul.SetPartition(cfg, partition); err != nil {
+		return nil, fmt.Errorf("configuring admin partition: %s", err)
+	}
+	if err := httpFlags.MergeTimeoutOntoConfig(cfg); err != nil {
+		return nil, fmt.Errorf("configuring Consul API timeout: %s", err)
+	}
+	if err := httpFlags.MergeRetriesOntoConfig(cfg); err != nil {
+		return nil, fmt.Errorf("configuring Consul API retries: %s", err)
+	}
+	if err := consul.SetTokenFile(cfg); err != nil {
+		return nil, fmt.Errorf("configuring ACL token: %s", err)
+	}
+	return consul.NewClient(cfg)
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the client is configured from the flags, with a failed call
+// retried and sent with the token of the -token-file.
+func TestNewConsulClient(t *testing.T) {
+	var requests int32
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first call so that it's retried.
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%q", r.Header.Get("X-Consul-Token"))
+	}))
+	defer consulServer.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("token"), 0600))
+
+	httpFlags, faultFlags := parseClientFlags(t,
+		"-http-addr", consulServer.URL,
+		"-token-file", tokenFile,
+		"-consul-api-timeout", "10s",
+		"-consul-api-retries", "1",
+		"-fault-consul-delay", "1ms",
+		"-fault-consul-delay-percent", "100",
+	)
+	client, err := NewConsulClient(httpFlags, faultFlags)
+	require.NoError(t, err)
+
+	// The leader endpoint echoes the token here.
+	token, err := client.Status().Leader()
+	require.NoError(t, err)
+	require.Equal(t, "token", token)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+// Test that the calls of a partition's client are scoped to it, including
+// the calls that are retried.
+func TestNewPartitionConsulClient(t *testing.T) {
+	var requests int32
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%q", r.URL.Query().Get("partition"))
+	}))
+	defer consulServer.Close()
+
+	httpFlags, _ := parseClientFlags(t,
+		"-http-addr", consulServer.URL,
+		"-consul-api-timeout", "10s",
+		"-consul-api-retries", "1",
+	)
+	client, err := NewPartitionConsulClient(httpFlags, nil, "foo")
+	require.NoError(t, err)
+
+	// The leader endpoint echoes the partition here.
+	partition, err := client.Status().Leader()
+	require.NoError(t, err)
+	require.Equal(t, "foo", partition)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestNewConsulClient_Errors(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		expErr string
+	}{
+		"negative timeout": {
+			args:   []string{"-consul-api-timeout", "-1s"},
+			expErr: "configuring Consul API timeout: -consul-api-timeout must not be negative",
+		},
+		"negative retries": {
+			args:   []string{"-consul-api-retries", "-1"},
+			expErr: "configuring Consul API retries: -consul-api-retries must not be negative",
+		},
+		"missing CA file": {
+			args:   []string{"-ca-file", "/does/not/exist.pem"},
+			expErr: "configuring TLS: ",
+		},
+		"missing token file": {
+			args:   []string{"-token-file", "/does/not/exist"},
+			expErr: "configuring ACL token: reading token file: ",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			httpFlags, _ := parseClientFlags(t, c.args...)
+			_, err := NewConsulClient(httpFlags, nil)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expErr)
+		})
+	}
+}
+
+func parseClientFlags(t *testing.T, args ...string) (*flags.HTTPFlags, *flags.FaultFlags) {
+	httpFlags := &flags.HTTPFlags{}
+	faultFlags := &flags.FaultFlags{}
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	flags.Merge(fs, httpFlags.Flags())
+	flags.Merge(fs, faultFlags.Flags())
+	require.NoError(t, fs.Parse(args))
+	return httpFlags, faultFlags
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Exit codes returned by the init commands, acl-init and
+// get-consul-client-ca, so that automation can react to specific failure
+// modes. Any other failure, such as invalid flags, exits with
+// ExitCodeError.
+const (
+	// ExitCodeError is returned for errors that don't fall into one of
+	// the categories below.
+	ExitCodeError = 1
+
+	// ExitCodeTimeout is returned when the command timed out waiting for
+	// the resource it needs, e.g. the ACL token Secret or the Consul CA.
+	ExitCodeTimeout = 2
+
+	// ExitCodeTLSVerificationFailed is returned when the Consul server's
+	// certificate couldn't be verified, usually because the CA file is
+	// wrong or the server name doesn't match the certificate. Retrying
+	// won't help since the CA file is only read once.
+	ExitCodeTLSVerificationFailed = 3
+)
+
+// exitReasons are the values of the reason field of the final log line for
+// each exit code.
+var exitReasons = map[int]string{
+	0:                             "success",
+	ExitCodeError:                 "error",
+	ExitCodeTimeout:               "timeout",
+	ExitCodeTLSVerificationFailed: "tls-verification-failed",
+}
+
+// LogExit logs the final line of a command with its exit code, a reason
+// describing the exit code and err if not nil, and returns code.
+func LogExit(logger hclog.Logger, code int, err error) int {
+	args := []interface{}{"exit_code", code, "reason", exitReasons[code]}
+	if err != nil {
+		args = append(args, "err", err)
+		logger.Error("exiting", args...)
+	} else {
+		logger.Info("exiting", args...)
+	}
+	return code
+}
+
+// IsTLSVerificationError returns true if err was caused by the server's
+// certificate failing verification.
+func IsTLSVerificationError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
@@ -0,0 +1,347 @@
+package configapply
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Command is the command for applying a directory of config entries.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	http  *flags.HTTPFlags
+
+	flagDir    string
+	flagDryRun bool
+
+	consulClient *api.Client
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagDir, "dir", "",
+		"Directory of JSON files to apply, as written by the config export command. Each file may hold "+
+			"a single config entry or a list of config entries. This value is required.")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"Print the changes that would be made without applying them.")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+// change is a config entry that differs from the one in Consul.
+type change struct {
+	entry    api.ConfigEntry
+	file     string
+	existing api.ConfigEntry
+	diff     string
+}
+
+func (ch change) String() string {
+	return entryID(ch.entry)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagDir == "" {
+		c.UI.Error("-dir must be set")
+		return 1
+	}
+
+	// Every file is read before anything is written so that a malformed
+	// file doesn't leave Consul partially updated.
+	entries, files, err := readDir(c.flagDir)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading config entries: %s", err))
+		return 1
+	}
+
+	if c.consulClient == nil {
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	changes, err := c.plan(entries, files)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error comparing config entries with Consul: %s", err))
+		return 1
+	}
+	if len(changes) == 0 {
+		c.UI.Output(fmt.Sprintf("All %d config entries are up to date", len(entries)))
+		return 0
+	}
+	for _, ch := range changes {
+		if ch.existing == nil {
+			c.UI.Output(fmt.Sprintf("+ %s will be created", ch))
+		} else {
+			c.UI.Output(fmt.Sprintf("~ %s will be updated", ch))
+		}
+		c.UI.Output(ch.diff)
+	}
+	c.UI.Output(fmt.Sprintf("%d to create or update, %d unchanged", len(changes), len(entries)-len(changes)))
+	if c.flagDryRun {
+		return 0
+	}
+
+	if err := c.apply(changes); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	c.UI.Info(fmt.Sprintf("Applied %d config entries", len(changes)))
+	return 0
+}
+
+// plan compares entries with Consul and returns those that need to be
+// written, in the order they should be written in.
+func (c *Command) plan(entries []api.ConfigEntry, files []string) ([]change, error) {
+	var changes []change
+	for i, entry := range entries {
+		existing, _, err := c.consulClient.ConfigEntries().Get(entry.GetKind(), entry.GetName(), &api.QueryOptions{Namespace: entry.GetNamespace()})
+		if err != nil && !isNotFound(err) {
+			return nil, fmt.Errorf("reading %s: %s", entryID(entry), err)
+		}
+
+		desired, err := common.NormalizeConfigEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		var current map[string]interface{}
+		if existing != nil {
+			if current, err = common.NormalizeConfigEntry(existing); err != nil {
+				return nil, err
+			}
+			if reflect.DeepEqual(desired, current) {
+				continue
+			}
+		}
+
+		diff, err := unifiedDiff(current, desired, files[i])
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change{entry: entry, file: files[i], existing: existing, diff: diff})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return kindOrder(changes[i].entry.GetKind()) < kindOrder(changes[j].entry.GetKind())
+	})
+	return changes, nil
+}
+
+// apply writes changes and rolls back the ones already written if a write
+// fails, so that either all of the changes are applied or none of them are.
+// Each write only succeeds if the entry hasn't been modified since the plan
+// was made.
+func (c *Command) apply(changes []change) error {
+	for i, ch := range changes {
+		var index uint64
+		if ch.existing != nil {
+			index = ch.existing.GetModifyIndex()
+		}
+		ok, _, err := c.consulClient.ConfigEntries().CAS(ch.entry, index, &api.WriteOptions{Namespace: ch.entry.GetNamespace()})
+		if err == nil && !ok {
+			err = errors.New("it was modified in Consul since its changes were computed")
+		}
+		if err != nil {
+			msg := fmt.Sprintf("Error applying %s from %s: %s", ch, ch.file, err)
+			if rollbackErr := c.rollback(changes[:i]); rollbackErr != nil {
+				return fmt.Errorf("%s\nError rolling back applied changes, config entries may be partially applied: %s", msg, rollbackErr)
+			}
+			return fmt.Errorf("%s\nRolled back %d applied changes", msg, i)
+		}
+	}
+	return nil
+}
+
+// rollback restores the config entries that applied changes replaced and
+// deletes the ones they created, in reverse order.
+func (c *Command) rollback(applied []change) error {
+	var errs []string
+	for i := len(applied) - 1; i >= 0; i-- {
+		ch := applied[i]
+		opts := &api.WriteOptions{Namespace: ch.entry.GetNamespace()}
+		var err error
+		if ch.existing == nil {
+			_, err = c.consulClient.ConfigEntries().Delete(ch.entry.GetKind(), ch.entry.GetName(), opts)
+		} else {
+			_, _, err = c.consulClient.ConfigEntries().Set(ch.existing, opts)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", ch, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// readDir decodes the config entries in the JSON files of dir and returns
+// them with the name of the file each one was read from.
+func readDir(dir string) ([]api.ConfigEntry, []string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no .json files found in %s", dir)
+	}
+
+	var entries []api.ConfigEntry
+	var files []string
+	seen := make(map[string]string)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileEntries, err := decodeEntries(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", path, err)
+		}
+		for _, entry := range fileEntries {
+			id := entryID(entry)
+			if prev, ok := seen[id]; ok {
+				return nil, nil, fmt.Errorf("%s is defined in both %s and %s", id, prev, path)
+			}
+			seen[id] = path
+			entries = append(entries, entry)
+			files = append(files, path)
+		}
+	}
+	return entries, files, nil
+}
+
+// decodeEntries decodes a JSON object or a list of JSON objects into config
+// entries.
+func decodeEntries(data []byte) ([]api.ConfigEntry, error) {
+	var raw []map[string]interface{}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	} else {
+		var single map[string]interface{}
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, err
+		}
+		raw = append(raw, single)
+	}
+
+	var entries []api.ConfigEntry
+	for _, r := range raw {
+		kind, _ := r["Kind"].(string)
+		if kindOrder(kind) == len(common.ConfigEntryKinds) {
+			return nil, fmt.Errorf("unsupported config entry kind %q", kind)
+		}
+		entry, err := api.DecodeConfigEntry(r)
+		if err != nil {
+			return nil, err
+		}
+		if entry.GetName() == "" {
+			return nil, fmt.Errorf("%s config entry has no name", kind)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// unifiedDiff returns the diff between the indented JSON of the current and
+// desired entries. current is nil if the entry doesn't exist.
+func unifiedDiff(current, desired map[string]interface{}, file string) (string, error) {
+	var a []string
+	if current != nil {
+		data, err := json.MarshalIndent(current, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		a = difflib.SplitLines(string(data) + "\n")
+	}
+	data, err := json.MarshalIndent(desired, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        difflib.SplitLines(string(data) + "\n"),
+		FromFile: "consul",
+		ToFile:   file,
+		Context:  3,
+	})
+}
+
+// kindOrder returns the position of kind in the order config entries are
+// written in, or the number of supported kinds if it isn't supported.
+func kindOrder(kind string) int {
+	for i, k := range common.ConfigEntryKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return len(common.ConfigEntryKinds)
+}
+
+func entryID(entry api.ConfigEntry) string {
+	id := entry.GetKind() + "/" + entry.GetName()
+	if ns := entry.GetNamespace(); ns != "" {
+		id = ns + "/" + id
+	}
+	return id
+}
+
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "Unexpected response code: 404")
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Apply a directory of config entries to a Consul datacenter."
+const help = `
+Usage: consul-k8s config apply [options]
+
+  Applies the config entries in a directory of JSON files, as written by
+  the config export command, for restoring a backup or migrating the
+  service mesh configuration to another datacenter.
+
+  The changes to each config entry are shown as a diff against Consul
+  before they are applied. Use -dry-run to only show the changes. Config
+  entries are written in dependency order, e.g. proxy-defaults and
+  service-defaults before the service-routers that depend on them. If a
+  write fails, or a config entry was modified in Consul since the changes
+  were computed, the config entries already written are rolled back.
+  Config entries in Consul that aren't in the directory are left alone.
+`
@@ -0,0 +1,169 @@
+package configapply
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	capi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	emptyDir, err := ioutil.TempDir("", "config-apply")
+	require.NoError(t, err)
+	badKindDir := writeDir(t, map[string]string{
+		"exported.json": `{"Kind": "exported-services", "Name": "default"}`,
+	})
+	duplicateDir := writeDir(t, map[string]string{
+		"a.json": `{"Kind": "service-defaults", "Name": "web"}`,
+		"b.json": `[{"Kind": "service-defaults", "Name": "web"}]`,
+	})
+
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-dir must be set",
+		},
+		{
+			flags:  []string{"-dir", emptyDir},
+			expErr: "no .json files found in",
+		},
+		{
+			flags:  []string{"-dir", badKindDir},
+			expErr: `unsupported config entry kind "exported-services"`,
+		},
+		{
+			flags:  []string{"-dir", duplicateDir},
+			expErr: "service-defaults/web is defined in both",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that the diff is shown without writing anything on a dry run, and
+// that entries are then created, updated or left alone.
+func TestRun_DryRunAndApply(t *testing.T) {
+	t.Parallel()
+	consulClient := setupConsul(t)
+	_, _, err := consulClient.ConfigEntries().Set(&capi.ServiceConfigEntry{Kind: capi.ServiceDefaults, Name: "db", Protocol: "tcp"}, nil)
+	require.NoError(t, err)
+	_, _, err = consulClient.ConfigEntries().Set(&capi.ServiceConfigEntry{Kind: capi.ServiceDefaults, Name: "cache", Protocol: "tcp"}, nil)
+	require.NoError(t, err)
+
+	dir := writeDir(t, map[string]string{
+		// The router depends on the service-defaults in the other file so
+		// it must be written last.
+		"a-router.json": `{
+  "Kind": "service-router",
+  "Name": "web",
+  "Routes": [{"Match": {"HTTP": {"PathPrefix": "/admin"}}, "Destination": {"Service": "admin"}}]
+}`,
+		"b-defaults.json": `[
+  {"Kind": "service-defaults", "Name": "web", "Protocol": "http"},
+  {"Kind": "service-defaults", "Name": "admin", "Protocol": "http"},
+  {"Kind": "service-defaults", "Name": "db", "Protocol": "grpc"},
+  {"Kind": "service-defaults", "Name": "cache", "Protocol": "tcp"}
+]`,
+	})
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consulClient}
+	exitCode := cmd.Run([]string{"-dir", dir, "-dry-run"})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	output := ui.OutputWriter.String()
+	require.Contains(t, output, "+ service-defaults/web will be created")
+	require.Contains(t, output, "~ service-defaults/db will be updated")
+	require.Contains(t, output, `-  "Protocol": "tcp"`)
+	require.Contains(t, output, `+  "Protocol": "grpc"`)
+	require.Contains(t, output, "+ service-router/web will be created")
+	require.NotContains(t, output, "service-defaults/cache")
+	require.Contains(t, output, "4 to create or update, 1 unchanged")
+
+	entry, _, err := consulClient.ConfigEntries().Get(capi.ServiceDefaults, "db", nil)
+	require.NoError(t, err)
+	require.Equal(t, "tcp", entry.(*capi.ServiceConfigEntry).Protocol)
+
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui, consulClient: consulClient}
+	exitCode = cmd.Run([]string{"-dir", dir})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.OutputWriter.String(), "Applied 4 config entries")
+
+	entry, _, err = consulClient.ConfigEntries().Get(capi.ServiceDefaults, "db", nil)
+	require.NoError(t, err)
+	require.Equal(t, "grpc", entry.(*capi.ServiceConfigEntry).Protocol)
+	_, _, err = consulClient.ConfigEntries().Get(capi.ServiceRouter, "web", nil)
+	require.NoError(t, err)
+
+	// Applying again is a no-op.
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui, consulClient: consulClient}
+	exitCode = cmd.Run([]string{"-dir", dir})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.OutputWriter.String(), "All 5 config entries are up to date")
+}
+
+// Test that entries written before a failed write are rolled back.
+func TestRun_Rollback(t *testing.T) {
+	t.Parallel()
+	consulClient := setupConsul(t)
+	_, _, err := consulClient.ConfigEntries().Set(&capi.ServiceConfigEntry{Kind: capi.ServiceDefaults, Name: "db", Protocol: "tcp"}, nil)
+	require.NoError(t, err)
+
+	dir := writeDir(t, map[string]string{
+		"entries.json": `[
+  {"Kind": "service-defaults", "Name": "db", "Protocol": "grpc"},
+  {"Kind": "service-defaults", "Name": "web", "Protocol": "http"},
+  {"Kind": "service-router", "Name": "api", "Routes": [{"Match": {"HTTP": {"PathPrefix": "/v2"}}}]}
+]`,
+	})
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consulClient}
+	exitCode := cmd.Run([]string{"-dir", dir})
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.ErrorWriter.String(), "Error applying service-router/api")
+	require.Contains(t, ui.ErrorWriter.String(), "Rolled back 2 applied changes")
+
+	entry, _, err := consulClient.ConfigEntries().Get(capi.ServiceDefaults, "db", nil)
+	require.NoError(t, err)
+	require.Equal(t, "tcp", entry.(*capi.ServiceConfigEntry).Protocol)
+	_, _, err = consulClient.ConfigEntries().Get(capi.ServiceDefaults, "web", nil)
+	require.Error(t, err)
+	require.True(t, isNotFound(err))
+}
+
+func writeDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "config-apply")
+	require.NoError(t, err)
+	for name, contents := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+	return dir
+}
+
+func setupConsul(t *testing.T) *capi.Client {
+	consul, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { consul.Stop() })
+	consul.WaitForLeader(t)
+	consulClient, err := capi.NewClient(&capi.Config{Address: consul.HTTPAddr})
+	require.NoError(t, err)
+	return consulClient
+}
@@ -0,0 +1,232 @@
+package configexport
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	formatJSON = "json"
+	formatCRD  = "crd"
+)
+
+// Command is the command for exporting the config entries of a datacenter.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	http  *flags.HTTPFlags
+
+	flagKinds     string
+	flagNamespace string
+	flagFormat    string
+	flagOutputDir string
+
+	consulClient *api.Client
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagKinds, "kind", "",
+		"Comma-separated list of config entry kinds to export. Defaults to all supported kinds: "+
+			strings.Join(common.ConfigEntryKinds, ", ")+".")
+	c.flags.StringVar(&c.flagNamespace, "namespace", "",
+		"[Enterprise Only] Consul namespace to export config entries from. Use \"*\" to export "+
+			"config entries from all namespaces.")
+	c.flags.StringVar(&c.flagFormat, "format", formatJSON,
+		"Output format, one of \"json\" or \"crd\". \"json\" writes config entries in the format used by "+
+			"the Consul API and can be read by the config apply command. \"crd\" writes Kubernetes custom "+
+			"resource manifests that can be applied with kubectl.")
+	c.flags.StringVar(&c.flagOutputDir, "output-dir", "",
+		"Directory to write one file per config entry to. If not set, config entries are written to stdout.")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagFormat != formatJSON && c.flagFormat != formatCRD {
+		c.UI.Error(fmt.Sprintf("-format must be one of %q or %q", formatJSON, formatCRD))
+		return 1
+	}
+	kinds := common.ConfigEntryKinds
+	if c.flagKinds != "" {
+		kinds = strings.Split(c.flagKinds, ",")
+		for _, kind := range kinds {
+			if !supportedKind(kind) {
+				c.UI.Error(fmt.Sprintf("-kind %q is not supported, must be one of: %s", kind, strings.Join(common.ConfigEntryKinds, ", ")))
+				return 1
+			}
+		}
+	}
+
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	var entries []api.ConfigEntry
+	for _, kind := range kinds {
+		kindEntries, _, err := c.consulClient.ConfigEntries().List(kind, &api.QueryOptions{Namespace: c.flagNamespace})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error listing %s config entries: %s", kind, err))
+			return 1
+		}
+		entries = append(entries, kindEntries...)
+	}
+
+	if c.flagOutputDir != "" {
+		if err := os.MkdirAll(c.flagOutputDir, 0755); err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating output directory: %s", err))
+			return 1
+		}
+	}
+
+	var stdoutEntries []interface{}
+	for _, entry := range entries {
+		var out interface{}
+		var err error
+		if c.flagFormat == formatCRD {
+			out, err = toCRD(entry)
+		} else {
+			out, err = common.NormalizeConfigEntry(entry)
+		}
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error converting %s %q: %s", entry.GetKind(), entry.GetName(), err))
+			return 1
+		}
+
+		if c.flagOutputDir == "" {
+			stdoutEntries = append(stdoutEntries, out)
+			continue
+		}
+		path := filepath.Join(c.flagOutputDir, fileName(entry, c.flagFormat))
+		if err := writeFile(path, out, c.flagFormat); err != nil {
+			c.UI.Error(fmt.Sprintf("Error writing %s: %s", path, err))
+			return 1
+		}
+	}
+
+	if c.flagOutputDir != "" {
+		c.UI.Info(fmt.Sprintf("Exported %d config entries to %s", len(entries), c.flagOutputDir))
+		return 0
+	}
+	output, err := marshalAll(stdoutEntries, c.flagFormat)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error encoding config entries: %s", err))
+		return 1
+	}
+	c.UI.Output(output)
+	return 0
+}
+
+func supportedKind(kind string) bool {
+	for _, k := range common.ConfigEntryKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// fileName returns the name of the file an entry is written to. Wildcard
+// names are spelled out since "*" isn't valid in Kubernetes names and is
+// awkward in file names.
+func fileName(entry api.ConfigEntry, format string) string {
+	ext := ".json"
+	if format == formatCRD {
+		ext = ".yaml"
+	}
+	name := kubeName(entry.GetName())
+	if ns := entry.GetNamespace(); ns != "" && ns != "default" {
+		name = kubeName(ns) + "-" + name
+	}
+	return fmt.Sprintf("%s-%s%s", entry.GetKind(), name, ext)
+}
+
+func writeFile(path string, out interface{}, format string) error {
+	var data []byte
+	var err error
+	if format == formatCRD {
+		data, err = yaml.Marshal(out)
+	} else {
+		data, err = json.MarshalIndent(out, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// marshalAll encodes entries written to stdout: a JSON array that can be
+// saved to a file for the config apply command, or a multi-document YAML
+// stream that can be piped to kubectl apply.
+func marshalAll(entries []interface{}, format string) (string, error) {
+	if format == formatJSON {
+		if entries == nil {
+			entries = []interface{}{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		return string(data), err
+	}
+	var docs []string
+	for _, entry := range entries {
+		data, err := yaml.Marshal(entry)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(data))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Export the config entries of a Consul datacenter."
+const help = `
+Usage: consul-k8s config export [options]
+
+  Exports the config entries of a Consul datacenter as JSON or as
+  Kubernetes custom resource manifests, for backing up the service mesh
+  configuration or migrating it to another datacenter.
+
+  JSON output can be restored with the config apply command. Custom
+  resource output can be applied with kubectl so that the config entries
+  are managed by the consul-k8s controller. Custom resources don't set a
+  Kubernetes namespace: the Consul namespace they are written to depends on
+  the controller's namespace settings.
+`
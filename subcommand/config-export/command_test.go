@@ -0,0 +1,262 @@
+package configexport
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/common"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"-format", "hcl"},
+			expErr: `-format must be one of "json" or "crd"`,
+		},
+		{
+			flags:  []string{"-kind", "service-defaults,exported-services"},
+			expErr: `-kind "exported-services" is not supported`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that the custom resources exported for each kind match the config
+// entries they were exported from.
+func TestRun_CRDRoundTrip(t *testing.T) {
+	t.Parallel()
+	consulClient := setupConsul(t)
+
+	resources := []common.ConfigEntryResource{
+		&v1alpha1.ProxyDefaults{
+			ObjectMeta: metav1.ObjectMeta{Name: common.Global},
+			Spec: v1alpha1.ProxyDefaultsSpec{
+				Config:      json.RawMessage(`{"protocol":"http","envoy_stats_tags":["a=b"]}`),
+				MeshGateway: v1alpha1.MeshGatewayConfig{Mode: "local"},
+			},
+		},
+		&v1alpha1.ServiceDefaults{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: v1alpha1.ServiceDefaultsSpec{
+				Protocol: "http",
+			},
+		},
+		&v1alpha1.ServiceDefaults{
+			ObjectMeta: metav1.ObjectMeta{Name: "external"},
+			Spec: v1alpha1.ServiceDefaultsSpec{
+				Protocol:    "tcp",
+				ExternalSNI: "external.example.com",
+			},
+		},
+		&v1alpha1.ServiceResolver{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: v1alpha1.ServiceResolverSpec{
+				DefaultSubset: "v1",
+				Subsets: v1alpha1.ServiceResolverSubsetMap{
+					"v1":  {Filter: "Service.Meta.version == v1", OnlyPassing: true},
+					"all": {},
+				},
+				Failover: v1alpha1.ServiceResolverFailoverMap{
+					"*": {Datacenters: []string{"dc2"}},
+				},
+				ConnectTimeout: 15 * time.Second,
+				LoadBalancer: &v1alpha1.LoadBalancer{
+					Policy: "ring_hash",
+					RingHashConfig: &v1alpha1.RingHashConfig{
+						MinimumRingSize: 1024,
+					},
+					HashPolicies: []v1alpha1.HashPolicy{
+						{
+							Field:      "cookie",
+							FieldValue: "session",
+							CookieConfig: &v1alpha1.CookieConfig{
+								TTL:  time.Minute,
+								Path: "/",
+							},
+						},
+						{SourceIP: true, Terminal: true},
+					},
+				},
+			},
+		},
+		&v1alpha1.ServiceSplitter{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: v1alpha1.ServiceSplitterSpec{
+				Splits: v1alpha1.ServiceSplits{
+					{Weight: 90, ServiceSubset: "v1"},
+					{Weight: 10, ServiceSubset: "all"},
+				},
+			},
+		},
+		&v1alpha1.ServiceRouter{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: v1alpha1.ServiceRouterSpec{
+				Routes: []v1alpha1.ServiceRoute{
+					{
+						Match: &v1alpha1.ServiceRouteMatch{
+							HTTP: &v1alpha1.ServiceRouteHTTPMatch{PathPrefix: "/admin"},
+						},
+						Destination: &v1alpha1.ServiceRouteDestination{
+							Service:        "admin",
+							PrefixRewrite:  "/",
+							RequestTimeout: 5 * time.Second,
+							NumRetries:     3,
+						},
+					},
+				},
+			},
+		},
+		&v1alpha1.IngressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "ingress-gateway"},
+			Spec: v1alpha1.IngressGatewaySpec{
+				TLS: v1alpha1.GatewayTLSConfig{Enabled: true},
+				Listeners: []v1alpha1.IngressListener{
+					{
+						Port:     8080,
+						Protocol: "http",
+						Services: []v1alpha1.IngressService{{Name: "web", Hosts: []string{"web.example.com"}}},
+					},
+				},
+			},
+		},
+		&v1alpha1.TerminatingGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "terminating-gateway"},
+			Spec: v1alpha1.TerminatingGatewaySpec{
+				Services: []v1alpha1.LinkedService{
+					{Name: "db", CAFile: "/etc/ca.pem", SNI: "db.example.com"},
+				},
+			},
+		},
+		&v1alpha1.ServiceIntentions{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-intentions"},
+			Spec: v1alpha1.ServiceIntentionsSpec{
+				Destination: v1alpha1.Destination{Name: "web"},
+				Sources: v1alpha1.SourceIntentions{
+					{Name: "frontend", Action: "allow", Description: "frontend"},
+					{
+						Name: "*",
+						Permissions: v1alpha1.IntentionPermissions{
+							{
+								Action: "deny",
+								HTTP: &v1alpha1.IntentionHTTPPermission{
+									PathPrefix: "/admin",
+									Methods:    []string{"POST"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, resource := range resources {
+		_, _, err := consulClient.ConfigEntries().Set(resource.ToConsul("dc1"), nil)
+		require.NoError(t, err, resource.ConsulKind())
+	}
+
+	outputDir, err := ioutil.TempDir("", "config-export")
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consulClient}
+	exitCode := cmd.Run([]string{"-format", "crd", "-output-dir", outputDir})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.OutputWriter.String(), "Exported 9 config entries")
+
+	for _, resource := range resources {
+		entry, _, err := consulClient.ConfigEntries().Get(resource.ConsulKind(), resource.ConsulName(), nil)
+		require.NoError(t, err)
+		data, err := ioutil.ReadFile(filepath.Join(outputDir, fileName(entry, formatCRD)))
+		require.NoError(t, err)
+
+		exported := resource.DeepCopyObject().(common.ConfigEntryResource)
+		require.NoError(t, yaml.Unmarshal(data, exported), string(data))
+		require.True(t, exported.MatchesConsul(entry), "%s doesn't match Consul:\n%s", resource.ConsulKind(), data)
+	}
+}
+
+// Test that JSON written to stdout can be decoded back into the config
+// entries, without the fields set by the servers.
+func TestRun_JSONStdout(t *testing.T) {
+	t.Parallel()
+	consulClient := setupConsul(t)
+	_, _, err := consulClient.ConfigEntries().Set(&capi.ServiceConfigEntry{
+		Kind:     capi.ServiceDefaults,
+		Name:     "web",
+		Protocol: "http",
+	}, nil)
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consulClient}
+	exitCode := cmd.Run([]string{"-kind", capi.ServiceDefaults})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+	var entries []map[string]interface{}
+	require.NoError(t, json.Unmarshal(ui.OutputWriter.Bytes(), &entries))
+	require.Equal(t, []map[string]interface{}{
+		{
+			"Kind":        capi.ServiceDefaults,
+			"Name":        "web",
+			"Protocol":    "http",
+			"MeshGateway": map[string]interface{}{},
+			"Expose":      map[string]interface{}{},
+		},
+	}, entries)
+}
+
+func TestLowerCamel(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"Protocol":    "protocol",
+		"TLS":         "tls",
+		"SNI":         "sni",
+		"CAFile":      "caFile",
+		"ExternalSNI": "externalSNI",
+		"SourceIP":    "sourceIP",
+		"HTTP":        "http",
+		"already":     "already",
+	}
+	for input, expected := range cases {
+		require.Equal(t, expected, lowerCamel(input), input)
+	}
+}
+
+func TestKubeName(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, "wildcard", kubeName("*"))
+	require.Equal(t, "my-service", kubeName("My_Service"))
+	require.Equal(t, "web", kubeName("web"))
+}
+
+func setupConsul(t *testing.T) *capi.Client {
+	consul, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { consul.Stop() })
+	consul.WaitForLeader(t)
+	consulClient, err := capi.NewClient(&capi.Config{Address: consul.HTTPAddr})
+	require.NoError(t, err)
+	return consulClient
+}
@@ -0,0 +1,199 @@
+package configexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul/api"
+)
+
+// crdKinds maps config entry kinds to the Kind of their custom resource and
+// a constructor for its spec.
+var crdKinds = map[string]struct {
+	kind string
+	spec func() interface{}
+}{
+	api.ProxyDefaults:      {"ProxyDefaults", func() interface{} { return &v1alpha1.ProxyDefaultsSpec{} }},
+	api.ServiceDefaults:    {"ServiceDefaults", func() interface{} { return &v1alpha1.ServiceDefaultsSpec{} }},
+	api.ServiceResolver:    {"ServiceResolver", func() interface{} { return &v1alpha1.ServiceResolverSpec{} }},
+	api.ServiceSplitter:    {"ServiceSplitter", func() interface{} { return &v1alpha1.ServiceSplitterSpec{} }},
+	api.ServiceRouter:      {"ServiceRouter", func() interface{} { return &v1alpha1.ServiceRouterSpec{} }},
+	api.IngressGateway:     {"IngressGateway", func() interface{} { return &v1alpha1.IngressGatewaySpec{} }},
+	api.TerminatingGateway: {"TerminatingGateway", func() interface{} { return &v1alpha1.TerminatingGatewaySpec{} }},
+	api.ServiceIntentions:  {"ServiceIntentions", func() interface{} { return &v1alpha1.ServiceIntentionsSpec{} }},
+}
+
+// topLevelFields are the fields of a config entry that are stored in the
+// custom resource's metadata, or not at all, rather than its spec.
+var topLevelFields = []string{"Kind", "Name", "Namespace", "Meta"}
+
+var invalidKubeNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// toCRD converts a config entry into the manifest of the custom resource
+// that the controller would write it from.
+func toCRD(entry api.ConfigEntry) (map[string]interface{}, error) {
+	crdKind, ok := crdKinds[entry.GetKind()]
+	if !ok {
+		return nil, fmt.Errorf("kind %q has no custom resource", entry.GetKind())
+	}
+
+	normalized, err := common.NormalizeConfigEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range topLevelFields {
+		delete(normalized, field)
+	}
+	spec := convertKeys(normalized).(map[string]interface{})
+	if entry.GetKind() == api.ServiceIntentions {
+		// The destination of service-intentions is set in the spec so that
+		// the resource's name doesn't have to match it.
+		destination := map[string]interface{}{"name": entry.GetName()}
+		if ns := entry.GetNamespace(); ns != "" {
+			destination["namespace"] = ns
+		}
+		spec["destination"] = destination
+	}
+
+	// Round-trip the spec through its Go type to drop any fields that don't
+	// exist in the custom resource.
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	typed := crdKind.spec()
+	if err := json.Unmarshal(raw, typed); err != nil {
+		return nil, err
+	}
+	raw, err = json.Marshal(typed)
+	if err != nil {
+		return nil, err
+	}
+	var pruned map[string]interface{}
+	if err := json.Unmarshal(raw, &pruned); err != nil {
+		return nil, err
+	}
+	pruneEmpty(pruned)
+
+	manifest := map[string]interface{}{
+		"apiVersion": v1alpha1.GroupVersion.String(),
+		"kind":       crdKind.kind,
+		"metadata": map[string]interface{}{
+			"name": kubeName(entry.GetName()),
+		},
+	}
+	if len(pruned) > 0 {
+		manifest["spec"] = pruned
+	}
+	return manifest, nil
+}
+
+// convertKeys converts the keys of a config entry's JSON representation from
+// the Consul API's casing to the lower camel case used by the custom
+// resources. The keys of free-form maps are user data and are kept as is.
+func convertKeys(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, fieldValue := range value {
+			switch k {
+			case "Config":
+				// Proxy config is opaque to Consul.
+				out[lowerCamel(k)] = fieldValue
+			case "Subsets", "Failover":
+				// Maps keyed by subset name whose values are structs.
+				values, _ := fieldValue.(map[string]interface{})
+				converted := make(map[string]interface{}, len(values))
+				for name, sub := range values {
+					converted[name] = convertKeys(sub)
+				}
+				out[lowerCamel(k)] = converted
+			case "ConnectTimeout", "RequestTimeout":
+				// The Consul API encodes these durations as strings while
+				// the custom resources store them in nanoseconds.
+				if str, ok := fieldValue.(string); ok {
+					if d, err := time.ParseDuration(str); err == nil {
+						fieldValue = int64(d)
+					}
+				}
+				out[lowerCamel(k)] = fieldValue
+			default:
+				out[lowerCamel(k)] = convertKeys(fieldValue)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, elem := range value {
+			out[i] = convertKeys(elem)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// lowerCamel lower cases the leading word of s, treating a run of upper
+// case letters as an acronym, e.g. "TLS" becomes "tls", "CAFile" becomes
+// "caFile" and "ExternalSNI" becomes "externalSNI".
+func lowerCamel(s string) string {
+	runes := []rune(s)
+	upper := 0
+	for upper < len(runes) && unicode.IsUpper(runes[upper]) {
+		upper++
+	}
+	switch {
+	case upper == 0:
+		return s
+	case upper == len(runes):
+		return strings.ToLower(s)
+	case upper > 1:
+		// The last upper case letter starts the next word.
+		upper--
+	}
+	return strings.ToLower(string(runes[:upper])) + string(runes[upper:])
+}
+
+// pruneEmpty removes empty objects from m, which are written for struct
+// fields of the custom resource types that aren't set. Subsets without a
+// filter are kept since they are still meaningful.
+func pruneEmpty(m map[string]interface{}) {
+	for k, v := range m {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if k == "subsets" || k == "failover" {
+				for _, sub := range value {
+					if subMap, ok := sub.(map[string]interface{}); ok {
+						pruneEmpty(subMap)
+					}
+				}
+			} else {
+				pruneEmpty(value)
+			}
+			if len(value) == 0 {
+				delete(m, k)
+			}
+		case []interface{}:
+			for _, elem := range value {
+				if elemMap, ok := elem.(map[string]interface{}); ok {
+					pruneEmpty(elemMap)
+				}
+			}
+		}
+	}
+}
+
+// kubeName converts a config entry name into a valid Kubernetes name.
+func kubeName(name string) string {
+	if name == "*" {
+		return "wildcard"
+	}
+	name = invalidKubeNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(name, "-.")
+}
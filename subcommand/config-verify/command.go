@@ -0,0 +1,381 @@
+package configverify
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/mitchellh/cli"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+)
+
+// Command is the command for detecting drift between a Helm release and the
+// resources deployed in the cluster.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	k8s   *flags.K8SFlags
+
+	flagReleaseName  string
+	flagK8sNamespace string
+
+	k8sClient kubernetes.Interface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagReleaseName, "release-name", "",
+		"Name of the Helm release Consul was installed with. This value is required.")
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace the Helm release is installed in. This value is required.")
+
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+// drift is a single difference between the release and the cluster.
+type drift struct {
+	resource string
+	message  string
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagReleaseName == "" {
+		c.UI.Error("-release-name must be set")
+		return 1
+	}
+	if c.flagK8sNamespace == "" {
+		c.UI.Error("-k8s-namespace must be set")
+		return 1
+	}
+
+	if c.k8sClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.k8sClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+	rel, err := deployedRelease(ctx, c.k8sClient, c.flagK8sNamespace, c.flagReleaseName)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading Helm release: %s", err))
+		return 1
+	}
+	if rel.Namespace == "" {
+		rel.Namespace = c.flagK8sNamespace
+	}
+	objs, err := rel.objects()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error parsing manifest of release %q: %s", rel.Name, err))
+		return 1
+	}
+
+	c.UI.Info(fmt.Sprintf("Comparing revision %d of release %q with the cluster...", rel.Version, rel.Name))
+
+	var drifts []drift
+	for _, obj := range objs {
+		objDrifts, err := c.verify(ctx, obj)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error verifying %s %q: %s", obj.Kind, obj.Name, err))
+			return 1
+		}
+		drifts = append(drifts, objDrifts...)
+	}
+
+	if len(drifts) == 0 {
+		c.UI.Output("No drift detected")
+		return 0
+	}
+	for _, d := range drifts {
+		c.UI.Output(fmt.Sprintf("DRIFT  %s: %s", d.resource, d.message))
+	}
+	c.UI.Error(fmt.Sprintf("Found %d difference(s) between release %q and the cluster. "+
+		"These changes will be lost on the next upgrade.", len(drifts), rel.Name))
+	return 1
+}
+
+// verify compares a single object from the manifest against the cluster.
+// Kinds that aren't checked for drift return no drift.
+func (c *Command) verify(ctx context.Context, obj object) ([]drift, error) {
+	resource := fmt.Sprintf("%s %s", obj.Kind, obj.Name)
+	missing := []drift{{resource: resource, message: "not found in the cluster"}}
+
+	switch obj.Kind {
+	case "Deployment":
+		var expected appsv1.Deployment
+		if err := json.Unmarshal(obj.Raw, &expected); err != nil {
+			return nil, err
+		}
+		actual, err := c.k8sClient.AppsV1().Deployments(obj.Namespace).Get(ctx, obj.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return missing, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return c.podSpecDrift(ctx, resource, obj.Namespace, expected.Spec.Template.Spec, actual.Spec.Template.Spec)
+	case "DaemonSet":
+		var expected appsv1.DaemonSet
+		if err := json.Unmarshal(obj.Raw, &expected); err != nil {
+			return nil, err
+		}
+		actual, err := c.k8sClient.AppsV1().DaemonSets(obj.Namespace).Get(ctx, obj.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return missing, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return c.podSpecDrift(ctx, resource, obj.Namespace, expected.Spec.Template.Spec, actual.Spec.Template.Spec)
+	case "StatefulSet":
+		var expected appsv1.StatefulSet
+		if err := json.Unmarshal(obj.Raw, &expected); err != nil {
+			return nil, err
+		}
+		actual, err := c.k8sClient.AppsV1().StatefulSets(obj.Namespace).Get(ctx, obj.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return missing, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return c.podSpecDrift(ctx, resource, obj.Namespace, expected.Spec.Template.Spec, actual.Spec.Template.Spec)
+	case "MutatingWebhookConfiguration":
+		var expected admissionv1.MutatingWebhookConfiguration
+		if err := json.Unmarshal(obj.Raw, &expected); err != nil {
+			return nil, err
+		}
+		actual, err := c.k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, obj.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return missing, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return webhookDrift(resource, expected.Webhooks, actual.Webhooks), nil
+	case "Secret":
+		_, err := c.k8sClient.CoreV1().Secrets(obj.Namespace).Get(ctx, obj.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return missing, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// podSpecDrift compares the images and flags of each container and checks
+// that the secrets referenced by the expected pod spec exist.
+func (c *Command) podSpecDrift(ctx context.Context, resource, namespace string, expected, actual corev1.PodSpec) ([]drift, error) {
+	var drifts []drift
+	for _, list := range []struct {
+		kind     string
+		expected []corev1.Container
+		actual   []corev1.Container
+	}{
+		{"init container", expected.InitContainers, actual.InitContainers},
+		{"container", expected.Containers, actual.Containers},
+	} {
+		actualByName := make(map[string]corev1.Container)
+		for _, container := range list.actual {
+			actualByName[container.Name] = container
+		}
+		for _, exp := range list.expected {
+			container := fmt.Sprintf("%s %q", list.kind, exp.Name)
+			act, ok := actualByName[exp.Name]
+			if !ok {
+				drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("%s is missing", container)})
+				continue
+			}
+			if exp.Image != act.Image {
+				drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("%s image is %q, expected %q", container, act.Image, exp.Image)})
+			}
+			expFlags, actFlags := containerFlags(exp), containerFlags(act)
+			for _, f := range difference(expFlags, actFlags) {
+				drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("%s is missing flag %s", container, f)})
+			}
+			for _, f := range difference(actFlags, expFlags) {
+				drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("%s has unexpected flag %s", container, f)})
+			}
+			delete(actualByName, exp.Name)
+		}
+		var extra []string
+		for name := range actualByName {
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("unexpected %s %q", list.kind, name)})
+		}
+	}
+
+	for _, name := range referencedSecrets(expected) {
+		_, err := c.k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("referenced secret %q not found", name)})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return drifts, nil
+}
+
+// webhookDrift compares the parts of each webhook that are set by Helm. The
+// CA bundle is ignored since it is patched in by webhook-cert-manager.
+func webhookDrift(resource string, expected, actual []admissionv1.MutatingWebhook) []drift {
+	actualByName := make(map[string]admissionv1.MutatingWebhook)
+	for _, webhook := range actual {
+		actualByName[webhook.Name] = webhook
+	}
+
+	var drifts []drift
+	for _, exp := range expected {
+		act, ok := actualByName[exp.Name]
+		if !ok {
+			drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("webhook %q is missing", exp.Name)})
+			continue
+		}
+		if exp.ClientConfig.Service != nil {
+			if act.ClientConfig.Service == nil {
+				drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("webhook %q no longer calls a service", exp.Name)})
+			} else {
+				expSvc, actSvc := exp.ClientConfig.Service, act.ClientConfig.Service
+				if expSvc.Name != actSvc.Name || expSvc.Namespace != actSvc.Namespace || !reflect.DeepEqual(expSvc.Path, actSvc.Path) {
+					drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("webhook %q calls service %s, expected %s",
+						exp.Name, serviceRef(actSvc), serviceRef(expSvc))})
+				}
+			}
+		}
+		if exp.NamespaceSelector != nil && !reflect.DeepEqual(exp.NamespaceSelector, act.NamespaceSelector) {
+			drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("webhook %q namespaceSelector differs", exp.Name)})
+		}
+		if exp.ObjectSelector != nil && !reflect.DeepEqual(exp.ObjectSelector, act.ObjectSelector) {
+			drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("webhook %q objectSelector differs", exp.Name)})
+		}
+		if exp.FailurePolicy != nil && (act.FailurePolicy == nil || *exp.FailurePolicy != *act.FailurePolicy) {
+			drifts = append(drifts, drift{resource: resource, message: fmt.Sprintf("webhook %q failurePolicy differs", exp.Name)})
+		}
+	}
+	return drifts
+}
+
+// serviceRef formats a webhook's service reference.
+func serviceRef(svc *admissionv1.ServiceReference) string {
+	path := ""
+	if svc.Path != nil {
+		path = *svc.Path
+	}
+	return fmt.Sprintf("%s/%s%s", svc.Namespace, svc.Name, path)
+}
+
+// containerFlags returns the flags passed to the container. Helm templates
+// pass them either as args or inline in a shell command so any word in the
+// command or args starting with a dash is treated as a flag.
+func containerFlags(container corev1.Container) []string {
+	var flags []string
+	for _, arg := range append(append([]string{}, container.Command...), container.Args...) {
+		for _, word := range strings.Fields(arg) {
+			if strings.HasPrefix(word, "-") {
+				flags = append(flags, word)
+			}
+		}
+	}
+	return flags
+}
+
+// referencedSecrets returns the names of the secrets that the pod spec
+// requires, ignoring optional references.
+func referencedSecrets(spec corev1.PodSpec) []string {
+	names := make(map[string]bool)
+	for _, volume := range spec.Volumes {
+		if s := volume.Secret; s != nil && (s.Optional == nil || !*s.Optional) {
+			names[s.SecretName] = true
+		}
+	}
+	for _, container := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil && (ref.Optional == nil || !*ref.Optional) {
+				names[ref.Name] = true
+			}
+		}
+		for _, from := range container.EnvFrom {
+			if ref := from.SecretRef; ref != nil && (ref.Optional == nil || !*ref.Optional) {
+				names[ref.Name] = true
+			}
+		}
+	}
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// difference returns the elements of a that aren't in b, in order.
+func difference(a, b []string) []string {
+	inB := make(map[string]bool)
+	for _, s := range b {
+		inB[s] = true
+	}
+	var diff []string
+	for _, s := range a {
+		if !inB[s] {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Detect drift between a Helm release and the cluster."
+const help = `
+Usage: consul-k8s config verify [options]
+
+  Compares the manifest stored in the latest deployed revision of the
+  Helm release with the resources running in the cluster and reports any
+  drift. Container images and flags, the connect injector's mutating
+  webhook configuration and the secrets the workloads depend on are
+  checked. Drift is usually caused by editing resources with kubectl and
+  will be lost on the next upgrade.
+
+  The command exits non-zero if any drift is found.
+`
@@ -0,0 +1,312 @@
+package configverify
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	releaseName = "consul"
+	namespace   = "consul"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-release-name must be set",
+		},
+		{
+			flags:  []string{"-release-name", releaseName},
+			expErr: "-k8s-namespace must be set",
+		},
+		{
+			flags:  []string{"-release-name", releaseName, "-k8s-namespace", namespace},
+			expErr: `Error reading Helm release: no deployed release "consul" found in namespace "consul"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				k8sClient: fake.NewSimpleClientset(),
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		// modify changes the objects deployed to the cluster.
+		modify      func(deployment *appsv1.Deployment, webhook *admissionv1.MutatingWebhookConfiguration, secrets *[]runtime.Object)
+		expExitCode int
+		expDrift    []string
+	}{
+		"no drift": {
+			modify:      func(*appsv1.Deployment, *admissionv1.MutatingWebhookConfiguration, *[]runtime.Object) {},
+			expExitCode: 0,
+		},
+		"CA bundle is ignored": {
+			modify: func(_ *appsv1.Deployment, webhook *admissionv1.MutatingWebhookConfiguration, _ *[]runtime.Object) {
+				webhook.Webhooks[0].ClientConfig.CABundle = []byte("ca")
+			},
+			expExitCode: 0,
+		},
+		"image changed": {
+			modify: func(deployment *appsv1.Deployment, _ *admissionv1.MutatingWebhookConfiguration, _ *[]runtime.Object) {
+				deployment.Spec.Template.Spec.Containers[0].Image = "hashicorp/consul-k8s:dev"
+			},
+			expExitCode: 1,
+			expDrift: []string{
+				`DRIFT  Deployment consul-connect-injector-webhook-deployment: container "sidecar-injector" image is "hashicorp/consul-k8s:dev", expected "hashicorp/consul-k8s:0.24.0"`,
+			},
+		},
+		"flag changed": {
+			modify: func(deployment *appsv1.Deployment, _ *admissionv1.MutatingWebhookConfiguration, _ *[]runtime.Object) {
+				container := &deployment.Spec.Template.Spec.Containers[0]
+				container.Command[2] = strings.Replace(container.Command[2], "-log-level=info", "-log-level=debug", 1)
+			},
+			expExitCode: 1,
+			expDrift: []string{
+				`DRIFT  Deployment consul-connect-injector-webhook-deployment: container "sidecar-injector" is missing flag -log-level=info`,
+				`DRIFT  Deployment consul-connect-injector-webhook-deployment: container "sidecar-injector" has unexpected flag -log-level=debug`,
+			},
+		},
+		"container added": {
+			modify: func(deployment *appsv1.Deployment, _ *admissionv1.MutatingWebhookConfiguration, _ *[]runtime.Object) {
+				deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, corev1.Container{Name: "debug"})
+			},
+			expExitCode: 1,
+			expDrift: []string{
+				`DRIFT  Deployment consul-connect-injector-webhook-deployment: unexpected container "debug"`,
+			},
+		},
+		"deployment deleted": {
+			modify: func(deployment *appsv1.Deployment, _ *admissionv1.MutatingWebhookConfiguration, _ *[]runtime.Object) {
+				deployment.Name = "renamed"
+			},
+			expExitCode: 1,
+			expDrift: []string{
+				`DRIFT  Deployment consul-connect-injector-webhook-deployment: not found in the cluster`,
+			},
+		},
+		"webhook path changed": {
+			modify: func(_ *appsv1.Deployment, webhook *admissionv1.MutatingWebhookConfiguration, _ *[]runtime.Object) {
+				path := "/other"
+				webhook.Webhooks[0].ClientConfig.Service.Path = &path
+			},
+			expExitCode: 1,
+			expDrift: []string{
+				`DRIFT  MutatingWebhookConfiguration consul-connect-injector-cfg: webhook "consul-connect-injector.consul.hashicorp.com" calls service consul/consul-connect-injector-svc/other, expected consul/consul-connect-injector-svc/mutate`,
+			},
+		},
+		"webhook namespace selector changed": {
+			modify: func(_ *appsv1.Deployment, webhook *admissionv1.MutatingWebhookConfiguration, _ *[]runtime.Object) {
+				webhook.Webhooks[0].NamespaceSelector = nil
+			},
+			expExitCode: 1,
+			expDrift: []string{
+				`DRIFT  MutatingWebhookConfiguration consul-connect-injector-cfg: webhook "consul-connect-injector.consul.hashicorp.com" namespaceSelector differs`,
+			},
+		},
+		"secrets deleted": {
+			modify: func(_ *appsv1.Deployment, _ *admissionv1.MutatingWebhookConfiguration, secrets *[]runtime.Object) {
+				*secrets = nil
+			},
+			expExitCode: 1,
+			expDrift: []string{
+				`DRIFT  Deployment consul-connect-injector-webhook-deployment: referenced secret "consul-connect-inject-acl-token" not found`,
+				`DRIFT  Secret consul-gossip-encryption-key: not found in the cluster`,
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			deployment, webhook, secrets := releaseObjects()
+			releaseSecret := helmReleaseSecret(t, 2, "deployed", deployment, webhook, secrets[0])
+
+			c.modify(deployment, webhook, &secrets)
+			objs := append([]runtime.Object{releaseSecret, deployment, webhook}, secrets...)
+			// An older revision with a different manifest shouldn't be used.
+			objs = append(objs, helmReleaseSecret(t, 1, "superseded", &corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "old"},
+			}))
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				k8sClient: fake.NewSimpleClientset(objs...),
+			}
+			exitCode := cmd.Run([]string{"-release-name", releaseName, "-k8s-namespace", namespace})
+			require.Equal(t, c.expExitCode, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.OutputWriter.String(), `Comparing revision 2 of release "consul" with the cluster...`)
+			if c.expExitCode == 0 {
+				require.Contains(t, ui.OutputWriter.String(), "No drift detected")
+				return
+			}
+			for _, exp := range c.expDrift {
+				require.Contains(t, ui.OutputWriter.String(), exp)
+			}
+			require.Equal(t, len(c.expDrift), strings.Count(ui.OutputWriter.String(), "DRIFT"), ui.OutputWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), "These changes will be lost on the next upgrade.")
+		})
+	}
+}
+
+func TestDecodeRelease(t *testing.T) {
+	t.Parallel()
+	encoded := base64.StdEncoding.EncodeToString([]byte(`{"name":"consul","version":3,"manifest":"---"}`))
+	rel, err := decodeRelease([]byte(encoded))
+	require.NoError(t, err)
+	require.Equal(t, "consul", rel.Name)
+	require.Equal(t, 3, rel.Version)
+
+	_, err = decodeRelease([]byte("not base64!"))
+	require.Error(t, err)
+}
+
+func TestContainerFlags(t *testing.T) {
+	t.Parallel()
+	flags := containerFlags(corev1.Container{
+		Command: []string{"/bin/sh", "-ec", "consul-k8s inject-connect \\\n  -log-level=info \\\n  -listen=:8080"},
+		Args:    []string{"-enable-health-checks-controller=true"},
+	})
+	require.Equal(t, []string{"-ec", "-log-level=info", "-listen=:8080", "-enable-health-checks-controller=true"}, flags)
+}
+
+// releaseObjects returns the objects in the test release as they are
+// deployed in the cluster when there is no drift.
+func releaseObjects() (*appsv1.Deployment, *admissionv1.MutatingWebhookConfiguration, []runtime.Object) {
+	path := "/mutate"
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "consul-connect-injector-webhook-deployment",
+			Namespace: namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:    "sidecar-injector",
+							Image:   "hashicorp/consul-k8s:0.24.0",
+							Command: []string{"/bin/sh", "-ec", "consul-k8s inject-connect \\\n  -log-level=info \\\n  -listen=:8080"},
+							Env: []corev1.EnvVar{
+								{
+									Name: "CONSUL_HTTP_TOKEN",
+									ValueFrom: &corev1.EnvVarSource{
+										SecretKeyRef: &corev1.SecretKeySelector{
+											LocalObjectReference: corev1.LocalObjectReference{Name: "consul-connect-inject-acl-token"},
+											Key:                  "token",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	webhook := &admissionv1.MutatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{Name: "consul-connect-injector-cfg"},
+		Webhooks: []admissionv1.MutatingWebhook{
+			{
+				Name: "consul-connect-injector.consul.hashicorp.com",
+				ClientConfig: admissionv1.WebhookClientConfig{
+					Service: &admissionv1.ServiceReference{
+						Name:      "consul-connect-injector-svc",
+						Namespace: namespace,
+						Path:      &path,
+					},
+				},
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"connect-inject": "enabled"},
+				},
+			},
+		},
+	}
+	secrets := []runtime.Object{
+		&corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-gossip-encryption-key", Namespace: namespace},
+		},
+		// Created by server-acl-init rather than Helm.
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-connect-inject-acl-token", Namespace: namespace},
+		},
+	}
+	return deployment, webhook, secrets
+}
+
+// helmReleaseSecret returns a secret storing a release with the objects in
+// its manifest, encoded the same way Helm 3 does.
+func helmReleaseSecret(t *testing.T, version int, status string, objs ...runtime.Object) *corev1.Secret {
+	var manifest strings.Builder
+	for _, obj := range objs {
+		out, err := yaml.Marshal(obj)
+		require.NoError(t, err)
+		manifest.WriteString("---\n")
+		manifest.Write(out)
+	}
+	rel, err := json.Marshal(map[string]interface{}{
+		"name":      releaseName,
+		"namespace": namespace,
+		"version":   version,
+		"manifest":  manifest.String(),
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(rel)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sh.helm.release.v1.consul.v" + strconv.Itoa(version),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"owner":   "helm",
+				"name":    releaseName,
+				"status":  status,
+				"version": strconv.Itoa(version),
+			},
+		},
+		Type: "helm.sh/release.v1",
+		Data: map[string][]byte{
+			"release": []byte(base64.StdEncoding.EncodeToString(buf.Bytes())),
+		},
+	}
+}
@@ -0,0 +1,142 @@
+package configverify
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// release is the subset of a Helm 3 release that we need.
+type release struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Manifest  string `json:"manifest"`
+}
+
+// object is a single resource from a release's manifest. Raw is the
+// resource encoded as JSON.
+type object struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Raw       []byte
+}
+
+// gzipMagic is the header of gzip-compressed data.
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// deployedRelease returns the latest deployed revision of the release from
+// the secrets Helm 3 stores releases in.
+func deployedRelease(ctx context.Context, client kubernetes.Interface, namespace, name string) (*release, error) {
+	secrets, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("owner=helm,name=%s,status=deployed", name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var latest []byte
+	latestVersion := -1
+	for _, secret := range secrets.Items {
+		version, err := strconv.Atoi(secret.Labels["version"])
+		if err != nil {
+			continue
+		}
+		if version > latestVersion {
+			latestVersion = version
+			latest = secret.Data["release"]
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no deployed release %q found in namespace %q", name, namespace)
+	}
+	return decodeRelease(latest)
+}
+
+// decodeRelease decodes a release the way Helm stores it in a secret:
+// JSON, optionally gzipped, then base64 encoded.
+func decodeRelease(data []byte) (*release, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding release: %s", err)
+	}
+	if bytes.HasPrefix(decoded, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing release: %s", err)
+		}
+		defer r.Close()
+		decoded, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing release: %s", err)
+		}
+	}
+
+	var rel release
+	if err := json.Unmarshal(decoded, &rel); err != nil {
+		return nil, fmt.Errorf("decoding release: %s", err)
+	}
+	return &rel, nil
+}
+
+// objects returns the resources in the release's manifest. Resources
+// without a namespace default to the release's namespace.
+func (r *release) objects() ([]object, error) {
+	var objs []object
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(r.Manifest)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw, err := sigsyaml.YAMLToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		var meta struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, err
+		}
+		if meta.Kind == "" {
+			continue
+		}
+		namespace := meta.Metadata.Namespace
+		if namespace == "" {
+			namespace = r.Namespace
+		}
+		objs = append(objs, object{
+			Kind:      meta.Kind,
+			Name:      meta.Metadata.Name,
+			Namespace: namespace,
+			Raw:       raw,
+		})
+	}
+	return objs, nil
+}
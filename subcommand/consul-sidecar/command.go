@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -25,8 +26,22 @@ type Command struct {
 	flagServiceConfig string
 	flagConsulBinary  string
 	flagSyncPeriod    time.Duration
+	flagCheckPeriod   time.Duration
 	flagSet           *flag.FlagSet
 	flagLogLevel      string
+	flagHealthPort    int
+
+	// flagDrainEnvoyAdminAddrs are the admin API addresses of the Envoy
+	// proxies to drain on shutdown.
+	flagDrainEnvoyAdminAddrs []string
+
+	// Flags to merge the proxy's metrics with the service's.
+	flagEnableMetricsMerging bool
+	flagMergedMetricsPort    int
+	flagPrometheusScrapePort int
+	flagPrometheusScrapePath string
+	flagServiceMetricsPort   int
+	flagServiceMetricsPath   string
 
 	consulCommand []string
 
@@ -40,13 +55,38 @@ func (c *Command) init() {
 	c.flagSet.StringVar(&c.flagServiceConfig, "service-config", "", "Path to the service config file")
 	c.flagSet.StringVar(&c.flagConsulBinary, "consul-binary", "consul", "Path to a consul binary")
 	c.flagSet.DurationVar(&c.flagSyncPeriod, "sync-period", 10*time.Second, "Time between syncing the service registration. Defaults to 10s.")
+	c.flagSet.DurationVar(&c.flagCheckPeriod, "registration-check-period", 2*time.Second,
+		"Time between checking that the services are still registered with the Consul agent, which "+
+			"re-registers them as soon as they aren't, e.g. after the agent restarted. Set to 0 to only "+
+			"register them every -sync-period. Defaults to 2s.")
 	c.flagSet.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\". Defaults to info.")
+	c.flagSet.IntVar(&c.flagHealthPort, "health-port", 0,
+		"Port to serve an HTTP health endpoint on, at /health, that responds with 503 if the last sync of "+
+			"the service registration failed, for use as a liveness probe. Disabled if 0.")
+	c.flagSet.Var((*flags.AppendSliceValue)(&c.flagDrainEnvoyAdminAddrs), "drain-envoy-admin-addr",
+		"Address of the admin API of an Envoy proxy, e.g. 127.0.0.1:19000, whose inbound listeners are "+
+			"gracefully drained when the sidecar receives SIGINT or SIGTERM. May be specified multiple times.")
+	c.flagSet.BoolVar(&c.flagEnableMetricsMerging, "enable-metrics-merging", false,
+		"Serve the Envoy proxy's metrics followed by the service's metrics on "+
+			"-prometheus-scrape-port and -prometheus-scrape-path.")
+	c.flagSet.IntVar(&c.flagMergedMetricsPort, "merged-metrics-port", 20100,
+		"Port on localhost that the Envoy proxy serves its metrics on. Defaults to 20100.")
+	c.flagSet.IntVar(&c.flagPrometheusScrapePort, "prometheus-scrape-port", 20200,
+		"Port to serve the merged metrics on. Defaults to 20200.")
+	c.flagSet.StringVar(&c.flagPrometheusScrapePath, "prometheus-scrape-path", "/metrics",
+		"Path to serve the merged metrics on. Defaults to /metrics.")
+	c.flagSet.IntVar(&c.flagServiceMetricsPort, "service-metrics-port", 0,
+		"Port on localhost that the service serves its metrics on. If not set, "+
+			"only the Envoy proxy's metrics are served.")
+	c.flagSet.StringVar(&c.flagServiceMetricsPath, "service-metrics-path", "/metrics",
+		"Path that the service serves its metrics on. Defaults to /metrics.")
 
 	c.help = flags.Usage(help, c.flagSet)
 	c.http = &flags.HTTPFlags{}
 	flags.Merge(c.flagSet, c.http.Flags())
+	flags.Merge(c.flagSet, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flagSet)
 
 	// Wait on an interrupt or terminate to exit. This channel must be initialized before
@@ -65,7 +105,7 @@ func (c *Command) init() {
 // mutating webhook.
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flagSet.Parse(args); err != nil {
+	if err := flags.Parse(c.flagSet, args); err != nil {
 		return 1
 	}
 
@@ -85,7 +125,11 @@ func (c *Command) Run(args []string) int {
 	logger.Info("Command configuration", "service-config", c.flagServiceConfig,
 		"consul-binary", c.flagConsulBinary,
 		"sync-period", c.flagSyncPeriod,
-		"log-level", c.flagLogLevel)
+		"registration-check-period", c.flagCheckPeriod,
+		"log-level", c.flagLogLevel,
+		"health-port", c.flagHealthPort,
+		"drain-envoy-admin-addrs", c.flagDrainEnvoyAdminAddrs,
+		"enable-metrics-merging", c.flagEnableMetricsMerging)
 
 	c.consulCommand = []string{"services", "register"}
 	c.consulCommand = append(c.consulCommand, c.parseConsulFlags()...)
@@ -103,6 +147,29 @@ func (c *Command) Run(args []string) int {
 			return
 		}
 	}()
+	health := &syncHealth{}
+	if c.flagHealthPort > 0 {
+		go c.serveHealth(ctx, health, logger)
+	}
+	if c.flagEnableMetricsMerging {
+		go c.serveMergedMetrics(ctx, logger)
+	}
+
+	// Re-register as soon as the services are missing from the agent, which
+	// happens when a Consul client restarts without its data directory,
+	// rather than waiting up to syncPeriod.
+	missingCh := make(chan struct{}, 1)
+	if c.flagCheckPeriod > 0 {
+		config, err := loadServiceConfig(c.flagServiceConfig)
+		if err != nil {
+			logger.Error("unable to read -service-config, only syncing every -sync-period", "err", err)
+		} else if client, err := common.NewConsulClient(c.http, nil); err != nil {
+			logger.Error("unable to create Consul client, only syncing every -sync-period", "err", err)
+		} else {
+			go watchRegistrations(ctx, client, config, c.flagCheckPeriod, missingCh, logger)
+		}
+	}
+
 	// The main work loop. We continually re-register our service every
 	// syncPeriod. Consul is smart enough to know when the service hasn't changed
 	// and so won't update any indices. This means we won't be causing a lot
@@ -113,9 +180,15 @@ func (c *Command) Run(args []string) int {
 	for {
 		start := time.Now()
 		cmd := exec.CommandContext(ctx, c.flagConsulBinary, c.consulCommand...)
+		if proxyEnv := c.http.ProxyEnv(); len(proxyEnv) > 0 {
+			cmd.Env = append(os.Environ(), proxyEnv...)
+		}
 
 		// Run the command and record the stdout and stderr output
 		output, err := cmd.CombinedOutput()
+		if ctx.Err() == nil {
+			health.record(err)
+		}
 		if err != nil {
 			logger.Error("failed to sync service", "output", strings.TrimSpace(string(output)), "err", err, "duration", time.Since(start))
 		} else {
@@ -125,7 +198,11 @@ func (c *Command) Run(args []string) int {
 		// Re-loop after syncPeriod or exit if we receive interrupt or terminate signals.
 		case <-time.After(c.flagSyncPeriod):
 			continue
+		case <-missingCh:
+			logger.Info("re-registering services missing from the Consul agent")
+			continue
 		case <-ctx.Done():
+			c.drainEnvoys(logger)
 			return 0
 		}
 	}
@@ -146,6 +223,20 @@ func (c *Command) validateFlags() error {
 		return errors.New("-sync-period must be greater than 0")
 	}
 
+	if c.flagHealthPort < 0 || c.flagHealthPort > 65535 {
+		return errors.New("-health-port must be between 0 and 65535")
+	}
+	for _, addr := range c.flagDrainEnvoyAdminAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("-drain-envoy-admin-addr %q is invalid: %s", addr, err)
+		}
+	}
+	if c.flagEnableMetricsMerging {
+		if err := c.validateMetricsMergingFlags(); err != nil {
+			return err
+		}
+	}
+
 	_, err := os.Stat(c.flagServiceConfig)
 	if os.IsNotExist(err) {
 		err = fmt.Errorf("-service-config file %q not found", c.flagServiceConfig)
@@ -159,11 +250,44 @@ func (c *Command) validateFlags() error {
 	return nil
 }
 
+// validateMetricsMergingFlags validates the flags used when
+// -enable-metrics-merging is set.
+func (c *Command) validateMetricsMergingFlags() error {
+	if c.flagMergedMetricsPort <= 0 || c.flagMergedMetricsPort > 65535 {
+		return errors.New("-merged-metrics-port must be between 1 and 65535")
+	}
+	if c.flagPrometheusScrapePort <= 0 || c.flagPrometheusScrapePort > 65535 {
+		return errors.New("-prometheus-scrape-port must be between 1 and 65535")
+	}
+	if c.flagServiceMetricsPort < 0 || c.flagServiceMetricsPort > 65535 {
+		return errors.New("-service-metrics-port must be between 0 and 65535")
+	}
+	if c.flagMergedMetricsPort == c.flagPrometheusScrapePort {
+		return errors.New("-merged-metrics-port and -prometheus-scrape-port must differ")
+	}
+	if c.flagHealthPort == c.flagPrometheusScrapePort {
+		return errors.New("-health-port and -prometheus-scrape-port must differ")
+	}
+	if !strings.HasPrefix(c.flagPrometheusScrapePath, "/") {
+		return errors.New("-prometheus-scrape-path must start with a /")
+	}
+	if !strings.HasPrefix(c.flagServiceMetricsPath, "/") {
+		return errors.New("-service-metrics-path must start with a /")
+	}
+	return nil
+}
+
 // parseConsulFlags creates Consul client command flags
 // from command's HTTP flags and returns them as an array of strings.
 func (c *Command) parseConsulFlags() []string {
 	var consulCommandFlags []string
 	c.http.Flags().VisitAll(func(f *flag.Flag) {
+		// The Consul CLI has no equivalent of these flags. The proxy is
+		// passed through the environment instead.
+		switch f.Name {
+		case "consul-api-timeout", "consul-api-retries", "http-proxy", "no-proxy":
+			return
+		}
 		if f.Value.String() != "" {
 			consulCommandFlags = append(consulCommandFlags, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
 		}
@@ -194,4 +318,7 @@ Usage: consul-k8s consul-sidecar [options]
   Run as a sidecar to your Connect service. Ensures that your service
   is registered with the local Consul client.
 
+  With -enable-metrics-merging, it also serves the metrics of the
+  Envoy proxy and of the service together for Prometheus to scrape.
+
 `
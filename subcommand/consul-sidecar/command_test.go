@@ -3,8 +3,10 @@ package subcommand
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"testing"
 	"time"
@@ -22,6 +24,7 @@ func TestRun_Defaults(t *testing.T) {
 	var cmd Command
 	cmd.init()
 	require.Equal(t, 10*time.Second, cmd.flagSyncPeriod)
+	require.Equal(t, 2*time.Second, cmd.flagCheckPeriod)
 	require.Equal(t, "info", cmd.flagLogLevel)
 	require.Equal(t, "consul", cmd.flagConsulBinary)
 }
@@ -98,6 +101,36 @@ func TestRun_FlagValidation(t *testing.T) {
 			},
 			ExpErr: "-sync-period must be greater than 0",
 		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-health-port=-1",
+			},
+			ExpErr: "-health-port must be between 0 and 65535",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-drain-envoy-admin-addr=19000",
+			},
+			ExpErr: "-drain-envoy-admin-addr \"19000\" is invalid",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-enable-metrics-merging",
+				"-merged-metrics-port=20200",
+			},
+			ExpErr: "-merged-metrics-port and -prometheus-scrape-port must differ",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-enable-metrics-merging",
+				"-prometheus-scrape-path=metrics",
+			},
+			ExpErr: "-prometheus-scrape-path must start with a /",
+		},
 	}
 
 	for _, c := range cases {
@@ -197,7 +230,8 @@ func TestRun_ServicesRegistration(t *testing.T) {
 	})
 }
 
-// Test that we register services when the Consul agent is down at first.
+// Test that we register services when the Consul agent is down at first,
+// and that the health endpoint reports the failed syncs until then.
 func TestRun_ServicesRegistration_ConsulDown(t *testing.T) {
 	t.Parallel()
 
@@ -209,18 +243,24 @@ func TestRun_ServicesRegistration_ConsulDown(t *testing.T) {
 		UI: ui,
 	}
 
-	// we need to reserve all 6 ports to avoid potential
-	// port collisions with other tests
-	randomPorts := freeport.MustTake(6)
+	// we need to reserve all 6 ports plus the health port to avoid
+	// potential port collisions with other tests
+	randomPorts := freeport.MustTake(7)
+	healthURL := fmt.Sprintf("http://127.0.0.1:%d/health", randomPorts[6])
 
 	// Run async because we need to kill it when the test is over.
 	exitChan := runCommandAsynchronously(&cmd, []string{
 		"-http-addr", fmt.Sprintf("127.0.0.1:%d", randomPorts[1]),
 		"-service-config", configFile,
 		"-sync-period", "100ms",
+		"-health-port", strconv.Itoa(randomPorts[6]),
 	})
 	defer stopCommand(t, &cmd, exitChan)
 
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, http.StatusServiceUnavailable, healthStatus(r, healthURL))
+	})
+
 	// Start the Consul agent after 500ms.
 	time.Sleep(500 * time.Millisecond)
 	a, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
@@ -251,6 +291,74 @@ func TestRun_ServicesRegistration_ConsulDown(t *testing.T) {
 		require.NoError(r, err)
 		require.Equal(r, 2000, svcProxy.Port)
 	})
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, http.StatusOK, healthStatus(r, healthURL))
+	})
+}
+
+// Test that services missing from the agent, e.g. because it restarted, are
+// registered again without waiting for the sync period.
+func TestRun_ServicesRegistration_MissingFromAgent(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, configFile := createServicesTmpFile(t, servicesRegistration)
+	defer os.RemoveAll(tmpDir)
+
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+
+	// Run async because we need to kill it when the test is over.
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-http-addr", a.HTTPAddr,
+		"-service-config", configFile,
+		"-sync-period", "1h",
+		"-registration-check-period", "100ms",
+	})
+	defer stopCommand(t, &cmd, exitChan)
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		_, _, err := client.Agent().Service("service-id", nil)
+		require.NoError(r, err)
+	})
+
+	// Deregister the service as if the agent had lost it.
+	require.NoError(t, client.Agent().ServiceDeregister("service-id"))
+
+	retry.Run(t, func(r *retry.R) {
+		svc, _, err := client.Agent().Service("service-id", nil)
+		require.NoError(r, err)
+		require.Equal(r, 80, svc.Port)
+	})
+}
+
+func TestLoadServiceConfig(t *testing.T) {
+	t.Parallel()
+	tmpDir, configFile := createServicesTmpFile(t, servicesRegistration+`
+services {
+	id        = "other-id"
+	name      = "other"
+	namespace = "ns"
+}`)
+	defer os.RemoveAll(tmpDir)
+
+	config, err := loadServiceConfig(configFile)
+	require.NoError(t, err)
+	var ids []string
+	for _, svc := range config.Services {
+		ids = append(ids, svc.ID+"/"+svc.Namespace)
+	}
+	require.Equal(t, []string{"service-id/", "service-id-sidecar-proxy/", "other-id/ns"}, ids)
 }
 
 // Test that we parse all flags and pass them down to the underlying Consul command.
@@ -353,3 +461,11 @@ services {
 	  local_service_port = 80
 	}
 }`
+
+// healthStatus returns the status code of a GET of the health endpoint.
+func healthStatus(r *retry.R, url string) int {
+	resp, err := http.Get(url)
+	require.NoError(r, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
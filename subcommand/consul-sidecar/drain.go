@@ -0,0 +1,36 @@
+package subcommand
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// drainRequestTimeout bounds each request to an Envoy admin API so that an
+// unresponsive proxy doesn't hold up the shutdown of the sidecar.
+const drainRequestTimeout = 2 * time.Second
+
+// drainEnvoys starts gracefully draining the inbound listeners of the Envoy
+// proxies at -drain-envoy-admin-addr. The proxies stop accepting new
+// connections and close the existing ones once their in-flight requests
+// complete, or when their --drain-time-s elapses. It doesn't wait for the
+// drain to finish since the proxies' preStop hook does.
+func (c *Command) drainEnvoys(logger hclog.Logger) {
+	client := &http.Client{Timeout: drainRequestTimeout}
+	for _, addr := range c.flagDrainEnvoyAdminAddrs {
+		url := fmt.Sprintf("http://%s/drain_listeners?graceful&inboundonly", addr)
+		resp, err := client.Post(url, "", nil)
+		if err != nil {
+			logger.Error("unable to drain Envoy proxy", "addr", addr, "err", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			logger.Error("unable to drain Envoy proxy", "addr", addr, "status", resp.Status)
+			continue
+		}
+		logger.Info("draining Envoy proxy", "addr", addr)
+	}
+}
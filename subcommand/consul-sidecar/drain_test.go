@@ -0,0 +1,80 @@
+package subcommand
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that on shutdown the inbound listeners of each proxy are drained and
+// that the proxies that can't be drained don't prevent the others from being
+// drained or the command from exiting cleanly.
+func TestRun_DrainsEnvoysOnShutdown(t *testing.T) {
+	t.Parallel()
+	tmpDir, configFile := createServicesTmpFile(t, servicesRegistration)
+	defer os.RemoveAll(tmpDir)
+
+	var lock sync.Mutex
+	var drained []*http.Request
+	envoyAdmin := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			drained = append(drained, r)
+			w.WriteHeader(status)
+		}))
+	}
+	failing := envoyAdmin(http.StatusInternalServerError)
+	defer failing.Close()
+	healthy := envoyAdmin(http.StatusOK)
+	defer healthy.Close()
+	stopped := httptest.NewServer(http.NotFoundHandler())
+	stopped.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-service-config", configFile,
+		"-http-addr", stopped.URL,
+		"-registration-check-period", "0s",
+		"-drain-envoy-admin-addr", hostPort(t, stopped),
+		"-drain-envoy-admin-addr", hostPort(t, failing),
+		"-drain-envoy-admin-addr", hostPort(t, healthy),
+	})
+	cmd.sendSignal(syscall.SIGTERM)
+
+	select {
+	case exitCode := <-exitChan:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout waiting for command to exit")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, drained, 2)
+	for _, r := range drained {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/drain_listeners", r.URL.Path)
+		query := r.URL.Query()
+		require.Contains(t, query, "graceful")
+		require.Contains(t, query, "inboundonly")
+	}
+}
+
+// hostPort returns the host:port address of server.
+func hostPort(t *testing.T, server *httptest.Server) string {
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return u.Host
+}
@@ -0,0 +1,67 @@
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// syncHealth records the result of the last sync of the services, which
+// the health endpoint reports for the sidecar's liveness probe.
+type syncHealth struct {
+	mu sync.Mutex
+	// err is the error of the last sync, if it failed.
+	err error
+	// lastSync is when the last sync finished.
+	lastSync time.Time
+}
+
+// record records the result of a sync.
+func (h *syncHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = err
+	h.lastSync = time.Now()
+}
+
+// ServeHTTP responds with 200 if the last sync succeeded, or if there hasn't
+// been one yet, and with 503 if it failed.
+func (h *syncHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	err, lastSync := h.err, h.lastSync
+	h.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("last sync at %s failed: %s", lastSync.Format(time.RFC3339), err),
+			http.StatusServiceUnavailable)
+		return
+	}
+	if lastSync.IsZero() {
+		fmt.Fprintln(w, "services not synced yet")
+		return
+	}
+	fmt.Fprintf(w, "last sync at %s succeeded\n", lastSync.Format(time.RFC3339))
+}
+
+// serveHealth serves the sync health on -health-port until ctx is done.
+func (c *Command) serveHealth(ctx context.Context, health *syncHealth, logger hclog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/health", health)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", c.flagHealthPort),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logger.Info("serving health endpoint", "addr", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("unable to serve health endpoint", "err", err)
+	}
+}
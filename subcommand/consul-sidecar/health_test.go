@@ -0,0 +1,29 @@
+package subcommand
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncHealth(t *testing.T) {
+	t.Parallel()
+	health := &syncHealth{}
+	requireStatus := func(exp int, expBody string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		require.Equal(t, exp, rec.Code)
+		require.Contains(t, rec.Body.String(), expBody)
+	}
+
+	// The sidecar is healthy until a sync has failed.
+	requireStatus(http.StatusOK, "services not synced yet")
+	health.record(errors.New("exit status 1"))
+	requireStatus(http.StatusServiceUnavailable, "failed: exit status 1")
+	health.record(nil)
+	requireStatus(http.StatusOK, "succeeded")
+}
@@ -0,0 +1,113 @@
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// metricsScrapeTimeout bounds each scrape of the proxy's and the service's
+// metrics, so that a stuck endpoint doesn't hold up Prometheus' scrape.
+const metricsScrapeTimeout = 10 * time.Second
+
+// metricsMerger serves the metrics of the Envoy proxy followed by the metrics
+// of the service, so that Prometheus can scrape both on a single endpoint.
+type metricsMerger struct {
+	// envoyMetricsURL is the URL of the proxy's Prometheus metrics, which
+	// it only serves on localhost.
+	envoyMetricsURL string
+	// serviceMetricsURL is the URL of the service's metrics, or empty to
+	// only serve the proxy's.
+	serviceMetricsURL string
+
+	client *http.Client
+	logger hclog.Logger
+}
+
+// ServeHTTP responds with the merged metrics. A failure to scrape the proxy
+// is an error, but the proxy's metrics are still served if the service's
+// can't be scraped so that the mesh stays observable when the service
+// doesn't expose any.
+func (m *metricsMerger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	envoyMetrics, err := m.scrape(r.Context(), m.envoyMetricsURL)
+	if err != nil {
+		m.logger.Error("unable to scrape Envoy proxy metrics", "url", m.envoyMetricsURL, "err", err)
+		http.Error(w, fmt.Sprintf("Error scraping Envoy proxy metrics: %s", err), http.StatusInternalServerError)
+		return
+	}
+	defer envoyMetrics.Close()
+
+	var serviceMetrics io.ReadCloser
+	if m.serviceMetricsURL != "" {
+		serviceMetrics, err = m.scrape(r.Context(), m.serviceMetricsURL)
+		if err != nil {
+			m.logger.Warn("unable to scrape service metrics, only serving Envoy proxy metrics",
+				"url", m.serviceMetricsURL, "err", err)
+		} else {
+			defer serviceMetrics.Close()
+		}
+	}
+
+	// Both endpoints serve the Prometheus text format.
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := io.Copy(w, envoyMetrics); err != nil {
+		m.logger.Error("unable to write Envoy proxy metrics", "err", err)
+		return
+	}
+	if serviceMetrics != nil {
+		// Make sure the first metric of the service starts on its own line.
+		io.WriteString(w, "\n")
+		if _, err := io.Copy(w, serviceMetrics); err != nil {
+			m.logger.Error("unable to write service metrics", "err", err)
+		}
+	}
+}
+
+// scrape returns the body of a successful GET of url.
+func (m *metricsMerger) scrape(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// serveMergedMetrics serves the merged metrics on the -prometheus-scrape-port
+// and -prometheus-scrape-path until ctx is done.
+func (c *Command) serveMergedMetrics(ctx context.Context, logger hclog.Logger) {
+	merger := &metricsMerger{
+		envoyMetricsURL: fmt.Sprintf("http://127.0.0.1:%d/metrics", c.flagMergedMetricsPort),
+		client:          &http.Client{Timeout: metricsScrapeTimeout},
+		logger:          logger.Named("metrics"),
+	}
+	if c.flagServiceMetricsPort > 0 {
+		merger.serviceMetricsURL = fmt.Sprintf("http://127.0.0.1:%d%s", c.flagServiceMetricsPort, c.flagServiceMetricsPath)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(c.flagPrometheusScrapePath, merger)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", c.flagPrometheusScrapePort),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logger.Info("serving merged metrics", "addr", server.Addr, "path", c.flagPrometheusScrapePath)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("unable to serve merged metrics", "err", err)
+	}
+}
@@ -0,0 +1,130 @@
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/sdk/freeport"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsMerger(t *testing.T) {
+	t.Parallel()
+	envoyServer := metricsServer(t, http.StatusOK, "envoy_metric 1")
+	serviceServer := metricsServer(t, http.StatusOK, "service_metric 1")
+	failingServer := metricsServer(t, http.StatusInternalServerError, "error")
+
+	cases := map[string]struct {
+		envoyURL   string
+		serviceURL string
+		expStatus  int
+		expBody    string
+	}{
+		"merged": {
+			envoyURL:   envoyServer.URL,
+			serviceURL: serviceServer.URL,
+			expStatus:  http.StatusOK,
+			expBody:    "envoy_metric 1\n\nservice_metric 1\n",
+		},
+		"no service metrics": {
+			envoyURL:  envoyServer.URL,
+			expStatus: http.StatusOK,
+			expBody:   "envoy_metric 1\n",
+		},
+		"service metrics failing": {
+			envoyURL:   envoyServer.URL,
+			serviceURL: failingServer.URL,
+			expStatus:  http.StatusOK,
+			expBody:    "envoy_metric 1\n",
+		},
+		"envoy metrics failing": {
+			envoyURL:   failingServer.URL,
+			serviceURL: serviceServer.URL,
+			expStatus:  http.StatusInternalServerError,
+			expBody:    "Error scraping Envoy proxy metrics: unexpected response code: 500\n",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			merger := &metricsMerger{
+				envoyMetricsURL:   c.envoyURL,
+				serviceMetricsURL: c.serviceURL,
+				client:            &http.Client{Timeout: metricsScrapeTimeout},
+				logger:            hclog.NewNullLogger(),
+			}
+			rec := httptest.NewRecorder()
+			merger.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			require.Equal(t, c.expStatus, rec.Code)
+			require.Equal(t, c.expBody, rec.Body.String())
+		})
+	}
+}
+
+// Test that the command serves the merged metrics on the scrape port.
+func TestServeMergedMetrics(t *testing.T) {
+	t.Parallel()
+	envoyServer := metricsServer(t, http.StatusOK, "envoy_metric 1")
+	serviceServer := metricsServer(t, http.StatusOK, "service_metric 1")
+	ports := freeport.MustTake(1)
+
+	cmd := Command{
+		flagMergedMetricsPort:    serverPort(t, envoyServer),
+		flagPrometheusScrapePort: ports[0],
+		flagPrometheusScrapePath: "/merged",
+		flagServiceMetricsPort:   serverPort(t, serviceServer),
+		flagServiceMetricsPath:   "/metrics",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cmd.serveMergedMetrics(ctx, hclog.NewNullLogger())
+		close(done)
+	}()
+
+	retry.Run(t, func(r *retry.R) {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/merged", ports[0]))
+		require.NoError(r, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(r, err)
+		require.Equal(r, http.StatusOK, resp.StatusCode)
+		require.Equal(r, "envoy_metric 1\n\nservice_metric 1\n", string(body))
+	})
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop after the context was done")
+	}
+}
+
+// metricsServer returns a server that responds to every request with status
+// and metrics.
+func metricsServer(t *testing.T, status int, metrics string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprintln(w, metrics)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func serverPort(t *testing.T, server *httptest.Server) int {
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return port
+}
@@ -0,0 +1,88 @@
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+)
+
+// serviceConfig is the part of the -service-config file needed to look up
+// its services on the agent.
+type serviceConfig struct {
+	Services []serviceConfigEntry
+}
+
+type serviceConfigEntry struct {
+	ID        string `hcl:"id"`
+	Namespace string `hcl:"namespace"`
+}
+
+// loadServiceConfig reads the services of the -service-config file.
+func loadServiceConfig(path string) (*serviceConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := hcl.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, fmt.Errorf("service config doesn't contain a root object")
+	}
+
+	// Each services block is decoded on its own since decoding repeated
+	// blocks straight into a slice of structs splits them up by field.
+	var config serviceConfig
+	for _, item := range root.Filter("services").Items {
+		var entry serviceConfigEntry
+		if err := hcl.DecodeObject(&entry, item.Val); err != nil {
+			return nil, err
+		}
+		config.Services = append(config.Services, entry)
+	}
+	return &config, nil
+}
+
+// watchRegistrations checks every period that the services in config are
+// registered with the agent and sends on missingCh when one isn't, e.g.
+// because the agent restarted and lost its state, so that they're registered
+// again without waiting for the sync period. It returns once ctx is done.
+func watchRegistrations(ctx context.Context, client *api.Client, config *serviceConfig, period time.Duration,
+	missingCh chan<- struct{}, logger hclog.Logger) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, svc := range config.Services {
+			_, _, err := client.Agent().Service(svc.ID, &api.QueryOptions{Namespace: svc.Namespace})
+			if err == nil {
+				continue
+			}
+			// Errors other than the service not being found, such as the
+			// agent being down, are left to the periodic sync.
+			if strings.Contains(err.Error(), "Unexpected response code: 404") {
+				logger.Info("service is no longer registered with the Consul agent", "id", svc.ID)
+				select {
+				case missingCh <- struct{}{}:
+				default:
+				}
+			} else {
+				logger.Debug("unable to check service registration", "id", svc.ID, "err", err)
+			}
+			break
+		}
+	}
+}
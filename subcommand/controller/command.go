@@ -8,6 +8,7 @@ import (
 	"github.com/hashicorp/consul-k8s/api/common"
 	"github.com/hashicorp/consul-k8s/api/v1alpha1"
 	"github.com/hashicorp/consul-k8s/controller"
+	subcommon "github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/mitchellh/cli"
 	"go.uber.org/zap/zapcore"
@@ -26,10 +27,12 @@ type Command struct {
 	flagSet   *flag.FlagSet
 	k8s       *flags.K8SFlags
 	httpFlags *flags.HTTPFlags
+	fault     *flags.FaultFlags
 
 	flagWebhookTLSCertDir    string
 	flagEnableLeaderElection bool
 	flagEnableWebhooks       bool
+	flagRouterAnnotations    bool
 	flagDatacenter           string
 	flagLogLevel             string
 
@@ -78,18 +81,24 @@ func (c *Command) init() {
 		"Directory that contains the TLS cert and key required for the webhook. The cert and key files must be named 'tls.crt' and 'tls.key' respectively.")
 	c.flagSet.BoolVar(&c.flagEnableWebhooks, "enable-webhooks", true,
 		"Enable webhooks. Disable when running locally since Kube API server won't be able to route to local server.")
+	c.flagSet.BoolVar(&c.flagRouterAnnotations, "enable-router-annotations", false,
+		fmt.Sprintf("Generate a ServiceRouter for Kubernetes services with the %q or %q annotations. "+
+			"Requires permission to watch services.", controller.AnnotationRetryAttempts, controller.AnnotationRequestTimeout))
 	c.flagSet.StringVar(&c.flagLogLevel, "log-level", zapcore.InfoLevel.String(),
 		fmt.Sprintf("Log verbosity level. Supported values (in order of detail) are "+
 			"%q, %q, %q, and %q.", zapcore.DebugLevel.String(), zapcore.InfoLevel.String(), zapcore.WarnLevel.String(), zapcore.ErrorLevel.String()))
 
 	c.httpFlags = &flags.HTTPFlags{}
+	c.fault = &flags.FaultFlags{}
 	flags.Merge(c.flagSet, c.httpFlags.Flags())
+	flags.Merge(c.flagSet, c.fault.Flags())
+	flags.Merge(c.flagSet, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flagSet)
 }
 
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flagSet.Parse(args); err != nil {
+	if err := flags.Parse(c.flagSet, args); err != nil {
 		c.UI.Error(fmt.Sprintf("Parsing flagset: %s", err.Error()))
 		return 1
 	}
@@ -105,6 +114,10 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error("Invalid arguments: -datacenter must be set")
 		return 1
 	}
+	if err := c.fault.Validate(); err != nil {
+		c.UI.Error(fmt.Sprintf("Invalid arguments: %s", err))
+		return 1
+	}
 
 	var zapLevel zapcore.Level
 	if err := zapLevel.UnmarshalText([]byte(c.flagLogLevel)); err != nil {
@@ -129,7 +142,7 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
-	consulClient, err := c.httpFlags.APIClient()
+	consulClient, err := subcommon.NewConsulClient(c.httpFlags, c.fault)
 	if err != nil {
 		setupLog.Error(err, "connecting to Consul agent")
 		return 1
@@ -216,6 +229,25 @@ func (c *Command) Run(args []string) int {
 		setupLog.Error(err, "unable to create controller", "controller", common.TerminatingGateway)
 		return 1
 	}
+	if err = (&controller.RegistrationController{
+		ConfigEntryController: configEntryReconciler,
+		Client:                mgr.GetClient(),
+		Log:                   ctrl.Log.WithName("controller").WithName(common.Registration),
+		Scheme:                mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", common.Registration)
+		return 1
+	}
+	if c.flagRouterAnnotations {
+		if err = (&controller.RouterAnnotationController{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controller").WithName("routerannotation"),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "routerannotation")
+			return 1
+		}
+	}
 
 	if c.flagEnableWebhooks {
 		// This webhook server sets up a Cert Watcher on the CertDir. This watches for file changes and updates the webhook certificates
@@ -30,6 +30,10 @@ func TestRun_FlagValidation(t *testing.T) {
 			flags:  []string{"-webhook-tls-cert-dir", "/foo", "-datacenter", "foo", "-log-level", "invalid"},
 			expErr: `Error parsing -log-level "invalid": unrecognized level: "invalid"`,
 		},
+		{
+			flags:  []string{"-webhook-tls-cert-dir", "/foo", "-datacenter", "foo", "-fault-consul-error-percent", "101"},
+			expErr: "-fault-consul-error-percent must be between 0 and 100",
+		},
 	}
 
 	for _, c := range cases {
@@ -0,0 +1,204 @@
+package corednsconfig
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/mitchellh/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+)
+
+const (
+	// corefileKey is the key in the CoreDNS ConfigMap that holds the Corefile.
+	corefileKey = "Corefile"
+
+	// beginMarker and endMarker delimit the server block we manage in the
+	// Corefile so it can be updated or removed without touching the rest
+	// of the configuration.
+	beginMarker = "# BEGIN consul-k8s: managed by consul-k8s coredns-config, do not edit"
+	endMarker   = "# END consul-k8s"
+)
+
+// Command is the command for configuring CoreDNS to forward the Consul
+// domain to the Consul DNS proxy.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	k8s   *flags.K8SFlags
+
+	flagDNSProxyAddr       string
+	flagDomain             string
+	flagConfigMapName      string
+	flagConfigMapNamespace string
+	flagRevert             bool
+
+	k8sClient kubernetes.Interface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagDNSProxyAddr, "dns-proxy-addr", "",
+		"IP address, optionally with a port, of the Consul DNS proxy Service. Queries for "+
+			"the Consul domain are forwarded here. Required unless -revert is set.")
+	c.flags.StringVar(&c.flagDomain, "domain", "consul",
+		"Consul DNS domain to forward to the DNS proxy.")
+	c.flags.StringVar(&c.flagConfigMapName, "configmap-name", "coredns",
+		"Name of the ConfigMap that holds the CoreDNS Corefile.")
+	c.flags.StringVar(&c.flagConfigMapNamespace, "configmap-namespace", metav1.NamespaceSystem,
+		"Namespace of the ConfigMap that holds the CoreDNS Corefile.")
+	c.flags.BoolVar(&c.flagRevert, "revert", false,
+		"Remove the configuration previously added by this command instead of adding it.")
+
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if !c.flagRevert {
+		if c.flagDNSProxyAddr == "" {
+			c.UI.Error("-dns-proxy-addr must be set")
+			return 1
+		}
+		if err := validateAddr(c.flagDNSProxyAddr); err != nil {
+			c.UI.Error(fmt.Sprintf("-dns-proxy-addr is invalid: %s", err))
+			return 1
+		}
+	}
+	if c.flagDomain == "" {
+		c.UI.Error("-domain must be set")
+		return 1
+	}
+
+	if c.k8sClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.k8sClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+	configMaps := c.k8sClient.CoreV1().ConfigMaps(c.flagConfigMapNamespace)
+	cm, err := configMaps.Get(ctx, c.flagConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading ConfigMap %s/%s: %s", c.flagConfigMapNamespace, c.flagConfigMapName, err))
+		return 1
+	}
+	corefile, ok := cm.Data[corefileKey]
+	if !ok {
+		c.UI.Error(fmt.Sprintf("ConfigMap %s/%s has no %q key", c.flagConfigMapNamespace, c.flagConfigMapName, corefileKey))
+		return 1
+	}
+
+	var updated string
+	if c.flagRevert {
+		updated = removeBlock(corefile)
+	} else {
+		updated = removeBlock(corefile) + serverBlock(c.flagDomain, c.flagDNSProxyAddr)
+	}
+	if updated == corefile {
+		c.UI.Info(fmt.Sprintf("ConfigMap %s/%s is already up to date", c.flagConfigMapNamespace, c.flagConfigMapName))
+		return 0
+	}
+
+	cm.Data[corefileKey] = updated
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		c.UI.Error(fmt.Sprintf("Error updating ConfigMap %s/%s: %s", c.flagConfigMapNamespace, c.flagConfigMapName, err))
+		return 1
+	}
+	if c.flagRevert {
+		c.UI.Info(fmt.Sprintf("Removed forwarding of the %q domain from ConfigMap %s/%s", c.flagDomain, cm.Namespace, cm.Name))
+	} else {
+		c.UI.Info(fmt.Sprintf("Configured ConfigMap %s/%s to forward the %q domain to %s", cm.Namespace, cm.Name, c.flagDomain, c.flagDNSProxyAddr))
+	}
+	return 0
+}
+
+// serverBlock returns the Corefile server block forwarding domain to addr.
+func serverBlock(domain, addr string) string {
+	return fmt.Sprintf(`%s
+%s:53 {
+    errors
+    cache 30
+    forward . %s
+}
+%s
+`, beginMarker, domain, addr, endMarker)
+}
+
+// removeBlock returns the Corefile with the server block we manage removed,
+// if present. The rest of the Corefile is left untouched apart from making
+// sure it ends with a newline.
+func removeBlock(corefile string) string {
+	start := strings.Index(corefile, beginMarker)
+	if start >= 0 {
+		if end := strings.Index(corefile[start:], endMarker); end >= 0 {
+			end += start + len(endMarker)
+			if end < len(corefile) && corefile[end] == '\n' {
+				end++
+			}
+			corefile = corefile[:start] + corefile[end:]
+		}
+	}
+	if corefile != "" && !strings.HasSuffix(corefile, "\n") {
+		corefile += "\n"
+	}
+	return corefile
+}
+
+// validateAddr returns an error if addr isn't an IP address, optionally with
+// a port, since CoreDNS's forward plugin can't resolve names.
+func validateAddr(addr string) error {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("%q is not an IP address", host)
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Configure CoreDNS to forward the Consul domain."
+const help = `
+Usage: consul-k8s coredns-config [options]
+
+  Adds a server block to the cluster's CoreDNS Corefile that forwards
+  queries for the Consul domain to the Consul DNS proxy so that names such
+  as web.service.consul resolve from any pod. Running the command again
+  replaces the block, and running it with -revert removes it, leaving the
+  rest of the Corefile unchanged.
+`
@@ -0,0 +1,132 @@
+package corednsconfig
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const defaultCorefile = `.:53 {
+    errors
+    health
+    kubernetes cluster.local in-addr.arpa ip6.arpa {
+      pods insecure
+      fallthrough in-addr.arpa ip6.arpa
+    }
+    forward . /etc/resolv.conf
+    cache 30
+}`
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-dns-proxy-addr must be set",
+		},
+		{
+			flags:  []string{"-dns-proxy-addr", "consul-dns-proxy.consul.svc"},
+			expErr: `-dns-proxy-addr is invalid: "consul-dns-proxy.consul.svc" is not an IP address`,
+		},
+		{
+			flags:  []string{"-dns-proxy-addr", "10.0.0.10", "-domain="},
+			expErr: "-domain must be set",
+		},
+		{
+			flags:  []string{"-dns-proxy-addr", "10.0.0.10"},
+			expErr: "Error reading ConfigMap kube-system/coredns",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				k8sClient: fake.NewSimpleClientset(),
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test adding, updating and reverting the forwarding configuration.
+func TestRun_AddUpdateRevert(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: metav1.NamespaceSystem},
+		Data:       map[string]string{corefileKey: defaultCorefile},
+	})
+	run := func(args ...string) {
+		ui := cli.NewMockUi()
+		cmd := Command{UI: ui, k8sClient: k8s}
+		exitCode := cmd.Run(args)
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	}
+	corefile := func() string {
+		cm, err := k8s.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(context.Background(), "coredns", metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data[corefileKey]
+	}
+
+	run("-dns-proxy-addr", "10.0.0.10")
+	require.Equal(t, defaultCorefile+`
+# BEGIN consul-k8s: managed by consul-k8s coredns-config, do not edit
+consul:53 {
+    errors
+    cache 30
+    forward . 10.0.0.10
+}
+# END consul-k8s
+`, corefile())
+
+	// Running again with the same address doesn't change anything.
+	run("-dns-proxy-addr", "10.0.0.10")
+	require.Equal(t, 1, strings.Count(corefile(), beginMarker))
+
+	// Changing the address replaces the block.
+	run("-dns-proxy-addr", "10.0.0.11:8053", "-domain", "dc1.consul")
+	require.Equal(t, 1, strings.Count(corefile(), beginMarker))
+	require.Contains(t, corefile(), "dc1.consul:53 {")
+	require.Contains(t, corefile(), "forward . 10.0.0.11:8053")
+	require.NotContains(t, corefile(), "10.0.0.10")
+
+	run("-revert")
+	require.Equal(t, defaultCorefile+"\n", corefile())
+
+	// Reverting again is a no-op.
+	run("-revert")
+	require.Equal(t, defaultCorefile+"\n", corefile())
+}
+
+// Test that configuration after our block is preserved when it's removed.
+func TestRemoveBlock(t *testing.T) {
+	t.Parallel()
+	corefile := defaultCorefile + "\n" + serverBlock("consul", "10.0.0.10") + "example.com:53 {\n    forward . 8.8.8.8\n}\n"
+	require.Equal(t, defaultCorefile+"\nexample.com:53 {\n    forward . 8.8.8.8\n}\n", removeBlock(corefile))
+}
+
+func TestRun_MissingCorefile(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+		k8sClient: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: metav1.NamespaceSystem},
+		}),
+	}
+	exitCode := cmd.Run([]string{"-dns-proxy-addr", "10.0.0.10"})
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.ErrorWriter.String(), `ConfigMap kube-system/coredns has no "Corefile" key`)
+}
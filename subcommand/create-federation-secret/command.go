@@ -7,12 +7,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
-	"github.com/hashicorp/consul-k8s/consul"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
@@ -31,6 +32,11 @@ const (
 	fedSecretCAKeyKey            = "caKey"
 	fedSecretServerConfigKey     = "serverConfigJSON"
 	fedSecretReplicationTokenKey = "replicationToken"
+
+	// fedSecretGeneratedAtAnnotation is the time the data of the secret was
+	// last generated, in RFC 3339 format, so that secondary datacenters can
+	// detect when it's rotated.
+	fedSecretGeneratedAtAnnotation = "consul.hashicorp.com/federation-secret-generated-at"
 )
 
 var retryInterval = 1 * time.Second
@@ -61,6 +67,10 @@ type Command struct {
 	flagLogLevel               string
 	flagMeshGatewayServiceName string
 
+	// flagRefresh updates the data of the existing secret in place instead
+	// of creating or replacing it.
+	flagRefresh bool
+
 	k8sClient    kubernetes.Interface
 	consulClient *api.Client
 
@@ -86,6 +96,11 @@ func (c *Command) init() {
 		"Name of Kubernetes namespace where Consul is deployed.")
 	c.flags.StringVar(&c.flagMeshGatewayServiceName, "mesh-gateway-service-name", "",
 		"Name of the mesh gateway service registered into Consul.")
+	c.flags.BoolVar(&c.flagRefresh, "refresh", false,
+		"Set to true to refresh an existing secret, e.g. after the gossip encryption key, the CA or the "+
+			"replication token were rotated. Its data is gathered again and updated in place, keeping its "+
+			"other labels and annotations, and the "+fedSecretGeneratedAtAnnotation+" annotation is only "+
+			"updated if the data changed. The command fails if the secret doesn't exist.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
@@ -94,6 +109,7 @@ func (c *Command) init() {
 	c.k8s = &flags.K8SFlags{}
 	flags.Merge(c.flags, c.http.Flags())
 	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
 }
 
@@ -163,7 +179,7 @@ func (c *Command) Run(args []string) int {
 
 	// Create the Kubernetes clientset.
 	if c.k8sClient == nil {
-		k8sCfg, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		k8sCfg, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
 			return 1
@@ -175,6 +191,16 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
+	// When refreshing, check the secret exists before waiting on Consul.
+	var existingSecret *corev1.Secret
+	if c.flagRefresh {
+		existingSecret, err = c.k8sClient.CoreV1().Secrets(c.flagK8sNamespace).Get(context.TODO(), federationSecret.Name, metav1.GetOptions{})
+		if err != nil {
+			logger.Error("Error retrieving federation secret to refresh", "name", federationSecret.Name, "err", err)
+			return 1
+		}
+	}
+
 	// Add replication token.
 	var replicationToken []byte
 	if c.flagExportReplicationToken {
@@ -190,20 +216,19 @@ func (c *Command) Run(args []string) int {
 	// Set up Consul client because we need to make calls to Consul to retrieve
 	// the datacenter name and mesh gateway addresses.
 	if c.consulClient == nil {
-		consulCfg := &api.Config{
-			// Use the replication token for our ACL token. If ACLs are disabled,
-			// this will be empty which won't matter because ACLs are disabled.
-			Token: string(replicationToken),
+		// Use the replication token for our ACL token unless one is passed
+		// via flags. If ACLs are disabled, this will be empty which won't
+		// matter because ACLs are disabled. The rest of the client config is
+		// automatically parsed from the passed flags and environment
+		// variables. For example, when running in k8s the CONSUL_HTTP_ADDR
+		// environment variable will be set to the IP of the Consul client pod
+		// on the same node.
+		if len(replicationToken) > 0 && c.http.Token() == "" {
+			c.http.SetToken(string(replicationToken))
 		}
-		// Merge our base config containing the optional ACL token with client
-		// config automatically parsed from the passed flags and environment
-		// variables. For example, when running in k8s the CONSUL_HTTP_ADDR environment
-		// variable will be set to the IP of the Consul client pod on the same
-		// node.
-		c.http.MergeOntoConfig(consulCfg)
 
 		var err error
-		c.consulClient, err = consul.NewClient(consulCfg)
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
 		if err != nil {
 			logger.Error("Error creating consul client", "err", err)
 			return 1
@@ -234,7 +259,14 @@ func (c *Command) Run(args []string) int {
 	}
 	federationSecret.Data[fedSecretServerConfigKey] = serverCfg
 
+	if c.flagRefresh {
+		return c.refreshSecret(existingSecret, federationSecret.Data, logger)
+	}
+
 	// Now create the Kubernetes secret.
+	federationSecret.Annotations = map[string]string{
+		fedSecretGeneratedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
 	logger.Info("Creating/updating Kubernetes secret", "name", federationSecret.ObjectMeta.Name, "ns", c.flagK8sNamespace)
 	_, err = c.k8sClient.CoreV1().Secrets(c.flagK8sNamespace).Create(context.TODO(), federationSecret, metav1.CreateOptions{})
 	if k8serrors.IsAlreadyExists(err) {
@@ -250,8 +282,32 @@ func (c *Command) Run(args []string) int {
 	return 0
 }
 
+// refreshSecret updates the data of the existing federation secret to data
+// if it changed. It returns the exit code of the command.
+func (c *Command) refreshSecret(secret *corev1.Secret, data map[string][]byte, logger hclog.Logger) int {
+	if reflect.DeepEqual(secret.Data, data) {
+		logger.Info("Federation secret is up to date", "name", secret.Name, "ns", c.flagK8sNamespace)
+		return 0
+	}
+
+	secret.Data = data
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[fedSecretGeneratedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	logger.Info("Refreshing Kubernetes secret", "name", secret.Name, "ns", c.flagK8sNamespace)
+	// The update fails if the secret changed since it was retrieved, in
+	// which case the command can be run again.
+	if _, err := c.k8sClient.CoreV1().Secrets(c.flagK8sNamespace).Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		logger.Error("Error refreshing federation secret", "err", err)
+		return 1
+	}
+	logger.Info("Successfully refreshed federation secret", "name", secret.Name, "ns", c.flagK8sNamespace)
+	return 0
+}
+
 func (c *Command) validateFlags(args []string) error {
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		return err
 	}
 	if len(c.flags.Args()) > 0 {
@@ -353,6 +409,8 @@ func (c *Command) meshGatewayAddrs(logger hclog.Logger) ([]string, error) {
 	for addr := range meshGatewayAddrs {
 		uniqMeshGatewayAddrs = append(uniqMeshGatewayAddrs, addr)
 	}
+	// Sort the addresses so the server config only changes if they do.
+	sort.Strings(uniqMeshGatewayAddrs)
 	return uniqMeshGatewayAddrs, nil
 }
 
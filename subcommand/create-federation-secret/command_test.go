@@ -939,6 +939,95 @@ func TestRun_UpdatesSecret(t *testing.T) {
 	}
 }
 
+// Test that -refresh updates the data of the existing secret in place and
+// only updates the generation timestamp if the data changed.
+func TestRun_Refresh(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+
+	caFile, certFile, keyFile, cleanup := common.GenerateServerCerts(t)
+	defer cleanup()
+	a, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.CAFile = caFile
+		c.CertFile = certFile
+		c.KeyFile = keyFile
+	})
+	require.NoError(t, err)
+	defer a.Stop()
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPSAddr,
+		Scheme:  "https",
+		TLSConfig: api.TLSConfig{
+			CAFile: caFile,
+		},
+	})
+	require.NoError(t, err)
+	err = client.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		Name: "mesh-gateway",
+		TaggedAddresses: map[string]api.ServiceAddress{
+			"wan": {
+				Address: "192.168.0.1",
+				Port:    443,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	f, err := ioutil.TempFile("", "")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	gossipKeyFile := f.Name()
+	require.NoError(t, ioutil.WriteFile(gossipKeyFile, []byte("old-key"), 0600))
+	run := func(refresh bool) int {
+		ui := cli.NewMockUi()
+		cmd := Command{
+			UI:        ui,
+			k8sClient: k8s,
+		}
+		return cmd.Run([]string{
+			"-resource-prefix=prefix",
+			"-k8s-namespace=default",
+			"-mesh-gateway-service-name=mesh-gateway",
+			"-ca-file", caFile,
+			"-server-ca-cert-file", certFile,
+			"-server-ca-key-file", keyFile,
+			"-gossip-key-file", gossipKeyFile,
+			"-http-addr", fmt.Sprintf("https://%s", a.HTTPSAddr),
+			fmt.Sprintf("-refresh=%t", refresh),
+		})
+	}
+	getSecret := func() *v1.Secret {
+		secret, err := k8s.CoreV1().Secrets("default").Get(context.Background(), "prefix-federation", metav1.GetOptions{})
+		require.NoError(t, err)
+		return secret
+	}
+
+	// The secret must exist to be refreshed.
+	require.Equal(t, 1, run(true))
+
+	require.Equal(t, 0, run(false))
+	secret := getSecret()
+	_, err = time.Parse(time.RFC3339, secret.Annotations[fedSecretGeneratedAtAnnotation])
+	require.NoError(t, err)
+	secret.Labels = map[string]string{"team": "mesh"}
+	secret.Annotations[fedSecretGeneratedAtAnnotation] = "2000-01-01T00:00:00Z"
+	_, err = k8s.CoreV1().Secrets("default").Update(context.Background(), secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	// Nothing changed so the secret is left as is.
+	require.Equal(t, 0, run(true))
+	require.Equal(t, "2000-01-01T00:00:00Z", getSecret().Annotations[fedSecretGeneratedAtAnnotation])
+
+	// Rotate the gossip key.
+	require.NoError(t, ioutil.WriteFile(gossipKeyFile, []byte("new-key"), 0600))
+	require.Equal(t, 0, run(true))
+	secret = getSecret()
+	require.Equal(t, "new-key", string(secret.Data[fedSecretGossipKey]))
+	require.Contains(t, secret.Data, fedSecretServerConfigKey)
+	require.Equal(t, map[string]string{"team": "mesh"}, secret.Labels)
+	require.NotEqual(t, "2000-01-01T00:00:00Z", secret.Annotations[fedSecretGeneratedAtAnnotation])
+}
+
 // Test that if the Consul client isn't up yet we will retry until it is.
 func TestRun_ConsulClientDelay(t *testing.T) {
 	t.Parallel()
@@ -10,10 +10,13 @@ import (
 	"github.com/hashicorp/consul-k8s/subcommand"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 )
@@ -24,17 +27,17 @@ const logLevel = "info"
 type Command struct {
 	UI cli.Ui
 
-	flags         *flag.FlagSet
-	k8s           *flags.K8SFlags
-	flagNamespace string
-	flagTimeout   string
+	flags             *flag.FlagSet
+	k8s               *flags.K8SFlags
+	flagNamespace     string
+	flagLabelSelector string
+	flagTimeout       string
+	flagPollInterval  time.Duration
+	flagRetainFailed  bool
 
 	once      sync.Once
 	help      string
 	k8sClient kubernetes.Interface
-
-	// retryDuration is how often we'll retry deletion.
-	retryDuration time.Duration
 }
 
 func (c *Command) init() {
@@ -42,48 +45,65 @@ func (c *Command) init() {
 
 	c.k8s = &flags.K8SFlags{}
 	c.flags.StringVar(&c.flagNamespace, "k8s-namespace", "",
-		"Name of Kubernetes namespace where the job is deployed")
+		"Name of Kubernetes namespace where the jobs are deployed")
+	c.flags.StringVar(&c.flagLabelSelector, "label-selector", "",
+		"Label selector of the jobs to delete, e.g. \"release=consul,component=server-acl-init\", in "+
+			"addition to the jobs named in the arguments. The jobs are listed again each poll interval "+
+			"so that jobs created after the command started are deleted too.")
 	c.flags.StringVar(&c.flagTimeout, "timeout", "30m",
-		"How long we'll wait for the job to complete before timing out, e.g. 1ms, 2s, 3m")
+		"How long we'll wait for all the jobs to complete before timing out, e.g. 1ms, 2s, 3m")
+	c.flags.DurationVar(&c.flagPollInterval, "poll-interval", 1*time.Second,
+		"How often to check whether the jobs have completed, e.g. 1ms, 2s, 3m.")
+	c.flags.BoolVar(&c.flagRetainFailed, "retain-failed", true,
+		"Whether to keep the jobs that failed, along with their pods and their logs, for debugging. "+
+			"If false, failed jobs are deleted too.")
+	c.flags.BoolVar(&c.flagRetainFailed, "keep-failed", true,
+		"[Deprecated] Please use '-retain-failed' flag instead.")
 	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
-
-	// Default retry to 1s. This is exposed for setting in tests.
-	if c.retryDuration == 0 {
-		c.retryDuration = 1 * time.Second
-	}
 }
 
-// Run will attempt to delete the job once it succeeds. If the job hits its
-// backoff limit, it will give up deleting it.
+// Run will attempt to delete the jobs once they succeed. If a job hits its
+// backoff limit, it will give up deleting it unless -retain-failed is false.
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
 
 	// Validate command.
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		return 1
 	}
-	if len(c.flags.Args()) != 1 {
-		c.UI.Error("Must have one arg: the job name to delete.")
+	jobNames := c.flags.Args()
+	if len(jobNames) == 0 && c.flagLabelSelector == "" {
+		c.UI.Error("Must have at least one arg, the names of the jobs to delete, or set flag -label-selector.")
 		return 1
 	}
-	jobName := c.flags.Args()[0]
 	if c.flagNamespace == "" {
 		c.UI.Error("Must set flag -k8s-namespace")
 		return 1
 	}
+	if c.flagLabelSelector != "" {
+		if _, err := labels.Parse(c.flagLabelSelector); err != nil {
+			c.UI.Error(fmt.Sprintf("-label-selector is invalid: %s", err))
+			return 1
+		}
+	}
 	timeout, err := time.ParseDuration(c.flagTimeout)
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("%q is not a valid timeout: %s", c.flagTimeout, err))
 		return 1
 	}
+	if c.flagPollInterval <= 0 {
+		c.UI.Error("-poll-interval must be greater than 0")
+		return 1
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	// The context will only ever be intentionally ended by the timeout.
 	defer cancel()
 
 	// c.k8sclient might already be set in a test.
 	if c.k8sClient == nil {
-		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
 			return 1
@@ -102,59 +122,134 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
-	// Wait for job to complete.
-	logger.Info(fmt.Sprintf("waiting for job %q to complete successfully", jobName))
+	// The jobs that are done with, i.e. that succeeded and were deleted, or
+	// that failed, or don't exist. Jobs that were deleted can still be
+	// listed until their pods are deleted so they mustn't be deleted twice.
+	done := make(map[string]bool)
+	failed := false
 	for {
-		job, err := c.k8sClient.BatchV1().Jobs(c.flagNamespace).Get(context.TODO(), jobName, metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			logger.Info(fmt.Sprintf("job %q does not exist, no need to delete", jobName))
-			return 0
-		}
+		jobs, err := c.jobs(ctx, logger, jobNames, done)
 		if err != nil {
-			c.UI.Error(fmt.Sprintf("Error getting job %q: %s", jobName, err))
+			c.UI.Error(err.Error())
 			return 1
 		}
 
-		// If its succeeded we're done.
-		if job.Status.Succeeded > 0 {
-			break
-		}
+		var waiting []string
+		for i := range jobs {
+			job := &jobs[i]
+			// If it succeeded we can delete it.
+			if job.Status.Succeeded > 0 {
+				done[job.Name] = true
+				logger.Info(fmt.Sprintf("job %q has succeeded, deleting", job.Name))
+				if err := c.deleteJob(job.Name); err != nil {
+					c.UI.Error(fmt.Sprintf("unable to delete job %q: %s", job.Name, err))
+					failed = true
+				} else {
+					logger.Info(fmt.Sprintf("Deleted job %q successfully", job.Name))
+				}
+				continue
+			}
 
-		// If its reached its backoff limit then it will never complete.
-		for _, condition := range job.Status.Conditions {
-			if condition.Type == v1.JobFailed && condition.Reason == "BackoffLimitExceeded" {
-				logger.Warn(fmt.Sprintf("job %q has reached its backoff limit and will never complete", jobName))
-				return 1
+			// If it has failed, e.g. because it reached its backoff limit or
+			// deadline, then it will never complete.
+			if condition := failedCondition(job); condition != nil {
+				done[job.Name] = true
+				failed = true
+				logger.Warn(fmt.Sprintf("job %q has failed and will never complete", job.Name),
+					"reason", condition.Reason, "message", condition.Message)
+				if c.flagRetainFailed {
+					logger.Info(fmt.Sprintf("keeping failed job %q and its pods for debugging", job.Name))
+				} else if err := c.deleteJob(job.Name); err != nil {
+					c.UI.Error(fmt.Sprintf("unable to delete job %q: %s", job.Name, err))
+				} else {
+					logger.Info(fmt.Sprintf("Deleted failed job %q", job.Name))
+				}
+				continue
 			}
+			waiting = append(waiting, job.Name)
+		}
+		if len(waiting) == 0 {
+			break
 		}
 
-		logger.Info(fmt.Sprintf("job %q has not yet succeeded, waiting %v", jobName, c.retryDuration))
-		// Wait on either the retry duration (in which case we continue) or the
+		logger.Info(fmt.Sprintf("jobs %q have not yet succeeded, waiting %v", waiting, c.flagPollInterval))
+		// Wait on either the poll interval (in which case we continue) or the
 		// overall command timeout.
 		select {
-		case <-time.After(c.retryDuration):
+		case <-time.After(c.flagPollInterval):
 			continue
 		case <-ctx.Done():
-			logger.Warn(fmt.Sprintf("timeout %q has been reached, exiting without deleting job", timeout))
+			logger.Warn(fmt.Sprintf("timeout %q has been reached, exiting without deleting jobs %q", timeout, waiting))
 			return 1
 		}
 	}
 
-	// Here we know the job has succeeded. We can delete it and then delete
-	// ourselves.
-	logger.Info(fmt.Sprintf("job %q has succeeded, deleting", jobName))
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// jobs returns the named jobs and the jobs matching the label selector,
+// except for those that are done or being deleted. Named jobs that don't
+// exist are marked as done.
+func (c *Command) jobs(ctx context.Context, logger hclog.Logger, names []string, done map[string]bool) ([]v1.Job, error) {
+	var jobs []v1.Job
+	seen := make(map[string]bool)
+	add := func(job v1.Job) {
+		if done[job.Name] || seen[job.Name] || job.DeletionTimestamp != nil {
+			return
+		}
+		seen[job.Name] = true
+		jobs = append(jobs, job)
+	}
+
+	for _, name := range names {
+		if done[name] {
+			continue
+		}
+		job, err := c.k8sClient.BatchV1().Jobs(c.flagNamespace).Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			logger.Info(fmt.Sprintf("job %q does not exist, no need to delete", name))
+			done[name] = true
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error getting job %q: %s", name, err)
+		}
+		add(*job)
+	}
+
+	if c.flagLabelSelector != "" {
+		list, err := c.k8sClient.BatchV1().Jobs(c.flagNamespace).List(ctx, metav1.ListOptions{LabelSelector: c.flagLabelSelector})
+		if err != nil {
+			return nil, fmt.Errorf("Error listing jobs matching %q: %s", c.flagLabelSelector, err)
+		}
+		for _, job := range list.Items {
+			add(job)
+		}
+	}
+	return jobs, nil
+}
+
+// deleteJob deletes the job along with its pods.
+func (c *Command) deleteJob(jobName string) error {
 	propagationPolicy := metav1.DeletePropagationForeground
-	err = c.k8sClient.BatchV1().Jobs(c.flagNamespace).Delete(context.TODO(), jobName, metav1.DeleteOptions{
+	return c.k8sClient.BatchV1().Jobs(c.flagNamespace).Delete(context.TODO(), jobName, metav1.DeleteOptions{
 		// Needed so that the underlying pods are also deleted.
 		PropagationPolicy: &propagationPolicy,
 	})
-	if err != nil {
-		c.UI.Error(fmt.Sprintf("unable to delete job %q: %s", jobName, err))
-		return 1
-	}
+}
 
-	logger.Info(fmt.Sprintf("Deleted job %q successfully", jobName))
-	return 0
+// failedCondition returns the condition of the job that marks it as failed,
+// or nil if it hasn't failed.
+func failedCondition(job *v1.Job) *v1.JobCondition {
+	for i, condition := range job.Status.Conditions {
+		if condition.Type == v1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
 }
 
 func (c *Command) Synopsis() string { return synopsis }
@@ -163,10 +258,14 @@ func (c *Command) Help() string {
 	return c.help
 }
 
-const synopsis = "Delete Kubernetes Job when complete."
+const synopsis = "Delete Kubernetes Jobs when complete."
 const help = `
-Usage: consul-k8s delete-completed-job [name] [options]
+Usage: consul-k8s delete-completed-job [options] [name...]
 
-  Waits for job to complete, then deletes it. If the job reaches its
-  backoff limit then the command will exit.
+  Waits for the named jobs and the jobs matching -label-selector to
+  complete, then deletes each of them once it succeeds. If a job fails, for
+  example because it reaches its backoff limit, then the job is kept unless
+  -retain-failed=false, the command keeps waiting for the other jobs and
+  exits with code 1. If the jobs don't all complete within -timeout then
+  the command exits with code 1 without deleting the remaining jobs.
 `
@@ -22,7 +22,15 @@ func TestRun_ArgValidation(t *testing.T) {
 	}{
 		{
 			[]string{},
-			"Must have one arg: the job name to delete.",
+			"Must have at least one arg, the names of the jobs to delete, or set flag -label-selector.",
+		},
+		{
+			[]string{"-label-selector=component=server-acl-init"},
+			"Must set flag -k8s-namespace",
+		},
+		{
+			[]string{"-k8s-namespace=default", "-label-selector=component in"},
+			"-label-selector is invalid",
 		},
 		{
 			[]string{"job-name"},
@@ -36,6 +44,10 @@ func TestRun_ArgValidation(t *testing.T) {
 			[]string{"-k8s-namespace=default", "-timeout=10jd", "job-name"},
 			"\"10jd\" is not a valid timeout",
 		},
+		{
+			[]string{"-k8s-namespace=default", "-poll-interval=0s", "job-name"},
+			"-poll-interval must be greater than 0",
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.expErr, func(t *testing.T) {
@@ -75,21 +87,50 @@ func TestRun_JobDoesNotExist(t *testing.T) {
 // Test when the job condition changes to either success or failed.
 func TestRun_JobConditionChanges(t *testing.T) {
 	t.Parallel()
+	failed := batch.JobStatus{
+		Active: 0,
+		Failed: 1,
+		Conditions: []batch.JobCondition{
+			{
+				Type:    batch.JobFailed,
+				Status:  "True",
+				Reason:  "BackoffLimitExceeded",
+				Message: "Job has reached the specified backoff limit",
+			},
+		},
+	}
 	cases := map[string]struct {
+		Flags          []string
 		EventualStatus batch.JobStatus
 		ExpDelete      bool
 		ExpCode        int
 	}{
 		"job fails": {
+			EventualStatus: failed,
+			ExpDelete:      false,
+			ExpCode:        1,
+		},
+		"job fails with -retain-failed=false": {
+			Flags:          []string{"-retain-failed=false"},
+			EventualStatus: failed,
+			ExpDelete:      true,
+			ExpCode:        1,
+		},
+		"job fails with deprecated -keep-failed=false": {
+			Flags:          []string{"-keep-failed=false"},
+			EventualStatus: failed,
+			ExpDelete:      true,
+			ExpCode:        1,
+		},
+		"job exceeds its deadline": {
 			EventualStatus: batch.JobStatus{
 				Active: 0,
-				Failed: 1,
 				Conditions: []batch.JobCondition{
 					{
 						Type:    batch.JobFailed,
 						Status:  "True",
-						Reason:  "BackoffLimitExceeded",
-						Message: "Job has reached the specified backoff limit",
+						Reason:  "DeadlineExceeded",
+						Message: "Job was active longer than specified deadline",
 					},
 				},
 			},
@@ -136,8 +177,6 @@ func TestRun_JobConditionChanges(t *testing.T) {
 			cmd := Command{
 				UI:        ui,
 				k8sClient: k8s,
-				// Set a low retry for tests.
-				retryDuration: 20 * time.Millisecond,
 			}
 			cmd.init()
 
@@ -146,10 +185,9 @@ func TestRun_JobConditionChanges(t *testing.T) {
 			done := make(chan bool)
 			var responseCode int
 			go func() {
-				responseCode = cmd.Run([]string{
-					"-k8s-namespace", ns,
-					jobName,
-				})
+				// Set a low poll interval for tests.
+				args := append([]string{"-k8s-namespace", ns, "-poll-interval=20ms"}, c.Flags...)
+				responseCode = cmd.Run(append(args, jobName))
 				close(done)
 			}()
 
@@ -191,6 +229,87 @@ func TestRun_JobConditionChanges(t *testing.T) {
 	}
 }
 
+// Test that the named jobs and the jobs matching the label selector are
+// deleted once they succeed, including jobs created after the command
+// started, while a failed job is retained without stopping the command.
+func TestRun_MultipleJobs(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ns := "default"
+	k8s := fake.NewSimpleClientset()
+	succeeded := batch.JobStatus{Succeeded: 1}
+	failed := batch.JobStatus{
+		Failed:     1,
+		Conditions: []batch.JobCondition{{Type: batch.JobFailed, Status: "True", Reason: "BackoffLimitExceeded"}},
+	}
+	hook := map[string]string{"component": "hook"}
+	createJob := func(name string, labels map[string]string, status batch.JobStatus) {
+		_, err := k8s.BatchV1().Jobs(ns).Create(
+			context.Background(),
+			&batch.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+				Status:     status,
+			},
+			metav1.CreateOptions{})
+		require.NoError(err)
+	}
+	createJob("named", nil, batch.JobStatus{Active: 1})
+	createJob("hook-succeeded", hook, succeeded)
+	createJob("hook-failed", hook, failed)
+	createJob("other", map[string]string{"component": "other"}, succeeded)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		k8sClient: k8s,
+	}
+	cmd.init()
+
+	done := make(chan bool)
+	var responseCode int
+	go func() {
+		responseCode = cmd.Run([]string{
+			"-k8s-namespace", ns,
+			"-label-selector", "component=hook",
+			"-poll-interval=20ms",
+			"named", "missing",
+		})
+		close(done)
+	}()
+
+	// Complete the named job and create another hook job after the command
+	// started.
+	time.Sleep(100 * time.Millisecond)
+	createJob("hook-late", hook, batch.JobStatus{Active: 1})
+	_, err := k8s.BatchV1().Jobs(ns).Update(
+		context.Background(),
+		&batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "named"}, Status: succeeded},
+		metav1.UpdateOptions{})
+	require.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = k8s.BatchV1().Jobs(ns).Update(
+		context.Background(),
+		&batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "hook-late", Labels: hook}, Status: succeeded},
+		metav1.UpdateOptions{})
+	require.NoError(err)
+
+	// Wait for the command to exit. It fails since a job failed.
+	select {
+	case <-done:
+		require.Equal(1, responseCode, ui.ErrorWriter.String())
+	case <-time.After(2 * time.Second):
+		require.FailNow("command did not exit after 2s")
+	}
+
+	jobs, err := k8s.BatchV1().Jobs(ns).List(context.Background(), metav1.ListOptions{})
+	require.NoError(err)
+	var remaining []string
+	for _, job := range jobs.Items {
+		remaining = append(remaining, job.Name)
+	}
+	require.ElementsMatch([]string{"hook-failed", "other"}, remaining)
+}
+
 // Test that the job times out after a certain duration.
 func TestRun_Timeout(t *testing.T) {
 	t.Parallel()
@@ -215,9 +334,8 @@ func TestRun_Timeout(t *testing.T) {
 
 	ui := cli.NewMockUi()
 	cmd := Command{
-		UI:            ui,
-		k8sClient:     k8s,
-		retryDuration: 100 * time.Millisecond,
+		UI:        ui,
+		k8sClient: k8s,
 	}
 	cmd.init()
 
@@ -227,6 +345,7 @@ func TestRun_Timeout(t *testing.T) {
 		responseCode = cmd.Run([]string{
 			"-k8s-namespace", ns,
 			"-timeout=1s",
+			"-poll-interval=100ms",
 			jobName,
 		})
 		close(done)
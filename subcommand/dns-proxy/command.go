@@ -0,0 +1,227 @@
+package dnsproxy
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+// maxUDPSize is the largest DNS message we'll read over UDP. Consul may
+// return responses larger than 512 bytes if the client advertises a larger
+// buffer using EDNS0.
+const maxUDPSize = 65535
+
+// Command is the command for running a DNS proxy that forwards queries to
+// a Consul agent.
+type Command struct {
+	UI cli.Ui
+
+	flagSet          *flag.FlagSet
+	flagListen       string
+	flagConsulDNS    string
+	flagQueryTimeout time.Duration
+	flagLogLevel     string
+
+	logger hclog.Logger
+
+	// ready is closed once the proxy is listening. It is exposed for tests.
+	ready chan struct{}
+	// udpAddr and tcpAddr are the addresses the proxy is listening on.
+	udpAddr net.Addr
+	tcpAddr net.Addr
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
+}
+
+func (c *Command) init() {
+	c.flagSet = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flagSet.StringVar(&c.flagListen, "listen", ":53",
+		"Address to listen for DNS queries on, over both UDP and TCP.")
+	c.flagSet.StringVar(&c.flagConsulDNS, "consul-dns-addr", "127.0.0.1:8600",
+		"Address and port of the Consul agent's DNS interface to forward queries to.")
+	c.flagSet.DurationVar(&c.flagQueryTimeout, "query-timeout", 2*time.Second,
+		"How long to wait for Consul to answer a query before giving up.")
+	c.flagSet.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+	flags.Merge(c.flagSet, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flagSet)
+
+	if c.ready == nil {
+		c.ready = make(chan struct{})
+	}
+
+	// Wait on an interrupt or terminate to exit. This channel must be initialized before
+	// Run() is called so that there are no race conditions where the channel
+	// is not defined.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flagSet, args); err != nil {
+		return 1
+	}
+	if len(c.flagSet.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagConsulDNS == "" {
+		c.UI.Error("-consul-dns-addr must be set")
+		return 1
+	}
+	if c.flagQueryTimeout <= 0 {
+		c.UI.Error("-query-timeout must be greater than 0")
+		return 1
+	}
+
+	var err error
+	c.logger, err = common.Logger(c.flagLogLevel)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	udpConn, err := net.ListenPacket("udp", c.flagListen)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error listening on UDP %s: %s", c.flagListen, err))
+		return 1
+	}
+	defer udpConn.Close()
+	tcpListener, err := net.Listen("tcp", c.flagListen)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error listening on TCP %s: %s", c.flagListen, err))
+		return 1
+	}
+	defer tcpListener.Close()
+	c.udpAddr = udpConn.LocalAddr()
+	c.tcpAddr = tcpListener.Addr()
+
+	go c.serveUDP(udpConn)
+	go c.serveTCP(tcpListener)
+	c.logger.Info("DNS proxy listening", "udp", c.udpAddr, "tcp", c.tcpAddr, "consul-dns-addr", c.flagConsulDNS)
+	close(c.ready)
+
+	sig := <-c.sigCh
+	c.logger.Info(fmt.Sprintf("%s received, shutting down", sig))
+	return 0
+}
+
+// serveUDP answers each UDP query by forwarding it to Consul until conn is
+// closed.
+func (c *Command) serveUDP(conn net.PacketConn) {
+	for {
+		buf := make([]byte, maxUDPSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		go func() {
+			resp, err := c.forwardUDP(buf[:n])
+			if err != nil {
+				c.logger.Warn("error forwarding UDP query", "client", addr, "err", err)
+				return
+			}
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				c.logger.Warn("error writing UDP response", "client", addr, "err", err)
+			}
+		}()
+	}
+}
+
+// forwardUDP sends the query to Consul over UDP and returns its response.
+func (c *Command) forwardUDP(query []byte) ([]byte, error) {
+	upstream, err := net.DialTimeout("udp", c.flagConsulDNS, c.flagQueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer upstream.Close()
+	if err := upstream.SetDeadline(time.Now().Add(c.flagQueryTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := upstream.Write(query); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, maxUDPSize)
+	n, err := upstream.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
+
+// serveTCP proxies each TCP connection to Consul until the listener is
+// closed.
+func (c *Command) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go c.proxyTCP(conn)
+	}
+}
+
+// proxyTCP copies the DNS messages on conn to and from Consul. DNS over TCP
+// prefixes each message with its length so the stream can be copied
+// without parsing it.
+func (c *Command) proxyTCP(conn net.Conn) {
+	defer conn.Close()
+	upstream, err := net.DialTimeout("tcp", c.flagConsulDNS, c.flagQueryTimeout)
+	if err != nil {
+		c.logger.Warn("error connecting to Consul DNS over TCP", "client", conn.RemoteAddr(), "err", err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// Unblock the copy in the other direction.
+		dst.SetReadDeadline(time.Now())
+		src.SetReadDeadline(time.Now())
+		done <- struct{}{}
+	}
+	go copyConn(upstream, conn)
+	go copyConn(conn, upstream)
+	<-done
+	<-done
+}
+
+// interrupt sends os.Interrupt signal to the command
+// so it can exit gracefully. This function is needed for tests
+func (c *Command) interrupt() {
+	c.sigCh <- os.Interrupt
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Run a DNS proxy that forwards queries to Consul."
+const help = `
+Usage: consul-k8s dns-proxy [options]
+
+  Listens for DNS queries over UDP and TCP and forwards them to the DNS
+  interface of a Consul agent. This is run as a Deployment behind a
+  Service so that cluster DNS can forward the "consul" domain to it. Use
+  the coredns-config command to configure CoreDNS to do so.
+`
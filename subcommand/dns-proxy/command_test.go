@@ -0,0 +1,204 @@
+package dnsproxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"-consul-dns-addr="},
+			expErr: "-consul-dns-addr must be set",
+		},
+		{
+			flags:  []string{"-query-timeout=0s"},
+			expErr: "-query-timeout must be greater than 0",
+		},
+		{
+			flags:  []string{"-listen=not-an-addr"},
+			expErr: "Error listening on UDP not-an-addr",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that queries over UDP and TCP are forwarded to Consul and that the
+// responses are returned to the client.
+func TestRun_ForwardsQueries(t *testing.T) {
+	t.Parallel()
+	upstreamAddr := startFakeConsulDNS(t)
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	exitCh := runCommandAsynchronously(&cmd, []string{"-listen=127.0.0.1:0", "-consul-dns-addr", upstreamAddr})
+	defer stopCommand(t, &cmd, exitCh)
+
+	select {
+	case <-cmd.ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("DNS proxy didn't start")
+	}
+
+	// UDP.
+	udpConn, err := net.Dial("udp", cmd.udpAddr.String())
+	require.NoError(t, err)
+	defer udpConn.Close()
+	require.NoError(t, udpConn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = udpConn.Write([]byte("udp-query"))
+	require.NoError(t, err)
+	resp := make([]byte, 512)
+	n, err := udpConn.Read(resp)
+	require.NoError(t, err)
+	require.Equal(t, "answer:udp-query", string(resp[:n]))
+
+	// TCP, with two queries on the same connection.
+	tcpConn, err := net.Dial("tcp", cmd.tcpAddr.String())
+	require.NoError(t, err)
+	defer tcpConn.Close()
+	require.NoError(t, tcpConn.SetDeadline(time.Now().Add(5*time.Second)))
+	for _, query := range []string{"tcp-query-1", "tcp-query-2"} {
+		require.NoError(t, writeTCPMessage(tcpConn, []byte(query)))
+		answer, err := readTCPMessage(tcpConn)
+		require.NoError(t, err)
+		require.Equal(t, "answer:"+query, string(answer))
+	}
+}
+
+// Test that a query isn't answered if Consul doesn't respond and that the
+// proxy keeps serving.
+func TestRun_ConsulUnavailable(t *testing.T) {
+	t.Parallel()
+	// Reserve a UDP port with nothing listening on it.
+	unused, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := unused.LocalAddr().String()
+	require.NoError(t, unused.Close())
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	exitCh := runCommandAsynchronously(&cmd, []string{"-listen=127.0.0.1:0", "-consul-dns-addr", addr, "-query-timeout=100ms"})
+	defer stopCommand(t, &cmd, exitCh)
+	<-cmd.ready
+
+	conn, err := net.Dial("udp", cmd.udpAddr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(500*time.Millisecond)))
+	_, err = conn.Write([]byte("query"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 512))
+	require.Error(t, err)
+}
+
+// startFakeConsulDNS starts UDP and TCP servers on the same port that answer
+// each message with "answer:" followed by the message.
+func startFakeConsulDNS(t *testing.T) string {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { tcpListener.Close() })
+	udpConn, err := net.ListenPacket("udp", tcpListener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { udpConn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udpConn.WriteTo(append([]byte("answer:"), buf[:n]...), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					msg, err := readTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					if err := writeTCPMessage(conn, append([]byte("answer:"), msg...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return tcpListener.Addr().String()
+}
+
+// writeTCPMessage writes a DNS message prefixed with its length.
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readTCPMessage reads a DNS message prefixed with its length.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, length)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// This function starts the command asynchronously and returns a non-blocking chan.
+// When finished, the command will send its exit code to the channel.
+// Note that it's the responsibility of the caller to terminate the command by calling stopCommand,
+// otherwise it can run forever.
+func runCommandAsynchronously(cmd *Command, args []string) chan int {
+	// We have to run cmd.init() to ensure that the channels are initialized
+	// before calling interrupt. Otherwise, we may be trying to interrupt
+	// before they're ready.
+	cmd.once.Do(cmd.init)
+	exitChan := make(chan int, 1)
+
+	go func() {
+		exitChan <- cmd.Run(args)
+	}()
+
+	return exitChan
+}
+
+func stopCommand(t *testing.T, cmd *Command, exitChan chan int) {
+	if len(exitChan) == 0 {
+		cmd.interrupt()
+	}
+	select {
+	case c := <-exitChan:
+		require.Equal(t, 0, c, string(cmd.UI.(*cli.MockUi).ErrorWriter.Bytes()))
+	}
+}
@@ -0,0 +1,186 @@
+package flags
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mitchellh/go-homedir"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigFileEnvVar is the environment variable that sets the path of
+	// the CLI config file when -config-file isn't set.
+	ConfigFileEnvVar = "CONSUL_K8S_CONFIG_FILE"
+
+	// configFileFlag is the flag that sets the path of the CLI config file.
+	// It isn't merged into commands that already have a -config-file flag.
+	configFileFlag = "config-file"
+
+	// defaultConfigFile is the path of the CLI config file, relative to the
+	// home directory, that is read if it exists and no other path is set.
+	defaultConfigFile = ".consul-k8s.yaml"
+)
+
+// flagEnvVars are the environment variables that set the same value as a
+// flag, in which case they take precedence over the CLI config file.
+var flagEnvVars = map[string]string{
+	"http-addr":       "CONSUL_HTTP_ADDR",
+	"token":           "CONSUL_HTTP_TOKEN",
+	"token-file":      "CONSUL_HTTP_TOKEN_FILE",
+	"ca-file":         "CONSUL_CACERT",
+	"ca-path":         "CONSUL_CAPATH",
+	"client-cert":     "CONSUL_CLIENT_CERT",
+	"client-key":      "CONSUL_CLIENT_KEY",
+	"tls-server-name": "CONSUL_TLS_SERVER_NAME",
+}
+
+// ConfigFileFlags is the -config-file flag that sets the path of the CLI
+// config file read by Parse. Commands merge it into their flag set so that
+// it's listed in their help.
+type ConfigFileFlags struct {
+	path configFilePath
+}
+
+func (f *ConfigFileFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.Var(&f.path, configFileFlag,
+		"Path to the CLI config file setting default flag values. This can also be specified via the "+
+			ConfigFileEnvVar+" environment variable. Defaults to ~/"+defaultConfigFile+" if it exists.")
+	return fs
+}
+
+// configFilePath is the value of the -config-file flag of ConfigFileFlags.
+// It's a type of its own so that Parse can tell it apart from the
+// -config-file flags that commands define for other files.
+type configFilePath string
+
+// Set implements the flag.Value interface.
+func (p *configFilePath) Set(v string) error {
+	*p = configFilePath(v)
+	return nil
+}
+
+// String implements the flag.Value interface.
+func (p *configFilePath) String() string {
+	if p == nil {
+		return ""
+	}
+	return string(*p)
+}
+
+// Parse parses args into fs, then sets the flags that weren't set by args
+// or by their environment variable to their value in the CLI config file.
+//
+// The CLI config file is a YAML map from flag names, without the leading
+// dash, to their value, or a list of values for flags that can be provided
+// multiple times. Flags that fs doesn't define are ignored so that the same
+// file can be used for every command. It's read from the -config-file flag
+// of ConfigFileFlags if fs has it, then $CONSUL_K8S_CONFIG_FILE, then
+// ~/.consul-k8s.yaml if it exists.
+func Parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var configFile string
+	if f := fs.Lookup(configFileFlag); f != nil {
+		if p, ok := f.Value.(*configFilePath); ok {
+			configFile = p.String()
+		}
+	}
+
+	path, required := "", true
+	if configFile != "" {
+		path = configFile
+	} else if env := os.Getenv(ConfigFileEnvVar); env != "" {
+		path = env
+	} else {
+		home, err := homedir.Dir()
+		if err != nil {
+			// Without a home directory there is no default config file.
+			return nil
+		}
+		path, required = filepath.Join(home, defaultConfigFile), false
+	}
+	values, err := readConfigFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading config file %s: %s", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == configFileFlag || fs.Lookup(name) == nil || set[name] {
+			continue
+		}
+		if env, ok := flagEnvVars[name]; ok && os.Getenv(env) != "" {
+			continue
+		}
+		for _, v := range values[name] {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("invalid value %q for %s in config file %s: %s", v, name, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readConfigFile returns the flag values in the config file at path.
+func readConfigFile(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	// Numbers are decoded as json.Number so that they're set as written
+	// rather than formatted as floats.
+	useNumber := func(d *json.Decoder) *json.Decoder {
+		d.UseNumber()
+		return d
+	}
+	if err := yaml.Unmarshal(data, &raw, useNumber); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				s, err := scalarValue(name, item)
+				if err != nil {
+					return nil, err
+				}
+				values[name] = append(values[name], s)
+			}
+		default:
+			s, err := scalarValue(name, v)
+			if err != nil {
+				return nil, err
+			}
+			values[name] = []string{s}
+		}
+	}
+	return values, nil
+}
+
+func scalarValue(name string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string, bool, json.Number:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("value of %s must be a string, number, boolean or a list of them", name)
+	}
+}
@@ -0,0 +1,164 @@
+package flags
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigFile = `
+http-addr: https://consul.example.com:8501
+token-file: /etc/consul/token
+k8s-namespace: consul
+retries: 3
+verbose: true
+label:
+- team=mesh
+- env=prod
+# Flags that the command doesn't define are ignored.
+unknown: value
+`
+
+func TestParse_ConfigFile(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		env    map[string]string
+		exp    map[string]string
+		expErr string
+	}{
+		"values from the file": {
+			exp: map[string]string{
+				"http-addr":     "https://consul.example.com:8501",
+				"token-file":    "/etc/consul/token",
+				"k8s-namespace": "consul",
+				"retries":       "3",
+				"verbose":       "true",
+				"label":         "team=mesh,env=prod",
+			},
+		},
+		"flags take precedence": {
+			args: []string{"-k8s-namespace=default", "-label=team=web"},
+			exp: map[string]string{
+				"http-addr":     "https://consul.example.com:8501",
+				"k8s-namespace": "default",
+				"label":         "team=web",
+			},
+		},
+		"environment variables take precedence": {
+			env: map[string]string{"CONSUL_HTTP_ADDR": "http://127.0.0.1:8500"},
+			exp: map[string]string{
+				// The flag isn't set so that the environment variable is used.
+				"http-addr":  "",
+				"token-file": "/etc/consul/token",
+			},
+		},
+		"file from environment variable": {
+			env: map[string]string{ConfigFileEnvVar: "CONFIG_FILE"},
+			exp: map[string]string{"k8s-namespace": "consul"},
+		},
+		"missing file": {
+			args:   []string{"-config-file=/does/not/exist.yaml"},
+			expErr: "error reading config file /does/not/exist.yaml: open /does/not/exist.yaml: no such file or directory",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			configFile := filepath.Join(dir, "config.yaml")
+			require.NoError(t, ioutil.WriteFile(configFile, []byte(testConfigFile), 0600))
+
+			for k, v := range c.env {
+				if v == "CONFIG_FILE" {
+					v = configFile
+				}
+				require.NoError(t, os.Setenv(k, v))
+				defer os.Unsetenv(k)
+			}
+			args := c.args
+			if c.env[ConfigFileEnvVar] == "" && c.expErr == "" {
+				args = append([]string{"-config-file", configFile}, args...)
+			}
+
+			var http HTTPFlags
+			var namespace string
+			var retries int
+			var verbose bool
+			var labels AppendSliceValue
+			fs := flag.NewFlagSet("", flag.ContinueOnError)
+			Merge(fs, http.Flags())
+			Merge(fs, new(ConfigFileFlags).Flags())
+			fs.StringVar(&namespace, "k8s-namespace", "", "")
+			fs.IntVar(&retries, "retries", 0, "")
+			fs.BoolVar(&verbose, "verbose", false, "")
+			fs.Var(&labels, "label", "")
+
+			err = Parse(fs, args)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			for name, exp := range c.exp {
+				require.Equal(t, exp, fs.Lookup(name).Value.String(), name)
+			}
+		})
+	}
+}
+
+// Test that the config file isn't read from -config-file on commands that
+// already define that flag for something else.
+func TestParse_ExistingConfigFileFlag(t *testing.T) {
+	var configFile string
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config-file", "", "")
+	require.NoError(t, Parse(fs, []string{"-config-file", "/does/not/exist.json"}))
+	require.Equal(t, "/does/not/exist.json", configFile)
+}
+
+func TestParse_InvalidConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("retries: many\n"), 0600))
+
+	var retries int
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.IntVar(&retries, "retries", 0, "")
+	Merge(fs, new(ConfigFileFlags).Flags())
+	err = Parse(fs, []string{"-config-file", configFile})
+	require.EqualError(t, err, `invalid value "many" for retries in config file `+configFile+`: parse error`)
+}
+
+// Test that the config file is read from -config-file every time the flag
+// set is parsed, as it is when a command is run more than once, and that the
+// flag is listed in the command's help.
+func TestParse_ConfigFileFlagReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	firstFile := filepath.Join(dir, "first.yaml")
+	require.NoError(t, ioutil.WriteFile(firstFile, []byte("retries: 3\n"), 0600))
+	secondFile := filepath.Join(dir, "second.yaml")
+	require.NoError(t, ioutil.WriteFile(secondFile, []byte("verbose: true\n"), 0600))
+
+	var retries int
+	var verbose bool
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.IntVar(&retries, "retries", 0, "")
+	fs.BoolVar(&verbose, "verbose", false, "")
+	Merge(fs, new(ConfigFileFlags).Flags())
+	require.Contains(t, Usage("", fs), "-config-file")
+
+	require.NoError(t, Parse(fs, []string{"-config-file", firstFile}))
+	require.Equal(t, 3, retries)
+	require.False(t, verbose)
+	require.NoError(t, Parse(fs, []string{"-config-file", secondFile}))
+	require.True(t, verbose)
+}
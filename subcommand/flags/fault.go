@@ -0,0 +1,77 @@
+package flags
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul/api"
+)
+
+// FaultFlags are developer flags used to inject faults into the calls made
+// to the Consul API so that retry and backoff behavior can be tested.
+type FaultFlags struct {
+	delay        time.Duration
+	delayPercent float64
+	errorPercent float64
+}
+
+func (f *FaultFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.DurationVar(&f.delay, "fault-consul-delay", 0,
+		"[Testing Only] How long to delay the Consul API calls selected by -fault-consul-delay-percent, "+
+			"e.g. 1ms, 2s, 3m.")
+	fs.Float64Var(&f.delayPercent, "fault-consul-delay-percent", 0,
+		"[Testing Only] Percentage of Consul API calls, from 0 to 100, to delay by -fault-consul-delay.")
+	fs.Float64Var(&f.errorPercent, "fault-consul-error-percent", 0,
+		"[Testing Only] Percentage of Consul API calls, from 0 to 100, to fail without sending them to Consul.")
+	return fs
+}
+
+// Validate returns an error if the flags are invalid.
+func (f *FaultFlags) Validate() error {
+	if f.delay < 0 {
+		return errors.New("-fault-consul-delay must not be negative")
+	}
+	if f.delayPercent < 0 || f.delayPercent > 100 {
+		return errors.New("-fault-consul-delay-percent must be between 0 and 100")
+	}
+	if f.errorPercent < 0 || f.errorPercent > 100 {
+		return errors.New("-fault-consul-error-percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// Enabled returns true if any faults will be injected.
+func (f *FaultFlags) Enabled() bool {
+	return (f.delay > 0 && f.delayPercent > 0) || f.errorPercent > 0
+}
+
+// MergeOntoConfig sets an HTTP client on c that injects the configured
+// faults. If c already has an HTTP client its transport is wrapped instead.
+// It must be called after any TLS settings have been merged onto c and does
+// nothing if no faults are configured.
+func (f *FaultFlags) MergeOntoConfig(c *api.Config) error {
+	if !f.Enabled() {
+		return nil
+	}
+	if c.HttpClient == nil {
+		transport := c.Transport
+		if transport == nil {
+			transport = api.DefaultConfig().Transport
+		}
+		httpClient, err := api.NewHttpClient(transport, c.TLSConfig)
+		if err != nil {
+			return err
+		}
+		c.HttpClient = httpClient
+	}
+	c.HttpClient.Transport = &consul.FaultInjector{
+		Transport:    c.HttpClient.Transport,
+		Delay:        f.delay,
+		DelayPercent: f.delayPercent,
+		ErrorPercent: f.errorPercent,
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package flags
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultFlags_Validate(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		expErr string
+	}{
+		"defaults": {
+			args: nil,
+		},
+		"valid": {
+			args: []string{"-fault-consul-delay=1s", "-fault-consul-delay-percent=10", "-fault-consul-error-percent=100"},
+		},
+		"negative delay": {
+			args:   []string{"-fault-consul-delay=-1s"},
+			expErr: "-fault-consul-delay must not be negative",
+		},
+		"delay percent too large": {
+			args:   []string{"-fault-consul-delay-percent=100.1"},
+			expErr: "-fault-consul-delay-percent must be between 0 and 100",
+		},
+		"negative error percent": {
+			args:   []string{"-fault-consul-error-percent=-1"},
+			expErr: "-fault-consul-error-percent must be between 0 and 100",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f FaultFlags
+			require.NoError(t, f.Flags().Parse(c.args))
+			err := f.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
+func TestFaultFlags_MergeOntoConfig(t *testing.T) {
+	cases := map[string]struct {
+		args       []string
+		expEnabled bool
+	}{
+		"disabled by default": {
+			args: nil,
+		},
+		"delay without percent is disabled": {
+			args: []string{"-fault-consul-delay=1s"},
+		},
+		"delay": {
+			args:       []string{"-fault-consul-delay=1s", "-fault-consul-delay-percent=10"},
+			expEnabled: true,
+		},
+		"errors": {
+			args:       []string{"-fault-consul-error-percent=10"},
+			expEnabled: true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f FaultFlags
+			require.NoError(t, f.Flags().Parse(c.args))
+			require.Equal(t, c.expEnabled, f.Enabled())
+
+			cfg := api.DefaultConfig()
+			require.NoError(t, f.MergeOntoConfig(cfg))
+			if !c.expEnabled {
+				require.Nil(t, cfg.HttpClient)
+				return
+			}
+			require.NotNil(t, cfg.HttpClient)
+			require.IsType(t, &consul.FaultInjector{}, cfg.HttpClient.Transport)
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package flags
 
 import (
+	"errors"
 	"flag"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/consul-k8s/consul"
 	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/http/httpproxy"
 )
 
 // Taken from https://github.com/hashicorp/consul/blob/b5b9c8d953cd3c79c6b795946839f4cf5012f507/command/flags/http.go
@@ -23,8 +28,16 @@ type HTTPFlags struct {
 	certFile      StringValue
 	keyFile       StringValue
 	tlsServerName StringValue
+	httpProxy     StringValue
+	noProxy       StringValue
+	apiTimeout    time.Duration
+	apiRetries    int
 }
 
+// consulAPIRetryWait is how long to wait before the first retry of a Consul
+// API call when -consul-api-retries is set.
+const consulAPIRetryWait = 500 * time.Millisecond
+
 func (f *HTTPFlags) Flags() *flag.FlagSet {
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	fs.Var(&f.address, "http-addr",
@@ -56,6 +69,21 @@ func (f *HTTPFlags) Flags() *flag.FlagSet {
 	fs.Var(&f.tlsServerName, "tls-server-name",
 		"The server name to use as the SNI host when connecting via TLS. This "+
 			"can also be specified via the CONSUL_TLS_SERVER_NAME environment variable.")
+	fs.Var(&f.httpProxy, "http-proxy",
+		"URL of the proxy to make Consul API calls through, e.g. http://proxy.example.com:3128. "+
+			"This overrides the HTTP_PROXY and HTTPS_PROXY environment variables, which are used if "+
+			"it isn't set.")
+	fs.Var(&f.noProxy, "no-proxy",
+		"Comma-separated list of hosts, domains, IP addresses and CIDR ranges to call directly "+
+			"instead of through the proxy, e.g. 10.0.0.0/8,.svc.cluster.local. This overrides the "+
+			"NO_PROXY environment variable, which is used if it isn't set.")
+	fs.DurationVar(&f.apiTimeout, "consul-api-timeout", 0,
+		"How long a Consul API call can take before it fails, e.g. 1ms, 2s, 3m. "+
+			"Blocking queries can take this long on top of their wait time. If 0, calls never time out.")
+	fs.IntVar(&f.apiRetries, "consul-api-retries", 0,
+		"How many times a Consul API call is retried when Consul can't be reached or responds "+
+			"with an error, waiting longer before each retry. Writes are only retried if they "+
+			"weren't sent. If 0, calls aren't retried.")
 	return fs
 }
 
@@ -79,6 +107,10 @@ func (f *HTTPFlags) SetTokenFile(v string) error {
 	return f.tokenFile.Set(v)
 }
 
+func (f *HTTPFlags) SetCAFile(v string) error {
+	return f.caFile.Set(v)
+}
+
 func (f *HTTPFlags) ReadTokenFile() (string, error) {
 	tokenFile := f.tokenFile.String()
 	if tokenFile == "" {
@@ -93,12 +125,8 @@ func (f *HTTPFlags) ReadTokenFile() (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
-func (f *HTTPFlags) APIClient() (*api.Client, error) {
-	c := api.DefaultConfig()
-
-	f.MergeOntoConfig(c)
-
-	return consul.NewClient(c)
+func (f *HTTPFlags) APITimeout() time.Duration {
+	return f.apiTimeout
 }
 
 func (f *HTTPFlags) MergeOntoConfig(c *api.Config) {
@@ -110,6 +138,65 @@ func (f *HTTPFlags) MergeOntoConfig(c *api.Config) {
 	f.certFile.Merge(&c.TLSConfig.CertFile)
 	f.keyFile.Merge(&c.TLSConfig.KeyFile)
 	f.tlsServerName.Merge(&c.TLSConfig.Address)
+	f.mergeProxyOntoConfig(c)
+}
+
+// mergeProxyOntoConfig sets the proxy of c's transport if -http-proxy or
+// -no-proxy is set. Otherwise the transport keeps using the proxy from the
+// environment.
+func (f *HTTPFlags) mergeProxyOntoConfig(c *api.Config) {
+	if f.httpProxy.v == nil && f.noProxy.v == nil {
+		return
+	}
+	proxyConfig := httpproxy.FromEnvironment()
+	if f.httpProxy.v != nil {
+		proxyConfig.HTTPProxy = f.httpProxy.String()
+		proxyConfig.HTTPSProxy = f.httpProxy.String()
+	}
+	f.noProxy.Merge(&proxyConfig.NoProxy)
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	if c.Transport == nil {
+		c.Transport = api.DefaultConfig().Transport
+	}
+	c.Transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// ProxyEnv returns the environment variables that configure the Consul CLI
+// to use the proxy set by -http-proxy and -no-proxy, since the CLI has no
+// flags for them.
+func (f *HTTPFlags) ProxyEnv() []string {
+	var env []string
+	if f.httpProxy.v != nil {
+		env = append(env, "HTTP_PROXY="+f.httpProxy.String(), "HTTPS_PROXY="+f.httpProxy.String())
+	}
+	if f.noProxy.v != nil {
+		env = append(env, "NO_PROXY="+f.noProxy.String())
+	}
+	return env
+}
+
+// MergeTimeoutOntoConfig sets an HTTP client on c that bounds each call to
+// -consul-api-timeout. It must be called after any TLS settings and faults
+// have been merged onto c and does nothing if no timeout is set.
+func (f *HTTPFlags) MergeTimeoutOntoConfig(c *api.Config) error {
+	if f.apiTimeout < 0 {
+		return errors.New("-consul-api-timeout must not be negative")
+	}
+	return consul.SetTimeout(c, f.apiTimeout)
+}
+
+// MergeRetriesOntoConfig sets an HTTP client on c that retries each call up
+// to -consul-api-retries times. It must be called after the timeout has been
+// merged onto c, so that each attempt is bounded by it, and does nothing if
+// no retries are set.
+func (f *HTTPFlags) MergeRetriesOntoConfig(c *api.Config) error {
+	if f.apiRetries < 0 {
+		return errors.New("-consul-api-retries must not be negative")
+	}
+	return consul.SetRetries(c, f.apiRetries, consulAPIRetryWait)
 }
 
 func Merge(dst, src *flag.FlagSet) {
@@ -1,8 +1,14 @@
 package flags
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,3 +22,128 @@ func TestHTTPFlagsSetToken(t *testing.T) {
 	require.NoError(f.SetToken("foo"))
 	require.Equal("foo", f.Token())
 }
+
+func TestHTTPFlagsMergeTimeoutOntoConfig(t *testing.T) {
+	cases := map[string]struct {
+		args       []string
+		expTimeout bool
+		expErr     string
+	}{
+		"disabled by default": {
+			args: nil,
+		},
+		"timeout": {
+			args:       []string{"-consul-api-timeout=30s"},
+			expTimeout: true,
+		},
+		"negative timeout": {
+			args:   []string{"-consul-api-timeout=-1s"},
+			expErr: "-consul-api-timeout must not be negative",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f HTTPFlags
+			require.NoError(t, f.Flags().Parse(c.args))
+
+			cfg := api.DefaultConfig()
+			err := f.MergeTimeoutOntoConfig(cfg)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			if !c.expTimeout {
+				require.Nil(t, cfg.HttpClient)
+				return
+			}
+			require.Equal(t, 30*time.Second, f.APITimeout())
+			require.IsType(t, &consul.TimeoutTransport{}, cfg.HttpClient.Transport)
+		})
+	}
+}
+
+func TestHTTPFlagsMergeRetriesOntoConfig(t *testing.T) {
+	cases := map[string]struct {
+		args       []string
+		expRetries bool
+		expErr     string
+	}{
+		"disabled by default": {
+			args: nil,
+		},
+		"retries": {
+			args:       []string{"-consul-api-retries=3"},
+			expRetries: true,
+		},
+		"negative retries": {
+			args:   []string{"-consul-api-retries=-1"},
+			expErr: "-consul-api-retries must not be negative",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f HTTPFlags
+			require.NoError(t, f.Flags().Parse(c.args))
+
+			cfg := api.DefaultConfig()
+			err := f.MergeRetriesOntoConfig(cfg)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			if !c.expRetries {
+				require.Nil(t, cfg.HttpClient)
+				return
+			}
+			require.IsType(t, &consul.RetryTransport{}, cfg.HttpClient.Transport)
+			require.Equal(t, 3, cfg.HttpClient.Transport.(*consul.RetryTransport).Retries)
+		})
+	}
+}
+
+func TestHTTPFlagsMergeOntoConfig_Proxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		fmt.Fprintln(w, `"leader"`)
+	}))
+	defer proxy.Close()
+
+	var f HTTPFlags
+	require.NoError(t, f.Flags().Parse([]string{
+		"-http-addr=consul.example.com:8500",
+		"-http-proxy=" + proxy.URL,
+		"-no-proxy=10.0.0.0/8,.svc.cluster.local",
+	}))
+	cfg := api.DefaultConfig()
+	f.MergeOntoConfig(cfg)
+
+	client, err := consul.NewClient(cfg)
+	require.NoError(t, err)
+	leader, err := client.Status().Leader()
+	require.NoError(t, err)
+	require.Equal(t, "leader", leader)
+	require.Equal(t, []string{"http://consul.example.com:8500/v1/status/leader"}, proxied)
+
+	for _, direct := range []string{"http://10.1.2.3:8500", "http://consul-server.consul.svc.cluster.local:8500"} {
+		req, err := http.NewRequest("GET", direct, nil)
+		require.NoError(t, err)
+		proxyURL, err := cfg.Transport.Proxy(req)
+		require.NoError(t, err)
+		require.Nil(t, proxyURL, direct)
+	}
+
+	require.Equal(t, []string{
+		"HTTP_PROXY=" + proxy.URL,
+		"HTTPS_PROXY=" + proxy.URL,
+		"NO_PROXY=10.0.0.0/8,.svc.cluster.local",
+	}, f.ProxyEnv())
+}
+
+func TestHTTPFlagsProxyEnv_Unset(t *testing.T) {
+	var f HTTPFlags
+	require.NoError(t, f.Flags().Parse(nil))
+	require.Empty(t, f.ProxyEnv())
+}
@@ -6,6 +6,7 @@ import (
 
 type K8SFlags struct {
 	kubeconfig StringValue
+	context    StringValue
 }
 
 func (f *K8SFlags) Flags() *flag.FlagSet {
@@ -14,9 +15,16 @@ func (f *K8SFlags) Flags() *flag.FlagSet {
 		"The path to a kubeconfig file to use for authentication. If this is "+
 			"blank, the default kubeconfig path (~/.kube/config) will be checked. "+
 			"If no kubeconfig is found, in-cluster auth will be used.")
+	fs.Var(&f.context, "context",
+		"The name of the kubeconfig context to use. If this is blank, the current "+
+			"context of the kubeconfig is used.")
 	return fs
 }
 
 func (f *K8SFlags) KubeConfig() string {
 	return f.kubeconfig.String()
 }
+
+func (f *K8SFlags) Context() string {
+	return f.context.String()
+}
@@ -0,0 +1,106 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/version"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// SecretLabelManagedBy is the label set to SecretManagedBy on every
+	// Secret created by consul-k8s so that they can all be found with one
+	// selector.
+	SecretLabelManagedBy = "app.kubernetes.io/managed-by"
+
+	// SecretManagedBy is the value of the SecretLabelManagedBy label.
+	SecretManagedBy = "consul-k8s"
+
+	// SecretLabelCreatedBy is the label set to the name of the command
+	// that created the Secret, e.g. "server-acl-init".
+	SecretLabelCreatedBy = "app.kubernetes.io/created-by"
+
+	// SecretLabelRelease is the label set to -release-name. It matches the
+	// label the Helm chart sets on the resources it creates.
+	SecretLabelRelease = "release"
+
+	// SecretAnnotationCreatedBy is the annotation set to the command and
+	// version of consul-k8s that created the Secret.
+	SecretAnnotationCreatedBy = "consul.hashicorp.com/created-by"
+)
+
+// SecretFlags are the flags of the commands that create Secrets, setting the
+// labels and annotations those Secrets get in addition to the ones describing
+// which command created them.
+type SecretFlags struct {
+	releaseName string
+	labels      AppendSliceValue
+}
+
+func (f *SecretFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&f.releaseName, "release-name", "",
+		"Name of the Helm release, set as the \"release\" label of the Secrets created by the command.")
+	fs.Var(&f.labels, "secret-label",
+		"Additional label to set on the Secrets created by the command, in the form key=value. "+
+			"This flag may be provided multiple times.")
+	return fs
+}
+
+// Validate returns an error if the flags are invalid.
+func (f *SecretFlags) Validate() error {
+	if f.releaseName != "" {
+		if errs := validation.IsValidLabelValue(f.releaseName); len(errs) > 0 {
+			return fmt.Errorf("-release-name=%s is invalid: %s", f.releaseName, strings.Join(errs, ", "))
+		}
+	}
+	for _, raw := range f.labels {
+		if _, _, err := parseLabel(raw); err != nil {
+			return fmt.Errorf("-secret-label=%s is invalid: %s", raw, err)
+		}
+	}
+	return nil
+}
+
+// MergeOntoObjectMeta sets the labels and annotations of a Secret created
+// by the command createdBy on meta, keeping any other labels and annotations
+// it already has. Validate must have been called first.
+func (f *SecretFlags) MergeOntoObjectMeta(meta *metav1.ObjectMeta, createdBy string) {
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	for _, raw := range f.labels {
+		if key, value, err := parseLabel(raw); err == nil {
+			meta.Labels[key] = value
+		}
+	}
+	if f.releaseName != "" {
+		meta.Labels[SecretLabelRelease] = f.releaseName
+	}
+	meta.Labels[SecretLabelManagedBy] = SecretManagedBy
+	meta.Labels[SecretLabelCreatedBy] = createdBy
+
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[SecretAnnotationCreatedBy] = fmt.Sprintf("consul-k8s %s %s", createdBy, version.GetHumanVersion())
+}
+
+// parseLabel returns the key and value of a label in the form key=value.
+func parseLabel(raw string) (string, string, error) {
+	parts := strings.SplitN(raw, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("must be in the form key=value")
+	}
+	key, value := parts[0], parts[1]
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid key: %s", strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid value: %s", strings.Join(errs, ", "))
+	}
+	return key, value, nil
+}
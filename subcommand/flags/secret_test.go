@@ -0,0 +1,72 @@
+package flags
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/version"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSecretFlags_Validate(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		expErr string
+	}{
+		"defaults": {
+			args: nil,
+		},
+		"valid": {
+			args: []string{"-release-name=consul", "-secret-label=example.com/team=mesh", "-secret-label=empty="},
+		},
+		"invalid release name": {
+			args:   []string{"-release-name=my release"},
+			expErr: "-release-name=my release is invalid: a valid label must be an empty string or consist of alphanumeric characters",
+		},
+		"label without value": {
+			args:   []string{"-secret-label=team"},
+			expErr: "-secret-label=team is invalid: must be in the form key=value",
+		},
+		"invalid label key": {
+			args:   []string{"-secret-label=-team=mesh"},
+			expErr: "-secret-label=-team=mesh is invalid: invalid key: name part must consist of alphanumeric characters",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f SecretFlags
+			require.NoError(t, f.Flags().Parse(c.args))
+			err := f.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
+
+func TestSecretFlags_MergeOntoObjectMeta(t *testing.T) {
+	var f SecretFlags
+	require.NoError(t, f.Flags().Parse([]string{"-release-name=consul", "-secret-label=team=mesh", "-secret-label=release=ignored"}))
+	require.NoError(t, f.Validate())
+
+	meta := metav1.ObjectMeta{
+		Name:        "consul-bootstrap-acl-token",
+		Labels:      map[string]string{"existing": "label"},
+		Annotations: map[string]string{"existing": "annotation"},
+	}
+	f.MergeOntoObjectMeta(&meta, "server-acl-init")
+	require.Equal(t, map[string]string{
+		"existing":                     "label",
+		"team":                         "mesh",
+		"release":                      "consul",
+		"app.kubernetes.io/managed-by": "consul-k8s",
+		"app.kubernetes.io/created-by": "server-acl-init",
+	}, meta.Labels)
+	require.Equal(t, map[string]string{
+		"existing":                        "annotation",
+		"consul.hashicorp.com/created-by": "consul-k8s server-acl-init " + version.GetHumanVersion(),
+	}, meta.Annotations)
+}
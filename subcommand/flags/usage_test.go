@@ -39,6 +39,17 @@ HTTP API Options
      is enabled. This can also be specified via the CONSUL_CLIENT_KEY
      environment variable.
 
+  -consul-api-retries=<int>
+     How many times a Consul API call is retried when Consul can't
+     be reached or responds with an error, waiting longer before each
+     retry. Writes are only retried if they weren't sent. If 0, calls
+     aren't retried.
+
+  -consul-api-timeout=<duration>
+     How long a Consul API call can take before it fails, e.g. 1ms, 2s,
+     3m. Blocking queries can take this long on top of their wait time.
+     If 0, calls never time out.
+
   -http-addr=<address>
      The $address$ and port of the Consul HTTP agent. The value can be
      an IP address or DNS address, but it must also include the port.
@@ -47,6 +58,17 @@ HTTP API Options
      can also be set to HTTPS by setting the environment variable
      CONSUL_HTTP_SSL=true.
 
+  -http-proxy=<value>
+     URL of the proxy to make Consul API calls through, e.g.
+     http://proxy.example.com:3128. This overrides the HTTP_PROXY and
+     HTTPS_PROXY environment variables, which are used if it isn't set.
+
+  -no-proxy=<value>
+     Comma-separated list of hosts, domains, IP addresses and CIDR
+     ranges to call directly instead of through the proxy, e.g.
+     10.0.0.0/8,.svc.cluster.local. This overrides the NO_PROXY
+     environment variable, which is used if it isn't set.
+
   -tls-server-name=<value>
      The server name to use as the SNI host when connecting via
      TLS. This can also be specified via the CONSUL_TLS_SERVER_NAME
@@ -1,22 +1,49 @@
 package getconsulclientca
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	"github.com/hashicorp/consul-k8s/consul"
 	godiscover "github.com/hashicorp/consul-k8s/helper/go-discover"
+	"github.com/hashicorp/consul-k8s/helper/vault"
+	"github.com/hashicorp/consul-k8s/subcommand"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-discover"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// outputFormatPEM writes only the active root certificate.
+	outputFormatPEM = "pem"
+	// outputFormatBundle writes the active root certificate followed by
+	// its intermediate certificates.
+	outputFormatBundle = "bundle"
+
+	// caFileName and chainFileName are the names of the files written to
+	// -output-dir and the keys of the -output-secret Secret. tls.crt is the
+	// file the client agents read their CA from.
+	caFileName    = "tls.crt"
+	chainFileName = "chain.crt"
+
+	secretCreatedBy = "get-consul-client-ca"
 )
 
 // get-consul-client-ca command talks to the Consul servers
@@ -25,26 +52,63 @@ import (
 type Command struct {
 	UI cli.Ui
 
-	flags *flag.FlagSet
+	flags  *flag.FlagSet
+	k8s    *flags.K8SFlags
+	secret *flags.SecretFlags
 
 	flagOutputFile      string
+	flagOutputFormat    string
+	flagOutputChainFile string
+	flagOutputDir       string
+	flagOutputSecret    string
+	flagK8sNamespace    string
 	flagServerAddr      string
 	flagServerPort      string
 	flagCAFile          string
 	flagTLSServerName   string
+	flagWatch           bool
 	flagPollingInterval time.Duration
+	flagAPITimeout      time.Duration
+	flagTimeout         time.Duration
 	flagLogLevel        string
 
-	once sync.Once
-	help string
+	flagVaultAddr            string
+	flagVaultCAFile          string
+	flagVaultTLSServerName   string
+	flagVaultNamespace       string
+	flagVaultPKIPath         string
+	flagVaultAuthMethod      string
+	flagVaultTokenFile       string
+	flagVaultAuthPath        string
+	flagVaultRole            string
+	flagVaultBearerTokenFile string
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
 
 	providers map[string]discover.Provider
+	clientset kubernetes.Interface
 }
 
 func (c *Command) init() {
 	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
 	c.flags.StringVar(&c.flagOutputFile, "output-file", "",
 		"The file path for writing the Consul client's CA certificate.")
+	c.flags.StringVar(&c.flagOutputFormat, "output-format", outputFormatPEM,
+		"The format of the CA certificate: \"pem\" for only the active root certificate or \"bundle\" "+
+			"for the active root certificate followed by its intermediate certificates.")
+	c.flags.StringVar(&c.flagOutputChainFile, "output-chain-file", "",
+		"The file path for writing the intermediate certificates of the active root, if any.")
+	c.flags.StringVar(&c.flagOutputDir, "output-dir", "",
+		"The directory to write the CA certificate to as "+caFileName+" and the intermediate "+
+			"certificates to as "+chainFileName+", the layout the Consul client agents expect.")
+	c.flags.StringVar(&c.flagOutputSecret, "output-secret", "",
+		"The name of a Kubernetes Secret to write the CA certificate to under the "+caFileName+
+			" key and the intermediate certificates to under the "+chainFileName+" key. "+
+			"The Secret is created if it doesn't exist.")
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "default",
+		"The Kubernetes namespace of the -output-secret Secret.")
 	c.flags.StringVar(&c.flagServerAddr, "server-addr", "",
 		"The address of the Consul server or the cloud auto-join string. The server must be running with TLS enabled. "+
 			"This value is required.")
@@ -55,16 +119,66 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagTLSServerName, "tls-server-name", "",
 		"The server name to set as the SNI header when sending HTTPS requests to Consul. This can also be provided via the CONSUL_TLS_SERVER_NAME environment variable instead if preferred. "+
 			"If both values are present, the flag value will be used.")
+	c.flags.DurationVar(&c.flagAPITimeout, "consul-api-timeout", 0,
+		"How long a Consul API call can take before it fails and is retried, e.g. 1ms, 2s, 3m. "+
+			"If 0, calls never time out.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 0,
+		"How long to wait for the Consul CA before exiting with exit code 2, e.g. 1ms, 2s, 3m. "+
+			"If 0, the command waits forever.")
+	c.flags.BoolVar(&c.flagWatch, "watch", false,
+		"If true, the command keeps running after writing the CA and rewrites its outputs whenever the "+
+			"active root changes, e.g. after a CA rotation, until it's interrupted. Files are replaced "+
+			"atomically so readers never see a partially written CA.")
+	c.flags.DurationVar(&c.flagPollingInterval, "polling-interval", 5*time.Minute,
+		"With -watch, how long each blocking query on the Consul servers' CA roots waits for a change, "+
+			"or how often Vault is polled when -vault-addr is set.")
+	c.flags.StringVar(&c.flagVaultAddr, "vault-addr", "",
+		"The address of a Vault server, e.g. https://vault:8200, to retrieve the CA from instead of "+
+			"the Consul servers, for when the Connect CA is Vault-backed. The CA chain of the "+
+			"-vault-pki-path PKI secrets engine is used as the active root and its intermediates.")
+	c.flags.StringVar(&c.flagVaultCAFile, "vault-ca-file", "",
+		"The path to the CA file to use when making requests to Vault.")
+	c.flags.StringVar(&c.flagVaultTLSServerName, "vault-tls-server-name", "",
+		"The server name to set as the SNI header when sending HTTPS requests to Vault.")
+	c.flags.StringVar(&c.flagVaultNamespace, "vault-namespace", "",
+		"The Vault Enterprise namespace of the PKI secrets engine and auth method.")
+	c.flags.StringVar(&c.flagVaultPKIPath, "vault-pki-path", "pki",
+		"The mount path of the Vault PKI secrets engine that is the Connect root CA.")
+	c.flags.StringVar(&c.flagVaultAuthMethod, "vault-auth-method", vault.AuthMethodToken,
+		"How to authenticate to Vault: \"token\" to use the token in -vault-token-file or the "+
+			"VAULT_TOKEN environment variable, if any, or \"kubernetes\" to log in with the "+
+			"pod's service account token using the Kubernetes auth method.")
+	c.flags.StringVar(&c.flagVaultTokenFile, "vault-token-file", "",
+		"The path to a file containing the Vault token when -vault-auth-method is \"token\".")
+	c.flags.StringVar(&c.flagVaultAuthPath, "vault-auth-path", "kubernetes",
+		"The mount path of the Vault Kubernetes auth method.")
+	c.flags.StringVar(&c.flagVaultRole, "vault-role", "",
+		"The Vault role to log in as with the Kubernetes auth method.")
+	c.flags.StringVar(&c.flagVaultBearerTokenFile, "vault-bearer-token-file", vault.DefaultBearerTokenFile,
+		"The path to the service account token to log in with using the Kubernetes auth method.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
 
+	c.k8s = &flags.K8SFlags{}
+	c.secret = &flags.SecretFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.secret.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
+
+	// Wait on an interrupt or terminate to stop watching. This channel must
+	// be initialized before Run() is called so that there are no race
+	// conditions where the channel is not defined.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
 }
 
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		return 1
 	}
 	if len(c.flags.Args()) > 0 {
@@ -72,56 +186,324 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
-	if c.flagOutputFile == "" {
-		c.UI.Error(fmt.Sprintf("-output-file must be set"))
+	if c.flagOutputFile == "" && c.flagOutputDir == "" && c.flagOutputSecret == "" {
+		c.UI.Error(fmt.Sprintf("-output-file, -output-dir or -output-secret must be set"))
+		return 1
+	}
+
+	if c.flagOutputFormat != outputFormatPEM && c.flagOutputFormat != outputFormatBundle {
+		c.UI.Error(fmt.Sprintf("-output-format must be one of %q or %q", outputFormatPEM, outputFormatBundle))
 		return 1
 	}
 
-	if c.flagServerAddr == "" {
+	if err := c.secret.Validate(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.flagVaultAddr != "" {
+		if c.flagServerAddr != "" {
+			c.UI.Error("only one of -server-addr and -vault-addr can be set")
+			return 1
+		}
+		switch c.flagVaultAuthMethod {
+		case vault.AuthMethodToken:
+		case vault.AuthMethodKubernetes:
+			if c.flagVaultRole == "" {
+				c.UI.Error(fmt.Sprintf("-vault-role must be set when -vault-auth-method is %q", vault.AuthMethodKubernetes))
+				return 1
+			}
+		default:
+			c.UI.Error(fmt.Sprintf("-vault-auth-method must be one of %q or %q", vault.AuthMethodToken, vault.AuthMethodKubernetes))
+			return 1
+		}
+	} else if c.flagServerAddr == "" {
 		c.UI.Error(fmt.Sprintf("-server-addr must be set"))
 		return 1
 	}
 
+	if c.flagAPITimeout < 0 {
+		c.UI.Error("-consul-api-timeout must not be negative")
+		return 1
+	}
+
+	if c.flagWatch {
+		if c.flagPollingInterval <= 0 {
+			c.UI.Error("-polling-interval must be positive")
+			return 1
+		}
+		// The blocking queries would time out before Consul responds.
+		if c.flagVaultAddr == "" && c.flagAPITimeout > 0 && c.flagAPITimeout <= c.flagPollingInterval {
+			c.UI.Error("-consul-api-timeout must be longer than -polling-interval with -watch")
+			return 1
+		}
+	}
+
 	logger, err := common.Logger(c.flagLogLevel)
 	if err != nil {
 		c.UI.Error(err.Error())
 		return 1
 	}
 
-	// create Consul client
-	consulClient, err := c.consulClient(logger)
-	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
-		return 1
+	// create the Consul or Vault client
+	source := "Consul"
+	var consulClient *api.Client
+	var vaultCA *vaultClient
+	if c.flagVaultAddr != "" {
+		source = "Vault"
+		vaultCA, err = c.vaultClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Vault client: %s", err))
+			return common.LogExit(logger, common.ExitCodeError, err)
+		}
+	} else {
+		consulClient, err = c.consulClient(logger)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return common.LogExit(logger, common.ExitCodeError, err)
+		}
+	}
+
+	ctx := context.Background()
+	if c.flagTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.flagTimeout)
+		defer cancel()
 	}
 
-	// Get the active CA root from Consul
+	// Get the active CA root from Consul or Vault
 	// Wait until it gets a successful response
-	var activeRoot string
-	backoff.Retry(func() error {
-		caRoots, _, err := consulClient.Agent().ConnectCARoots(nil)
+	var activeRoot *caRoot
+	var index uint64
+	err = backoff.Retry(func() error {
+		var err error
+		activeRoot, index, err = c.fetchActiveRoot(ctx, logger, consulClient, vaultCA, 0)
+		return err
+	}, backoff.WithContext(backoff.NewConstantBackOff(1*time.Second), ctx))
+	if err != nil {
+		if common.IsTLSVerificationError(err) {
+			c.UI.Error(fmt.Sprintf("Error verifying the %s server's certificate: %s", source, err))
+			return common.LogExit(logger, common.ExitCodeTLSVerificationFailed, err)
+		}
+		c.UI.Error(fmt.Sprintf("Timed out after %s waiting for the %s CA: %s", c.flagTimeout, source, err))
+		return common.LogExit(logger, common.ExitCodeTimeout, err)
+	}
+
+	written, err := c.writeOutputs(activeRoot)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return common.LogExit(logger, common.ExitCodeError, err)
+	}
+	c.UI.Info(fmt.Sprintf("Successfully wrote Consul client CA to: %s", strings.Join(written, ", ")))
+
+	if c.flagWatch {
+		return c.watch(logger, source, consulClient, vaultCA, activeRoot, index)
+	}
+	return common.LogExit(logger, 0, nil)
+}
+
+// fetchActiveRoot gets the active CA root from Consul or, if vaultCA isn't nil,
+// Vault. If waitIndex isn't 0, the Consul query blocks until the roots change
+// from that index or -polling-interval elapses. It also returns the index to
+// block on next, which is 0 for Vault.
+func (c *Command) fetchActiveRoot(ctx context.Context, logger hclog.Logger, consulClient *api.Client, vaultCA *vaultClient, waitIndex uint64) (*caRoot, uint64, error) {
+	if vaultCA != nil {
+		activeRoot, err := vaultCA.activeRoot(ctx)
 		if err != nil {
-			logger.Error("Error retrieving CA roots from Consul", "err", err)
+			logger.Error("Error retrieving CA chain from Vault", "err", err)
+			if common.IsTLSVerificationError(err) {
+				return nil, 0, backoff.Permanent(err)
+			}
+			return nil, 0, err
+		}
+		return activeRoot, 0, nil
+	}
+
+	opts := &api.QueryOptions{}
+	if waitIndex > 0 {
+		opts.WaitIndex = waitIndex
+		opts.WaitTime = c.flagPollingInterval
+	}
+	var caRoots caRootList
+	meta, err := consulClient.Raw().Query("/v1/agent/connect/ca/roots", &caRoots, opts.WithContext(ctx))
+	if err != nil {
+		logger.Error("Error retrieving CA roots from Consul", "err", err)
+		// The CA file is only read once so there's no point retrying
+		// if the server's certificate can't be verified.
+		if common.IsTLSVerificationError(err) {
+			return nil, 0, backoff.Permanent(err)
+		}
+		return nil, 0, err
+	}
+
+	activeRoot, err := getActiveRoot(&caRoots)
+	if err != nil {
+		logger.Error("Could not get an active root", "err", err)
+		return nil, 0, err
+	}
+	return activeRoot, meta.LastIndex, nil
+}
+
+// watch rewrites the outputs whenever the active root changes from current
+// until the command is interrupted. Consul is watched with blocking queries
+// from index and Vault is polled every -polling-interval.
+func (c *Command) watch(logger hclog.Logger, source string, consulClient *api.Client, vaultCA *vaultClient, current *caRoot, index uint64) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-c.sigCh:
+			logger.Info(fmt.Sprintf("%s received, shutting down", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	logger.Info(fmt.Sprintf("Watching the %s CA for changes of the active root", source))
+	for {
+		if vaultCA != nil {
+			select {
+			case <-time.After(c.flagPollingInterval):
+			case <-ctx.Done():
+				return common.LogExit(logger, 0, nil)
+			}
+		}
+
+		var activeRoot *caRoot
+		err := backoff.Retry(func() error {
+			var err error
+			activeRoot, index, err = c.fetchActiveRoot(ctx, logger, consulClient, vaultCA, index)
 			return err
+		}, backoff.WithContext(backoff.NewConstantBackOff(1*time.Second), ctx))
+		if ctx.Err() != nil {
+			return common.LogExit(logger, 0, nil)
+		}
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error verifying the %s server's certificate: %s", source, err))
+			return common.LogExit(logger, common.ExitCodeTLSVerificationFailed, err)
 		}
 
-		activeRoot, err = getActiveRoot(caRoots)
+		if activeRoot.caFile(c.flagOutputFormat) == current.caFile(c.flagOutputFormat) &&
+			activeRoot.chainFile() == current.chainFile() {
+			continue
+		}
+		logger.Info("Active root changed, rewriting the CA")
+		written, err := c.writeOutputs(activeRoot)
 		if err != nil {
-			logger.Error("Could not get an active root", "err", err)
-			return err
+			c.UI.Error(err.Error())
+			return common.LogExit(logger, common.ExitCodeError, err)
 		}
+		current = activeRoot
+		c.UI.Info(fmt.Sprintf("Successfully wrote rotated Consul client CA to: %s", strings.Join(written, ", ")))
+	}
+}
 
-		return nil
-	}, backoff.NewConstantBackOff(1*time.Second))
+// writeOutputs writes the CA and chain files of activeRoot to the outputs set
+// by the flags and returns their names.
+func (c *Command) writeOutputs(activeRoot *caRoot) ([]string, error) {
+	ca, chain := activeRoot.caFile(c.flagOutputFormat), activeRoot.chainFile()
+	var written []string
+	if c.flagOutputFile != "" {
+		if err := writeFileAtomic(c.flagOutputFile, ca); err != nil {
+			return nil, fmt.Errorf("Error writing CA file: %s", err)
+		}
+		written = append(written, c.flagOutputFile)
+	}
+	if c.flagOutputChainFile != "" {
+		if err := writeFileAtomic(c.flagOutputChainFile, chain); err != nil {
+			return nil, fmt.Errorf("Error writing chain file: %s", err)
+		}
+		written = append(written, c.flagOutputChainFile)
+	}
+	if c.flagOutputDir != "" {
+		if err := writeDir(c.flagOutputDir, ca, chain); err != nil {
+			return nil, fmt.Errorf("Error writing CA files to %s: %s", c.flagOutputDir, err)
+		}
+		written = append(written, c.flagOutputDir)
+	}
+	if c.flagOutputSecret != "" {
+		if err := c.writeSecret(ca, chain); err != nil {
+			return nil, fmt.Errorf("Error writing CA to Secret %s/%s: %s", c.flagK8sNamespace, c.flagOutputSecret, err)
+		}
+		written = append(written, fmt.Sprintf("secret/%s", c.flagOutputSecret))
+	}
+	return written, nil
+}
 
-	err = ioutil.WriteFile(c.flagOutputFile, []byte(activeRoot), 0644)
+// writeDir writes the CA and chain files to dir, creating it if needed.
+func writeDir(dir, ca, chain string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, caFileName), ca); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, chainFileName), chain)
+}
+
+// writeFileAtomic writes contents to a temporary file next to path and
+// renames it to path, so that readers see either the old or the new file.
+func writeFileAtomic(path, contents string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error writing CA file: %s", err))
-		return 1
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(contents); err != nil {
+		tmp.Close()
+		return err
 	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	c.UI.Info(fmt.Sprintf("Successfully wrote Consul client CA to: %s", c.flagOutputFile))
-	return 0
+// writeSecret creates or updates the -output-secret Secret with the CA and
+// chain files.
+func (c *Command) writeSecret(ca, chain string) error {
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			return fmt.Errorf("error retrieving Kubernetes auth: %s", err)
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("error initializing Kubernetes client: %s", err)
+		}
+	}
+
+	ctx := context.Background()
+	data := map[string][]byte{
+		caFileName:    []byte(ca),
+		chainFileName: []byte(chain),
+	}
+	secrets := c.clientset.CoreV1().Secrets(c.flagK8sNamespace)
+	secret, err := secrets.Get(ctx, c.flagOutputSecret, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: c.flagOutputSecret},
+			Data:       data,
+		}
+		c.secret.MergeOntoObjectMeta(&secret.ObjectMeta, secretCreatedBy)
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	for k, v := range data {
+		secret.Data[k] = v
+	}
+	c.secret.MergeOntoObjectMeta(&secret.ObjectMeta, secretCreatedBy)
+	_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	return err
 }
 
 // consulClient returns a Consul API client.
@@ -151,6 +533,9 @@ func (c *Command) consulClient(logger hclog.Logger) (*api.Client, error) {
 		cfg.TLSConfig.Address = c.flagTLSServerName
 	}
 
+	if err := consul.SetTimeout(cfg, c.flagAPITimeout); err != nil {
+		return nil, err
+	}
 	return consul.NewClient(cfg)
 }
 
@@ -182,25 +567,64 @@ func (c *Command) consulServerAddr(logger hclog.Logger) (string, error) {
 	return fmt.Sprintf("%s:%s", firstServer, c.flagServerPort), nil
 }
 
+// caRootList is the response of the agent's CA roots endpoint. It's decoded
+// with the raw client because api.CARoot doesn't have the intermediate
+// certificates.
+type caRootList struct {
+	Roots []caRoot
+}
+
+type caRoot struct {
+	RootCert          string
+	IntermediateCerts []string
+	Active            bool
+}
+
+// caFile returns the CA certificate in the given -output-format.
+func (r *caRoot) caFile(format string) string {
+	if format == outputFormatBundle {
+		return joinPEM(append([]string{r.RootCert}, r.IntermediateCerts...))
+	}
+	return r.RootCert
+}
+
+// chainFile returns the intermediate certificates, which is empty if there
+// are none.
+func (r *caRoot) chainFile() string {
+	return joinPEM(r.IntermediateCerts)
+}
+
+// joinPEM concatenates PEM-encoded certificates, each ending with a newline.
+func joinPEM(certs []string) string {
+	var b strings.Builder
+	for _, cert := range certs {
+		b.WriteString(cert)
+		if !strings.HasSuffix(cert, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
 // getActiveRoot returns the currently active root
 // from the roots list, otherwise returns error.
-func getActiveRoot(roots *api.CARootList) (string, error) {
+func getActiveRoot(roots *caRootList) (*caRoot, error) {
 	if roots == nil {
-		return "", fmt.Errorf("ca root list is nil")
+		return nil, fmt.Errorf("ca root list is nil")
 	}
 	if roots.Roots == nil {
-		return "", fmt.Errorf("ca roots is nil")
+		return nil, fmt.Errorf("ca roots is nil")
 	}
 	if len(roots.Roots) == 0 {
-		return "", fmt.Errorf("the list of root CAs is empty")
+		return nil, fmt.Errorf("the list of root CAs is empty")
 	}
 
-	for _, root := range roots.Roots {
-		if root.Active {
-			return root.RootCertPEM, nil
+	for i := range roots.Roots {
+		if roots.Roots[i].Active {
+			return &roots.Roots[i], nil
 		}
 	}
-	return "", fmt.Errorf("none of the roots were active")
+	return nil, fmt.Errorf("none of the roots were active")
 }
 
 func (c *Command) Synopsis() string { return synopsis }
@@ -214,6 +638,19 @@ const help = `
 Usage: consul-k8s get-consul-client-ca [options]
 
   Retrieve Consul client CA certificate by continuously polling
-  Consul servers and save it at the provided file location.
+  Consul servers and save it to -output-file, -output-dir and/or the
+  -output-secret Kubernetes Secret. If -vault-addr is set, the CA chain
+  of a Vault PKI secrets engine is retrieved instead. With -watch, the
+  command keeps running and rewrites its outputs when the active root
+  changes.
+
+  The command exits with one of the following codes and logs a final
+  "exiting" line with the exit_code and reason:
+
+    0  The CA was written to its outputs, or -watch was interrupted.
+    1  Invalid flags or any other error.
+    2  Timed out after -timeout waiting for the Consul or Vault CA.
+    3  The Consul server's certificate couldn't be verified with -ca-file,
+       or the Vault server's with -vault-ca-file.
 
 `
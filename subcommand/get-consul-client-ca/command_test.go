@@ -1,11 +1,14 @@
 package getconsulclientca
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -20,6 +23,8 @@ import (
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestRun_FlagsValidation(t *testing.T) {
@@ -30,7 +35,14 @@ func TestRun_FlagsValidation(t *testing.T) {
 	}{
 		{
 			flags:  []string{},
-			expErr: "-output-file must be set",
+			expErr: "-output-file, -output-dir or -output-secret must be set",
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-output-format=der",
+			},
+			expErr: `-output-format must be one of "pem" or "bundle"`,
 		},
 		{
 			flags: []string{
@@ -46,6 +58,49 @@ func TestRun_FlagsValidation(t *testing.T) {
 			},
 			expErr: "unknown log level: invalid-log-level",
 		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-server-addr=foo.com",
+				"-vault-addr=https://vault:8200",
+			},
+			expErr: "only one of -server-addr and -vault-addr can be set",
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-vault-addr=https://vault:8200",
+				"-vault-auth-method=approle",
+			},
+			expErr: `-vault-auth-method must be one of "token" or "kubernetes"`,
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-vault-addr=https://vault:8200",
+				"-vault-auth-method=kubernetes",
+			},
+			expErr: `-vault-role must be set when -vault-auth-method is "kubernetes"`,
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-server-addr=foo.com",
+				"-watch",
+				"-polling-interval=0s",
+			},
+			expErr: "-polling-interval must be positive",
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-server-addr=foo.com",
+				"-watch",
+				"-polling-interval=1m",
+				"-consul-api-timeout=30s",
+			},
+			expErr: "-consul-api-timeout must be longer than -polling-interval with -watch",
+		},
 	}
 
 	for _, c := range cases {
@@ -125,6 +180,88 @@ func TestRun(t *testing.T) {
 	require.Equal(t, expectedCARoot, string(actualCARoot))
 }
 
+// Test that the CA is written to -output-dir and -output-secret.
+func TestRun_OutputDirAndSecret(t *testing.T) {
+	t.Parallel()
+	outputDir, err := ioutil.TempDir("", "ca")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	caFile, certFile, keyFile, cleanup := common.GenerateServerCerts(t)
+	defer cleanup()
+
+	ui := cli.NewMockUi()
+	k8s := fake.NewSimpleClientset()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+
+	a, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.Connect = map[string]interface{}{
+			"enabled": true,
+		}
+		c.CAFile = caFile
+		c.CertFile = certFile
+		c.KeyFile = keyFile
+	})
+	require.NoError(t, err)
+	defer a.Stop()
+
+	exitCode := cmd.Run([]string{
+		"-server-addr", strings.Split(a.HTTPSAddr, ":")[0],
+		"-server-port", strings.Split(a.HTTPSAddr, ":")[1],
+		"-ca-file", caFile,
+		"-output-format", "bundle",
+		"-output-dir", filepath.Join(outputDir, "client-ca"),
+		"-output-secret", "consul-client-ca",
+		"-k8s-namespace", "consul",
+		"-release-name", "consul",
+	})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPSAddr,
+		Scheme:  "https",
+		TLSConfig: api.TLSConfig{
+			CAFile: caFile,
+		},
+	})
+	require.NoError(t, err)
+	roots, _, err := client.Agent().ConnectCARoots(nil)
+	require.NoError(t, err)
+	require.Len(t, roots.Roots, 1)
+	expectedCARoot := roots.Roots[0].RootCertPEM
+
+	// The primary datacenter's root has no intermediates, so the bundle is
+	// only the root.
+	actualCARoot, err := ioutil.ReadFile(filepath.Join(outputDir, "client-ca", "tls.crt"))
+	require.NoError(t, err)
+	require.Equal(t, expectedCARoot, string(actualCARoot))
+	chain, err := ioutil.ReadFile(filepath.Join(outputDir, "client-ca", "chain.crt"))
+	require.NoError(t, err)
+	require.Empty(t, chain)
+
+	secret, err := k8s.CoreV1().Secrets("consul").Get(context.Background(), "consul-client-ca", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, expectedCARoot, string(secret.Data["tls.crt"]))
+	require.Empty(t, secret.Data["chain.crt"])
+	require.Equal(t, "get-consul-client-ca", secret.Labels["app.kubernetes.io/created-by"])
+	require.Equal(t, "consul", secret.Labels["release"])
+}
+
+func TestCARoot_Files(t *testing.T) {
+	t.Parallel()
+	root := &caRoot{
+		RootCert:          "root\n",
+		IntermediateCerts: []string{"intermediate-1", "intermediate-2\n"},
+	}
+	require.Equal(t, "root\n", root.caFile(outputFormatPEM))
+	require.Equal(t, "root\nintermediate-1\nintermediate-2\n", root.caFile(outputFormatBundle))
+	require.Equal(t, "intermediate-1\nintermediate-2\n", root.chainFile())
+	require.Equal(t, "", (&caRoot{RootCert: "root\n"}).chainFile())
+}
+
 // Test that if the Consul server is not available at first,
 // we continue to poll it until it comes up.
 func TestRun_ConsulServerAvailableLater(t *testing.T) {
@@ -211,6 +348,63 @@ func TestRun_ConsulServerAvailableLater(t *testing.T) {
 	require.Equal(t, expectedCARoot, string(actualCARoot))
 }
 
+// Test that the command exits straight away with the TLS verification exit
+// code if the server's certificate isn't signed by -ca-file.
+func TestRun_TLSVerificationFailed(t *testing.T) {
+	t.Parallel()
+	outputFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(outputFile.Name())
+
+	caFile, certFile, keyFile, cleanup := common.GenerateServerCerts(t)
+	defer cleanup()
+	otherCAFile, _, _, otherCleanup := common.GenerateServerCerts(t)
+	defer otherCleanup()
+
+	a, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.CAFile = caFile
+		c.CertFile = certFile
+		c.KeyFile = keyFile
+	})
+	require.NoError(t, err)
+	defer a.Stop()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+	exitCode := cmd.Run([]string{
+		"-server-addr", strings.Split(a.HTTPSAddr, ":")[0],
+		"-server-port", strings.Split(a.HTTPSAddr, ":")[1],
+		"-ca-file", otherCAFile,
+		"-output-file", outputFile.Name(),
+	})
+	require.Equal(t, common.ExitCodeTLSVerificationFailed, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.ErrorWriter.String(), "Error verifying the Consul server's certificate")
+}
+
+// Test that the command exits with the timeout exit code if the servers
+// aren't reachable within -timeout.
+func TestRun_Timeout(t *testing.T) {
+	t.Parallel()
+	outputFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(outputFile.Name())
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+	exitCode := cmd.Run([]string{
+		"-server-addr", "127.0.0.1",
+		"-server-port", fmt.Sprintf("%d", freeport.MustTake(1)[0]),
+		"-output-file", outputFile.Name(),
+		"-timeout", "2s",
+	})
+	require.Equal(t, common.ExitCodeTimeout, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.ErrorWriter.String(), "Timed out after 2s waiting for the Consul CA")
+}
+
 // Test that the command checks for the active root CA
 // and only writes the active one to the output file, ignoring
 // the inactive one.
@@ -295,6 +489,107 @@ func TestRun_GetsOnlyActiveRoot(t *testing.T) {
 	require.Equal(t, expectedCARoot, string(actualCARoot))
 }
 
+// Test that with -watch the CA file is rewritten when the active root
+// changes until the command is interrupted.
+func TestRun_Watch(t *testing.T) {
+	t.Parallel()
+	outputDir, err := ioutil.TempDir("", "ca")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+	outputFile := filepath.Join(outputDir, "ca.pem")
+
+	caFile, certFile, keyFile, cleanup := common.GenerateServerCerts(t)
+	defer cleanup()
+
+	a, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.Connect = map[string]interface{}{
+			"enabled": true,
+		}
+		c.CAFile = caFile
+		c.CertFile = certFile
+		c.KeyFile = keyFile
+	})
+	require.NoError(t, err)
+	defer a.Stop()
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPSAddr,
+		Scheme:  "https",
+		TLSConfig: api.TLSConfig{
+			CAFile: caFile,
+		},
+	})
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+	cmd.init()
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-server-addr", strings.Split(a.HTTPSAddr, ":")[0],
+			"-server-port", strings.Split(a.HTTPSAddr, ":")[1],
+			"-ca-file", caFile,
+			"-output-file", outputFile,
+			"-watch",
+			"-polling-interval", "1s",
+		})
+	}()
+
+	// activeRoot returns the active root cert of the Consul CA.
+	activeRoot := func(r *retry.R) string {
+		roots, _, err := client.Agent().ConnectCARoots(nil)
+		require.NoError(r, err)
+		for _, root := range roots.Roots {
+			if root.Active {
+				return root.RootCertPEM
+			}
+		}
+		r.Fatal("no active root")
+		return ""
+	}
+	retry.Run(t, func(r *retry.R) {
+		actual, err := ioutil.ReadFile(outputFile)
+		require.NoError(r, err)
+		require.Equal(r, activeRoot(r), string(actual))
+	})
+
+	// Rotate the CA.
+	ca, key := generateCA(t)
+	retry.Run(t, func(r *retry.R) {
+		_, err = client.Connect().CASetConfig(&api.CAConfig{
+			Provider: "consul",
+			Config: map[string]interface{}{
+				"RootCert":   ca,
+				"PrivateKey": key,
+			},
+		}, nil)
+		require.NoError(r, err)
+	})
+	retry.Run(t, func(r *retry.R) {
+		expected := activeRoot(r)
+		require.Equal(r, strings.TrimSpace(ca), strings.TrimSpace(expected))
+		actual, err := ioutil.ReadFile(outputFile)
+		require.NoError(r, err)
+		require.Equal(r, expected, string(actual))
+	})
+
+	// The temporary files were renamed.
+	files, err := ioutil.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	cmd.sigCh <- syscall.SIGINT
+	select {
+	case exitCode := <-exitCh:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't exit after being interrupted")
+	}
+}
+
 // Test that when using cloud auto-join
 // it uses the provider to get the address of the server
 func TestRun_WithProvider(t *testing.T) {
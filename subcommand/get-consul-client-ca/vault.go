@@ -0,0 +1,78 @@
+package getconsulclientca
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/helper/vault"
+)
+
+// vaultClient retrieves the CA chain of a Vault PKI secrets engine.
+type vaultClient struct {
+	*vault.Client
+	pkiPath string
+}
+
+// vaultClient returns a client for the Vault server at -vault-addr.
+func (c *Command) vaultClient() (*vaultClient, error) {
+	client, err := vault.NewClient(vault.Config{
+		Addr:            c.flagVaultAddr,
+		CAFile:          c.flagVaultCAFile,
+		TLSServerName:   c.flagVaultTLSServerName,
+		Namespace:       c.flagVaultNamespace,
+		AuthMethod:      c.flagVaultAuthMethod,
+		TokenFile:       c.flagVaultTokenFile,
+		AuthPath:        c.flagVaultAuthPath,
+		Role:            c.flagVaultRole,
+		BearerTokenFile: c.flagVaultBearerTokenFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &vaultClient{Client: client, pkiPath: strings.Trim(c.flagVaultPKIPath, "/")}, nil
+}
+
+// activeRoot returns the CA chain of the PKI secrets engine as a caRoot: the
+// last certificate of the chain is the root and the others, starting with
+// the engine's own CA certificate, are its intermediates.
+func (v *vaultClient) activeRoot(ctx context.Context) (*caRoot, error) {
+	chain, err := v.Get(ctx, fmt.Sprintf("/v1/%s/ca_chain", v.pkiPath))
+	if err != nil {
+		return nil, err
+	}
+	certs := splitPEM(chain)
+	// Root CAs have no chain in older versions of Vault, in which case the
+	// CA certificate is all there is.
+	if len(certs) == 0 {
+		ca, err := v.Get(ctx, fmt.Sprintf("/v1/%s/ca/pem", v.pkiPath))
+		if err != nil {
+			return nil, err
+		}
+		certs = splitPEM(ca)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("the PKI secrets engine at %s has no CA certificate", v.pkiPath)
+	}
+	return &caRoot{
+		RootCert:          certs[len(certs)-1],
+		IntermediateCerts: certs[:len(certs)-1],
+		Active:            true,
+	}, nil
+}
+
+// splitPEM returns each certificate of PEM-encoded data.
+func splitPEM(data []byte) []string {
+	var certs []string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, string(pem.EncodeToMemory(block)))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package getconsulclientca
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the CA chain of the PKI secrets engine is retrieved from Vault
+// with each auth method.
+func TestRun_Vault(t *testing.T) {
+	t.Parallel()
+	rootCA, _ := generateCA(t)
+	intermediateCA, _ := generateCA(t)
+
+	cases := map[string]struct {
+		flags    []string
+		env      string
+		expToken string
+	}{
+		"token file": {
+			flags:    []string{"-vault-token-file", "token"},
+			expToken: "file-token",
+		},
+		"token env": {
+			env:      "env-token",
+			expToken: "env-token",
+		},
+		"kubernetes": {
+			flags: []string{
+				"-vault-auth-method", "kubernetes",
+				"-vault-auth-path", "k8s",
+				"-vault-role", "consul-client",
+				"-vault-bearer-token-file", "jwt",
+			},
+			expToken: "login-token",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "vault")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "jwt"), []byte("service-account-jwt"), 0600))
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/auth/k8s/login":
+					var body map[string]string
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					require.Equal(t, map[string]string{"role": "consul-client", "jwt": "service-account-jwt"}, body)
+					w.Write([]byte(`{"auth": {"client_token": "login-token"}}`))
+				case "/v1/connect-root/ca_chain":
+					require.Equal(t, c.expToken, r.Header.Get("X-Vault-Token"))
+					require.Equal(t, "ns1", r.Header.Get("X-Vault-Namespace"))
+					w.Write([]byte(intermediateCA + rootCA))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			// The CA and token files are relative to the test's directory.
+			for i, flag := range c.flags {
+				if flag == "token" || flag == "jwt" {
+					c.flags[i] = filepath.Join(dir, flag)
+				}
+			}
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+			}
+			if c.env != "" {
+				os.Setenv("VAULT_TOKEN", c.env)
+				defer os.Unsetenv("VAULT_TOKEN")
+			}
+			exitCode := cmd.Run(append([]string{
+				"-vault-addr", server.URL,
+				"-vault-namespace", "ns1",
+				"-vault-pki-path", "connect-root",
+				"-output-dir", dir,
+				"-output-format", "bundle",
+			}, c.flags...))
+			require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+			ca, err := ioutil.ReadFile(filepath.Join(dir, caFileName))
+			require.NoError(t, err)
+			require.Equal(t, rootCA+intermediateCA, string(ca))
+			chain, err := ioutil.ReadFile(filepath.Join(dir, chainFileName))
+			require.NoError(t, err)
+			require.Equal(t, intermediateCA, string(chain))
+		})
+	}
+}
+
+// Test that the CA certificate is used if the PKI secrets engine has no CA
+// chain, like root CAs in older versions of Vault.
+func TestRun_VaultNoCAChain(t *testing.T) {
+	t.Parallel()
+	outputFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(outputFile.Name())
+	rootCA, _ := generateCA(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/pki/ca_chain":
+		case "/v1/pki/ca/pem":
+			w.Write([]byte(rootCA))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+	exitCode := cmd.Run([]string{
+		"-vault-addr", server.URL,
+		"-output-file", outputFile.Name(),
+	})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	ca, err := ioutil.ReadFile(outputFile.Name())
+	require.NoError(t, err)
+	require.Equal(t, rootCA, string(ca))
+}
+
+// Test that the command exits straight away with the TLS verification exit
+// code if Vault's certificate isn't signed by -vault-ca-file.
+func TestRun_VaultTLSVerificationFailed(t *testing.T) {
+	t.Parallel()
+	outputFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(outputFile.Name())
+	otherCAFile, _, _, cleanup := common.GenerateServerCerts(t)
+	defer cleanup()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+	exitCode := cmd.Run([]string{
+		"-vault-addr", server.URL,
+		"-vault-ca-file", otherCAFile,
+		"-output-file", outputFile.Name(),
+	})
+	require.Equal(t, common.ExitCodeTLSVerificationFailed, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.ErrorWriter.String(), "Error verifying the Vault server's certificate")
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -21,16 +22,21 @@ import (
 	"github.com/hashicorp/consul-k8s/consul"
 	"github.com/hashicorp/consul-k8s/helper/cert"
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/helper/dashboard"
+	"github.com/hashicorp/consul-k8s/helper/servicemeta"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
 )
 
 type Command struct {
@@ -46,29 +52,59 @@ type Command struct {
 	flagEnvoyImage           string // Docker image for Envoy
 	flagConsulK8sImage       string // Docker image for consul-k8s
 	flagACLAuthMethod        string // Auth Method to use for ACLs, if enabled
+	flagACLSAValidation      string // Whether pods whose ServiceAccount doesn't match their service are rejected
 	flagWriteServiceDefaults bool   // True to enable central config injection
 	flagDefaultProtocol      string // Default protocol for use with central config
 	flagConsulCACert         string // [Deprecated] Path to CA Certificate to use when communicating with Consul clients
 	flagEnvoyExtraArgs       string // Extra envoy args when starting envoy
+	flagBootstrapOverrides   string // JSON object merged into the Envoy bootstrap
+	flagDashboardURLTemplate string // Template for links to the Kubernetes dashboard added to service meta
+	flagNSImagesConfigMap    string // <namespace>/<name> of the ConfigMap of the images to use per namespace
 	flagLogLevel             string
+	flagDryRun               bool // Mutate a pod read from stdin and print it instead of serving
+	flagEnableOpenShift      bool // Run the injected containers as the user IDs OpenShift assigns to namespaces
+	flagEnableNSDefaults     bool // Use the injection annotations of namespaces as defaults for their pods
+
+	// Flags to configure the tracing of the sidecar proxies
+	flagTracingProvider      string  // Tracer of the sidecar proxies
+	flagTracingCollectorAddr string  // Address of the collector the sidecar proxies send traces to
+	flagTracingSampleRate    float64 // Percentage of requests the sidecar proxies trace
+
+	// Flags to configure the metrics of the sidecar proxies
+	flagDefaultEnableMetrics        bool   // Expose the metrics of the sidecar proxies to Prometheus
+	flagDefaultEnableMetricsMerging bool   // Merge the metrics of the sidecar proxies with the services'
+	flagDefaultMergedMetricsPort    int    // Port the sidecar proxies serve their metrics on when merging
+	flagDefaultPrometheusScrapePort int    // Port Prometheus scrapes the metrics on
+	flagDefaultPrometheusScrapePath string // Path Prometheus scrapes the metrics on
 
 	// Flags to support namespaces
 	flagEnableNamespaces           bool     // Use namespacing on all components
 	flagConsulDestinationNamespace string   // Consul namespace to register everything if not mirroring
 	flagAllowK8sNamespacesList     []string // K8s namespaces to explicitly inject
 	flagDenyK8sNamespacesList      []string // K8s namespaces to deny injection (has precedence)
+	flagMetaLabelPrefixes          []string // Prefixes of the labels to copy into service meta
+	flagMetaAnnotationPrefixes     []string // Prefixes of the annotations to copy into service meta
 	flagEnableK8SNSMirroring       bool     // Enables mirroring of k8s namespaces into Consul
 	flagK8SNSMirroringPrefix       string   // Prefix added to Consul namespaces created when mirroring
 	flagCrossNamespaceACLPolicy    string   // The name of the ACL policy to add to every created namespace if ACLs are enabled
 
+	// Flags to support admin partitions
+	flagEnablePartitions bool   // Register everything in an admin partition
+	flagPartitionName    string // Name of the admin partition of this cluster
+
 	// Flags to enable connect-inject health checks.
 	flagEnableHealthChecks          bool          // Start the health check controller.
 	flagHealthChecksReconcilePeriod time.Duration // Period for health check reconcile.
+	flagEnableTopologyMeta          bool          // Set the node's zone and region in the service meta.
 
 	// Flags for cleanup controller.
 	flagEnableCleanupController          bool          // Start the cleanup controller.
 	flagCleanupControllerReconcilePeriod time.Duration // Period for cleanup controller reconcile.
 
+	flagMetricsListen string // Address to serve Prometheus metrics on
+
+	flagEnvoyDrainTime time.Duration // How long the sidecar proxies drain when their pod is deleted
+
 	// Proxy resource settings.
 	flagDefaultSidecarProxyCPULimit      string
 	flagDefaultSidecarProxyCPURequest    string
@@ -89,10 +125,15 @@ type Command struct {
 
 	flagSet *flag.FlagSet
 	http    *flags.HTTPFlags
+	fault   *flags.FaultFlags
 
 	consulClient *api.Client
 	clientset    kubernetes.Interface
 
+	// stdin is where the pod is read from in dry-run mode. It defaults to
+	// os.Stdin and is exposed for setting in tests.
+	stdin io.Reader
+
 	sigCh chan os.Signal
 	once  sync.Once
 	help  string
@@ -119,8 +160,54 @@ func (c *Command) init() {
 		"Docker image for consul-k8s. Used for the connect sidecar.")
 	c.flagSet.StringVar(&c.flagEnvoyExtraArgs, "envoy-extra-args", "",
 		"Extra envoy command line args to be set when starting envoy (e.g \"--log-level debug --disable-hot-restart\").")
+	c.flagSet.DurationVar(&c.flagEnvoyDrainTime, "default-envoy-drain-time", 0,
+		"How long the sidecar proxies gracefully drain their inbound connections when their pod is deleted, "+
+			"e.g. \"30s\". The pods' termination grace period is raised to cover it. Pods can override it with "+
+			"the consul.hashicorp.com/envoy-drain-time annotation. The proxies don't drain if 0.")
+	c.flagSet.StringVar(&c.flagBootstrapOverrides, "bootstrap-config-overrides", "",
+		"JSON object merged into the Envoy bootstrap of the sidecar proxies, e.g. to configure stats sinks or the "+
+			"overload manager. Objects are merged, lists are appended to and other values are replaced. Pods can "+
+			"override it with the consul.hashicorp.com/envoy-bootstrap-config-overrides annotation.")
+	c.flagSet.StringVar(&c.flagTracingProvider, "envoy-tracing-provider", "",
+		"The tracer the sidecar proxies send traces with: \"zipkin\", \"jaeger\" (using the collector's Zipkin "+
+			"endpoint) or \"datadog\". Tracing is disabled if empty. Pods can override it with the "+
+			"consul.hashicorp.com/envoy-tracing-provider annotation.")
+	c.flagSet.StringVar(&c.flagTracingCollectorAddr, "envoy-tracing-collector-addr", "",
+		"The <host>:<port> address of the collector the sidecar proxies send traces to, e.g. "+
+			"\"zipkin.monitoring:9411\". Pods can override it with the "+
+			"consul.hashicorp.com/envoy-tracing-collector-address annotation.")
+	c.flagSet.Float64Var(&c.flagTracingSampleRate, "envoy-tracing-sample-rate", 0,
+		"The percentage of requests the sidecar proxies trace, from 0 to 100. If 0, only requests whose "+
+			"trace headers ask for it are traced. Pods can override it with the "+
+			"consul.hashicorp.com/envoy-tracing-sample-rate annotation.")
+	c.flagSet.BoolVar(&c.flagDefaultEnableMetrics, "default-enable-metrics", false,
+		"Expose the metrics of the sidecar proxies on -default-prometheus-scrape-port and annotate the pods "+
+			"with prometheus.io/scrape, prometheus.io/port and prometheus.io/path. Pods can override it with the "+
+			"consul.hashicorp.com/enable-metrics annotation.")
+	c.flagSet.BoolVar(&c.flagDefaultEnableMetricsMerging, "default-enable-metrics-merging", false,
+		"Serve the metrics of the sidecar proxies together with the metrics of the services from the "+
+			"consul-sidecar container, so that Prometheus scrapes both on one port and path. Pods can override "+
+			"it with the consul.hashicorp.com/enable-metrics-merging annotation.")
+	c.flagSet.IntVar(&c.flagDefaultMergedMetricsPort, "default-merged-metrics-port", 20100,
+		"The port on localhost that the sidecar proxies serve their metrics on to the consul-sidecar "+
+			"container when merging. Pods can override it with the "+
+			"consul.hashicorp.com/merged-metrics-port annotation.")
+	c.flagSet.IntVar(&c.flagDefaultPrometheusScrapePort, "default-prometheus-scrape-port", 20200,
+		"The port that Prometheus scrapes the metrics of the pods on. Pods can override it with the "+
+			"consul.hashicorp.com/prometheus-scrape-port annotation.")
+	c.flagSet.StringVar(&c.flagDefaultPrometheusScrapePath, "default-prometheus-scrape-path", "/metrics",
+		"The path that Prometheus scrapes the metrics of the pods on. It can only differ from /metrics "+
+			"when merging. Pods can override it with the consul.hashicorp.com/prometheus-scrape-path annotation.")
+	c.flagSet.StringVar(&c.flagDashboardURLTemplate, "dashboard-url-template", "",
+		"Go template for a link to the Kubernetes dashboard that is added to the meta of each service registration "+
+			"under the \"k8s-dashboard-url\" key. The template is rendered with .Namespace, .Name (the pod's name) "+
+			"and .ServiceName, e.g. \"https://dashboard.example.com/#/pod/{{.Namespace}}/{{.Name}}\".")
 	c.flagSet.StringVar(&c.flagACLAuthMethod, "acl-auth-method", "",
 		"The name of the Kubernetes Auth Method to use for connectInjection if ACLs are enabled.")
+	c.flagSet.StringVar(&c.flagACLSAValidation, "acl-service-account-validation", connectinject.ServiceAccountValidationStrict,
+		"When ACLs are enabled, whether pods whose ServiceAccount name doesn't match their service name are "+
+			"rejected (\"strict\") or injected with a warning (\"permissive\"), e.g. when the auth method's "+
+			"binding rules don't bind service identities to ServiceAccount names.")
 	c.flagSet.BoolVar(&c.flagWriteServiceDefaults, "enable-central-config", false,
 		"Write a service-defaults config for every Connect service using protocol from -default-protocol or Pod annotation.")
 	c.flagSet.StringVar(&c.flagDefaultProtocol, "default-protocol", "",
@@ -131,12 +218,24 @@ func (c *Command) init() {
 		"K8s namespaces to explicitly allow. May be specified multiple times.")
 	c.flagSet.Var((*flags.AppendSliceValue)(&c.flagDenyK8sNamespacesList), "deny-k8s-namespace",
 		"K8s namespaces to explicitly deny. Takes precedence over allow. May be specified multiple times.")
+	c.flagSet.Var((*flags.AppendSliceValue)(&c.flagMetaLabelPrefixes), "meta-label-prefix",
+		"Copy the pod's labels that start with this prefix into the meta of its registrations, "+
+			"replacing characters that aren't allowed in meta keys with underscores. May be specified multiple times.")
+	c.flagSet.Var((*flags.AppendSliceValue)(&c.flagMetaAnnotationPrefixes), "meta-annotation-prefix",
+		"Copy the pod's annotations that start with this prefix into the meta of its registrations, "+
+			"replacing characters that aren't allowed in meta keys with underscores. May be specified multiple times.")
 	c.flagSet.BoolVar(&c.flagEnableHealthChecks, "enable-health-checks-controller", false,
 		"Enables health checks controller.")
 	c.flagSet.DurationVar(&c.flagHealthChecksReconcilePeriod, "health-checks-reconcile-period", 1*time.Minute, "Reconcile period for health checks controller.")
+	c.flagSet.BoolVar(&c.flagEnableTopologyMeta, "enable-topology-meta", false,
+		fmt.Sprintf("If true, the health checks controller sets the %q and %q meta of the services of the pods "+
+			"to the topology.kubernetes.io/zone and topology.kubernetes.io/region labels of their node. "+
+			"Requires -enable-health-checks-controller.", connectinject.MetaKeyZone, connectinject.MetaKeyRegion))
 	c.flagSet.BoolVar(&c.flagEnableCleanupController, "enable-cleanup-controller", true,
 		"Enables cleanup controller that cleans up stale Consul service instances.")
 	c.flagSet.DurationVar(&c.flagCleanupControllerReconcilePeriod, "cleanup-controller-reconcile-period", 5*time.Minute, "Reconcile period for cleanup controller.")
+	c.flagSet.StringVar(&c.flagMetricsListen, "metrics-listen", "",
+		"Address to serve Prometheus metrics on over HTTP at /metrics, e.g. \":9102\". Metrics aren't served if empty.")
 	c.flagSet.BoolVar(&c.flagEnableNamespaces, "enable-namespaces", false,
 		"[Enterprise Only] Enables namespaces, in either a single Consul namespace or mirrored.")
 	c.flagSet.StringVar(&c.flagConsulDestinationNamespace, "consul-destination-namespace", "default",
@@ -149,6 +248,29 @@ func (c *Command) init() {
 	c.flagSet.StringVar(&c.flagCrossNamespaceACLPolicy, "consul-cross-namespace-acl-policy", "",
 		"[Enterprise Only] Name of the ACL policy to attach to all created Consul namespaces to allow service "+
 			"discovery across Consul namespaces. Only necessary if ACLs are enabled.")
+	c.flagSet.BoolVar(&c.flagEnablePartitions, "enable-partitions", false,
+		"[Enterprise Only] Enables admin partitions. Services and proxies are registered in the partition set by "+
+			"'-partition-name' and log in with its auth method. Requires '-enable-namespaces'.")
+	c.flagSet.StringVar(&c.flagPartitionName, "partition-name", consul.DefaultPartition,
+		"[Enterprise Only] Name of the admin partition of this Kubernetes cluster. Only used if "+
+			"'-enable-partitions' is true.")
+	c.flagSet.BoolVar(&c.flagDryRun, "dry-run", false,
+		"Read a pod manifest (YAML or JSON) from stdin, print the pod as it would be "+
+			"mutated by the injector and exit. No Kubernetes cluster or Consul agent is required. "+
+			"Validation that requires Consul, such as checking the mesh gateway mode for upstreams "+
+			"in other datacenters, is skipped and a warning is printed instead.")
+	c.flagSet.BoolVar(&c.flagEnableOpenShift, "enable-openshift", false,
+		"Run the injected containers without privileges and as the first user ID of the range in the "+
+			"openshift.io/sa.scc.uid-range annotation of the pod's namespace, so that pods are admitted "+
+			"by OpenShift's restricted SCC. Requires permission to get namespaces.")
+	c.flagSet.BoolVar(&c.flagEnableNSDefaults, "enable-namespace-defaults", false,
+		"Use the connect-inject, sidecar-proxy resource, envoy-extra-args and envoy-tracing annotations set on the "+
+			"pod's namespace as defaults for the pods that don't set them. Requires permission to get namespaces.")
+	c.flagSet.StringVar(&c.flagNSImagesConfigMap, "namespace-images-config-map", "",
+		"<namespace>/<name> of a ConfigMap that overrides -consul-image and -envoy-image for the pods of some "+
+			"namespaces. Each key is a namespace and its value is YAML with consulImage and envoyImage keys. "+
+			"The ConfigMap is watched so changes apply to the pods injected afterwards. Ignored with -dry-run. "+
+			"Requires permission to list and watch ConfigMaps in its namespace.")
 	c.flagSet.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
@@ -172,10 +294,18 @@ func (c *Command) init() {
 	c.flagSet.StringVar(&c.flagConsulSidecarMemoryLimit, "consul-sidecar-memory-limit", "50Mi", "Consul sidecar memory limit.")
 
 	c.http = &flags.HTTPFlags{}
+	c.fault = &flags.FaultFlags{}
 
 	flags.Merge(c.flagSet, c.http.Flags())
+	flags.Merge(c.flagSet, c.fault.Flags())
+	flags.Merge(c.flagSet, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flagSet)
 
+	// Read the pod from stdin in dry-run mode unless a reader was set in tests.
+	if c.stdin == nil {
+		c.stdin = os.Stdin
+	}
+
 	// Wait on an interrupt or terminate for exit, be sure to init it before running
 	// the controller so that we don't receive an interrupt before it's ready.
 	if c.sigCh == nil {
@@ -186,11 +316,15 @@ func (c *Command) init() {
 
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flagSet.Parse(args); err != nil {
+	if err := flags.Parse(c.flagSet, args); err != nil {
 		return 1
 	}
 
 	// Validate flags.
+	if err := c.fault.Validate(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 	if c.flagConsulK8sImage == "" {
 		c.UI.Error("-consul-k8s-image must be set")
 		return 1
@@ -211,6 +345,73 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error("-default-protocol is no longer supported")
 		return 1
 	}
+	if c.flagEnvoyDrainTime < 0 {
+		c.UI.Error("-default-envoy-drain-time must not be negative")
+		return 1
+	}
+	var nsImagesNamespace, nsImagesName string
+	if c.flagNSImagesConfigMap != "" {
+		parts := strings.Split(c.flagNSImagesConfigMap, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			c.UI.Error("-namespace-images-config-map must be in the form <namespace>/<name>")
+			return 1
+		}
+		nsImagesNamespace, nsImagesName = parts[0], parts[1]
+	}
+	if c.flagACLSAValidation != connectinject.ServiceAccountValidationStrict &&
+		c.flagACLSAValidation != connectinject.ServiceAccountValidationPermissive {
+		c.UI.Error(fmt.Sprintf("-acl-service-account-validation must be %q or %q",
+			connectinject.ServiceAccountValidationStrict, connectinject.ServiceAccountValidationPermissive))
+		return 1
+	}
+	if c.flagEnablePartitions && !c.flagEnableNamespaces {
+		c.UI.Error("-enable-partitions requires -enable-namespaces")
+		return 1
+	}
+	if c.flagEnablePartitions && c.flagPartitionName == "" {
+		c.UI.Error("-partition-name must be set if -enable-partitions is true")
+		return 1
+	}
+	var consulPartition string
+	if c.flagEnablePartitions {
+		consulPartition = c.flagPartitionName
+	}
+	if c.flagEnableTopologyMeta && !c.flagEnableHealthChecks {
+		c.UI.Error("-enable-topology-meta requires -enable-health-checks-controller")
+		return 1
+	}
+	if c.flagTracingProvider != "" {
+		if err := connectinject.ValidateEnvoyTracing(c.flagTracingProvider, c.flagTracingCollectorAddr, c.flagTracingSampleRate); err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+	if c.flagBootstrapOverrides != "" {
+		if err := connectinject.ValidateBootstrapConfigOverrides(c.flagBootstrapOverrides); err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+	// Pods can enable metrics whatever the default, so the defaults for
+	// them are always validated.
+	if err := connectinject.ValidateEnvoyMetrics(c.flagDefaultEnableMetricsMerging, c.flagDefaultMergedMetricsPort,
+		c.flagDefaultPrometheusScrapePort, c.flagDefaultPrometheusScrapePath); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	metaPropagator := &servicemeta.Propagator{
+		LabelPrefixes:      c.flagMetaLabelPrefixes,
+		AnnotationPrefixes: c.flagMetaAnnotationPrefixes,
+	}
+	var dashboardURLTemplate *dashboard.URLTemplate
+	if c.flagDashboardURLTemplate != "" {
+		var err error
+		dashboardURLTemplate, err = dashboard.ParseURLTemplate(c.flagDashboardURLTemplate)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("-dashboard-url-template is invalid: %s", err))
+			return 1
+		}
+	}
 
 	logger, err := common.Logger(c.flagLogLevel)
 	if err != nil {
@@ -269,8 +470,9 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
-	// We must have an in-cluster K8S client
-	if c.clientset == nil {
+	// We must have an in-cluster K8S client unless we're running locally in
+	// dry-run mode.
+	if c.clientset == nil && !c.flagDryRun {
 		config, err := rest.InClusterConfig()
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error loading in-cluster K8S config: %s", err))
@@ -288,6 +490,7 @@ func (c *Command) Run(args []string) int {
 	c.http.MergeOntoConfig(cfg)
 	if cfg.TLSConfig.CAFile == "" && c.flagConsulCACert != "" {
 		cfg.TLSConfig.CAFile = c.flagConsulCACert
+		c.http.SetCAFile(c.flagConsulCACert)
 	}
 	consulURLRaw := cfg.Address
 	// cfg.Address may or may not be prefixed with scheme.
@@ -311,16 +514,81 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
-	// Set up Consul client
-	if c.consulClient == nil {
+	// Set up Consul client. In dry-run mode no Consul agent is expected to be
+	// reachable so the handler runs without a client.
+	if c.consulClient == nil && !c.flagDryRun {
 		var err error
-		c.consulClient, err = consul.NewClient(cfg)
+		c.consulClient, err = common.NewPartitionConsulClient(c.http, c.fault, consulPartition)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 			return 1
 		}
 	}
 
+	// Convert allow/deny lists to sets
+	allowK8sNamespaces := flags.ToSet(c.flagAllowK8sNamespacesList)
+	denyK8sNamespaces := flags.ToSet(c.flagDenyK8sNamespacesList)
+
+	// The images ConfigMap is watched once the server starts.
+	var nsImages *connectinject.NamespaceImagesResource
+	if nsImagesName != "" && !c.flagDryRun {
+		nsImages = &connectinject.NamespaceImagesResource{
+			Log:              logger.Named("namespaceImages"),
+			KubernetesClient: c.clientset,
+			Namespace:        nsImagesNamespace,
+			Name:             nsImagesName,
+		}
+	}
+
+	// Build the HTTP handler and server
+	injector := connectinject.Handler{
+		ConsulClient:                c.consulClient,
+		ImageConsul:                 c.flagConsulImage,
+		ImageEnvoy:                  c.flagEnvoyImage,
+		NamespaceImages:             nsImages,
+		EnvoyExtraArgs:              c.flagEnvoyExtraArgs,
+		DefaultEnvoyDrainTime:       c.flagEnvoyDrainTime,
+		EnvoyTracingProvider:        c.flagTracingProvider,
+		EnvoyTracingCollectorAddr:   c.flagTracingCollectorAddr,
+		EnvoyTracingSampleRate:      c.flagTracingSampleRate,
+		EnvoyBootstrapOverrides:     c.flagBootstrapOverrides,
+		DefaultEnableMetrics:        c.flagDefaultEnableMetrics,
+		DefaultEnableMetricsMerging: c.flagDefaultEnableMetricsMerging,
+		DefaultMergedMetricsPort:    c.flagDefaultMergedMetricsPort,
+		DefaultPrometheusScrapePort: c.flagDefaultPrometheusScrapePort,
+		DefaultPrometheusScrapePath: c.flagDefaultPrometheusScrapePath,
+		DashboardURLTemplate:        dashboardURLTemplate,
+		MetaPropagator:              metaPropagator,
+		ImageConsulK8S:              c.flagConsulK8sImage,
+		RequireAnnotation:           !c.flagDefaultInject,
+		AuthMethod:                  c.flagACLAuthMethod,
+		ServiceAccountValidation:    c.flagACLSAValidation,
+		ConsulCACert:                string(consulCACert),
+		DefaultProxyCPURequest:      sidecarProxyCPURequest,
+		DefaultProxyCPULimit:        sidecarProxyCPULimit,
+		DefaultProxyMemoryRequest:   sidecarProxyMemoryRequest,
+		DefaultProxyMemoryLimit:     sidecarProxyMemoryLimit,
+		InitContainerResources:      initResources,
+		ConsulSidecarResources:      consulSidecarResources,
+		EnableNamespaces:            c.flagEnableNamespaces,
+		AllowK8sNamespacesSet:       allowK8sNamespaces,
+		DenyK8sNamespacesSet:        denyK8sNamespaces,
+		ConsulDestinationNamespace:  c.flagConsulDestinationNamespace,
+		EnableK8SNSMirroring:        c.flagEnableK8SNSMirroring,
+		K8SNSMirroringPrefix:        c.flagK8SNSMirroringPrefix,
+		CrossNamespaceACLPolicy:     c.flagCrossNamespaceACLPolicy,
+		ConsulPartition:             consulPartition,
+		EnableOpenShift:             c.flagEnableOpenShift,
+		EnableNamespaceDefaults:     c.flagEnableNSDefaults,
+		KubernetesClient:            c.clientset,
+		Log:                         logger.Named("handler"),
+	}
+
+	// In dry-run mode we only mutate the pod read from stdin and exit.
+	if c.flagDryRun {
+		return c.dryRun(&injector)
+	}
+
 	// Determine where to source the certificates from
 	var certSource cert.Source = &cert.GenSource{
 		Name:  "Connect Inject",
@@ -343,35 +611,6 @@ func (c *Command) Run(args []string) int {
 	defer cancelFunc()
 	go c.certWatcher(ctx, certCh, c.clientset)
 
-	// Convert allow/deny lists to sets
-	allowK8sNamespaces := flags.ToSet(c.flagAllowK8sNamespacesList)
-	denyK8sNamespaces := flags.ToSet(c.flagDenyK8sNamespacesList)
-
-	// Build the HTTP handler and server
-	injector := connectinject.Handler{
-		ConsulClient:               c.consulClient,
-		ImageConsul:                c.flagConsulImage,
-		ImageEnvoy:                 c.flagEnvoyImage,
-		EnvoyExtraArgs:             c.flagEnvoyExtraArgs,
-		ImageConsulK8S:             c.flagConsulK8sImage,
-		RequireAnnotation:          !c.flagDefaultInject,
-		AuthMethod:                 c.flagACLAuthMethod,
-		ConsulCACert:               string(consulCACert),
-		DefaultProxyCPURequest:     sidecarProxyCPURequest,
-		DefaultProxyCPULimit:       sidecarProxyCPULimit,
-		DefaultProxyMemoryRequest:  sidecarProxyMemoryRequest,
-		DefaultProxyMemoryLimit:    sidecarProxyMemoryLimit,
-		InitContainerResources:     initResources,
-		ConsulSidecarResources:     consulSidecarResources,
-		EnableNamespaces:           c.flagEnableNamespaces,
-		AllowK8sNamespacesSet:      allowK8sNamespaces,
-		DenyK8sNamespacesSet:       denyK8sNamespaces,
-		ConsulDestinationNamespace: c.flagConsulDestinationNamespace,
-		EnableK8SNSMirroring:       c.flagEnableK8SNSMirroring,
-		K8SNSMirroringPrefix:       c.flagK8SNSMirroringPrefix,
-		CrossNamespaceACLPolicy:    c.flagCrossNamespaceACLPolicy,
-		Log:                        logger.Named("handler"),
-	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mutate", injector.Handle)
 	mux.HandleFunc("/health/ready", c.handleReady)
@@ -392,11 +631,36 @@ func (c *Command) Run(args []string) int {
 		}
 	}()
 
+	// Serve metrics separately from the webhook server so they can be
+	// scraped without TLS.
+	registry := prometheus.NewRegistry()
+	var metricsServer *http.Server
+	if c.flagMetricsListen != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		metricsServer = &http.Server{
+			Addr:    c.flagMetricsListen,
+			Handler: metricsMux,
+		}
+		go func() {
+			c.UI.Info(fmt.Sprintf("Serving metrics on %q...", c.flagMetricsListen))
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				c.UI.Error(fmt.Sprintf("Error listening for metrics: %s", err))
+				serverErrors <- err
+			}
+		}()
+	}
+
 	// Start the cleanup controller that cleans up Consul service instances
 	// still registered after the pod has been deleted (usually due to a force delete).
 	ctrlExitCh := make(chan error)
 
 	if c.flagEnableCleanupController {
+		cleanupMetrics, err := connectinject.NewCleanupMetrics(registry)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error registering cleanup controller metrics: %s", err))
+			return 1
+		}
 		cleanupResource := connectinject.CleanupResource{
 			Log:                    logger.Named("cleanupResource"),
 			KubernetesClient:       c.clientset,
@@ -405,7 +669,9 @@ func (c *Command) Run(args []string) int {
 			ConsulClient:           c.consulClient,
 			ConsulScheme:           consulURL.Scheme,
 			ConsulPort:             consulURL.Port(),
+			ConsulAPITimeout:       c.http.APITimeout(),
 			EnableConsulNamespaces: c.flagEnableNamespaces,
+			Metrics:                cleanupMetrics,
 		}
 		cleanupCtrl := &controller.Controller{
 			Log:      logger.Named("cleanupController"),
@@ -419,14 +685,30 @@ func (c *Command) Run(args []string) int {
 		}()
 	}
 
+	if nsImages != nil {
+		nsImages.Ctx = ctx
+		nsImagesCtrl := &controller.Controller{
+			Log:      logger.Named("namespaceImagesController"),
+			Resource: nsImages,
+		}
+		go func() {
+			nsImagesCtrl.Run(ctx.Done())
+			if ctx.Err() == nil {
+				ctrlExitCh <- fmt.Errorf("namespace images controller exited unexpectedly")
+			}
+		}()
+	}
+
 	if c.flagEnableHealthChecks {
 		healthResource := connectinject.HealthCheckResource{
 			Log:                 logger.Named("healthCheckResource"),
 			KubernetesClientset: c.clientset,
 			ConsulScheme:        consulURL.Scheme,
 			ConsulPort:          consulURL.Port(),
+			ConsulAPITimeout:    c.http.APITimeout(),
 			Ctx:                 ctx,
 			ReconcilePeriod:     c.flagHealthChecksReconcilePeriod,
+			TopologyMeta:        c.flagEnableTopologyMeta,
 		}
 
 		healthChecksCtrl := &controller.Controller{
@@ -455,6 +737,12 @@ func (c *Command) Run(args []string) int {
 			c.UI.Error(fmt.Sprintf("shutting down server: %v", err))
 			return 1
 		}
+		if metricsServer != nil {
+			if err := metricsServer.Close(); err != nil {
+				c.UI.Error(fmt.Sprintf("shutting down metrics server: %v", err))
+				return 1
+			}
+		}
 		return 0
 
 	case <-serverErrors:
@@ -466,6 +754,63 @@ func (c *Command) Run(args []string) int {
 	}
 }
 
+// dryRun reads a pod from stdin, runs it through the injector and writes
+// the mutated pod to the UI as YAML. Checks against Consul that were skipped
+// because there is no Consul client are reported as warnings.
+func (c *Command) dryRun(injector *connectinject.Handler) int {
+	// Read stdin in the background so that an interrupt or terminate signal
+	// still exits the command while we're blocked waiting on input.
+	type readResult struct {
+		data []byte
+		err  error
+	}
+	readCh := make(chan readResult, 1)
+	go func() {
+		data, err := ioutil.ReadAll(c.stdin)
+		readCh <- readResult{data: data, err: err}
+	}()
+
+	var raw []byte
+	select {
+	case sig := <-c.sigCh:
+		c.UI.Info(fmt.Sprintf("%s received, exiting", sig))
+		return 1
+	case r := <-readCh:
+		if r.err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading pod from stdin: %s", r.err))
+			return 1
+		}
+		raw = r.data
+	}
+
+	var pod corev1.Pod
+	if err := yaml.Unmarshal(raw, &pod); err != nil {
+		c.UI.Error(fmt.Sprintf("Error decoding pod: %s", err))
+		return 1
+	}
+
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	mutated, warnings, err := injector.DryRun(pod, namespace)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error mutating pod: %s", err))
+		return 1
+	}
+	for _, warning := range warnings {
+		c.UI.Warn(fmt.Sprintf("Warning: %s", warning))
+	}
+
+	out, err := yaml.Marshal(mutated)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error encoding pod: %s", err))
+		return 1
+	}
+	c.UI.Output(strings.TrimSpace(string(out)))
+	return 0
+}
+
 func (c *Command) interrupt() {
 	c.sendSignal(syscall.SIGINT)
 }
@@ -654,4 +999,11 @@ Usage: consul-k8s inject-connect [options]
   Run the admission webhook server for injecting the Consul Connect
   proxy sidecar. The sidecar uses Envoy by default.
 
+  With -dry-run, read a pod manifest from stdin and print the mutated
+  pod instead. Consul is not contacted so checks against Consul are
+  skipped and reported as warnings, e.g.
+
+      $ consul-k8s inject-connect -dry-run -allow-k8s-namespace='*' \
+          -consul-image=... -envoy-image=... -consul-k8s-image=... < pod.yaml
+
 `
@@ -2,7 +2,9 @@ package connectinject
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -11,7 +13,9 @@ import (
 	"github.com/hashicorp/consul/sdk/freeport"
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
 )
 
 func TestRun_FlagValidation(t *testing.T) {
@@ -23,6 +27,10 @@ func TestRun_FlagValidation(t *testing.T) {
 			flags:  []string{},
 			expErr: "-consul-k8s-image must be set",
 		},
+		{
+			flags:  []string{"-fault-consul-delay=-1s"},
+			expErr: "-fault-consul-delay must not be negative",
+		},
 		{
 			flags:  []string{"-consul-k8s-image", "foo"},
 			expErr: "-consul-image must be set",
@@ -41,11 +49,36 @@ func TestRun_FlagValidation(t *testing.T) {
 				"-enable-central-config", "true"},
 			expErr: "-enable-central-config is no longer supported",
 		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-default-envoy-drain-time", "-1s"},
+			expErr: "-default-envoy-drain-time must not be negative",
+		},
 		{
 			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
 				"-default-protocol", "http"},
 			expErr: "-default-protocol is no longer supported",
 		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-acl-service-account-validation", "lenient"},
+			expErr: `-acl-service-account-validation must be "strict" or "permissive"`,
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-enable-partitions"},
+			expErr: "-enable-partitions requires -enable-namespaces",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-enable-namespaces", "-enable-partitions", "-partition-name", ""},
+			expErr: "-partition-name must be set if -enable-partitions is true",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-dashboard-url-template", "https://dashboard.example.com/{{ .Pod }}"},
+			expErr: "-dashboard-url-template is invalid",
+		},
 		{
 			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
 				"-ca-file", "bar"},
@@ -153,6 +186,36 @@ func TestRun_FlagValidation(t *testing.T) {
 			},
 			expErr: "request must be <= limit: -consul-sidecar-cpu-request value of \"50m\" is greater than the -consul-sidecar-cpu-limit value of \"25m\"",
 		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-namespace-images-config-map=images",
+			},
+			expErr: "-namespace-images-config-map must be in the form <namespace>/<name>",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-enable-topology-meta",
+			},
+			expErr: "-enable-topology-meta requires -enable-health-checks-controller",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-default-prometheus-scrape-path", "/merged",
+			},
+			expErr: "-default-prometheus-scrape-path must be \"/metrics\" unless -default-enable-metrics-merging is set",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-default-enable-metrics-merging", "-default-merged-metrics-port", "20200",
+			},
+			expErr: "-default-merged-metrics-port and -default-prometheus-scrape-port must differ",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-bootstrap-config-overrides", "[]",
+			},
+			expErr: "-bootstrap-config-overrides is invalid: must be a JSON object",
+		},
 	}
 
 	for _, c := range cases {
@@ -295,3 +358,106 @@ func runCommandAsynchronously(cmd *Command, args []string) chan int {
 	}()
 	return exitChan
 }
+
+// Test that in dry-run mode the pod read from stdin is printed with the
+// injected containers without needing a Kubernetes or Consul client.
+func TestRun_DryRun(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+		stdin: strings.NewReader(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+  annotations:
+    consul.hashicorp.com/connect-inject: "true"
+spec:
+  containers:
+  - name: web
+    image: web:latest
+`),
+	}
+	code := cmd.Run([]string{
+		"-consul-k8s-image", "hashicorp/consul-k8s", "-consul-image", "consul:1.9.3", "-envoy-image", "envoy:1.16.0",
+		"-allow-k8s-namespace=*", "-dry-run",
+	})
+	require.Equal(t, 0, code, ui.ErrorWriter.String())
+
+	var pod corev1.Pod
+	require.NoError(t, yaml.Unmarshal(ui.OutputWriter.Bytes(), &pod))
+	require.Equal(t, "injected", pod.Annotations["consul.hashicorp.com/connect-inject-status"])
+	var containerNames []string
+	for _, container := range pod.Spec.Containers {
+		containerNames = append(containerNames, container.Name)
+	}
+	require.Equal(t, []string{"web", "envoy-sidecar", "consul-sidecar"}, containerNames)
+	require.Len(t, pod.Spec.InitContainers, 1)
+	require.Equal(t, "consul:1.9.3", pod.Spec.InitContainers[0].Image)
+}
+
+// Test that skipped Consul checks are reported as warnings without
+// polluting the pod written to stdout.
+func TestRun_DryRunSkippedConsulChecks(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+		stdin: strings.NewReader(`
+metadata:
+  name: web
+  annotations:
+    consul.hashicorp.com/connect-service-upstreams: "db:1234:dc2"
+spec:
+  containers:
+  - name: web
+`),
+	}
+	code := cmd.Run([]string{
+		"-consul-k8s-image", "hashicorp/consul-k8s", "-consul-image", "consul:1.9.3", "-envoy-image", "envoy:1.16.0",
+		"-allow-k8s-namespace=*", "-dry-run",
+	})
+	require.Equal(t, 0, code, ui.ErrorWriter.String())
+	require.Contains(t, ui.ErrorWriter.String(), `Warning: upstream "db:1234:dc2" references a datacenter`)
+
+	var pod corev1.Pod
+	require.NoError(t, yaml.Unmarshal(ui.OutputWriter.Bytes(), &pod))
+	require.Equal(t, "injected", pod.Annotations["consul.hashicorp.com/connect-inject-status"])
+}
+
+func TestRun_DryRunInvalidPod(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:    ui,
+		stdin: strings.NewReader("{"),
+	}
+	code := cmd.Run([]string{
+		"-consul-k8s-image", "hashicorp/consul-k8s", "-consul-image", "consul:1.9.3", "-envoy-image", "envoy:1.16.0",
+		"-dry-run",
+	})
+	require.Equal(t, 1, code)
+	require.Contains(t, ui.ErrorWriter.String(), "Error decoding pod")
+}
+
+// Test that a signal exits dry-run mode while it's still waiting on stdin.
+func TestRun_DryRunExitsOnSignal(t *testing.T) {
+	stdin, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:    ui,
+		stdin: stdin,
+	}
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-consul-k8s-image", "hashicorp/consul-k8s", "-consul-image", "consul:1.9.3", "-envoy-image", "envoy:1.16.0",
+		"-dry-run",
+	})
+	cmd.interrupt()
+
+	select {
+	case exitCode := <-exitChan:
+		require.Equal(t, 1, exitCode)
+		require.Contains(t, ui.OutputWriter.String(), "interrupt received, exiting")
+	case <-time.After(time.Second * 1):
+		require.Fail(t, "timeout waiting for command to exit")
+	}
+}
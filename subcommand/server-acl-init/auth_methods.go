@@ -0,0 +1,209 @@
+package serveraclinit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"sigs.k8s.io/yaml"
+)
+
+// authMethodsConfig is the -auth-methods-file, which configures auth methods
+// such as JWT and OIDC auth methods in addition to the Kubernetes auth method
+// created for connect injection, so that applications whose workload identity
+// tokens aren't service account JWTs can log in. It's a YAML file of the form:
+//
+//	authMethods:
+//	- name: "workload-jwt"
+//	  type: "jwt"
+//	  maxTokenTTL: "1h"
+//	  config:
+//	    JWKSURL: "https://issuer.example.com/.well-known/jwks.json"
+//	    BoundIssuer: "https://issuer.example.com"
+//	    ClaimMappings:
+//	      service: "service"
+//	  bindingRules:
+//	  - description: "Workload identity services"
+//	    bindType: "service"
+//	    bindName: "${value.service}"
+//
+// The config of each auth method is passed to Consul as is. Binding rules are
+// identified by their description, and the binding rules of the auth methods
+// that aren't in the file are deleted.
+type authMethodsConfig struct {
+	AuthMethods []authMethodConfig `json:"authMethods"`
+}
+
+type authMethodConfig struct {
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	DisplayName   string                 `json:"displayName"`
+	Description   string                 `json:"description"`
+	MaxTokenTTL   string                 `json:"maxTokenTTL"`
+	TokenLocality string                 `json:"tokenLocality"`
+	Namespace     string                 `json:"namespace"`
+	Config        map[string]interface{} `json:"config"`
+	BindingRules  []bindingRuleConfig    `json:"bindingRules"`
+}
+
+type bindingRuleConfig struct {
+	Description string `json:"description"`
+	Selector    string `json:"selector"`
+	BindType    string `json:"bindType"`
+	BindName    string `json:"bindName"`
+}
+
+// loadAuthMethods reads and validates the auth methods file at filename.
+func loadAuthMethods(filename string) (*authMethodsConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config authMethodsConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", filename, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %s", filename, err)
+	}
+	return &config, nil
+}
+
+func (a *authMethodsConfig) validate() error {
+	names := make(map[string]bool)
+	for i, am := range a.AuthMethods {
+		if am.Name == "" {
+			return fmt.Errorf("authMethods[%d].name must be set", i)
+		}
+		if names[am.Namespace+"/"+am.Name] {
+			return fmt.Errorf("authMethods[%d].name %q is not unique", i, am.Name)
+		}
+		names[am.Namespace+"/"+am.Name] = true
+		if am.Type == "" {
+			return fmt.Errorf("authMethods[%d].type must be set", i)
+		}
+		if am.MaxTokenTTL != "" {
+			if _, err := time.ParseDuration(am.MaxTokenTTL); err != nil {
+				return fmt.Errorf("authMethods[%d].maxTokenTTL %q is not a valid duration", i, am.MaxTokenTTL)
+			}
+		}
+		descriptions := make(map[string]bool)
+		for j, br := range am.BindingRules {
+			if br.Description == "" {
+				return fmt.Errorf("authMethods[%d].bindingRules[%d].description must be set", i, j)
+			}
+			if descriptions[br.Description] {
+				return fmt.Errorf("authMethods[%d].bindingRules[%d].description %q is not unique", i, j, br.Description)
+			}
+			descriptions[br.Description] = true
+			switch api.BindingRuleBindType(br.BindType) {
+			case api.BindingRuleBindTypeService, api.BindingRuleBindTypeRole:
+			default:
+				return fmt.Errorf("authMethods[%d].bindingRules[%d].bindType must be %q or %q", i, j,
+					api.BindingRuleBindTypeService, api.BindingRuleBindTypeRole)
+			}
+			if br.BindName == "" {
+				return fmt.Errorf("authMethods[%d].bindingRules[%d].bindName must be set", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// configureAuthMethods creates or updates the auth methods of the
+// -auth-methods-file and their binding rules.
+func (c *Command) configureAuthMethods(consulClient *api.Client) error {
+	for _, am := range c.authMethods.AuthMethods {
+		// The duration was validated when the file was loaded.
+		maxTokenTTL, _ := time.ParseDuration(am.MaxTokenTTL)
+		authMethod := api.ACLAuthMethod{
+			Name:          am.Name,
+			Type:          am.Type,
+			DisplayName:   am.DisplayName,
+			Description:   am.Description,
+			MaxTokenTTL:   maxTokenTTL,
+			TokenLocality: am.TokenLocality,
+			Config:        am.Config,
+			Namespace:     am.Namespace,
+		}
+		writeOptions := api.WriteOptions{Namespace: am.Namespace}
+		err := c.untilSucceeds(fmt.Sprintf("creating auth method %s", am.Name),
+			func() error {
+				// `AuthMethodCreate` also updates an existing AuthMethod of
+				// the same name.
+				_, _, err := consulClient.ACL().AuthMethodCreate(&authMethod, &writeOptions)
+				return err
+			})
+		if err != nil {
+			return err
+		}
+		if err := c.configureBindingRules(consulClient, am); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configureBindingRules creates or updates the binding rules of the auth
+// method and deletes its other binding rules.
+func (c *Command) configureBindingRules(consulClient *api.Client, am authMethodConfig) error {
+	queryOptions := api.QueryOptions{Namespace: am.Namespace}
+	writeOptions := api.WriteOptions{Namespace: am.Namespace}
+
+	var existingRules []*api.ACLBindingRule
+	err := c.untilSucceeds(fmt.Sprintf("listing binding rules for auth method %s", am.Name),
+		func() error {
+			var err error
+			existingRules, _, err = consulClient.ACL().BindingRuleList(am.Name, &queryOptions)
+			return err
+		})
+	if err != nil {
+		return err
+	}
+	existingIDs := make(map[string]string)
+	for _, existingRule := range existingRules {
+		existingIDs[existingRule.Description] = existingRule.ID
+	}
+
+	for _, br := range am.BindingRules {
+		abr := api.ACLBindingRule{
+			ID:          existingIDs[br.Description],
+			Description: br.Description,
+			AuthMethod:  am.Name,
+			Selector:    br.Selector,
+			BindType:    api.BindingRuleBindType(br.BindType),
+			BindName:    br.BindName,
+			Namespace:   am.Namespace,
+		}
+		if abr.ID != "" {
+			delete(existingIDs, br.Description)
+			err = c.untilSucceeds(fmt.Sprintf("updating acl binding rule %q for %s", br.Description, am.Name),
+				func() error {
+					_, _, err := consulClient.ACL().BindingRuleUpdate(&abr, &writeOptions)
+					return err
+				})
+		} else {
+			err = c.untilSucceeds(fmt.Sprintf("creating acl binding rule %q for %s", br.Description, am.Name),
+				func() error {
+					_, _, err := consulClient.ACL().BindingRuleCreate(&abr, &writeOptions)
+					return err
+				})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for description, id := range existingIDs {
+		err = c.untilSucceeds(fmt.Sprintf("deleting acl binding rule %q for %s", description, am.Name),
+			func() error {
+				_, err := consulClient.ACL().BindingRuleDelete(id, &writeOptions)
+				return err
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
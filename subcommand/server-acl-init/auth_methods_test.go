@@ -0,0 +1,136 @@
+package serveraclinit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAuthMethods(t *testing.T) {
+	cases := map[string]struct {
+		file   string
+		expErr string
+	}{
+		"valid": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+  maxTokenTTL: 1h
+  config:
+    BoundIssuer: https://issuer.example.com
+  bindingRules:
+  - description: services
+    bindType: service
+    bindName: ${value.service}
+  - description: roles
+    selector: value.team==payments
+    bindType: role
+    bindName: payments`,
+		},
+		"unknown field": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+  rules: []`,
+			expErr: `parsing auth-methods.yaml: error unmarshaling JSON: while decoding JSON: json: unknown field "rules"`,
+		},
+		"no name": {
+			file: `
+authMethods:
+- type: jwt`,
+			expErr: "auth-methods.yaml is invalid: authMethods[0].name must be set",
+		},
+		"duplicate name": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+- name: jwt
+  type: oidc`,
+			expErr: `auth-methods.yaml is invalid: authMethods[1].name "jwt" is not unique`,
+		},
+		"no type": {
+			file: `
+authMethods:
+- name: jwt`,
+			expErr: "auth-methods.yaml is invalid: authMethods[0].type must be set",
+		},
+		"invalid max token ttl": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+  maxTokenTTL: 1 hour`,
+			expErr: `auth-methods.yaml is invalid: authMethods[0].maxTokenTTL "1 hour" is not a valid duration`,
+		},
+		"no binding rule description": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+  bindingRules:
+  - bindType: service
+    bindName: web`,
+			expErr: "auth-methods.yaml is invalid: authMethods[0].bindingRules[0].description must be set",
+		},
+		"duplicate binding rule description": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+  bindingRules:
+  - description: services
+    bindType: service
+    bindName: web
+  - description: services
+    bindType: service
+    bindName: api`,
+			expErr: `auth-methods.yaml is invalid: authMethods[0].bindingRules[1].description "services" is not unique`,
+		},
+		"invalid bind type": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+  bindingRules:
+  - description: services
+    bindType: node
+    bindName: web`,
+			expErr: `auth-methods.yaml is invalid: authMethods[0].bindingRules[0].bindType must be "service" or "role"`,
+		},
+		"no bind name": {
+			file: `
+authMethods:
+- name: jwt
+  type: jwt
+  bindingRules:
+  - description: services
+    bindType: service`,
+			expErr: "auth-methods.yaml is invalid: authMethods[0].bindingRules[0].bindName must be set",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "auth-methods.yaml")
+			require.NoError(t, ioutil.WriteFile(path, []byte(c.file), 0600))
+
+			config, err := loadAuthMethods(path)
+			if c.expErr != "" {
+				require.EqualError(t, err, strings.Replace(c.expErr, "auth-methods.yaml", path, 1))
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, config.AuthMethods, 1)
+			require.Len(t, config.AuthMethods[0].BindingRules, 2)
+		})
+	}
+}
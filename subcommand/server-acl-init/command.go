@@ -6,13 +6,17 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/consul-k8s/consul"
 	godiscover "github.com/hashicorp/consul-k8s/helper/go-discover"
+	"github.com/hashicorp/consul-k8s/helper/vault"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
@@ -29,8 +33,9 @@ import (
 type Command struct {
 	UI cli.Ui
 
-	flags *flag.FlagSet
-	k8s   *k8sflags.K8SFlags
+	flags  *flag.FlagSet
+	k8s    *k8sflags.K8SFlags
+	secret *flags.SecretFlags
 
 	flagResourcePrefix string
 	flagK8sNamespace   string
@@ -47,6 +52,8 @@ type Command struct {
 	flagInjectAuthMethodHost   string
 	flagBindingRuleSelector    string
 
+	flagAuthMethodsFile string
+
 	flagCreateControllerToken bool
 
 	flagCreateEntLicenseToken bool
@@ -63,6 +70,7 @@ type Command struct {
 	flagConsulCACert        string
 	flagConsulTLSServerName string
 	flagUseHTTPS            bool
+	flagConsulAPITimeout    time.Duration
 
 	// Flags for ACL replication
 	flagCreateACLReplicationToken bool
@@ -77,19 +85,56 @@ type Command struct {
 	flagEnableInjectK8SNSMirroring       bool   // Enables mirroring of k8s namespaces into Consul for Connect inject
 	flagInjectK8SNSMirroringPrefix       string // Prefix added to Consul namespaces created when mirroring injected services
 
+	// Flags to support admin partitions
+	flagEnablePartitions bool
+	flagPartitionName    string
+
 	// Flag to support a custom bootstrap token
-	flagBootstrapTokenFile string
+	flagBootstrapTokenFile       string
+	flagBootstrapTokenSecretName string
+	flagBootstrapTokenSecretKey  string
+
+	// Flags to store the bootstrap and ACL replication tokens in Vault.
+	flagVaultAddr            string
+	flagVaultCAFile          string
+	flagVaultTLSServerName   string
+	flagVaultNamespace       string
+	flagVaultKVMount         string
+	flagVaultSecretsPath     string
+	flagVaultAuthMethod      string
+	flagVaultTokenFile       string
+	flagVaultAuthPath        string
+	flagVaultRole            string
+	flagVaultBearerTokenFile string
 
 	// Flag to indicate that the health checks controller is enabled.
 	flagEnableHealthChecks bool
 
 	flagEnableCleanupController bool
 
+	// Flag to extend the policies of the tokens with user-provided templates.
+	flagPolicyTemplatesDir string
+
+	// Flags to rotate the component tokens once or periodically.
+	flagRotate         bool
+	flagRotateInterval time.Duration
+
+	// Flag to report the progress and result of the command.
+	flagStatusConfigMap string
+
+	// Flag to run every step even if the configuration is unchanged since
+	// the last successful run.
+	flagForce bool
+
 	flagLogLevel string
 	flagTimeout  time.Duration
 
 	clientset kubernetes.Interface
 
+	// vault stores the bootstrap and ACL replication tokens if -vault-addr
+	// is set.
+	vault *vault.Client
+
 	// cmdTimeout is cancelled when the command timeout is reached.
 	cmdTimeout    context.Context
 	retryDuration time.Duration
@@ -97,6 +142,31 @@ type Command struct {
 	// log
 	log hclog.Logger
 
+	// policyTemplates maps token names to the policy templates loaded from
+	// -policy-templates-dir. appliedPolicyTemplates records which of them
+	// were rendered into a policy.
+	policyTemplates        map[string]string
+	appliedPolicyTemplates map[string]bool
+
+	// authMethods are the auth methods loaded from -auth-methods-file.
+	authMethods *authMethodsConfig
+
+	// componentTokens are the tokens created or found by createACL that
+	// are rotated with -rotate and -rotate-interval.
+	componentTokens []componentToken
+
+	// status reports the progress of the command if -status-config-map is
+	// set.
+	status *statusReporter
+
+	// state is the state of the last successful run, if the servers were
+	// bootstrapped by this command.
+	state *runState
+
+	// sigCh stops the periodic rotation. It is only set up if
+	// -rotate-interval is set so that signals otherwise end the command.
+	sigCh chan os.Signal
+
 	once sync.Once
 	help string
 
@@ -137,6 +207,11 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagInjectAuthMethodHost, "inject-auth-method-host", "",
 		"Kubernetes Host config parameter for the auth method."+
 			"If not provided, the default cluster Kubernetes service will be used.")
+	c.flags.StringVar(&c.flagAuthMethodsFile, "auth-methods-file", "",
+		"Path to a YAML file, such as a mounted ConfigMap, of auth methods to create, such as JWT or OIDC "+
+			"auth methods, along with their binding rules, so that applications with workload identity tokens "+
+			"other than service account JWTs can log in. Binding rules of these auth methods that aren't in "+
+			"the file are deleted.")
 	c.flags.StringVar(&c.flagBindingRuleSelector, "acl-binding-rule-selector", "",
 		"Selector string for connectInject ACL Binding Rule.")
 
@@ -168,6 +243,9 @@ func (c *Command) init() {
 		"The server name to set as the SNI header when sending HTTPS requests to Consul.")
 	c.flags.BoolVar(&c.flagUseHTTPS, "use-https", false,
 		"Toggle for using HTTPS for all API calls to Consul.")
+	c.flags.DurationVar(&c.flagConsulAPITimeout, "consul-api-timeout", 0,
+		"How long a Consul API call can take before it fails and is retried, e.g. 1ms, 2s, 3m. "+
+			"If 0, calls never time out.")
 
 	c.flags.BoolVar(&c.flagEnableNamespaces, "enable-namespaces", false,
 		"[Enterprise Only] Enables namespaces, in either a single Consul namespace or mirrored [Enterprise only feature]")
@@ -188,6 +266,13 @@ func (c *Command) init() {
 		"[Enterprise Only] Prefix that will be added to all k8s namespaces mirrored into Consul by Connect inject "+
 			"if mirroring is enabled.")
 
+	c.flags.BoolVar(&c.flagEnablePartitions, "enable-partitions", false,
+		"[Enterprise Only] Enables admin partitions. The policies, tokens and auth methods are created in the "+
+			"partition set by '-partition-name', which is created if it doesn't exist.")
+	c.flags.StringVar(&c.flagPartitionName, "partition-name", consul.DefaultPartition,
+		"[Enterprise Only] Name of the admin partition of this Kubernetes cluster. Only used if "+
+			"'-enable-partitions' is true.")
+
 	c.flags.BoolVar(&c.flagCreateACLReplicationToken, "create-acl-replication-token", false,
 		"Toggle for creating a token for ACL replication between datacenters.")
 	c.flags.StringVar(&c.flagACLReplicationTokenFile, "acl-replication-token-file", "",
@@ -196,6 +281,42 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagBootstrapTokenFile, "bootstrap-token-file", "",
 		"Path to file containing ACL token for creating policies and tokens. This token must have 'acl:write' permissions."+
 			"When provided, servers will not be bootstrapped and their policies and tokens will not be updated.")
+	c.flags.StringVar(&c.flagBootstrapTokenSecretName, "bootstrap-token-secret-name", "",
+		"Name of the Kubernetes Secret in -k8s-namespace containing the ACL token for creating policies and tokens, "+
+			"e.g. the management token of servers that weren't deployed by this installation. It's used like "+
+			"-bootstrap-token-file, which can be used instead for tokens stored elsewhere, such as in Vault "+
+			"with the token written to a file by the Vault Agent injector.")
+	c.flags.StringVar(&c.flagBootstrapTokenSecretKey, "bootstrap-token-secret-key", common.ACLTokenSecretKey,
+		"Key of the token in the data of the -bootstrap-token-secret-name Secret.")
+
+	c.flags.StringVar(&c.flagVaultAddr, "vault-addr", "",
+		"The address of a Vault server, e.g. https://vault:8200, to store the bootstrap token and the ACL "+
+			"replication token in instead of Kubernetes Secrets, for when long-lived secrets mustn't be stored "+
+			"in etcd. They're written to the -vault-kv-mount KV version 2 secrets engine at "+
+			"-vault-secrets-path/<Secret name> under the \"token\" key and read back from there by later runs. "+
+			"The state of the runs isn't recorded then, so every step runs each time.")
+	c.flags.StringVar(&c.flagVaultCAFile, "vault-ca-file", "",
+		"The path to the CA file to use when making requests to Vault.")
+	c.flags.StringVar(&c.flagVaultTLSServerName, "vault-tls-server-name", "",
+		"The server name to set as the SNI header when sending HTTPS requests to Vault.")
+	c.flags.StringVar(&c.flagVaultNamespace, "vault-namespace", "",
+		"The Vault Enterprise namespace of the KV secrets engine and auth method.")
+	c.flags.StringVar(&c.flagVaultKVMount, "vault-kv-mount", "secret",
+		"The mount path of the Vault KV version 2 secrets engine the tokens are stored in.")
+	c.flags.StringVar(&c.flagVaultSecretsPath, "vault-secrets-path", "consul",
+		"The path of the tokens' secrets in the -vault-kv-mount secrets engine.")
+	c.flags.StringVar(&c.flagVaultAuthMethod, "vault-auth-method", vault.AuthMethodToken,
+		"How to authenticate to Vault: \"token\" to use the token in -vault-token-file or the "+
+			"VAULT_TOKEN environment variable, if any, or \"kubernetes\" to log in with the "+
+			"pod's service account token using the Kubernetes auth method.")
+	c.flags.StringVar(&c.flagVaultTokenFile, "vault-token-file", "",
+		"The path to a file containing the Vault token when -vault-auth-method is \"token\".")
+	c.flags.StringVar(&c.flagVaultAuthPath, "vault-auth-path", "kubernetes",
+		"The mount path of the Vault Kubernetes auth method.")
+	c.flags.StringVar(&c.flagVaultRole, "vault-role", "",
+		"The Vault role to log in as with the Kubernetes auth method.")
+	c.flags.StringVar(&c.flagVaultBearerTokenFile, "vault-bearer-token-file", vault.DefaultBearerTokenFile,
+		"The path to the service account token to log in with using the Kubernetes auth method.")
 
 	c.flags.BoolVar(&c.flagEnableHealthChecks, "enable-health-checks", false,
 		"Toggle for adding ACL rules for the health check controller to the connect ACL token. Requires -create-inject-token to be also be set.")
@@ -203,6 +324,32 @@ func (c *Command) init() {
 	c.flags.BoolVar(&c.flagEnableCleanupController, "enable-cleanup-controller", true,
 		"Toggle for adding ACL rules for the cleanup controller to the connect ACL token. Requires -create-inject-token to be also be set.")
 
+	c.flags.StringVar(&c.flagPolicyTemplatesDir, "policy-templates-dir", "",
+		"Path to a directory, such as a mounted ConfigMap, of HCL policy templates named <token>.hcl, e.g. "+
+			"catalog-sync.hcl or my-gateway-ingress-gateway.hcl. Each template is rendered with the same data as the "+
+			"built-in rules and appended to the policy of the token of the same name.")
+
+	c.flags.BoolVar(&c.flagRotate, "rotate", false,
+		"Toggle for replacing the tokens of the components that already have one, such as the client, "+
+			"catalog sync, connect inject and gateway tokens, with new tokens. Their Secrets are updated and "+
+			"the old tokens are revoked, so the components must be restarted to use the new tokens. "+
+			"The ACL replication token is never rotated.")
+	c.flags.DurationVar(&c.flagRotateInterval, "rotate-interval", 0,
+		"If set, keep running after the tokens are created and rotate them on this interval, e.g. 720h, "+
+			"until the command is interrupted. Each rotation can take up to -timeout. The components only "+
+			"read their token on startup, so the previous tokens aren't revoked until the next rotation: the "+
+			"components must be restarted within the interval to keep working.")
+
+	c.flags.StringVar(&c.flagStatusConfigMap, "status-config-map", "",
+		"Name of a ConfigMap in -k8s-namespace to write the progress and result of the command to, i.e. "+
+			"the steps that completed and, on failure, the step that failed and its error. Events are also "+
+			"emitted for the ConfigMap as the steps complete. If not set, the progress is only logged.")
+	c.flags.BoolVar(&c.flagForce, "force", false,
+		"Toggle for running every step. By default, when the servers were bootstrapped by this command, "+
+			"a hash of the configuration is recorded on the bootstrap token Secret after each successful run, "+
+			"and a later run with the same configuration skips the steps that were completed, except for "+
+			"creating the tokens whose Secrets were deleted. The steps always run with -rotate.")
+
 	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
 		"How long we'll try to bootstrap ACLs for before timing out, e.g. 1ms, 2s, 3m")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
@@ -210,7 +357,10 @@ func (c *Command) init() {
 			"\"debug\", \"info\", \"warn\", and \"error\".")
 
 	c.k8s = &k8sflags.K8SFlags{}
+	c.secret = &flags.SecretFlags{}
 	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.secret.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
 
 	// Default retry to 1s. This is exposed for setting in tests.
@@ -230,9 +380,9 @@ func (c *Command) Help() string {
 // Given various flags, it will also create policies and associated ACL tokens
 // and store the tokens as Kubernetes Secrets.
 // The function will retry its tasks indefinitely until they are complete.
-func (c *Command) Run(args []string) int {
+func (c *Command) Run(args []string) (ret int) {
 	c.once.Do(c.init)
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		return 1
 	}
 	if len(c.flags.Args()) > 0 {
@@ -246,6 +396,24 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
+	if c.flagPolicyTemplatesDir != "" {
+		var err error
+		c.policyTemplates, err = loadPolicyTemplates(c.flagPolicyTemplatesDir)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to load policy templates from %q: %s", c.flagPolicyTemplatesDir, err))
+			return 1
+		}
+	}
+
+	if c.flagAuthMethodsFile != "" {
+		var err error
+		c.authMethods, err = loadAuthMethods(c.flagAuthMethodsFile)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to load auth methods: %s", err))
+			return 1
+		}
+	}
+
 	var aclReplicationToken string
 	if c.flagACLReplicationTokenFile != "" {
 		// Load the ACL replication token from file.
@@ -282,11 +450,13 @@ func (c *Command) Run(args []string) int {
 	defer cancel()
 
 	var err error
-	c.log, err = common.Logger(c.flagLogLevel)
+	var logger hclog.InterceptLogger
+	logger, err = common.InterceptLogger(c.flagLogLevel)
 	if err != nil {
 		c.UI.Error(err.Error())
 		return 1
 	}
+	c.log = logger
 
 	serverAddresses := c.flagServerAddresses
 	// Check if the provided addresses contain a cloud-auto join string.
@@ -308,15 +478,50 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
+	if c.flagVaultAddr != "" {
+		c.vault, err = c.vaultClient()
+		if err != nil {
+			c.log.Error(fmt.Sprintf("Error initializing Vault client: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagStatusConfigMap != "" {
+		c.status = &statusReporter{
+			clientset: c.clientset,
+			namespace: c.flagK8sNamespace,
+			name:      c.flagStatusConfigMap,
+			log:       c.log,
+		}
+		logger.RegisterSink(c.status)
+		defer func() {
+			if ret != 0 {
+				c.status.failed()
+			}
+		}()
+	}
+	c.status.start(statusStepBootstrap)
+
 	scheme := "http"
 	if c.flagUseHTTPS {
 		scheme = "https"
 	}
 
+	if c.flagBootstrapTokenSecretName != "" {
+		providedBootstrapToken, err = c.bootstrapTokenFromSecret()
+		if err != nil {
+			c.log.Error(err.Error())
+			return 1
+		}
+	}
+
 	var updateServerPolicy bool
 	var bootstrapToken string
+	// stateSecretName is the Secret the state of the run is recorded on,
+	// which is only the bootstrap token Secret created by this command.
+	var stateSecretName string
 
-	if c.flagBootstrapTokenFile != "" {
+	if providedBootstrapToken != "" {
 		// If bootstrap token is provided, we skip server bootstrapping and use
 		// the provided token to create policies and tokens for the rest of the components.
 		c.log.Info("Bootstrap token is provided so skipping Consul server ACL bootstrapping")
@@ -332,14 +537,20 @@ func (c *Command) Run(args []string) int {
 		// Check if we've already been bootstrapped.
 		var err error
 		bootTokenSecretName := c.withPrefix("bootstrap-acl-token")
+		bootTokenSource := fmt.Sprintf("Secret %q", bootTokenSecretName)
+		if c.storedInVault(bootTokenSecretName) {
+			bootTokenSource = fmt.Sprintf("Vault secret %q", c.vaultSecretPath(bootTokenSecretName))
+		} else {
+			stateSecretName = bootTokenSecretName
+		}
 		bootstrapToken, err = c.getBootstrapToken(bootTokenSecretName)
 		if err != nil {
-			c.log.Error(fmt.Sprintf("Unexpected error looking for preexisting bootstrap Secret: %s", err))
+			c.log.Error(fmt.Sprintf("Unexpected error looking for preexisting bootstrap %s: %s", bootTokenSource, err))
 			return 1
 		}
 
 		if bootstrapToken != "" {
-			c.log.Info(fmt.Sprintf("ACLs already bootstrapped - retrieved bootstrap token from Secret %q", bootTokenSecretName))
+			c.log.Info(fmt.Sprintf("ACLs already bootstrapped - retrieved bootstrap token from %s", bootTokenSource))
 
 			// Mark that we should update the server ACL policy in case
 			// there are namespace related config changes. Because of the
@@ -358,15 +569,18 @@ func (c *Command) Run(args []string) int {
 
 	// For all of the next operations we'll need a Consul client.
 	serverAddr := fmt.Sprintf("%s:%d", serverAddresses[0], c.flagServerPort)
-	consulClient, err := consul.NewClient(&api.Config{
-		Address: serverAddr,
-		Scheme:  scheme,
-		Token:   bootstrapToken,
-		TLSConfig: api.TLSConfig{
-			Address: c.flagConsulTLSServerName,
-			CAFile:  c.flagConsulCACert,
-		},
-	})
+	consulConfig := func() *api.Config {
+		return &api.Config{
+			Address: serverAddr,
+			Scheme:  scheme,
+			Token:   bootstrapToken,
+			TLSConfig: api.TLSConfig{
+				Address: c.flagConsulTLSServerName,
+				CAFile:  c.flagConsulCACert,
+			},
+		}
+	}
+	consulClient, err := c.newConsulClient(consulConfig())
 	if err != nil {
 		c.log.Error(fmt.Sprintf("Error creating Consul client for addr %q: %s", serverAddr, err))
 		return 1
@@ -378,17 +592,56 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 	c.log.Info("Current datacenter", "datacenter", consulDC)
+	if stateSecretName != "" {
+		if err := c.loadState(stateSecretName); err != nil {
+			c.log.Error("Error reading the state of the last run", "err", err)
+			return 1
+		}
+	}
+	c.completeStep(statusStepBootstrap)
 
 	// With the addition of namespaces, the ACL policies associated
 	// with the server tokens may need to be updated if Enterprise Consul
 	// users upgrade to 1.7+. This updates the policy if the bootstrap
 	// token had previously existed, which signals a potential config change.
-	if updateServerPolicy {
+	if updateServerPolicy && !c.skipStep(statusStepServerPolicy) {
+		c.status.start(statusStepServerPolicy)
 		_, err = c.setServerPolicy(consulClient)
 		if err != nil {
 			c.log.Error("Error updating the server ACL policy", "err", err)
 			return 1
 		}
+		c.completeStep(statusStepServerPolicy)
+	}
+
+	// If admin partitions are enabled, the partition of this cluster is
+	// created if it doesn't exist yet, from the default partition, and all
+	// of the next operations are scoped to it so that the policies, tokens
+	// and auth methods belong to the partition.
+	if c.inNonDefaultPartition() {
+		if !c.skipStep(statusStepPartition) {
+			c.status.start(statusStepPartition)
+			err = c.untilSucceeds(fmt.Sprintf("creating partition %s", c.flagPartitionName),
+				func() error {
+					return c.createPartitionIfNotExists(consulClient)
+				})
+			if err != nil {
+				c.log.Error("Error creating the partition", "partition", c.flagPartitionName, "err", err)
+				return 1
+			}
+			c.completeStep(statusStepPartition)
+		}
+
+		cfg := consulConfig()
+		if err := consul.SetPartition(cfg, c.flagPartitionName); err != nil {
+			c.log.Error(fmt.Sprintf("Error creating Consul client for partition %q: %s", c.flagPartitionName, err))
+			return 1
+		}
+		consulClient, err = c.newConsulClient(cfg)
+		if err != nil {
+			c.log.Error(fmt.Sprintf("Error creating Consul client for partition %q: %s", c.flagPartitionName, err))
+			return 1
+		}
 	}
 
 	// If namespaces are enabled, to allow cross-Consul-namespace permissions
@@ -397,7 +650,8 @@ func (c *Command) Run(args []string) int {
 	// created by consul-k8s components (this bootstrapper, catalog sync or
 	// connect inject) needs to reference this policy on namespace creation
 	// to finish the cross namespace permission setup.
-	if c.flagEnableNamespaces {
+	if c.flagEnableNamespaces && !c.skipStep(statusStepCrossNamespacePolicy) {
+		c.status.start(statusStepCrossNamespacePolicy)
 		policyTmpl := api.ACLPolicy{
 			Name:        "cross-namespace-policy",
 			Description: "Policy to allow permissions to cross Consul namespaces for k8s services",
@@ -433,6 +687,7 @@ func (c *Command) Run(args []string) int {
 			}
 			return 1
 		}
+		c.completeStep(statusStepCrossNamespacePolicy)
 	}
 
 	if c.flagCreateClientToken {
@@ -449,12 +704,14 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
-	if c.createAnonymousPolicy() {
+	if c.createAnonymousPolicy() && !c.skipStep(statusStepAnonymousPolicy) {
+		c.status.start(statusStepAnonymousPolicy)
 		err := c.configureAnonymousPolicy(consulClient)
 		if err != nil {
 			c.log.Error(err.Error())
 			return 1
 		}
+		c.completeStep(statusStepAnonymousPolicy)
 	}
 
 	if c.flagCreateSyncToken {
@@ -478,10 +735,14 @@ func (c *Command) Run(args []string) int {
 	}
 
 	if c.flagCreateInjectToken {
-		err := c.configureConnectInjectAuthMethod(consulClient)
-		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+		if !c.skipStep(statusStepInjectAuthMethod) {
+			c.status.start(statusStepInjectAuthMethod)
+			err := c.configureConnectInjectAuthMethod(consulClient)
+			if err != nil {
+				c.log.Error(err.Error())
+				return 1
+			}
+			c.completeStep(statusStepInjectAuthMethod)
 		}
 
 		// If health checks or namespaces are enabled,
@@ -683,14 +944,75 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
+	if c.authMethods != nil && !c.skipStep(statusStepAuthMethods) {
+		c.status.start(statusStepAuthMethods)
+		if err := c.configureAuthMethods(consulClient); err != nil {
+			c.log.Error(err.Error())
+			return 1
+		}
+		c.completeStep(statusStepAuthMethods)
+	}
+
+	for name := range c.policyTemplates {
+		if !c.appliedPolicyTemplates[name] {
+			c.log.Warn("Policy template doesn't match any token that was created", "template", name+policyTemplateExt)
+		}
+	}
+
+	if err := c.saveState(); err != nil {
+		c.log.Error("Error recording the state of the run", "err", err)
+		return 1
+	}
+	c.status.succeeded()
+	if c.flagRotateInterval > 0 {
+		return c.rotatePeriodically(consulClient)
+	}
+
 	c.log.Info("server-acl-init completed successfully")
 	return 0
 }
 
+// rotatePeriodically rotates the component tokens every -rotate-interval
+// until the command receives an interrupt or terminate signal. It returns
+// the exit code of the command.
+func (c *Command) rotatePeriodically(consulClient *api.Client) int {
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
+	c.log.Info("Rotating tokens periodically", "interval", c.flagRotateInterval, "tokens", len(c.componentTokens))
+	for {
+		select {
+		case sig := <-c.sigCh:
+			c.log.Info(fmt.Sprintf("%s received, shutting down", sig))
+			return 0
+		case <-time.After(c.flagRotateInterval):
+		}
+
+		// Each rotation gets the full timeout rather than what's left of
+		// the timeout of the initial run.
+		var cancel context.CancelFunc
+		c.cmdTimeout, cancel = context.WithTimeout(context.Background(), c.flagTimeout)
+		c.status.start(statusStepRotateTokens)
+		err := c.rotateTokens(consulClient)
+		cancel()
+		if err != nil {
+			c.log.Error("Error rotating tokens", "err", err)
+			return 1
+		}
+		c.status.complete(statusStepRotateTokens)
+		c.log.Info("Rotated tokens", "tokens", len(c.componentTokens))
+	}
+}
+
 // getBootstrapToken returns the existing bootstrap token if there is one by
-// reading the Kubernetes Secret with name secretName.
+// reading the Kubernetes Secret with name secretName, or the Vault secret
+// that stores it instead.
 // If there is no bootstrap token yet, then it returns an empty string (not an error).
 func (c *Command) getBootstrapToken(secretName string) (string, error) {
+	if c.storedInVault(secretName) {
+		return c.tokenFromVault(secretName)
+	}
 	secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
@@ -705,8 +1027,23 @@ func (c *Command) getBootstrapToken(secretName string) (string, error) {
 	return string(token), nil
 }
 
+// bootstrapTokenFromSecret returns the ACL token in the data of the Secret
+// set by -bootstrap-token-secret-name.
+func (c *Command) bootstrapTokenFromSecret() (string, error) {
+	name, key := c.flagBootstrapTokenSecretName, c.flagBootstrapTokenSecretKey
+	secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to read bootstrap token from Secret %q: %s", name, err)
+	}
+	token := strings.TrimSpace(string(secret.Data[key]))
+	if token == "" {
+		return "", fmt.Errorf("bootstrap token Secret %q has no value for key %q", name, key)
+	}
+	return token, nil
+}
+
 func (c *Command) configureKubeClient() error {
-	config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+	config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
 	if err != nil {
 		return fmt.Errorf("error retrieving Kubernetes auth: %s", err)
 	}
@@ -775,6 +1112,15 @@ func (c *Command) consulDatacenter(client *api.Client) (string, error) {
 	return dc, nil
 }
 
+// newConsulClient returns a Consul API client for cfg whose calls are bounded by
+// -consul-api-timeout.
+func (c *Command) newConsulClient(cfg *api.Config) (*api.Client, error) {
+	if err := consul.SetTimeout(cfg, c.flagConsulAPITimeout); err != nil {
+		return nil, err
+	}
+	return consul.NewClient(cfg)
+}
+
 // createAnonymousPolicy returns whether we should create a policy for the
 // anonymous ACL token, i.e. queries without ACL tokens.
 func (c *Command) createAnonymousPolicy() bool {
@@ -784,7 +1130,9 @@ func (c *Command) createAnonymousPolicy() bool {
 	// We don't want to modify the anonymous policy in secondary datacenters
 	// because it is global and we can't create separate tokens for each
 	// secondary datacenter because the anonymous token is global.
-	return c.flagACLReplicationTokenFile == "" &&
+	// The anonymous token belongs to the default partition so it's only
+	// configured by the run in the default partition.
+	return c.flagACLReplicationTokenFile == "" && !c.inNonDefaultPartition() &&
 		// Consul DNS requires the anonymous policy because DNS queries don't
 		// have ACL tokens.
 		(c.flagAllowDNS ||
@@ -807,6 +1155,38 @@ func (c *Command) validateFlags() error {
 		return errors.New("-resource-prefix must be set")
 	}
 
+	if c.flagConsulAPITimeout < 0 {
+		return errors.New("-consul-api-timeout must not be negative")
+	}
+
+	if c.flagBootstrapTokenFile != "" && c.flagBootstrapTokenSecretName != "" {
+		return errors.New("only one of -bootstrap-token-file and -bootstrap-token-secret-name can be set")
+	}
+
+	if c.flagEnablePartitions && c.flagPartitionName == "" {
+		return errors.New("-partition-name must be set if -enable-partitions is true")
+	}
+
+	if c.flagRotateInterval < 0 {
+		return errors.New("-rotate-interval must not be negative")
+	}
+
+	if c.flagVaultAddr != "" {
+		switch c.flagVaultAuthMethod {
+		case vault.AuthMethodToken:
+		case vault.AuthMethodKubernetes:
+			if c.flagVaultRole == "" {
+				return fmt.Errorf("-vault-role must be set when -vault-auth-method is %q", vault.AuthMethodKubernetes)
+			}
+		default:
+			return fmt.Errorf("-vault-auth-method must be one of %q or %q", vault.AuthMethodToken, vault.AuthMethodKubernetes)
+		}
+	}
+
+	if err := c.secret.Validate(); err != nil {
+		return err
+	}
+
 	// For the Consul node name to be discoverable via DNS, it must contain only
 	// dashes and alphanumeric characters. Length is also constrained.
 	// These restrictions match those defined in Consul's agent definition.
@@ -831,6 +1211,9 @@ func (c *Command) validateFlags() error {
 	return nil
 }
 
+// secretCreatedBy is the name of the command set on the Secrets it creates.
+const secretCreatedBy = "server-acl-init"
+
 const consulDefaultNamespace = "default"
 const synopsis = "Initialize ACLs on Consul servers and other components."
 const help = `
@@ -839,6 +1222,7 @@ Usage: consul-k8s server-acl-init [options]
   Bootstraps servers with ACLs and creates policies and ACL tokens for other
   components as Kubernetes Secrets.
   It will run indefinitely until all tokens have been created. It is idempotent
-  and safe to run multiple times.
+  and safe to run multiple times. Re-runs with an unchanged configuration skip
+  the steps that previous runs completed unless -force is set.
 
 `
@@ -2,7 +2,12 @@ package serveraclinit
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -10,6 +15,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -74,6 +80,33 @@ func TestRun_FlagValidation(t *testing.T) {
 			ExpErr: "-sync-consul-node-name=5r9OPGfSRXUdGzNjBdAwmhCBrzHDNYs4XjZVR4wp7lSLIzqwS0ta51nBLIN0TMPV-too-long is invalid: node name will not be discoverable " +
 				"via DNS due to it being too long. Valid lengths are between 1 and 63 bytes",
 		},
+		{
+			Flags: []string{"-bootstrap-token-file=/notexist", "-bootstrap-token-secret-name=token",
+				"-server-address=localhost", "-resource-prefix=prefix"},
+			ExpErr: "only one of -bootstrap-token-file and -bootstrap-token-secret-name can be set",
+		},
+		{
+			Flags:  []string{"-enable-partitions", "-partition-name=", "-server-address=localhost", "-resource-prefix=prefix"},
+			ExpErr: "-partition-name must be set if -enable-partitions is true",
+		},
+		{
+			Flags:  []string{"-rotate-interval=-1s", "-server-address=localhost", "-resource-prefix=prefix"},
+			ExpErr: "-rotate-interval must not be negative",
+		},
+		{
+			Flags:  []string{"-policy-templates-dir=/notexist", "-server-address=localhost", "-resource-prefix=prefix"},
+			ExpErr: "Unable to load policy templates from \"/notexist\": stat /notexist: no such file or directory",
+		},
+		{
+			Flags: []string{"-vault-addr=https://vault:8200", "-vault-auth-method=approle",
+				"-server-address=localhost", "-resource-prefix=prefix"},
+			ExpErr: `-vault-auth-method must be one of "token" or "kubernetes"`,
+		},
+		{
+			Flags: []string{"-vault-addr=https://vault:8200", "-vault-auth-method=kubernetes",
+				"-server-address=localhost", "-resource-prefix=prefix"},
+			ExpErr: `-vault-role must be set when -vault-auth-method is "kubernetes"`,
+		},
 	}
 
 	for _, c := range cases {
@@ -140,6 +173,45 @@ func TestRun_Defaults(t *testing.T) {
 	// endpoint was called.
 }
 
+// Test that the Secrets created for the tokens have the labels from the flags
+// and the ones describing what created them.
+func TestRun_SecretLabels(t *testing.T) {
+	t.Parallel()
+
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+	require := require.New(t)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode := cmd.Run([]string{
+		"-timeout=1m",
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-resource-prefix=" + resourcePrefix,
+		"-create-client-token",
+		"-release-name=release-name",
+		"-secret-label=team=mesh",
+	})
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	for _, name := range []string{resourcePrefix + "-bootstrap-acl-token", resourcePrefix + "-client-acl-token"} {
+		secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(err)
+		require.Equal(map[string]string{
+			"team":                         "mesh",
+			"release":                      "release-name",
+			"app.kubernetes.io/managed-by": "consul-k8s",
+			"app.kubernetes.io/created-by": "server-acl-init",
+		}, secret.Labels, name)
+		require.Contains(secret.Annotations, "consul.hashicorp.com/created-by", name)
+	}
+}
+
 // Test the different flags that should create tokens and save them as
 // Kubernetes secrets.
 func TestRun_TokensPrimaryDC(t *testing.T) {
@@ -1117,6 +1189,305 @@ func TestRun_SyncPolicyUpdates(t *testing.T) {
 	}
 }
 
+// Test that policy templates are appended to the policies of their token and
+// that the policies are updated when the templates change.
+func TestRun_PolicyTemplates(t *testing.T) {
+	t.Parallel()
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	writeTemplate := func(rules string) {
+		require.NoError(ioutil.WriteFile(filepath.Join(dir, "catalog-sync.hcl"), []byte(rules), 0600))
+	}
+	writeTemplate(`key_prefix "sync/{{ .SyncConsulNodeName }}" {
+  policy = "write"
+}`)
+
+	args := []string{
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-create-sync-token",
+		"-policy-templates-dir", dir,
+	}
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode := cmd.Run(args)
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	bootToken := getBootToken(t, k8s, resourcePrefix, ns)
+	consul, err := api.NewClient(&api.Config{
+		Address: testSvr.HTTPAddr,
+		Token:   bootToken,
+	})
+	require.NoError(err)
+	policy, _, err := consul.ACL().PolicyRead(policyExists(t, "catalog-sync-token", consul).ID, nil)
+	require.NoError(err)
+	require.Contains(policy.Rules, `node "k8s-sync"`)
+	require.Contains(policy.Rules, `key_prefix "sync/k8s-sync"`)
+
+	// Re-run the command with a changed template. The policy should be updated.
+	writeTemplate(`key_prefix "sync-v2/" {
+  policy = "read"
+}`)
+	cmd = Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode = cmd.Run(args)
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+	policy, _, err = consul.ACL().PolicyRead(policy.ID, nil)
+	require.NoError(err)
+	require.Contains(policy.Rules, `key_prefix "sync-v2/"`)
+	require.NotContains(policy.Rules, `key_prefix "sync/k8s-sync"`)
+}
+
+// Test that the auth methods of -auth-methods-file are created with their
+// binding rules, and that the binding rules are updated when the file
+// changes.
+func TestRun_AuthMethods(t *testing.T) {
+	t.Parallel()
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	require.NoError(err)
+	pubKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(err)
+	pubKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKey})
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "auth-methods.yaml")
+	writeFile := func(bindingRules string) {
+		contents := fmt.Sprintf(`
+authMethods:
+- name: workload-jwt
+  type: jwt
+  description: Workload identity
+  maxTokenTTL: 1h
+  config:
+    BoundIssuer: https://issuer.example.com
+    JWTValidationPubKeys:
+    - %q
+    ClaimMappings:
+      service: service
+  bindingRules:
+%s`, pubKeyPEM, bindingRules)
+		require.NoError(ioutil.WriteFile(file, []byte(contents), 0600))
+	}
+	writeFile(`
+  - description: services
+    bindType: service
+    bindName: ${value.service}
+  - description: admins
+    selector: value.service==admin
+    bindType: role
+    bindName: admin`)
+
+	args := []string{
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-auth-methods-file", file,
+	}
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode := cmd.Run(args)
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	bootToken := getBootToken(t, k8s, resourcePrefix, ns)
+	consul, err := api.NewClient(&api.Config{
+		Address: testSvr.HTTPAddr,
+		Token:   bootToken,
+	})
+	require.NoError(err)
+	authMethod, _, err := consul.ACL().AuthMethodRead("workload-jwt", nil)
+	require.NoError(err)
+	require.Equal("jwt", authMethod.Type)
+	require.Equal("Workload identity", authMethod.Description)
+	require.Equal(time.Hour, authMethod.MaxTokenTTL)
+	require.Equal("https://issuer.example.com", authMethod.Config["BoundIssuer"])
+
+	rules, _, err := consul.ACL().BindingRuleList("workload-jwt", nil)
+	require.NoError(err)
+	require.Len(rules, 2)
+	byDescription := make(map[string]*api.ACLBindingRule)
+	for _, rule := range rules {
+		byDescription[rule.Description] = rule
+	}
+	require.Equal(api.BindingRuleBindTypeService, byDescription["services"].BindType)
+	require.Equal("${value.service}", byDescription["services"].BindName)
+	require.Equal(api.BindingRuleBindTypeRole, byDescription["admins"].BindType)
+	require.Equal("value.service==admin", byDescription["admins"].Selector)
+
+	// Re-run the command with a changed binding rule and without the other.
+	// The first should be updated and the other deleted.
+	writeFile(`
+  - description: services
+    selector: value.service!=admin
+    bindType: service
+    bindName: ${value.service}`)
+	cmd = Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode = cmd.Run(args)
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+	rules, _, err = consul.ACL().BindingRuleList("workload-jwt", nil)
+	require.NoError(err)
+	require.Len(rules, 1)
+	require.Equal(byDescription["services"].ID, rules[0].ID)
+	require.Equal("value.service!=admin", rules[0].Selector)
+}
+
+// Test that -rotate replaces the tokens in the Secrets and revokes the old
+// tokens.
+func TestRun_RotateTokens(t *testing.T) {
+	t.Parallel()
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+	require := require.New(t)
+
+	args := []string{
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-create-sync-token",
+		"-create-mesh-gateway-token",
+	}
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode := cmd.Run(args)
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	secretNames := []string{
+		resourcePrefix + "-client-acl-token",
+		resourcePrefix + "-catalog-sync-acl-token",
+		resourcePrefix + "-mesh-gateway-acl-token",
+	}
+	oldTokens := make(map[string]string)
+	for _, name := range secretNames {
+		oldTokens[name] = getSecretToken(t, k8s, name)
+	}
+
+	cmd = Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode = cmd.Run(append(args, "-rotate"))
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	bootToken := getBootToken(t, k8s, resourcePrefix, ns)
+	consul, err := api.NewClient(&api.Config{
+		Address: testSvr.HTTPAddr,
+		Token:   bootToken,
+	})
+	require.NoError(err)
+	for _, name := range secretNames {
+		newToken := getSecretToken(t, k8s, name)
+		require.NotEqual(oldTokens[name], newToken, name)
+
+		// The new token works and the old one was revoked.
+		_, _, err := consul.ACL().TokenReadSelf(&api.QueryOptions{Token: newToken})
+		require.NoError(err, name)
+		_, _, err = consul.ACL().TokenReadSelf(&api.QueryOptions{Token: oldTokens[name]})
+		require.Error(err, name)
+		require.Contains(err.Error(), "ACL not found", name)
+	}
+}
+
+// Test that -rotate-interval keeps rotating the tokens until interrupted, and
+// that each token is only revoked on the rotation after the one that
+// replaced it.
+func TestRun_RotateTokensPeriodically(t *testing.T) {
+	t.Parallel()
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+		sigCh:     make(chan os.Signal, 1),
+	}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-resource-prefix=" + resourcePrefix,
+			"-k8s-namespace=" + ns,
+			"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+			"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+			"-create-sync-token",
+			"-rotate-interval=100ms",
+		})
+	}()
+
+	secretName := resourcePrefix + "-catalog-sync-acl-token"
+	var first string
+	retry.Run(t, func(r *retry.R) {
+		secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+		require.NoError(r, err)
+		first = string(secret.Data["token"])
+	})
+	// The token is rotated at least twice.
+	seen := map[string]bool{first: true}
+	retry.Run(t, func(r *retry.R) {
+		seen[getSecretToken(t, k8s, secretName)] = true
+		if len(seen) < 3 {
+			r.Fatalf("token rotated %d times", len(seen)-1)
+		}
+	})
+
+	cmd.sigCh <- os.Interrupt
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code, ui.ErrorWriter.String())
+	case <-time.After(10 * time.Second):
+		t.Fatal("command didn't exit after interrupt")
+	}
+
+	bootToken := getBootToken(t, k8s, resourcePrefix, ns)
+	consul, err := api.NewClient(&api.Config{
+		Address: testSvr.HTTPAddr,
+		Token:   bootToken,
+	})
+	require.NoError(t, err)
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// The current token and the one it replaced work.
+	_, _, err = consul.ACL().TokenReadSelf(&api.QueryOptions{Token: string(secret.Data["token"])})
+	require.NoError(t, err)
+	previous, _, err := consul.ACL().TokenRead(secret.Annotations[previousTokenAnnotation], nil)
+	require.NoError(t, err)
+	require.NotEqual(t, string(secret.Data["token"]), previous.SecretID)
+
+	// The first token was replaced at least two rotations ago so it was
+	// revoked.
+	_, _, err = consul.ACL().TokenReadSelf(&api.QueryOptions{Token: first})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ACL not found")
+}
+
 // Test that we give an error if an ACL policy we were going to create
 // already exists but it has a different description than what consul-k8s
 // expected. In this case, it's likely that a user manually created an ACL
@@ -1575,65 +1946,121 @@ func TestRun_AlreadyBootstrapped(t *testing.T) {
 // and continue on to the next step.
 func TestRun_SkipBootstrapping_WhenBootstrapTokenIsProvided(t *testing.T) {
 	t.Parallel()
-	require := require.New(t)
-	k8s := fake.NewSimpleClientset()
-
 	bootToken := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
-	tokenFile := writeTempFile(t, bootToken)
-
-	type APICall struct {
-		Method string
-		Path   string
+	cases := map[string]func(t *testing.T, k8s *fake.Clientset) []string{
+		"file": func(t *testing.T, _ *fake.Clientset) []string {
+			return []string{"-bootstrap-token-file=" + writeTempFile(t, bootToken)}
+		},
+		"secret": func(t *testing.T, k8s *fake.Clientset) []string {
+			_, err := k8s.CoreV1().Secrets(ns).Create(context.Background(), &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "external-consul-token"},
+				Data:       map[string][]byte{"management": []byte(bootToken)},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+			return []string{
+				"-bootstrap-token-secret-name=external-consul-token",
+				"-bootstrap-token-secret-key=management",
+			}
+		},
 	}
-	var consulAPICalls []APICall
+	for name, flags := range cases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			k8s := fake.NewSimpleClientset()
 
-	// Start the Consul server.
-	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Record all the API calls made.
-		consulAPICalls = append(consulAPICalls, APICall{
-			Method: r.Method,
-			Path:   r.URL.Path,
-		})
-		switch r.URL.Path {
-		case "/v1/agent/self":
-			fmt.Fprintln(w, `{"Config": {"Datacenter": "dc1"}}`)
-		default:
-			// Send an empty JSON response with code 200 to all calls.
-			fmt.Fprintln(w, "{}")
-		}
-	}))
-	defer consulServer.Close()
+			type APICall struct {
+				Method string
+				Path   string
+				Token  string
+			}
+			var consulAPICalls []APICall
+
+			// Start the Consul server.
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Record all the API calls made.
+				consulAPICalls = append(consulAPICalls, APICall{
+					Method: r.Method,
+					Path:   r.URL.Path,
+					Token:  r.Header.Get("X-Consul-Token"),
+				})
+				switch r.URL.Path {
+				case "/v1/agent/self":
+					fmt.Fprintln(w, `{"Config": {"Datacenter": "dc1"}}`)
+				default:
+					// Send an empty JSON response with code 200 to all calls.
+					fmt.Fprintln(w, "{}")
+				}
+			}))
+			defer consulServer.Close()
 
-	serverURL, err := url.Parse(consulServer.URL)
-	require.NoError(err)
+			serverURL, err := url.Parse(consulServer.URL)
+			require.NoError(err)
 
-	// Run the command.
-	ui := cli.NewMockUi()
-	cmd := Command{
-		UI:        ui,
-		clientset: k8s,
+			// Run the command.
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: k8s,
+			}
+
+			responseCode := cmd.Run(append([]string{
+				"-timeout=500ms",
+				"-resource-prefix=" + resourcePrefix,
+				"-k8s-namespace=" + ns,
+				"-server-address=" + serverURL.Hostname(),
+				"-server-port=" + serverURL.Port(),
+				"-create-client-token=false", // disable client token, so there are less calls
+			}, flags(t, k8s)...))
+			require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+			// Test that the expected API calls were made.
+			// We expect not to see the call to /v1/acl/bootstrap.
+			require.Equal([]APICall{
+				// We only expect the calls to get the datacenter
+				{
+					"GET",
+					"/v1/agent/self",
+					bootToken,
+				},
+			}, consulAPICalls)
+		})
 	}
+}
 
-	responseCode := cmd.Run([]string{
-		"-timeout=500ms",
-		"-resource-prefix=" + resourcePrefix,
-		"-k8s-namespace=" + ns,
-		"-server-address=" + serverURL.Hostname(),
-		"-server-port=" + serverURL.Port(),
-		"-bootstrap-token-file=" + tokenFile,
-		"-create-client-token=false", // disable client token, so there are less calls
+// Test that reading the bootstrap token fails if the Secret doesn't exist or
+// doesn't have the token.
+func TestBootstrapTokenFromSecret_Errors(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "external-consul-token", Namespace: ns},
+		Data:       map[string][]byte{"management": []byte("aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee")},
 	})
-	require.Equal(0, responseCode, ui.ErrorWriter.String())
-
-	// Test that the expected API calls were made.
-	// We expect not to see the call to /v1/acl/bootstrap.
-	require.Equal([]APICall{
-		// We only expect the calls to get the datacenter
-		{
-			"GET",
-			"/v1/agent/self",
+	cases := map[string]struct {
+		name   string
+		expErr string
+	}{
+		"missing secret": {
+			name:   "does-not-exist",
+			expErr: `unable to read bootstrap token from Secret "does-not-exist"`,
 		},
-	}, consulAPICalls)
+		"missing key": {
+			name:   "external-consul-token",
+			expErr: `bootstrap token Secret "external-consul-token" has no value for key "token"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			cmd := Command{
+				clientset:                    k8s,
+				flagK8sNamespace:             ns,
+				flagBootstrapTokenSecretName: c.name,
+				flagBootstrapTokenSecretKey:  "token",
+			}
+			_, err := cmd.bootstrapTokenFromSecret()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expErr)
+		})
+	}
 }
 
 // Test that we exit after timeout.
@@ -1906,6 +2333,168 @@ func TestRun_GatewayErrors(t *testing.T) {
 	}
 }
 
+// Test that the progress and result of the command are written to the
+// -status-config-map ConfigMap and that Events are emitted for it.
+func TestRun_StatusConfigMap(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		flags          []string
+		expCode        int
+		expStatus      map[string]string
+		expLastReason  string
+		expLastMessage string
+	}{
+		"success": {
+			flags:   []string{"-create-client-token", "-create-sync-token"},
+			expCode: 0,
+			expStatus: map[string]string{
+				statusKeyPhase:          statusPhaseCompleted,
+				statusKeyCompletedSteps: "bootstrap,client-token,catalog-sync-token",
+			},
+			expLastReason:  "Completed",
+			expLastMessage: "server-acl-init completed successfully",
+		},
+		"failure": {
+			flags:   []string{"-create-client-token", "-ingress-gateway-name=gw.ns"},
+			expCode: 1,
+			expStatus: map[string]string{
+				statusKeyPhase:          statusPhaseFailed,
+				statusKeyCompletedSteps: "bootstrap,client-token",
+				statusKeyFailedStep:     "",
+				statusKeyError:          "Gateway names shouldn't include a namespace if Consul namespaces aren't enabled gateway-name=gw.ns",
+			},
+			expLastReason: "Failed",
+			expLastMessage: "server-acl-init failed: Gateway names shouldn't include a namespace if Consul " +
+				"namespaces aren't enabled gateway-name=gw.ns",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s, testSvr := completeSetup(t)
+			defer testSvr.Stop()
+			require := require.New(t)
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: k8s,
+			}
+			args := append([]string{
+				"-timeout=1m",
+				"-resource-prefix=" + resourcePrefix,
+				"-k8s-namespace=" + ns,
+				"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+				"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+				"-status-config-map=acl-init-status",
+			}, c.flags...)
+			require.Equal(c.expCode, cmd.Run(args), ui.ErrorWriter.String())
+
+			configMap, err := k8s.CoreV1().ConfigMaps(ns).Get(context.Background(), "acl-init-status", metav1.GetOptions{})
+			require.NoError(err)
+			require.NotEmpty(configMap.Data[statusKeyUpdated])
+			delete(configMap.Data, statusKeyUpdated)
+			require.Equal(c.expStatus, configMap.Data)
+
+			events, err := k8s.CoreV1().Events(ns).List(context.Background(), metav1.ListOptions{})
+			require.NoError(err)
+			steps := strings.Split(c.expStatus[statusKeyCompletedSteps], ",")
+			require.Len(events.Items, len(steps)+1)
+			for _, event := range events.Items {
+				require.Equal("ConfigMap", event.InvolvedObject.Kind)
+				require.Equal("acl-init-status", event.InvolvedObject.Name)
+			}
+			// The events are listed in the order of their names, which
+			// have the time they were created as a suffix.
+			last := events.Items[len(events.Items)-1]
+			require.Equal(c.expLastReason, last.Reason)
+			require.Equal(c.expLastMessage, last.Message)
+		})
+	}
+}
+
+// Test that a re-run with an unchanged configuration skips the steps of the
+// last run other than creating the tokens whose Secrets were deleted, and
+// that -force runs them again.
+func TestRun_UnchangedConfig(t *testing.T) {
+	t.Parallel()
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+	require := require.New(t)
+
+	args := []string{
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-create-sync-token",
+		"-create-mesh-gateway-token",
+	}
+	run := func(args ...string) {
+		t.Helper()
+		ui := cli.NewMockUi()
+		cmd := Command{
+			UI:        ui,
+			clientset: k8s,
+		}
+		require.Equal(0, cmd.Run(args), ui.ErrorWriter.String())
+	}
+	requireState := func(expSteps string) string {
+		t.Helper()
+		secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), resourcePrefix+"-bootstrap-acl-token", metav1.GetOptions{})
+		require.NoError(err)
+		require.Equal(expSteps, secret.Annotations[stateAnnotationCompletedSteps])
+		require.NotEmpty(secret.Annotations[stateAnnotationConfigHash])
+		return secret.Annotations[stateAnnotationConfigHash]
+	}
+	run(args...)
+	hash := requireState("bootstrap,client-token,catalog-sync-token,mesh-gateway-token")
+
+	bootToken := getBootToken(t, k8s, resourcePrefix, ns)
+	consul, err := api.NewClient(&api.Config{
+		Address: testSvr.HTTPAddr,
+		Token:   bootToken,
+	})
+	require.NoError(err)
+	syncPolicy, _, err := consul.ACL().PolicyReadByName("catalog-sync-token", nil)
+	require.NoError(err)
+	syncPolicy.Rules = `node_prefix "" { policy = "read" }`
+	_, _, err = consul.ACL().PolicyUpdate(syncPolicy, nil)
+	require.NoError(err)
+	meshGatewaySecret := resourcePrefix + "-mesh-gateway-acl-token"
+	meshGatewayToken := getSecretToken(t, k8s, meshGatewaySecret)
+	require.NoError(k8s.CoreV1().Secrets(ns).Delete(context.Background(), meshGatewaySecret, metav1.DeleteOptions{}))
+
+	// The sync policy isn't updated but the mesh gateway token is created
+	// again. The server policy wasn't updated by the first run since it
+	// bootstrapped the servers.
+	run(args...)
+	require.Equal(hash, requireState("bootstrap,server-policy,client-token,catalog-sync-token,mesh-gateway-token"))
+	syncPolicy, _, err = consul.ACL().PolicyReadByName("catalog-sync-token", nil)
+	require.NoError(err)
+	require.Equal(`node_prefix "" { policy = "read" }`, syncPolicy.Rules)
+	require.NotEqual(meshGatewayToken, getSecretToken(t, k8s, meshGatewaySecret))
+
+	// Flags that don't change the configuration don't make it run again.
+	run(append(args, "-log-level=debug", "-timeout=5m")...)
+	syncPolicy, _, err = consul.ACL().PolicyReadByName("catalog-sync-token", nil)
+	require.NoError(err)
+	require.Equal(`node_prefix "" { policy = "read" }`, syncPolicy.Rules)
+
+	run(append(args, "-force")...)
+	require.Equal(hash, requireState("bootstrap,server-policy,client-token,catalog-sync-token,mesh-gateway-token"))
+	syncPolicy, _, err = consul.ACL().PolicyReadByName("catalog-sync-token", nil)
+	require.NoError(err)
+	require.Contains(syncPolicy.Rules, "k8s-sync")
+
+	// A changed configuration runs every step.
+	run(append(args, "-sync-consul-node-name=new-node-name")...)
+	require.NotEqual(hash, requireState("bootstrap,server-policy,client-token,catalog-sync-token,mesh-gateway-token"))
+	syncPolicy, _, err = consul.ACL().PolicyReadByName("catalog-sync-token", nil)
+	require.NoError(err)
+	require.Contains(syncPolicy.Rules, "new-node-name")
+}
+
 // Set up test consul agent and kubernetes cluster.
 func completeSetup(t *testing.T) (*fake.Clientset, *testutil.TestServer) {
 	k8s := fake.NewSimpleClientset()
@@ -2197,6 +2786,13 @@ func createOrUpdateSecret(t *testing.T, k8s *fake.Clientset, secret *v1.Secret,
 
 // policyExists asserts that policy with name exists. Returns the policy
 // if it does, otherwise fails the test.
+// getSecretToken returns the token in the Secret secretName.
+func getSecretToken(t *testing.T, k8s *fake.Clientset, secretName string) string {
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	return string(secret.Data["token"])
+}
+
 func policyExists(t require.TestingT, name string, client *api.Client) *api.ACLPolicyListEntry {
 	policies, _, err := client.ACL().PolicyList(nil)
 	require.NoError(t, err)
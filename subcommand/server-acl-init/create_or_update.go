@@ -28,14 +28,54 @@ func (c *Command) createGlobalACL(name, rules, dc string, consulClient *api.Clie
 // the token will be a local token and the policy will be scoped to only dc.
 // If localToken is false, the policy will be global.
 // The token will be written to a Kubernetes secret.
-func (c *Command) createACL(name, rules string, localToken bool, dc string, consulClient *api.Client) error {
-	// Create policy with the given rules.
+func (c *Command) createACL(name, rules string, localToken bool, dc string, consulClient *api.Client) (err error) {
 	policyName := fmt.Sprintf("%s-token", name)
 	if c.flagACLReplicationTokenFile != "" {
 		// If performing ACL replication, we must ensure policy names are
 		// globally unique so we append the datacenter name.
 		policyName += fmt.Sprintf("-%s", dc)
 	}
+	secretName := c.withPrefix(name + "-acl-token")
+	t := componentToken{name: name, secretName: secretName, policyName: policyName, local: localToken}
+
+	// If the configuration is unchanged since the last run, the policy is
+	// up to date and the token only needs to be created again if its
+	// Secret was deleted.
+	step := fmt.Sprintf("%s-token", name)
+	if c.canSkipStep(step) {
+		stored, err := c.tokenStored(secretName)
+		if err != nil {
+			return err
+		}
+		if stored {
+			if name != common.ACLReplicationTokenName {
+				c.componentTokens = append(c.componentTokens, t)
+			}
+			if _, ok := c.policyTemplates[name]; ok {
+				if c.appliedPolicyTemplates == nil {
+					c.appliedPolicyTemplates = make(map[string]bool)
+				}
+				c.appliedPolicyTemplates[name] = true
+			}
+			c.skipStep(step)
+			return nil
+		}
+		c.log.Info(fmt.Sprintf("Secret %q of a token created by the last run is missing, creating the token again", secretName))
+	}
+
+	c.status.start(step)
+	defer func() {
+		if err == nil {
+			c.completeStep(step)
+		}
+	}()
+
+	rules, err = c.withPolicyTemplate(name, rules)
+	if err != nil {
+		return fmt.Errorf("error rendering policy template for %s token: %s", name, err)
+	}
+
+	// Create policy with the given rules.
 	var datacenters []string
 	if localToken && dc != "" {
 		datacenters = append(datacenters, dc)
@@ -46,7 +86,7 @@ func (c *Command) createACL(name, rules string, localToken bool, dc string, cons
 		Rules:       rules,
 		Datacenters: datacenters,
 	}
-	err := c.untilSucceeds(fmt.Sprintf("creating %s policy", policyTmpl.Name),
+	err = c.untilSucceeds(fmt.Sprintf("creating %s policy", policyTmpl.Name),
 		func() error {
 			return c.createOrUpdateACLPolicy(policyTmpl, consulClient)
 		})
@@ -55,34 +95,32 @@ func (c *Command) createACL(name, rules string, localToken bool, dc string, cons
 	}
 
 	// Check if the secret already exists, if so, we assume the ACL has already been
-	// created and return.
-	secretName := c.withPrefix(name + "-acl-token")
-	_, err = c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
-	if err == nil {
+	// created and return, unless the token should be rotated.
+	if name != common.ACLReplicationTokenName {
+		c.componentTokens = append(c.componentTokens, t)
+	}
+	stored, err := c.tokenStored(secretName)
+	if err != nil {
+		return err
+	}
+	if stored {
+		if c.flagRotate && name != common.ACLReplicationTokenName {
+			return c.rotateToken(t, consulClient)
+		}
 		c.log.Info(fmt.Sprintf("Secret %q already exists", secretName))
 		return nil
 	}
 
 	// Create token for the policy if the secret did not exist previously.
-	tokenTmpl := api.ACLToken{
-		Description: fmt.Sprintf("%s Token", policyTmpl.Name),
-		Policies:    []*api.ACLTokenPolicyLink{{Name: policyTmpl.Name}},
-		Local:       localToken,
-	}
-	var token string
-	err = c.untilSucceeds(fmt.Sprintf("creating token for policy %s", policyTmpl.Name),
-		func() error {
-			createdToken, _, err := consulClient.ACL().TokenCreate(&tokenTmpl, &api.WriteOptions{})
-			if err == nil {
-				token = createdToken.SecretID
-			}
-			return err
-		})
+	token, err := c.createToken(policyTmpl.Name, localToken, consulClient)
 	if err != nil {
 		return err
 	}
 
-	// Write token to a Kubernetes secret.
+	// Write token to a Kubernetes secret, or to Vault.
+	if c.storedInVault(secretName) {
+		return c.tokenToVault(secretName, token)
+	}
 	return c.untilSucceeds(fmt.Sprintf("writing Secret for token %s", policyTmpl.Name),
 		func() error {
 			secret := &apiv1.Secret{
@@ -93,11 +131,44 @@ func (c *Command) createACL(name, rules string, localToken bool, dc string, cons
 					common.ACLTokenSecretKey: []byte(token),
 				},
 			}
+			c.secret.MergeOntoObjectMeta(&secret.ObjectMeta, secretCreatedBy)
 			_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Create(context.TODO(), secret, metav1.CreateOptions{})
 			return err
 		})
 }
 
+// tokenStored returns true if the token whose Secret is secretName was
+// already created, i.e. its Secret, or the Vault secret that stores it
+// instead, exists.
+func (c *Command) tokenStored(secretName string) (bool, error) {
+	if c.storedInVault(secretName) {
+		token, err := c.tokenFromVault(secretName)
+		return token != "", err
+	}
+	_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	return err == nil, nil
+}
+
+// createToken creates a token for the policy policyName and returns its
+// secret ID.
+func (c *Command) createToken(policyName string, localToken bool, consulClient *api.Client) (string, error) {
+	tokenTmpl := api.ACLToken{
+		Description: fmt.Sprintf("%s Token", policyName),
+		Policies:    []*api.ACLTokenPolicyLink{{Name: policyName}},
+		Local:       localToken,
+	}
+	var token string
+	err := c.untilSucceeds(fmt.Sprintf("creating token for policy %s", policyName),
+		func() error {
+			createdToken, _, err := consulClient.ACL().TokenCreate(&tokenTmpl, &api.WriteOptions{})
+			if err == nil {
+				token = createdToken.SecretID
+			}
+			return err
+		})
+	return token, err
+}
+
 func (c *Command) createOrUpdateACLPolicy(policy api.ACLPolicy, consulClient *api.Client) error {
 	// Attempt to create the ACL policy
 	_, _, err := consulClient.ACL().PolicyCreate(&policy, &api.WriteOptions{})
@@ -107,9 +178,10 @@ func (c *Command) createOrUpdateACLPolicy(policy api.ACLPolicy, consulClient *ap
 	// settings, the policies associated with their ACL tokens will need to be
 	// updated to be namespace aware.
 	// Allowing the Consul node name to be configurable also requires any sync
-	// policy to be updated in case the node name has changed.
+	// policy to be updated in case the node name has changed, and policy
+	// templates may have changed since the policies were created.
 	if isPolicyExistsErr(err, policy.Name) {
-		if c.flagEnableNamespaces || c.flagCreateSyncToken {
+		if c.flagEnableNamespaces || c.flagCreateSyncToken || len(c.policyTemplates) > 0 {
 			c.log.Info(fmt.Sprintf("Policy %q already exists, updating", policy.Name))
 
 			// The policy ID is required in any PolicyUpdate call, so first we need to
@@ -0,0 +1,53 @@
+package serveraclinit
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul/api"
+)
+
+// partition is an admin partition as read from and written to the
+// /v1/partition endpoints of Consul Enterprise.
+type partition struct {
+	Name        string
+	Description string `json:",omitempty"`
+}
+
+// inNonDefaultPartition returns true if admin partitions are enabled and this
+// cluster's partition isn't the default partition, in which case the
+// partition has to be created and the Consul client scoped to it.
+func (c *Command) inNonDefaultPartition() bool {
+	return c.flagEnablePartitions && c.flagPartitionName != consul.DefaultPartition
+}
+
+// createPartitionIfNotExists creates the -partition-name partition unless it
+// already exists. consulClient must not be scoped to a partition since
+// partitions are created from the default partition.
+func (c *Command) createPartitionIfNotExists(consulClient *api.Client) error {
+	// The Consul API client doesn't check the status code of raw queries so
+	// reading a missing partition by name can't be told apart from other
+	// errors. The partitions are listed instead.
+	var partitions []partition
+	_, err := consulClient.Raw().Query("/v1/partitions", &partitions, &api.QueryOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing partitions - ensure you're running Consul Enterprise with admin partitions enabled: %s", err)
+	}
+	for _, p := range partitions {
+		if p.Name == c.flagPartitionName {
+			c.log.Info("Partition already exists", "partition", c.flagPartitionName)
+			return nil
+		}
+	}
+
+	p := partition{
+		Name:        c.flagPartitionName,
+		Description: "Partition created by consul-k8s",
+	}
+	_, err = consulClient.Raw().Write("/v1/partition", &p, nil, &api.WriteOptions{})
+	if err != nil {
+		return err
+	}
+	c.log.Info("Created partition", "partition", c.flagPartitionName)
+	return nil
+}
@@ -0,0 +1,147 @@
+package serveraclinit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that with -enable-partitions the partition is created if it doesn't
+// exist and that the policies and tokens are created in it, except for the
+// anonymous token policy which only the default partition configures.
+func TestRun_Partitions(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		partitionName   string
+		partitionExists bool
+		expCreated      bool
+		expPartition    string
+	}{
+		"default partition": {
+			partitionName: "default",
+		},
+		"existing partition": {
+			partitionName:   "foo",
+			partitionExists: true,
+			expPartition:    "foo",
+		},
+		"missing partition": {
+			partitionName: "foo",
+			expCreated:    true,
+			expPartition:  "foo",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			k8s := fake.NewSimpleClientset()
+
+			type APICall struct {
+				Method    string
+				Path      string
+				Partition string
+			}
+			var lock sync.Mutex
+			var consulAPICalls []APICall
+			var created []partition
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+				consulAPICalls = append(consulAPICalls, APICall{
+					Method:    r.Method,
+					Path:      r.URL.Path,
+					Partition: r.URL.Query().Get("partition"),
+				})
+				switch r.URL.Path {
+				case "/v1/agent/self":
+					fmt.Fprintln(w, `{"Config": {"Datacenter": "dc1"}}`)
+				case "/v1/partitions":
+					if c.partitionExists {
+						fmt.Fprintln(w, `[{"Name": "default"}, {"Name": "foo"}]`)
+					} else {
+						fmt.Fprintln(w, `[{"Name": "default"}]`)
+					}
+				case "/v1/partition":
+					var p partition
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+					created = append(created, p)
+					fmt.Fprintln(w, `{"Name": "foo"}`)
+				default:
+					fmt.Fprintln(w, "{}")
+				}
+			}))
+			defer consulServer.Close()
+			serverURL, err := url.Parse(consulServer.URL)
+			require.NoError(t, err)
+
+			_, err = k8s.CoreV1().Secrets(ns).Create(
+				context.Background(),
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: resourcePrefix + "-bootstrap-acl-token",
+					},
+					Data: map[string][]byte{
+						"token": []byte("bootstrap-token"),
+					},
+				},
+				metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: k8s,
+			}
+			responseCode := cmd.Run([]string{
+				"-timeout=500ms",
+				"-resource-prefix=" + resourcePrefix,
+				"-k8s-namespace=" + ns,
+				"-server-address=" + serverURL.Hostname(),
+				"-server-port=" + serverURL.Port(),
+				"-enable-partitions",
+				"-partition-name=" + c.partitionName,
+				"-create-client-token",
+				"-allow-dns",
+			})
+			require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+			if c.expCreated {
+				require.Equal(t, []partition{{Name: "foo", Description: "Partition created by consul-k8s"}}, created)
+			} else {
+				require.Empty(t, created)
+			}
+
+			var tokens, anonymousTokenUpdates int
+			for _, call := range consulAPICalls {
+				switch call.Path {
+				case "/v1/acl/token":
+					tokens++
+					require.Equal(t, c.expPartition, call.Partition, "token created in the wrong partition")
+				case "/v1/acl/token/00000000-0000-0000-0000-000000000002":
+					anonymousTokenUpdates++
+				case "/v1/partition", "/v1/partitions":
+					// The partition is created from the default partition.
+					require.Empty(t, call.Partition)
+				}
+			}
+			require.NotZero(t, tokens)
+			if c.expPartition == "" {
+				require.Equal(t, 1, anonymousTokenUpdates)
+			} else {
+				require.Zero(t, anonymousTokenUpdates)
+			}
+		})
+	}
+}
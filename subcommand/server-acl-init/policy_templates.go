@@ -0,0 +1,66 @@
+package serveraclinit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// policyTemplateExt is the extension of the files loaded as policy templates.
+const policyTemplateExt = ".hcl"
+
+// loadPolicyTemplates returns the policy templates in dir keyed by the name
+// of the token they extend, which is their file name without the extension.
+// Other files are ignored so that a mounted ConfigMap can be used as is, and
+// each template is parsed so that mistakes are reported before any policy
+// is written.
+func loadPolicyTemplates(dir string) (map[string]string, error) {
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+policyTemplateExt))
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]string, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), policyTemplateExt)
+		if name == "" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := template.New(name).Parse(string(contents)); err != nil {
+			return nil, fmt.Errorf("invalid template %s: %s", filepath.Base(path), err)
+		}
+		templates[name] = string(contents)
+	}
+	return templates, nil
+}
+
+// withPolicyTemplate returns rules with the rendered policy template of the
+// token name appended, if there is one.
+func (c *Command) withPolicyTemplate(name, rules string) (string, error) {
+	tmpl, ok := c.policyTemplates[name]
+	if !ok {
+		return rules, nil
+	}
+	rendered, err := c.renderRules(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	if c.appliedPolicyTemplates == nil {
+		c.appliedPolicyTemplates = make(map[string]bool)
+	}
+	c.appliedPolicyTemplates[name] = true
+	return strings.TrimRight(rules, "\n") + "\n" + rendered, nil
+}
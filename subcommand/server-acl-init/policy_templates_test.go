@@ -0,0 +1,78 @@
+package serveraclinit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPolicyTemplates(t *testing.T) {
+	cases := map[string]struct {
+		files  map[string]string
+		exp    map[string]string
+		expErr string
+	}{
+		"only hcl files": {
+			files: map[string]string{
+				"client.hcl":       `node_prefix "" { policy = "read" }`,
+				"mesh-gateway.hcl": `service "web" { policy = "read" }`,
+				"README.md":        "not a template",
+			},
+			exp: map[string]string{
+				"client":       `node_prefix "" { policy = "read" }`,
+				"mesh-gateway": `service "web" { policy = "read" }`,
+			},
+		},
+		"empty directory": {
+			exp: map[string]string{},
+		},
+		"invalid template": {
+			files:  map[string]string{"client.hcl": `{{ if .EnableNamespaces }}`},
+			expErr: "invalid template client.hcl: template: client:1: unexpected EOF",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			for file, contents := range c.files {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(contents), 0600))
+			}
+
+			templates, err := loadPolicyTemplates(dir)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, templates)
+		})
+	}
+}
+
+func TestWithPolicyTemplate(t *testing.T) {
+	cmd := Command{
+		flagSyncConsulNodeName: "k8s-sync",
+		policyTemplates: map[string]string{
+			"catalog-sync": `key_prefix "{{ .SyncConsulNodeName }}/" { policy = "write" }`,
+			"client":       `{{ .Unknown }}`,
+		},
+	}
+
+	rules, err := cmd.withPolicyTemplate("catalog-sync", "node \"k8s-sync\" { policy = \"write\" }\n")
+	require.NoError(t, err)
+	require.Equal(t, "node \"k8s-sync\" { policy = \"write\" }\nkey_prefix \"k8s-sync/\" { policy = \"write\" }", rules)
+
+	// Tokens without a template keep their rules.
+	rules, err = cmd.withPolicyTemplate("mesh-gateway", `service_prefix "" { policy = "read" }`)
+	require.NoError(t, err)
+	require.Equal(t, `service_prefix "" { policy = "read" }`, rules)
+
+	_, err = cmd.withPolicyTemplate("client", "")
+	require.Error(t, err)
+	require.Equal(t, map[string]bool{"catalog-sync": true}, cmd.appliedPolicyTemplates)
+}
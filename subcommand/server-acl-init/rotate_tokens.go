@@ -0,0 +1,142 @@
+package serveraclinit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul/api"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// componentToken is a token created by createACL for a component such as
+// catalog sync or a mesh gateway. These tokens can be rotated.
+type componentToken struct {
+	name       string
+	secretName string
+	policyName string
+	local      bool
+}
+
+// rotateTokens rotates the tokens of the components configured by this run.
+func (c *Command) rotateTokens(consulClient *api.Client) error {
+	for _, t := range c.componentTokens {
+		if err := c.rotateToken(t, consulClient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousTokenAnnotation is the annotation of a component token's Secret
+// that records the accessor ID of the token the Secret held before it was
+// last rotated with -rotate-interval. That token is kept until the next
+// rotation so that the components have until then to be restarted.
+const previousTokenAnnotation = "consul.hashicorp.com/previous-token-accessor-id"
+
+// rotateToken creates a new token for the policy of t and writes it to the
+// Secret of t. Components read their token on startup so they must be
+// restarted to use the new one. With -rotate-interval, the token the Secret
+// had before is revoked on the next rotation rather than right away, since
+// nothing restarts the components in between. The token recorded by the
+// previous rotation is revoked either way.
+func (c *Command) rotateToken(t componentToken, consulClient *api.Client) error {
+	secretsClient := c.clientset.CoreV1().Secrets(c.flagK8sNamespace)
+	var secret *apiv1.Secret
+	err := c.untilSucceeds(fmt.Sprintf("reading Secret %s", t.secretName),
+		func() error {
+			var err error
+			secret, err = secretsClient.Get(context.TODO(), t.secretName, metav1.GetOptions{})
+			return err
+		})
+	if err != nil {
+		return err
+	}
+	previousAccessorID := secret.Annotations[previousTokenAnnotation]
+
+	// Tokens are deleted by accessor ID, which the Secret doesn't have.
+	var oldAccessorID string
+	if oldToken := string(secret.Data[common.ACLTokenSecretKey]); oldToken != "" {
+		err = c.untilSucceeds(fmt.Sprintf("reading previous token for policy %s", t.policyName),
+			func() error {
+				old, _, err := consulClient.ACL().TokenReadSelf(&api.QueryOptions{Token: oldToken})
+				if isACLNotFoundErr(err) {
+					c.log.Info("Previous token was already revoked", "policy", t.policyName)
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				oldAccessorID = old.AccessorID
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	token, err := c.createToken(t.policyName, t.local, consulClient)
+	if err != nil {
+		return err
+	}
+
+	keepOld := c.flagRotateInterval > 0
+	err = c.untilSucceeds(fmt.Sprintf("updating Secret for token %s", t.policyName),
+		func() error {
+			if secret.Data == nil {
+				secret.Data = make(map[string][]byte)
+			}
+			secret.Data[common.ACLTokenSecretKey] = []byte(token)
+			c.secret.MergeOntoObjectMeta(&secret.ObjectMeta, secretCreatedBy)
+			if keepOld && oldAccessorID != "" {
+				if secret.Annotations == nil {
+					secret.Annotations = make(map[string]string)
+				}
+				secret.Annotations[previousTokenAnnotation] = oldAccessorID
+			} else {
+				delete(secret.Annotations, previousTokenAnnotation)
+			}
+			updated, err := secretsClient.Update(context.TODO(), secret, metav1.UpdateOptions{})
+			if err != nil {
+				// The Secret is read again in case the update conflicted.
+				if current, getErr := secretsClient.Get(context.TODO(), t.secretName, metav1.GetOptions{}); getErr == nil {
+					secret = current
+				}
+				return err
+			}
+			secret = updated
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	revoke := []string{previousAccessorID}
+	if !keepOld {
+		revoke = append(revoke, oldAccessorID)
+	}
+	for _, accessorID := range revoke {
+		if accessorID == "" {
+			continue
+		}
+		err = c.untilSucceeds(fmt.Sprintf("revoking previous token for policy %s", t.policyName),
+			func() error {
+				_, err := consulClient.ACL().TokenDelete(accessorID, nil)
+				return err
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isACLNotFoundErr returns true if err is due to using a token that doesn't
+// exist.
+func isACLNotFoundErr(err error) bool {
+	return err != nil &&
+		strings.Contains(err.Error(), "Unexpected response code: 403") &&
+		strings.Contains(err.Error(), "ACL not found")
+}
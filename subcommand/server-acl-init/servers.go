@@ -2,11 +2,9 @@ package serveraclinit
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"strings"
 
-	"github.com/hashicorp/consul-k8s/consul"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul/api"
 	apiv1 "k8s.io/api/core/v1"
@@ -17,7 +15,7 @@ import (
 func (c *Command) bootstrapServers(serverAddresses []string, bootTokenSecretName, scheme string) (string, error) {
 	// Pick the first server address to connect to for bootstrapping and set up connection.
 	firstServerAddr := fmt.Sprintf("%s:%d", serverAddresses[0], c.flagServerPort)
-	consulClient, err := consul.NewClient(&api.Config{
+	consulClient, err := c.newConsulClient(&api.Config{
 		Address: firstServerAddr,
 		Scheme:  scheme,
 		TLSConfig: api.TLSConfig{
@@ -42,9 +40,13 @@ func (c *Command) bootstrapServers(serverAddresses []string, bootTokenSecretName
 
 			// Check if already bootstrapped.
 			if strings.Contains(err.Error(), "Unexpected response code: 403") {
-				unrecoverableErr = errors.New("ACLs already bootstrapped but the ACL token was not written to a Kubernetes secret." +
-					" We can't proceed because the bootstrap token is lost." +
-					" You must reset ACLs.")
+				store := "a Kubernetes secret"
+				if c.storedInVault(bootTokenSecretName) {
+					store = "Vault"
+				}
+				unrecoverableErr = fmt.Errorf("ACLs already bootstrapped but the ACL token was not written to %s."+
+					" We can't proceed because the bootstrap token is lost."+
+					" You must reset ACLs.", store)
 				return nil
 			}
 
@@ -62,27 +64,32 @@ func (c *Command) bootstrapServers(serverAddresses []string, bootTokenSecretName
 		return "", err
 	}
 
-	// Write bootstrap token to a Kubernetes secret.
-	err = c.untilSucceeds(fmt.Sprintf("writing bootstrap Secret %q", bootTokenSecretName),
-		func() error {
-			secret := &apiv1.Secret{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: bootTokenSecretName,
-				},
-				Data: map[string][]byte{
-					common.ACLTokenSecretKey: bootstrapToken,
-				},
-			}
-			_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Create(context.TODO(), secret, metav1.CreateOptions{})
-			return err
-		})
+	// Write bootstrap token to a Kubernetes secret, or to Vault.
+	if c.storedInVault(bootTokenSecretName) {
+		err = c.tokenToVault(bootTokenSecretName, string(bootstrapToken))
+	} else {
+		err = c.untilSucceeds(fmt.Sprintf("writing bootstrap Secret %q", bootTokenSecretName),
+			func() error {
+				secret := &apiv1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: bootTokenSecretName,
+					},
+					Data: map[string][]byte{
+						common.ACLTokenSecretKey: bootstrapToken,
+					},
+				}
+				c.secret.MergeOntoObjectMeta(&secret.ObjectMeta, secretCreatedBy)
+				_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+				return err
+			})
+	}
 	if err != nil {
 		return "", err
 	}
 
 	// Override our original client with a new one that has the bootstrap token
 	// set.
-	consulClient, err = consul.NewClient(&api.Config{
+	consulClient, err = c.newConsulClient(&api.Config{
 		Address: firstServerAddr,
 		Scheme:  scheme,
 		Token:   string(bootstrapToken),
@@ -116,7 +123,7 @@ func (c *Command) setServerTokens(consulClient *api.Client, serverAddresses []st
 
 		// We create a new client for each server because we need to call each
 		// server specifically.
-		serverClient, err := consul.NewClient(&api.Config{
+		serverClient, err := c.newConsulClient(&api.Config{
 			Address: fmt.Sprintf("%s:%d", host, c.flagServerPort),
 			Scheme:  scheme,
 			Token:   bootstrapToken,
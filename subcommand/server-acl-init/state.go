@@ -0,0 +1,179 @@
+package serveraclinit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/version"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The annotations of the bootstrap token Secret that record the state of the
+// last successful run, so that a re-run with the same configuration, e.g. on
+// every Helm upgrade, skips the steps that are already done.
+const (
+	// stateAnnotationConfigHash is the hash of the configuration of the run,
+	// as returned by configHash.
+	stateAnnotationConfigHash = "consul.hashicorp.com/server-acl-init-config-hash"
+	// stateAnnotationCompletedSteps is the comma-separated list of the steps
+	// that the run completed, named as in the status.
+	stateAnnotationCompletedSteps = "consul.hashicorp.com/server-acl-init-completed-steps"
+)
+
+// stateIgnoredFlags are the flags that don't change what the command
+// configures, only how it connects, logs or reports its progress, so that
+// changing them doesn't make a re-run redo every step.
+var stateIgnoredFlags = map[string]bool{
+	"server-address":         true,
+	"server-port":            true,
+	"consul-ca-cert":         true,
+	"consul-tls-server-name": true,
+	"use-https":              true,
+	"consul-api-timeout":     true,
+	"kubeconfig":             true,
+	"context":                true,
+	"timeout":                true,
+	"log-level":              true,
+	"status-config-map":      true,
+	"rotate":                 true,
+	"rotate-interval":        true,
+	"force":                  true,
+}
+
+// runState is the state of the last successful run, read from the bootstrap
+// token Secret.
+type runState struct {
+	// secretName is the name of the bootstrap token Secret the state is
+	// stored on.
+	secretName string
+	// hash is the hash of the configuration of this run.
+	hash string
+	// unchanged is whether the last successful run had the same
+	// configuration, in which case the steps it completed are skipped.
+	unchanged bool
+	// previousSteps are the steps the last successful run completed.
+	previousSteps map[string]bool
+	// completedSteps are the steps this run completed or skipped, in order.
+	completedSteps []string
+}
+
+// loadState reads the state of the last successful run from the bootstrap
+// token Secret secretName. The state isn't used with -force or -rotate, since
+// they're meant to run every step, but it's still recorded for the next run.
+func (c *Command) loadState(secretName string) error {
+	hash, err := c.configHash()
+	if err != nil {
+		return fmt.Errorf("error hashing the configuration: %s", err)
+	}
+	c.state = &runState{secretName: secretName, hash: hash}
+
+	var secret *apiv1.Secret
+	err = c.untilSucceeds(fmt.Sprintf("reading the state of the last run from Secret %q", secretName),
+		func() error {
+			var err error
+			secret, err = c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+			return err
+		})
+	if err != nil {
+		return err
+	}
+	if c.flagForce || c.flagRotate {
+		return nil
+	}
+	if secret.Annotations[stateAnnotationConfigHash] != hash {
+		c.log.Info("Configuration changed since the last successful run, running every step")
+		return nil
+	}
+	c.state.unchanged = true
+	c.state.previousSteps = make(map[string]bool)
+	for _, step := range strings.Split(secret.Annotations[stateAnnotationCompletedSteps], ",") {
+		if step != "" {
+			c.state.previousSteps[step] = true
+		}
+	}
+	c.log.Info("Configuration unchanged since the last successful run, skipping the steps it completed")
+	return nil
+}
+
+// saveState records the state of this run on the bootstrap token Secret once
+// it succeeded.
+func (c *Command) saveState() error {
+	if c.state == nil {
+		return nil
+	}
+	secretsClient := c.clientset.CoreV1().Secrets(c.flagK8sNamespace)
+	return c.untilSucceeds(fmt.Sprintf("recording the state of the run on Secret %q", c.state.secretName),
+		func() error {
+			secret, err := secretsClient.Get(context.TODO(), c.state.secretName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if secret.Annotations == nil {
+				secret.Annotations = make(map[string]string)
+			}
+			secret.Annotations[stateAnnotationConfigHash] = c.state.hash
+			secret.Annotations[stateAnnotationCompletedSteps] = strings.Join(c.state.completedSteps, ",")
+			_, err = secretsClient.Update(context.TODO(), secret, metav1.UpdateOptions{})
+			return err
+		})
+}
+
+// canSkipStep returns true if step was completed by the last successful run
+// and the configuration hasn't changed since.
+func (c *Command) canSkipStep(step string) bool {
+	return c.state != nil && c.state.unchanged && c.state.previousSteps[step]
+}
+
+// skipStep returns true if the step can be skipped, in which case it's
+// reported as completed.
+func (c *Command) skipStep(step string) bool {
+	if !c.canSkipStep(step) {
+		return false
+	}
+	c.log.Info("Skipping step completed by the last run", "step", step)
+	c.status.start(step)
+	c.completeStep(step)
+	return true
+}
+
+// completeStep records that step completed.
+func (c *Command) completeStep(step string) {
+	c.status.complete(step)
+	if c.state != nil {
+		c.state.completedSteps = append(c.state.completedSteps, step)
+	}
+}
+
+// configHash returns a hash of everything that changes what the command
+// configures: the version of consul-k8s, the flags other than
+// stateIgnoredFlags, and the contents of the files they point to.
+func (c *Command) configHash() (string, error) {
+	var lines []string
+	lines = append(lines, "version="+version.GetHumanVersion())
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if !stateIgnoredFlags[f.Name] {
+			lines = append(lines, fmt.Sprintf("flag %s=%s", f.Name, f.Value.String()))
+		}
+	})
+	for name, tmpl := range c.policyTemplates {
+		lines = append(lines, fmt.Sprintf("policy-template %s=%q", name, tmpl))
+	}
+	if c.flagAuthMethodsFile != "" {
+		contents, err := ioutil.ReadFile(c.flagAuthMethodsFile)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("auth-methods-file=%q", contents))
+	}
+	sort.Strings(lines)
+
+	hash := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,207 @@
+package serveraclinit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The keys of the -status-config-map ConfigMap.
+const (
+	// statusKeyPhase is statusPhaseRunning, statusPhaseCompleted or
+	// statusPhaseFailed.
+	statusKeyPhase = "phase"
+	// statusKeyCompletedSteps is the comma-separated list of the steps that
+	// completed, in order, e.g. "bootstrap,client-token".
+	statusKeyCompletedSteps = "completedSteps"
+	// statusKeyFailedStep and statusKeyError are only set if the command
+	// failed. The step is empty if it failed in between steps.
+	statusKeyFailedStep = "failedStep"
+	statusKeyError      = "error"
+	statusKeyUpdated    = "lastUpdated"
+)
+
+const (
+	statusPhaseRunning   = "Running"
+	statusPhaseCompleted = "Completed"
+	statusPhaseFailed    = "Failed"
+)
+
+// The steps reported in the status, besides "<name>-token" for the token of
+// each component.
+const (
+	statusStepBootstrap            = "bootstrap"
+	statusStepServerPolicy         = "server-policy"
+	statusStepPartition            = "partition"
+	statusStepCrossNamespacePolicy = "cross-namespace-policy"
+	statusStepAnonymousPolicy      = "anonymous-policy"
+	statusStepInjectAuthMethod     = "connect-inject-auth-method"
+	statusStepAuthMethods          = "auth-methods"
+	statusStepRotateTokens         = "rotate-tokens"
+)
+
+// statusReporter writes the progress and result of the command to the
+// -status-config-map ConfigMap and emits Events for it, so that partial
+// failures can be seen without reading the logs. Failing to report the
+// status doesn't fail the command. The methods are no-ops on a nil
+// *statusReporter so that the status doesn't need to be enabled.
+//
+// statusReporter is an hclog.SinkAdapter so that the last error logged by
+// the command is reported when it fails.
+type statusReporter struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	log       hclog.Logger
+
+	lock      sync.Mutex
+	uid       types.UID
+	phase     string
+	step      string
+	completed []string
+	lastError string
+}
+
+// start begins step. If the command fails before the step is completed, the
+// step is reported as the one that failed.
+func (s *statusReporter) start(step string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.phase == "" {
+		s.phase = statusPhaseRunning
+		s.writeLocked()
+	}
+	s.step = step
+}
+
+// complete records that step completed.
+func (s *statusReporter) complete(step string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.step = ""
+	s.completed = append(s.completed, step)
+	s.writeLocked()
+	s.eventLocked(apiv1.EventTypeNormal, "StepCompleted", fmt.Sprintf("Completed step %s", step))
+}
+
+// succeeded records that the command completed, which for -rotate-interval
+// is after the tokens were first created.
+func (s *statusReporter) succeeded() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.phase = statusPhaseCompleted
+	s.writeLocked()
+	s.eventLocked(apiv1.EventTypeNormal, "Completed", "server-acl-init completed successfully")
+}
+
+// failed records that the command failed in the current step.
+func (s *statusReporter) failed() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.phase = statusPhaseFailed
+	s.writeLocked()
+	msg := "server-acl-init failed"
+	if s.step != "" {
+		msg += fmt.Sprintf(" in step %s", s.step)
+	}
+	if s.lastError != "" {
+		msg += ": " + s.lastError
+	}
+	s.eventLocked(apiv1.EventTypeWarning, "Failed", msg)
+}
+
+// Accept implements hclog.SinkAdapter.
+func (s *statusReporter) Accept(_ string, level hclog.Level, msg string, args ...interface{}) {
+	if level < hclog.Error {
+		return
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		msg += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastError = msg
+}
+
+// writeLocked creates or updates the ConfigMap with the current status.
+//
+// Precondition: lock must be held
+func (s *statusReporter) writeLocked() {
+	data := map[string]string{
+		statusKeyPhase:          s.phase,
+		statusKeyCompletedSteps: strings.Join(s.completed, ","),
+		statusKeyUpdated:        time.Now().UTC().Format(time.RFC3339),
+	}
+	if s.phase == statusPhaseFailed {
+		data[statusKeyFailedStep] = s.step
+		data[statusKeyError] = s.lastError
+	}
+
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+	configMap, err := configMaps.Get(context.TODO(), s.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		configMap, err = configMaps.Create(context.TODO(), &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.name},
+			Data:       data,
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		configMap.Data = data
+		configMap, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		s.log.Warn("Unable to write status ConfigMap", "name", s.name, "err", err)
+		return
+	}
+	s.uid = configMap.UID
+}
+
+// eventLocked emits an Event for the ConfigMap.
+//
+// Precondition: lock must be held
+func (s *statusReporter) eventLocked(eventType, reason, msg string) {
+	now := metav1.Now()
+	event := &apiv1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", s.name, now.UnixNano()),
+			Namespace: s.namespace,
+		},
+		InvolvedObject: apiv1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Namespace:  s.namespace,
+			Name:       s.name,
+			UID:        s.uid,
+		},
+		Reason:         reason,
+		Message:        msg,
+		Type:           eventType,
+		Source:         apiv1.EventSource{Component: "server-acl-init"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := s.clientset.CoreV1().Events(s.namespace).Create(context.TODO(), event, metav1.CreateOptions{}); err != nil {
+		s.log.Warn("Unable to create status Event", "reason", reason, "err", err)
+	}
+}
@@ -0,0 +1,71 @@
+package serveraclinit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Test that a failure in a step is reported with the step and the last error
+// logged, and that the status of a previous run is replaced.
+func TestStatusReporter_failedStep(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(&apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "status", Namespace: ns},
+		Data:       map[string]string{statusKeyPhase: statusPhaseCompleted, statusKeyCompletedSteps: "bootstrap,client-token"},
+	})
+	s := &statusReporter{clientset: k8s, namespace: ns, name: "status", log: hclog.NewNullLogger()}
+
+	s.start(statusStepBootstrap)
+	s.complete(statusStepBootstrap)
+	s.start("client-token")
+	s.Accept("", hclog.Warn, "ignored")
+	s.Accept("", hclog.Error, "Error creating token", "err", "permission denied")
+	s.failed()
+
+	configMap, err := k8s.CoreV1().ConfigMaps(ns).Get(context.Background(), "status", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, statusPhaseFailed, configMap.Data[statusKeyPhase])
+	require.Equal(t, "bootstrap", configMap.Data[statusKeyCompletedSteps])
+	require.Equal(t, "client-token", configMap.Data[statusKeyFailedStep])
+	require.Equal(t, "Error creating token err=permission denied", configMap.Data[statusKeyError])
+
+	events, err := k8s.CoreV1().Events(ns).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 2)
+	failed := events.Items[1]
+	require.Equal(t, apiv1.EventTypeWarning, failed.Type)
+	require.Equal(t, "server-acl-init failed in step client-token: Error creating token err=permission denied", failed.Message)
+	require.Equal(t, configMap.UID, failed.InvolvedObject.UID)
+}
+
+// Test that the command isn't affected by the status not being written.
+func TestStatusReporter_writeErrors(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+	k8s.PrependReactor("*", "*", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	s := &statusReporter{clientset: k8s, namespace: ns, name: "status", log: hclog.NewNullLogger()}
+	s.start(statusStepBootstrap)
+	s.complete(statusStepBootstrap)
+	s.succeeded()
+	require.Equal(t, []string{statusStepBootstrap}, s.completed)
+}
+
+func TestStatusReporter_nil(t *testing.T) {
+	t.Parallel()
+	var s *statusReporter
+	s.start(statusStepBootstrap)
+	s.complete(statusStepBootstrap)
+	s.succeeded()
+	s.failed()
+}
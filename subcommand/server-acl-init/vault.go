@@ -0,0 +1,64 @@
+package serveraclinit
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/hashicorp/consul-k8s/helper/vault"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+)
+
+// vaultClient returns a client for the Vault server at -vault-addr.
+func (c *Command) vaultClient() (*vault.Client, error) {
+	return vault.NewClient(vault.Config{
+		Addr:            c.flagVaultAddr,
+		CAFile:          c.flagVaultCAFile,
+		TLSServerName:   c.flagVaultTLSServerName,
+		Namespace:       c.flagVaultNamespace,
+		AuthMethod:      c.flagVaultAuthMethod,
+		TokenFile:       c.flagVaultTokenFile,
+		AuthPath:        c.flagVaultAuthPath,
+		Role:            c.flagVaultRole,
+		BearerTokenFile: c.flagVaultBearerTokenFile,
+	})
+}
+
+// storedInVault returns true if the token whose Secret would be secretName
+// is stored in Vault instead, which is only the case of the bootstrap and
+// ACL replication tokens.
+func (c *Command) storedInVault(secretName string) bool {
+	return c.vault != nil &&
+		(secretName == c.withPrefix("bootstrap-acl-token") ||
+			secretName == c.withPrefix(common.ACLReplicationTokenName+"-acl-token"))
+}
+
+// vaultSecretPath returns the path of the Vault secret that stores the token
+// whose Secret would be secretName.
+func (c *Command) vaultSecretPath(secretName string) string {
+	return path.Join(c.flagVaultSecretsPath, secretName)
+}
+
+// tokenFromVault returns the token stored in Vault instead of the Secret
+// secretName, or an empty string if there's none.
+func (c *Command) tokenFromVault(secretName string) (string, error) {
+	secretPath := c.vaultSecretPath(secretName)
+	var token string
+	err := c.untilSucceeds(fmt.Sprintf("reading Vault secret %q", secretPath),
+		func() error {
+			var err error
+			token, _, err = c.vault.ReadKV(c.cmdTimeout, c.flagVaultKVMount, secretPath, common.ACLTokenSecretKey)
+			return err
+		})
+	return token, err
+}
+
+// tokenToVault stores token in Vault instead of the Secret secretName.
+func (c *Command) tokenToVault(secretName, token string) error {
+	secretPath := c.vaultSecretPath(secretName)
+	return c.untilSucceeds(fmt.Sprintf("writing Vault secret %q", secretPath),
+		func() error {
+			return c.vault.WriteKV(c.cmdTimeout, c.flagVaultKVMount, secretPath, map[string]string{
+				common.ACLTokenSecretKey: token,
+			})
+		})
+}
@@ -0,0 +1,142 @@
+package serveraclinit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test that with -vault-addr the bootstrap and ACL replication tokens are
+// stored in Vault instead of Secrets and that later runs read them back.
+func TestRun_Vault(t *testing.T) {
+	t.Parallel()
+
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+
+	vault := newFakeVaultKV(t, "vault-token")
+	defer vault.Close()
+	tokenFile, err := ioutil.TempFile("", "vault-token")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("vault-token\n")
+	require.NoError(t, err)
+
+	args := []string{
+		"-timeout=1m",
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-resource-prefix=" + resourcePrefix,
+		"-create-acl-replication-token",
+		"-create-client-token",
+		"-vault-addr", vault.URL,
+		"-vault-kv-mount", "kv",
+		"-vault-secrets-path", "consul/dc1",
+		"-vault-token-file", tokenFile.Name(),
+	}
+	for i := 0; i < 2; i++ {
+		ui := cli.NewMockUi()
+		cmd := Command{
+			UI:        ui,
+			clientset: k8s,
+		}
+		responseCode := cmd.Run(args)
+		require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+	}
+
+	// The tokens are in Vault and not in Secrets.
+	bootToken := vault.get("kv/data/consul/dc1/" + resourcePrefix + "-bootstrap-acl-token")
+	require.NotEmpty(t, bootToken)
+	replicationToken := vault.get("kv/data/consul/dc1/" + resourcePrefix + "-acl-replication-acl-token")
+	require.NotEmpty(t, replicationToken)
+	for _, name := range []string{"bootstrap-acl-token", "acl-replication-acl-token"} {
+		_, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), resourcePrefix+"-"+name, metav1.GetOptions{})
+		require.Error(t, err, name)
+	}
+	// The other tokens still are in Secrets.
+	_, err = k8s.CoreV1().Secrets(ns).Get(context.Background(), resourcePrefix+"-client-acl-token", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// The replication token was only created once since it was found in
+	// Vault by the second run.
+	consul, err := api.NewClient(&api.Config{
+		Address: testSvr.HTTPAddr,
+		Token:   bootToken,
+	})
+	require.NoError(t, err)
+	tokens, _, err := consul.ACL().TokenList(nil)
+	require.NoError(t, err)
+	var replicationTokens int
+	for _, token := range tokens {
+		for _, policy := range token.Policies {
+			if policy.Name == "acl-replication-token" {
+				replicationTokens++
+			}
+		}
+	}
+	require.Equal(t, 1, replicationTokens)
+	self, _, err := consul.ACL().TokenReadSelf(nil)
+	require.NoError(t, err)
+	require.Equal(t, "global-management", self.Policies[0].Name)
+}
+
+// fakeVaultKV is a Vault server with a KV version 2 secrets engine.
+type fakeVaultKV struct {
+	*httptest.Server
+	lock    sync.Mutex
+	secrets map[string]map[string]string
+}
+
+// newFakeVaultKV returns a fakeVaultKV that only accepts token.
+func newFakeVaultKV(t *testing.T, token string) *fakeVaultKV {
+	v := &fakeVaultKV{secrets: make(map[string]map[string]string)}
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := v.secrets[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"data": data},
+			})
+		case http.MethodPost:
+			var body struct {
+				Data map[string]string `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			v.secrets[path] = body.Data
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	return v
+}
+
+// get returns the token of the secret at path, an API path without /v1/.
+func (v *fakeVaultKV) get(path string) string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.secrets[path]["token"]
+}
@@ -0,0 +1,326 @@
+package serverrestart
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command is the command for restarting the Consul server pods one at a
+// time without losing quorum.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	k8s   *flags.K8SFlags
+	http  *flags.HTTPFlags
+
+	flagK8sNamespace      string
+	flagStatefulSetName   string
+	flagTimeout           time.Duration
+	flagStabilizationTime time.Duration
+	flagLogLevel          string
+
+	k8sClient    kubernetes.Interface
+	consulClient *api.Client
+
+	// pollInterval is how often we check whether a restarted server is
+	// healthy. It defaults to 2s and is exposed for setting in tests.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace the Consul servers are running in. This value is required.")
+	c.flags.StringVar(&c.flagStatefulSetName, "statefulset-name", "",
+		"Name of the Consul server StatefulSet, e.g. consul-server. This value is required.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
+		"How long to wait for each restarted server to become healthy before aborting, e.g. 1ms, 2s, 3m.")
+	c.flags.DurationVar(&c.flagStabilizationTime, "stabilization-time", 10*time.Second,
+		"How long a restarted server must be healthy according to autopilot before the next "+
+			"server is restarted. This should be at least autopilot's server stabilization time.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Default poll interval to 2s. This is exposed for setting in tests.
+	if c.pollInterval == 0 {
+		c.pollInterval = 2 * time.Second
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagK8sNamespace == "" {
+		c.UI.Error("-k8s-namespace must be set")
+		return 1
+	}
+	if c.flagStatefulSetName == "" {
+		c.UI.Error("-statefulset-name must be set")
+		return 1
+	}
+
+	logger, err := common.Logger(c.flagLogLevel)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.k8sClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.k8sClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+	sts, err := c.k8sClient.AppsV1().StatefulSets(c.flagK8sNamespace).Get(ctx, c.flagStatefulSetName, metav1.GetOptions{})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading StatefulSet %s/%s: %s", c.flagK8sNamespace, c.flagStatefulSetName, err))
+		return 1
+	}
+	replicas := 1
+	if sts.Spec.Replicas != nil {
+		replicas = int(*sts.Spec.Replicas)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error parsing selector of StatefulSet %q: %s", sts.Name, err))
+		return 1
+	}
+	podList, err := c.k8sClient.CoreV1().Pods(c.flagK8sNamespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error listing pods of StatefulSet %q: %s", sts.Name, err))
+		return 1
+	}
+	if len(podList.Items) != replicas {
+		c.UI.Error(fmt.Sprintf("StatefulSet %q has %d pods but %d replicas, wait for it to finish scaling", sts.Name, len(podList.Items), replicas))
+		return 1
+	}
+
+	// Restarting a server while the cluster can't tolerate a failure would
+	// lose quorum.
+	health, err := c.consulClient.Operator().AutopilotServerHealth(nil)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading autopilot health, the servers must be healthy before restarting them: %s", err))
+		return 1
+	}
+	if !health.Healthy {
+		c.UI.Error("Consul servers aren't healthy, not restarting them")
+		return 1
+	}
+	if health.FailureTolerance < 1 {
+		c.UI.Error(fmt.Sprintf("Consul servers have a failure tolerance of %d, restarting a server would lose quorum", health.FailureTolerance))
+		return 1
+	}
+
+	leader, err := c.consulClient.Status().Leader()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading Consul leader: %s", err))
+		return 1
+	}
+	pods := restartOrder(podList.Items, leader)
+
+	for _, pod := range pods {
+		c.UI.Info(fmt.Sprintf("Restarting pod %q...", pod.Name))
+		if err := c.restart(ctx, logger, pod, replicas, health.FailureTolerance); err != nil {
+			c.UI.Error(fmt.Sprintf("Error restarting pod %q: %s. Remaining servers were not restarted.", pod.Name, err))
+			return 1
+		}
+		c.UI.Info(fmt.Sprintf("Pod %q restarted and its server is healthy", pod.Name))
+	}
+	c.UI.Info(fmt.Sprintf("Restarted %d Consul servers", len(pods)))
+	return 0
+}
+
+// restart deletes pod and waits for it to be recreated and for its server
+// to rejoin the cluster, and for the cluster to have the failure tolerance
+// it had before the restart.
+func (c *Command) restart(ctx context.Context, logger hclog.Logger, pod corev1.Pod, replicas, failureTolerance int) error {
+	ctx, cancel := context.WithTimeout(ctx, c.flagTimeout)
+	defer cancel()
+
+	err := c.k8sClient.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &pod.UID},
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("waiting for pod to be recreated", "pod", pod.Name)
+	err = c.waitFor(ctx, func() (bool, error) {
+		current, err := c.k8sClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return current.UID != pod.UID && isReady(current), nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for pod to be ready: %s", err)
+	}
+
+	logger.Info("waiting for server to be healthy", "pod", pod.Name)
+	err = c.waitFor(ctx, func() (bool, error) {
+		leader, err := c.consulClient.Status().Leader()
+		if err != nil {
+			return false, err
+		}
+		if leader == "" {
+			return false, errors.New("no leader")
+		}
+		health, err := c.consulClient.Operator().AutopilotServerHealth(nil)
+		if err != nil {
+			return false, err
+		}
+		if !health.Healthy || len(health.Servers) != replicas || health.FailureTolerance < failureTolerance {
+			return false, fmt.Errorf("autopilot reports healthy=%t with %d servers and a failure tolerance of %d",
+				health.Healthy, len(health.Servers), health.FailureTolerance)
+		}
+		for _, server := range health.Servers {
+			if server.Name != pod.Name {
+				continue
+			}
+			if !server.Healthy || !server.Voter {
+				return false, errors.New("server isn't a healthy voter yet")
+			}
+			stableFor := time.Since(server.StableSince)
+			logger.Debug("server is healthy", "pod", pod.Name, "stable-for", stableFor)
+			return stableFor >= c.flagStabilizationTime, nil
+		}
+		return false, errors.New("server hasn't rejoined the cluster")
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for server to be healthy: %s", err)
+	}
+	return nil
+}
+
+// waitFor calls fn every poll interval until it returns true or ctx is done.
+func (c *Command) waitFor(ctx context.Context, fn func() (bool, error)) error {
+	var lastErr error
+	for {
+		done, err := fn()
+		if err == nil && done {
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-time.After(c.pollInterval):
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("timed out: %s", lastErr)
+			}
+			return errors.New("timed out")
+		}
+	}
+}
+
+// restartOrder returns the pods in the order they should be restarted in:
+// from the highest ordinal to the lowest like a StatefulSet rolling update,
+// except for the leader which is restarted last so that leadership only
+// changes once.
+func restartOrder(pods []corev1.Pod, leaderAddr string) []corev1.Pod {
+	leaderIP, _, err := net.SplitHostPort(leaderAddr)
+	if err != nil {
+		leaderIP = leaderAddr
+	}
+	sorted := append([]corev1.Pod{}, pods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iLeader, jLeader := sorted[i].Status.PodIP == leaderIP, sorted[j].Status.PodIP == leaderIP
+		if iLeader != jLeader {
+			return jLeader
+		}
+		return ordinal(sorted[i].Name) > ordinal(sorted[j].Name)
+	})
+	return sorted
+}
+
+// ordinal returns the ordinal of a StatefulSet pod from its name.
+func ordinal(name string) int {
+	i, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+func isReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Restart the Consul servers one at a time."
+const help = `
+Usage: consul-k8s server restart [options]
+
+  Restarts the pods of the Consul server StatefulSet one at a time, for
+  example to pick up configuration changes. After each pod is deleted the
+  command waits for it to be recreated and for its server to rejoin the
+  cluster as a healthy voter according to autopilot, and for the cluster
+  to regain the failure tolerance it had before the restart. The leader is
+  restarted last.
+
+  The command refuses to start unless autopilot reports the servers as
+  healthy with a failure tolerance of at least one, and stops without
+  restarting the remaining servers if a server doesn't become healthy
+  within -timeout.
+`
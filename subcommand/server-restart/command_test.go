@@ -0,0 +1,223 @@
+package serverrestart
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const ns = "default"
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-k8s-namespace must be set",
+		},
+		{
+			flags:  []string{"-k8s-namespace", ns},
+			expErr: "-statefulset-name must be set",
+		},
+		{
+			flags:  []string{"-k8s-namespace", ns, "-statefulset-name", "consul-server", "-log-level", "invalid"},
+			expErr: "unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				k8sClient: fake.NewSimpleClientset(),
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that servers are restarted one at a time, from the highest ordinal to
+// the lowest with the leader last, waiting for each to be healthy again.
+func TestRun_RestartsOneAtATime(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(t, 1)
+	k8s, deleted := setupK8s(t, consul, true)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		k8sClient:    k8s,
+		consulClient: consul.client(t),
+		pollInterval: 10 * time.Millisecond,
+	}
+	exitCode := cmd.Run([]string{"-k8s-namespace", ns, "-statefulset-name", "consul-server", "-stabilization-time", "0s"})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.OutputWriter.String(), "Restarted 3 Consul servers")
+
+	// consul-server-1 is the leader.
+	require.Equal(t, []string{"consul-server-2", "consul-server-0", "consul-server-1"}, *deleted)
+	// Each server was unhealthy after its restart so the command must have
+	// waited for it before restarting the next one.
+	consul.lock.Lock()
+	defer consul.lock.Unlock()
+	require.Equal(t, []string{"consul-server-2", "consul-server-0", "consul-server-1"}, consul.waitedFor)
+}
+
+func TestRun_RefusesToLoseQuorum(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(t, 0)
+	k8s, deleted := setupK8s(t, consul, true)
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, k8sClient: k8s, consulClient: consul.client(t)}
+	exitCode := cmd.Run([]string{"-k8s-namespace", ns, "-statefulset-name", "consul-server"})
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.ErrorWriter.String(), "Consul servers have a failure tolerance of 0, restarting a server would lose quorum")
+	require.Empty(t, *deleted)
+}
+
+// Test that the remaining servers aren't restarted if a restarted pod
+// doesn't become ready.
+func TestRun_StopsOnTimeout(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(t, 1)
+	k8s, deleted := setupK8s(t, consul, false)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		k8sClient:    k8s,
+		consulClient: consul.client(t),
+		pollInterval: 10 * time.Millisecond,
+	}
+	exitCode := cmd.Run([]string{"-k8s-namespace", ns, "-statefulset-name", "consul-server", "-timeout", "100ms"})
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.ErrorWriter.String(), `Error restarting pod "consul-server-2": waiting for pod to be ready: timed out`)
+	require.Equal(t, []string{"consul-server-2"}, *deleted)
+}
+
+// fakeConsul serves the leader and autopilot health of three servers. A
+// server is reported as unhealthy for the first health check after its pod
+// is restarted.
+type fakeConsul struct {
+	lock             sync.Mutex
+	server           *httptest.Server
+	failureTolerance int
+	restarting       string
+	waitedFor        []string
+}
+
+func newFakeConsul(t *testing.T, failureTolerance int) *fakeConsul {
+	f := &fakeConsul{failureTolerance: failureTolerance}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		switch r.URL.Path {
+		case "/v1/status/leader":
+			fmt.Fprintln(w, `"10.0.0.1:8300"`)
+		case "/v1/operator/autopilot/health":
+			reply := api.OperatorHealthReply{Healthy: true, FailureTolerance: f.failureTolerance}
+			for i := 0; i < 3; i++ {
+				name := fmt.Sprintf("consul-server-%d", i)
+				healthy := name != f.restarting
+				reply.Servers = append(reply.Servers, api.ServerHealth{
+					Name:        name,
+					Healthy:     healthy,
+					Voter:       true,
+					StableSince: time.Now().Add(-time.Minute),
+				})
+			}
+			if f.restarting != "" {
+				f.waitedFor = append(f.waitedFor, f.restarting)
+				f.restarting = ""
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(reply))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeConsul) client(t *testing.T) *api.Client {
+	client, err := api.NewClient(&api.Config{Address: f.server.URL})
+	require.NoError(t, err)
+	return client
+}
+
+// setupK8s returns a client with the server StatefulSet and its pods. If
+// recreate is true, deleted pods are replaced by ready pods with a new UID
+// like the StatefulSet controller would.
+func setupK8s(t *testing.T, consul *fakeConsul, recreate bool) (*fake.Clientset, *[]string) {
+	replicas := int32(3)
+	labels := map[string]string{"app": "consul", "component": "server"}
+	objs := []runtime.Object{
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-server", Namespace: ns},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+			},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		objs = append(objs, serverPod(i, "original", labels))
+	}
+	// A pod that doesn't belong to the StatefulSet.
+	objs = append(objs, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "consul-client-abcde", Namespace: ns}})
+	k8s := fake.NewSimpleClientset(objs...)
+
+	var deleted []string
+	k8s.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.DeleteAction).GetName()
+		deleted = append(deleted, name)
+		if !recreate {
+			return false, nil, nil
+		}
+		consul.lock.Lock()
+		consul.restarting = name
+		consul.lock.Unlock()
+		var i int
+		_, err := fmt.Sscanf(name, "consul-server-%d", &i)
+		require.NoError(t, err)
+		return true, nil, k8s.Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), serverPod(i, "recreated", labels), ns)
+	})
+	return k8s, &deleted
+}
+
+func serverPod(i int, uid string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("consul-server-%d", i),
+			Namespace: ns,
+			UID:       types.UID(uid),
+			Labels:    labels,
+		},
+		Status: corev1.PodStatus{
+			PodIP:      fmt.Sprintf("10.0.0.%d", i),
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
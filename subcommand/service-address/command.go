@@ -31,11 +31,12 @@ type Command struct {
 	flagServiceName      string
 	flagOutputFile       string
 	flagResolveHostnames bool
+	flagTimeout          time.Duration
+	flagRetryInterval    time.Duration
 
-	retryDuration time.Duration
-	k8sClient     kubernetes.Interface
-	once          sync.Once
-	help          string
+	k8sClient kubernetes.Interface
+	once      sync.Once
+	help      string
 }
 
 func (c *Command) init() {
@@ -48,9 +49,15 @@ func (c *Command) init() {
 		"Path to file to write load balancer address")
 	c.flags.BoolVar(&c.flagResolveHostnames, "resolve-hostnames", false,
 		"If true we will resolve any hostnames and use their first IP address")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 0,
+		"How long to wait for the service to have an address, e.g. 10m. "+
+			"If 0, the command waits until it does.")
+	c.flags.DurationVar(&c.flagRetryInterval, "retry-interval", 1*time.Second,
+		"How long to wait between attempts to get the service's address.")
 
 	c.k8sFlags = &k8sflags.K8SFlags{}
 	flags.Merge(c.flags, c.k8sFlags.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
 }
 
@@ -64,7 +71,7 @@ func (c *Command) Run(args []string) int {
 	}
 
 	if c.k8sClient == nil {
-		config, err := subcommand.K8SConfig(c.k8sFlags.KubeConfig())
+		config, err := subcommand.K8SConfig(c.k8sFlags.KubeConfig(), c.k8sFlags.Context())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
 			return 1
@@ -75,16 +82,20 @@ func (c *Command) Run(args []string) int {
 			return 1
 		}
 	}
-	if c.retryDuration == 0 {
-		c.retryDuration = 1 * time.Second
-	}
 	logger := hclog.Default()
 
+	ctx := context.Background()
+	if c.flagTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.flagTimeout)
+		defer cancel()
+	}
+
 	// Run until we get an address from the service.
 	var address string
 	var unretryableErr error
-	backoff.Retry(withErrLogger(logger, func() error {
-		svc, err := c.k8sClient.CoreV1().Services(c.flagNamespace).Get(context.TODO(), c.flagServiceName, metav1.GetOptions{})
+	err := backoff.Retry(withErrLogger(logger, func() error {
+		svc, err := c.k8sClient.CoreV1().Services(c.flagNamespace).Get(ctx, c.flagServiceName, metav1.GetOptions{})
 		if err != nil {
 			return fmt.Errorf("getting service %s: %s", c.flagServiceName, err)
 		}
@@ -117,15 +128,19 @@ func (c *Command) Run(args []string) int {
 			unretryableErr = fmt.Errorf("unknown service type %q", svc.Spec.Type)
 			return nil
 		}
-	}), backoff.NewConstantBackOff(c.retryDuration))
+	}), backoff.WithContext(backoff.NewConstantBackOff(c.flagRetryInterval), ctx))
 
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Timed out after %s waiting for service address: %s", c.flagTimeout, err))
+		return 1
+	}
 	if unretryableErr != nil {
 		c.UI.Error(fmt.Sprintf("Unable to get service address: %s", unretryableErr.Error()))
 		return 1
 	}
 
 	// Write the address to file.
-	err := ioutil.WriteFile(c.flagOutputFile, []byte(address), 0600)
+	err = ioutil.WriteFile(c.flagOutputFile, []byte(address), 0600)
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("Unable to write address to file: %s", err))
 		return 1
@@ -136,7 +151,7 @@ func (c *Command) Run(args []string) int {
 }
 
 func (c *Command) validateFlags(args []string) error {
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		return err
 	}
 	if len(c.flags.Args()) > 0 {
@@ -151,6 +166,12 @@ func (c *Command) validateFlags(args []string) error {
 	if c.flagOutputFile == "" {
 		return errors.New("-output-file must be set")
 	}
+	if c.flagTimeout < 0 {
+		return errors.New("-timeout must not be negative")
+	}
+	if c.flagRetryInterval <= 0 {
+		return errors.New("-retry-interval must be greater than 0")
+	}
 	return nil
 }
 
@@ -198,6 +219,7 @@ Usage: consul-k8s service-address [options]
 
   Waits until the Kubernetes service specified by -name in namespace
   -k8s-namespace is created, then writes its address to -output-file.
+  If -timeout is set, it fails if the service has no address by then.
   The address written depends on the service type:
     ClusterIP - Cluster IP
     NodePort - Not supported
@@ -35,6 +35,14 @@ func TestRun_FlagValidation(t *testing.T) {
 			Flags:  []string{"-k8s-namespace=default", "-name=name"},
 			ExpErr: "-output-file must be set",
 		},
+		{
+			Flags:  []string{"-k8s-namespace=default", "-name=name", "-output-file=file", "-timeout=-1s"},
+			ExpErr: "-timeout must not be negative",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=default", "-name=name", "-output-file=file", "-retry-interval=0s"},
+			ExpErr: "-retry-interval must be greater than 0",
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.ExpErr, func(t *testing.T) {
@@ -285,9 +293,8 @@ func TestRun_FileWrittenAfterRetry(t *testing.T) {
 			// Run command.
 			ui := cli.NewMockUi()
 			cmd := Command{
-				UI:            ui,
-				k8sClient:     k8s,
-				retryDuration: 10 * time.Millisecond,
+				UI:        ui,
+				k8sClient: k8s,
 			}
 			tmpDir, err := ioutil.TempDir("", "")
 			require.NoError(t, err)
@@ -298,6 +305,7 @@ func TestRun_FileWrittenAfterRetry(t *testing.T) {
 				"-k8s-namespace", k8sNS,
 				"-name", svcName,
 				"-output-file", outputFile,
+				"-retry-interval", "10ms",
 			})
 			require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
 			actAddressBytes, err := ioutil.ReadFile(outputFile)
@@ -307,6 +315,40 @@ func TestRun_FileWrittenAfterRetry(t *testing.T) {
 	}
 }
 
+// Test that the command fails if the service has no address before
+// -timeout.
+func TestRun_Timeout(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+	svc := kubeLoadBalancerSvc("service-name", "", "")
+	svc.Status = v1.ServiceStatus{}
+	_, err := k8s.CoreV1().Services("default").Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		k8sClient: k8s,
+	}
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	outputFile := filepath.Join(tmpDir, "address.txt")
+
+	responseCode := cmd.Run([]string{
+		"-k8s-namespace", "default",
+		"-name", "service-name",
+		"-output-file", outputFile,
+		"-timeout", "100ms",
+		"-retry-interval", "10ms",
+	})
+	require.Equal(t, 1, responseCode)
+	require.Contains(t, ui.ErrorWriter.String(),
+		"Timed out after 100ms waiting for service address: service service-name has no ingress IP or hostname")
+	_, err = os.Stat(outputFile)
+	require.True(t, os.IsNotExist(err))
+}
+
 func kubeLoadBalancerSvc(name string, ip string, hostname string) *v1.Service {
 	return &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,321 @@
+package snapshotrestore
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command is the command for restoring a Consul snapshot.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	k8s   *flags.K8SFlags
+	http  *flags.HTTPFlags
+
+	flagSource       string
+	flagK8sNamespace string
+	flagScaleDown    []string
+	flagTimeout      time.Duration
+	flagLogLevel     string
+
+	k8sClient    kubernetes.Interface
+	consulClient *api.Client
+
+	// pollInterval is how often we check whether components have scaled
+	// down and whether the servers have recovered from the restore. It
+	// defaults to 1s and is exposed for setting in tests.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagSource, "source", "",
+		"Path or HTTP(S) URL of the snapshot to restore. Snapshots in object storage can be restored "+
+			"using a pre-signed URL. This value is required.")
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace of the components to scale down. Required if -scale-down is set.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagScaleDown), "scale-down",
+		"Deployment or StatefulSet to scale down to zero replicas while the snapshot is restored, "+
+			"in the form deployment/<name> or statefulset/<name>, e.g. deployment/consul-sync-catalog. "+
+			"Components that write to Consul, such as catalog sync and the controller, should be scaled down "+
+			"so they don't overwrite the restored state. They are scaled back up afterwards. May be specified "+
+			"multiple times.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 5*time.Minute,
+		"How long to wait for components to scale down and for the servers to recover after the restore, e.g. 1ms, 2s, 3m.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Default poll interval to 1s. This is exposed for setting in tests.
+	if c.pollInterval == 0 {
+		c.pollInterval = 1 * time.Second
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagSource == "" {
+		c.UI.Error("-source must be set")
+		return 1
+	}
+	var components []component
+	for _, s := range c.flagScaleDown {
+		comp, err := parseComponent(s)
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+		components = append(components, comp)
+	}
+	if len(components) > 0 && c.flagK8sNamespace == "" {
+		c.UI.Error("-k8s-namespace must be set if -scale-down is set")
+		return 1
+	}
+
+	logger, err := common.Logger(c.flagLogLevel)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	// Stage and verify the snapshot before touching anything so that a bad
+	// snapshot doesn't cause any downtime.
+	path, err := stage(c.flagSource)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error staging snapshot: %s", err))
+		return 1
+	}
+	defer os.Remove(path)
+	meta, err := verifySnapshot(path)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error verifying snapshot %s: %s", redactURL(c.flagSource), err))
+		return 1
+	}
+	c.UI.Info(fmt.Sprintf("Staged snapshot %s at index %d", meta.ID, meta.Index))
+
+	if len(components) > 0 && c.k8sClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.k8sClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	leader, err := c.consulClient.Status().Leader()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading Consul leader: %s", err))
+		return 1
+	}
+	if leader == "" {
+		c.UI.Error("Consul has no leader, a snapshot can only be restored once a leader is elected")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.flagTimeout)
+	defer cancel()
+
+	// Components are always scaled back up, even if the restore failed, so
+	// that a failed restore doesn't leave them down.
+	scaledDown, err := c.scaleDown(ctx, logger, components)
+	defer func() {
+		if err := c.scaleUp(logger, scaledDown); err != nil {
+			c.UI.Error(fmt.Sprintf("Error scaling components back up: %s", err))
+		}
+	}()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error scaling down components: %s", err))
+		return 1
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening staged snapshot: %s", err))
+		return 1
+	}
+	defer f.Close()
+	c.UI.Info(fmt.Sprintf("Restoring snapshot through leader %s...", leader))
+	if err := c.consulClient.Snapshot().Restore(nil, f); err != nil {
+		c.UI.Error(fmt.Sprintf("Error restoring snapshot: %s", err))
+		return 1
+	}
+
+	nodes, services, err := c.verifyCatalog(ctx)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Snapshot was restored but verifying the catalog failed: %s", err))
+		return 1
+	}
+	c.UI.Info(fmt.Sprintf("Snapshot restored, the catalog has %d nodes and %d services", nodes, services))
+	return 0
+}
+
+// verifyCatalog waits for a leader to be elected after the restore and for
+// the catalog to be readable, and returns the number of nodes and services
+// registered in it.
+func (c *Command) verifyCatalog(ctx context.Context) (int, int, error) {
+	var nodes, services int
+	err := c.waitFor(ctx, func() (bool, error) {
+		leader, err := c.consulClient.Status().Leader()
+		if err != nil {
+			return false, err
+		}
+		if leader == "" {
+			return false, errors.New("no leader")
+		}
+		// Consistent reads fail until the servers agree on the restored
+		// state.
+		opts := &api.QueryOptions{RequireConsistent: true}
+		catalogServices, _, err := c.consulClient.Catalog().Services(opts)
+		if err != nil {
+			return false, err
+		}
+		if _, ok := catalogServices["consul"]; !ok {
+			return false, errors.New("consul service is missing from the catalog")
+		}
+		catalogNodes, _, err := c.consulClient.Catalog().Nodes(opts)
+		if err != nil {
+			return false, err
+		}
+		nodes, services = len(catalogNodes), len(catalogServices)
+		return true, nil
+	})
+	return nodes, services, err
+}
+
+// waitFor calls fn every poll interval until it returns true or ctx is done.
+func (c *Command) waitFor(ctx context.Context, fn func() (bool, error)) error {
+	var lastErr error
+	for {
+		done, err := fn()
+		if err == nil && done {
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-time.After(c.pollInterval):
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("timed out: %s", lastErr)
+			}
+			return errors.New("timed out")
+		}
+	}
+}
+
+// stage copies the snapshot at source to a temporary file and returns its
+// path.
+func stage(source string) (string, error) {
+	var in io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := http.Get(source)
+		if err != nil {
+			// The error includes the URL so it would leak its credentials.
+			if urlErr, ok := err.(*url.Error); ok {
+				err = urlErr.Err
+			}
+			return "", fmt.Errorf("downloading %s: %s", redactURL(source), err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("downloading %s: unexpected status %s", redactURL(source), resp.Status)
+		}
+		in = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return "", err
+		}
+		in = f
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile("", "consul-snapshot")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// redactURL removes the query string from rawURL since pre-signed URLs carry
+// their credentials there.
+func redactURL(rawURL string) string {
+	if i := strings.Index(rawURL, "?"); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Restore a Consul snapshot."
+const help = `
+Usage: consul-k8s snapshot restore [options]
+
+  Restores a snapshot taken with "consul snapshot save" or the snapshot
+  agent, from local disk or an HTTP(S) URL such as a pre-signed object
+  storage URL.
+
+  The snapshot is downloaded and its checksums are verified before
+  anything is changed. The components given with -scale-down are then
+  scaled down to zero replicas, the snapshot is restored through the
+  current leader and, once the servers have recovered, the catalog is
+  checked to be consistently readable. The
+  components are scaled back up to their original number of replicas
+  whether or not the restore succeeded.
+`
@@ -0,0 +1,219 @@
+package snapshotrestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const ns = "default"
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-source must be set",
+		},
+		{
+			flags:  []string{"-source", "backup.snap", "-scale-down", "daemonset/consul"},
+			expErr: `-scale-down "daemonset/consul" is invalid, must be deployment/<name> or statefulset/<name>`,
+		},
+		{
+			flags:  []string{"-source", "backup.snap", "-scale-down", "deployment/consul-sync-catalog"},
+			expErr: "-k8s-namespace must be set if -scale-down is set",
+		},
+		{
+			flags:  []string{"-source", "backup.snap", "-log-level", "invalid"},
+			expErr: "unknown log level: invalid",
+		},
+		{
+			flags:  []string{"-source", "/does/not/exist.snap"},
+			expErr: "Error staging snapshot",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test restoring from a file and over HTTP. Writes made after the snapshot
+// was taken must be lost and the components scaled back up.
+func TestRun_Restore(t *testing.T) {
+	t.Parallel()
+	for _, source := range []string{"file", "http"} {
+		t.Run(source, func(t *testing.T) {
+			consulClient := setupConsul(t)
+			_, err := consulClient.KV().Put(&api.KVPair{Key: "before", Value: []byte("1")}, nil)
+			require.NoError(t, err)
+			snapshot := saveSnapshot(t, consulClient)
+			_, err = consulClient.KV().Put(&api.KVPair{Key: "after", Value: []byte("1")}, nil)
+			require.NoError(t, err)
+
+			path := filepath.Join(writeDir(t), "backup.snap")
+			require.NoError(t, ioutil.WriteFile(path, snapshot, 0644))
+			if source == "http" {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					require.Equal(t, "/backups/backup.snap", r.URL.Path)
+					w.Write(snapshot)
+				}))
+				defer server.Close()
+				path = server.URL + "/backups/backup.snap?X-Amz-Signature=secret"
+			}
+
+			k8s, replicaUpdates := setupK8s()
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:           ui,
+				k8sClient:    k8s,
+				consulClient: consulClient,
+				pollInterval: 10 * time.Millisecond,
+			}
+			exitCode := cmd.Run([]string{
+				"-source", path,
+				"-k8s-namespace", ns,
+				"-scale-down", "deployment/consul-sync-catalog",
+				"-scale-down", "statefulset/consul-controller",
+			})
+			require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.OutputWriter.String(), "Snapshot restored, the catalog has 1 nodes and 1 services")
+
+			pair, _, err := consulClient.KV().Get("before", nil)
+			require.NoError(t, err)
+			require.NotNil(t, pair)
+			pair, _, err = consulClient.KV().Get("after", nil)
+			require.NoError(t, err)
+			require.Nil(t, pair)
+
+			require.Equal(t, map[string][]int32{
+				"consul-sync-catalog": {0, 2},
+				"consul-controller":   {0, 1},
+			}, *replicaUpdates)
+		})
+	}
+}
+
+// Test that a corrupt snapshot is rejected before anything is scaled down.
+func TestRun_CorruptSnapshot(t *testing.T) {
+	t.Parallel()
+	consulClient := setupConsul(t)
+	snapshot := saveSnapshot(t, consulClient)
+
+	// Rewrite the archive with a modified state.bin.
+	var corrupt bytes.Buffer
+	gzw := gzip.NewWriter(&corrupt)
+	tw := tar.NewWriter(gzw)
+	gzr, err := gzip.NewReader(bytes.NewReader(snapshot))
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		if hdr.Name == "state.bin" {
+			data[len(data)-1] ^= 0xff
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	path := filepath.Join(writeDir(t), "corrupt.snap")
+	require.NoError(t, ioutil.WriteFile(path, corrupt.Bytes(), 0644))
+
+	k8s, replicaUpdates := setupK8s()
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, k8sClient: k8s, consulClient: consulClient}
+	exitCode := cmd.Run([]string{"-source", path, "-k8s-namespace", ns, "-scale-down", "deployment/consul-sync-catalog"})
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.ErrorWriter.String(), "checksum of state.bin doesn't match SHA256SUMS, the snapshot is corrupt")
+	require.Empty(t, *replicaUpdates)
+}
+
+func TestRedactURL(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, "https://bucket.s3.amazonaws.com/backup.snap", redactURL("https://bucket.s3.amazonaws.com/backup.snap?X-Amz-Signature=secret"))
+	require.Equal(t, "https://example.com/backup.snap", redactURL("https://example.com/backup.snap"))
+}
+
+func saveSnapshot(t *testing.T, consulClient *api.Client) []byte {
+	snap, _, err := consulClient.Snapshot().Save(nil)
+	require.NoError(t, err)
+	defer snap.Close()
+	data, err := ioutil.ReadAll(snap)
+	require.NoError(t, err)
+	return data
+}
+
+// setupK8s returns a client holding the components to scale down and a map
+// recording the replicas each one was updated to.
+func setupK8s() (*fake.Clientset, *map[string][]int32) {
+	two, one := int32(2), int32(1)
+	k8s := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-sync-catalog", Namespace: ns},
+			Spec:       appsv1.DeploymentSpec{Replicas: &two},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-controller", Namespace: ns},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &one},
+		},
+	)
+	updates := make(map[string][]int32)
+	k8s.PrependReactor("update", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		switch obj := action.(k8stesting.UpdateAction).GetObject().(type) {
+		case *appsv1.Deployment:
+			updates[obj.Name] = append(updates[obj.Name], *obj.Spec.Replicas)
+		case *appsv1.StatefulSet:
+			updates[obj.Name] = append(updates[obj.Name], *obj.Spec.Replicas)
+		}
+		return false, nil, nil
+	})
+	return k8s, &updates
+}
+
+func writeDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "snapshot-restore")
+	require.NoError(t, err)
+	return dir
+}
+
+func setupConsul(t *testing.T) *api.Client {
+	consul, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { consul.Stop() })
+	consul.WaitForLeader(t)
+	consulClient, err := api.NewClient(&api.Config{Address: consul.HTTPAddr})
+	require.NoError(t, err)
+	return consulClient
+}
@@ -0,0 +1,129 @@
+package snapshotrestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	kindDeployment  = "deployment"
+	kindStatefulSet = "statefulset"
+)
+
+// component is a workload that is scaled down during the restore.
+type component struct {
+	kind string
+	name string
+	// replicas is the number of replicas to scale back up to.
+	replicas int32
+}
+
+func (comp component) String() string {
+	return comp.kind + "/" + comp.name
+}
+
+func parseComponent(s string) (component, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[1] == "" || (parts[0] != kindDeployment && parts[0] != kindStatefulSet) {
+		return component{}, fmt.Errorf("-scale-down %q is invalid, must be deployment/<name> or statefulset/<name>", s)
+	}
+	return component{kind: parts[0], name: parts[1]}, nil
+}
+
+// scaleDown scales components down to zero replicas and waits for their pods
+// to be gone. It returns the components that were scaled down with their
+// original number of replicas, even if it fails part way through, so that
+// they can be scaled back up.
+func (c *Command) scaleDown(ctx context.Context, logger hclog.Logger, components []component) ([]component, error) {
+	var scaledDown []component
+	for _, comp := range components {
+		replicas, err := c.setReplicas(ctx, comp, 0)
+		if err != nil {
+			return scaledDown, fmt.Errorf("%s: %s", comp, err)
+		}
+		comp.replicas = replicas
+		scaledDown = append(scaledDown, comp)
+		logger.Info("scaled down", "component", comp.String(), "replicas", replicas)
+	}
+
+	for _, comp := range scaledDown {
+		err := c.waitFor(ctx, func() (bool, error) {
+			current, err := c.currentReplicas(ctx, comp)
+			return current == 0, err
+		})
+		if err != nil {
+			return scaledDown, fmt.Errorf("waiting for %s to scale down: %s", comp, err)
+		}
+	}
+	return scaledDown, nil
+}
+
+// scaleUp restores the original number of replicas of components. It
+// doesn't wait for them to become ready.
+func (c *Command) scaleUp(logger hclog.Logger, components []component) error {
+	var errs []string
+	for _, comp := range components {
+		if _, err := c.setReplicas(context.Background(), comp, comp.replicas); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", comp, err))
+			continue
+		}
+		logger.Info("scaled up", "component", comp.String(), "replicas", comp.replicas)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// setReplicas sets the number of replicas of comp and returns the previous
+// number.
+func (c *Command) setReplicas(ctx context.Context, comp component, replicas int32) (int32, error) {
+	var previous int32 = 1
+	switch comp.kind {
+	case kindDeployment:
+		deployment, err := c.k8sClient.AppsV1().Deployments(c.flagK8sNamespace).Get(ctx, comp.name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		if deployment.Spec.Replicas != nil {
+			previous = *deployment.Spec.Replicas
+		}
+		deployment.Spec.Replicas = &replicas
+		_, err = c.k8sClient.AppsV1().Deployments(c.flagK8sNamespace).Update(ctx, deployment, metav1.UpdateOptions{})
+		return previous, err
+	default:
+		sts, err := c.k8sClient.AppsV1().StatefulSets(c.flagK8sNamespace).Get(ctx, comp.name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		if sts.Spec.Replicas != nil {
+			previous = *sts.Spec.Replicas
+		}
+		sts.Spec.Replicas = &replicas
+		_, err = c.k8sClient.AppsV1().StatefulSets(c.flagK8sNamespace).Update(ctx, sts, metav1.UpdateOptions{})
+		return previous, err
+	}
+}
+
+// currentReplicas returns the number of pods comp is running.
+func (c *Command) currentReplicas(ctx context.Context, comp component) (int32, error) {
+	switch comp.kind {
+	case kindDeployment:
+		deployment, err := c.k8sClient.AppsV1().Deployments(c.flagK8sNamespace).Get(ctx, comp.name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return deployment.Status.Replicas, nil
+	default:
+		sts, err := c.k8sClient.AppsV1().StatefulSets(c.flagK8sNamespace).Get(ctx, comp.name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return sts.Status.Replicas, nil
+	}
+}
@@ -0,0 +1,113 @@
+package snapshotrestore
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// snapshotMeta is the part of the Raft snapshot metadata stored in a
+// snapshot's meta.json that we use.
+type snapshotMeta struct {
+	ID    string
+	Index uint64
+	Term  uint64
+}
+
+// verifySnapshot checks that the file at path is a Consul snapshot archive
+// whose files match its SHA256SUMS and returns its metadata. This is the
+// same check the servers perform before restoring it, done up front so that
+// a corrupt snapshot is rejected before any component is scaled down.
+func verifySnapshot(path string) (*snapshotMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzipped snapshot archive: %s", err)
+	}
+	defer gz.Close()
+
+	hashes := make(map[string]string)
+	var sums []byte
+	var meta *snapshotMeta
+	archive := tar.NewReader(gz)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot archive: %s", err)
+		}
+
+		h := sha256.New()
+		switch hdr.Name {
+		case "meta.json":
+			var buf bytes.Buffer
+			if _, err := io.Copy(io.MultiWriter(h, &buf), archive); err != nil {
+				return nil, err
+			}
+			meta = &snapshotMeta{}
+			if err := json.Unmarshal(buf.Bytes(), meta); err != nil {
+				return nil, fmt.Errorf("decoding meta.json: %s", err)
+			}
+		case "SHA256SUMS":
+			if sums, err = ioutil.ReadAll(archive); err != nil {
+				return nil, err
+			}
+			continue
+		default:
+			if _, err := io.Copy(h, archive); err != nil {
+				return nil, err
+			}
+		}
+		hashes[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if meta == nil {
+		return nil, fmt.Errorf("meta.json is missing from the snapshot archive")
+	}
+	if _, ok := hashes["state.bin"]; !ok {
+		return nil, fmt.Errorf("state.bin is missing from the snapshot archive")
+	}
+	if sums == nil {
+		return nil, fmt.Errorf("SHA256SUMS is missing from the snapshot archive")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid SHA256SUMS line %q", scanner.Text())
+		}
+		sum, name := fields[0], fields[1]
+		actual, ok := hashes[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is listed in SHA256SUMS but missing from the snapshot archive", name)
+		}
+		if actual != sum {
+			return nil, fmt.Errorf("checksum of %s doesn't match SHA256SUMS, the snapshot is corrupt", name)
+		}
+		delete(hashes, name)
+	}
+	if len(hashes) > 0 {
+		var missing []string
+		for name := range hashes {
+			missing = append(missing, name)
+		}
+		return nil, fmt.Errorf("%s missing from SHA256SUMS", strings.Join(missing, ", "))
+	}
+	return meta, nil
+}
@@ -0,0 +1,430 @@
+package status
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	statusOK        = "OK"
+	statusUnhealthy = "UNHEALTHY"
+	statusSkipped   = "SKIPPED"
+)
+
+// Command is the command for printing a health summary of a Consul
+// installation, e.g. for support bundles.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	k8s   *flags.K8SFlags
+	http  *flags.HTTPFlags
+
+	flagK8sNamespace      string
+	flagReleaseName       string
+	flagMeshGatewayName   string
+	flagDialTimeout       time.Duration
+	flagMaxReplicationLag time.Duration
+
+	k8sClient    kubernetes.Interface
+	consulClient *api.Client
+
+	// now returns the current time. It is exposed for setting in tests.
+	now func() time.Time
+
+	once sync.Once
+	help string
+}
+
+// check is the result of checking the health of a component.
+type check struct {
+	name   string
+	status string
+	detail string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace Consul is installed in. This value is required.")
+	c.flags.StringVar(&c.flagReleaseName, "release-name", "",
+		"Name of the Helm release of Consul, whose components are found by their \"release\" "+
+			"label. This value is required.")
+	c.flags.StringVar(&c.flagMeshGatewayName, "mesh-gateway-service-name", "mesh-gateway",
+		"Name of the Consul service of the mesh gateways.")
+	c.flags.DurationVar(&c.flagDialTimeout, "dial-timeout", 5*time.Second,
+		"How long to wait for a TCP connection to each mesh gateway's WAN address.")
+	c.flags.DurationVar(&c.flagMaxReplicationLag, "max-replication-lag", 10*time.Minute,
+		"Maximum time since the last successful ACL replication round before replication is "+
+			"considered unhealthy.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	if c.now == nil {
+		c.now = time.Now
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagK8sNamespace == "" {
+		c.UI.Error("-k8s-namespace must be set")
+		return 1
+	}
+	if c.flagReleaseName == "" {
+		c.UI.Error("-release-name must be set")
+		return 1
+	}
+
+	if c.k8sClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.k8sClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	// The checks carry on when a component can't be read so that the summary
+	// is as complete as possible.
+	ctx := context.Background()
+	servers, serverReplicas := c.checkServers(ctx)
+	checks := []check{
+		servers,
+		c.checkClients(ctx),
+		c.checkInjector(ctx),
+		c.checkSyncCatalog(ctx),
+	}
+	checks = append(checks, c.checkLeader(serverReplicas)...)
+	checks = append(checks, c.checkMeshGateways(), c.checkACLReplication())
+
+	c.UI.Output(fmt.Sprintf("Consul release %q in namespace %q:", c.flagReleaseName, c.flagK8sNamespace))
+	unhealthy := 0
+	for _, check := range checks {
+		line := fmt.Sprintf("  %-18s %-10s %s", check.name, check.status, check.detail)
+		if check.status == statusUnhealthy {
+			unhealthy++
+			c.UI.Error(line)
+		} else {
+			c.UI.Output(line)
+		}
+	}
+	if unhealthy > 0 {
+		c.UI.Error(fmt.Sprintf("%d of %d checks are unhealthy", unhealthy, len(checks)))
+		return 1
+	}
+	c.UI.Info("All checks are healthy")
+	return 0
+}
+
+// selector returns the label selector of the release's component.
+func (c *Command) selector(component string) string {
+	return fmt.Sprintf("release=%s,component=%s", c.flagReleaseName, component)
+}
+
+// checkServers checks that the server StatefulSet is ready and returns its
+// number of replicas, or 0 if it can't be read.
+func (c *Command) checkServers(ctx context.Context) (check, int) {
+	result := check{name: "Servers"}
+	list, err := c.k8sClient.AppsV1().StatefulSets(c.flagK8sNamespace).List(ctx, metav1.ListOptions{LabelSelector: c.selector("server")})
+	if err != nil {
+		return unhealthy(result, "listing StatefulSets: %s", err), 0
+	}
+	if len(list.Items) == 0 {
+		return unhealthy(result, "no server StatefulSet found"), 0
+	}
+	var ready, replicas int
+	for _, sts := range list.Items {
+		if sts.Spec.Replicas != nil {
+			replicas += int(*sts.Spec.Replicas)
+		} else {
+			replicas++
+		}
+		ready += int(sts.Status.ReadyReplicas)
+	}
+	return readiness(result, ready, replicas), replicas
+}
+
+// checkClients checks that the client DaemonSet is ready on every node it's
+// scheduled on.
+func (c *Command) checkClients(ctx context.Context) check {
+	result := check{name: "Clients"}
+	list, err := c.k8sClient.AppsV1().DaemonSets(c.flagK8sNamespace).List(ctx, metav1.ListOptions{LabelSelector: c.selector("client")})
+	if err != nil {
+		return unhealthy(result, "listing DaemonSets: %s", err)
+	}
+	if len(list.Items) == 0 {
+		return skipped(result, "no client DaemonSet found")
+	}
+	var ready, desired int
+	for _, ds := range list.Items {
+		ready += int(ds.Status.NumberReady)
+		desired += int(ds.Status.DesiredNumberScheduled)
+	}
+	return readiness(result, ready, desired)
+}
+
+// checkInjector checks that the connect injector Deployment is ready and that
+// its webhooks have a CA bundle, without which the API server can't call
+// them.
+func (c *Command) checkInjector(ctx context.Context) check {
+	result := c.checkDeployment(ctx, "Connect injector", "connect-injector")
+	if result.status != statusOK {
+		return result
+	}
+	list, err := c.k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx,
+		metav1.ListOptions{LabelSelector: c.selector("connect-injector")})
+	if err != nil {
+		return unhealthy(result, "listing MutatingWebhookConfigurations: %s", err)
+	}
+	if len(list.Items) == 0 {
+		return unhealthy(result, "no MutatingWebhookConfiguration found")
+	}
+	for _, config := range list.Items {
+		for _, webhook := range config.Webhooks {
+			if len(webhook.ClientConfig.CABundle) == 0 {
+				return unhealthy(result, "webhook %s of MutatingWebhookConfiguration %s has no CA bundle", webhook.Name, config.Name)
+			}
+		}
+	}
+	result.detail += ", webhook has a CA bundle"
+	return result
+}
+
+// checkSyncCatalog checks that the sync-catalog Deployment is ready.
+func (c *Command) checkSyncCatalog(ctx context.Context) check {
+	return c.checkDeployment(ctx, "Sync catalog", "sync-catalog")
+}
+
+// checkDeployment checks that the Deployments of the release's component are
+// ready. It's skipped if there are none since the component is optional.
+func (c *Command) checkDeployment(ctx context.Context, name, component string) check {
+	result := check{name: name}
+	list, err := c.k8sClient.AppsV1().Deployments(c.flagK8sNamespace).List(ctx, metav1.ListOptions{LabelSelector: c.selector(component)})
+	if err != nil {
+		return unhealthy(result, "listing Deployments: %s", err)
+	}
+	if len(list.Items) == 0 {
+		return skipped(result, "not installed")
+	}
+	var ready, replicas int
+	for _, deployment := range list.Items {
+		if deployment.Spec.Replicas != nil {
+			replicas += int(*deployment.Spec.Replicas)
+		} else {
+			replicas++
+		}
+		ready += int(deployment.Status.ReadyReplicas)
+	}
+	return readiness(result, ready, replicas)
+}
+
+// checkLeader checks that the servers have a leader and that every server is
+// a raft voter.
+func (c *Command) checkLeader(serverReplicas int) []check {
+	leader := check{name: "Leader"}
+	peers := check{name: "Raft peers"}
+
+	addr, err := c.consulClient.Status().Leader()
+	if err != nil {
+		leader = unhealthy(leader, "reading leader: %s", err)
+	} else if addr == "" {
+		leader = unhealthy(leader, "no leader elected")
+	} else {
+		leader.status = statusOK
+		leader.detail = addr
+	}
+
+	raft, err := c.consulClient.Operator().RaftGetConfiguration(nil)
+	if err != nil {
+		return []check{leader, unhealthy(peers, "reading raft configuration: %s", err)}
+	}
+	var names []string
+	voters := 0
+	for _, server := range raft.Servers {
+		name := server.Node
+		if server.Leader {
+			name += " (leader)"
+		}
+		if server.Voter {
+			voters++
+		} else {
+			name += " (non-voter)"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	detail := fmt.Sprintf("%d voters: %s", voters, strings.Join(names, ", "))
+	if serverReplicas > 0 && voters < serverReplicas {
+		peers = unhealthy(peers, "%s, but %d servers are deployed", detail, serverReplicas)
+	} else {
+		peers.status = statusOK
+		peers.detail = detail
+	}
+	return []check{leader, peers}
+}
+
+// checkMeshGateways checks that the mesh gateways are passing their health
+// checks and that their WAN addresses, which the other datacenters connect
+// to, accept connections.
+func (c *Command) checkMeshGateways() check {
+	result := check{name: "Mesh gateways"}
+	entries, _, err := c.consulClient.Health().Service(c.flagMeshGatewayName, "", false, nil)
+	if err != nil {
+		return unhealthy(result, "reading %s instances: %s", c.flagMeshGatewayName, err)
+	}
+	if len(entries) == 0 {
+		return skipped(result, "no %s instances registered", c.flagMeshGatewayName)
+	}
+
+	var passing, reachable int
+	var problems []string
+	for _, entry := range entries {
+		if entry.Checks.AggregatedStatus() == api.HealthPassing {
+			passing++
+		} else {
+			problems = append(problems, fmt.Sprintf("%s is %s", entry.Service.ID, entry.Checks.AggregatedStatus()))
+		}
+
+		addr := wanAddress(entry)
+		conn, err := net.DialTimeout("tcp", addr, c.flagDialTimeout)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s is unreachable at %s", entry.Service.ID, addr))
+			continue
+		}
+		conn.Close()
+		reachable++
+	}
+	detail := fmt.Sprintf("%d/%d passing, %d/%d reachable", passing, len(entries), reachable, len(entries))
+	if len(problems) > 0 {
+		return unhealthy(result, "%s: %s", detail, strings.Join(problems, ", "))
+	}
+	result.status = statusOK
+	result.detail = detail
+	return result
+}
+
+// wanAddress returns the address the other datacenters connect to the mesh
+// gateway on.
+func wanAddress(entry *api.ServiceEntry) string {
+	if wan, ok := entry.Service.TaggedAddresses["wan"]; ok && wan.Address != "" {
+		return net.JoinHostPort(wan.Address, strconv.Itoa(wan.Port))
+	}
+	addr := entry.Service.Address
+	if addr == "" {
+		addr = entry.Node.Address
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(entry.Service.Port))
+}
+
+// checkACLReplication checks that ACL replication is running and has
+// succeeded recently. It's skipped in datacenters that don't replicate ACLs.
+func (c *Command) checkACLReplication() check {
+	result := check{name: "ACL replication"}
+	status, _, err := c.consulClient.ACL().Replication(nil)
+	if err != nil {
+		return unhealthy(result, "reading ACL replication status: %s", err)
+	}
+	if !status.Enabled {
+		return skipped(result, "not enabled")
+	}
+	if !status.Running {
+		return unhealthy(result, "not running")
+	}
+	if status.LastSuccess.IsZero() {
+		return unhealthy(result, "never succeeded")
+	}
+	lag := c.now().Sub(status.LastSuccess).Round(time.Second)
+	detail := fmt.Sprintf("lag %s from %s", lag, status.SourceDatacenter)
+	if lag > c.flagMaxReplicationLag {
+		return unhealthy(result, "%s, more than %s", detail, c.flagMaxReplicationLag)
+	}
+	result.status = statusOK
+	result.detail = detail
+	return result
+}
+
+// readiness returns result as healthy if all the pods are ready.
+func readiness(result check, ready, total int) check {
+	detail := fmt.Sprintf("%d/%d ready", ready, total)
+	if ready < total {
+		return unhealthy(result, "%s", detail)
+	}
+	result.status = statusOK
+	result.detail = detail
+	return result
+}
+
+func unhealthy(result check, format string, args ...interface{}) check {
+	result.status = statusUnhealthy
+	result.detail = fmt.Sprintf(format, args...)
+	return result
+}
+
+func skipped(result check, format string, args ...interface{}) check {
+	result.status = statusSkipped
+	result.detail = fmt.Sprintf(format, args...)
+	return result
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Print a health summary of a Consul installation."
+const help = `
+Usage: consul-k8s status [options]
+
+  Prints a summary of the health of the Consul installation of a Helm
+  release, for example to attach to a support request. It checks that the
+  servers, clients, connect injector and catalog sync are ready, that the
+  servers have a leader and all of them are raft voters, that the mesh
+  gateways are passing their health checks and reachable on their WAN
+  addresses, and how far ACL replication lags behind the primary
+  datacenter.
+
+  The components are found by their "release" and "component" labels.
+  Optional components that aren't installed are skipped. The command exits
+  with 1 if any check is unhealthy.
+`
@@ -0,0 +1,239 @@
+package status
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const ns = "default"
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-k8s-namespace must be set",
+		},
+		{
+			flags:  []string{"-k8s-namespace", ns},
+			expErr: "-release-name must be set",
+		},
+		{
+			flags:  []string{"-k8s-namespace", ns, "-release-name", "consul", "extra"},
+			expErr: "Should have no non-flag arguments.",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				k8sClient: fake.NewSimpleClientset(),
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_Healthy(t *testing.T) {
+	t.Parallel()
+	gateway, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer gateway.Close()
+
+	now := time.Now()
+	consul := newFakeConsul(t, fakeConsul{
+		voters:       3,
+		gateways:     []string{gateway.Addr().String()},
+		replicatedAt: now.Add(-30 * time.Second),
+	})
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		k8sClient:    fake.NewSimpleClientset(release(3, true)...),
+		consulClient: consul,
+		now:          func() time.Time { return now },
+	}
+	exitCode := cmd.Run([]string{"-k8s-namespace", ns, "-release-name", "consul"})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+	out := ui.OutputWriter.String()
+	for _, line := range []string{
+		"Servers            OK         3/3 ready",
+		"Clients            OK         2/2 ready",
+		"Connect injector   OK         1/1 ready, webhook has a CA bundle",
+		"Sync catalog       SKIPPED    not installed",
+		"Leader             OK         10.0.0.1:8300",
+		"Raft peers         OK         3 voters: consul-server-0 (leader), consul-server-1, consul-server-2",
+		"Mesh gateways      OK         1/1 passing, 1/1 reachable",
+		"ACL replication    OK         lag 30s from dc1",
+		"All checks are healthy",
+	} {
+		require.Contains(t, out, line)
+	}
+}
+
+// Test that the problems of each component are reported together.
+func TestRun_Unhealthy(t *testing.T) {
+	t.Parallel()
+	// A port that isn't listened on anymore.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	now := time.Now()
+	consul := newFakeConsul(t, fakeConsul{
+		voters:       2,
+		gateways:     []string{unreachable},
+		replicatedAt: now.Add(-time.Hour),
+	})
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		k8sClient:    fake.NewSimpleClientset(release(2, false)...),
+		consulClient: consul,
+		now:          func() time.Time { return now },
+	}
+	exitCode := cmd.Run([]string{"-k8s-namespace", ns, "-release-name", "consul", "-dial-timeout", "1s"})
+	require.Equal(t, 1, exitCode)
+
+	out := ui.ErrorWriter.String()
+	for _, line := range []string{
+		"Servers            UNHEALTHY  2/3 ready",
+		"Connect injector   UNHEALTHY  webhook consul-connect-injector.consul.hashicorp.com of MutatingWebhookConfiguration consul-connect-injector-cfg has no CA bundle",
+		"Raft peers         UNHEALTHY  2 voters: consul-server-0 (leader), consul-server-1, but 3 servers are deployed",
+		"Mesh gateways      UNHEALTHY  1/1 passing, 0/1 reachable: mesh-gateway-0 is unreachable at " + unreachable,
+		"ACL replication    UNHEALTHY  lag 1h0m0s from dc1, more than 10m0s",
+		"5 of 8 checks are unhealthy",
+	} {
+		require.Contains(t, out, line)
+	}
+}
+
+// release returns the resources of a release named consul with three
+// servers of which readyServers are ready.
+func release(readyServers int32, caBundle bool) []runtime.Object {
+	labels := func(component string) map[string]string {
+		return map[string]string{"app": "consul", "release": "consul", "component": component}
+	}
+	servers, injectors := int32(3), int32(1)
+	webhook := admissionv1.MutatingWebhook{Name: "consul-connect-injector.consul.hashicorp.com"}
+	if caBundle {
+		webhook.ClientConfig.CABundle = []byte("ca")
+	}
+	return []runtime.Object{
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-server", Namespace: ns, Labels: labels("server")},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &servers},
+			Status:     appsv1.StatefulSetStatus{ReadyReplicas: readyServers},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul", Namespace: ns, Labels: labels("client")},
+			Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, NumberReady: 2},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-connect-injector-webhook-deployment", Namespace: ns, Labels: labels("connect-injector")},
+			Spec:       appsv1.DeploymentSpec{Replicas: &injectors},
+			Status:     appsv1.DeploymentStatus{ReadyReplicas: 1},
+		},
+		&admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-connect-injector-cfg", Labels: labels("connect-injector")},
+			Webhooks:   []admissionv1.MutatingWebhook{webhook},
+		},
+		// A Deployment of another release.
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-sync-catalog", Namespace: ns,
+				Labels: map[string]string{"release": "other", "component": "sync-catalog"}},
+		},
+	}
+}
+
+// fakeConsul configures the responses of a fake Consul server.
+type fakeConsul struct {
+	// voters is the number of raft voters.
+	voters int
+	// gateways are the WAN addresses of the mesh gateway instances.
+	gateways []string
+	// replicatedAt is the last success of ACL replication.
+	replicatedAt time.Time
+}
+
+// newFakeConsul returns a client of a fake Consul server that serves the
+// endpoints the command reads.
+func newFakeConsul(t *testing.T, f fakeConsul) *api.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reply interface{}
+		switch r.URL.Path {
+		case "/v1/status/leader":
+			reply = "10.0.0.1:8300"
+		case "/v1/operator/raft/configuration":
+			config := api.RaftConfiguration{}
+			for i := 0; i < f.voters; i++ {
+				config.Servers = append(config.Servers, &api.RaftServer{
+					Node:   "consul-server-" + strconv.Itoa(i),
+					Leader: i == 0,
+					Voter:  true,
+				})
+			}
+			reply = config
+		case "/v1/health/service/mesh-gateway":
+			var entries []*api.ServiceEntry
+			for i, addr := range f.gateways {
+				host, port, err := net.SplitHostPort(addr)
+				require.NoError(t, err)
+				portNum, err := strconv.Atoi(port)
+				require.NoError(t, err)
+				entries = append(entries, &api.ServiceEntry{
+					Node: &api.Node{Address: "10.0.0.10"},
+					Service: &api.AgentService{
+						ID:      "mesh-gateway-" + strconv.Itoa(i),
+						Port:    8443,
+						Address: "10.0.0.10",
+						TaggedAddresses: map[string]api.ServiceAddress{
+							"wan": {Address: host, Port: portNum},
+						},
+					},
+					Checks: api.HealthChecks{{Status: api.HealthPassing}},
+				})
+			}
+			reply = entries
+		case "/v1/acl/replication":
+			reply = api.ACLReplicationStatus{
+				Enabled:          true,
+				Running:          true,
+				SourceDatacenter: "dc1",
+				LastSuccess:      f.replicatedAt,
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(reply))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+	return client
+}
@@ -1,6 +1,7 @@
 package synccatalog
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
@@ -10,19 +11,28 @@ import (
 	"regexp"
 	"sync"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/catalog/dryrun"
+	"github.com/hashicorp/consul-k8s/catalog/metrics"
 	catalogtoconsul "github.com/hashicorp/consul-k8s/catalog/to-consul"
 	catalogtok8s "github.com/hashicorp/consul-k8s/catalog/to-k8s"
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/helper/dashboard"
+	"github.com/hashicorp/consul-k8s/helper/servicemeta"
+	"github.com/hashicorp/consul-k8s/namespaces"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 )
@@ -34,6 +44,7 @@ type Command struct {
 
 	flags                     *flag.FlagSet
 	http                      *flags.HTTPFlags
+	fault                     *flags.FaultFlags
 	k8s                       *flags.K8SFlags
 	flagListen                string
 	flagToConsul              bool
@@ -41,26 +52,42 @@ type Command struct {
 	flagConsulDomain          string
 	flagConsulK8STag          string
 	flagConsulNodeName        string
+	flagK8SClusterName        string
 	flagK8SDefault            bool
 	flagK8SServicePrefix      string
+	flagK8SLabelSelector      string
+	flagK8SServiceMetadata    bool
 	flagConsulServicePrefix   string
 	flagK8SSourceNamespace    string
 	flagK8SWriteNamespace     string
 	flagConsulWritePeriod     time.Duration
+	flagConsulWriteRateLimit  float64
+	flagConsulWriteBatchSize  int
 	flagSyncClusterIPServices bool
+	flagSyncHeadlessServices  bool
 	flagSyncLBEndpoints       bool
+	flagSyncEndpointSlices    bool
+	flagSyncIngress           bool
 	flagNodePortSyncType      string
 	flagAddK8SNamespaceSuffix bool
+	flagDashboardURLTemplate  string
 	flagLogLevel              string
+	flagDryRun                bool
 
 	// Flags to support namespaces
 	flagEnableNamespaces           bool     // Use namespacing on all components
 	flagConsulDestinationNamespace string   // Consul namespace to register everything if not mirroring
 	flagAllowK8sNamespacesList     []string // K8s namespaces to explicitly inject
 	flagDenyK8sNamespacesList      []string // K8s namespaces to deny injection (has precedence)
+	flagMetaLabelPrefixes          []string // Prefixes of the labels to copy into service meta
+	flagMetaAnnotationPrefixes     []string // Prefixes of the annotations to copy into service meta
 	flagEnableK8SNSMirroring       bool     // Enables mirroring of k8s namespaces into Consul
 	flagK8SNSMirroringPrefix       string   // Prefix added to Consul namespaces created when mirroring
+	flagK8SNSMappingFile           string   // File mapping k8s namespaces to Consul namespaces
 	flagCrossNamespaceACLPolicy    string   // The name of the ACL policy to add to every created namespace if ACLs are enabled
+	flagConsulSourceNamespace      string   // Consul namespace whose services are synced to k8s, or "*"
+	flagEnableConsulNSMirroring    bool     // Enables mirroring of Consul namespaces into k8s
+	flagConsulNSMirroringPrefix    string   // Prefix added to k8s namespaces created when mirroring
 
 	consulClient *api.Client
 	clientset    kubernetes.Interface
@@ -73,7 +100,8 @@ type Command struct {
 
 func (c *Command) init() {
 	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
-	c.flags.StringVar(&c.flagListen, "listen", ":8080", "Address to bind listener to.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080", "Address to bind listener to. "+
+		"Serves the health check at /health/ready and Prometheus metrics at /metrics.")
 	c.flags.BoolVar(&c.flagToConsul, "to-consul", true,
 		"If true, K8S services will be synced to Consul.")
 	c.flags.BoolVar(&c.flagToK8S, "to-k8s", true,
@@ -82,9 +110,16 @@ func (c *Command) init() {
 		"If true, all valid services in K8S are synced by default. If false, "+
 			"the service must be annotated properly to sync. In either case "+
 			"an annotation can override the default")
+	c.flags.StringVar(&c.flagK8SLabelSelector, "k8s-service-label-selector", "",
+		"If set, only K8S services whose labels match this selector, e.g. consul.hashicorp.com/sync=true, "+
+			"are synced to Consul. The annotation and -k8s-default-sync still apply to the services that match.")
 	c.flags.StringVar(&c.flagK8SServicePrefix, "k8s-service-prefix", "",
 		"A prefix to prepend to all services written to Kubernetes from Consul. "+
 			"If this is not set then services will have no prefix.")
+	c.flags.BoolVar(&c.flagK8SServiceMetadata, "k8s-service-metadata", false,
+		"If true, the tags and meta of Consul services are set as the consul.hashicorp.com/service-tags "+
+			"and consul.hashicorp.com/service-meta-<key> annotations of the services written to Kubernetes. "+
+			"This queries Consul once per service whenever the services change.")
 	c.flags.StringVar(&c.flagConsulServicePrefix, "consul-service-prefix", "",
 		"A prefix to prepend to all services written to Consul from Kubernetes. "+
 			"If this is not set then services will have no prefix.")
@@ -101,17 +136,40 @@ func (c *Command) init() {
 		"Tag value for K8S services registered in Consul")
 	c.flags.StringVar(&c.flagConsulNodeName, "consul-node-name", "k8s-sync",
 		"The Consul node name to register for catalog sync. Defaults to k8s-sync. To be discoverable "+
-			"via DNS, the name should only contain alpha-numerics and dashes.")
+			"via DNS, the name should only contain alpha-numerics and dashes. The name is a Go "+
+			"template in which {{ clusterName }} is the -k8s-cluster-name, e.g. k8s-sync-{{ clusterName }}, "+
+			"so that services synced from different Kubernetes clusters are registered on different nodes.")
+	c.flags.StringVar(&c.flagK8SClusterName, "k8s-cluster-name", "",
+		"The name of the Kubernetes cluster, used in the -consul-node-name template.")
 	c.flags.DurationVar(&c.flagConsulWritePeriod, "consul-write-interval", 30*time.Second,
 		"The interval to perform syncing operations creating Consul services, formatted "+
 			"as a time.Duration. All changes are merged and write calls are only made "+
 			"on this interval. Defaults to 30 seconds (30s).")
+	c.flags.Float64Var(&c.flagConsulWriteRateLimit, "consul-write-rate-limit", 0,
+		"The maximum number of write requests per second made to the Consul servers when syncing "+
+			"services to Consul, so that a change to thousands of services doesn't overload them. "+
+			"Defaults to 0, which doesn't limit the rate.")
+	c.flags.IntVar(&c.flagConsulWriteBatchSize, "consul-write-batch-size", 1,
+		fmt.Sprintf("The maximum number of registrations of services synced to Consul written in a "+
+			"single catalog transaction, up to %d. Defaults to 1, which writes each with its "+
+			"own request.", catalogtoconsul.MaxWriteBatchSize))
 	c.flags.BoolVar(&c.flagSyncClusterIPServices, "sync-clusterip-services", true,
 		"If true, all valid ClusterIP services in K8S are synced by default. If false, "+
 			"ClusterIP services are not synced to Consul.")
+	c.flags.BoolVar(&c.flagSyncHeadlessServices, "sync-headless-services", false,
+		"If true, headless ClusterIP services are synced to Consul even if -sync-clusterip-services "+
+			"is false, with an instance per pod registered with the pod's IP and target port.")
 	c.flags.BoolVar(&c.flagSyncLBEndpoints, "sync-lb-services-endpoints", false,
 		"If true, LoadBalancer service endpoints instead of ingress addresses will be synced to Consul. If false, "+
 			"LoadBalancer endpoints are not synced to Consul.")
+	c.flags.BoolVar(&c.flagSyncEndpointSlices, "sync-endpoint-slices", false,
+		"If true, the endpoints of services are read from their EndpointSlices and NodePort services "+
+			"are synced to Consul with an instance per ready pod, using the pod's IP and port, instead of "+
+			"an instance per node. Requires the discovery.k8s.io/v1beta1 API.")
+	c.flags.BoolVar(&c.flagSyncIngress, "sync-ingress", false,
+		"If true, Ingresses are synced to Consul as services named <ingress>-ingress, with an instance "+
+			"per load balancer address and the k8s-ingress tag. They're filtered and annotated like "+
+			"services. Requires the networking.k8s.io/v1beta1 API.")
 	c.flags.StringVar(&c.flagNodePortSyncType, "node-port-sync-type", "ExternalOnly",
 		"Defines the type of sync for NodePort services. Valid options are ExternalOnly, "+
 			"InternalOnly and ExternalFirst.")
@@ -119,6 +177,14 @@ func (c *Command) init() {
 		"If true, Kubernetes namespace will be appended to service names synced to Consul separated by a dash. "+
 			"If false, no suffix will be appended to the service names in Consul. "+
 			"If the service name annotation is provided, the suffix is not appended.")
+	c.flags.StringVar(&c.flagDashboardURLTemplate, "dashboard-url-template", "",
+		"Go template for a link to the Kubernetes dashboard that is added to the meta of each synced service "+
+			"under the \"k8s-dashboard-url\" key. The template is rendered with .Namespace, .Name (the "+
+			"Kubernetes service's name) and .ServiceName, e.g. \"https://dashboard.example.com/#/service/{{.Namespace}}/{{.Name}}\".")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"If true, nothing is written to Consul or Kubernetes. Instead, the Consul registrations and "+
+			"deregistrations and the Kubernetes service creations, updates and deletions that each sync "+
+			"would make are logged and served as JSON at /dry-run on the -listen address.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
@@ -127,6 +193,12 @@ func (c *Command) init() {
 		"K8s namespaces to explicitly allow. May be specified multiple times.")
 	c.flags.Var((*flags.AppendSliceValue)(&c.flagDenyK8sNamespacesList), "deny-k8s-namespace",
 		"K8s namespaces to explicitly deny. Takes precedence over allow. May be specified multiple times.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagMetaLabelPrefixes), "meta-label-prefix",
+		"Copy the Kubernetes service's labels that start with this prefix into the meta of its registrations, "+
+			"replacing characters that aren't allowed in meta keys with underscores. May be specified multiple times.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagMetaAnnotationPrefixes), "meta-annotation-prefix",
+		"Copy the Kubernetes service's annotations that start with this prefix into the meta of its registrations, "+
+			"replacing characters that aren't allowed in meta keys with underscores. May be specified multiple times.")
 	c.flags.BoolVar(&c.flagEnableNamespaces, "enable-namespaces", false,
 		"[Enterprise Only] Enables namespaces, in either a single Consul namespace or mirrored.")
 	c.flags.StringVar(&c.flagConsulDestinationNamespace, "consul-destination-namespace", "default",
@@ -136,15 +208,31 @@ func (c *Command) init() {
 		"namespace mirroring.")
 	c.flags.StringVar(&c.flagK8SNSMirroringPrefix, "k8s-namespace-mirroring-prefix", "",
 		"[Enterprise Only] Prefix that will be added to all k8s namespaces mirrored into Consul if mirroring is enabled.")
+	c.flags.StringVar(&c.flagK8SNSMappingFile, "k8s-namespace-mapping-file", "",
+		"[Enterprise Only] Path to a YAML file mapping k8s namespaces, which may contain '*' wildcards, to the "+
+			"Consul namespaces to register their services into. Namespaces that aren't mapped are synced according "+
+			"to the mirroring or destination namespace settings. Requires '-enable-namespaces'.")
 	c.flags.StringVar(&c.flagCrossNamespaceACLPolicy, "consul-cross-namespace-acl-policy", "",
 		"[Enterprise Only] Name of the ACL policy to attach to all created Consul namespaces to allow service "+
 			"discovery across Consul namespaces. Only necessary if ACLs are enabled.")
+	c.flags.StringVar(&c.flagConsulSourceNamespace, "consul-source-namespace", "default",
+		"[Enterprise Only] Name of the Consul namespace whose services are synced to Kubernetes, or '*' to "+
+			"sync the services of all Consul namespaces. Requires '-enable-namespaces'.")
+	c.flags.BoolVar(&c.flagEnableConsulNSMirroring, "enable-consul-namespace-mirroring", false,
+		"[Enterprise Only] Syncs the services of each Consul namespace into the Kubernetes namespace of the same "+
+			"name, which is created if needed, instead of '-k8s-write-namespace'. Requires '-enable-namespaces'.")
+	c.flags.StringVar(&c.flagConsulNSMirroringPrefix, "consul-namespace-mirroring-prefix", "",
+		"[Enterprise Only] Prefix that will be added to all Consul namespaces mirrored into Kubernetes if "+
+			"mirroring is enabled.")
 
 	c.http = &flags.HTTPFlags{}
 	c.k8s = &flags.K8SFlags{}
+	c.fault = &flags.FaultFlags{}
 	flags.Merge(c.flags, c.http.Flags())
 	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.fault.Flags())
 
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
 
 	// Wait on an interrupt or terminate to exit. This channel must be initialized before
@@ -158,7 +246,7 @@ func (c *Command) init() {
 
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		return 1
 	}
 	if len(c.flags.Args()) > 0 {
@@ -166,15 +254,58 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
+	// Render the node name first so that the rendered name is validated.
+	nodeName, err := renderNodeName(c.flagConsulNodeName, c.flagK8SClusterName)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("-consul-node-name is invalid: %s", err))
+		return 1
+	}
+	c.flagConsulNodeName = nodeName
+
 	// Validate flags
 	if err := c.validateFlags(); err != nil {
 		c.UI.Error(err.Error())
 		return 1
 	}
+	var k8sNSMapping *namespaces.Mapping
+	if c.flagK8SNSMappingFile != "" {
+		if !c.flagEnableNamespaces {
+			c.UI.Error("-k8s-namespace-mapping-file requires -enable-namespaces")
+			return 1
+		}
+		var err error
+		k8sNSMapping, err = namespaces.LoadMapping(c.flagK8SNSMappingFile)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading -k8s-namespace-mapping-file: %s", err))
+			return 1
+		}
+	}
+	metaPropagator := &servicemeta.Propagator{
+		LabelPrefixes:      c.flagMetaLabelPrefixes,
+		AnnotationPrefixes: c.flagMetaAnnotationPrefixes,
+	}
+	var labelSelector labels.Selector
+	if c.flagK8SLabelSelector != "" {
+		var err error
+		labelSelector, err = labels.Parse(c.flagK8SLabelSelector)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("-k8s-service-label-selector is invalid: %s", err))
+			return 1
+		}
+	}
+	var dashboardURLTemplate *dashboard.URLTemplate
+	if c.flagDashboardURLTemplate != "" {
+		var err error
+		dashboardURLTemplate, err = dashboard.ParseURLTemplate(c.flagDashboardURLTemplate)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("-dashboard-url-template is invalid: %s", err))
+			return 1
+		}
+	}
 
 	// Create the k8s clientset
 	if c.clientset == nil {
-		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
 			return 1
@@ -190,7 +321,7 @@ func (c *Command) Run(args []string) int {
 	// Setup Consul client
 	if c.consulClient == nil {
 		var err error
-		c.consulClient, err = c.http.APIClient()
+		c.consulClient, err = common.NewConsulClient(c.http, c.fault)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 			return 1
@@ -218,6 +349,33 @@ func (c *Command) Run(args []string) int {
 	c.logger.Info("K8s namespace syncing configuration", "k8s namespaces allowed to be synced", allowSet,
 		"k8s namespaces denied from syncing", denySet)
 
+	// Metrics are served on the same listener as the health check.
+	registry := prometheus.NewRegistry()
+	var toConsulMetrics, toK8SMetrics *metrics.SyncMetrics
+	if c.flagToConsul {
+		var err error
+		toConsulMetrics, err = metrics.NewSyncMetrics(registry, metrics.DirectionToConsul)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error registering metrics: %s", err))
+			return 1
+		}
+	}
+	if c.flagToK8S {
+		var err error
+		toK8SMetrics, err = metrics.NewSyncMetrics(registry, metrics.DirectionToK8S)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error registering metrics: %s", err))
+			return 1
+		}
+	}
+
+	var plan *dryrun.Plan
+	if c.flagDryRun {
+		c.logger.Info("Running in dry-run mode: the planned changes are logged and served at /dry-run " +
+			"but not made")
+		plan = &dryrun.Plan{}
+	}
+
 	// Create the context we'll use to cancel everything
 	ctx, cancelF := context.WithCancel(context.Background())
 
@@ -249,6 +407,10 @@ func (c *Command) Run(args []string) int {
 			ConsulK8STag:             c.flagConsulK8STag,
 			ConsulNodeName:           c.flagConsulNodeName,
 			ConsulNodeServicesClient: svcsClient,
+			Metrics:                  toConsulMetrics,
+			DryRun:                   plan,
+			WriteRateLimit:           c.flagConsulWriteRateLimit,
+			WriteBatchSize:           c.flagConsulWriteBatchSize,
 		}
 		go syncer.Run(ctx)
 
@@ -261,17 +423,24 @@ func (c *Command) Run(args []string) int {
 				Syncer:                     syncer,
 				AllowK8sNamespacesSet:      allowSet,
 				DenyK8sNamespacesSet:       denySet,
+				LabelSelector:              labelSelector,
 				ExplicitEnable:             !c.flagK8SDefault,
 				ClusterIPSync:              c.flagSyncClusterIPServices,
+				HeadlessSync:               c.flagSyncHeadlessServices,
 				LoadBalancerEndpointsSync:  c.flagSyncLBEndpoints,
 				NodePortSync:               catalogtoconsul.NodePortSyncType(c.flagNodePortSyncType),
+				EndpointSlicesSync:         c.flagSyncEndpointSlices,
+				IngressSync:                c.flagSyncIngress,
 				ConsulK8STag:               c.flagConsulK8STag,
 				ConsulServicePrefix:        c.flagConsulServicePrefix,
+				DashboardURLTemplate:       dashboardURLTemplate,
+				MetaPropagator:             metaPropagator,
 				AddK8SNamespaceSuffix:      c.flagAddK8SNamespaceSuffix,
 				EnableNamespaces:           c.flagEnableNamespaces,
 				ConsulDestinationNamespace: c.flagConsulDestinationNamespace,
 				EnableK8SNSMirroring:       c.flagEnableK8SNSMirroring,
 				K8SNSMirroringPrefix:       c.flagK8SNSMirroringPrefix,
+				K8SNSMapping:               k8sNSMapping,
 				ConsulNodeName:             c.flagConsulNodeName,
 			},
 		}
@@ -287,18 +456,27 @@ func (c *Command) Run(args []string) int {
 	var toK8SCh chan struct{}
 	if c.flagToK8S {
 		sink := &catalogtok8s.K8SSink{
-			Client:    c.clientset,
-			Namespace: c.flagK8SWriteNamespace,
-			Log:       c.logger.Named("to-k8s/sink"),
+			Client:        c.clientset,
+			Namespace:     c.flagK8SWriteNamespace,
+			Log:           c.logger.Named("to-k8s/sink"),
+			Metrics:       toK8SMetrics,
+			AllNamespaces: c.flagEnableNamespaces && c.flagEnableConsulNSMirroring,
+			DryRun:        plan,
 		}
 
 		source := &catalogtok8s.Source{
-			Client:       c.consulClient,
-			Domain:       c.flagConsulDomain,
-			Sink:         sink,
-			Prefix:       c.flagK8SServicePrefix,
-			Log:          c.logger.Named("to-k8s/source"),
-			ConsulK8STag: c.flagConsulK8STag,
+			Client:                  c.consulClient,
+			Domain:                  c.flagConsulDomain,
+			Sink:                    sink,
+			Prefix:                  c.flagK8SServicePrefix,
+			Log:                     c.logger.Named("to-k8s/source"),
+			ConsulK8STag:            c.flagConsulK8STag,
+			SyncMetadata:            c.flagK8SServiceMetadata,
+			Metrics:                 toK8SMetrics,
+			EnableNamespaces:        c.flagEnableNamespaces,
+			ConsulSourceNamespace:   c.flagConsulSourceNamespace,
+			EnableConsulNSMirroring: c.flagEnableConsulNSMirroring,
+			ConsulNSMirroringPrefix: c.flagConsulNSMirroringPrefix,
 		}
 		go source.Run(ctx)
 
@@ -319,6 +497,10 @@ func (c *Command) Run(args []string) int {
 	go func() {
 		mux := http.NewServeMux()
 		mux.HandleFunc("/health/ready", c.handleReady)
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		if plan != nil {
+			mux.Handle("/dry-run", plan)
+		}
 		var handler http.Handler = mux
 
 		c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
@@ -405,8 +587,37 @@ func (c *Command) validateFlags() error {
 			c.flagConsulNodeName,
 		)
 	}
+	if c.flagEnableConsulNSMirroring && !c.flagEnableNamespaces {
+		return fmt.Errorf("-enable-consul-namespace-mirroring requires -enable-namespaces")
+	}
+	if c.flagConsulWriteRateLimit < 0 {
+		return fmt.Errorf("-consul-write-rate-limit must not be negative")
+	}
+	if c.flagConsulWriteBatchSize < 1 || c.flagConsulWriteBatchSize > catalogtoconsul.MaxWriteBatchSize {
+		return fmt.Errorf("-consul-write-batch-size must be between 1 and %d", catalogtoconsul.MaxWriteBatchSize)
+	}
 
-	return nil
+	return c.fault.Validate()
+}
+
+// renderNodeName renders the -consul-node-name template.
+func renderNodeName(raw, clusterName string) (string, error) {
+	tpl, err := template.New("consul-node-name").Funcs(template.FuncMap{
+		"clusterName": func() (string, error) {
+			if clusterName == "" {
+				return "", fmt.Errorf("-k8s-cluster-name must be set to use {{ clusterName }}")
+			}
+			return clusterName, nil
+		},
+	}).Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 const synopsis = "Sync Kubernetes services and Consul services."
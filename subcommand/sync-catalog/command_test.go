@@ -2,12 +2,17 @@ package synccatalog
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/catalog/dryrun"
 	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/freeport"
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
@@ -36,6 +41,51 @@ func TestRun_FlagValidation(t *testing.T) {
 			ExpErr: "-consul-node-name=5r9OPGfSRXUdGzNjBdAwmhCBrzHDNYs4XjZVR4wp7lSLIzqwS0ta51nBLIN0TMPV-too-long is invalid: node name will not be discoverable " +
 				"via DNS due to it being too long. Valid lengths are between 1 and 63 bytes",
 		},
+		{
+			Flags:  []string{"-consul-node-name=k8s-sync-{{ clusterName"},
+			ExpErr: "-consul-node-name is invalid",
+		},
+		{
+			Flags:  []string{"-consul-node-name=k8s-sync-{{ clusterName }}"},
+			ExpErr: "-k8s-cluster-name must be set to use {{ clusterName }}",
+		},
+		{
+			Flags: []string{"-consul-node-name=k8s-sync-{{ clusterName }}", "-k8s-cluster-name=us_east"},
+			ExpErr: "-consul-node-name=k8s-sync-us_east is invalid: node name will not be discoverable " +
+				"via DNS due to invalid characters. Valid characters include all alpha-numerics and dashes",
+		},
+		{
+			Flags:  []string{"-fault-consul-delay-percent=-1"},
+			ExpErr: "-fault-consul-delay-percent must be between 0 and 100",
+		},
+		{
+			Flags:  []string{"-dashboard-url-template=https://dashboard.example.com/{{ .Pod }}"},
+			ExpErr: "-dashboard-url-template is invalid",
+		},
+		{
+			Flags:  []string{"-k8s-service-label-selector=consul.hashicorp.com/sync in true"},
+			ExpErr: "-k8s-service-label-selector is invalid",
+		},
+		{
+			Flags:  []string{"-k8s-namespace-mapping-file=mapping.yaml"},
+			ExpErr: "-k8s-namespace-mapping-file requires -enable-namespaces",
+		},
+		{
+			Flags:  []string{"-enable-namespaces", "-k8s-namespace-mapping-file=/does/not/exist.yaml"},
+			ExpErr: "Error reading -k8s-namespace-mapping-file: open /does/not/exist.yaml",
+		},
+		{
+			Flags:  []string{"-enable-consul-namespace-mirroring"},
+			ExpErr: "-enable-consul-namespace-mirroring requires -enable-namespaces",
+		},
+		{
+			Flags:  []string{"-consul-write-rate-limit=-1"},
+			ExpErr: "-consul-write-rate-limit must not be negative",
+		},
+		{
+			Flags:  []string{"-consul-write-batch-size=65"},
+			ExpErr: "-consul-write-batch-size must be between 1 and 64",
+		},
 	}
 
 	for _, c := range cases {
@@ -51,6 +101,26 @@ func TestRun_FlagValidation(t *testing.T) {
 	}
 }
 
+func TestRenderNodeName(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		raw         string
+		clusterName string
+		exp         string
+	}{
+		"no template":               {raw: "k8s-sync", exp: "k8s-sync"},
+		"no template, cluster name": {raw: "k8s-sync", clusterName: "east", exp: "k8s-sync"},
+		"cluster name":              {raw: "k8s-sync-{{ clusterName }}", clusterName: "east", exp: "k8s-sync-east"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			nodeName, err := renderNodeName(c.raw, c.clusterName)
+			require.NoError(t, err)
+			require.Equal(t, c.exp, nodeName)
+		})
+	}
+}
+
 // Test that the default consul service is synced to k8s
 func TestRun_Defaults_SyncsConsulServiceToK8s(t *testing.T) {
 	t.Parallel()
@@ -83,6 +153,79 @@ func TestRun_Defaults_SyncsConsulServiceToK8s(t *testing.T) {
 	})
 }
 
+// Test that with -dry-run the planned changes are served but not made.
+func TestRun_DryRun(t *testing.T) {
+	t.Parallel()
+
+	k8s, testServer := completeSetup(t)
+	defer testServer.Stop()
+
+	consulClient, err := api.NewClient(&api.Config{
+		Address: testServer.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	// Run the command.
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		clientset:    k8s,
+		consulClient: consulClient,
+		logger: hclog.New(&hclog.LoggerOptions{
+			Name:  t.Name(),
+			Level: hclog.Debug,
+		}),
+		flagAllowK8sNamespacesList: []string{"*"},
+	}
+
+	_, err = k8s.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), lbService("foo", "1.1.1.1"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	ports := freeport.MustTake(1)
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-consul-write-interval", "100ms",
+		"-listen", fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		"-dry-run",
+	})
+	defer stopCommand(t, &cmd, exitChan)
+
+	var plan struct {
+		ToConsul *struct {
+			ConsulChanges []dryrun.ConsulChange
+		}
+		ToK8S *struct {
+			K8SChanges []dryrun.K8SChange
+		}
+	}
+	retry.Run(t, func(r *retry.R) {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/dry-run", ports[0]))
+		require.NoError(r, err)
+		defer resp.Body.Close()
+		require.Equal(r, http.StatusOK, resp.StatusCode)
+		require.NoError(r, json.NewDecoder(resp.Body).Decode(&plan))
+		require.NotNil(r, plan.ToConsul)
+		require.NotNil(r, plan.ToK8S)
+		require.Len(r, plan.ToConsul.ConsulChanges, 1)
+	})
+	require.Equal(t, dryrun.OperationRegister, plan.ToConsul.ConsulChanges[0].Operation)
+	require.Equal(t, "foo", plan.ToConsul.ConsulChanges[0].ServiceName)
+	require.Equal(t, []dryrun.K8SChange{{
+		Operation:    dryrun.OperationCreate,
+		Namespace:    metav1.NamespaceDefault,
+		Name:         "consul",
+		ExternalName: "consul.service.consul",
+	}}, plan.ToK8S.K8SChanges)
+
+	// Nothing was written.
+	services, _, err := consulClient.Catalog().Services(nil)
+	require.NoError(t, err)
+	require.NotContains(t, services, "foo")
+	serviceList, err := k8s.CoreV1().Services(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, serviceList.Items, 1)
+	require.Equal(t, "foo", serviceList.Items[0].Name)
+}
+
 // Test that the command exits cleanly on signals
 func TestRun_ExitCleanlyOnSignals(t *testing.T) {
 	t.Run("SIGINT", testSignalHandling(syscall.SIGINT))
@@ -26,8 +26,9 @@ type Command struct {
 	UI        cli.Ui
 	clientset kubernetes.Interface
 
-	flags    *flag.FlagSet
-	k8sFlags *flags.K8SFlags
+	flags       *flag.FlagSet
+	k8sFlags    *flags.K8SFlags
+	secretFlags *flags.SecretFlags
 
 	// flags that support the CA/key as files on disk.
 	flagCaFile  string
@@ -61,7 +62,7 @@ type Command struct {
 
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flags.Parse(args); err != nil {
+	if err := flags.Parse(c.flags, args); err != nil {
 		c.UI.Error(fmt.Sprintf("Failed to parse args: %v", err))
 		return 1
 	}
@@ -132,10 +133,7 @@ func (c *Command) Run(args []string) int {
 
 		c.log.Info("saving CA certificate", "secret", fmt.Sprintf("%s-ca-cert", c.flagNamePrefix))
 		c.caCertSecret, err = c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Create(c.ctx, &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("%s-ca-cert", c.flagNamePrefix),
-				Namespace: c.flagK8sNamespace,
-			},
+			ObjectMeta: c.secretMeta(fmt.Sprintf("%s-ca-cert", c.flagNamePrefix)),
 			Data: map[string][]byte{
 				corev1.TLSCertKey: []byte(ca),
 			},
@@ -148,10 +146,7 @@ func (c *Command) Run(args []string) int {
 		}
 		c.log.Info("saving ca private key", "secret", fmt.Sprintf("%s-ca-key", c.flagNamePrefix))
 		c.caKeySecret, err = c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Create(c.ctx, &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("%s-ca-key", c.flagNamePrefix),
-				Namespace: c.flagK8sNamespace,
-			},
+			ObjectMeta: c.secretMeta(fmt.Sprintf("%s-ca-key", c.flagNamePrefix)),
 			Data: map[string][]byte{
 				corev1.TLSPrivateKeyKey: []byte(pk),
 			},
@@ -233,10 +228,7 @@ func (c *Command) Run(args []string) int {
 	if err != nil && k8serrors.IsNotFound(err) {
 		c.log.Info("creating server certificate and private key secret")
 		_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Create(c.ctx, &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: c.flagK8sNamespace,
-				Name:      fmt.Sprintf("%s-server-cert", c.flagNamePrefix),
-			},
+			ObjectMeta: c.secretMeta(fmt.Sprintf("%s-server-cert", c.flagNamePrefix)),
 			Data: map[string][]byte{
 				corev1.TLSCertKey:       []byte(serverCert),
 				corev1.TLSPrivateKeyKey: []byte(serverKey),
@@ -252,6 +244,7 @@ func (c *Command) Run(args []string) int {
 			corev1.TLSCertKey:       []byte(serverCert),
 			corev1.TLSPrivateKeyKey: []byte(serverKey),
 		}
+		c.secretFlags.MergeOntoObjectMeta(&serverCertSecret.ObjectMeta, secretCreatedBy)
 		c.log.Info("updating server certificate and private key secret")
 		_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Update(c.ctx, serverCertSecret, metav1.UpdateOptions{})
 		if err != nil {
@@ -266,6 +259,16 @@ func (c *Command) Run(args []string) int {
 	return 0
 }
 
+// secretMeta returns the metadata of a new Secret named name.
+func (c *Command) secretMeta(name string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: c.flagK8sNamespace,
+	}
+	c.secretFlags.MergeOntoObjectMeta(&meta, secretCreatedBy)
+	return meta
+}
+
 // getDaysAsDuration returns number of days the certificate
 // is valid for as a time.Duration.
 func (c *Command) getDaysAsDuration() time.Duration {
@@ -294,13 +297,16 @@ func (c *Command) init() {
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
 	c.k8sFlags = &flags.K8SFlags{}
+	c.secretFlags = &flags.SecretFlags{}
 	flags.Merge(c.flags, c.k8sFlags.Flags())
+	flags.Merge(c.flags, c.secretFlags.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
 	c.help = flags.Usage(help, c.flags)
 }
 
 // configureKubeClient initialized the K8s clientset.
 func (c *Command) configureKubeClient() error {
-	config, err := subcommand.K8SConfig(c.k8sFlags.KubeConfig())
+	config, err := subcommand.K8SConfig(c.k8sFlags.KubeConfig(), c.k8sFlags.Context())
 	if err != nil {
 		return fmt.Errorf("error retrieving Kubernetes auth: %s", err)
 	}
@@ -344,10 +350,16 @@ func (c *Command) validateFlags() error {
 	if c.flagDays <= 0 {
 		return errors.New("-days must be a positive integer")
 	}
+	if err := c.secretFlags.Validate(); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// secretCreatedBy is the name of the command set on the Secrets it creates.
+const secretCreatedBy = "tls-init"
+
 const synopsis = "Initialize CA and Server Certificates during Consul install."
 const help = `
 Usage: consul-k8s tls-init [options]
@@ -44,6 +44,10 @@ func TestRun_FlagValidation(t *testing.T) {
 			flags:  []string{"-name-prefix", "consul", "-days", "-3"},
 			expErr: "-days must be a positive integer",
 		},
+		{
+			flags:  []string{"-name-prefix", "consul", "-secret-label", "team"},
+			expErr: "-secret-label=team is invalid: must be in the form key=value",
+		},
 	}
 
 	for _, c := range cases {
@@ -253,6 +257,31 @@ func TestRun_CreatesServerCertificatesWithoutExistingCerts(t *testing.T) {
 	require.Equal(t, &privateKey.PublicKey, certificate.PublicKey)
 }
 
+// Test that the Secrets created have the labels from the flags and the ones
+// describing what created them.
+func TestRun_SecretLabels(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	k8s := fake.NewSimpleClientset()
+	cmd.clientset = k8s
+
+	flags := []string{"-name-prefix", "consul", "-release-name", "release-name", "-secret-label", "team=mesh"}
+	exitCode := cmd.Run(flags)
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+	for _, name := range []string{"consul-ca-cert", "consul-ca-key", "consul-server-cert"} {
+		secret, err := k8s.CoreV1().Secrets("default").Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{
+			"team":                         "mesh",
+			"release":                      "release-name",
+			"app.kubernetes.io/managed-by": "consul-k8s",
+			"app.kubernetes.io/created-by": "tls-init",
+		}, secret.Labels, name)
+		require.Contains(t, secret.Annotations, "consul.hashicorp.com/created-by", name)
+	}
+}
+
 func TestRun_UpdatesServerCertificatesWithExistingCertsAsSecrets(t *testing.T) {
 	ui := cli.NewMockUi()
 	cmd := Command{UI: ui}
@@ -0,0 +1,428 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// serverName is the name of the test server's pod, service account and
+	// Consul service.
+	serverName = "consul-verify-server"
+
+	// clientName is the name of the test client's pod, service account and
+	// Consul service.
+	clientName = "consul-verify-client"
+
+	// ingressProbeName is the name of the pod used to probe the ingress
+	// gateway.
+	ingressProbeName = "consul-verify-ingress"
+
+	// probeContainerName is the name of the container in the probe pods
+	// whose exit code determines whether the probe passed.
+	probeContainerName = "probe"
+
+	// serverPort is the port the test server listens on.
+	serverPort = 8080
+
+	// upstreamPort is the local port the test client reaches the
+	// server on through its sidecar proxy.
+	upstreamPort = 1234
+
+	// labelVerify is added to every resource created so they can be
+	// identified and cleaned up.
+	labelVerify = "consul.hashicorp.com/verify"
+)
+
+// Command is the command for running a post-install smoke test of
+// the service mesh.
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	k8s   *flags.K8SFlags
+	http  *flags.HTTPFlags
+
+	flagK8sNamespace       string
+	flagServerImage        string
+	flagProbeImage         string
+	flagIngressGatewayAddr string
+	flagTimeout            time.Duration
+	flagLogLevel           string
+
+	k8sClient    kubernetes.Interface
+	consulClient *api.Client
+
+	// pollInterval is how often we check whether a test resource is ready.
+	// It defaults to 1s and is exposed for setting in tests.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "default",
+		"Name of the Kubernetes namespace to deploy the test services into. Connect injection must be enabled for this namespace.")
+	c.flags.StringVar(&c.flagServerImage, "server-image", "hashicorp/http-echo:latest",
+		"Docker image for the test server. It must accept the '-listen' and '-text' arguments of hashicorp/http-echo.")
+	c.flags.StringVar(&c.flagProbeImage, "probe-image", "curlimages/curl:latest",
+		"Docker image used to probe connectivity. It must contain curl.")
+	c.flags.StringVar(&c.flagIngressGatewayAddr, "ingress-gateway-addr", "",
+		"Address and port of an ingress gateway listener, e.g. consul-ingress-gateway:8080. If set, "+
+			"connectivity to the test server through the ingress gateway is also verified. The listener must "+
+			"route the test server's '<service>.ingress.*' host, for example using the '*' service.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 5*time.Minute,
+		"How long to wait for all checks to complete, e.g. 1ms, 2s, 3m.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.http.Flags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, new(flags.ConfigFileFlags).Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Default poll interval to 1s. This is exposed for setting in tests.
+	if c.pollInterval == 0 {
+		c.pollInterval = 1 * time.Second
+	}
+}
+
+// check is one step of the smoke test.
+type check struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := flags.Parse(c.flags, args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagK8sNamespace == "" {
+		c.UI.Error("-k8s-namespace must be set")
+		return 1
+	}
+
+	logger, err := common.Logger(c.flagLogLevel)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.k8sClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.k8sClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		c.consulClient, err = common.NewConsulClient(c.http, nil)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.flagTimeout)
+	defer cancel()
+
+	// Always clean up, even if a check failed part way through.
+	defer c.cleanup(logger)
+
+	checks := []check{
+		{name: "Test server is deployed and registered with Consul", run: c.deployServer},
+		{name: "Intention allowing the test client is created", run: c.createIntention},
+		{name: "Test client reaches the test server through the mesh", run: c.verifyMesh},
+	}
+	if c.flagIngressGatewayAddr != "" {
+		checks = append(checks, check{name: "Test server is reachable through the ingress gateway", run: c.verifyIngressGateway})
+	}
+
+	// Each check depends on the previous ones passing, so once one fails
+	// we skip the rest.
+	failed := false
+	for _, chk := range checks {
+		if failed {
+			c.UI.Output(fmt.Sprintf("SKIP  %s", chk.name))
+			continue
+		}
+		logger.Info("running check", "name", chk.name)
+		if err := chk.run(ctx); err != nil {
+			failed = true
+			c.UI.Error(fmt.Sprintf("FAIL  %s: %s", chk.name, err))
+			continue
+		}
+		c.UI.Output(fmt.Sprintf("PASS  %s", chk.name))
+	}
+	if c.flagIngressGatewayAddr == "" {
+		c.UI.Output("SKIP  Test server is reachable through the ingress gateway: -ingress-gateway-addr is not set")
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// deployServer creates the test server and waits for it to be registered
+// and healthy in Consul.
+func (c *Command) deployServer(ctx context.Context) error {
+	pod := c.pod(serverName, map[string]string{
+		"consul.hashicorp.com/connect-inject":       "true",
+		"consul.hashicorp.com/connect-service":      serverName,
+		"consul.hashicorp.com/connect-service-port": fmt.Sprintf("%d", serverPort),
+	}, corev1.Container{
+		Name:  "server",
+		Image: c.flagServerImage,
+		Args:  []string{fmt.Sprintf("-listen=:%d", serverPort), "-text=ok"},
+		Ports: []corev1.ContainerPort{{ContainerPort: serverPort}},
+	})
+	if err := c.createPod(ctx, pod, true); err != nil {
+		return err
+	}
+
+	return c.waitFor(ctx, func() (bool, error) {
+		instances, _, err := c.consulClient.Health().Service(serverName, "", true, nil)
+		if err != nil {
+			return false, err
+		}
+		return len(instances) > 0, nil
+	})
+}
+
+// createIntention allows the test client to connect to the test server. It
+// refuses to overwrite intentions for the test server that it didn't create.
+func (c *Command) createIntention(_ context.Context) error {
+	entry, _, err := c.consulClient.ConfigEntries().Get(api.ServiceIntentions, serverName, nil)
+	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "unexpected response code: 404") {
+		return err
+	}
+	if entry != nil && !createdByVerify(entry) {
+		return fmt.Errorf("intentions for %q already exist and were not created by this command", serverName)
+	}
+
+	_, _, err = c.consulClient.ConfigEntries().Set(&api.ServiceIntentionsConfigEntry{
+		Kind: api.ServiceIntentions,
+		Name: serverName,
+		Sources: []*api.SourceIntention{
+			{
+				Name:   clientName,
+				Action: api.IntentionActionAllow,
+			},
+		},
+		Meta: map[string]string{labelVerify: "true"},
+	}, nil)
+	return err
+}
+
+// verifyMesh runs a client that calls the server through its upstream.
+func (c *Command) verifyMesh(ctx context.Context) error {
+	pod := c.pod(clientName, map[string]string{
+		"consul.hashicorp.com/connect-inject":            "true",
+		"consul.hashicorp.com/connect-service":           clientName,
+		"consul.hashicorp.com/connect-service-upstreams": fmt.Sprintf("%s:%d", serverName, upstreamPort),
+	}, c.probeContainer(fmt.Sprintf("http://127.0.0.1:%d", upstreamPort), ""))
+	return c.runProbe(ctx, pod, true)
+}
+
+// verifyIngressGateway runs a pod outside of the mesh that calls the test
+// server through the ingress gateway.
+func (c *Command) verifyIngressGateway(ctx context.Context) error {
+	pod := c.pod(ingressProbeName, map[string]string{
+		"consul.hashicorp.com/connect-inject": "false",
+	}, c.probeContainer(fmt.Sprintf("http://%s", c.flagIngressGatewayAddr), fmt.Sprintf("%s.ingress.consul", serverName)))
+	return c.runProbe(ctx, pod, false)
+}
+
+// runProbe creates the probe pod and waits for its probe container to
+// terminate, returning an error if it didn't exit successfully.
+func (c *Command) runProbe(ctx context.Context, pod *corev1.Pod, createServiceAccount bool) error {
+	if err := c.createPod(ctx, pod, createServiceAccount); err != nil {
+		return err
+	}
+
+	var exitCode int32
+	err := c.waitFor(ctx, func() (bool, error) {
+		current, err := c.k8sClient.CoreV1().Pods(c.flagK8sNamespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range current.Status.ContainerStatuses {
+			if status.Name == probeContainerName && status.State.Terminated != nil {
+				exitCode = status.State.Terminated.ExitCode
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("request failed: %s container in pod %q exited with code %d", probeContainerName, pod.Name, exitCode)
+	}
+	return nil
+}
+
+// pod returns a test pod with the given annotations and container.
+func (c *Command) pod(name string, annotations map[string]string, container corev1.Container) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   c.flagK8sNamespace,
+			Labels:      map[string]string{labelVerify: "true"},
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: name,
+			RestartPolicy:      corev1.RestartPolicyNever,
+			Containers:         []corev1.Container{container},
+		},
+	}
+}
+
+// probeContainer returns a container that makes a single request to url,
+// optionally overriding the Host header, and exits non-zero if it fails.
+func (c *Command) probeContainer(url, host string) corev1.Container {
+	args := []string{"--silent", "--show-error", "--fail", "--retry", "10", "--retry-connrefused", "--retry-delay", "2"}
+	if host != "" {
+		args = append(args, "--header", fmt.Sprintf("Host: %s", host))
+	}
+	return corev1.Container{
+		Name:    probeContainerName,
+		Image:   c.flagProbeImage,
+		Command: []string{"curl"},
+		Args:    append(args, url),
+	}
+}
+
+// createPod creates the pod and, if requested, a service account of the
+// same name. When ACLs are enabled the injector requires the service account
+// name to match the Consul service name.
+func (c *Command) createPod(ctx context.Context, pod *corev1.Pod, createServiceAccount bool) error {
+	if createServiceAccount {
+		sa := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: c.flagK8sNamespace,
+				Labels:    map[string]string{labelVerify: "true"},
+			},
+		}
+		if _, err := c.k8sClient.CoreV1().ServiceAccounts(c.flagK8sNamespace).Create(ctx, sa, metav1.CreateOptions{}); err != nil && !k8serrors.IsAlreadyExists(err) {
+			return fmt.Errorf("creating service account %q: %s", pod.Name, err)
+		}
+	} else {
+		pod.Spec.ServiceAccountName = ""
+	}
+	if _, err := c.k8sClient.CoreV1().Pods(c.flagK8sNamespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("creating pod %q: %s", pod.Name, err)
+	}
+	return nil
+}
+
+// waitFor calls fn every poll interval until it returns true or ctx is done.
+// Errors from fn are retried until the context is done.
+func (c *Command) waitFor(ctx context.Context, fn func() (bool, error)) error {
+	var lastErr error
+	for {
+		done, err := fn()
+		if err == nil && done {
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-time.After(c.pollInterval):
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("timed out: %s", lastErr)
+			}
+			return errors.New("timed out")
+		}
+	}
+}
+
+// cleanup deletes everything created by the checks. Resources that don't
+// exist are ignored.
+func (c *Command) cleanup(logger hclog.Logger) {
+	ctx := context.Background()
+	for _, name := range []string{serverName, clientName, ingressProbeName} {
+		err := c.k8sClient.CoreV1().Pods(c.flagK8sNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logger.Warn("unable to delete pod", "name", name, "err", err)
+		}
+		err = c.k8sClient.CoreV1().ServiceAccounts(c.flagK8sNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logger.Warn("unable to delete service account", "name", name, "err", err)
+		}
+	}
+
+	// Only delete the intention if we created it so we never remove an
+	// intention written by the user.
+	entry, _, err := c.consulClient.ConfigEntries().Get(api.ServiceIntentions, serverName, nil)
+	if err != nil {
+		return
+	}
+	if createdByVerify(entry) {
+		if _, err := c.consulClient.ConfigEntries().Delete(api.ServiceIntentions, serverName, nil); err != nil {
+			logger.Warn("unable to delete intention", "name", serverName, "err", err)
+		}
+	}
+}
+
+// createdByVerify returns true if the config entry was written by this
+// command.
+func createdByVerify(entry api.ConfigEntry) bool {
+	return entry.GetMeta()[labelVerify] == "true"
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Run a post-install smoke test of the service mesh."
+const help = `
+Usage: consul-k8s verify [options]
+
+  Deploys a Connect-injected test server and client, creates an intention
+  allowing the client to call the server and verifies that the client can
+  reach the server over mTLS through the mesh. If -ingress-gateway-addr is
+  set, it also verifies the server is reachable through the ingress
+  gateway. All test resources are removed once the checks complete.
+
+  The result of each check is reported as PASS, FAIL or SKIP and the
+  command exits non-zero if any check fails.
+`
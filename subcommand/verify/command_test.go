@@ -0,0 +1,284 @@
+package verify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"-k8s-namespace="},
+			expErr: "-k8s-namespace must be set",
+		},
+		{
+			flags:  []string{"arg"},
+			expErr: "Should have no non-flag arguments.",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		flags          []string
+		registerServer bool
+		probeExitCodes map[string]int32
+		expExitCode    int
+		expOutput      []string
+		expErr         string
+	}{
+		"all checks pass": {
+			registerServer: true,
+			probeExitCodes: map[string]int32{clientName: 0},
+			expExitCode:    0,
+			expOutput: []string{
+				"PASS  Test server is deployed and registered with Consul",
+				"PASS  Intention allowing the test client is created",
+				"PASS  Test client reaches the test server through the mesh",
+				"SKIP  Test server is reachable through the ingress gateway: -ingress-gateway-addr is not set",
+			},
+		},
+		"all checks pass with ingress gateway": {
+			flags:          []string{"-ingress-gateway-addr=ingress-gateway:8080"},
+			registerServer: true,
+			probeExitCodes: map[string]int32{clientName: 0, ingressProbeName: 0},
+			expExitCode:    0,
+			expOutput: []string{
+				"PASS  Test client reaches the test server through the mesh",
+				"PASS  Test server is reachable through the ingress gateway",
+			},
+		},
+		"server never registers": {
+			flags:       []string{"-timeout=1s"},
+			expExitCode: 1,
+			expOutput: []string{
+				"SKIP  Intention allowing the test client is created",
+				"SKIP  Test client reaches the test server through the mesh",
+			},
+			expErr: "FAIL  Test server is deployed and registered with Consul: timed out",
+		},
+		"client can't reach server": {
+			registerServer: true,
+			probeExitCodes: map[string]int32{clientName: 7},
+			expExitCode:    1,
+			expOutput: []string{
+				"PASS  Intention allowing the test client is created",
+			},
+			expErr: `FAIL  Test client reaches the test server through the mesh: request failed: probe container in pod "consul-verify-client" exited with code 7`,
+		},
+		"ingress gateway unreachable": {
+			flags:          []string{"-ingress-gateway-addr=ingress-gateway:8080"},
+			registerServer: true,
+			probeExitCodes: map[string]int32{clientName: 0, ingressProbeName: 6},
+			expExitCode:    1,
+			expOutput: []string{
+				"PASS  Test client reaches the test server through the mesh",
+			},
+			expErr: `FAIL  Test server is reachable through the ingress gateway: request failed: probe container in pod "consul-verify-ingress" exited with code 6`,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			a, err := testutil.NewTestServerConfigT(t, nil)
+			require.NoError(t, err)
+			defer a.Stop()
+			consulClient, err := api.NewClient(&api.Config{Address: a.HTTPAddr})
+			require.NoError(t, err)
+			if c.registerServer {
+				require.NoError(t, consulClient.Agent().ServiceRegister(&api.AgentServiceRegistration{Name: serverName}))
+			}
+
+			k8s := fake.NewSimpleClientset()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go terminateProbes(ctx, k8s, c.probeExitCodes)
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:           ui,
+				k8sClient:    k8s,
+				consulClient: consulClient,
+				pollInterval: 50 * time.Millisecond,
+			}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(t, c.expExitCode, exitCode, ui.ErrorWriter.String())
+			for _, exp := range c.expOutput {
+				require.Contains(t, ui.OutputWriter.String(), exp)
+			}
+			if c.expErr != "" {
+				require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+			}
+
+			// Everything we created should have been cleaned up.
+			pods, err := k8s.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Empty(t, pods.Items)
+			serviceAccounts, err := k8s.CoreV1().ServiceAccounts("default").List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Empty(t, serviceAccounts.Items)
+			_, _, err = consulClient.ConfigEntries().Get(api.ServiceIntentions, serverName, nil)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "404")
+		})
+	}
+}
+
+// Test that the pods are created with the expected annotations and that
+// the ingress probe isn't injected.
+func TestRun_Pods(t *testing.T) {
+	t.Parallel()
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	defer a.Stop()
+	consulClient, err := api.NewClient(&api.Config{Address: a.HTTPAddr})
+	require.NoError(t, err)
+	require.NoError(t, consulClient.Agent().ServiceRegister(&api.AgentServiceRegistration{Name: serverName}))
+
+	k8s := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go terminateProbes(ctx, k8s, map[string]int32{clientName: 0, ingressProbeName: 0})
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		k8sClient:    k8s,
+		consulClient: consulClient,
+		pollInterval: 50 * time.Millisecond,
+	}
+	exitCode := cmd.Run([]string{"-k8s-namespace=default", "-ingress-gateway-addr=ingress-gateway:8080", "-probe-image=curl"})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+	var pods []*corev1.Pod
+	for _, action := range k8s.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok && action.GetVerb() == "create" && action.GetResource().Resource == "pods" {
+			pods = append(pods, create.GetObject().(*corev1.Pod))
+		}
+	}
+	require.Len(t, pods, 3)
+
+	server := pods[0]
+	require.Equal(t, serverName, server.Name)
+	require.Equal(t, serverName, server.Spec.ServiceAccountName)
+	require.Equal(t, "true", server.Annotations["consul.hashicorp.com/connect-inject"])
+	require.Equal(t, "8080", server.Annotations["consul.hashicorp.com/connect-service-port"])
+
+	client := pods[1]
+	require.Equal(t, clientName, client.Name)
+	require.Equal(t, clientName, client.Spec.ServiceAccountName)
+	require.Equal(t, "consul-verify-server:1234", client.Annotations["consul.hashicorp.com/connect-service-upstreams"])
+	require.Equal(t, corev1.RestartPolicyNever, client.Spec.RestartPolicy)
+	require.Equal(t, "curl", client.Spec.Containers[0].Image)
+	require.Contains(t, client.Spec.Containers[0].Args, "http://127.0.0.1:1234")
+
+	ingress := pods[2]
+	require.Equal(t, ingressProbeName, ingress.Name)
+	require.Empty(t, ingress.Spec.ServiceAccountName)
+	require.Equal(t, "false", ingress.Annotations["consul.hashicorp.com/connect-inject"])
+	require.Contains(t, ingress.Spec.Containers[0].Args, "Host: consul-verify-server.ingress.consul")
+	require.Contains(t, ingress.Spec.Containers[0].Args, "http://ingress-gateway:8080")
+}
+
+// Test that intentions for the test server that weren't written by the
+// command are neither overwritten nor deleted.
+func TestRun_ExistingIntention(t *testing.T) {
+	t.Parallel()
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	defer a.Stop()
+	consulClient, err := api.NewClient(&api.Config{Address: a.HTTPAddr})
+	require.NoError(t, err)
+	require.NoError(t, consulClient.Agent().ServiceRegister(&api.AgentServiceRegistration{Name: serverName}))
+	_, _, err = consulClient.ConfigEntries().Set(&api.ServiceIntentionsConfigEntry{
+		Kind: api.ServiceIntentions,
+		Name: serverName,
+		Sources: []*api.SourceIntention{
+			{
+				Name:   "web",
+				Action: api.IntentionActionAllow,
+			},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		k8sClient:    fake.NewSimpleClientset(),
+		consulClient: consulClient,
+		pollInterval: 50 * time.Millisecond,
+	}
+	exitCode := cmd.Run(nil)
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, ui.ErrorWriter.String(), `intentions for "consul-verify-server" already exist and were not created by this command`)
+
+	entry, _, err := consulClient.ConfigEntries().Get(api.ServiceIntentions, serverName, nil)
+	require.NoError(t, err)
+	intentions := entry.(*api.ServiceIntentionsConfigEntry)
+	require.Len(t, intentions.Sources, 1)
+	require.Equal(t, "web", intentions.Sources[0].Name)
+}
+
+// terminateProbes acts as the kubelet for the probe pods, marking the probe
+// container of each pod in exitCodes as terminated with the given exit code
+// once the pod has been created.
+func terminateProbes(ctx context.Context, k8s kubernetes.Interface, exitCodes map[string]int32) {
+	done := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		for name, exitCode := range exitCodes {
+			if done[name] {
+				continue
+			}
+			pod, err := k8s.CoreV1().Pods("default").Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				continue
+			}
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+				{
+					Name: probeContainerName,
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode},
+					},
+				},
+			}
+			if _, err := k8s.CoreV1().Pods("default").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err == nil {
+				done[name] = true
+			}
+		}
+	}
+}
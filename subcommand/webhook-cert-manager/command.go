@@ -9,6 +9,7 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
 	"strings"
@@ -23,16 +24,23 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-multierror"
 	"github.com/mitchellh/cli"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	defaultCertExpiry    = 24 * time.Hour
 	defaultRetryDuration = 1 * time.Second
+
+	// webhookTypeMutating and webhookTypeValidating are the supported
+	// values of the "type" field of a webhook config.
+	webhookTypeMutating   = "mutating"
+	webhookTypeValidating = "validating"
 )
 
 type Command struct {
@@ -40,9 +48,12 @@ type Command struct {
 
 	flagSet *flag.FlagSet
 	k8s     *flags.K8SFlags
+	secret  *flags.SecretFlags
 
 	flagConfigFile string
 	flagLogLevel   string
+	flagKeyType    string
+	flagKeyBits    int
 
 	clientset kubernetes.Interface
 
@@ -62,9 +73,16 @@ func (c *Command) init() {
 	c.flagSet.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
+	c.flagSet.StringVar(&c.flagKeyType, "tls-key-type", cert.KeyTypeEC,
+		"Type of the private keys of the generated CAs and certificates, either \"ec\" or \"rsa\".")
+	c.flagSet.IntVar(&c.flagKeyBits, "tls-key-bits", 0,
+		"Size of the private keys of the generated CAs and certificates: 256 or 384 for EC keys, "+
+			"defaulting to 256, and 2048, 3072 or 4096 for RSA keys, defaulting to 2048.")
 
 	c.k8s = &flags.K8SFlags{}
+	c.secret = &flags.SecretFlags{}
 	flags.Merge(c.flagSet, c.k8s.Flags())
+	flags.Merge(c.flagSet, c.secret.Flags())
 	c.help = flags.Usage(help, c.flagSet)
 
 	// Wait on an interrupt or terminate to exit. This channel must be initialized before
@@ -78,7 +96,7 @@ func (c *Command) init() {
 
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
-	if err := c.flagSet.Parse(args); err != nil {
+	if err := flags.Parse(c.flagSet, args); err != nil {
 		c.UI.Error(fmt.Sprintf("Error parsing flagSet: %s", err))
 		return 1
 	}
@@ -91,10 +109,19 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error(fmt.Sprintf("-config-file must be set"))
 		return 1
 	}
+	if err := c.secret.Validate(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	key := cert.KeySpec{Type: c.flagKeyType, Bits: c.flagKeyBits}
+	if err := key.Validate(); err != nil {
+		c.UI.Error(fmt.Sprintf("-tls-key-type=%s -tls-key-bits=%d is invalid: %s", c.flagKeyType, c.flagKeyBits, err))
+		return 1
+	}
 
 	// Create the Kubernetes clientset
 	if c.clientset == nil {
-		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig(), c.k8s.Context())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
 			return 1
@@ -136,6 +163,10 @@ func (c *Command) Run(args []string) int {
 			return 1
 		}
 	}
+	if err := validateConfigs(configs); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	certCh := make(chan cert.MetaBundle)
 
@@ -155,11 +186,12 @@ func (c *Command) Run(args []string) int {
 		} else {
 			certSource = &cert.GenSource{
 				Name:   "Consul Webhook Certificates",
-				Hosts:  config.TLSAutoHosts,
+				Hosts:  append(append([]string{}, config.TLSAutoHosts...), config.TLSAdditionalSANs...),
 				Expiry: expiry,
+				Key:    key,
 			}
 		}
-		certNotify := &cert.Notify{Source: certSource, Ch: certCh, WebhookConfigName: config.Name, SecretName: config.SecretName, SecretNamespace: config.SecretNamespace}
+		certNotify := &cert.Notify{Source: certSource, Ch: certCh, WebhookConfigName: config.Name, WebhookConfigType: config.Type, SecretName: config.SecretName, SecretNamespace: config.SecretNamespace}
 		notifiers = append(notifiers, certNotify)
 		go certNotify.Start(ctx)
 	}
@@ -181,38 +213,50 @@ func (c *Command) Run(args []string) int {
 }
 
 // certWatcher listens for a new MetaBundle on the ch channel for all webhooks and updates
-// MutatingWebhooksConfigs and Secrets when a new Bundle is available on the channel.
+// webhook configurations and Secrets when a new Bundle is available on the channel.
+// The latest bundle of every webhook configuration is kept so that all of them are
+// reconciled periodically, not just the one whose bundle was received last.
 func (c *Command) certWatcher(ctx context.Context, ch <-chan cert.MetaBundle, clientset kubernetes.Interface, log hclog.Logger) {
-	var bundle cert.MetaBundle
+	bundles := make(map[string]cert.MetaBundle)
+	var keys []string
 	for {
 		select {
-		case bundle = <-ch:
+		case bundle := <-ch:
 			log.Info(fmt.Sprintf("Updated certificate bundle received for %s; Updating webhook certs.", bundle.WebhookConfigName))
 			// Bundle is updated, set it up
+			key := webhookKey(bundle.WebhookConfigType, bundle.WebhookConfigName)
+			if _, ok := bundles[key]; !ok {
+				keys = append(keys, key)
+			}
+			bundles[key] = bundle
+			if err := c.reconcileCertificates(ctx, clientset, bundle, log); err != nil {
+				log.Error("failed to reconcile certificates", "err", err)
+			}
 
 		case <-time.After(defaultRetryDuration):
-			// This forces the mutating ctrlWebhook config to remain updated
+			// This forces the webhook configs to remain updated
 			// fairly quickly. Helm upgrades will rewrite the contents of the
 			// CA bundle which will not be in sync with the certs in the system.
 			// This fast reconcile ensures the system recovers fairly quickly in case
-			// the secret or MWC gets deleted or reset.
+			// the secret or webhook config gets deleted or reset.
+			for _, key := range keys {
+				if err := c.reconcileCertificates(ctx, clientset, bundles[key], log); err != nil {
+					log.Error("failed to reconcile certificates", "err", err)
+				}
+			}
 
 		case <-ctx.Done():
 			// Quit
 			return
 		}
-
-		if err := c.reconcileCertificates(ctx, clientset, bundle, log); err != nil {
-			log.Error("failed to reconcile certificates", "err", err)
-		}
 	}
 }
 
 // reconcileCertificates ensures the secret in the MetaBundle has the latest certificate from the MetaBundle and the caBundles on the
-// MutatingWebhookConfiguration have the latest CA certificate from the MetaBundle. It updates them if they are outdated and exits early
+// webhook configuration have the latest CA certificate from the MetaBundle. It updates them if they are outdated and exits early
 // if they are up-to date.
 func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernetes.Interface, bundle cert.MetaBundle, log hclog.Logger) error {
-	iterLog := log.With("mutatingwebhookconfig", bundle.WebhookConfigName, "secret", bundle.SecretName, "secretNS", bundle.SecretNamespace)
+	iterLog := log.With("webhookconfig", bundle.WebhookConfigName, "type", webhookType(bundle), "secret", bundle.SecretName, "secretNS", bundle.SecretNamespace)
 
 	certSecret, err := clientset.CoreV1().Secrets(bundle.SecretNamespace).Get(ctx, bundle.SecretName, metav1.GetOptions{})
 	if err != nil && k8serrors.IsNotFound(err) {
@@ -226,6 +270,10 @@ func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernete
 			},
 			Type: corev1.SecretTypeTLS,
 		}
+		if err := c.setSecretMeta(ctx, &secret.ObjectMeta, bundle, clientset); err != nil {
+			iterLog.Error("Error getting webhook configuration", "err", err)
+			return err
+		}
 
 		iterLog.Info("Creating Kubernetes secret with certificate")
 		if _, err = clientset.CoreV1().Secrets(bundle.SecretNamespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
@@ -245,12 +293,28 @@ func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernete
 	}
 
 	// Don't update secret if the certificate and key are unchanged.
-	if bytes.Equal(certSecret.Data[corev1.TLSCertKey], bundle.Cert) && bytes.Equal(certSecret.Data[corev1.TLSPrivateKeyKey], bundle.Key) && c.webhookUpdated(ctx, bundle, clientset) {
+	secretUpdated := bytes.Equal(certSecret.Data[corev1.TLSCertKey], bundle.Cert) && bytes.Equal(certSecret.Data[corev1.TLSPrivateKeyKey], bundle.Key)
+	if secretUpdated {
+		if c.webhookUpdated(ctx, bundle, clientset) {
+			return nil
+		}
+		// The certificate hasn't changed so the caBundle was overwritten,
+		// e.g. by a Helm upgrade or a GitOps sync. Until it's repaired the
+		// API server can't call the webhook.
+		iterLog.Warn("caBundle on webhook configuration doesn't match the current CA, repairing it")
+		if err := c.updateWebhookConfig(ctx, bundle, clientset); err != nil {
+			iterLog.Error("Error updating webhook configuration", "err", err)
+			return err
+		}
 		return nil
 	}
 
 	certSecret.Data[corev1.TLSCertKey] = bundle.Cert
 	certSecret.Data[corev1.TLSPrivateKeyKey] = bundle.Key
+	if err := c.setSecretMeta(ctx, &certSecret.ObjectMeta, bundle, clientset); err != nil {
+		iterLog.Error("Error getting webhook configuration", "err", err)
+		return err
+	}
 
 	iterLog.Info("Updating secret with new certificate")
 	_, err = clientset.CoreV1().Secrets(bundle.SecretNamespace).Update(ctx, certSecret, metav1.UpdateOptions{})
@@ -267,6 +331,40 @@ func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernete
 	return nil
 }
 
+// setSecretMeta sets the labels and annotations of the certificate Secret on
+// meta, and makes the webhook configuration in the bundle an owner of the
+// Secret so that it's deleted along with the webhook configuration.
+func (c *Command) setSecretMeta(ctx context.Context, meta *metav1.ObjectMeta, bundle cert.MetaBundle, clientset kubernetes.Interface) error {
+	c.secret.MergeOntoObjectMeta(meta, secretCreatedBy)
+
+	ownerRef := metav1.OwnerReference{
+		APIVersion: admissionv1beta1.SchemeGroupVersion.String(),
+		Name:       bundle.WebhookConfigName,
+	}
+	if webhookType(bundle) == webhookTypeValidating {
+		webhookCfg, err := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ctx, bundle.WebhookConfigName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		ownerRef.Kind = "ValidatingWebhookConfiguration"
+		ownerRef.UID = webhookCfg.UID
+	} else {
+		webhookCfg, err := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ctx, bundle.WebhookConfigName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		ownerRef.Kind = "MutatingWebhookConfiguration"
+		ownerRef.UID = webhookCfg.UID
+	}
+	for _, ref := range meta.OwnerReferences {
+		if ref.UID == ownerRef.UID {
+			return nil
+		}
+	}
+	meta.OwnerReferences = append(meta.OwnerReferences, ownerRef)
+	return nil
+}
+
 // updateWebhookConfig iterates over every webhook on the specified webhook configuration and updates
 // their caBundle with the CA from the MetaBundle.
 func (c *Command) updateWebhookConfig(ctx context.Context, metaBundle cert.MetaBundle, clientset kubernetes.Interface) error {
@@ -275,12 +373,12 @@ func (c *Command) updateWebhookConfig(ctx context.Context, metaBundle cert.MetaB
 	}
 	value := base64.StdEncoding.EncodeToString(metaBundle.CACert)
 
-	webhookCfg, err := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ctx, metaBundle.WebhookConfigName, metav1.GetOptions{})
+	caBundles, err := webhookCABundles(ctx, metaBundle, clientset)
 	if err != nil {
 		return err
 	}
 	var patches []patch
-	for i := range webhookCfg.Webhooks {
+	for i := range caBundles {
 		patches = append(patches, patch{
 			Op:    "add",
 			Path:  fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", i),
@@ -292,41 +390,112 @@ func (c *Command) updateWebhookConfig(ctx context.Context, metaBundle cert.MetaB
 		return err
 	}
 
-	if _, err = clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Patch(ctx, metaBundle.WebhookConfigName, types.JSONPatchType, patchesJson, metav1.PatchOptions{}); err != nil {
-		return err
+	if webhookType(metaBundle) == webhookTypeValidating {
+		_, err = clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Patch(ctx, metaBundle.WebhookConfigName, types.JSONPatchType, patchesJson, metav1.PatchOptions{})
+	} else {
+		_, err = clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Patch(ctx, metaBundle.WebhookConfigName, types.JSONPatchType, patchesJson, metav1.PatchOptions{})
 	}
-	return nil
+	return err
 }
 
 // webhookUpdated verifies if every caBundle on the specified webhook configuration matches the desired CA certificate.
 // It returns true if the CA is up-to date and false if it needs to be updated.
 func (c *Command) webhookUpdated(ctx context.Context, bundle cert.MetaBundle, clientset kubernetes.Interface) bool {
-	webhookCfg, err := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ctx, bundle.WebhookConfigName, metav1.GetOptions{})
+	caBundles, err := webhookCABundles(ctx, bundle, clientset)
 	if err != nil {
 		return false
 	}
-	for _, webhook := range webhookCfg.Webhooks {
-		if !bytes.Equal(webhook.ClientConfig.CABundle, bundle.CACert) {
+	for _, caBundle := range caBundles {
+		if !bytes.Equal(caBundle, bundle.CACert) {
 			return false
 		}
 	}
 	return true
 }
 
+// webhookCABundles returns the caBundle of every webhook on the webhook
+// configuration in the bundle, in order.
+func webhookCABundles(ctx context.Context, bundle cert.MetaBundle, clientset kubernetes.Interface) ([][]byte, error) {
+	var caBundles [][]byte
+	if webhookType(bundle) == webhookTypeValidating {
+		webhookCfg, err := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ctx, bundle.WebhookConfigName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, webhook := range webhookCfg.Webhooks {
+			caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+		}
+		return caBundles, nil
+	}
+	webhookCfg, err := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ctx, bundle.WebhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhookCfg.Webhooks {
+		caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+	}
+	return caBundles, nil
+}
+
+// webhookType returns the type of the webhook configuration in the bundle,
+// defaulting to mutating.
+func webhookType(bundle cert.MetaBundle) string {
+	if bundle.WebhookConfigType == "" {
+		return webhookTypeMutating
+	}
+	return bundle.WebhookConfigType
+}
+
+// webhookKey identifies the webhook configuration of the type and name, since
+// a mutating and a validating one can have the same name.
+func webhookKey(configType, name string) string {
+	if configType == "" {
+		configType = webhookTypeMutating
+	}
+	return configType + "/" + name
+}
+
 type webhookConfig struct {
-	Name            string   `json:"name,omitempty"`
-	TLSAutoHosts    []string `json:"tlsAutoHosts,omitempty"`
-	SecretName      string   `json:"secretName,omitempty"`
-	SecretNamespace string   `json:"secretNamespace,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Type is "mutating" for a MutatingWebhookConfiguration or "validating"
+	// for a ValidatingWebhookConfiguration. Defaults to "mutating".
+	Type         string   `json:"type,omitempty"`
+	TLSAutoHosts []string `json:"tlsAutoHosts,omitempty"`
+	// TLSAdditionalSANs are DNS names and IP addresses that the certificate
+	// is valid for in addition to TLSAutoHosts.
+	TLSAdditionalSANs []string `json:"tlsAdditionalSANs,omitempty"`
+	SecretName        string   `json:"secretName,omitempty"`
+	SecretNamespace   string   `json:"secretNamespace,omitempty"`
 }
 
 func (c webhookConfig) validate(ctx context.Context, client kubernetes.Interface) error {
 	var err *multierror.Error
+	validType := true
+	switch c.Type {
+	case "", webhookTypeMutating, webhookTypeValidating:
+	default:
+		validType = false
+		err = multierror.Append(err, fmt.Errorf(`config.Type must be one of %q or %q`, webhookTypeMutating, webhookTypeValidating))
+	}
 	if c.Name == "" {
 		err = multierror.Append(err, errors.New(`config.Name cannot be ""`))
-	} else {
-		if _, err2 := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ctx, c.Name, metav1.GetOptions{}); err2 != nil && k8serrors.IsNotFound(err2) {
-			err = multierror.Append(err, errors.New(fmt.Sprintf("MutatingWebhookConfiguration with name \"%s\" must exist in cluster", c.Name)))
+	} else if validType {
+		if c.Type == webhookTypeValidating {
+			if _, err2 := client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ctx, c.Name, metav1.GetOptions{}); err2 != nil && k8serrors.IsNotFound(err2) {
+				err = multierror.Append(err, errors.New(fmt.Sprintf("ValidatingWebhookConfiguration with name \"%s\" must exist in cluster", c.Name)))
+			}
+		} else {
+			if _, err2 := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ctx, c.Name, metav1.GetOptions{}); err2 != nil && k8serrors.IsNotFound(err2) {
+				err = multierror.Append(err, errors.New(fmt.Sprintf("MutatingWebhookConfiguration with name \"%s\" must exist in cluster", c.Name)))
+			}
+		}
+	}
+	for _, san := range c.TLSAdditionalSANs {
+		if net.ParseIP(san) != nil {
+			continue
+		}
+		if errs := validation.IsWildcardDNS1123Subdomain(san); len(errs) > 0 && len(validation.IsDNS1123Subdomain(san)) > 0 {
+			err = multierror.Append(err, fmt.Errorf("config.TLSAdditionalSANs value %q must be a DNS name or an IP address", san))
 		}
 	}
 	if c.SecretName == "" {
@@ -349,6 +518,27 @@ func (c webhookConfig) validate(ctx context.Context, client kubernetes.Interface
 	return nil
 }
 
+// validateConfigs returns an error if two configs are for the same webhook
+// configuration or Secret, since their certificates would keep replacing
+// each other.
+func validateConfigs(configs []webhookConfig) error {
+	webhooks := make(map[string]int)
+	secrets := make(map[string]int)
+	for i, config := range configs {
+		webhook := webhookKey(config.Type, config.Name)
+		if j, ok := webhooks[webhook]; ok {
+			return fmt.Errorf("configs at index %d and %d are both for webhook configuration %q", j, i, config.Name)
+		}
+		webhooks[webhook] = i
+		secret := config.SecretNamespace + "/" + config.SecretName
+		if j, ok := secrets[secret]; ok {
+			return fmt.Errorf("configs at index %d and %d both use Secret %q", j, i, secret)
+		}
+		secrets[secret] = i
+	}
+	return nil
+}
+
 type patch struct {
 	Op    string `json:"op,omitempty"`
 	Path  string `json:"path,omitempty"`
@@ -374,10 +564,19 @@ func (c *Command) sendSignal(sig os.Signal) {
 	c.sigCh <- sig
 }
 
+// secretCreatedBy is the name of the command set on the Secrets it creates.
+const secretCreatedBy = "webhook-cert-manager"
+
 const synopsis = "Starts the Consul Kubernetes webhook-cert-manager"
 const help = `
 Usage: consul-k8s webhook-cert-manager [options]
 
   Starts the Consul Kubernetes webhook-cert-manager that manages the lifecycle for webhook TLS certificates.
+  Each mutating or validating webhook configuration in -config-file gets its own CA and certificate,
+  valid for its tlsAutoHosts and tlsAdditionalSANs and stored in its secret.
+  The caBundle of each webhook configuration is checked every second and repaired if it doesn't match
+  the current CA, for example because it was overwritten by a Helm upgrade or a GitOps sync.
+  The certificate Secrets are owned by their webhook configuration so that they're deleted
+  along with it.
 
 `
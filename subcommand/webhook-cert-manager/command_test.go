@@ -2,12 +2,14 @@ package webhookcertmanager
 
 import (
 	"context"
+	"crypto/rsa"
 	"io/ioutil"
 	"os"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/helper/cert"
 	"github.com/hashicorp/consul-k8s/subcommand/webhook-cert-manager/mocks"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/mitchellh/cli"
@@ -107,6 +109,14 @@ func TestRun_FlagValidation(t *testing.T) {
 			flags:  nil,
 			expErr: "-config-file must be set",
 		},
+		{
+			flags:  []string{"-config-file", "config.json", "-tls-key-type", "rsa", "-tls-key-bits", "1024"},
+			expErr: "-tls-key-type=rsa -tls-key-bits=1024 is invalid: RSA keys must be 2048, 3072 or 4096 bits, not 1024",
+		},
+		{
+			flags:  []string{"-config-file", "config.json", "-tls-key-type", "dsa"},
+			expErr: `-tls-key-type=dsa -tls-key-bits=0 is invalid: key type must be "ec" or "rsa", not "dsa"`,
+		},
 	}
 
 	for _, c := range cases {
@@ -183,6 +193,7 @@ func TestRun_SecretDoesNotExist(t *testing.T) {
 
 	exitCh := runCommandAsynchronously(&cmd, []string{
 		"-config-file", file.Name(),
+		"-release-name", "release-name",
 	})
 	defer stopCommand(t, &cmd, exitCh)
 
@@ -192,6 +203,11 @@ func TestRun_SecretDoesNotExist(t *testing.T) {
 		secretOne, err := k8s.CoreV1().Secrets("default").Get(ctx, secretOneName, metav1.GetOptions{})
 		require.NoError(r, err)
 		require.Equal(r, secretOne.Type, v1.SecretTypeTLS)
+		require.Equal(r, "webhook-cert-manager", secretOne.Labels["app.kubernetes.io/created-by"])
+		require.Equal(r, "release-name", secretOne.Labels["release"])
+		require.Len(r, secretOne.OwnerReferences, 1)
+		require.Equal(r, "MutatingWebhookConfiguration", secretOne.OwnerReferences[0].Kind)
+		require.Equal(r, webhookConfigOneName, secretOne.OwnerReferences[0].Name)
 
 		secretTwo, err := k8s.CoreV1().Secrets("default").Get(ctx, secretTwoName, metav1.GetOptions{})
 		require.NoError(r, err)
@@ -209,6 +225,66 @@ func TestRun_SecretDoesNotExist(t *testing.T) {
 	})
 }
 
+// Test that the certificates are generated with the configured key type and
+// additional SANs.
+func TestRun_KeyTypeAndAdditionalSANs(t *testing.T) {
+	t.Parallel()
+	webhook := &admissionv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "webhookOne",
+		},
+		Webhooks: []admissionv1beta1.MutatingWebhook{
+			{
+				Name: "webhook-under-test",
+			},
+		},
+	}
+	k8s := fake.NewSimpleClientset(webhook)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	cmd.init()
+
+	file, err := ioutil.TempFile("", "config.json")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.Write([]byte(`[
+  {
+    "name": "webhookOne",
+    "tlsAutoHosts": ["foo"],
+    "tlsAdditionalSANs": ["webhook.example.com", "10.0.0.1"],
+    "secretName": "secret-deploy-1",
+    "secretNamespace": "default"
+  }
+]`))
+	require.NoError(t, err)
+
+	exitCh := runCommandAsynchronously(&cmd, []string{
+		"-config-file", file.Name(),
+		"-tls-key-type", "rsa",
+		"-tls-key-bits", "3072",
+	})
+	defer stopCommand(t, &cmd, exitCh)
+
+	timer := &retry.Timer{Timeout: 10 * time.Second, Wait: 500 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		secret, err := k8s.CoreV1().Secrets("default").Get(context.Background(), "secret-deploy-1", metav1.GetOptions{})
+		require.NoError(r, err)
+
+		leaf, err := cert.ParseCert(secret.Data[v1.TLSCertKey])
+		require.NoError(r, err)
+		require.Equal(r, []string{"foo", "webhook.example.com"}, leaf.DNSNames)
+		require.Len(r, leaf.IPAddresses, 1)
+		require.Equal(r, "10.0.0.1", leaf.IPAddresses[0].String())
+		key, ok := leaf.PublicKey.(*rsa.PublicKey)
+		require.True(r, ok, "expected an RSA key, got %T", leaf.PublicKey)
+		require.Equal(r, 3072, key.N.BitLen())
+	})
+}
+
 func TestRun_SecretExists(t *testing.T) {
 	t.Parallel()
 	secretOneName := "secret-deploy-1"
@@ -473,6 +549,176 @@ func TestCertWatcher(t *testing.T) {
 	})
 }
 
+// Test that the caBundle on a ValidatingWebhookConfiguration is set and
+// repaired after it's overwritten.
+func TestCertWatcher_ValidatingWebhookConfiguration(t *testing.T) {
+	t.Parallel()
+
+	webhookName := "webhookOne"
+	webhook := &admissionv1beta1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: webhookName,
+		},
+		Webhooks: []admissionv1beta1.ValidatingWebhook{
+			{
+				Name:         "webhook-under-test",
+				ClientConfig: admissionv1beta1.WebhookClientConfig{},
+			},
+			{
+				Name:         "webhook-under-test-2",
+				ClientConfig: admissionv1beta1.WebhookClientConfig{},
+			},
+		},
+	}
+	certSource := &mocks.MockCertSource{}
+
+	k8s := fake.NewSimpleClientset(webhook)
+	ui := cli.NewMockUi()
+
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+		source:    certSource,
+	}
+	cmd.init()
+
+	file, err := ioutil.TempFile("", "config.json")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.Write([]byte(configFileValidating))
+	require.NoError(t, err)
+
+	exitCh := runCommandAsynchronously(&cmd, []string{
+		"-config-file", file.Name(),
+	})
+	defer stopCommand(t, &cmd, exitCh)
+
+	ctx := context.Background()
+	timer := &retry.Timer{Timeout: 5 * time.Second, Wait: 500 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		webhookConfig, err := k8s.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ctx, webhookName, metav1.GetOptions{})
+		require.NoError(r, err)
+		for _, w := range webhookConfig.Webhooks {
+			require.Contains(r, string(w.ClientConfig.CABundle), "ca-certificate-string")
+		}
+	})
+
+	// Overwrite the CA bundle on the second webhook.
+	webhook.Webhooks[1].ClientConfig.CABundle = []byte("overwritten")
+	_, err = k8s.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Update(ctx, webhook, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	timer = &retry.Timer{Timeout: 5 * time.Second, Wait: 500 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		webhookConfig, err := k8s.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ctx, webhookName, metav1.GetOptions{})
+		require.NoError(r, err)
+		for _, w := range webhookConfig.Webhooks {
+			require.Contains(r, string(w.ClientConfig.CABundle), "ca-certificate-string")
+		}
+	})
+}
+
+// Test that the caBundle of every webhook configuration is repaired after
+// it's overwritten, not just of the one whose certificate was issued last.
+func TestCertWatcher_MultipleWebhookConfigurations(t *testing.T) {
+	t.Parallel()
+
+	mutating := &admissionv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "connect-injector"},
+		Webhooks:   []admissionv1beta1.MutatingWebhook{{Name: "connect-injector"}},
+	}
+	validating := &admissionv1beta1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "controller"},
+		Webhooks:   []admissionv1beta1.ValidatingWebhook{{Name: "controller"}},
+	}
+	k8s := fake.NewSimpleClientset(mutating, validating)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+		source:    &mocks.StaticCertSource{},
+	}
+	cmd.init()
+
+	file, err := ioutil.TempFile("", "config.json")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.Write([]byte(configFileMultipleTypes))
+	require.NoError(t, err)
+
+	exitCh := runCommandAsynchronously(&cmd, []string{
+		"-config-file", file.Name(),
+	})
+	defer stopCommand(t, &cmd, exitCh)
+
+	ctx := context.Background()
+	requireCABundles := func() {
+		timer := &retry.Timer{Timeout: 5 * time.Second, Wait: 500 * time.Millisecond}
+		retry.RunWith(timer, t, func(r *retry.R) {
+			mwc, err := k8s.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ctx, mutating.Name, metav1.GetOptions{})
+			require.NoError(r, err)
+			require.Contains(r, string(mwc.Webhooks[0].ClientConfig.CABundle), "ca-certificate-string")
+			vwc, err := k8s.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ctx, validating.Name, metav1.GetOptions{})
+			require.NoError(r, err)
+			require.Contains(r, string(vwc.Webhooks[0].ClientConfig.CABundle), "ca-certificate-string")
+		})
+	}
+	requireCABundles()
+	for _, name := range []string{"injector-cert", "controller-cert"} {
+		_, err := k8s.CoreV1().Secrets("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+	}
+
+	// Overwrite both caBundles.
+	_, err = k8s.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Update(ctx, mutating, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = k8s.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Update(ctx, validating, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	requireCABundles()
+}
+
+func TestValidateConfigs(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		configs []webhookConfig
+		expErr  string
+	}{
+		"valid": {
+			configs: []webhookConfig{
+				{Name: "webhook", SecretName: "secret-1", SecretNamespace: "default"},
+				// A validating webhook configuration can have the same name.
+				{Name: "webhook", Type: webhookTypeValidating, SecretName: "secret-2", SecretNamespace: "default"},
+				{Name: "other", SecretName: "secret-1", SecretNamespace: "other"},
+			},
+		},
+		"same webhook configuration": {
+			configs: []webhookConfig{
+				{Name: "webhook", SecretName: "secret-1", SecretNamespace: "default"},
+				{Name: "webhook", Type: webhookTypeMutating, SecretName: "secret-2", SecretNamespace: "default"},
+			},
+			expErr: `configs at index 0 and 1 are both for webhook configuration "webhook"`,
+		},
+		"same secret": {
+			configs: []webhookConfig{
+				{Name: "webhook", SecretName: "secret", SecretNamespace: "default"},
+				{Name: "other", SecretName: "secret", SecretNamespace: "default"},
+			},
+			expErr: `configs at index 0 and 1 both use Secret "default/secret"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := validateConfigs(c.configs)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
 func TestValidate(t *testing.T) {
 	t.Parallel()
 	webhook := &admissionv1beta1.MutatingWebhookConfiguration{
@@ -507,6 +753,28 @@ func TestValidate(t *testing.T) {
 			clientset: fake.NewSimpleClientset(),
 			expErr:    `MutatingWebhookConfiguration with name "webhook-config-name" must exist in cluster`,
 		},
+		"type": {
+			config: webhookConfig{
+				Name:            "webhook-config-name",
+				Type:            "admission",
+				TLSAutoHosts:    []string{"host-1", "host-2"},
+				SecretName:      "secret-name",
+				SecretNamespace: "default",
+			},
+			clientset: client,
+			expErr:    `config.Type must be one of "mutating" or "validating"`,
+		},
+		"nonExistantVWC": {
+			config: webhookConfig{
+				Name:            "webhook-config-name",
+				Type:            "validating",
+				TLSAutoHosts:    []string{"host-1", "host-2"},
+				SecretName:      "secret-name",
+				SecretNamespace: "default",
+			},
+			clientset: client,
+			expErr:    `ValidatingWebhookConfiguration with name "webhook-config-name" must exist in cluster`,
+		},
 		"secretName": {
 			config: webhookConfig{
 				Name:            "webhook-config-name",
@@ -527,6 +795,17 @@ func TestValidate(t *testing.T) {
 			clientset: client,
 			expErr:    `config.SecretNameSpace cannot be ""`,
 		},
+		"additionalSANs": {
+			config: webhookConfig{
+				Name:              "webhook-config-name",
+				TLSAutoHosts:      []string{"host-1", "host-2"},
+				TLSAdditionalSANs: []string{"*.example.com", "10.0.0.1", "not a host"},
+				SecretName:        "secret-name",
+				SecretNamespace:   "default",
+			},
+			clientset: client,
+			expErr:    `config.TLSAdditionalSANs value "not a host" must be a DNS name or an IP address`,
+		},
 		"multi-error": {
 			config: webhookConfig{
 				Name:            "",
@@ -610,3 +889,37 @@ const configFileUpdates = `[
     "secretNamespace": "default"
   }
 ]`
+
+const configFileValidating = `[
+  {
+    "name": "webhookOne",
+    "type": "validating",
+    "tlsAutoHosts": [
+      "foo",
+      "bar",
+      "baz"
+    ],
+    "secretName": "secret-deploy-1",
+    "secretNamespace": "default"
+  }
+]`
+
+const configFileMultipleTypes = `[
+  {
+    "name": "connect-injector",
+    "tlsAutoHosts": [
+      "consul-connect-injector-svc.default.svc"
+    ],
+    "secretName": "injector-cert",
+    "secretNamespace": "default"
+  },
+  {
+    "name": "controller",
+    "type": "validating",
+    "tlsAutoHosts": [
+      "consul-controller-webhook.default.svc"
+    ],
+    "secretName": "controller-cert",
+    "secretNamespace": "default"
+  }
+]`
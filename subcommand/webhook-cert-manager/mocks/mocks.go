@@ -21,3 +21,20 @@ func (m *MockCertSource) Certificate(_ context.Context, _ *cert.Bundle) (cert.Bu
 	}
 	return result, nil
 }
+
+// StaticCertSource returns the same bundle on the first call and then blocks
+// until the context is cancelled, like a cert.GenSource does until its
+// certificate is about to expire.
+type StaticCertSource struct{}
+
+func (s *StaticCertSource) Certificate(ctx context.Context, last *cert.Bundle) (cert.Bundle, error) {
+	if last != nil {
+		<-ctx.Done()
+		return cert.Bundle{}, ctx.Err()
+	}
+	return cert.Bundle{
+		Cert:   []byte("certificate-string"),
+		Key:    []byte("private-key-string"),
+		CACert: []byte("ca-certificate-string"),
+	}, nil
+}